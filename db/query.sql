@@ -11,6 +11,21 @@ INSERT INTO users (phone)
 VALUES ($1)
 RETURNING *;
 
+-- name: GetUserProfile :one
+SELECT * FROM user_profiles WHERE user_id = $1;
+
+-- name: UpsertUserProfile :one
+INSERT INTO user_profiles (user_id, name, email, locale, timezone, city, vehicle_type,
+                           vehicle_make, vehicle_model, battery_kwh, preferred_language, attributes)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+ON CONFLICT (user_id) DO UPDATE SET
+    name = EXCLUDED.name, email = EXCLUDED.email, locale = EXCLUDED.locale,
+    timezone = EXCLUDED.timezone, city = EXCLUDED.city, vehicle_type = EXCLUDED.vehicle_type,
+    vehicle_make = EXCLUDED.vehicle_make, vehicle_model = EXCLUDED.vehicle_model,
+    battery_kwh = EXCLUDED.battery_kwh, preferred_language = EXCLUDED.preferred_language,
+    attributes = EXCLUDED.attributes
+RETURNING *;
+
 -- name: GetPointsEventsByUser :many
 SELECT * FROM points_events
 WHERE user_id = $1
@@ -26,15 +41,27 @@ SELECT COALESCE(SUM(points), 0)::bigint as balance
 FROM points_events
 WHERE user_id = $1;
 
+-- GetRewardsCatalog lists the active rewards available at now: inside their
+-- availability window and on one of their available UTC weekdays
 -- name: GetRewardsCatalog :many
 SELECT * FROM rewards_catalog
 WHERE active = true
-ORDER BY cost ASC;
+  AND (available_from IS NULL OR available_from <= sqlc.arg('now')::timestamptz)
+  AND (available_until IS NULL OR available_until > sqlc.arg('now')::timestamptz)
+  AND (available_days IS NULL
+       OR available_days & (1 << EXTRACT(DOW FROM sqlc.arg('now')::timestamptz AT TIME ZONE 'UTC')::int) <> 0)
+ORDER BY featured DESC, sort_order ASC, cost ASC;
 
 -- name: GetReward :one
 SELECT * FROM rewards_catalog
 WHERE id = $1 LIMIT 1;
 
+-- GetRewardForUpdate locks a reward for a read-modify-write update
+-- name: GetRewardForUpdate :one
+SELECT * FROM rewards_catalog
+WHERE id = $1
+FOR UPDATE;
+
 -- name: GetRedemptionsByUser :many
 SELECT * FROM redemptions
 WHERE user_id = $1
@@ -45,8 +72,13 @@ SELECT * FROM redemptions
 WHERE id = $1 LIMIT 1;
 
 -- name: CreateRedemption :one
-INSERT INTO redemptions (user_id, reward_id, points_spent)
-VALUES ($1, $2, $3)
+INSERT INTO redemptions (user_id, reward_id, points_spent, expires_at, status, base_cost, price_rule, checkout_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING *;
+
+-- name: CreateCheckout :one
+INSERT INTO checkouts (user_id, points_spent)
+VALUES ($1, $2)
 RETURNING *;
 
 -- name: UpdateRedemptionStatus :one
@@ -55,10 +87,21 @@ SET status = $2
 WHERE id = $1
 RETURNING *;
 
--- name: GetPendingRedemptionsOlderThan :many
-SELECT * FROM redemptions
-WHERE status = 'PENDING' AND created_at < $1
-ORDER BY created_at ASC;
+-- ExpireDueRedemptions expires a batch of pending redemptions past their
+-- expiry. Redemptions awaiting a partner delivery are left to the fulfillment
+-- dispatcher; those locked by a concurrent run are skipped.
+-- name: ExpireDueRedemptions :many
+UPDATE redemptions SET status = 'EXPIRED'
+WHERE id IN (
+    SELECT r.id FROM redemptions r
+    WHERE r.status = 'PENDING' AND r.expires_at <= sqlc.arg('now')::timestamptz
+      AND NOT EXISTS (SELECT 1 FROM fulfillment_deliveries d
+                      WHERE d.redemption_id = r.id AND d.status = 'PENDING')
+    ORDER BY r.expires_at ASC
+    LIMIT sqlc.arg('batch_size')::int
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
 
 -- Rules queries
 -- name: CreateRule :one
@@ -72,7 +115,7 @@ SELECT * FROM rules WHERE id = $1;
 SELECT * FROM rules ORDER BY created_at DESC;
 
 -- name: UpdateRule :one
-UPDATE rules SET name = $2, description = $3, config = $4, active = $5, updated_at = NOW() 
+UPDATE rules SET name = $2, description = $3, config = $4, active = $5, version = version + 1, updated_at = NOW() 
 WHERE id = $1 RETURNING *;
 
 -- name: DeleteRule :exec
@@ -80,8 +123,8 @@ DELETE FROM rules WHERE id = $1;
 
 -- Segments queries
 -- name: CreateSegment :one
-INSERT INTO segments (id, name, description, criteria, active, created_by) 
-VALUES ($1, $2, $3, $4, $5, $6) RETURNING *;
+INSERT INTO segments (id, name, description, criteria, active, materialized, created_by) 
+VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING *;
 
 -- name: GetSegment :one
 SELECT * FROM segments WHERE id = $1;
@@ -90,7 +133,7 @@ SELECT * FROM segments WHERE id = $1;
 SELECT * FROM segments ORDER BY created_at DESC;
 
 -- name: UpdateSegment :one
-UPDATE segments SET name = $2, description = $3, criteria = $4, active = $5 
+UPDATE segments SET name = $2, description = $3, criteria = $4, active = $5, materialized = $6, version = version + 1 
 WHERE id = $1 RETURNING *;
 
 -- Enhanced rewards queries
@@ -98,9 +141,552 @@ WHERE id = $1 RETURNING *;
 SELECT * FROM rewards_catalog WHERE active = $1 ORDER BY created_at DESC;
 
 -- name: CreateReward :one
-INSERT INTO rewards_catalog (id, name, description, cost, segment, active, created_by) 
-VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING *;
+INSERT INTO rewards_catalog (id, name, description, cost, segment, active, created_by, min_tier, stock_total, daily_stock, max_per_user, max_per_user_monthly, cooldown_seconds, code_source, code_format, code_low_threshold, reward_type, credit_amount, credit_valid_days, fulfillment_adapter, fulfillment_url, fulfillment_secret, pending_ttl_seconds, available_from, available_until, available_days, min_points, cash_price, price_rules, category, tags, image_url, terms, partner_name, partner_url, partner_logo_url, sort_order, featured) 
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29, $30, $31, $32, $33, $34, $35, $36, $37, $38) RETURNING *;
 
+-- UpdateReward keeps a reward inactive while its new stock_total is already used up
 -- name: UpdateReward :one
-UPDATE rewards_catalog SET name = $2, description = $3, cost = $4, segment = $5, active = $6 
-WHERE id = $1 RETURNING *; 
\ No newline at end of file
+UPDATE rewards_catalog SET name = $2, description = $3, cost = $4, segment = $5,
+    active = $6 AND (sqlc.narg('stock_total')::int IS NULL OR stock_redeemed < sqlc.narg('stock_total')::int),
+    min_tier = $7, stock_total = sqlc.narg('stock_total')::int, daily_stock = $8,
+    max_per_user = $9, max_per_user_monthly = $10, cooldown_seconds = $11,
+    code_source = $12, code_format = $13, code_low_threshold = $14,
+    reward_type = $15, credit_amount = $16, credit_valid_days = $17,
+    fulfillment_adapter = $18, fulfillment_url = $19, fulfillment_secret = $20, pending_ttl_seconds = $21,
+    available_from = $22, available_until = $23, available_days = $24,
+    min_points = $25, cash_price = $26, price_rules = $27,
+    category = $28, tags = $29, image_url = $30, terms = $31,
+    partner_name = $32, partner_url = $33, partner_logo_url = $34, sort_order = $35, featured = $36,
+    version = version + 1 
+WHERE id = $1 RETURNING *; 
+
+-- Reward stock queries
+-- ClaimRewardStock takes one unit of an active reward that is not sold out,
+-- deactivating it and bumping its version when the last unit is taken. No
+-- row is returned otherwise.
+-- name: ClaimRewardStock :one
+UPDATE rewards_catalog
+SET stock_redeemed = stock_redeemed + 1,
+    active = CASE WHEN stock_total IS NOT NULL AND stock_redeemed + 1 >= stock_total THEN false ELSE active END,
+    version = CASE WHEN stock_total IS NOT NULL AND stock_redeemed + 1 >= stock_total THEN version + 1 ELSE version END
+WHERE id = $1 AND active = true AND (stock_total IS NULL OR stock_redeemed < stock_total)
+RETURNING *;
+
+-- ClaimDailyRewardStock counts one unit against a day's stock; no row is
+-- affected when the day's stock is used up
+-- name: ClaimDailyRewardStock :execrows
+INSERT INTO reward_daily_redemptions (reward_id, day, redeemed)
+VALUES ($1, $2, 1)
+ON CONFLICT (reward_id, day) DO UPDATE SET redeemed = reward_daily_redemptions.redeemed + 1
+WHERE reward_daily_redemptions.redeemed < sqlc.arg('daily_stock')::int;
+
+-- ReleaseRewardStock returns a unit claimed by a cancelled redemption
+-- name: ReleaseRewardStock :exec
+UPDATE rewards_catalog SET stock_redeemed = stock_redeemed - 1
+WHERE id = $1 AND stock_redeemed > 0;
+
+-- name: ReleaseDailyRewardStock :exec
+UPDATE reward_daily_redemptions SET redeemed = redeemed - 1
+WHERE reward_id = $1 AND day = $2 AND redeemed > 0;
+
+-- name: ListDailyRewardRedemptions :many
+SELECT reward_id, redeemed FROM reward_daily_redemptions WHERE day = $1;
+
+-- Per-user redemption limit queries
+-- LockUserRedemptions serializes a user's redemptions so limits and the
+-- balance are checked against committed redemptions only
+-- name: LockUserRedemptions :exec
+SELECT id FROM users WHERE id = $1 FOR UPDATE;
+
+-- CountUserRewardRedemptions counts a user's redemptions of a reward that
+-- are awaiting payment, pending or fulfilled, in total and since the start of
+-- the month
+-- name: CountUserRewardRedemptions :one
+SELECT COUNT(*)::int AS total,
+    (COUNT(*) FILTER (WHERE created_at >= sqlc.arg('month_start')::timestamptz))::int AS this_month
+FROM redemptions
+WHERE user_id = sqlc.arg('user_id') AND reward_id = sqlc.arg('reward_id') AND status IN ('AWAITING_PAYMENT', 'PENDING', 'FULFILLED');
+
+-- name: GetLastUserRewardRedemption :one
+SELECT created_at FROM redemptions
+WHERE user_id = $1 AND reward_id = $2 AND status IN ('AWAITING_PAYMENT', 'PENDING', 'FULFILLED')
+ORDER BY created_at DESC LIMIT 1;
+
+-- Voucher code queries
+-- AddRewardCode adds a code to a reward's pool; no row is inserted when the
+-- pool already has the code
+-- name: AddRewardCode :execrows
+INSERT INTO reward_codes (reward_id, code) VALUES ($1, $2)
+ON CONFLICT (reward_id, code) DO NOTHING;
+
+-- ClaimRewardCode assigns the oldest unused code of a reward to a redemption.
+-- Codes locked by concurrent claims are skipped; no row is returned when the
+-- pool is empty.
+-- name: ClaimRewardCode :one
+UPDATE reward_codes SET redemption_id = sqlc.arg('redemption_id'), claimed_at = NOW()
+WHERE id = (
+    SELECT c.id FROM reward_codes c
+    WHERE c.reward_id = sqlc.arg('reward_id') AND c.redemption_id IS NULL
+    ORDER BY c.created_at, c.id
+    LIMIT 1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- IssueRewardCode records a generated code as claimed by a redemption; no row
+-- is inserted when the reward already issued the code
+-- name: IssueRewardCode :execrows
+INSERT INTO reward_codes (reward_id, code, redemption_id, claimed_at) VALUES ($1, $2, $3, NOW())
+ON CONFLICT (reward_id, code) DO NOTHING;
+
+-- name: CountRewardCodes :one
+SELECT COUNT(*)::int AS total,
+    (COUNT(*) FILTER (WHERE redemption_id IS NULL))::int AS available
+FROM reward_codes WHERE reward_id = $1;
+
+-- MarkRewardCodesLow records that a reward's low-stock alert fired; no row is
+-- affected when it already fired since the codes were last topped up
+-- name: MarkRewardCodesLow :execrows
+UPDATE rewards_catalog SET code_low_alerted_at = NOW()
+WHERE id = $1 AND code_low_alerted_at IS NULL;
+
+-- name: ClearRewardCodesLow :exec
+UPDATE rewards_catalog SET code_low_alerted_at = NULL WHERE id = $1;
+
+-- ReleaseRewardCode returns the pool code claimed by a redemption to its
+-- pool; generated codes affect no row
+-- name: ReleaseRewardCode :execrows
+UPDATE reward_codes SET redemption_id = NULL, claimed_at = NULL
+WHERE redemption_id = $1
+  AND reward_id IN (SELECT id FROM rewards_catalog WHERE code_source = 'pool');
+
+-- VoidRewardCode voids the code issued to a redemption; it stays claimed, so
+-- it is never issued again
+-- name: VoidRewardCode :exec
+UPDATE reward_codes SET voided_at = NOW()
+WHERE redemption_id = $1 AND voided_at IS NULL;
+
+-- name: SetRedemptionVoucherCode :exec
+UPDATE redemptions SET voucher_code = $2 WHERE id = $1;
+
+-- Audit log queries
+-- name: CreateAuditLogEntry :one
+INSERT INTO admin_audit_log (actor_id, actor_email, action, entity_type, entity_id, before, after, diff, request_id, ip)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+RETURNING *;
+
+-- name: ListAuditLog :many
+SELECT * FROM admin_audit_log
+WHERE (sqlc.narg('actor_id')::uuid IS NULL OR actor_id = sqlc.narg('actor_id'))
+  AND (sqlc.narg('entity_type')::text IS NULL OR entity_type = sqlc.narg('entity_type'))
+  AND (sqlc.narg('entity_id')::uuid IS NULL OR entity_id = sqlc.narg('entity_id'))
+  AND (sqlc.narg('action')::text IS NULL OR action = sqlc.narg('action'))
+  AND (sqlc.narg('since')::timestamptz IS NULL OR created_at >= sqlc.narg('since'))
+  AND (sqlc.narg('until')::timestamptz IS NULL OR created_at < sqlc.narg('until'))
+ORDER BY created_at DESC
+LIMIT sqlc.arg('row_limit') OFFSET sqlc.arg('row_offset');
+
+-- Outbox queries
+-- name: EnqueueOutboxEvent :one
+INSERT INTO event_outbox (topic, ordering_key, payload)
+VALUES ($1, $2, $3)
+RETURNING *;
+
+-- ClaimOutboxBatch locks the due events of a topic that are the oldest
+-- unpublished event for their ordering key, so each key is published in order.
+-- Dead-lettered events are skipped and no longer hold back their key.
+-- name: ClaimOutboxBatch :many
+SELECT * FROM event_outbox o
+WHERE o.topic = sqlc.arg('topic')
+  AND o.published_at IS NULL
+  AND o.dead_at IS NULL
+  AND o.next_attempt_at <= NOW()
+  AND NOT EXISTS (
+    SELECT 1 FROM event_outbox p
+    WHERE p.topic = o.topic
+      AND p.ordering_key = o.ordering_key
+      AND p.published_at IS NULL
+      AND p.dead_at IS NULL
+      AND p.id < o.id
+  )
+ORDER BY o.id
+LIMIT sqlc.arg('batch_size')
+FOR UPDATE SKIP LOCKED;
+
+-- name: MarkOutboxEventPublished :exec
+UPDATE event_outbox
+SET published_at = NOW(), last_error = NULL
+WHERE id = $1;
+
+-- name: MarkOutboxEventFailed :exec
+UPDATE event_outbox
+SET attempts = attempts + 1, last_error = $2, next_attempt_at = $3
+WHERE id = $1;
+
+-- name: MarkOutboxEventDead :exec
+UPDATE event_outbox
+SET attempts = attempts + 1, last_error = $2, dead_at = NOW()
+WHERE id = $1;
+
+-- name: GetOutboxLag :one
+SELECT count(*) AS pending,
+       COALESCE(MIN(created_at), NOW())::timestamptz AS oldest_created_at
+FROM event_outbox
+WHERE topic = $1 AND published_at IS NULL AND dead_at IS NULL;
+
+-- name: DeletePublishedOutboxEvents :execrows
+DELETE FROM event_outbox
+WHERE published_at IS NOT NULL AND published_at < sqlc.arg('published_before')::timestamptz;
+
+-- Segment queries
+-- name: GetSegmentByName :one
+SELECT * FROM segments WHERE name = $1;
+
+-- GetUserSegmentFacts returns the ledger-derived and profile facts segment criteria are evaluated against
+-- name: GetUserSegmentFacts :one
+SELECT u.id, u.created_at,
+       COALESCE((SELECT SUM(pe.points) FROM points_events pe WHERE pe.user_id = u.id), 0)::bigint AS balance,
+       COALESCE((SELECT SUM((pe.meta->>'kwh')::double precision) FROM points_events pe
+                 WHERE pe.user_id = u.id AND pe.event_type = 'CHARGE_KWH'), 0)::double precision AS lifetime_kwh,
+       p.city, p.vehicle_type, p.vehicle_make, p.vehicle_model, p.battery_kwh,
+       p.locale, p.timezone, p.preferred_language,
+       COALESCE(p.attributes, '{}')::jsonb AS attributes,
+       t.tier
+FROM users u
+LEFT JOIN user_profiles p ON p.user_id = u.id
+LEFT JOIN user_tiers t ON t.user_id = u.id
+WHERE u.id = $1;
+
+-- ListUserSegmentFacts pages through the segment facts of all users in ID order
+-- name: ListUserSegmentFacts :many
+SELECT u.id, u.created_at,
+       COALESCE((SELECT SUM(pe.points) FROM points_events pe WHERE pe.user_id = u.id), 0)::bigint AS balance,
+       COALESCE((SELECT SUM((pe.meta->>'kwh')::double precision) FROM points_events pe
+                 WHERE pe.user_id = u.id AND pe.event_type = 'CHARGE_KWH'), 0)::double precision AS lifetime_kwh,
+       p.city, p.vehicle_type, p.vehicle_make, p.vehicle_model, p.battery_kwh,
+       p.locale, p.timezone, p.preferred_language,
+       COALESCE(p.attributes, '{}')::jsonb AS attributes,
+       t.tier
+FROM users u
+LEFT JOIN user_profiles p ON p.user_id = u.id
+LEFT JOIN user_tiers t ON t.user_id = u.id
+WHERE u.id > sqlc.arg('after_id')::uuid
+ORDER BY u.id
+LIMIT sqlc.arg('batch_size')::int;
+
+-- name: ListMaterializedSegments :many
+SELECT * FROM segments WHERE materialized = true AND active = true ORDER BY name;
+
+-- name: UpsertSegmentMember :exec
+INSERT INTO segment_members (segment_id, user_id, refreshed_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (segment_id, user_id) DO UPDATE SET refreshed_at = EXCLUDED.refreshed_at;
+
+-- name: DeleteSegmentMember :exec
+DELETE FROM segment_members WHERE segment_id = $1 AND user_id = $2;
+
+-- DeleteStaleSegmentMembers removes members a full refresh no longer matched
+-- name: DeleteStaleSegmentMembers :execrows
+DELETE FROM segment_members
+WHERE segment_id = $1 AND refreshed_at < sqlc.arg('refreshed_before')::timestamptz;
+
+-- name: SetSegmentMaterializedAt :exec
+UPDATE segments SET materialized_at = $2 WHERE id = $1;
+
+-- name: IsSegmentMember :one
+SELECT EXISTS (
+    SELECT 1 FROM segment_members WHERE segment_id = $1 AND user_id = $2
+) AS member;
+
+-- name: CountSegmentMembers :one
+SELECT count(*) FROM segment_members WHERE segment_id = $1;
+
+-- ListSegmentMemberFacts returns the segment facts of a sample of a materialized segment's members
+-- name: ListSegmentMemberFacts :many
+SELECT u.id, u.created_at,
+       COALESCE((SELECT SUM(pe.points) FROM points_events pe WHERE pe.user_id = u.id), 0)::bigint AS balance,
+       COALESCE((SELECT SUM((pe.meta->>'kwh')::double precision) FROM points_events pe
+                 WHERE pe.user_id = u.id AND pe.event_type = 'CHARGE_KWH'), 0)::double precision AS lifetime_kwh,
+       p.city, p.vehicle_type, p.vehicle_make, p.vehicle_model, p.battery_kwh,
+       p.locale, p.timezone, p.preferred_language,
+       COALESCE(p.attributes, '{}')::jsonb AS attributes,
+       t.tier
+FROM segment_members sm
+JOIN users u ON u.id = sm.user_id
+LEFT JOIN user_profiles p ON p.user_id = u.id
+LEFT JOIN user_tiers t ON t.user_id = u.id
+WHERE sm.segment_id = $1
+ORDER BY u.id
+LIMIT sqlc.arg('sample_size')::int;
+
+-- OTP login queries
+-- name: CreateOTPChallenge :one
+INSERT INTO otp_challenges (phone, code_hash, expires_at)
+VALUES ($1, $2, $3)
+RETURNING *;
+
+-- name: GetLatestOTPChallenge :one
+SELECT * FROM otp_challenges
+WHERE phone = $1
+ORDER BY created_at DESC
+LIMIT 1;
+
+-- name: CountOTPChallengesSince :one
+SELECT count(*) FROM otp_challenges
+WHERE phone = $1 AND created_at >= sqlc.arg('since')::timestamptz;
+
+-- LockOTPPhone serializes code requests for a phone number until the
+-- transaction ends, so concurrent requests cannot all pass the rate limits
+-- name: LockOTPPhone :exec
+SELECT pg_advisory_xact_lock(hashtext(sqlc.arg('phone')::text));
+
+-- ClaimOTPAttempt counts a verification attempt before the code is compared;
+-- it affects no rows once the challenge is used or has max_attempts attempts
+-- name: ClaimOTPAttempt :execrows
+UPDATE otp_challenges SET attempts = attempts + 1
+WHERE id = $1 AND verified_at IS NULL AND attempts < sqlc.arg('max_attempts')::int;
+
+-- MarkOTPVerified consumes a challenge; it affects no rows if the challenge was already used
+-- name: MarkOTPVerified :execrows
+UPDATE otp_challenges SET verified_at = NOW()
+WHERE id = $1 AND verified_at IS NULL;
+
+-- Loyalty tier queries
+-- name: GetUserTier :one
+SELECT * FROM user_tiers WHERE user_id = $1;
+
+-- GetUserTierStats returns what a user charged and earned since the start of the qualification window.
+-- Refunds of redemptions and credits give back spent points, so they do not count as earned.
+-- name: GetUserTierStats :one
+SELECT COALESCE(SUM(points) FILTER (WHERE points > 0 AND event_type NOT IN ('REDEMPTION_REFUND', 'CREDIT_REFUND')), 0)::bigint AS earned_points,
+       COALESCE(SUM((meta->>'kwh')::double precision) FILTER (WHERE event_type = 'CHARGE_KWH'), 0)::double precision AS kwh
+FROM points_events
+WHERE user_id = $1 AND created_at >= sqlc.arg('since')::timestamptz;
+
+-- ListUserTierStats pages through every user's current tier and qualification window stats in ID order,
+-- counting earned points as GetUserTierStats does
+-- name: ListUserTierStats :many
+SELECT u.id,
+       t.tier, t.downgrade_at,
+       COALESCE((SELECT SUM(pe.points) FROM points_events pe
+                 WHERE pe.user_id = u.id AND pe.points > 0 AND pe.event_type NOT IN ('REDEMPTION_REFUND', 'CREDIT_REFUND')
+                   AND pe.created_at >= sqlc.arg('since')::timestamptz), 0)::bigint AS earned_points,
+       COALESCE((SELECT SUM((pe.meta->>'kwh')::double precision) FROM points_events pe
+                 WHERE pe.user_id = u.id AND pe.event_type = 'CHARGE_KWH' AND pe.created_at >= sqlc.arg('since')::timestamptz), 0)::double precision AS kwh
+FROM users u
+LEFT JOIN user_tiers t ON t.user_id = u.id
+WHERE u.id > sqlc.arg('after_id')::uuid
+ORDER BY u.id
+LIMIT sqlc.arg('batch_size')::int;
+
+-- UpsertUserTier records an evaluation; tier_since only moves when the tier changes
+-- name: UpsertUserTier :one
+INSERT INTO user_tiers (user_id, tier, tier_since, rolling_kwh, rolling_points, downgrade_at, evaluated_at)
+VALUES ($1, $2, sqlc.arg('evaluated_at')::timestamptz, $3, $4, $5, sqlc.arg('evaluated_at')::timestamptz)
+ON CONFLICT (user_id) DO UPDATE SET
+    tier = EXCLUDED.tier,
+    tier_since = CASE WHEN user_tiers.tier = EXCLUDED.tier THEN user_tiers.tier_since ELSE EXCLUDED.tier_since END,
+    rolling_kwh = EXCLUDED.rolling_kwh,
+    rolling_points = EXCLUDED.rolling_points,
+    downgrade_at = EXCLUDED.downgrade_at,
+    evaluated_at = EXCLUDED.evaluated_at
+RETURNING *;
+
+-- DeleteUserTier removes the tier of a user who no longer qualifies for any
+-- name: DeleteUserTier :exec
+DELETE FROM user_tiers WHERE user_id = $1;
+
+-- Charging credit queries
+-- name: CreateChargingCredit :one
+INSERT INTO charging_credits (user_id, redemption_id, amount, remaining, points_spent, expires_at)
+VALUES ($1, $2, $3, $3, $4, $5)
+RETURNING *;
+
+-- name: ListUserChargingCredits :many
+SELECT * FROM charging_credits
+WHERE user_id = sqlc.arg('user_id') AND status = 'ACTIVE' AND expires_at > sqlc.arg('now')::timestamptz
+ORDER BY expires_at ASC, created_at ASC;
+
+-- LockUserChargingCredits locks a user's usable credits, soonest to expire
+-- first, so concurrent consume calls apply them one at a time
+-- name: LockUserChargingCredits :many
+SELECT * FROM charging_credits
+WHERE user_id = sqlc.arg('user_id') AND status = 'ACTIVE' AND expires_at > sqlc.arg('now')::timestamptz
+ORDER BY expires_at ASC, created_at ASC
+FOR UPDATE;
+
+-- ConsumeChargingCredit takes amount from a credit, marking it CONSUMED when
+-- nothing is left
+-- name: ConsumeChargingCredit :one
+UPDATE charging_credits
+SET remaining = remaining - sqlc.arg('amount')::bigint,
+    status = CASE WHEN remaining = sqlc.arg('amount')::bigint THEN 'CONSUMED' ELSE status END
+WHERE id = sqlc.arg('id') AND status = 'ACTIVE' AND remaining >= sqlc.arg('amount')::bigint
+RETURNING *;
+
+-- name: CreateCreditConsumption :one
+INSERT INTO credit_consumptions (credit_id, user_id, session_id, amount)
+VALUES ($1, $2, $3, $4)
+RETURNING *;
+
+-- name: ListSessionCreditConsumptions :many
+SELECT * FROM credit_consumptions
+WHERE user_id = $1 AND session_id = $2
+ORDER BY created_at ASC, id ASC;
+
+-- ListDueChargingCredits claims a batch of active credits past their expiry;
+-- credits locked by a concurrent run are skipped
+-- name: ListDueChargingCredits :many
+SELECT * FROM charging_credits
+WHERE status = 'ACTIVE' AND expires_at <= sqlc.arg('now')::timestamptz
+ORDER BY expires_at ASC
+LIMIT sqlc.arg('batch_size')::int
+FOR UPDATE SKIP LOCKED;
+
+-- name: ExpireChargingCredit :one
+UPDATE charging_credits SET status = 'EXPIRED', refunded_points = $2
+WHERE id = $1 AND status = 'ACTIVE'
+RETURNING *;
+
+-- Fulfillment queries
+-- CreateFulfillmentDelivery queues a redemption for delivery; redelivered
+-- RedemptionCreated events affect no row
+-- name: CreateFulfillmentDelivery :execrows
+INSERT INTO fulfillment_deliveries (redemption_id, reward_id, adapter, payload)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (redemption_id) DO NOTHING;
+
+-- ClaimDueFulfillmentDeliveries leases a batch of deliveries due for an
+-- attempt until locked_until; deliveries leased or locked by a concurrent run
+-- are skipped
+-- name: ClaimDueFulfillmentDeliveries :many
+UPDATE fulfillment_deliveries SET locked_until = sqlc.arg('locked_until')::timestamptz
+WHERE id IN (
+    SELECT id FROM fulfillment_deliveries
+    WHERE status = 'PENDING' AND next_attempt_at <= sqlc.arg('now')::timestamptz
+      AND (locked_until IS NULL OR locked_until <= sqlc.arg('now')::timestamptz)
+    ORDER BY next_attempt_at ASC
+    LIMIT sqlc.arg('batch_size')::int
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- FinishFulfillmentDelivery records a delivery's final attempt; deliveries
+-- whose lease was lost, to a cancellation or another run, affect no row
+-- name: FinishFulfillmentDelivery :execrows
+UPDATE fulfillment_deliveries
+SET status = sqlc.arg('status'), attempts = attempts + 1, last_error = sqlc.arg('last_error'),
+    response_status = sqlc.arg('response_status'), locked_until = NULL
+WHERE id = sqlc.arg('id') AND status = 'PENDING' AND locked_until = sqlc.arg('locked_until')::timestamptz;
+
+-- RetryFulfillmentDelivery records a failed attempt to be retried at
+-- next_attempt_at; deliveries whose lease was lost affect no row
+-- name: RetryFulfillmentDelivery :execrows
+UPDATE fulfillment_deliveries
+SET attempts = attempts + 1, last_error = sqlc.arg('last_error'), response_status = sqlc.arg('response_status'),
+    next_attempt_at = sqlc.arg('next_attempt_at'), locked_until = NULL
+WHERE id = sqlc.arg('id') AND status = 'PENDING' AND locked_until = sqlc.arg('locked_until')::timestamptz;
+
+-- FinishPendingRedemption settles a redemption still pending; redemptions
+-- already expired or settled affect no row
+-- name: FinishPendingRedemption :execrows
+UPDATE redemptions SET status = $2
+WHERE id = $1 AND status = 'PENDING';
+
+-- Cancellation queries
+-- LockPendingFulfillmentDelivery locks a redemption's pending delivery against
+-- new attempts
+-- name: LockPendingFulfillmentDelivery :one
+SELECT * FROM fulfillment_deliveries
+WHERE redemption_id = $1 AND status = 'PENDING'
+FOR UPDATE;
+
+-- CancelFulfillmentDelivery stops a pending delivery
+-- name: CancelFulfillmentDelivery :exec
+UPDATE fulfillment_deliveries SET status = 'CANCELLED'
+WHERE redemption_id = $1 AND status = 'PENDING';
+
+-- CancelPendingRedemption cancels a user's pending redemption made after
+-- cancellable_after; no row is returned otherwise
+-- name: CancelPendingRedemption :one
+UPDATE redemptions SET status = 'CANCELLED'
+WHERE id = sqlc.arg('id') AND user_id = sqlc.arg('user_id') AND status = 'PENDING'
+    AND created_at > sqlc.arg('cancellable_after')::timestamptz
+RETURNING *;
+
+-- Redemption history queries
+-- ListUserRedemptions pages through a user's redemptions, newest first,
+-- optionally limited to one status
+-- name: ListUserRedemptions :many
+SELECT r.id, r.reward_id, r.points_spent, r.base_cost, r.status, r.voucher_code, r.expires_at, r.created_at, r.updated_at,
+       c.name AS reward_name, c.reward_type
+FROM redemptions r
+JOIN rewards_catalog c ON c.id = r.reward_id
+WHERE r.user_id = sqlc.arg('user_id')
+  AND (sqlc.narg('status')::text IS NULL OR r.status = sqlc.narg('status'))
+ORDER BY r.created_at DESC, r.id DESC
+LIMIT sqlc.arg('row_limit')::int OFFSET sqlc.arg('row_offset')::int;
+
+-- name: ListRedemptionStatusEvents :many
+SELECT * FROM redemption_status_events
+WHERE redemption_id = $1
+ORDER BY occurred_at ASC;
+
+-- name: GetRedemptionChargingCredit :one
+SELECT * FROM charging_credits
+WHERE redemption_id = $1 LIMIT 1;
+
+-- Points + cash payment queries
+-- name: CreateRedemptionPayment :one
+INSERT INTO redemption_payments (redemption_id, provider, amount, currency, expires_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: SetRedemptionPaymentIntent :exec
+UPDATE redemption_payments SET intent_id = $2 WHERE id = $1;
+
+-- LockRedemptionPayment loads a redemption's payment for settling it
+-- name: LockRedemptionPayment :one
+SELECT * FROM redemption_payments
+WHERE redemption_id = $1
+FOR UPDATE;
+
+-- name: GetRedemptionPayment :one
+SELECT * FROM redemption_payments
+WHERE redemption_id = $1 LIMIT 1;
+
+-- ListOpenRedemptionPayments lists a batch of unpaid payments after a cursor
+-- to check with the provider; each is locked again before it is settled
+-- name: ListOpenRedemptionPayments :many
+SELECT * FROM redemption_payments
+WHERE status = 'REQUIRES_PAYMENT' AND id > sqlc.arg('after')::uuid
+ORDER BY id ASC
+LIMIT sqlc.arg('batch_size')::int;
+
+-- name: FinishRedemptionPayment :one
+UPDATE redemption_payments SET status = $2, failure_reason = $3
+WHERE id = $1
+RETURNING *;
+
+-- SettleAwaitingRedemption moves a redemption awaiting payment to its
+-- outcome, restarting its expiry if one is given; no row is returned once it
+-- has left AWAITING_PAYMENT
+-- name: SettleAwaitingRedemption :one
+UPDATE redemptions SET status = sqlc.arg('status'),
+    expires_at = COALESCE(sqlc.narg('expires_at')::timestamptz, expires_at)
+WHERE id = sqlc.arg('id') AND status = 'AWAITING_PAYMENT'
+RETURNING *;
+
+-- Price quote queries
+-- name: CreatePriceQuote :one
+INSERT INTO price_quotes (user_id, prices, expires_at)
+VALUES ($1, $2, $3)
+RETURNING *;
+
+-- name: GetPriceQuote :one
+SELECT * FROM price_quotes
+WHERE id = $1 LIMIT 1;
+
+-- DeleteExpiredPriceQuotes deletes quotes that expired before a cutoff
+-- name: DeleteExpiredPriceQuotes :execrows
+DELETE FROM price_quotes WHERE expires_at < $1;
@@ -5,6 +5,24 @@ CREATE TABLE users (
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 
+-- user_profiles table (optional profile data used for targeting and personalization)
+CREATE TABLE user_profiles (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT,
+    email TEXT,
+    locale TEXT, -- BCP 47 tag, e.g. en-IN
+    timezone TEXT, -- IANA zone, e.g. Asia/Kolkata
+    city TEXT,
+    vehicle_type TEXT, -- 2W / 3W / 4W
+    vehicle_make TEXT,
+    vehicle_model TEXT,
+    battery_kwh DOUBLE PRECISION,
+    preferred_language TEXT, -- ISO 639-1 code, e.g. hi
+    attributes JSONB NOT NULL DEFAULT '{}', -- custom attributes, "attributes.<key>" in segment criteria
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
 -- points_events table (append-only ledger)
 CREATE TABLE points_events (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
@@ -23,6 +41,7 @@ CREATE TABLE rules (
     description TEXT,
     config JSONB NOT NULL,
     active BOOLEAN NOT NULL DEFAULT true,
+    version INT NOT NULL DEFAULT 1, -- bumped on every change, carried in change events
     created_by UUID REFERENCES users(id) ON DELETE SET NULL,
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
     updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
@@ -35,6 +54,9 @@ CREATE TABLE segments (
     description TEXT,
     criteria JSONB NOT NULL,
     active BOOLEAN NOT NULL DEFAULT true,
+    version INT NOT NULL DEFAULT 1,
+    materialized BOOLEAN NOT NULL DEFAULT false, -- members are kept in segment_members
+    materialized_at TIMESTAMPTZ, -- last full refresh of segment_members
     created_by UUID REFERENCES users(id) ON DELETE SET NULL,
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
@@ -46,18 +68,65 @@ CREATE TABLE rewards_catalog (
     description TEXT,
     cost INT NOT NULL,
     segment JSONB, -- user attributes or feature-flag keys
+    min_tier TEXT, -- silver / gold / platinum; NULL for every tier
+    reward_type TEXT NOT NULL DEFAULT 'standard', -- standard / charging_credit
+    credit_amount BIGINT, -- charging credit issued per redemption, in paise
+    credit_valid_days INT, -- days a charging credit can be used; NULL for the default
+    stock_total INT, -- units available in total; NULL for unlimited
+    stock_redeemed INT NOT NULL DEFAULT 0, -- units claimed by redemptions
+    daily_stock INT, -- units available per UTC day; NULL for unlimited
+    max_per_user INT, -- redemptions per user in total; NULL for unlimited
+    max_per_user_monthly INT, -- redemptions per user per UTC calendar month; NULL for unlimited
+    cooldown_seconds INT, -- minimum time between a user's redemptions; NULL for none
+    pending_ttl_seconds INT, -- time a redemption may stay pending before it expires; NULL for 24 hours
+    available_from TIMESTAMPTZ, -- when the reward becomes available; NULL for now
+    available_until TIMESTAMPTZ, -- when the reward stops being available; NULL for never
+    available_days INT, -- UTC weekdays the reward is available, bit 0 = Sunday; NULL for every day
+    min_points INT, -- points paid alongside cash_price in a points + cash redemption
+    cash_price BIGINT, -- cash top-up of a points + cash redemption, in paise; NULL for points only
+    price_rules JSONB, -- tier discounts, segment prices and sales lowering cost; NULL for none
+    category TEXT, -- lowercase slug, e.g. charging / food / travel; NULL for uncategorized
+    tags JSONB, -- array of lowercase slugs the catalog is filtered and searched by; NULL for none
+    image_url TEXT,
+    terms TEXT, -- terms & conditions shown before redeeming
+    partner_name TEXT, -- partner providing the reward; NULL for Urja's own rewards
+    partner_url TEXT,
+    partner_logo_url TEXT,
+    sort_order INT NOT NULL DEFAULT 0, -- position in the catalog, lowest first after featured rewards
+    featured BOOLEAN NOT NULL DEFAULT false, -- listed first and highlighted in the app
+    code_source TEXT, -- pool / generated; NULL when redemptions carry no voucher code
+    code_format TEXT, -- format of generated codes; NULL for the default
+    code_low_threshold INT, -- alert when this many pool codes are left; NULL for no alert
+    code_low_alerted_at TIMESTAMPTZ, -- set when the low-stock alert fires, cleared by uploads
+    fulfillment_adapter TEXT, -- webhook; NULL when the reward is fulfilled outside the platform
+    fulfillment_url TEXT, -- partner endpoint receiving webhook deliveries
+    fulfillment_secret TEXT, -- key signing webhook deliveries
     active BOOLEAN NOT NULL DEFAULT true,
+    version INT NOT NULL DEFAULT 1,
     created_by UUID REFERENCES users(id) ON DELETE SET NULL,
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 
+-- checkouts table: carts of rewards redeemed together in one transaction
+CREATE TABLE checkouts (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    points_spent INT NOT NULL, -- total points of the checkout's redemptions
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
 -- redemptions table
 CREATE TABLE redemptions (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
     reward_id UUID NOT NULL REFERENCES rewards_catalog(id) ON DELETE CASCADE,
     points_spent INT NOT NULL,
-    status TEXT NOT NULL DEFAULT 'PENDING', -- PENDING / FULFILLED / EXPIRED
+    status TEXT NOT NULL DEFAULT 'PENDING', -- AWAITING_PAYMENT / PENDING / FULFILLED / FAILED / CANCELLED / EXPIRED
+    voucher_code TEXT, -- code issued from the reward's pool or generated
+    expires_at TIMESTAMPTZ NOT NULL DEFAULT NOW() + INTERVAL '24 hours', -- when the redemption expires if still pending
+    base_cost INT, -- the reward's cost before price rules; NULL for redemptions made before pricing
+    price_rule TEXT, -- type of the price rule that set points_spent; NULL at the base cost
+    checkout_id UUID REFERENCES checkouts(id) ON DELETE SET NULL, -- the checkout that made the redemption; NULL for single redemptions
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
     updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
@@ -72,8 +141,11 @@ CREATE INDEX idx_segments_active ON segments(active);
 CREATE INDEX idx_segments_name ON segments(name);
 CREATE INDEX idx_rewards_catalog_active ON rewards_catalog(active);
 CREATE INDEX idx_redemptions_user_id ON redemptions(user_id);
+CREATE INDEX idx_redemptions_user_reward ON redemptions(user_id, reward_id, created_at);
 CREATE INDEX idx_redemptions_status ON redemptions(status);
 CREATE INDEX idx_redemptions_created_at ON redemptions(created_at);
+CREATE INDEX idx_redemptions_pending_expiry ON redemptions(expires_at) WHERE status = 'PENDING';
+CREATE INDEX idx_redemptions_checkout_id ON redemptions(checkout_id) WHERE checkout_id IS NOT NULL;
 
 -- Function to update updated_at timestamp
 CREATE OR REPLACE FUNCTION update_updated_at_column()
@@ -90,8 +162,243 @@ CREATE TRIGGER update_redemptions_updated_at
     FOR EACH ROW 
     EXECUTE FUNCTION update_updated_at_column();
 
+-- redemption_status_events table: each status a redemption has entered, for
+-- the redemption timeline; written by the trigger below
+CREATE TABLE redemption_status_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    redemption_id UUID NOT NULL REFERENCES redemptions(id) ON DELETE CASCADE,
+    status TEXT NOT NULL,
+    occurred_at TIMESTAMPTZ NOT NULL DEFAULT clock_timestamp()
+);
+
+CREATE INDEX idx_redemption_status_events_redemption ON redemption_status_events(redemption_id, occurred_at);
+
+-- Function to record a redemption's new status
+CREATE OR REPLACE FUNCTION record_redemption_status()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' OR NEW.status IS DISTINCT FROM OLD.status THEN
+        INSERT INTO redemption_status_events (redemption_id, status) VALUES (NEW.id, NEW.status);
+    END IF;
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+-- Trigger to record the status timeline of redemptions
+CREATE TRIGGER record_redemptions_status
+    AFTER INSERT OR UPDATE OF status ON redemptions
+    FOR EACH ROW
+    EXECUTE FUNCTION record_redemption_status();
+
+-- Trigger to automatically update updated_at on user_profiles
+CREATE TRIGGER update_user_profiles_updated_at 
+    BEFORE UPDATE ON user_profiles 
+    FOR EACH ROW 
+    EXECUTE FUNCTION update_updated_at_column();
+
 -- Trigger to automatically update updated_at on rules
 CREATE TRIGGER update_rules_updated_at 
     BEFORE UPDATE ON rules 
     FOR EACH ROW 
-    EXECUTE FUNCTION update_updated_at_column(); 
\ No newline at end of file
+    EXECUTE FUNCTION update_updated_at_column(); 
+
+-- admin_audit_log table (who changed what in the admin API)
+CREATE TABLE admin_audit_log (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    actor_id UUID,
+    actor_email TEXT,
+    action TEXT NOT NULL, -- e.g. rule.created / reward.updated / segment.updated
+    entity_type TEXT NOT NULL, -- rule / reward / segment / adjustment
+    entity_id UUID,
+    before JSONB,
+    after JSONB,
+    diff JSONB,
+    request_id TEXT,
+    ip TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_admin_audit_log_actor_id ON admin_audit_log(actor_id);
+CREATE INDEX idx_admin_audit_log_entity ON admin_audit_log(entity_type, entity_id);
+CREATE INDEX idx_admin_audit_log_created_at ON admin_audit_log(created_at);
+
+-- event_outbox table: pub/sub events written in the same transaction as the
+-- change that produced them and published by a relay worker. Events that keep
+-- failing are dead-lettered (dead_at set) and no longer hold back their key.
+CREATE TABLE event_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    topic TEXT NOT NULL,
+    ordering_key TEXT NOT NULL, -- events sharing a key (the user ID) are published in order
+    payload JSONB NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    published_at TIMESTAMPTZ,
+    dead_at TIMESTAMPTZ -- set when the relay gave up after its max attempts
+);
+
+CREATE INDEX idx_event_outbox_pending ON event_outbox(topic, id) WHERE published_at IS NULL AND dead_at IS NULL;
+CREATE INDEX idx_event_outbox_ordering_key ON event_outbox(ordering_key, id) WHERE published_at IS NULL AND dead_at IS NULL;
+CREATE INDEX idx_event_outbox_dead ON event_outbox(topic, dead_at) WHERE dead_at IS NOT NULL;
+
+-- segment_members table: members of materialized segments, fully refreshed on
+-- a schedule and kept current per user on UserPointsUpdated
+CREATE TABLE segment_members (
+    segment_id UUID NOT NULL REFERENCES segments(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    refreshed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(), -- last time the user was seen to match
+    PRIMARY KEY (segment_id, user_id)
+);
+
+CREATE INDEX idx_segment_members_user_id ON segment_members(user_id);
+
+-- otp_challenges table: one-time codes sent by SMS for phone login
+CREATE TABLE otp_challenges (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    phone TEXT NOT NULL, -- E.164, e.g. +919876543210
+    code_hash TEXT NOT NULL, -- SHA-256 of the phone and code, never the code itself
+    attempts INT NOT NULL DEFAULT 0, -- verification attempts
+    expires_at TIMESTAMPTZ NOT NULL,
+    verified_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_otp_challenges_phone_created_at ON otp_challenges(phone, created_at);
+
+-- price_quotes table: reward prices shown to a user in the catalog, honoured
+-- by redemptions until they expire
+CREATE TABLE price_quotes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    prices JSONB NOT NULL, -- reward ID to quoted cost and price rule
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_price_quotes_expires_at ON price_quotes(expires_at);
+
+-- user_tiers table: each user's loyalty tier, re-evaluated nightly from the
+-- rolling 12-month ledger
+CREATE TABLE user_tiers (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    tier TEXT NOT NULL, -- silver / gold / platinum
+    tier_since TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    rolling_kwh DOUBLE PRECISION NOT NULL DEFAULT 0, -- kWh charged in the qualification window
+    rolling_points BIGINT NOT NULL DEFAULT 0, -- points earned in the qualification window
+    downgrade_at TIMESTAMPTZ, -- when the user drops a tier unless they requalify first
+    evaluated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_user_tiers_tier ON user_tiers(tier);
+
+-- reward_daily_redemptions table: units of each reward claimed per UTC day,
+-- enforcing rewards_catalog.daily_stock
+CREATE TABLE reward_daily_redemptions (
+    reward_id UUID NOT NULL REFERENCES rewards_catalog(id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    redeemed INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (reward_id, day)
+);
+
+-- reward_codes table: voucher codes of rewards with a code pool, and the
+-- generated codes issued by rewards that generate them
+CREATE TABLE reward_codes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    reward_id UUID NOT NULL REFERENCES rewards_catalog(id) ON DELETE CASCADE,
+    code TEXT NOT NULL,
+    redemption_id UUID REFERENCES redemptions(id) ON DELETE SET NULL, -- NULL while unused
+    claimed_at TIMESTAMPTZ,
+    voided_at TIMESTAMPTZ, -- set when the redemption holding the code was withdrawn
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (reward_id, code)
+);
+
+CREATE INDEX idx_reward_codes_unused ON reward_codes(reward_id, created_at) WHERE redemption_id IS NULL;
+
+-- charging_credits table: monetary credit issued by charging_credit rewards,
+-- consumed by the charge-point backend when billing sessions
+CREATE TABLE charging_credits (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    redemption_id UUID NOT NULL UNIQUE REFERENCES redemptions(id) ON DELETE CASCADE,
+    amount BIGINT NOT NULL, -- credit issued, in paise
+    remaining BIGINT NOT NULL, -- credit not consumed yet, in paise
+    currency TEXT NOT NULL DEFAULT 'INR',
+    points_spent INT NOT NULL, -- points paid for the credit, refunded pro rata on expiry
+    status TEXT NOT NULL DEFAULT 'ACTIVE', -- ACTIVE / CONSUMED / EXPIRED
+    refunded_points INT NOT NULL DEFAULT 0,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_charging_credits_user_active ON charging_credits(user_id, expires_at) WHERE status = 'ACTIVE';
+CREATE INDEX idx_charging_credits_expiry ON charging_credits(expires_at) WHERE status = 'ACTIVE';
+
+CREATE TRIGGER update_charging_credits_updated_at 
+    BEFORE UPDATE ON charging_credits 
+    FOR EACH ROW 
+    EXECUTE FUNCTION update_updated_at_column();
+
+-- credit_consumptions table: credit applied to charging sessions; a session's
+-- consumptions make repeated consume calls for it idempotent
+CREATE TABLE credit_consumptions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    credit_id UUID NOT NULL REFERENCES charging_credits(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    session_id TEXT NOT NULL,
+    amount BIGINT NOT NULL, -- in paise
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (credit_id, session_id)
+);
+
+CREATE INDEX idx_credit_consumptions_session ON credit_consumptions(user_id, session_id);
+
+-- fulfillment_deliveries table: redemptions to deliver to the reward's partner,
+-- retried with backoff until the partner accepts or rejects them
+CREATE TABLE fulfillment_deliveries (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    redemption_id UUID NOT NULL UNIQUE REFERENCES redemptions(id) ON DELETE CASCADE,
+    reward_id UUID NOT NULL REFERENCES rewards_catalog(id) ON DELETE CASCADE,
+    adapter TEXT NOT NULL,
+    payload JSONB NOT NULL, -- the RedemptionCreated event sent to the partner
+    status TEXT NOT NULL DEFAULT 'PENDING', -- PENDING / DELIVERED / FAILED / CANCELLED
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    response_status INT, -- HTTP status of the last attempt
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMPTZ, -- lease of the attempt in progress, if any
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_fulfillment_deliveries_due ON fulfillment_deliveries(next_attempt_at) WHERE status = 'PENDING';
+
+CREATE TRIGGER update_fulfillment_deliveries_updated_at 
+    BEFORE UPDATE ON fulfillment_deliveries 
+    FOR EACH ROW 
+    EXECUTE FUNCTION update_updated_at_column();
+
+-- redemption_payments table: cash top-ups of points + cash redemptions; the
+-- redemption is confirmed once its payment succeeds
+CREATE TABLE redemption_payments (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    redemption_id UUID NOT NULL UNIQUE REFERENCES redemptions(id) ON DELETE CASCADE,
+    provider TEXT NOT NULL,
+    intent_id TEXT, -- the provider's payment intent; NULL until it is created
+    amount BIGINT NOT NULL, -- in paise
+    currency TEXT NOT NULL DEFAULT 'INR',
+    status TEXT NOT NULL DEFAULT 'REQUIRES_PAYMENT', -- REQUIRES_PAYMENT / SUCCEEDED / FAILED / CANCELLED
+    failure_reason TEXT,
+    expires_at TIMESTAMPTZ NOT NULL, -- unpaid payments are cancelled after this
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_redemption_payments_open ON redemption_payments(id) WHERE status = 'REQUIRES_PAYMENT';
+
+CREATE TRIGGER update_redemption_payments_updated_at 
+    BEFORE UPDATE ON redemption_payments 
+    FOR EACH ROW 
+    EXECUTE FUNCTION update_updated_at_column();
@@ -0,0 +1,72 @@
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// Change is the before/after value of a single changed field
+type Change struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Diff compares the JSON representations of before and after field by field
+// and returns the changed top-level fields. Either side may be nil, e.g. for
+// creations and deletions.
+func Diff(before, after interface{}) (map[string]Change, error) {
+	b, err := toMap(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := toMap(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]Change{}
+	for _, key := range unionKeys(b, a) {
+		if !reflect.DeepEqual(b[key], a[key]) {
+			changes[key] = Change{Before: b[key], After: a[key]}
+		}
+	}
+	return changes, nil
+}
+
+// Marshal encodes v as JSON, returning nil for a nil value
+func Marshal(v interface{}) (json.RawMessage, error) {
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
+
+// toMap converts a value to its generic JSON object form
+func toMap(v interface{}) (map[string]interface{}, error) {
+	data, err := Marshal(v)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// unionKeys returns the sorted keys present in either map
+func unionKeys(a, b map[string]interface{}) []string {
+	seen := map[string]bool{}
+	var keys []string
+	for _, m := range []map[string]interface{}{a, b} {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
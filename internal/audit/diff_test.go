@@ -0,0 +1,45 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testRule struct {
+	Name   string                 `json:"name"`
+	Active bool                   `json:"active"`
+	Config map[string]interface{} `json:"config"`
+}
+
+func TestDiff_Update(t *testing.T) {
+	before := testRule{Name: "charge_kwh", Active: true, Config: map[string]interface{}{"points_per_kwh": 10}}
+	after := testRule{Name: "charge_kwh", Active: false, Config: map[string]interface{}{"points_per_kwh": 12}}
+
+	changes, err := Diff(before, after)
+	require.NoError(t, err)
+	assert.Len(t, changes, 2)
+	assert.Equal(t, Change{Before: true, After: false}, changes["active"])
+	assert.Equal(t, map[string]interface{}{"points_per_kwh": float64(12)}, changes["config"].After)
+	assert.NotContains(t, changes, "name")
+}
+
+func TestDiff_CreateAndDelete(t *testing.T) {
+	rule := &testRule{Name: "referral", Active: true}
+
+	created, err := Diff(nil, rule)
+	require.NoError(t, err)
+	assert.Equal(t, Change{Before: nil, After: "referral"}, created["name"])
+
+	deleted, err := Diff(rule, nil)
+	require.NoError(t, err)
+	assert.Equal(t, Change{Before: "referral", After: nil}, deleted["name"])
+}
+
+func TestMarshal_Nil(t *testing.T) {
+	var rule *testRule
+	data, err := Marshal(rule)
+	require.NoError(t, err)
+	assert.Nil(t, data)
+}
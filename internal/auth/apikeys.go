@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"strings"
+)
+
+// APIKeys maps a service credential's name to the SHA-256 digest of its key
+type APIKeys map[string][32]byte
+
+// ParseAPIKeys parses a comma-separated list of name=key pairs, e.g.
+// "chargepoint-backend=s3cr3t,billing=an0ther"
+func ParseAPIKeys(spec string) (APIKeys, error) {
+	keys := APIKeys{}
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, key, ok := strings.Cut(pair, "=")
+		if !ok || name == "" || key == "" {
+			return nil, fmt.Errorf("invalid API key entry %q: expected name=key", pair)
+		}
+		keys[name] = sha256.Sum256([]byte(key))
+	}
+	return keys, nil
+}
+
+// Match returns the name of the service owning the presented key
+func (k APIKeys) Match(presented string) (string, bool) {
+	if presented == "" {
+		return "", false
+	}
+	digest := sha256.Sum256([]byte(presented))
+	for name, want := range k {
+		if subtle.ConstantTimeCompare(digest[:], want[:]) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
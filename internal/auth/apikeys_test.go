@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAPIKeys(t *testing.T) {
+	keys, err := ParseAPIKeys("chargepoint-backend=s3cr3t, billing=an0ther")
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+
+	name, ok := keys.Match("s3cr3t")
+	assert.True(t, ok)
+	assert.Equal(t, "chargepoint-backend", name)
+
+	_, ok = keys.Match("wrong")
+	assert.False(t, ok)
+
+	_, ok = keys.Match("")
+	assert.False(t, ok)
+}
+
+func TestParseAPIKeys_Invalid(t *testing.T) {
+	_, err := ParseAPIKeys("missing-separator")
+	assert.Error(t, err)
+
+	keys, err := ParseAPIKeys("")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Defaults for token verification
+const (
+	DefaultLeeway      = 30 * time.Second
+	DefaultJWKSRefresh = time.Minute
+	DefaultTokenTTL    = 7 * 24 * time.Hour
+)
+
+// Config describes where verification keys come from and which claims are required
+type Config struct {
+	Issuer   string
+	Audience string
+	Leeway   time.Duration
+
+	// HMACSecret enables HS256 tokens signed with a shared secret
+	HMACSecret []byte
+	// RSAPublicKeyFile is a PEM file with the RS256 public key
+	RSAPublicKeyFile string
+	// JWKSFile is a local JWKS document, re-read when it changes to pick up rotated keys
+	JWKSFile    string
+	JWKSRefresh time.Duration
+
+	// TokenTTL is the lifetime of tokens issued by a Signer
+	TokenTTL time.Duration
+}
+
+// ConfigFromEnv reads the verifier configuration from JWT_* environment variables
+func ConfigFromEnv() Config {
+	return ConfigFromEnvPrefix("JWT_")
+}
+
+// ConfigFromEnvPrefix reads the verifier configuration from environment variables
+// with the given prefix, e.g. USER_JWT_SECRET for prefix "USER_JWT_"
+func ConfigFromEnvPrefix(prefix string) Config {
+	cfg := Config{
+		Issuer:           os.Getenv(prefix + "ISSUER"),
+		Audience:         os.Getenv(prefix + "AUDIENCE"),
+		RSAPublicKeyFile: os.Getenv(prefix + "PUBLIC_KEY_FILE"),
+		JWKSFile:         os.Getenv(prefix + "JWKS_FILE"),
+	}
+	if secret := os.Getenv(prefix + "SECRET"); secret != "" {
+		cfg.HMACSecret = []byte(secret)
+	}
+	if d, err := time.ParseDuration(os.Getenv(prefix + "LEEWAY")); err == nil {
+		cfg.Leeway = d
+	}
+	if d, err := time.ParseDuration(os.Getenv(prefix + "JWKS_REFRESH")); err == nil {
+		cfg.JWKSRefresh = d
+	}
+	if d, err := time.ParseDuration(os.Getenv(prefix + "TTL")); err == nil {
+		cfg.TokenTTL = d
+	}
+	return cfg
+}
+
+// keySet builds the key set described by the configuration
+func (c Config) keySet() (*KeySet, error) {
+	var static []*Key
+	if len(c.HMACSecret) > 0 {
+		static = append(static, &Key{Alg: AlgHS256, Secret: c.HMACSecret})
+	}
+	if c.RSAPublicKeyFile != "" {
+		data, err := os.ReadFile(c.RSAPublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read public key: %w", err)
+		}
+		pub, err := ParseRSAPublicKeyPEM(data)
+		if err != nil {
+			return nil, err
+		}
+		static = append(static, &Key{Alg: AlgRS256, PublicKey: pub})
+	}
+
+	refresh := c.JWKSRefresh
+	if refresh == 0 {
+		refresh = DefaultJWKSRefresh
+	}
+	ks, err := NewKeySet(static, c.JWKSFile, refresh)
+	if err != nil {
+		return nil, err
+	}
+	if ks.Len() == 0 {
+		return nil, ErrVerifierNotEnabled
+	}
+	return ks, nil
+}
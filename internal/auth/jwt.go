@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Errors returned by token verification
+var (
+	ErrMalformedToken     = errors.New("malformed token")
+	ErrUnsupportedAlg     = errors.New("unsupported signing algorithm")
+	ErrUnknownKey         = errors.New("no verification key for token")
+	ErrInvalidSignature   = errors.New("invalid token signature")
+	ErrTokenExpired       = errors.New("token expired")
+	ErrTokenNotYetValid   = errors.New("token not yet valid")
+	ErrInvalidIssuer      = errors.New("invalid token issuer")
+	ErrInvalidAudience    = errors.New("invalid token audience")
+	ErrMissingExpiry      = errors.New("token has no expiry")
+	ErrVerifierNotEnabled = errors.New("no verification keys configured")
+)
+
+// Supported signing algorithms
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+)
+
+// Audience is the JWT "aud" claim, which may be encoded as a string or an array of strings
+type Audience []string
+
+// UnmarshalJSON accepts both the string and array forms of "aud"
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// Contains reports whether aud is one of the token's audiences
+func (a Audience) Contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// Claims are the registered and application claims carried by a token
+type Claims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+
+	UserID string   `json:"user_id,omitempty"`
+	Email  string   `json:"email,omitempty"`
+	Role   string   `json:"role,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+}
+
+// UserIDOrSubject returns the user_id claim, falling back to sub
+func (c *Claims) UserIDOrSubject() string {
+	if c.UserID != "" {
+		return c.UserID
+	}
+	return c.Subject
+}
+
+// header is the JOSE header of a compact JWS
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// Verifier validates signed tokens and their registered claims
+type Verifier struct {
+	issuer   string
+	audience string
+	leeway   time.Duration
+	keys     *KeySet
+	now      func() time.Time
+}
+
+// NewVerifier creates a verifier from the given configuration
+func NewVerifier(cfg Config) (*Verifier, error) {
+	keys, err := cfg.keySet()
+	if err != nil {
+		return nil, err
+	}
+	leeway := cfg.Leeway
+	if leeway == 0 {
+		leeway = DefaultLeeway
+	}
+	return &Verifier{
+		issuer:   cfg.Issuer,
+		audience: cfg.Audience,
+		leeway:   leeway,
+		keys:     keys,
+		now:      time.Now,
+	}, nil
+}
+
+// Verify checks the token signature, expiry, issuer and audience and returns its claims
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+
+	var hdr header
+	if err := decodeSegment(parts[0], &hdr); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrMalformedToken, err)
+	}
+
+	key, err := v.keys.Lookup(hdr.Kid, hdr.Alg)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrMalformedToken, err)
+	}
+	if err := verifySignature(hdr.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrMalformedToken, err)
+	}
+	if err := v.validateClaims(&claims); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+// validateClaims checks the time-based and issuer/audience claims
+func (v *Verifier) validateClaims(c *Claims) error {
+	now := v.now()
+	if c.ExpiresAt == 0 {
+		return ErrMissingExpiry
+	}
+	if now.After(time.Unix(c.ExpiresAt, 0).Add(v.leeway)) {
+		return ErrTokenExpired
+	}
+	if c.NotBefore != 0 && now.Add(v.leeway).Before(time.Unix(c.NotBefore, 0)) {
+		return ErrTokenNotYetValid
+	}
+	if v.issuer != "" && c.Issuer != v.issuer {
+		return ErrInvalidIssuer
+	}
+	if v.audience != "" && !c.Audience.Contains(v.audience) {
+		return ErrInvalidAudience
+	}
+	return nil
+}
+
+// verifySignature checks the signature of signingInput with the given key
+func verifySignature(alg string, key *Key, signingInput string, signature []byte) error {
+	switch alg {
+	case AlgHS256:
+		mac := hmac.New(sha256.New, key.Secret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrInvalidSignature
+		}
+		return nil
+	case AlgRS256:
+		digest := sha256.Sum256([]byte(signingInput))
+		if err := rsa.VerifyPKCS1v15(key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+			return ErrInvalidSignature
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupportedAlg, alg)
+	}
+}
+
+// decodeSegment base64url-decodes a token segment into v
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signTestToken builds a compact JWS for the given header and claims
+func signTestToken(t *testing.T, hdr header, claims interface{}, hmacKey []byte, rsaKey *rsa.PrivateKey) string {
+	t.Helper()
+	h, err := json.Marshal(hdr)
+	require.NoError(t, err)
+	c, err := json.Marshal(claims)
+	require.NoError(t, err)
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+
+	var sig []byte
+	switch hdr.Alg {
+	case AlgHS256:
+		mac := hmac.New(sha256.New, hmacKey)
+		mac.Write([]byte(input))
+		sig = mac.Sum(nil)
+	case AlgRS256:
+		digest := sha256.Sum256([]byte(input))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func validClaims() Claims {
+	return Claims{
+		Issuer:    "urja-rewards",
+		Audience:  Audience{"admin"},
+		Subject:   "550e8400-e29b-41d4-a716-446655440000",
+		Email:     "admin@urja.com",
+		Role:      "product-admin",
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		IssuedAt:  time.Now().Unix(),
+	}
+}
+
+func newHS256Verifier(t *testing.T, secret string) *Verifier {
+	t.Helper()
+	v, err := NewVerifier(Config{
+		Issuer:     "urja-rewards",
+		Audience:   "admin",
+		HMACSecret: []byte(secret),
+	})
+	require.NoError(t, err)
+	return v
+}
+
+func TestVerify_HS256(t *testing.T) {
+	v := newHS256Verifier(t, "test-secret")
+	token := signTestToken(t, header{Alg: AlgHS256, Typ: "JWT"}, validClaims(), []byte("test-secret"), nil)
+
+	claims, err := v.Verify(token)
+	require.NoError(t, err)
+	assert.Equal(t, "550e8400-e29b-41d4-a716-446655440000", claims.UserIDOrSubject())
+	assert.Equal(t, "admin@urja.com", claims.Email)
+	assert.Equal(t, "product-admin", claims.Role)
+}
+
+func TestVerify_RejectsInvalidTokens(t *testing.T) {
+	v := newHS256Verifier(t, "test-secret")
+
+	expired := validClaims()
+	expired.ExpiresAt = time.Now().Add(-time.Hour).Unix()
+
+	wrongIssuer := validClaims()
+	wrongIssuer.Issuer = "someone-else"
+
+	wrongAudience := validClaims()
+	wrongAudience.Audience = Audience{"mobile-app"}
+
+	noExpiry := validClaims()
+	noExpiry.ExpiresAt = 0
+
+	notYetValid := validClaims()
+	notYetValid.NotBefore = time.Now().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"garbage", "not-a-token", ErrMalformedToken},
+		{"wrong secret", signTestToken(t, header{Alg: AlgHS256}, validClaims(), []byte("other"), nil), ErrInvalidSignature},
+		{"alg none", signTestToken(t, header{Alg: "none"}, validClaims(), nil, nil), ErrUnsupportedAlg},
+		{"expired", signTestToken(t, header{Alg: AlgHS256}, expired, []byte("test-secret"), nil), ErrTokenExpired},
+		{"wrong issuer", signTestToken(t, header{Alg: AlgHS256}, wrongIssuer, []byte("test-secret"), nil), ErrInvalidIssuer},
+		{"wrong audience", signTestToken(t, header{Alg: AlgHS256}, wrongAudience, []byte("test-secret"), nil), ErrInvalidAudience},
+		{"no expiry", signTestToken(t, header{Alg: AlgHS256}, noExpiry, []byte("test-secret"), nil), ErrMissingExpiry},
+		{"not yet valid", signTestToken(t, header{Alg: AlgHS256}, notYetValid, []byte("test-secret"), nil), ErrTokenNotYetValid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.Verify(tt.token)
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+}
+
+func TestVerify_RS256RejectsHS256Confusion(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	ks, err := NewKeySet([]*Key{{Alg: AlgRS256, PublicKey: &key.PublicKey}}, "", time.Minute)
+	require.NoError(t, err)
+	v := &Verifier{issuer: "urja-rewards", audience: "admin", leeway: DefaultLeeway, keys: ks, now: time.Now}
+
+	token := signTestToken(t, header{Alg: AlgRS256}, validClaims(), nil, key)
+	_, err = v.Verify(token)
+	require.NoError(t, err)
+
+	// An HS256 token must not be verified against the RSA public key
+	forged := signTestToken(t, header{Alg: AlgHS256}, validClaims(), key.PublicKey.N.Bytes(), nil)
+	_, err = v.Verify(forged)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestVerify_JWKSRotation(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, map[string]*rsa.PublicKey{"key-1": &oldKey.PublicKey})
+
+	v, err := NewVerifier(Config{Issuer: "urja-rewards", Audience: "admin", JWKSFile: path, JWKSRefresh: time.Hour})
+	require.NoError(t, err)
+
+	_, err = v.Verify(signTestToken(t, header{Alg: AlgRS256, Kid: "key-1"}, validClaims(), nil, oldKey))
+	require.NoError(t, err)
+
+	// Rotate: the new key replaces the old one in the JWKS file
+	writeJWKS(t, path, map[string]*rsa.PublicKey{"key-2": &newKey.PublicKey})
+	future := time.Now().Add(time.Second)
+	require.NoError(t, os.Chtimes(path, future, future))
+
+	_, err = v.Verify(signTestToken(t, header{Alg: AlgRS256, Kid: "key-2"}, validClaims(), nil, newKey))
+	require.NoError(t, err, "unknown kid should trigger a JWKS reload")
+
+	_, err = v.Verify(signTestToken(t, header{Alg: AlgRS256, Kid: "key-1"}, validClaims(), nil, oldKey))
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestNewVerifier_RequiresKeys(t *testing.T) {
+	_, err := NewVerifier(Config{Issuer: "urja-rewards"})
+	assert.ErrorIs(t, err, ErrVerifierNotEnabled)
+}
+
+func writeJWKS(t *testing.T, path string, keys map[string]*rsa.PublicKey) {
+	t.Helper()
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	for kid, pub := range keys {
+		doc.Keys = append(doc.Keys, jwk{
+			Kty: "RSA",
+			Kid: kid,
+			Alg: AlgRS256,
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString([]byte{1, 0, 1}),
+		})
+	}
+	data, err := json.Marshal(doc)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+}
@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+// Key is a single verification key
+type Key struct {
+	ID        string
+	Alg       string
+	Secret    []byte         // HS256
+	PublicKey *rsa.PublicKey // RS256
+}
+
+// supports reports whether the key can verify tokens signed with alg
+func (k *Key) supports(alg string) bool {
+	switch alg {
+	case AlgHS256:
+		return len(k.Secret) > 0
+	case AlgRS256:
+		return k.PublicKey != nil
+	default:
+		return false
+	}
+}
+
+// jwk is the JSON representation of a key in a JWKS document
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	K   string `json:"k,omitempty"`
+}
+
+// KeySet holds statically configured keys plus keys loaded from a JWKS file.
+// The JWKS file is re-read when it changes on disk, so keys can be rotated
+// by replacing the file without restarting the service.
+type KeySet struct {
+	static []*Key
+
+	jwksPath        string
+	refreshInterval time.Duration
+
+	mu        sync.RWMutex
+	fileKeys  []*Key
+	modTime   time.Time
+	lastCheck time.Time
+	now       func() time.Time
+}
+
+// NewKeySet creates a key set from static keys and an optional JWKS file path
+func NewKeySet(static []*Key, jwksPath string, refreshInterval time.Duration) (*KeySet, error) {
+	ks := &KeySet{
+		static:          static,
+		jwksPath:        jwksPath,
+		refreshInterval: refreshInterval,
+		now:             time.Now,
+	}
+	if jwksPath != "" {
+		if err := ks.reload(); err != nil {
+			return nil, err
+		}
+	}
+	return ks, nil
+}
+
+// Lookup returns the key to verify a token with the given kid and alg
+func (ks *KeySet) Lookup(kid, alg string) (*Key, error) {
+	if alg != AlgHS256 && alg != AlgRS256 {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedAlg, alg)
+	}
+
+	ks.maybeRefresh(false)
+	if key := ks.find(kid, alg); key != nil {
+		return key, nil
+	}
+
+	// An unknown kid usually means the keys were rotated; force a re-read once.
+	if kid != "" && ks.maybeRefresh(true) {
+		if key := ks.find(kid, alg); key != nil {
+			return key, nil
+		}
+	}
+	return nil, ErrUnknownKey
+}
+
+// Len returns the number of keys currently available
+func (ks *KeySet) Len() int {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return len(ks.static) + len(ks.fileKeys)
+}
+
+// find selects a key by kid, or the only key supporting alg when kid is empty
+func (ks *KeySet) find(kid, alg string) *Key {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	var candidates []*Key
+	for _, keys := range [][]*Key{ks.fileKeys, ks.static} {
+		for _, k := range keys {
+			if !k.supports(alg) {
+				continue
+			}
+			if kid != "" && k.ID == kid {
+				return k
+			}
+			candidates = append(candidates, k)
+		}
+	}
+	if kid == "" && len(candidates) == 1 {
+		return candidates[0]
+	}
+	return nil
+}
+
+// maybeRefresh re-reads the JWKS file if it changed, returning true if keys were reloaded
+func (ks *KeySet) maybeRefresh(force bool) bool {
+	if ks.jwksPath == "" {
+		return false
+	}
+
+	ks.mu.RLock()
+	due := ks.now().Sub(ks.lastCheck) >= ks.refreshInterval
+	modTime := ks.modTime
+	ks.mu.RUnlock()
+	if !due && !force {
+		return false
+	}
+
+	info, err := os.Stat(ks.jwksPath)
+	ks.mu.Lock()
+	ks.lastCheck = ks.now()
+	ks.mu.Unlock()
+	if err != nil || info.ModTime().Equal(modTime) {
+		return false
+	}
+
+	// Keep serving the previous keys if the new file is invalid
+	return ks.reload() == nil
+}
+
+// reload reads and parses the JWKS file
+func (ks *KeySet) reload() error {
+	info, err := os.Stat(ks.jwksPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat JWKS file: %w", err)
+	}
+	data, err := os.ReadFile(ks.jwksPath)
+	if err != nil {
+		return fmt.Errorf("failed to read JWKS file: %w", err)
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.fileKeys = keys
+	ks.modTime = info.ModTime()
+	ks.lastCheck = ks.now()
+	return nil
+}
+
+// ParseJWKS parses a JSON Web Key Set containing RSA and symmetric keys
+func ParseJWKS(data []byte) ([]*Key, error) {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+
+	keys := make([]*Key, 0, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			pub, err := rsaPublicKeyFromJWK(k.N, k.E)
+			if err != nil {
+				return nil, fmt.Errorf("invalid RSA key %q: %w", k.Kid, err)
+			}
+			keys = append(keys, &Key{ID: k.Kid, Alg: AlgRS256, PublicKey: pub})
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil {
+				return nil, fmt.Errorf("invalid symmetric key %q: %w", k.Kid, err)
+			}
+			keys = append(keys, &Key{ID: k.Kid, Alg: AlgHS256, Secret: secret})
+		}
+	}
+	return keys, nil
+}
+
+// rsaPublicKeyFromJWK decodes the base64url modulus and exponent of an RSA JWK
+func rsaPublicKeyFromJWK(n, e string) (*rsa.PublicKey, error) {
+	nb, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return nil, err
+	}
+	eb, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil {
+		return nil, err
+	}
+	exp := new(big.Int).SetBytes(eb)
+	if !exp.IsInt64() || exp.Int64() < 3 {
+		return nil, fmt.Errorf("invalid exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(nb), E: int(exp.Int64())}, nil
+}
+
+// ParseRSAPublicKeyPEM parses a PEM encoded PKIX or PKCS#1 RSA public key
+func ParseRSAPublicKeyPEM(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+	if pub, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return pub, nil
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	pub, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is not RSA")
+	}
+	return pub, nil
+}
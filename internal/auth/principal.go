@@ -0,0 +1,54 @@
+package auth
+
+import (
+	encauth "encore.dev/beta/auth"
+	"encore.dev/beta/errs"
+)
+
+// Principal kinds
+const (
+	PrincipalUser    = "user"
+	PrincipalService = "service"
+)
+
+// Principal is the auth data attached to requests by the Encore auth handler.
+// App calls authenticate as a user; backend integrations (e.g. the
+// charge-point backend) authenticate as a named service.
+type Principal struct {
+	Kind    string `json:"kind"`
+	UserID  string `json:"user_id,omitempty"`
+	Role    string `json:"role,omitempty"`
+	Service string `json:"service,omitempty"`
+}
+
+// CurrentPrincipal returns the principal of the current request, or nil
+func CurrentPrincipal() *Principal {
+	p, _ := encauth.Data().(*Principal)
+	return p
+}
+
+// RequireUser checks the request was made by an end user. If requestedUserID is
+// set it must match the authenticated user, so clients can't act on behalf of others.
+func RequireUser(p *Principal, requestedUserID string) (*Principal, error) {
+	if p == nil {
+		return nil, &errs.Error{Code: errs.Unauthenticated, Message: "authentication required"}
+	}
+	if p.Kind != PrincipalUser {
+		return nil, &errs.Error{Code: errs.PermissionDenied, Message: "user credentials required"}
+	}
+	if requestedUserID != "" && requestedUserID != p.UserID {
+		return nil, &errs.Error{Code: errs.PermissionDenied, Message: "cannot act on behalf of another user"}
+	}
+	return p, nil
+}
+
+// RequireService checks the request was made with a service credential
+func RequireService(p *Principal) (*Principal, error) {
+	if p == nil {
+		return nil, &errs.Error{Code: errs.Unauthenticated, Message: "authentication required"}
+	}
+	if p.Kind != PrincipalService {
+		return nil, &errs.Error{Code: errs.PermissionDenied, Message: "service credentials required"}
+	}
+	return p, nil
+}
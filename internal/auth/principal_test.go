@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"encore.dev/beta/errs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func errCode(t *testing.T, err error) errs.ErrCode {
+	t.Helper()
+	var e *errs.Error
+	require.True(t, errors.As(err, &e), "expected *errs.Error")
+	return e.Code
+}
+
+func TestRequireUser(t *testing.T) {
+	user := &Principal{Kind: PrincipalUser, UserID: "550e8400-e29b-41d4-a716-446655440000"}
+	service := &Principal{Kind: PrincipalService, Service: "chargepoint-backend"}
+
+	p, err := RequireUser(user, "")
+	require.NoError(t, err)
+	assert.Equal(t, user.UserID, p.UserID)
+
+	_, err = RequireUser(user, user.UserID)
+	assert.NoError(t, err)
+
+	_, err = RequireUser(user, "660e8400-e29b-41d4-a716-446655440000")
+	assert.Equal(t, errs.PermissionDenied, errCode(t, err))
+
+	_, err = RequireUser(service, "")
+	assert.Equal(t, errs.PermissionDenied, errCode(t, err))
+
+	_, err = RequireUser(nil, "")
+	assert.Equal(t, errs.Unauthenticated, errCode(t, err))
+}
+
+func TestRequireService(t *testing.T) {
+	_, err := RequireService(&Principal{Kind: PrincipalService, Service: "chargepoint-backend"})
+	assert.NoError(t, err)
+
+	_, err = RequireService(&Principal{Kind: PrincipalUser, UserID: "user-1"})
+	assert.Equal(t, errs.PermissionDenied, errCode(t, err))
+
+	_, err = RequireService(nil)
+	assert.Equal(t, errs.Unauthenticated, errCode(t, err))
+}
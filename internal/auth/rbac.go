@@ -0,0 +1,74 @@
+package auth
+
+// Permission is a single capability checked by admin endpoints
+type Permission string
+
+// Admin permissions
+const (
+	PermRulesRead          Permission = "rules:read"
+	PermRulesWrite         Permission = "rules:write"
+	PermRewardsRead        Permission = "rewards:read"
+	PermRewardsWrite       Permission = "rewards:write"
+	PermSegmentsRead       Permission = "segments:read"
+	PermSegmentsWrite      Permission = "segments:write"
+	PermAdjustmentsApprove Permission = "adjustments:approve"
+	PermUsersPII           Permission = "users:pii"
+	PermAuditRead          Permission = "audit:read"
+)
+
+// Admin roles
+const (
+	RoleProductAdmin = "product-admin"
+	RoleSupportAgent = "support-agent"
+	RoleFinance      = "finance"
+	RoleMarketing    = "marketing"
+)
+
+// AllPermissions lists every admin permission
+var AllPermissions = []Permission{
+	PermRulesRead,
+	PermRulesWrite,
+	PermRewardsRead,
+	PermRewardsWrite,
+	PermSegmentsRead,
+	PermSegmentsWrite,
+	PermAdjustmentsApprove,
+	PermUsersPII,
+	PermAuditRead,
+}
+
+// RolePermissions maps each role to the permissions it grants
+var RolePermissions = map[string][]Permission{
+	RoleProductAdmin: AllPermissions,
+	RoleSupportAgent: {
+		PermRulesRead,
+		PermRewardsRead,
+		PermSegmentsRead,
+		PermUsersPII,
+	},
+	RoleFinance: {
+		PermRulesRead,
+		PermRewardsRead,
+		PermAdjustmentsApprove,
+		PermAuditRead,
+	},
+	RoleMarketing: {
+		PermRulesRead,
+		PermRewardsRead,
+		PermRewardsWrite,
+		PermSegmentsRead,
+		PermSegmentsWrite,
+	},
+}
+
+// HasPermission reports whether any of the roles grants the permission
+func HasPermission(roles []string, p Permission) bool {
+	for _, role := range roles {
+		for _, granted := range RolePermissions[role] {
+			if granted == p {
+				return true
+			}
+		}
+	}
+	return false
+}
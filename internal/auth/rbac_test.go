@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHasPermission(t *testing.T) {
+	tests := []struct {
+		name  string
+		roles []string
+		perm  Permission
+		want  bool
+	}{
+		{"product-admin can write rules", []string{RoleProductAdmin}, PermRulesWrite, true},
+		{"product-admin can read PII", []string{RoleProductAdmin}, PermUsersPII, true},
+		{"support-agent can read PII", []string{RoleSupportAgent}, PermUsersPII, true},
+		{"support-agent cannot write rewards", []string{RoleSupportAgent}, PermRewardsWrite, false},
+		{"finance can approve adjustments", []string{RoleFinance}, PermAdjustmentsApprove, true},
+		{"finance can read the audit log", []string{RoleFinance}, PermAuditRead, true},
+		{"marketing cannot read the audit log", []string{RoleMarketing}, PermAuditRead, false},
+		{"finance cannot write rules", []string{RoleFinance}, PermRulesWrite, false},
+		{"marketing can write segments", []string{RoleMarketing}, PermSegmentsWrite, true},
+		{"marketing cannot approve adjustments", []string{RoleMarketing}, PermAdjustmentsApprove, false},
+		{"roles are combined", []string{RoleSupportAgent, RoleFinance}, PermAdjustmentsApprove, true},
+		{"unknown role has no permissions", []string{"user"}, PermRulesRead, false},
+		{"no roles", nil, PermRulesRead, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, HasPermission(tt.roles, tt.perm))
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrSignerNotEnabled is returned when no signing secret is configured
+var ErrSignerNotEnabled = errors.New("no signing secret configured")
+
+// Signer issues HS256 tokens that a Verifier with the same configuration accepts
+type Signer struct {
+	issuer   string
+	audience string
+	ttl      time.Duration
+	secret   []byte
+	now      func() time.Time
+}
+
+// NewSigner creates a signer from the given configuration, which must have an HMACSecret
+func NewSigner(cfg Config) (*Signer, error) {
+	if len(cfg.HMACSecret) == 0 {
+		return nil, ErrSignerNotEnabled
+	}
+	ttl := cfg.TokenTTL
+	if ttl == 0 {
+		ttl = DefaultTokenTTL
+	}
+	return &Signer{
+		issuer:   cfg.Issuer,
+		audience: cfg.Audience,
+		ttl:      ttl,
+		secret:   cfg.HMACSecret,
+		now:      time.Now,
+	}, nil
+}
+
+// IssueUserToken issues a token for an app user and returns it with its expiry
+func (s *Signer) IssueUserToken(userID string) (string, time.Time, error) {
+	now := s.now()
+	expires := now.Add(s.ttl)
+	claims := Claims{
+		Issuer:    s.issuer,
+		Subject:   userID,
+		ExpiresAt: expires.Unix(),
+		IssuedAt:  now.Unix(),
+		UserID:    userID,
+	}
+	if s.audience != "" {
+		claims.Audience = Audience{s.audience}
+	}
+	token, err := s.Sign(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expires, nil
+}
+
+// Sign encodes and signs claims as a compact JWS
+func (s *Signer) Sign(claims Claims) (string, error) {
+	hdr, err := json.Marshal(header{Alg: AlgHS256, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := base64.RawURLEncoding.EncodeToString(hdr) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(input))
+	return input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
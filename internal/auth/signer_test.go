@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigner_IssueUserToken(t *testing.T) {
+	cfg := Config{
+		Issuer:     "urja-rewards",
+		Audience:   "urja-app",
+		HMACSecret: []byte("user-secret"),
+		TokenTTL:   time.Hour,
+	}
+	s, err := NewSigner(cfg)
+	require.NoError(t, err)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	token, expires, err := s.IssueUserToken("550e8400-e29b-41d4-a716-446655440000")
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(time.Hour), expires)
+
+	v, err := NewVerifier(cfg)
+	require.NoError(t, err)
+	v.now = func() time.Time { return now.Add(30 * time.Minute) }
+
+	claims, err := v.Verify(token)
+	require.NoError(t, err)
+	assert.Equal(t, "550e8400-e29b-41d4-a716-446655440000", claims.UserIDOrSubject())
+	assert.Equal(t, now.Unix(), claims.IssuedAt)
+
+	v.now = func() time.Time { return now.Add(2 * time.Hour) }
+	_, err = v.Verify(token)
+	assert.ErrorIs(t, err, ErrTokenExpired)
+}
+
+func TestSigner_DefaultTTL(t *testing.T) {
+	s, err := NewSigner(Config{HMACSecret: []byte("user-secret")})
+	require.NoError(t, err)
+	_, expires, err := s.IssueUserToken("u1")
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(DefaultTokenTTL), expires, time.Minute)
+}
+
+func TestNewSigner_RequiresSecret(t *testing.T) {
+	_, err := NewSigner(Config{Issuer: "urja-rewards"})
+	assert.ErrorIs(t, err, ErrSignerNotEnabled)
+}
@@ -0,0 +1,150 @@
+// Package availability schedules when rewards can be redeemed.
+//
+// A reward can be limited to a window (available_from, available_until) and
+// to days of the week (available_days), both in UTC like daily stock. Outside
+// them the reward is hidden from the catalog and cannot be redeemed, so
+// seasonal rewards can be set up ahead of time and switch on and off by
+// themselves.
+package availability
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"encore.app/internal/db"
+)
+
+// Errors wrapped by *Error, one per reason
+var (
+	ErrNotYetAvailable   = errors.New("reward is not available yet")
+	ErrNoLongerAvailable = errors.New("reward is no longer available")
+	ErrNotAvailableToday = errors.New("reward is not available today")
+)
+
+// Reasons reported by *Error
+const (
+	ReasonNotYetAvailable   = "not_yet_available"
+	ReasonNoLongerAvailable = "no_longer_available"
+	ReasonNotAvailableToday = "not_available_today"
+)
+
+// DayNames are the names accepted for available_days, indexed by time.Weekday
+var DayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ErrNoDays is returned by ParseDays for an empty day list
+var ErrNoDays = errors.New("at least one day is required")
+
+// Error reports why a reward is unavailable. It unwraps to ErrNotYetAvailable,
+// ErrNoLongerAvailable or ErrNotAvailableToday.
+type Error struct {
+	// Reason is one of the Reason constants
+	Reason string
+	// AvailableAt is when the reward next becomes available; zero if never
+	AvailableAt time.Time
+}
+
+func (e *Error) Error() string {
+	return e.Unwrap().Error()
+}
+
+func (e *Error) Unwrap() error {
+	switch e.Reason {
+	case ReasonNotYetAvailable:
+		return ErrNotYetAvailable
+	case ReasonNoLongerAvailable:
+		return ErrNoLongerAvailable
+	}
+	return ErrNotAvailableToday
+}
+
+// ParseDays converts day names to an available_days mask
+func ParseDays(names []string) (int32, error) {
+	if len(names) == 0 {
+		return 0, ErrNoDays
+	}
+	var mask int32
+	for _, name := range names {
+		day := dayIndex(strings.ToLower(name))
+		if day < 0 {
+			return 0, fmt.Errorf("unknown day %q", name)
+		}
+		mask |= 1 << day
+	}
+	return mask, nil
+}
+
+// FormatDays converts an available_days mask to day names, Sunday first
+func FormatDays(mask int32) []string {
+	names := []string{}
+	for day, name := range DayNames {
+		if mask&(1<<day) != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// dayIndex returns the time.Weekday of a day name, or -1
+func dayIndex(name string) int {
+	for i, n := range DayNames {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Available reports whether a reward can be redeemed at now
+func Available(reward db.RewardsCatalog, now time.Time) bool {
+	if reward.AvailableFrom.Valid && now.Before(reward.AvailableFrom.Time) {
+		return false
+	}
+	if reward.AvailableUntil.Valid && !now.Before(reward.AvailableUntil.Time) {
+		return false
+	}
+	return onDay(reward, now)
+}
+
+// Check returns an *Error when a reward cannot be redeemed at now
+func Check(reward db.RewardsCatalog, now time.Time) error {
+	if Available(reward, now) {
+		return nil
+	}
+	switch {
+	case reward.AvailableUntil.Valid && !now.Before(reward.AvailableUntil.Time):
+		return &Error{Reason: ReasonNoLongerAvailable}
+	case reward.AvailableFrom.Valid && now.Before(reward.AvailableFrom.Time):
+		return &Error{Reason: ReasonNotYetAvailable, AvailableAt: Next(reward, now)}
+	}
+	return &Error{Reason: ReasonNotAvailableToday, AvailableAt: Next(reward, now)}
+}
+
+// Next returns the first time at or after now the reward is available, or
+// zero if it never will be again
+func Next(reward db.RewardsCatalog, now time.Time) time.Time {
+	t := now
+	if reward.AvailableFrom.Valid && t.Before(reward.AvailableFrom.Time) {
+		t = reward.AvailableFrom.Time
+	}
+	for i := 0; i < len(DayNames); i++ {
+		if reward.AvailableUntil.Valid && !t.Before(reward.AvailableUntil.Time) {
+			return time.Time{}
+		}
+		if onDay(reward, t) {
+			return t
+		}
+		y, m, d := t.UTC().Date()
+		t = time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Time{}
+}
+
+// onDay reports whether t falls on one of the reward's available UTC weekdays
+func onDay(reward db.RewardsCatalog, t time.Time) bool {
+	if !reward.AvailableDays.Valid {
+		return true
+	}
+	return reward.AvailableDays.Int32&(1<<int(t.UTC().Weekday())) != 0
+}
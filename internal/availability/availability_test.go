@@ -0,0 +1,111 @@
+package availability
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"encore.app/internal/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func at(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func validTime(s string) sql.NullTime {
+	return sql.NullTime{Time: at(s), Valid: true}
+}
+
+func days(names ...string) sql.NullInt32 {
+	mask, err := ParseDays(names)
+	if err != nil {
+		panic(err)
+	}
+	return sql.NullInt32{Int32: mask, Valid: true}
+}
+
+func TestParseDays(t *testing.T) {
+	mask, err := ParseDays([]string{"mon", "FRI", "sun"})
+	require.NoError(t, err)
+	assert.Equal(t, int32(1<<0|1<<1|1<<5), mask)
+	assert.Equal(t, []string{"sun", "mon", "fri"}, FormatDays(mask))
+
+	_, err = ParseDays(nil)
+	assert.ErrorIs(t, err, ErrNoDays)
+	_, err = ParseDays([]string{"funday"})
+	assert.Error(t, err)
+}
+
+func TestCheck(t *testing.T) {
+	// 2024-06-01 is a Saturday
+	now := at("2024-06-01T10:00:00Z")
+	tests := []struct {
+		name            string
+		reward          db.RewardsCatalog
+		wantReason      string
+		wantAvailableAt time.Time
+	}{
+		{name: "unscheduled", reward: db.RewardsCatalog{}},
+		{
+			name:   "inside window",
+			reward: db.RewardsCatalog{AvailableFrom: validTime("2024-05-01T00:00:00Z"), AvailableUntil: validTime("2024-07-01T00:00:00Z")},
+		},
+		{
+			name:            "before window",
+			reward:          db.RewardsCatalog{AvailableFrom: validTime("2024-06-10T09:00:00Z")},
+			wantReason:      ReasonNotYetAvailable,
+			wantAvailableAt: at("2024-06-10T09:00:00Z"),
+		},
+		{
+			name:            "window opens on an unavailable day",
+			reward:          db.RewardsCatalog{AvailableFrom: validTime("2024-06-08T09:00:00Z"), AvailableDays: days("mon")},
+			wantReason:      ReasonNotYetAvailable,
+			wantAvailableAt: at("2024-06-10T00:00:00Z"),
+		},
+		{
+			name:       "after window",
+			reward:     db.RewardsCatalog{AvailableUntil: validTime("2024-06-01T10:00:00Z")},
+			wantReason: ReasonNoLongerAvailable,
+		},
+		{name: "available day", reward: db.RewardsCatalog{AvailableDays: days("sat", "sun")}},
+		{
+			name:            "unavailable day",
+			reward:          db.RewardsCatalog{AvailableDays: days("tue", "wed")},
+			wantReason:      ReasonNotAvailableToday,
+			wantAvailableAt: at("2024-06-04T00:00:00Z"),
+		},
+		{
+			name:       "window closes before the next available day",
+			reward:     db.RewardsCatalog{AvailableDays: days("tue"), AvailableUntil: validTime("2024-06-03T00:00:00Z")},
+			wantReason: ReasonNotAvailableToday,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Check(tt.reward, now)
+			if tt.wantReason == "" {
+				assert.NoError(t, err)
+				assert.True(t, Available(tt.reward, now))
+				return
+			}
+			var e *Error
+			require.True(t, errors.As(err, &e))
+			assert.Equal(t, tt.wantReason, e.Reason)
+			assert.Equal(t, tt.wantAvailableAt, e.AvailableAt)
+			assert.False(t, Available(tt.reward, now))
+		})
+	}
+}
+
+func TestErrorUnwrap(t *testing.T) {
+	assert.ErrorIs(t, &Error{Reason: ReasonNotYetAvailable}, ErrNotYetAvailable)
+	assert.ErrorIs(t, &Error{Reason: ReasonNoLongerAvailable}, ErrNoLongerAvailable)
+	assert.ErrorIs(t, &Error{Reason: ReasonNotAvailableToday}, ErrNotAvailableToday)
+}
@@ -0,0 +1,122 @@
+// Package catalog validates rewards' catalog metadata and filters the catalog.
+//
+// A reward can have a category and tags, both lowercase slugs such as
+// "charging" or "weekend-deal", which the app filters and searches the
+// catalog by. Featured rewards are listed first, then rewards by sort order.
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"encore.app/internal/db"
+)
+
+// MaxTags caps the tags of one reward
+const MaxTags = 20
+
+// maxSlugLength caps the length of a category or tag
+const maxSlugLength = 40
+
+// slugPattern matches categories and tags
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// Normalize lowercases and trims a category or tag, checking it is a slug
+func Normalize(s string) (string, error) {
+	slug := strings.ToLower(strings.TrimSpace(s))
+	if len(slug) > maxSlugLength || !slugPattern.MatchString(slug) {
+		return "", fmt.Errorf("%q must be lowercase letters, digits and dashes, at most %d characters", s, maxSlugLength)
+	}
+	return slug, nil
+}
+
+// NormalizeTags normalizes a reward's tags and drops repeated ones, keeping
+// their order
+func NormalizeTags(tags []string) ([]string, error) {
+	out := make([]string, 0, len(tags))
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		slug, err := Normalize(tag)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[slug] {
+			seen[slug] = true
+			out = append(out, slug)
+		}
+	}
+	if len(out) > MaxTags {
+		return nil, fmt.Errorf("at most %d tags are allowed", MaxTags)
+	}
+	return out, nil
+}
+
+// Tags decodes a reward's tags column; a null column has no tags
+func Tags(raw []byte) []string {
+	var tags []string
+	if len(raw) > 0 {
+		_ = json.Unmarshal(raw, &tags)
+	}
+	return tags
+}
+
+// ValidateURL checks an image or partner URL is an absolute http or https URL
+func ValidateURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("must be an absolute http or https URL")
+	}
+	return nil
+}
+
+// Filter narrows the catalog. Empty fields do not filter.
+type Filter struct {
+	// Category keeps rewards in the category
+	Category string
+	// Tag keeps rewards with the tag
+	Tag string
+	// Query keeps rewards whose name, description, category, tags or partner
+	// contain it, ignoring case
+	Query string
+	// Featured keeps featured rewards only
+	Featured bool
+}
+
+// Matches reports whether a reward passes the filter
+func (f Filter) Matches(reward db.RewardsCatalog) bool {
+	if f.Featured && !reward.Featured {
+		return false
+	}
+	if f.Category != "" && !strings.EqualFold(reward.Category.String, f.Category) {
+		return false
+	}
+	tags := Tags(reward.Tags.RawMessage)
+	if f.Tag != "" && !containsFold(tags, f.Tag) {
+		return false
+	}
+	query := strings.ToLower(strings.TrimSpace(f.Query))
+	if query == "" {
+		return true
+	}
+	fields := append([]string{reward.Name, reward.Description.String, reward.Category.String, reward.PartnerName.String}, tags...)
+	for _, field := range fields {
+		if strings.Contains(strings.ToLower(field), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsFold reports whether tags contain tag, ignoring case
+func containsFold(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package catalog
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"encore.app/internal/db"
+	"github.com/sqlc-dev/pqtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	got, err := Normalize("  Weekend-Deal ")
+	require.NoError(t, err)
+	assert.Equal(t, "weekend-deal", got)
+
+	for _, s := range []string{"", "food & drink", "-charging", "charging-", "two--dashes", strings.Repeat("a", maxSlugLength+1)} {
+		_, err := Normalize(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	got, err := NormalizeTags([]string{"Weekend", "coffee", "weekend"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"weekend", "coffee"}, got)
+
+	_, err = NormalizeTags([]string{"coffee", "not a tag"})
+	assert.Error(t, err)
+
+	tooMany := make([]string, MaxTags+1)
+	for i := range tooMany {
+		tooMany[i] = strings.Repeat("a", i+1)
+	}
+	_, err = NormalizeTags(tooMany)
+	assert.Error(t, err)
+}
+
+func TestValidateURL(t *testing.T) {
+	assert.NoError(t, ValidateURL("https://cdn.urja.example.com/rewards/coffee.png"))
+	for _, s := range []string{"cdn.urja.example.com/coffee.png", "ftp://example.com/coffee.png", "https://", "javascript:alert(1)"} {
+		assert.Error(t, ValidateURL(s), s)
+	}
+}
+
+func TestFilterMatches(t *testing.T) {
+	tags, err := json.Marshal([]string{"weekend", "partner-deal"})
+	require.NoError(t, err)
+	coffee := db.RewardsCatalog{
+		Name:        "Free Coffee",
+		Description: sql.NullString{String: "Any size at partner cafes", Valid: true},
+		Category:    sql.NullString{String: "food", Valid: true},
+		Tags:        pqtype.NullRawMessage{RawMessage: tags, Valid: true},
+		PartnerName: sql.NullString{String: "Blue Tokai", Valid: true},
+		Featured:    true,
+	}
+	session := db.RewardsCatalog{Name: "Free Charging Session", Category: sql.NullString{String: "charging", Valid: true}}
+
+	tests := []struct {
+		name    string
+		filter  Filter
+		coffee  bool
+		session bool
+	}{
+		{"no filter", Filter{}, true, true},
+		{"category", Filter{Category: "Food"}, true, false},
+		{"tag", Filter{Tag: "weekend"}, true, false},
+		{"unknown tag", Filter{Tag: "weekday"}, false, false},
+		{"featured", Filter{Featured: true}, true, false},
+		{"search name", Filter{Query: "free"}, true, true},
+		{"search description", Filter{Query: "CAFES"}, true, false},
+		{"search tag", Filter{Query: "partner-deal"}, true, false},
+		{"search partner", Filter{Query: "tokai"}, true, false},
+		{"search category", Filter{Query: "charg"}, false, true},
+		{"combined", Filter{Category: "charging", Query: "coffee"}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.coffee, tt.filter.Matches(coffee))
+			assert.Equal(t, tt.session, tt.filter.Matches(session))
+		})
+	}
+}
@@ -0,0 +1,242 @@
+// Package credits manages charging credits.
+//
+// Redeeming a charging_credit reward issues a monetary credit (in paise) that
+// the charge-point backend applies when billing the user's next sessions.
+// Credits are consumed soonest-to-expire first and may be used across several
+// sessions. A credit that expires with a balance left refunds the points paid
+// for that share of it.
+package credits
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"encore.app/internal/db"
+	"github.com/google/uuid"
+)
+
+// Reward types
+const (
+	RewardTypeStandard = "standard"
+	RewardTypeCredit   = "charging_credit"
+)
+
+// Credit statuses
+const (
+	StatusActive   = "ACTIVE"
+	StatusConsumed = "CONSUMED"
+	StatusExpired  = "EXPIRED"
+)
+
+// RefundEventType is the points event type of expiry refunds
+const RefundEventType = "CREDIT_REFUND"
+
+// DefaultValidity is how long a credit lasts when its reward sets no
+// credit_valid_days
+const DefaultValidity = 30 * 24 * time.Hour
+
+// Errors returned by the package
+var (
+	ErrInvalidRewardType = errors.New("reward_type must be standard or charging_credit")
+	ErrNoCreditAmount    = errors.New("charging_credit rewards need a positive credit_amount")
+	ErrInvalidAmount     = errors.New("amount must be positive")
+	ErrSessionRequired   = errors.New("session_id is required")
+)
+
+// ValidateReward checks a reward's type and credit settings
+func ValidateReward(rewardType string, creditAmount sql.NullInt64) error {
+	switch rewardType {
+	case "", RewardTypeStandard:
+		return nil
+	case RewardTypeCredit:
+		if !creditAmount.Valid || creditAmount.Int64 <= 0 {
+			return ErrNoCreditAmount
+		}
+		return nil
+	}
+	return ErrInvalidRewardType
+}
+
+// IsCredit reports whether redeeming reward issues a charging credit
+func IsCredit(reward db.RewardsCatalog) bool {
+	return reward.RewardType == RewardTypeCredit
+}
+
+// Validity returns how long credits issued by reward last
+func Validity(reward db.RewardsCatalog) time.Duration {
+	if reward.CreditValidDays.Valid && reward.CreditValidDays.Int32 > 0 {
+		return time.Duration(reward.CreditValidDays.Int32) * 24 * time.Hour
+	}
+	return DefaultValidity
+}
+
+// Issuer is the subset of *db.Queries used to issue credits
+type Issuer interface {
+	CreateChargingCredit(ctx context.Context, arg db.CreateChargingCreditParams) (db.ChargingCredit, error)
+}
+
+// Issue creates the credit for a redemption of a charging_credit reward. Call
+// it in the redemption's transaction.
+func Issue(ctx context.Context, q Issuer, reward db.RewardsCatalog, redemption db.Redemption, now time.Time) (db.ChargingCredit, error) {
+	if err := ValidateReward(reward.RewardType, reward.CreditAmount); err != nil {
+		return db.ChargingCredit{}, err
+	}
+	return q.CreateChargingCredit(ctx, db.CreateChargingCreditParams{
+		UserID:       redemption.UserID,
+		RedemptionID: redemption.ID,
+		Amount:       reward.CreditAmount.Int64,
+		PointsSpent:  redemption.PointsSpent,
+		ExpiresAt:    now.Add(Validity(reward)),
+	})
+}
+
+// Balance returns the credit left across credits
+func Balance(credits []db.ChargingCredit) int64 {
+	var total int64
+	for _, c := range credits {
+		total += c.Remaining
+	}
+	return total
+}
+
+// Consumer is the subset of *db.Queries used to consume credits
+type Consumer interface {
+	LockUserChargingCredits(ctx context.Context, arg db.LockUserChargingCreditsParams) ([]db.ChargingCredit, error)
+	ListSessionCreditConsumptions(ctx context.Context, arg db.ListSessionCreditConsumptionsParams) ([]db.CreditConsumption, error)
+	ConsumeChargingCredit(ctx context.Context, arg db.ConsumeChargingCreditParams) (db.ChargingCredit, error)
+	CreateCreditConsumption(ctx context.Context, arg db.CreateCreditConsumptionParams) (db.CreditConsumption, error)
+}
+
+// Consumption is the credit applied to a charging session
+type Consumption struct {
+	// Applied is the credit applied, at most the amount requested
+	Applied int64
+	// Consumptions lists the credits the amount was taken from
+	Consumptions []db.CreditConsumption
+	// Balance is the user's credit left afterwards
+	Balance int64
+	// Replayed is set when the session had already consumed credit and
+	// nothing more was taken
+	Replayed bool
+}
+
+// Consume applies up to amount of the user's credit to a session, taking it
+// from the credits that expire first. Consuming for a session that already
+// consumed credit returns the earlier consumption unchanged, so the backend
+// can retry safely. Call it in a transaction.
+func Consume(ctx context.Context, q Consumer, userID uuid.UUID, sessionID string, amount int64, now time.Time) (Consumption, error) {
+	if sessionID == "" {
+		return Consumption{}, ErrSessionRequired
+	}
+	if amount <= 0 {
+		return Consumption{}, ErrInvalidAmount
+	}
+
+	// Lock the credits first so a retry of the same session waits for the
+	// first attempt and then sees its consumptions
+	credits, err := q.LockUserChargingCredits(ctx, db.LockUserChargingCreditsParams{UserID: userID, Now: now})
+	if err != nil {
+		return Consumption{}, err
+	}
+	previous, err := q.ListSessionCreditConsumptions(ctx, db.ListSessionCreditConsumptionsParams{
+		UserID:    userID,
+		SessionID: sessionID,
+	})
+	if err != nil {
+		return Consumption{}, err
+	}
+	if len(previous) > 0 {
+		result := Consumption{Consumptions: previous, Balance: Balance(credits), Replayed: true}
+		for _, c := range previous {
+			result.Applied += c.Amount
+		}
+		return result, nil
+	}
+
+	result := Consumption{Consumptions: []db.CreditConsumption{}}
+	left := amount
+	for _, credit := range credits {
+		if left == 0 {
+			break
+		}
+		take := credit.Remaining
+		if take > left {
+			take = left
+		}
+		if take == 0 {
+			continue
+		}
+		if _, err := q.ConsumeChargingCredit(ctx, db.ConsumeChargingCreditParams{ID: credit.ID, Amount: take}); err != nil {
+			return Consumption{}, err
+		}
+		consumption, err := q.CreateCreditConsumption(ctx, db.CreateCreditConsumptionParams{
+			CreditID:  credit.ID,
+			UserID:    userID,
+			SessionID: sessionID,
+			Amount:    take,
+		})
+		if err != nil {
+			return Consumption{}, err
+		}
+		result.Consumptions = append(result.Consumptions, consumption)
+		result.Applied += take
+		left -= take
+	}
+	result.Balance = Balance(credits) - result.Applied
+	return result, nil
+}
+
+// RefundPoints returns the points refunded when credit expires: the share of
+// the points paid matching the share of the credit left unused
+func RefundPoints(credit db.ChargingCredit) int32 {
+	if credit.Amount <= 0 || credit.Remaining <= 0 {
+		return 0
+	}
+	return int32(int64(credit.PointsSpent) * credit.Remaining / credit.Amount)
+}
+
+// Expirer is the subset of *db.Queries used to expire credits
+type Expirer interface {
+	ListDueChargingCredits(ctx context.Context, arg db.ListDueChargingCreditsParams) ([]db.ChargingCredit, error)
+	ExpireChargingCredit(ctx context.Context, arg db.ExpireChargingCreditParams) (db.ChargingCredit, error)
+	CreatePointsEvent(ctx context.Context, arg db.CreatePointsEventParams) (db.PointsEvent, error)
+}
+
+// ExpireResult summarizes an expiry batch
+type ExpireResult struct {
+	Expired        int
+	RefundedPoints int64
+}
+
+// ExpireBatch expires up to batchSize credits past their expiry and refunds
+// the points for their unused balance. Call it in a transaction; credits
+// claimed by a concurrent batch are skipped.
+func ExpireBatch(ctx context.Context, q Expirer, now time.Time, batchSize int32) (ExpireResult, error) {
+	due, err := q.ListDueChargingCredits(ctx, db.ListDueChargingCreditsParams{Now: now, BatchSize: batchSize})
+	if err != nil {
+		return ExpireResult{}, err
+	}
+	var result ExpireResult
+	for _, credit := range due {
+		refund := RefundPoints(credit)
+		if _, err := q.ExpireChargingCredit(ctx, db.ExpireChargingCreditParams{ID: credit.ID, RefundedPoints: refund}); err != nil {
+			return ExpireResult{}, err
+		}
+		if refund > 0 {
+			_, err := q.CreatePointsEvent(ctx, db.CreatePointsEventParams{
+				UserID:    credit.UserID,
+				EventType: RefundEventType,
+				RefID:     sql.NullString{String: credit.ID.String(), Valid: true},
+				Points:    refund,
+			})
+			if err != nil {
+				return ExpireResult{}, err
+			}
+		}
+		result.Expired++
+		result.RefundedPoints += int64(refund)
+	}
+	return result, nil
+}
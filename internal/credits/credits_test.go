@@ -0,0 +1,232 @@
+package credits
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"testing"
+	"time"
+
+	"encore.app/internal/db"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStore keeps credits, consumptions and points events in memory
+type fakeStore struct {
+	credits      []db.ChargingCredit
+	consumptions []db.CreditConsumption
+	events       []db.CreatePointsEventParams
+}
+
+func (f *fakeStore) CreateChargingCredit(ctx context.Context, arg db.CreateChargingCreditParams) (db.ChargingCredit, error) {
+	c := db.ChargingCredit{
+		ID:           uuid.New(),
+		UserID:       arg.UserID,
+		RedemptionID: arg.RedemptionID,
+		Amount:       arg.Amount,
+		Remaining:    arg.Amount,
+		Currency:     "INR",
+		PointsSpent:  arg.PointsSpent,
+		Status:       StatusActive,
+		ExpiresAt:    arg.ExpiresAt,
+	}
+	f.credits = append(f.credits, c)
+	return c, nil
+}
+
+func (f *fakeStore) LockUserChargingCredits(ctx context.Context, arg db.LockUserChargingCreditsParams) ([]db.ChargingCredit, error) {
+	var usable []db.ChargingCredit
+	for _, c := range f.credits {
+		if c.UserID == arg.UserID && c.Status == StatusActive && c.ExpiresAt.After(arg.Now) {
+			usable = append(usable, c)
+		}
+	}
+	sort.Slice(usable, func(i, j int) bool { return usable[i].ExpiresAt.Before(usable[j].ExpiresAt) })
+	return usable, nil
+}
+
+func (f *fakeStore) ListSessionCreditConsumptions(ctx context.Context, arg db.ListSessionCreditConsumptionsParams) ([]db.CreditConsumption, error) {
+	var found []db.CreditConsumption
+	for _, c := range f.consumptions {
+		if c.UserID == arg.UserID && c.SessionID == arg.SessionID {
+			found = append(found, c)
+		}
+	}
+	return found, nil
+}
+
+func (f *fakeStore) ConsumeChargingCredit(ctx context.Context, arg db.ConsumeChargingCreditParams) (db.ChargingCredit, error) {
+	for i := range f.credits {
+		c := &f.credits[i]
+		if c.ID == arg.ID && c.Status == StatusActive && c.Remaining >= arg.Amount {
+			c.Remaining -= arg.Amount
+			if c.Remaining == 0 {
+				c.Status = StatusConsumed
+			}
+			return *c, nil
+		}
+	}
+	return db.ChargingCredit{}, sql.ErrNoRows
+}
+
+func (f *fakeStore) CreateCreditConsumption(ctx context.Context, arg db.CreateCreditConsumptionParams) (db.CreditConsumption, error) {
+	c := db.CreditConsumption{ID: uuid.New(), CreditID: arg.CreditID, UserID: arg.UserID, SessionID: arg.SessionID, Amount: arg.Amount}
+	f.consumptions = append(f.consumptions, c)
+	return c, nil
+}
+
+func (f *fakeStore) ListDueChargingCredits(ctx context.Context, arg db.ListDueChargingCreditsParams) ([]db.ChargingCredit, error) {
+	var due []db.ChargingCredit
+	for _, c := range f.credits {
+		if c.Status == StatusActive && !c.ExpiresAt.After(arg.Now) && int32(len(due)) < arg.BatchSize {
+			due = append(due, c)
+		}
+	}
+	return due, nil
+}
+
+func (f *fakeStore) ExpireChargingCredit(ctx context.Context, arg db.ExpireChargingCreditParams) (db.ChargingCredit, error) {
+	for i := range f.credits {
+		c := &f.credits[i]
+		if c.ID == arg.ID && c.Status == StatusActive {
+			c.Status = StatusExpired
+			c.RefundedPoints = arg.RefundedPoints
+			return *c, nil
+		}
+	}
+	return db.ChargingCredit{}, sql.ErrNoRows
+}
+
+func (f *fakeStore) CreatePointsEvent(ctx context.Context, arg db.CreatePointsEventParams) (db.PointsEvent, error) {
+	f.events = append(f.events, arg)
+	return db.PointsEvent{ID: uuid.New(), UserID: arg.UserID, EventType: arg.EventType, Points: arg.Points}, nil
+}
+
+func creditReward(amount int64, validDays int32) db.RewardsCatalog {
+	r := db.RewardsCatalog{
+		ID:           uuid.New(),
+		Name:         "₹100 off your next charge",
+		Cost:         1000,
+		Active:       true,
+		RewardType:   RewardTypeCredit,
+		CreditAmount: sql.NullInt64{Int64: amount, Valid: true},
+	}
+	if validDays > 0 {
+		r.CreditValidDays = sql.NullInt32{Int32: validDays, Valid: true}
+	}
+	return r
+}
+
+func TestIssue(t *testing.T) {
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	q := &fakeStore{}
+	redemption := db.Redemption{ID: uuid.New(), UserID: uuid.New(), PointsSpent: 1000}
+
+	credit, err := Issue(context.Background(), q, creditReward(10000, 7), redemption, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10000), credit.Remaining)
+	assert.Equal(t, int32(1000), credit.PointsSpent)
+	assert.Equal(t, now.Add(7*24*time.Hour), credit.ExpiresAt)
+
+	// Rewards without credit_valid_days use the default validity
+	credit, err = Issue(context.Background(), q, creditReward(10000, 0), redemption, now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(DefaultValidity), credit.ExpiresAt)
+
+	_, err = Issue(context.Background(), q, creditReward(0, 0), redemption, now)
+	assert.ErrorIs(t, err, ErrNoCreditAmount)
+}
+
+func TestConsume(t *testing.T) {
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	userID := uuid.New()
+	q := &fakeStore{credits: []db.ChargingCredit{
+		{ID: uuid.New(), UserID: userID, Amount: 5000, Remaining: 5000, PointsSpent: 500, Status: StatusActive, ExpiresAt: now.Add(48 * time.Hour)},
+		{ID: uuid.New(), UserID: userID, Amount: 10000, Remaining: 10000, PointsSpent: 1000, Status: StatusActive, ExpiresAt: now.Add(24 * time.Hour)},
+		// Expired credits are never applied
+		{ID: uuid.New(), UserID: userID, Amount: 9000, Remaining: 9000, PointsSpent: 900, Status: StatusActive, ExpiresAt: now.Add(-time.Hour)},
+	}}
+
+	// The credit expiring first is used first, then the next one
+	result, err := Consume(context.Background(), q, userID, "session-1", 12000, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(12000), result.Applied)
+	assert.Equal(t, int64(3000), result.Balance)
+	require.Len(t, result.Consumptions, 2)
+	assert.Equal(t, q.credits[1].ID, result.Consumptions[0].CreditID)
+	assert.Equal(t, int64(10000), result.Consumptions[0].Amount)
+	assert.Equal(t, StatusConsumed, q.credits[1].Status)
+	assert.Equal(t, int64(3000), q.credits[0].Remaining)
+
+	// Retrying the session returns the same consumption
+	result, err = Consume(context.Background(), q, userID, "session-1", 12000, now)
+	require.NoError(t, err)
+	assert.True(t, result.Replayed)
+	assert.Equal(t, int64(12000), result.Applied)
+	assert.Equal(t, int64(3000), q.credits[0].Remaining)
+
+	// A bill larger than the balance is only partly covered
+	result, err = Consume(context.Background(), q, userID, "session-2", 8000, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3000), result.Applied)
+	assert.Zero(t, result.Balance)
+
+	result, err = Consume(context.Background(), q, userID, "session-3", 8000, now)
+	require.NoError(t, err)
+	assert.Zero(t, result.Applied)
+	assert.Empty(t, result.Consumptions)
+
+	_, err = Consume(context.Background(), q, userID, "", 100, now)
+	assert.ErrorIs(t, err, ErrSessionRequired)
+	_, err = Consume(context.Background(), q, userID, "session-4", 0, now)
+	assert.ErrorIs(t, err, ErrInvalidAmount)
+}
+
+func TestRefundPoints(t *testing.T) {
+	tests := []struct {
+		name      string
+		remaining int64
+		want      int32
+	}{
+		{"unused", 10000, 1000},
+		{"partly used", 2500, 250},
+		{"rounds down", 3333, 333},
+		{"fully used", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			credit := db.ChargingCredit{Amount: 10000, Remaining: tt.remaining, PointsSpent: 1000}
+			assert.Equal(t, tt.want, RefundPoints(credit))
+		})
+	}
+}
+
+func TestExpireBatch(t *testing.T) {
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	userID := uuid.New()
+	q := &fakeStore{credits: []db.ChargingCredit{
+		{ID: uuid.New(), UserID: userID, Amount: 10000, Remaining: 10000, PointsSpent: 1000, Status: StatusActive, ExpiresAt: now.Add(-time.Hour)},
+		{ID: uuid.New(), UserID: userID, Amount: 10000, Remaining: 4000, PointsSpent: 1000, Status: StatusActive, ExpiresAt: now.Add(-time.Minute)},
+		{ID: uuid.New(), UserID: userID, Amount: 10000, Remaining: 10000, PointsSpent: 1000, Status: StatusActive, ExpiresAt: now.Add(time.Hour)},
+	}}
+
+	result, err := ExpireBatch(context.Background(), q, now, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Expired)
+	assert.Equal(t, int64(1400), result.RefundedPoints)
+	assert.Equal(t, StatusExpired, q.credits[0].Status)
+	assert.Equal(t, int32(400), q.credits[1].RefundedPoints)
+	assert.Equal(t, StatusActive, q.credits[2].Status)
+
+	require.Len(t, q.events, 2)
+	assert.Equal(t, RefundEventType, q.events[0].EventType)
+	assert.Equal(t, q.credits[0].ID.String(), q.events[0].RefID.String)
+	assert.Equal(t, int32(1000), q.events[0].Points)
+
+	// Nothing is left to expire
+	result, err = ExpireBatch(context.Background(), q, now, 10)
+	require.NoError(t, err)
+	assert.Zero(t, result.Expired)
+}
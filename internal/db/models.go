@@ -13,6 +13,91 @@ import (
 	"github.com/sqlc-dev/pqtype"
 )
 
+type AdminAuditLog struct {
+	ID         uuid.UUID             `json:"id"`
+	ActorID    uuid.NullUUID         `json:"actor_id"`
+	ActorEmail sql.NullString        `json:"actor_email"`
+	Action     string                `json:"action"`
+	EntityType string                `json:"entity_type"`
+	EntityID   uuid.NullUUID         `json:"entity_id"`
+	Before     pqtype.NullRawMessage `json:"before"`
+	After      pqtype.NullRawMessage `json:"after"`
+	Diff       pqtype.NullRawMessage `json:"diff"`
+	RequestID  sql.NullString        `json:"request_id"`
+	Ip         sql.NullString        `json:"ip"`
+	CreatedAt  time.Time             `json:"created_at"`
+}
+
+type ChargingCredit struct {
+	ID             uuid.UUID `json:"id"`
+	UserID         uuid.UUID `json:"user_id"`
+	RedemptionID   uuid.UUID `json:"redemption_id"`
+	Amount         int64     `json:"amount"`
+	Remaining      int64     `json:"remaining"`
+	Currency       string    `json:"currency"`
+	PointsSpent    int32     `json:"points_spent"`
+	Status         string    `json:"status"`
+	RefundedPoints int32     `json:"refunded_points"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+type Checkout struct {
+	ID          uuid.UUID `json:"id"`
+	UserID      uuid.UUID `json:"user_id"`
+	PointsSpent int32     `json:"points_spent"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type CreditConsumption struct {
+	ID        uuid.UUID `json:"id"`
+	CreditID  uuid.UUID `json:"credit_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	SessionID string    `json:"session_id"`
+	Amount    int64     `json:"amount"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type EventOutbox struct {
+	ID            int64           `json:"id"`
+	Topic         string          `json:"topic"`
+	OrderingKey   string          `json:"ordering_key"`
+	Payload       json.RawMessage `json:"payload"`
+	Attempts      int32           `json:"attempts"`
+	LastError     sql.NullString  `json:"last_error"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	CreatedAt     time.Time       `json:"created_at"`
+	PublishedAt   sql.NullTime    `json:"published_at"`
+	DeadAt        sql.NullTime    `json:"dead_at"`
+}
+
+type FulfillmentDelivery struct {
+	ID             uuid.UUID       `json:"id"`
+	RedemptionID   uuid.UUID       `json:"redemption_id"`
+	RewardID       uuid.UUID       `json:"reward_id"`
+	Adapter        string          `json:"adapter"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int32           `json:"attempts"`
+	LastError      sql.NullString  `json:"last_error"`
+	ResponseStatus sql.NullInt32   `json:"response_status"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LockedUntil    sql.NullTime    `json:"locked_until"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+type OtpChallenge struct {
+	ID         uuid.UUID    `json:"id"`
+	Phone      string       `json:"phone"`
+	CodeHash   string       `json:"code_hash"`
+	Attempts   int32        `json:"attempts"`
+	ExpiresAt  time.Time    `json:"expires_at"`
+	VerifiedAt sql.NullTime `json:"verified_at"`
+	CreatedAt  time.Time    `json:"created_at"`
+}
+
 type PointsEvent struct {
 	ID         uuid.UUID             `json:"id"`
 	UserID     uuid.UUID             `json:"user_id"`
@@ -23,25 +108,109 @@ type PointsEvent struct {
 	OccurredAt time.Time             `json:"occurred_at"`
 }
 
+type PriceQuote struct {
+	ID        uuid.UUID       `json:"id"`
+	UserID    uuid.UUID       `json:"user_id"`
+	Prices    json.RawMessage `json:"prices"`
+	ExpiresAt time.Time       `json:"expires_at"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
 type Redemption struct {
-	ID          uuid.UUID `json:"id"`
-	UserID      uuid.UUID `json:"user_id"`
-	RewardID    uuid.UUID `json:"reward_id"`
-	PointsSpent int32     `json:"points_spent"`
-	Status      string    `json:"status"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID          uuid.UUID      `json:"id"`
+	UserID      uuid.UUID      `json:"user_id"`
+	RewardID    uuid.UUID      `json:"reward_id"`
+	PointsSpent int32          `json:"points_spent"`
+	Status      string         `json:"status"`
+	VoucherCode sql.NullString `json:"voucher_code"`
+	ExpiresAt   time.Time      `json:"expires_at"`
+	BaseCost    sql.NullInt32  `json:"base_cost"`
+	PriceRule   sql.NullString `json:"price_rule"`
+	CheckoutID  uuid.NullUUID  `json:"checkout_id"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+}
+
+type RedemptionPayment struct {
+	ID            uuid.UUID      `json:"id"`
+	RedemptionID  uuid.UUID      `json:"redemption_id"`
+	Provider      string         `json:"provider"`
+	IntentID      sql.NullString `json:"intent_id"`
+	Amount        int64          `json:"amount"`
+	Currency      string         `json:"currency"`
+	Status        string         `json:"status"`
+	FailureReason sql.NullString `json:"failure_reason"`
+	ExpiresAt     time.Time      `json:"expires_at"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+}
+
+type RedemptionStatusEvent struct {
+	ID           uuid.UUID `json:"id"`
+	RedemptionID uuid.UUID `json:"redemption_id"`
+	Status       string    `json:"status"`
+	OccurredAt   time.Time `json:"occurred_at"`
+}
+
+type RewardCode struct {
+	ID           uuid.UUID     `json:"id"`
+	RewardID     uuid.UUID     `json:"reward_id"`
+	Code         string        `json:"code"`
+	RedemptionID uuid.NullUUID `json:"redemption_id"`
+	ClaimedAt    sql.NullTime  `json:"claimed_at"`
+	VoidedAt     sql.NullTime  `json:"voided_at"`
+	CreatedAt    time.Time     `json:"created_at"`
+}
+
+type RewardDailyRedemption struct {
+	RewardID uuid.UUID `json:"reward_id"`
+	Day      time.Time `json:"day"`
+	Redeemed int32     `json:"redeemed"`
 }
 
 type RewardsCatalog struct {
-	ID          uuid.UUID             `json:"id"`
-	Name        string                `json:"name"`
-	Description sql.NullString        `json:"description"`
-	Cost        int32                 `json:"cost"`
-	Segment     pqtype.NullRawMessage `json:"segment"`
-	Active      bool                  `json:"active"`
-	CreatedBy   uuid.NullUUID         `json:"created_by"`
-	CreatedAt   time.Time             `json:"created_at"`
+	ID                 uuid.UUID             `json:"id"`
+	Name               string                `json:"name"`
+	Description        sql.NullString        `json:"description"`
+	Cost               int32                 `json:"cost"`
+	Segment            pqtype.NullRawMessage `json:"segment"`
+	MinTier            sql.NullString        `json:"min_tier"`
+	RewardType         string                `json:"reward_type"`
+	CreditAmount       sql.NullInt64         `json:"credit_amount"`
+	CreditValidDays    sql.NullInt32         `json:"credit_valid_days"`
+	StockTotal         sql.NullInt32         `json:"stock_total"`
+	StockRedeemed      int32                 `json:"stock_redeemed"`
+	DailyStock         sql.NullInt32         `json:"daily_stock"`
+	MaxPerUser         sql.NullInt32         `json:"max_per_user"`
+	MaxPerUserMonthly  sql.NullInt32         `json:"max_per_user_monthly"`
+	CooldownSeconds    sql.NullInt32         `json:"cooldown_seconds"`
+	PendingTtlSeconds  sql.NullInt32         `json:"pending_ttl_seconds"`
+	AvailableFrom      sql.NullTime          `json:"available_from"`
+	AvailableUntil     sql.NullTime          `json:"available_until"`
+	AvailableDays      sql.NullInt32         `json:"available_days"`
+	MinPoints          sql.NullInt32         `json:"min_points"`
+	CashPrice          sql.NullInt64         `json:"cash_price"`
+	PriceRules         pqtype.NullRawMessage `json:"price_rules"`
+	Category           sql.NullString        `json:"category"`
+	Tags               pqtype.NullRawMessage `json:"tags"`
+	ImageUrl           sql.NullString        `json:"image_url"`
+	Terms              sql.NullString        `json:"terms"`
+	PartnerName        sql.NullString        `json:"partner_name"`
+	PartnerUrl         sql.NullString        `json:"partner_url"`
+	PartnerLogoUrl     sql.NullString        `json:"partner_logo_url"`
+	SortOrder          int32                 `json:"sort_order"`
+	Featured           bool                  `json:"featured"`
+	CodeSource         sql.NullString        `json:"code_source"`
+	CodeFormat         sql.NullString        `json:"code_format"`
+	CodeLowThreshold   sql.NullInt32         `json:"code_low_threshold"`
+	CodeLowAlertedAt   sql.NullTime          `json:"code_low_alerted_at"`
+	FulfillmentAdapter sql.NullString        `json:"fulfillment_adapter"`
+	FulfillmentUrl     sql.NullString        `json:"fulfillment_url"`
+	FulfillmentSecret  sql.NullString        `json:"fulfillment_secret"`
+	Active             bool                  `json:"active"`
+	Version            int32                 `json:"version"`
+	CreatedBy          uuid.NullUUID         `json:"created_by"`
+	CreatedAt          time.Time             `json:"created_at"`
 }
 
 type Rule struct {
@@ -50,19 +219,29 @@ type Rule struct {
 	Description sql.NullString  `json:"description"`
 	Config      json.RawMessage `json:"config"`
 	Active      bool            `json:"active"`
+	Version     int32           `json:"version"`
 	CreatedBy   uuid.NullUUID   `json:"created_by"`
 	CreatedAt   time.Time       `json:"created_at"`
 	UpdatedAt   time.Time       `json:"updated_at"`
 }
 
 type Segment struct {
-	ID          uuid.UUID       `json:"id"`
-	Name        string          `json:"name"`
-	Description sql.NullString  `json:"description"`
-	Criteria    json.RawMessage `json:"criteria"`
-	Active      bool            `json:"active"`
-	CreatedBy   uuid.NullUUID   `json:"created_by"`
-	CreatedAt   time.Time       `json:"created_at"`
+	ID             uuid.UUID       `json:"id"`
+	Name           string          `json:"name"`
+	Description    sql.NullString  `json:"description"`
+	Criteria       json.RawMessage `json:"criteria"`
+	Active         bool            `json:"active"`
+	Version        int32           `json:"version"`
+	Materialized   bool            `json:"materialized"`
+	MaterializedAt sql.NullTime    `json:"materialized_at"`
+	CreatedBy      uuid.NullUUID   `json:"created_by"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+type SegmentMember struct {
+	SegmentID   uuid.UUID `json:"segment_id"`
+	UserID      uuid.UUID `json:"user_id"`
+	RefreshedAt time.Time `json:"refreshed_at"`
 }
 
 type User struct {
@@ -70,3 +249,30 @@ type User struct {
 	Phone     string    `json:"phone"`
 	CreatedAt time.Time `json:"created_at"`
 }
+
+type UserProfile struct {
+	UserID            uuid.UUID       `json:"user_id"`
+	Name              sql.NullString  `json:"name"`
+	Email             sql.NullString  `json:"email"`
+	Locale            sql.NullString  `json:"locale"`
+	Timezone          sql.NullString  `json:"timezone"`
+	City              sql.NullString  `json:"city"`
+	VehicleType       sql.NullString  `json:"vehicle_type"`
+	VehicleMake       sql.NullString  `json:"vehicle_make"`
+	VehicleModel      sql.NullString  `json:"vehicle_model"`
+	BatteryKwh        sql.NullFloat64 `json:"battery_kwh"`
+	PreferredLanguage sql.NullString  `json:"preferred_language"`
+	Attributes        json.RawMessage `json:"attributes"`
+	CreatedAt         time.Time       `json:"created_at"`
+	UpdatedAt         time.Time       `json:"updated_at"`
+}
+
+type UserTier struct {
+	UserID        uuid.UUID    `json:"user_id"`
+	Tier          string       `json:"tier"`
+	TierSince     time.Time    `json:"tier_since"`
+	RollingKwh    float64      `json:"rolling_kwh"`
+	RollingPoints int64        `json:"rolling_points"`
+	DowngradeAt   sql.NullTime `json:"downgrade_at"`
+	EvaluatedAt   time.Time    `json:"evaluated_at"`
+}
@@ -12,34 +12,209 @@ import (
 )
 
 type Querier interface {
+	// Voucher code queries
+	// AddRewardCode adds a code to a reward's pool; no row is inserted when the
+	// pool already has the code
+	AddRewardCode(ctx context.Context, arg AddRewardCodeParams) (int64, error)
+	// CancelFulfillmentDelivery stops a pending delivery
+	CancelFulfillmentDelivery(ctx context.Context, redemptionID uuid.UUID) error
+	// CancelPendingRedemption cancels a user's pending redemption made after
+	// cancellable_after; no row is returned otherwise
+	CancelPendingRedemption(ctx context.Context, arg CancelPendingRedemptionParams) (Redemption, error)
+	// ClaimDailyRewardStock counts one unit against a day's stock; no row is
+	// affected when the day's stock is used up
+	ClaimDailyRewardStock(ctx context.Context, arg ClaimDailyRewardStockParams) (int64, error)
+	// ClaimDueFulfillmentDeliveries leases a batch of deliveries due for an
+	// attempt until locked_until; deliveries leased or locked by a concurrent run
+	// are skipped
+	ClaimDueFulfillmentDeliveries(ctx context.Context, arg ClaimDueFulfillmentDeliveriesParams) ([]FulfillmentDelivery, error)
+	// ClaimOTPAttempt counts a verification attempt before the code is compared;
+	// it affects no rows once the challenge is used or has max_attempts attempts
+	ClaimOTPAttempt(ctx context.Context, arg ClaimOTPAttemptParams) (int64, error)
+	// ClaimOutboxBatch locks the due events of a topic that are the oldest
+	// unpublished event for their ordering key, so each key is published in order.
+	// Dead-lettered events are skipped and no longer hold back their key.
+	ClaimOutboxBatch(ctx context.Context, arg ClaimOutboxBatchParams) ([]EventOutbox, error)
+	// ClaimRewardCode assigns the oldest unused code of a reward to a redemption.
+	// Codes locked by concurrent claims are skipped; no row is returned when the
+	// pool is empty.
+	ClaimRewardCode(ctx context.Context, arg ClaimRewardCodeParams) (RewardCode, error)
+	// Reward stock queries
+	// ClaimRewardStock takes one unit of an active reward that is not sold out,
+	// deactivating it and bumping its version when the last unit is taken. No
+	// row is returned otherwise.
+	ClaimRewardStock(ctx context.Context, id uuid.UUID) (RewardsCatalog, error)
+	ClearRewardCodesLow(ctx context.Context, id uuid.UUID) error
+	// ConsumeChargingCredit takes amount from a credit, marking it CONSUMED when
+	// nothing is left
+	ConsumeChargingCredit(ctx context.Context, arg ConsumeChargingCreditParams) (ChargingCredit, error)
+	CountOTPChallengesSince(ctx context.Context, arg CountOTPChallengesSinceParams) (int64, error)
+	CountRewardCodes(ctx context.Context, rewardID uuid.UUID) (CountRewardCodesRow, error)
+	CountSegmentMembers(ctx context.Context, segmentID uuid.UUID) (int64, error)
+	// CountUserRewardRedemptions counts a user's redemptions of a reward that
+	// are awaiting payment, pending or fulfilled, in total and since the start of
+	// the month
+	CountUserRewardRedemptions(ctx context.Context, arg CountUserRewardRedemptionsParams) (CountUserRewardRedemptionsRow, error)
+	// Audit log queries
+	CreateAuditLogEntry(ctx context.Context, arg CreateAuditLogEntryParams) (AdminAuditLog, error)
+	// Charging credit queries
+	CreateChargingCredit(ctx context.Context, arg CreateChargingCreditParams) (ChargingCredit, error)
+	CreateCheckout(ctx context.Context, arg CreateCheckoutParams) (Checkout, error)
+	CreateCreditConsumption(ctx context.Context, arg CreateCreditConsumptionParams) (CreditConsumption, error)
+	// Fulfillment queries
+	// CreateFulfillmentDelivery queues a redemption for delivery; redelivered
+	// RedemptionCreated events affect no row
+	CreateFulfillmentDelivery(ctx context.Context, arg CreateFulfillmentDeliveryParams) (int64, error)
+	// OTP login queries
+	CreateOTPChallenge(ctx context.Context, arg CreateOTPChallengeParams) (OtpChallenge, error)
 	CreatePointsEvent(ctx context.Context, arg CreatePointsEventParams) (PointsEvent, error)
+	// Price quote queries
+	CreatePriceQuote(ctx context.Context, arg CreatePriceQuoteParams) (PriceQuote, error)
 	CreateRedemption(ctx context.Context, arg CreateRedemptionParams) (Redemption, error)
+	// Points + cash payment queries
+	CreateRedemptionPayment(ctx context.Context, arg CreateRedemptionPaymentParams) (RedemptionPayment, error)
 	CreateReward(ctx context.Context, arg CreateRewardParams) (RewardsCatalog, error)
 	// Rules queries
 	CreateRule(ctx context.Context, arg CreateRuleParams) (Rule, error)
 	// Segments queries
 	CreateSegment(ctx context.Context, arg CreateSegmentParams) (Segment, error)
 	CreateUser(ctx context.Context, phone string) (User, error)
+	// DeleteExpiredPriceQuotes deletes quotes that expired before a cutoff
+	DeleteExpiredPriceQuotes(ctx context.Context, expiresAt time.Time) (int64, error)
+	DeletePublishedOutboxEvents(ctx context.Context, publishedBefore time.Time) (int64, error)
 	DeleteRule(ctx context.Context, id uuid.UUID) error
-	GetPendingRedemptionsOlderThan(ctx context.Context, createdAt time.Time) ([]Redemption, error)
+	DeleteSegmentMember(ctx context.Context, arg DeleteSegmentMemberParams) error
+	// DeleteStaleSegmentMembers removes members a full refresh no longer matched
+	DeleteStaleSegmentMembers(ctx context.Context, arg DeleteStaleSegmentMembersParams) (int64, error)
+	// DeleteUserTier removes the tier of a user who no longer qualifies for any
+	DeleteUserTier(ctx context.Context, userID uuid.UUID) error
+	// Outbox queries
+	EnqueueOutboxEvent(ctx context.Context, arg EnqueueOutboxEventParams) (EventOutbox, error)
+	ExpireChargingCredit(ctx context.Context, arg ExpireChargingCreditParams) (ChargingCredit, error)
+	// ExpireDueRedemptions expires a batch of pending redemptions past their
+	// expiry. Redemptions awaiting a partner delivery are left to the fulfillment
+	// dispatcher; those locked by a concurrent run are skipped.
+	ExpireDueRedemptions(ctx context.Context, arg ExpireDueRedemptionsParams) ([]Redemption, error)
+	// FinishFulfillmentDelivery records a delivery's final attempt; deliveries
+	// whose lease was lost, to a cancellation or another run, affect no row
+	FinishFulfillmentDelivery(ctx context.Context, arg FinishFulfillmentDeliveryParams) (int64, error)
+	// FinishPendingRedemption settles a redemption still pending; redemptions
+	// already expired or settled affect no row
+	FinishPendingRedemption(ctx context.Context, arg FinishPendingRedemptionParams) (int64, error)
+	FinishRedemptionPayment(ctx context.Context, arg FinishRedemptionPaymentParams) (RedemptionPayment, error)
+	GetLastUserRewardRedemption(ctx context.Context, arg GetLastUserRewardRedemptionParams) (time.Time, error)
+	GetLatestOTPChallenge(ctx context.Context, phone string) (OtpChallenge, error)
+	GetOutboxLag(ctx context.Context, topic string) (GetOutboxLagRow, error)
 	GetPointsEventsByUser(ctx context.Context, userID uuid.UUID) ([]PointsEvent, error)
+	GetPriceQuote(ctx context.Context, id uuid.UUID) (PriceQuote, error)
 	GetRedemption(ctx context.Context, id uuid.UUID) (Redemption, error)
+	GetRedemptionChargingCredit(ctx context.Context, redemptionID uuid.UUID) (ChargingCredit, error)
+	GetRedemptionPayment(ctx context.Context, redemptionID uuid.UUID) (RedemptionPayment, error)
 	GetRedemptionsByUser(ctx context.Context, userID uuid.UUID) ([]Redemption, error)
 	GetReward(ctx context.Context, id uuid.UUID) (RewardsCatalog, error)
-	GetRewardsCatalog(ctx context.Context) ([]RewardsCatalog, error)
+	// GetRewardForUpdate locks a reward for a read-modify-write update
+	GetRewardForUpdate(ctx context.Context, id uuid.UUID) (RewardsCatalog, error)
+	// GetRewardsCatalog lists the active rewards available at now: inside their
+	// availability window and on one of their available UTC weekdays
+	GetRewardsCatalog(ctx context.Context, now time.Time) ([]RewardsCatalog, error)
 	GetRule(ctx context.Context, id uuid.UUID) (Rule, error)
 	GetSegment(ctx context.Context, id uuid.UUID) (Segment, error)
+	// Segment queries
+	GetSegmentByName(ctx context.Context, name string) (Segment, error)
 	GetUser(ctx context.Context, id uuid.UUID) (User, error)
 	GetUserByPhone(ctx context.Context, phone string) (User, error)
 	GetUserPointsBalance(ctx context.Context, userID uuid.UUID) (int64, error)
+	GetUserProfile(ctx context.Context, userID uuid.UUID) (UserProfile, error)
+	// GetUserSegmentFacts returns the ledger-derived and profile facts segment criteria are evaluated against
+	GetUserSegmentFacts(ctx context.Context, id uuid.UUID) (GetUserSegmentFactsRow, error)
+	// Loyalty tier queries
+	GetUserTier(ctx context.Context, userID uuid.UUID) (UserTier, error)
+	// GetUserTierStats returns what a user charged and earned since the start of the qualification window.
+	// Refunds of redemptions and credits give back spent points, so they do not count as earned.
+	GetUserTierStats(ctx context.Context, arg GetUserTierStatsParams) (GetUserTierStatsRow, error)
+	IsSegmentMember(ctx context.Context, arg IsSegmentMemberParams) (bool, error)
+	// IssueRewardCode records a generated code as claimed by a redemption; no row
+	// is inserted when the reward already issued the code
+	IssueRewardCode(ctx context.Context, arg IssueRewardCodeParams) (int64, error)
+	ListAuditLog(ctx context.Context, arg ListAuditLogParams) ([]AdminAuditLog, error)
+	ListDailyRewardRedemptions(ctx context.Context, day time.Time) ([]ListDailyRewardRedemptionsRow, error)
+	// ListDueChargingCredits claims a batch of active credits past their expiry;
+	// credits locked by a concurrent run are skipped
+	ListDueChargingCredits(ctx context.Context, arg ListDueChargingCreditsParams) ([]ChargingCredit, error)
+	ListMaterializedSegments(ctx context.Context) ([]Segment, error)
+	// ListOpenRedemptionPayments lists a batch of unpaid payments after a cursor
+	// to check with the provider; each is locked again before it is settled
+	ListOpenRedemptionPayments(ctx context.Context, arg ListOpenRedemptionPaymentsParams) ([]RedemptionPayment, error)
+	ListRedemptionStatusEvents(ctx context.Context, redemptionID uuid.UUID) ([]RedemptionStatusEvent, error)
 	// Enhanced rewards queries
 	ListRewards(ctx context.Context, active bool) ([]RewardsCatalog, error)
 	ListRules(ctx context.Context) ([]Rule, error)
+	// ListSegmentMemberFacts returns the segment facts of a sample of a materialized segment's members
+	ListSegmentMemberFacts(ctx context.Context, arg ListSegmentMemberFactsParams) ([]ListSegmentMemberFactsRow, error)
 	ListSegments(ctx context.Context) ([]Segment, error)
+	ListSessionCreditConsumptions(ctx context.Context, arg ListSessionCreditConsumptionsParams) ([]CreditConsumption, error)
+	ListUserChargingCredits(ctx context.Context, arg ListUserChargingCreditsParams) ([]ChargingCredit, error)
+	// Redemption history queries
+	// ListUserRedemptions pages through a user's redemptions, newest first,
+	// optionally limited to one status
+	ListUserRedemptions(ctx context.Context, arg ListUserRedemptionsParams) ([]ListUserRedemptionsRow, error)
+	// ListUserSegmentFacts pages through the segment facts of all users in ID order
+	ListUserSegmentFacts(ctx context.Context, arg ListUserSegmentFactsParams) ([]ListUserSegmentFactsRow, error)
+	// ListUserTierStats pages through every user's current tier and qualification window stats in ID order,
+	// counting earned points as GetUserTierStats does
+	ListUserTierStats(ctx context.Context, arg ListUserTierStatsParams) ([]ListUserTierStatsRow, error)
+	// LockOTPPhone serializes code requests for a phone number until the
+	// transaction ends, so concurrent requests cannot all pass the rate limits
+	LockOTPPhone(ctx context.Context, phone string) error
+	// Cancellation queries
+	// LockPendingFulfillmentDelivery locks a redemption's pending delivery against
+	// new attempts
+	LockPendingFulfillmentDelivery(ctx context.Context, redemptionID uuid.UUID) (FulfillmentDelivery, error)
+	// LockRedemptionPayment loads a redemption's payment for settling it
+	LockRedemptionPayment(ctx context.Context, redemptionID uuid.UUID) (RedemptionPayment, error)
+	// LockUserChargingCredits locks a user's usable credits, soonest to expire
+	// first, so concurrent consume calls apply them one at a time
+	LockUserChargingCredits(ctx context.Context, arg LockUserChargingCreditsParams) ([]ChargingCredit, error)
+	// Per-user redemption limit queries
+	// LockUserRedemptions serializes a user's redemptions so limits and the
+	// balance are checked against committed redemptions only
+	LockUserRedemptions(ctx context.Context, id uuid.UUID) error
+	// MarkOTPVerified consumes a challenge; it affects no rows if the challenge was already used
+	MarkOTPVerified(ctx context.Context, id uuid.UUID) (int64, error)
+	MarkOutboxEventDead(ctx context.Context, arg MarkOutboxEventDeadParams) error
+	MarkOutboxEventFailed(ctx context.Context, arg MarkOutboxEventFailedParams) error
+	MarkOutboxEventPublished(ctx context.Context, id int64) error
+	// MarkRewardCodesLow records that a reward's low-stock alert fired; no row is
+	// affected when it already fired since the codes were last topped up
+	MarkRewardCodesLow(ctx context.Context, id uuid.UUID) (int64, error)
+	ReleaseDailyRewardStock(ctx context.Context, arg ReleaseDailyRewardStockParams) error
+	// ReleaseRewardCode returns the pool code claimed by a redemption to its
+	// pool; generated codes affect no row
+	ReleaseRewardCode(ctx context.Context, redemptionID uuid.NullUUID) (int64, error)
+	// ReleaseRewardStock returns a unit claimed by a cancelled redemption
+	ReleaseRewardStock(ctx context.Context, id uuid.UUID) error
+	// RetryFulfillmentDelivery records a failed attempt to be retried at
+	// next_attempt_at; deliveries whose lease was lost affect no row
+	RetryFulfillmentDelivery(ctx context.Context, arg RetryFulfillmentDeliveryParams) (int64, error)
+	SetRedemptionPaymentIntent(ctx context.Context, arg SetRedemptionPaymentIntentParams) error
+	SetRedemptionVoucherCode(ctx context.Context, arg SetRedemptionVoucherCodeParams) error
+	SetSegmentMaterializedAt(ctx context.Context, arg SetSegmentMaterializedAtParams) error
+	// SettleAwaitingRedemption moves a redemption awaiting payment to its
+	// outcome, restarting its expiry if one is given; no row is returned once it
+	// has left AWAITING_PAYMENT
+	SettleAwaitingRedemption(ctx context.Context, arg SettleAwaitingRedemptionParams) (Redemption, error)
 	UpdateRedemptionStatus(ctx context.Context, arg UpdateRedemptionStatusParams) (Redemption, error)
+	// UpdateReward keeps a reward inactive while its new stock_total is already used up
 	UpdateReward(ctx context.Context, arg UpdateRewardParams) (RewardsCatalog, error)
 	UpdateRule(ctx context.Context, arg UpdateRuleParams) (Rule, error)
 	UpdateSegment(ctx context.Context, arg UpdateSegmentParams) (Segment, error)
+	UpsertSegmentMember(ctx context.Context, arg UpsertSegmentMemberParams) error
+	UpsertUserProfile(ctx context.Context, arg UpsertUserProfileParams) (UserProfile, error)
+	// UpsertUserTier records an evaluation; tier_since only moves when the tier changes
+	UpsertUserTier(ctx context.Context, arg UpsertUserTierParams) (UserTier, error)
+	// VoidRewardCode voids the code issued to a redemption; it stays claimed, so
+	// it is never issued again
+	VoidRewardCode(ctx context.Context, redemptionID uuid.NullUUID) error
 }
 
 var _ Querier = (*Queries)(nil)
@@ -15,55 +15,55 @@ import (
 	"github.com/sqlc-dev/pqtype"
 )
 
-const createPointsEvent = `-- name: CreatePointsEvent :one
-INSERT INTO points_events (user_id, event_type, ref_id, points, meta)
-VALUES ($1, $2, $3, $4, $5)
-RETURNING id, user_id, event_type, ref_id, points, meta, occurred_at
+const addRewardCode = `-- name: AddRewardCode :execrows
+INSERT INTO reward_codes (reward_id, code) VALUES ($1, $2)
+ON CONFLICT (reward_id, code) DO NOTHING
 `
 
-type CreatePointsEventParams struct {
-	UserID    uuid.UUID             `json:"user_id"`
-	EventType string                `json:"event_type"`
-	RefID     sql.NullString        `json:"ref_id"`
-	Points    int32                 `json:"points"`
-	Meta      pqtype.NullRawMessage `json:"meta"`
+type AddRewardCodeParams struct {
+	RewardID uuid.UUID `json:"reward_id"`
+	Code     string    `json:"code"`
 }
 
-func (q *Queries) CreatePointsEvent(ctx context.Context, arg CreatePointsEventParams) (PointsEvent, error) {
-	row := q.db.QueryRowContext(ctx, createPointsEvent,
-		arg.UserID,
-		arg.EventType,
-		arg.RefID,
-		arg.Points,
-		arg.Meta,
-	)
-	var i PointsEvent
-	err := row.Scan(
-		&i.ID,
-		&i.UserID,
-		&i.EventType,
-		&i.RefID,
-		&i.Points,
-		&i.Meta,
-		&i.OccurredAt,
-	)
-	return i, err
+// Voucher code queries
+// AddRewardCode adds a code to a reward's pool; no row is inserted when the
+// pool already has the code
+func (q *Queries) AddRewardCode(ctx context.Context, arg AddRewardCodeParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, addRewardCode, arg.RewardID, arg.Code)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
-const createRedemption = `-- name: CreateRedemption :one
-INSERT INTO redemptions (user_id, reward_id, points_spent)
-VALUES ($1, $2, $3)
-RETURNING id, user_id, reward_id, points_spent, status, created_at, updated_at
+const cancelFulfillmentDelivery = `-- name: CancelFulfillmentDelivery :exec
+UPDATE fulfillment_deliveries SET status = 'CANCELLED'
+WHERE redemption_id = $1 AND status = 'PENDING'
 `
 
-type CreateRedemptionParams struct {
-	UserID      uuid.UUID `json:"user_id"`
-	RewardID    uuid.UUID `json:"reward_id"`
-	PointsSpent int32     `json:"points_spent"`
+// CancelFulfillmentDelivery stops a pending delivery
+func (q *Queries) CancelFulfillmentDelivery(ctx context.Context, redemptionID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, cancelFulfillmentDelivery, redemptionID)
+	return err
 }
 
-func (q *Queries) CreateRedemption(ctx context.Context, arg CreateRedemptionParams) (Redemption, error) {
-	row := q.db.QueryRowContext(ctx, createRedemption, arg.UserID, arg.RewardID, arg.PointsSpent)
+const cancelPendingRedemption = `-- name: CancelPendingRedemption :one
+UPDATE redemptions SET status = 'CANCELLED'
+WHERE id = $1 AND user_id = $2 AND status = 'PENDING'
+    AND created_at > $3::timestamptz
+RETURNING id, user_id, reward_id, points_spent, status, voucher_code, expires_at, base_cost, price_rule, checkout_id, created_at, updated_at
+`
+
+type CancelPendingRedemptionParams struct {
+	ID               uuid.UUID `json:"id"`
+	UserID           uuid.UUID `json:"user_id"`
+	CancellableAfter time.Time `json:"cancellable_after"`
+}
+
+// CancelPendingRedemption cancels a user's pending redemption made after
+// cancellable_after; no row is returned otherwise
+func (q *Queries) CancelPendingRedemption(ctx context.Context, arg CancelPendingRedemptionParams) (Redemption, error) {
+	row := q.db.QueryRowContext(ctx, cancelPendingRedemption, arg.ID, arg.UserID, arg.CancellableAfter)
 	var i Redemption
 	err := row.Scan(
 		&i.ID,
@@ -71,37 +71,230 @@ func (q *Queries) CreateRedemption(ctx context.Context, arg CreateRedemptionPara
 		&i.RewardID,
 		&i.PointsSpent,
 		&i.Status,
+		&i.VoucherCode,
+		&i.ExpiresAt,
+		&i.BaseCost,
+		&i.PriceRule,
+		&i.CheckoutID,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 	)
 	return i, err
 }
 
-const createReward = `-- name: CreateReward :one
-INSERT INTO rewards_catalog (id, name, description, cost, segment, active, created_by) 
-VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, name, description, cost, segment, active, created_by, created_at
+const claimDailyRewardStock = `-- name: ClaimDailyRewardStock :execrows
+INSERT INTO reward_daily_redemptions (reward_id, day, redeemed)
+VALUES ($1, $2, 1)
+ON CONFLICT (reward_id, day) DO UPDATE SET redeemed = reward_daily_redemptions.redeemed + 1
+WHERE reward_daily_redemptions.redeemed < $3::int
 `
 
-type CreateRewardParams struct {
-	ID          uuid.UUID             `json:"id"`
-	Name        string                `json:"name"`
-	Description sql.NullString        `json:"description"`
-	Cost        int32                 `json:"cost"`
-	Segment     pqtype.NullRawMessage `json:"segment"`
-	Active      bool                  `json:"active"`
-	CreatedBy   uuid.NullUUID         `json:"created_by"`
+type ClaimDailyRewardStockParams struct {
+	RewardID   uuid.UUID `json:"reward_id"`
+	Day        time.Time `json:"day"`
+	DailyStock int32     `json:"daily_stock"`
 }
 
-func (q *Queries) CreateReward(ctx context.Context, arg CreateRewardParams) (RewardsCatalog, error) {
-	row := q.db.QueryRowContext(ctx, createReward,
-		arg.ID,
-		arg.Name,
-		arg.Description,
-		arg.Cost,
-		arg.Segment,
-		arg.Active,
-		arg.CreatedBy,
+// ClaimDailyRewardStock counts one unit against a day's stock; no row is
+// affected when the day's stock is used up
+func (q *Queries) ClaimDailyRewardStock(ctx context.Context, arg ClaimDailyRewardStockParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, claimDailyRewardStock, arg.RewardID, arg.Day, arg.DailyStock)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const claimDueFulfillmentDeliveries = `-- name: ClaimDueFulfillmentDeliveries :many
+UPDATE fulfillment_deliveries SET locked_until = $1::timestamptz
+WHERE id IN (
+    SELECT id FROM fulfillment_deliveries
+    WHERE status = 'PENDING' AND next_attempt_at <= $2::timestamptz
+      AND (locked_until IS NULL OR locked_until <= $2::timestamptz)
+    ORDER BY next_attempt_at ASC
+    LIMIT $3::int
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING id, redemption_id, reward_id, adapter, payload, status, attempts, last_error, response_status, next_attempt_at, locked_until, created_at, updated_at
+`
+
+type ClaimDueFulfillmentDeliveriesParams struct {
+	LockedUntil time.Time `json:"locked_until"`
+	Now         time.Time `json:"now"`
+	BatchSize   int32     `json:"batch_size"`
+}
+
+// ClaimDueFulfillmentDeliveries leases a batch of deliveries due for an
+// attempt until locked_until; deliveries leased or locked by a concurrent run
+// are skipped
+func (q *Queries) ClaimDueFulfillmentDeliveries(ctx context.Context, arg ClaimDueFulfillmentDeliveriesParams) ([]FulfillmentDelivery, error) {
+	rows, err := q.db.QueryContext(ctx, claimDueFulfillmentDeliveries, arg.LockedUntil, arg.Now, arg.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []FulfillmentDelivery{}
+	for rows.Next() {
+		var i FulfillmentDelivery
+		if err := rows.Scan(
+			&i.ID,
+			&i.RedemptionID,
+			&i.RewardID,
+			&i.Adapter,
+			&i.Payload,
+			&i.Status,
+			&i.Attempts,
+			&i.LastError,
+			&i.ResponseStatus,
+			&i.NextAttemptAt,
+			&i.LockedUntil,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const claimOTPAttempt = `-- name: ClaimOTPAttempt :execrows
+UPDATE otp_challenges SET attempts = attempts + 1
+WHERE id = $1 AND verified_at IS NULL AND attempts < $2::int
+`
+
+type ClaimOTPAttemptParams struct {
+	ID          uuid.UUID `json:"id"`
+	MaxAttempts int32     `json:"max_attempts"`
+}
+
+// ClaimOTPAttempt counts a verification attempt before the code is compared;
+// it affects no rows once the challenge is used or has max_attempts attempts
+func (q *Queries) ClaimOTPAttempt(ctx context.Context, arg ClaimOTPAttemptParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, claimOTPAttempt, arg.ID, arg.MaxAttempts)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const claimOutboxBatch = `-- name: ClaimOutboxBatch :many
+SELECT id, topic, ordering_key, payload, attempts, last_error, next_attempt_at, created_at, published_at, dead_at FROM event_outbox o
+WHERE o.topic = $1
+  AND o.published_at IS NULL
+  AND o.dead_at IS NULL
+  AND o.next_attempt_at <= NOW()
+  AND NOT EXISTS (
+    SELECT 1 FROM event_outbox p
+    WHERE p.topic = o.topic
+      AND p.ordering_key = o.ordering_key
+      AND p.published_at IS NULL
+      AND p.dead_at IS NULL
+      AND p.id < o.id
+  )
+ORDER BY o.id
+LIMIT $2
+FOR UPDATE SKIP LOCKED
+`
+
+type ClaimOutboxBatchParams struct {
+	Topic     string `json:"topic"`
+	BatchSize int32  `json:"batch_size"`
+}
+
+// ClaimOutboxBatch locks the due events of a topic that are the oldest
+// unpublished event for their ordering key, so each key is published in order.
+// Dead-lettered events are skipped and no longer hold back their key.
+func (q *Queries) ClaimOutboxBatch(ctx context.Context, arg ClaimOutboxBatchParams) ([]EventOutbox, error) {
+	rows, err := q.db.QueryContext(ctx, claimOutboxBatch, arg.Topic, arg.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []EventOutbox{}
+	for rows.Next() {
+		var i EventOutbox
+		if err := rows.Scan(
+			&i.ID,
+			&i.Topic,
+			&i.OrderingKey,
+			&i.Payload,
+			&i.Attempts,
+			&i.LastError,
+			&i.NextAttemptAt,
+			&i.CreatedAt,
+			&i.PublishedAt,
+			&i.DeadAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const claimRewardCode = `-- name: ClaimRewardCode :one
+UPDATE reward_codes SET redemption_id = $1, claimed_at = NOW()
+WHERE id = (
+    SELECT c.id FROM reward_codes c
+    WHERE c.reward_id = $2 AND c.redemption_id IS NULL
+    ORDER BY c.created_at, c.id
+    LIMIT 1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING id, reward_id, code, redemption_id, claimed_at, voided_at, created_at
+`
+
+type ClaimRewardCodeParams struct {
+	RedemptionID uuid.NullUUID `json:"redemption_id"`
+	RewardID     uuid.UUID     `json:"reward_id"`
+}
+
+// ClaimRewardCode assigns the oldest unused code of a reward to a redemption.
+// Codes locked by concurrent claims are skipped; no row is returned when the
+// pool is empty.
+func (q *Queries) ClaimRewardCode(ctx context.Context, arg ClaimRewardCodeParams) (RewardCode, error) {
+	row := q.db.QueryRowContext(ctx, claimRewardCode, arg.RedemptionID, arg.RewardID)
+	var i RewardCode
+	err := row.Scan(
+		&i.ID,
+		&i.RewardID,
+		&i.Code,
+		&i.RedemptionID,
+		&i.ClaimedAt,
+		&i.VoidedAt,
+		&i.CreatedAt,
 	)
+	return i, err
+}
+
+const claimRewardStock = `-- name: ClaimRewardStock :one
+UPDATE rewards_catalog
+SET stock_redeemed = stock_redeemed + 1,
+    active = CASE WHEN stock_total IS NOT NULL AND stock_redeemed + 1 >= stock_total THEN false ELSE active END,
+    version = CASE WHEN stock_total IS NOT NULL AND stock_redeemed + 1 >= stock_total THEN version + 1 ELSE version END
+WHERE id = $1 AND active = true AND (stock_total IS NULL OR stock_redeemed < stock_total)
+RETURNING id, name, description, cost, segment, min_tier, reward_type, credit_amount, credit_valid_days, stock_total, stock_redeemed, daily_stock, max_per_user, max_per_user_monthly, cooldown_seconds, pending_ttl_seconds, available_from, available_until, available_days, min_points, cash_price, price_rules, category, tags, image_url, terms, partner_name, partner_url, partner_logo_url, sort_order, featured, code_source, code_format, code_low_threshold, code_low_alerted_at, fulfillment_adapter, fulfillment_url, fulfillment_secret, active, version, created_by, created_at
+`
+
+// Reward stock queries
+// ClaimRewardStock takes one unit of an active reward that is not sold out,
+// deactivating it and bumping its version when the last unit is taken. No
+// row is returned otherwise.
+func (q *Queries) ClaimRewardStock(ctx context.Context, id uuid.UUID) (RewardsCatalog, error) {
+	row := q.db.QueryRowContext(ctx, claimRewardStock, id)
 	var i RewardsCatalog
 	err := row.Scan(
 		&i.ID,
@@ -109,131 +302,1936 @@ func (q *Queries) CreateReward(ctx context.Context, arg CreateRewardParams) (Rew
 		&i.Description,
 		&i.Cost,
 		&i.Segment,
+		&i.MinTier,
+		&i.RewardType,
+		&i.CreditAmount,
+		&i.CreditValidDays,
+		&i.StockTotal,
+		&i.StockRedeemed,
+		&i.DailyStock,
+		&i.MaxPerUser,
+		&i.MaxPerUserMonthly,
+		&i.CooldownSeconds,
+		&i.PendingTtlSeconds,
+		&i.AvailableFrom,
+		&i.AvailableUntil,
+		&i.AvailableDays,
+		&i.MinPoints,
+		&i.CashPrice,
+		&i.PriceRules,
+		&i.Category,
+		&i.Tags,
+		&i.ImageUrl,
+		&i.Terms,
+		&i.PartnerName,
+		&i.PartnerUrl,
+		&i.PartnerLogoUrl,
+		&i.SortOrder,
+		&i.Featured,
+		&i.CodeSource,
+		&i.CodeFormat,
+		&i.CodeLowThreshold,
+		&i.CodeLowAlertedAt,
+		&i.FulfillmentAdapter,
+		&i.FulfillmentUrl,
+		&i.FulfillmentSecret,
 		&i.Active,
+		&i.Version,
 		&i.CreatedBy,
 		&i.CreatedAt,
 	)
 	return i, err
 }
 
-const createRule = `-- name: CreateRule :one
-INSERT INTO rules (id, name, description, config, active, created_by) 
-VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, name, description, config, active, created_by, created_at, updated_at
+const clearRewardCodesLow = `-- name: ClearRewardCodesLow :exec
+UPDATE rewards_catalog SET code_low_alerted_at = NULL WHERE id = $1
 `
 
-type CreateRuleParams struct {
-	ID          uuid.UUID       `json:"id"`
-	Name        string          `json:"name"`
-	Description sql.NullString  `json:"description"`
-	Config      json.RawMessage `json:"config"`
-	Active      bool            `json:"active"`
-	CreatedBy   uuid.NullUUID   `json:"created_by"`
+func (q *Queries) ClearRewardCodesLow(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, clearRewardCodesLow, id)
+	return err
 }
 
-// Rules queries
-func (q *Queries) CreateRule(ctx context.Context, arg CreateRuleParams) (Rule, error) {
-	row := q.db.QueryRowContext(ctx, createRule,
-		arg.ID,
-		arg.Name,
-		arg.Description,
-		arg.Config,
-		arg.Active,
-		arg.CreatedBy,
-	)
-	var i Rule
+const consumeChargingCredit = `-- name: ConsumeChargingCredit :one
+UPDATE charging_credits
+SET remaining = remaining - $1::bigint,
+    status = CASE WHEN remaining = $1::bigint THEN 'CONSUMED' ELSE status END
+WHERE id = $2 AND status = 'ACTIVE' AND remaining >= $1::bigint
+RETURNING id, user_id, redemption_id, amount, remaining, currency, points_spent, status, refunded_points, expires_at, created_at, updated_at
+`
+
+type ConsumeChargingCreditParams struct {
+	Amount int64     `json:"amount"`
+	ID     uuid.UUID `json:"id"`
+}
+
+// ConsumeChargingCredit takes amount from a credit, marking it CONSUMED when
+// nothing is left
+func (q *Queries) ConsumeChargingCredit(ctx context.Context, arg ConsumeChargingCreditParams) (ChargingCredit, error) {
+	row := q.db.QueryRowContext(ctx, consumeChargingCredit, arg.Amount, arg.ID)
+	var i ChargingCredit
 	err := row.Scan(
 		&i.ID,
-		&i.Name,
-		&i.Description,
-		&i.Config,
-		&i.Active,
-		&i.CreatedBy,
+		&i.UserID,
+		&i.RedemptionID,
+		&i.Amount,
+		&i.Remaining,
+		&i.Currency,
+		&i.PointsSpent,
+		&i.Status,
+		&i.RefundedPoints,
+		&i.ExpiresAt,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 	)
 	return i, err
 }
 
-const createSegment = `-- name: CreateSegment :one
-INSERT INTO segments (id, name, description, criteria, active, created_by) 
-VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, name, description, criteria, active, created_by, created_at
+const countOTPChallengesSince = `-- name: CountOTPChallengesSince :one
+SELECT count(*) FROM otp_challenges
+WHERE phone = $1 AND created_at >= $2::timestamptz
 `
 
-type CreateSegmentParams struct {
-	ID          uuid.UUID       `json:"id"`
-	Name        string          `json:"name"`
-	Description sql.NullString  `json:"description"`
-	Criteria    json.RawMessage `json:"criteria"`
-	Active      bool            `json:"active"`
-	CreatedBy   uuid.NullUUID   `json:"created_by"`
+type CountOTPChallengesSinceParams struct {
+	Phone string    `json:"phone"`
+	Since time.Time `json:"since"`
 }
 
-// Segments queries
-func (q *Queries) CreateSegment(ctx context.Context, arg CreateSegmentParams) (Segment, error) {
-	row := q.db.QueryRowContext(ctx, createSegment,
-		arg.ID,
-		arg.Name,
-		arg.Description,
-		arg.Criteria,
-		arg.Active,
-		arg.CreatedBy,
+func (q *Queries) CountOTPChallengesSince(ctx context.Context, arg CountOTPChallengesSinceParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countOTPChallengesSince, arg.Phone, arg.Since)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countRewardCodes = `-- name: CountRewardCodes :one
+SELECT COUNT(*)::int AS total,
+    (COUNT(*) FILTER (WHERE redemption_id IS NULL))::int AS available
+FROM reward_codes WHERE reward_id = $1
+`
+
+type CountRewardCodesRow struct {
+	Total     int32 `json:"total"`
+	Available int32 `json:"available"`
+}
+
+func (q *Queries) CountRewardCodes(ctx context.Context, rewardID uuid.UUID) (CountRewardCodesRow, error) {
+	row := q.db.QueryRowContext(ctx, countRewardCodes, rewardID)
+	var i CountRewardCodesRow
+	err := row.Scan(&i.Total, &i.Available)
+	return i, err
+}
+
+const countSegmentMembers = `-- name: CountSegmentMembers :one
+SELECT count(*) FROM segment_members WHERE segment_id = $1
+`
+
+func (q *Queries) CountSegmentMembers(ctx context.Context, segmentID uuid.UUID) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countSegmentMembers, segmentID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countUserRewardRedemptions = `-- name: CountUserRewardRedemptions :one
+SELECT COUNT(*)::int AS total,
+    (COUNT(*) FILTER (WHERE created_at >= $1::timestamptz))::int AS this_month
+FROM redemptions
+WHERE user_id = $2 AND reward_id = $3 AND status IN ('AWAITING_PAYMENT', 'PENDING', 'FULFILLED')
+`
+
+type CountUserRewardRedemptionsParams struct {
+	MonthStart time.Time `json:"month_start"`
+	UserID     uuid.UUID `json:"user_id"`
+	RewardID   uuid.UUID `json:"reward_id"`
+}
+
+type CountUserRewardRedemptionsRow struct {
+	Total     int32 `json:"total"`
+	ThisMonth int32 `json:"this_month"`
+}
+
+// CountUserRewardRedemptions counts a user's redemptions of a reward that
+// are awaiting payment, pending or fulfilled, in total and since the start of
+// the month
+func (q *Queries) CountUserRewardRedemptions(ctx context.Context, arg CountUserRewardRedemptionsParams) (CountUserRewardRedemptionsRow, error) {
+	row := q.db.QueryRowContext(ctx, countUserRewardRedemptions, arg.MonthStart, arg.UserID, arg.RewardID)
+	var i CountUserRewardRedemptionsRow
+	err := row.Scan(&i.Total, &i.ThisMonth)
+	return i, err
+}
+
+const createAuditLogEntry = `-- name: CreateAuditLogEntry :one
+INSERT INTO admin_audit_log (actor_id, actor_email, action, entity_type, entity_id, before, after, diff, request_id, ip)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+RETURNING id, actor_id, actor_email, action, entity_type, entity_id, before, after, diff, request_id, ip, created_at
+`
+
+type CreateAuditLogEntryParams struct {
+	ActorID    uuid.NullUUID         `json:"actor_id"`
+	ActorEmail sql.NullString        `json:"actor_email"`
+	Action     string                `json:"action"`
+	EntityType string                `json:"entity_type"`
+	EntityID   uuid.NullUUID         `json:"entity_id"`
+	Before     pqtype.NullRawMessage `json:"before"`
+	After      pqtype.NullRawMessage `json:"after"`
+	Diff       pqtype.NullRawMessage `json:"diff"`
+	RequestID  sql.NullString        `json:"request_id"`
+	Ip         sql.NullString        `json:"ip"`
+}
+
+// Audit log queries
+func (q *Queries) CreateAuditLogEntry(ctx context.Context, arg CreateAuditLogEntryParams) (AdminAuditLog, error) {
+	row := q.db.QueryRowContext(ctx, createAuditLogEntry,
+		arg.ActorID,
+		arg.ActorEmail,
+		arg.Action,
+		arg.EntityType,
+		arg.EntityID,
+		arg.Before,
+		arg.After,
+		arg.Diff,
+		arg.RequestID,
+		arg.Ip,
 	)
-	var i Segment
+	var i AdminAuditLog
 	err := row.Scan(
 		&i.ID,
-		&i.Name,
-		&i.Description,
-		&i.Criteria,
-		&i.Active,
-		&i.CreatedBy,
+		&i.ActorID,
+		&i.ActorEmail,
+		&i.Action,
+		&i.EntityType,
+		&i.EntityID,
+		&i.Before,
+		&i.After,
+		&i.Diff,
+		&i.RequestID,
+		&i.Ip,
 		&i.CreatedAt,
 	)
 	return i, err
 }
 
-const createUser = `-- name: CreateUser :one
-INSERT INTO users (phone)
-VALUES ($1)
-RETURNING id, phone, created_at
+const createChargingCredit = `-- name: CreateChargingCredit :one
+INSERT INTO charging_credits (user_id, redemption_id, amount, remaining, points_spent, expires_at)
+VALUES ($1, $2, $3, $3, $4, $5)
+RETURNING id, user_id, redemption_id, amount, remaining, currency, points_spent, status, refunded_points, expires_at, created_at, updated_at
 `
 
-func (q *Queries) CreateUser(ctx context.Context, phone string) (User, error) {
-	row := q.db.QueryRowContext(ctx, createUser, phone)
-	var i User
-	err := row.Scan(&i.ID, &i.Phone, &i.CreatedAt)
+type CreateChargingCreditParams struct {
+	UserID       uuid.UUID `json:"user_id"`
+	RedemptionID uuid.UUID `json:"redemption_id"`
+	Amount       int64     `json:"amount"`
+	PointsSpent  int32     `json:"points_spent"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// Charging credit queries
+func (q *Queries) CreateChargingCredit(ctx context.Context, arg CreateChargingCreditParams) (ChargingCredit, error) {
+	row := q.db.QueryRowContext(ctx, createChargingCredit,
+		arg.UserID,
+		arg.RedemptionID,
+		arg.Amount,
+		arg.PointsSpent,
+		arg.ExpiresAt,
+	)
+	var i ChargingCredit
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.RedemptionID,
+		&i.Amount,
+		&i.Remaining,
+		&i.Currency,
+		&i.PointsSpent,
+		&i.Status,
+		&i.RefundedPoints,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const createCheckout = `-- name: CreateCheckout :one
+INSERT INTO checkouts (user_id, points_spent)
+VALUES ($1, $2)
+RETURNING id, user_id, points_spent, created_at
+`
+
+type CreateCheckoutParams struct {
+	UserID      uuid.UUID `json:"user_id"`
+	PointsSpent int32     `json:"points_spent"`
+}
+
+func (q *Queries) CreateCheckout(ctx context.Context, arg CreateCheckoutParams) (Checkout, error) {
+	row := q.db.QueryRowContext(ctx, createCheckout, arg.UserID, arg.PointsSpent)
+	var i Checkout
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.PointsSpent,
+		&i.CreatedAt,
+	)
 	return i, err
 }
 
-const deleteRule = `-- name: DeleteRule :exec
-DELETE FROM rules WHERE id = $1
+const createCreditConsumption = `-- name: CreateCreditConsumption :one
+INSERT INTO credit_consumptions (credit_id, user_id, session_id, amount)
+VALUES ($1, $2, $3, $4)
+RETURNING id, credit_id, user_id, session_id, amount, created_at
+`
+
+type CreateCreditConsumptionParams struct {
+	CreditID  uuid.UUID `json:"credit_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	SessionID string    `json:"session_id"`
+	Amount    int64     `json:"amount"`
+}
+
+func (q *Queries) CreateCreditConsumption(ctx context.Context, arg CreateCreditConsumptionParams) (CreditConsumption, error) {
+	row := q.db.QueryRowContext(ctx, createCreditConsumption,
+		arg.CreditID,
+		arg.UserID,
+		arg.SessionID,
+		arg.Amount,
+	)
+	var i CreditConsumption
+	err := row.Scan(
+		&i.ID,
+		&i.CreditID,
+		&i.UserID,
+		&i.SessionID,
+		&i.Amount,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createFulfillmentDelivery = `-- name: CreateFulfillmentDelivery :execrows
+INSERT INTO fulfillment_deliveries (redemption_id, reward_id, adapter, payload)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (redemption_id) DO NOTHING
+`
+
+type CreateFulfillmentDeliveryParams struct {
+	RedemptionID uuid.UUID       `json:"redemption_id"`
+	RewardID     uuid.UUID       `json:"reward_id"`
+	Adapter      string          `json:"adapter"`
+	Payload      json.RawMessage `json:"payload"`
+}
+
+// Fulfillment queries
+// CreateFulfillmentDelivery queues a redemption for delivery; redelivered
+// RedemptionCreated events affect no row
+func (q *Queries) CreateFulfillmentDelivery(ctx context.Context, arg CreateFulfillmentDeliveryParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, createFulfillmentDelivery,
+		arg.RedemptionID,
+		arg.RewardID,
+		arg.Adapter,
+		arg.Payload,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const createOTPChallenge = `-- name: CreateOTPChallenge :one
+INSERT INTO otp_challenges (phone, code_hash, expires_at)
+VALUES ($1, $2, $3)
+RETURNING id, phone, code_hash, attempts, expires_at, verified_at, created_at
+`
+
+type CreateOTPChallengeParams struct {
+	Phone     string    `json:"phone"`
+	CodeHash  string    `json:"code_hash"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// OTP login queries
+func (q *Queries) CreateOTPChallenge(ctx context.Context, arg CreateOTPChallengeParams) (OtpChallenge, error) {
+	row := q.db.QueryRowContext(ctx, createOTPChallenge, arg.Phone, arg.CodeHash, arg.ExpiresAt)
+	var i OtpChallenge
+	err := row.Scan(
+		&i.ID,
+		&i.Phone,
+		&i.CodeHash,
+		&i.Attempts,
+		&i.ExpiresAt,
+		&i.VerifiedAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createPointsEvent = `-- name: CreatePointsEvent :one
+INSERT INTO points_events (user_id, event_type, ref_id, points, meta)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, user_id, event_type, ref_id, points, meta, occurred_at
+`
+
+type CreatePointsEventParams struct {
+	UserID    uuid.UUID             `json:"user_id"`
+	EventType string                `json:"event_type"`
+	RefID     sql.NullString        `json:"ref_id"`
+	Points    int32                 `json:"points"`
+	Meta      pqtype.NullRawMessage `json:"meta"`
+}
+
+func (q *Queries) CreatePointsEvent(ctx context.Context, arg CreatePointsEventParams) (PointsEvent, error) {
+	row := q.db.QueryRowContext(ctx, createPointsEvent,
+		arg.UserID,
+		arg.EventType,
+		arg.RefID,
+		arg.Points,
+		arg.Meta,
+	)
+	var i PointsEvent
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.EventType,
+		&i.RefID,
+		&i.Points,
+		&i.Meta,
+		&i.OccurredAt,
+	)
+	return i, err
+}
+
+const createPriceQuote = `-- name: CreatePriceQuote :one
+INSERT INTO price_quotes (user_id, prices, expires_at)
+VALUES ($1, $2, $3)
+RETURNING id, user_id, prices, expires_at, created_at
+`
+
+type CreatePriceQuoteParams struct {
+	UserID    uuid.UUID       `json:"user_id"`
+	Prices    json.RawMessage `json:"prices"`
+	ExpiresAt time.Time       `json:"expires_at"`
+}
+
+// Price quote queries
+func (q *Queries) CreatePriceQuote(ctx context.Context, arg CreatePriceQuoteParams) (PriceQuote, error) {
+	row := q.db.QueryRowContext(ctx, createPriceQuote, arg.UserID, arg.Prices, arg.ExpiresAt)
+	var i PriceQuote
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Prices,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createRedemption = `-- name: CreateRedemption :one
+INSERT INTO redemptions (user_id, reward_id, points_spent, expires_at, status, base_cost, price_rule, checkout_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING id, user_id, reward_id, points_spent, status, voucher_code, expires_at, base_cost, price_rule, checkout_id, created_at, updated_at
+`
+
+type CreateRedemptionParams struct {
+	UserID      uuid.UUID      `json:"user_id"`
+	RewardID    uuid.UUID      `json:"reward_id"`
+	PointsSpent int32          `json:"points_spent"`
+	ExpiresAt   time.Time      `json:"expires_at"`
+	Status      string         `json:"status"`
+	BaseCost    sql.NullInt32  `json:"base_cost"`
+	PriceRule   sql.NullString `json:"price_rule"`
+	CheckoutID  uuid.NullUUID  `json:"checkout_id"`
+}
+
+func (q *Queries) CreateRedemption(ctx context.Context, arg CreateRedemptionParams) (Redemption, error) {
+	row := q.db.QueryRowContext(ctx, createRedemption,
+		arg.UserID,
+		arg.RewardID,
+		arg.PointsSpent,
+		arg.ExpiresAt,
+		arg.Status,
+		arg.BaseCost,
+		arg.PriceRule,
+		arg.CheckoutID,
+	)
+	var i Redemption
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.RewardID,
+		&i.PointsSpent,
+		&i.Status,
+		&i.VoucherCode,
+		&i.ExpiresAt,
+		&i.BaseCost,
+		&i.PriceRule,
+		&i.CheckoutID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const createRedemptionPayment = `-- name: CreateRedemptionPayment :one
+INSERT INTO redemption_payments (redemption_id, provider, amount, currency, expires_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, redemption_id, provider, intent_id, amount, currency, status, failure_reason, expires_at, created_at, updated_at
+`
+
+type CreateRedemptionPaymentParams struct {
+	RedemptionID uuid.UUID `json:"redemption_id"`
+	Provider     string    `json:"provider"`
+	Amount       int64     `json:"amount"`
+	Currency     string    `json:"currency"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// Points + cash payment queries
+func (q *Queries) CreateRedemptionPayment(ctx context.Context, arg CreateRedemptionPaymentParams) (RedemptionPayment, error) {
+	row := q.db.QueryRowContext(ctx, createRedemptionPayment,
+		arg.RedemptionID,
+		arg.Provider,
+		arg.Amount,
+		arg.Currency,
+		arg.ExpiresAt,
+	)
+	var i RedemptionPayment
+	err := row.Scan(
+		&i.ID,
+		&i.RedemptionID,
+		&i.Provider,
+		&i.IntentID,
+		&i.Amount,
+		&i.Currency,
+		&i.Status,
+		&i.FailureReason,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const createReward = `-- name: CreateReward :one
+INSERT INTO rewards_catalog (id, name, description, cost, segment, active, created_by, min_tier, stock_total, daily_stock, max_per_user, max_per_user_monthly, cooldown_seconds, code_source, code_format, code_low_threshold, reward_type, credit_amount, credit_valid_days, fulfillment_adapter, fulfillment_url, fulfillment_secret, pending_ttl_seconds, available_from, available_until, available_days, min_points, cash_price, price_rules, category, tags, image_url, terms, partner_name, partner_url, partner_logo_url, sort_order, featured) 
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29, $30, $31, $32, $33, $34, $35, $36, $37, $38) RETURNING id, name, description, cost, segment, min_tier, reward_type, credit_amount, credit_valid_days, stock_total, stock_redeemed, daily_stock, max_per_user, max_per_user_monthly, cooldown_seconds, pending_ttl_seconds, available_from, available_until, available_days, min_points, cash_price, price_rules, category, tags, image_url, terms, partner_name, partner_url, partner_logo_url, sort_order, featured, code_source, code_format, code_low_threshold, code_low_alerted_at, fulfillment_adapter, fulfillment_url, fulfillment_secret, active, version, created_by, created_at
+`
+
+type CreateRewardParams struct {
+	ID                 uuid.UUID             `json:"id"`
+	Name               string                `json:"name"`
+	Description        sql.NullString        `json:"description"`
+	Cost               int32                 `json:"cost"`
+	Segment            pqtype.NullRawMessage `json:"segment"`
+	Active             bool                  `json:"active"`
+	CreatedBy          uuid.NullUUID         `json:"created_by"`
+	MinTier            sql.NullString        `json:"min_tier"`
+	StockTotal         sql.NullInt32         `json:"stock_total"`
+	DailyStock         sql.NullInt32         `json:"daily_stock"`
+	MaxPerUser         sql.NullInt32         `json:"max_per_user"`
+	MaxPerUserMonthly  sql.NullInt32         `json:"max_per_user_monthly"`
+	CooldownSeconds    sql.NullInt32         `json:"cooldown_seconds"`
+	CodeSource         sql.NullString        `json:"code_source"`
+	CodeFormat         sql.NullString        `json:"code_format"`
+	CodeLowThreshold   sql.NullInt32         `json:"code_low_threshold"`
+	RewardType         string                `json:"reward_type"`
+	CreditAmount       sql.NullInt64         `json:"credit_amount"`
+	CreditValidDays    sql.NullInt32         `json:"credit_valid_days"`
+	FulfillmentAdapter sql.NullString        `json:"fulfillment_adapter"`
+	FulfillmentUrl     sql.NullString        `json:"fulfillment_url"`
+	FulfillmentSecret  sql.NullString        `json:"fulfillment_secret"`
+	PendingTtlSeconds  sql.NullInt32         `json:"pending_ttl_seconds"`
+	AvailableFrom      sql.NullTime          `json:"available_from"`
+	AvailableUntil     sql.NullTime          `json:"available_until"`
+	AvailableDays      sql.NullInt32         `json:"available_days"`
+	MinPoints          sql.NullInt32         `json:"min_points"`
+	CashPrice          sql.NullInt64         `json:"cash_price"`
+	PriceRules         pqtype.NullRawMessage `json:"price_rules"`
+	Category           sql.NullString        `json:"category"`
+	Tags               pqtype.NullRawMessage `json:"tags"`
+	ImageUrl           sql.NullString        `json:"image_url"`
+	Terms              sql.NullString        `json:"terms"`
+	PartnerName        sql.NullString        `json:"partner_name"`
+	PartnerUrl         sql.NullString        `json:"partner_url"`
+	PartnerLogoUrl     sql.NullString        `json:"partner_logo_url"`
+	SortOrder          int32                 `json:"sort_order"`
+	Featured           bool                  `json:"featured"`
+}
+
+func (q *Queries) CreateReward(ctx context.Context, arg CreateRewardParams) (RewardsCatalog, error) {
+	row := q.db.QueryRowContext(ctx, createReward,
+		arg.ID,
+		arg.Name,
+		arg.Description,
+		arg.Cost,
+		arg.Segment,
+		arg.Active,
+		arg.CreatedBy,
+		arg.MinTier,
+		arg.StockTotal,
+		arg.DailyStock,
+		arg.MaxPerUser,
+		arg.MaxPerUserMonthly,
+		arg.CooldownSeconds,
+		arg.CodeSource,
+		arg.CodeFormat,
+		arg.CodeLowThreshold,
+		arg.RewardType,
+		arg.CreditAmount,
+		arg.CreditValidDays,
+		arg.FulfillmentAdapter,
+		arg.FulfillmentUrl,
+		arg.FulfillmentSecret,
+		arg.PendingTtlSeconds,
+		arg.AvailableFrom,
+		arg.AvailableUntil,
+		arg.AvailableDays,
+		arg.MinPoints,
+		arg.CashPrice,
+		arg.PriceRules,
+		arg.Category,
+		arg.Tags,
+		arg.ImageUrl,
+		arg.Terms,
+		arg.PartnerName,
+		arg.PartnerUrl,
+		arg.PartnerLogoUrl,
+		arg.SortOrder,
+		arg.Featured,
+	)
+	var i RewardsCatalog
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.Cost,
+		&i.Segment,
+		&i.MinTier,
+		&i.RewardType,
+		&i.CreditAmount,
+		&i.CreditValidDays,
+		&i.StockTotal,
+		&i.StockRedeemed,
+		&i.DailyStock,
+		&i.MaxPerUser,
+		&i.MaxPerUserMonthly,
+		&i.CooldownSeconds,
+		&i.PendingTtlSeconds,
+		&i.AvailableFrom,
+		&i.AvailableUntil,
+		&i.AvailableDays,
+		&i.MinPoints,
+		&i.CashPrice,
+		&i.PriceRules,
+		&i.Category,
+		&i.Tags,
+		&i.ImageUrl,
+		&i.Terms,
+		&i.PartnerName,
+		&i.PartnerUrl,
+		&i.PartnerLogoUrl,
+		&i.SortOrder,
+		&i.Featured,
+		&i.CodeSource,
+		&i.CodeFormat,
+		&i.CodeLowThreshold,
+		&i.CodeLowAlertedAt,
+		&i.FulfillmentAdapter,
+		&i.FulfillmentUrl,
+		&i.FulfillmentSecret,
+		&i.Active,
+		&i.Version,
+		&i.CreatedBy,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createRule = `-- name: CreateRule :one
+INSERT INTO rules (id, name, description, config, active, created_by) 
+VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, name, description, config, active, version, created_by, created_at, updated_at
+`
+
+type CreateRuleParams struct {
+	ID          uuid.UUID       `json:"id"`
+	Name        string          `json:"name"`
+	Description sql.NullString  `json:"description"`
+	Config      json.RawMessage `json:"config"`
+	Active      bool            `json:"active"`
+	CreatedBy   uuid.NullUUID   `json:"created_by"`
+}
+
+// Rules queries
+func (q *Queries) CreateRule(ctx context.Context, arg CreateRuleParams) (Rule, error) {
+	row := q.db.QueryRowContext(ctx, createRule,
+		arg.ID,
+		arg.Name,
+		arg.Description,
+		arg.Config,
+		arg.Active,
+		arg.CreatedBy,
+	)
+	var i Rule
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.Config,
+		&i.Active,
+		&i.Version,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const createSegment = `-- name: CreateSegment :one
+INSERT INTO segments (id, name, description, criteria, active, materialized, created_by) 
+VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, name, description, criteria, active, version, materialized, materialized_at, created_by, created_at
+`
+
+type CreateSegmentParams struct {
+	ID           uuid.UUID       `json:"id"`
+	Name         string          `json:"name"`
+	Description  sql.NullString  `json:"description"`
+	Criteria     json.RawMessage `json:"criteria"`
+	Active       bool            `json:"active"`
+	Materialized bool            `json:"materialized"`
+	CreatedBy    uuid.NullUUID   `json:"created_by"`
+}
+
+// Segments queries
+func (q *Queries) CreateSegment(ctx context.Context, arg CreateSegmentParams) (Segment, error) {
+	row := q.db.QueryRowContext(ctx, createSegment,
+		arg.ID,
+		arg.Name,
+		arg.Description,
+		arg.Criteria,
+		arg.Active,
+		arg.Materialized,
+		arg.CreatedBy,
+	)
+	var i Segment
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.Criteria,
+		&i.Active,
+		&i.Version,
+		&i.Materialized,
+		&i.MaterializedAt,
+		&i.CreatedBy,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createUser = `-- name: CreateUser :one
+INSERT INTO users (phone)
+VALUES ($1)
+RETURNING id, phone, created_at
+`
+
+func (q *Queries) CreateUser(ctx context.Context, phone string) (User, error) {
+	row := q.db.QueryRowContext(ctx, createUser, phone)
+	var i User
+	err := row.Scan(&i.ID, &i.Phone, &i.CreatedAt)
+	return i, err
+}
+
+const deleteExpiredPriceQuotes = `-- name: DeleteExpiredPriceQuotes :execrows
+DELETE FROM price_quotes WHERE expires_at < $1
+`
+
+// DeleteExpiredPriceQuotes deletes quotes that expired before a cutoff
+func (q *Queries) DeleteExpiredPriceQuotes(ctx context.Context, expiresAt time.Time) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteExpiredPriceQuotes, expiresAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deletePublishedOutboxEvents = `-- name: DeletePublishedOutboxEvents :execrows
+DELETE FROM event_outbox
+WHERE published_at IS NOT NULL AND published_at < $1::timestamptz
+`
+
+func (q *Queries) DeletePublishedOutboxEvents(ctx context.Context, publishedBefore time.Time) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deletePublishedOutboxEvents, publishedBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteRule = `-- name: DeleteRule :exec
+DELETE FROM rules WHERE id = $1
+`
+
+func (q *Queries) DeleteRule(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, deleteRule, id)
+	return err
+}
+
+const deleteSegmentMember = `-- name: DeleteSegmentMember :exec
+DELETE FROM segment_members WHERE segment_id = $1 AND user_id = $2
+`
+
+type DeleteSegmentMemberParams struct {
+	SegmentID uuid.UUID `json:"segment_id"`
+	UserID    uuid.UUID `json:"user_id"`
+}
+
+func (q *Queries) DeleteSegmentMember(ctx context.Context, arg DeleteSegmentMemberParams) error {
+	_, err := q.db.ExecContext(ctx, deleteSegmentMember, arg.SegmentID, arg.UserID)
+	return err
+}
+
+const deleteStaleSegmentMembers = `-- name: DeleteStaleSegmentMembers :execrows
+DELETE FROM segment_members
+WHERE segment_id = $1 AND refreshed_at < $2::timestamptz
+`
+
+type DeleteStaleSegmentMembersParams struct {
+	SegmentID       uuid.UUID `json:"segment_id"`
+	RefreshedBefore time.Time `json:"refreshed_before"`
+}
+
+// DeleteStaleSegmentMembers removes members a full refresh no longer matched
+func (q *Queries) DeleteStaleSegmentMembers(ctx context.Context, arg DeleteStaleSegmentMembersParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteStaleSegmentMembers, arg.SegmentID, arg.RefreshedBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteUserTier = `-- name: DeleteUserTier :exec
+DELETE FROM user_tiers WHERE user_id = $1
+`
+
+// DeleteUserTier removes the tier of a user who no longer qualifies for any
+func (q *Queries) DeleteUserTier(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, deleteUserTier, userID)
+	return err
+}
+
+const enqueueOutboxEvent = `-- name: EnqueueOutboxEvent :one
+INSERT INTO event_outbox (topic, ordering_key, payload)
+VALUES ($1, $2, $3)
+RETURNING id, topic, ordering_key, payload, attempts, last_error, next_attempt_at, created_at, published_at, dead_at
+`
+
+type EnqueueOutboxEventParams struct {
+	Topic       string          `json:"topic"`
+	OrderingKey string          `json:"ordering_key"`
+	Payload     json.RawMessage `json:"payload"`
+}
+
+// Outbox queries
+func (q *Queries) EnqueueOutboxEvent(ctx context.Context, arg EnqueueOutboxEventParams) (EventOutbox, error) {
+	row := q.db.QueryRowContext(ctx, enqueueOutboxEvent, arg.Topic, arg.OrderingKey, arg.Payload)
+	var i EventOutbox
+	err := row.Scan(
+		&i.ID,
+		&i.Topic,
+		&i.OrderingKey,
+		&i.Payload,
+		&i.Attempts,
+		&i.LastError,
+		&i.NextAttemptAt,
+		&i.CreatedAt,
+		&i.PublishedAt,
+		&i.DeadAt,
+	)
+	return i, err
+}
+
+const expireChargingCredit = `-- name: ExpireChargingCredit :one
+UPDATE charging_credits SET status = 'EXPIRED', refunded_points = $2
+WHERE id = $1 AND status = 'ACTIVE'
+RETURNING id, user_id, redemption_id, amount, remaining, currency, points_spent, status, refunded_points, expires_at, created_at, updated_at
+`
+
+type ExpireChargingCreditParams struct {
+	ID             uuid.UUID `json:"id"`
+	RefundedPoints int32     `json:"refunded_points"`
+}
+
+func (q *Queries) ExpireChargingCredit(ctx context.Context, arg ExpireChargingCreditParams) (ChargingCredit, error) {
+	row := q.db.QueryRowContext(ctx, expireChargingCredit, arg.ID, arg.RefundedPoints)
+	var i ChargingCredit
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.RedemptionID,
+		&i.Amount,
+		&i.Remaining,
+		&i.Currency,
+		&i.PointsSpent,
+		&i.Status,
+		&i.RefundedPoints,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const expireDueRedemptions = `-- name: ExpireDueRedemptions :many
+UPDATE redemptions SET status = 'EXPIRED'
+WHERE id IN (
+    SELECT r.id FROM redemptions r
+    WHERE r.status = 'PENDING' AND r.expires_at <= $1::timestamptz
+      AND NOT EXISTS (SELECT 1 FROM fulfillment_deliveries d
+                      WHERE d.redemption_id = r.id AND d.status = 'PENDING')
+    ORDER BY r.expires_at ASC
+    LIMIT $2::int
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING id, user_id, reward_id, points_spent, status, voucher_code, expires_at, base_cost, price_rule, checkout_id, created_at, updated_at
+`
+
+type ExpireDueRedemptionsParams struct {
+	Now       time.Time `json:"now"`
+	BatchSize int32     `json:"batch_size"`
+}
+
+// ExpireDueRedemptions expires a batch of pending redemptions past their
+// expiry. Redemptions awaiting a partner delivery are left to the fulfillment
+// dispatcher; those locked by a concurrent run are skipped.
+func (q *Queries) ExpireDueRedemptions(ctx context.Context, arg ExpireDueRedemptionsParams) ([]Redemption, error) {
+	rows, err := q.db.QueryContext(ctx, expireDueRedemptions, arg.Now, arg.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Redemption{}
+	for rows.Next() {
+		var i Redemption
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.RewardID,
+			&i.PointsSpent,
+			&i.Status,
+			&i.VoucherCode,
+			&i.ExpiresAt,
+			&i.BaseCost,
+			&i.PriceRule,
+			&i.CheckoutID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const finishFulfillmentDelivery = `-- name: FinishFulfillmentDelivery :execrows
+UPDATE fulfillment_deliveries
+SET status = $1, attempts = attempts + 1, last_error = $2,
+    response_status = $3, locked_until = NULL
+WHERE id = $4 AND status = 'PENDING' AND locked_until = $5::timestamptz
+`
+
+type FinishFulfillmentDeliveryParams struct {
+	Status         string         `json:"status"`
+	LastError      sql.NullString `json:"last_error"`
+	ResponseStatus sql.NullInt32  `json:"response_status"`
+	ID             uuid.UUID      `json:"id"`
+	LockedUntil    time.Time      `json:"locked_until"`
+}
+
+// FinishFulfillmentDelivery records a delivery's final attempt; deliveries
+// whose lease was lost, to a cancellation or another run, affect no row
+func (q *Queries) FinishFulfillmentDelivery(ctx context.Context, arg FinishFulfillmentDeliveryParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, finishFulfillmentDelivery,
+		arg.Status,
+		arg.LastError,
+		arg.ResponseStatus,
+		arg.ID,
+		arg.LockedUntil,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const finishPendingRedemption = `-- name: FinishPendingRedemption :execrows
+UPDATE redemptions SET status = $2
+WHERE id = $1 AND status = 'PENDING'
+`
+
+type FinishPendingRedemptionParams struct {
+	ID     uuid.UUID `json:"id"`
+	Status string    `json:"status"`
+}
+
+// FinishPendingRedemption settles a redemption still pending; redemptions
+// already expired or settled affect no row
+func (q *Queries) FinishPendingRedemption(ctx context.Context, arg FinishPendingRedemptionParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, finishPendingRedemption, arg.ID, arg.Status)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const finishRedemptionPayment = `-- name: FinishRedemptionPayment :one
+UPDATE redemption_payments SET status = $2, failure_reason = $3
+WHERE id = $1
+RETURNING id, redemption_id, provider, intent_id, amount, currency, status, failure_reason, expires_at, created_at, updated_at
+`
+
+type FinishRedemptionPaymentParams struct {
+	ID            uuid.UUID      `json:"id"`
+	Status        string         `json:"status"`
+	FailureReason sql.NullString `json:"failure_reason"`
+}
+
+func (q *Queries) FinishRedemptionPayment(ctx context.Context, arg FinishRedemptionPaymentParams) (RedemptionPayment, error) {
+	row := q.db.QueryRowContext(ctx, finishRedemptionPayment, arg.ID, arg.Status, arg.FailureReason)
+	var i RedemptionPayment
+	err := row.Scan(
+		&i.ID,
+		&i.RedemptionID,
+		&i.Provider,
+		&i.IntentID,
+		&i.Amount,
+		&i.Currency,
+		&i.Status,
+		&i.FailureReason,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getLastUserRewardRedemption = `-- name: GetLastUserRewardRedemption :one
+SELECT created_at FROM redemptions
+WHERE user_id = $1 AND reward_id = $2 AND status IN ('AWAITING_PAYMENT', 'PENDING', 'FULFILLED')
+ORDER BY created_at DESC LIMIT 1
+`
+
+type GetLastUserRewardRedemptionParams struct {
+	UserID   uuid.UUID `json:"user_id"`
+	RewardID uuid.UUID `json:"reward_id"`
+}
+
+func (q *Queries) GetLastUserRewardRedemption(ctx context.Context, arg GetLastUserRewardRedemptionParams) (time.Time, error) {
+	row := q.db.QueryRowContext(ctx, getLastUserRewardRedemption, arg.UserID, arg.RewardID)
+	var createdAt time.Time
+	err := row.Scan(&createdAt)
+	return createdAt, err
+}
+
+const getLatestOTPChallenge = `-- name: GetLatestOTPChallenge :one
+SELECT id, phone, code_hash, attempts, expires_at, verified_at, created_at FROM otp_challenges
+WHERE phone = $1
+ORDER BY created_at DESC
+LIMIT 1
+`
+
+func (q *Queries) GetLatestOTPChallenge(ctx context.Context, phone string) (OtpChallenge, error) {
+	row := q.db.QueryRowContext(ctx, getLatestOTPChallenge, phone)
+	var i OtpChallenge
+	err := row.Scan(
+		&i.ID,
+		&i.Phone,
+		&i.CodeHash,
+		&i.Attempts,
+		&i.ExpiresAt,
+		&i.VerifiedAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getOutboxLag = `-- name: GetOutboxLag :one
+SELECT count(*) AS pending,
+       COALESCE(MIN(created_at), NOW())::timestamptz AS oldest_created_at
+FROM event_outbox
+WHERE topic = $1 AND published_at IS NULL AND dead_at IS NULL
+`
+
+type GetOutboxLagRow struct {
+	Pending         int64     `json:"pending"`
+	OldestCreatedAt time.Time `json:"oldest_created_at"`
+}
+
+func (q *Queries) GetOutboxLag(ctx context.Context, topic string) (GetOutboxLagRow, error) {
+	row := q.db.QueryRowContext(ctx, getOutboxLag, topic)
+	var i GetOutboxLagRow
+	err := row.Scan(&i.Pending, &i.OldestCreatedAt)
+	return i, err
+}
+
+const getPointsEventsByUser = `-- name: GetPointsEventsByUser :many
+SELECT id, user_id, event_type, ref_id, points, meta, occurred_at FROM points_events
+WHERE user_id = $1
+ORDER BY occurred_at DESC
+`
+
+func (q *Queries) GetPointsEventsByUser(ctx context.Context, userID uuid.UUID) ([]PointsEvent, error) {
+	rows, err := q.db.QueryContext(ctx, getPointsEventsByUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []PointsEvent{}
+	for rows.Next() {
+		var i PointsEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.EventType,
+			&i.RefID,
+			&i.Points,
+			&i.Meta,
+			&i.OccurredAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPriceQuote = `-- name: GetPriceQuote :one
+SELECT id, user_id, prices, expires_at, created_at FROM price_quotes
+WHERE id = $1 LIMIT 1
+`
+
+func (q *Queries) GetPriceQuote(ctx context.Context, id uuid.UUID) (PriceQuote, error) {
+	row := q.db.QueryRowContext(ctx, getPriceQuote, id)
+	var i PriceQuote
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Prices,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getRedemption = `-- name: GetRedemption :one
+SELECT id, user_id, reward_id, points_spent, status, voucher_code, expires_at, base_cost, price_rule, checkout_id, created_at, updated_at FROM redemptions
+WHERE id = $1 LIMIT 1
+`
+
+func (q *Queries) GetRedemption(ctx context.Context, id uuid.UUID) (Redemption, error) {
+	row := q.db.QueryRowContext(ctx, getRedemption, id)
+	var i Redemption
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.RewardID,
+		&i.PointsSpent,
+		&i.Status,
+		&i.VoucherCode,
+		&i.ExpiresAt,
+		&i.BaseCost,
+		&i.PriceRule,
+		&i.CheckoutID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getRedemptionChargingCredit = `-- name: GetRedemptionChargingCredit :one
+SELECT id, user_id, redemption_id, amount, remaining, currency, points_spent, status, refunded_points, expires_at, created_at, updated_at FROM charging_credits
+WHERE redemption_id = $1 LIMIT 1
+`
+
+func (q *Queries) GetRedemptionChargingCredit(ctx context.Context, redemptionID uuid.UUID) (ChargingCredit, error) {
+	row := q.db.QueryRowContext(ctx, getRedemptionChargingCredit, redemptionID)
+	var i ChargingCredit
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.RedemptionID,
+		&i.Amount,
+		&i.Remaining,
+		&i.Currency,
+		&i.PointsSpent,
+		&i.Status,
+		&i.RefundedPoints,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getRedemptionPayment = `-- name: GetRedemptionPayment :one
+SELECT id, redemption_id, provider, intent_id, amount, currency, status, failure_reason, expires_at, created_at, updated_at FROM redemption_payments
+WHERE redemption_id = $1 LIMIT 1
+`
+
+func (q *Queries) GetRedemptionPayment(ctx context.Context, redemptionID uuid.UUID) (RedemptionPayment, error) {
+	row := q.db.QueryRowContext(ctx, getRedemptionPayment, redemptionID)
+	var i RedemptionPayment
+	err := row.Scan(
+		&i.ID,
+		&i.RedemptionID,
+		&i.Provider,
+		&i.IntentID,
+		&i.Amount,
+		&i.Currency,
+		&i.Status,
+		&i.FailureReason,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getRedemptionsByUser = `-- name: GetRedemptionsByUser :many
+SELECT id, user_id, reward_id, points_spent, status, voucher_code, expires_at, base_cost, price_rule, checkout_id, created_at, updated_at FROM redemptions
+WHERE user_id = $1
+ORDER BY created_at DESC
+`
+
+func (q *Queries) GetRedemptionsByUser(ctx context.Context, userID uuid.UUID) ([]Redemption, error) {
+	rows, err := q.db.QueryContext(ctx, getRedemptionsByUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Redemption{}
+	for rows.Next() {
+		var i Redemption
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.RewardID,
+			&i.PointsSpent,
+			&i.Status,
+			&i.VoucherCode,
+			&i.ExpiresAt,
+			&i.BaseCost,
+			&i.PriceRule,
+			&i.CheckoutID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getReward = `-- name: GetReward :one
+SELECT id, name, description, cost, segment, min_tier, reward_type, credit_amount, credit_valid_days, stock_total, stock_redeemed, daily_stock, max_per_user, max_per_user_monthly, cooldown_seconds, pending_ttl_seconds, available_from, available_until, available_days, min_points, cash_price, price_rules, category, tags, image_url, terms, partner_name, partner_url, partner_logo_url, sort_order, featured, code_source, code_format, code_low_threshold, code_low_alerted_at, fulfillment_adapter, fulfillment_url, fulfillment_secret, active, version, created_by, created_at FROM rewards_catalog
+WHERE id = $1 LIMIT 1
+`
+
+func (q *Queries) GetReward(ctx context.Context, id uuid.UUID) (RewardsCatalog, error) {
+	row := q.db.QueryRowContext(ctx, getReward, id)
+	var i RewardsCatalog
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.Cost,
+		&i.Segment,
+		&i.MinTier,
+		&i.RewardType,
+		&i.CreditAmount,
+		&i.CreditValidDays,
+		&i.StockTotal,
+		&i.StockRedeemed,
+		&i.DailyStock,
+		&i.MaxPerUser,
+		&i.MaxPerUserMonthly,
+		&i.CooldownSeconds,
+		&i.PendingTtlSeconds,
+		&i.AvailableFrom,
+		&i.AvailableUntil,
+		&i.AvailableDays,
+		&i.MinPoints,
+		&i.CashPrice,
+		&i.PriceRules,
+		&i.Category,
+		&i.Tags,
+		&i.ImageUrl,
+		&i.Terms,
+		&i.PartnerName,
+		&i.PartnerUrl,
+		&i.PartnerLogoUrl,
+		&i.SortOrder,
+		&i.Featured,
+		&i.CodeSource,
+		&i.CodeFormat,
+		&i.CodeLowThreshold,
+		&i.CodeLowAlertedAt,
+		&i.FulfillmentAdapter,
+		&i.FulfillmentUrl,
+		&i.FulfillmentSecret,
+		&i.Active,
+		&i.Version,
+		&i.CreatedBy,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getRewardForUpdate = `-- name: GetRewardForUpdate :one
+SELECT id, name, description, cost, segment, min_tier, reward_type, credit_amount, credit_valid_days, stock_total, stock_redeemed, daily_stock, max_per_user, max_per_user_monthly, cooldown_seconds, pending_ttl_seconds, available_from, available_until, available_days, min_points, cash_price, price_rules, category, tags, image_url, terms, partner_name, partner_url, partner_logo_url, sort_order, featured, code_source, code_format, code_low_threshold, code_low_alerted_at, fulfillment_adapter, fulfillment_url, fulfillment_secret, active, version, created_by, created_at FROM rewards_catalog
+WHERE id = $1
+FOR UPDATE
+`
+
+// GetRewardForUpdate locks a reward for a read-modify-write update
+func (q *Queries) GetRewardForUpdate(ctx context.Context, id uuid.UUID) (RewardsCatalog, error) {
+	row := q.db.QueryRowContext(ctx, getRewardForUpdate, id)
+	var i RewardsCatalog
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.Cost,
+		&i.Segment,
+		&i.MinTier,
+		&i.RewardType,
+		&i.CreditAmount,
+		&i.CreditValidDays,
+		&i.StockTotal,
+		&i.StockRedeemed,
+		&i.DailyStock,
+		&i.MaxPerUser,
+		&i.MaxPerUserMonthly,
+		&i.CooldownSeconds,
+		&i.PendingTtlSeconds,
+		&i.AvailableFrom,
+		&i.AvailableUntil,
+		&i.AvailableDays,
+		&i.MinPoints,
+		&i.CashPrice,
+		&i.PriceRules,
+		&i.Category,
+		&i.Tags,
+		&i.ImageUrl,
+		&i.Terms,
+		&i.PartnerName,
+		&i.PartnerUrl,
+		&i.PartnerLogoUrl,
+		&i.SortOrder,
+		&i.Featured,
+		&i.CodeSource,
+		&i.CodeFormat,
+		&i.CodeLowThreshold,
+		&i.CodeLowAlertedAt,
+		&i.FulfillmentAdapter,
+		&i.FulfillmentUrl,
+		&i.FulfillmentSecret,
+		&i.Active,
+		&i.Version,
+		&i.CreatedBy,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getRewardsCatalog = `-- name: GetRewardsCatalog :many
+SELECT id, name, description, cost, segment, min_tier, reward_type, credit_amount, credit_valid_days, stock_total, stock_redeemed, daily_stock, max_per_user, max_per_user_monthly, cooldown_seconds, pending_ttl_seconds, available_from, available_until, available_days, min_points, cash_price, price_rules, category, tags, image_url, terms, partner_name, partner_url, partner_logo_url, sort_order, featured, code_source, code_format, code_low_threshold, code_low_alerted_at, fulfillment_adapter, fulfillment_url, fulfillment_secret, active, version, created_by, created_at FROM rewards_catalog
+WHERE active = true
+  AND (available_from IS NULL OR available_from <= $1::timestamptz)
+  AND (available_until IS NULL OR available_until > $1::timestamptz)
+  AND (available_days IS NULL
+       OR available_days & (1 << EXTRACT(DOW FROM $1::timestamptz AT TIME ZONE 'UTC')::int) <> 0)
+ORDER BY featured DESC, sort_order ASC, cost ASC
+`
+
+// GetRewardsCatalog lists the active rewards available at now: inside their
+// availability window and on one of their available UTC weekdays
+func (q *Queries) GetRewardsCatalog(ctx context.Context, now time.Time) ([]RewardsCatalog, error) {
+	rows, err := q.db.QueryContext(ctx, getRewardsCatalog, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []RewardsCatalog{}
+	for rows.Next() {
+		var i RewardsCatalog
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Description,
+			&i.Cost,
+			&i.Segment,
+			&i.MinTier,
+			&i.RewardType,
+			&i.CreditAmount,
+			&i.CreditValidDays,
+			&i.StockTotal,
+			&i.StockRedeemed,
+			&i.DailyStock,
+			&i.MaxPerUser,
+			&i.MaxPerUserMonthly,
+			&i.CooldownSeconds,
+			&i.PendingTtlSeconds,
+			&i.AvailableFrom,
+			&i.AvailableUntil,
+			&i.AvailableDays,
+			&i.MinPoints,
+			&i.CashPrice,
+			&i.PriceRules,
+			&i.Category,
+			&i.Tags,
+			&i.ImageUrl,
+			&i.Terms,
+			&i.PartnerName,
+			&i.PartnerUrl,
+			&i.PartnerLogoUrl,
+			&i.SortOrder,
+			&i.Featured,
+			&i.CodeSource,
+			&i.CodeFormat,
+			&i.CodeLowThreshold,
+			&i.CodeLowAlertedAt,
+			&i.FulfillmentAdapter,
+			&i.FulfillmentUrl,
+			&i.FulfillmentSecret,
+			&i.Active,
+			&i.Version,
+			&i.CreatedBy,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRule = `-- name: GetRule :one
+SELECT id, name, description, config, active, version, created_by, created_at, updated_at FROM rules WHERE id = $1
+`
+
+func (q *Queries) GetRule(ctx context.Context, id uuid.UUID) (Rule, error) {
+	row := q.db.QueryRowContext(ctx, getRule, id)
+	var i Rule
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.Config,
+		&i.Active,
+		&i.Version,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getSegment = `-- name: GetSegment :one
+SELECT id, name, description, criteria, active, version, materialized, materialized_at, created_by, created_at FROM segments WHERE id = $1
+`
+
+func (q *Queries) GetSegment(ctx context.Context, id uuid.UUID) (Segment, error) {
+	row := q.db.QueryRowContext(ctx, getSegment, id)
+	var i Segment
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.Criteria,
+		&i.Active,
+		&i.Version,
+		&i.Materialized,
+		&i.MaterializedAt,
+		&i.CreatedBy,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getSegmentByName = `-- name: GetSegmentByName :one
+SELECT id, name, description, criteria, active, version, materialized, materialized_at, created_by, created_at FROM segments WHERE name = $1
+`
+
+// Segment queries
+func (q *Queries) GetSegmentByName(ctx context.Context, name string) (Segment, error) {
+	row := q.db.QueryRowContext(ctx, getSegmentByName, name)
+	var i Segment
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.Criteria,
+		&i.Active,
+		&i.Version,
+		&i.Materialized,
+		&i.MaterializedAt,
+		&i.CreatedBy,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getUser = `-- name: GetUser :one
+SELECT id, phone, created_at FROM users
+WHERE id = $1 LIMIT 1
+`
+
+func (q *Queries) GetUser(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUser, id)
+	var i User
+	err := row.Scan(&i.ID, &i.Phone, &i.CreatedAt)
+	return i, err
+}
+
+const getUserByPhone = `-- name: GetUserByPhone :one
+SELECT id, phone, created_at FROM users
+WHERE phone = $1 LIMIT 1
+`
+
+func (q *Queries) GetUserByPhone(ctx context.Context, phone string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByPhone, phone)
+	var i User
+	err := row.Scan(&i.ID, &i.Phone, &i.CreatedAt)
+	return i, err
+}
+
+const getUserPointsBalance = `-- name: GetUserPointsBalance :one
+SELECT COALESCE(SUM(points), 0)::bigint as balance
+FROM points_events
+WHERE user_id = $1
+`
+
+func (q *Queries) GetUserPointsBalance(ctx context.Context, userID uuid.UUID) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getUserPointsBalance, userID)
+	var balance int64
+	err := row.Scan(&balance)
+	return balance, err
+}
+
+const getUserProfile = `-- name: GetUserProfile :one
+SELECT user_id, name, email, locale, timezone, city, vehicle_type, vehicle_make, vehicle_model, battery_kwh, preferred_language, attributes, created_at, updated_at FROM user_profiles WHERE user_id = $1
+`
+
+func (q *Queries) GetUserProfile(ctx context.Context, userID uuid.UUID) (UserProfile, error) {
+	row := q.db.QueryRowContext(ctx, getUserProfile, userID)
+	var i UserProfile
+	err := row.Scan(
+		&i.UserID,
+		&i.Name,
+		&i.Email,
+		&i.Locale,
+		&i.Timezone,
+		&i.City,
+		&i.VehicleType,
+		&i.VehicleMake,
+		&i.VehicleModel,
+		&i.BatteryKwh,
+		&i.PreferredLanguage,
+		&i.Attributes,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getUserSegmentFacts = `-- name: GetUserSegmentFacts :one
+SELECT u.id, u.created_at,
+       COALESCE((SELECT SUM(pe.points) FROM points_events pe WHERE pe.user_id = u.id), 0)::bigint AS balance,
+       COALESCE((SELECT SUM((pe.meta->>'kwh')::double precision) FROM points_events pe
+                 WHERE pe.user_id = u.id AND pe.event_type = 'CHARGE_KWH'), 0)::double precision AS lifetime_kwh,
+       p.city, p.vehicle_type, p.vehicle_make, p.vehicle_model, p.battery_kwh,
+       p.locale, p.timezone, p.preferred_language,
+       COALESCE(p.attributes, '{}')::jsonb AS attributes,
+       t.tier
+FROM users u
+LEFT JOIN user_profiles p ON p.user_id = u.id
+LEFT JOIN user_tiers t ON t.user_id = u.id
+WHERE u.id = $1
+`
+
+type GetUserSegmentFactsRow struct {
+	ID                uuid.UUID       `json:"id"`
+	CreatedAt         time.Time       `json:"created_at"`
+	Balance           int64           `json:"balance"`
+	LifetimeKwh       float64         `json:"lifetime_kwh"`
+	City              sql.NullString  `json:"city"`
+	VehicleType       sql.NullString  `json:"vehicle_type"`
+	VehicleMake       sql.NullString  `json:"vehicle_make"`
+	VehicleModel      sql.NullString  `json:"vehicle_model"`
+	BatteryKwh        sql.NullFloat64 `json:"battery_kwh"`
+	Locale            sql.NullString  `json:"locale"`
+	Timezone          sql.NullString  `json:"timezone"`
+	PreferredLanguage sql.NullString  `json:"preferred_language"`
+	Attributes        json.RawMessage `json:"attributes"`
+	Tier              sql.NullString  `json:"tier"`
+}
+
+// GetUserSegmentFacts returns the ledger-derived and profile facts segment criteria are evaluated against
+func (q *Queries) GetUserSegmentFacts(ctx context.Context, id uuid.UUID) (GetUserSegmentFactsRow, error) {
+	row := q.db.QueryRowContext(ctx, getUserSegmentFacts, id)
+	var i GetUserSegmentFactsRow
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.Balance,
+		&i.LifetimeKwh,
+		&i.City,
+		&i.VehicleType,
+		&i.VehicleMake,
+		&i.VehicleModel,
+		&i.BatteryKwh,
+		&i.Locale,
+		&i.Timezone,
+		&i.PreferredLanguage,
+		&i.Attributes,
+		&i.Tier,
+	)
+	return i, err
+}
+
+const getUserTier = `-- name: GetUserTier :one
+SELECT user_id, tier, tier_since, rolling_kwh, rolling_points, downgrade_at, evaluated_at FROM user_tiers WHERE user_id = $1
+`
+
+// Loyalty tier queries
+func (q *Queries) GetUserTier(ctx context.Context, userID uuid.UUID) (UserTier, error) {
+	row := q.db.QueryRowContext(ctx, getUserTier, userID)
+	var i UserTier
+	err := row.Scan(
+		&i.UserID,
+		&i.Tier,
+		&i.TierSince,
+		&i.RollingKwh,
+		&i.RollingPoints,
+		&i.DowngradeAt,
+		&i.EvaluatedAt,
+	)
+	return i, err
+}
+
+const getUserTierStats = `-- name: GetUserTierStats :one
+SELECT COALESCE(SUM(points) FILTER (WHERE points > 0 AND event_type NOT IN ('REDEMPTION_REFUND', 'CREDIT_REFUND')), 0)::bigint AS earned_points,
+       COALESCE(SUM((meta->>'kwh')::double precision) FILTER (WHERE event_type = 'CHARGE_KWH'), 0)::double precision AS kwh
+FROM points_events
+WHERE user_id = $1 AND created_at >= $2::timestamptz
+`
+
+type GetUserTierStatsParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	Since  time.Time `json:"since"`
+}
+
+type GetUserTierStatsRow struct {
+	EarnedPoints int64   `json:"earned_points"`
+	Kwh          float64 `json:"kwh"`
+}
+
+// GetUserTierStats returns what a user charged and earned since the start of the qualification window.
+// Refunds of redemptions and credits give back spent points, so they do not count as earned.
+func (q *Queries) GetUserTierStats(ctx context.Context, arg GetUserTierStatsParams) (GetUserTierStatsRow, error) {
+	row := q.db.QueryRowContext(ctx, getUserTierStats, arg.UserID, arg.Since)
+	var i GetUserTierStatsRow
+	err := row.Scan(&i.EarnedPoints, &i.Kwh)
+	return i, err
+}
+
+const isSegmentMember = `-- name: IsSegmentMember :one
+SELECT EXISTS (
+    SELECT 1 FROM segment_members WHERE segment_id = $1 AND user_id = $2
+) AS member
+`
+
+type IsSegmentMemberParams struct {
+	SegmentID uuid.UUID `json:"segment_id"`
+	UserID    uuid.UUID `json:"user_id"`
+}
+
+func (q *Queries) IsSegmentMember(ctx context.Context, arg IsSegmentMemberParams) (bool, error) {
+	row := q.db.QueryRowContext(ctx, isSegmentMember, arg.SegmentID, arg.UserID)
+	var member bool
+	err := row.Scan(&member)
+	return member, err
+}
+
+const issueRewardCode = `-- name: IssueRewardCode :execrows
+INSERT INTO reward_codes (reward_id, code, redemption_id, claimed_at) VALUES ($1, $2, $3, NOW())
+ON CONFLICT (reward_id, code) DO NOTHING
+`
+
+type IssueRewardCodeParams struct {
+	RewardID     uuid.UUID     `json:"reward_id"`
+	Code         string        `json:"code"`
+	RedemptionID uuid.NullUUID `json:"redemption_id"`
+}
+
+// IssueRewardCode records a generated code as claimed by a redemption; no row
+// is inserted when the reward already issued the code
+func (q *Queries) IssueRewardCode(ctx context.Context, arg IssueRewardCodeParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, issueRewardCode, arg.RewardID, arg.Code, arg.RedemptionID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const listAuditLog = `-- name: ListAuditLog :many
+SELECT id, actor_id, actor_email, action, entity_type, entity_id, before, after, diff, request_id, ip, created_at FROM admin_audit_log
+WHERE ($1::uuid IS NULL OR actor_id = $1)
+  AND ($2::text IS NULL OR entity_type = $2)
+  AND ($3::uuid IS NULL OR entity_id = $3)
+  AND ($4::text IS NULL OR action = $4)
+  AND ($5::timestamptz IS NULL OR created_at >= $5)
+  AND ($6::timestamptz IS NULL OR created_at < $6)
+ORDER BY created_at DESC
+LIMIT $7 OFFSET $8
+`
+
+type ListAuditLogParams struct {
+	ActorID    uuid.NullUUID  `json:"actor_id"`
+	EntityType sql.NullString `json:"entity_type"`
+	EntityID   uuid.NullUUID  `json:"entity_id"`
+	Action     sql.NullString `json:"action"`
+	Since      sql.NullTime   `json:"since"`
+	Until      sql.NullTime   `json:"until"`
+	RowLimit   int32          `json:"row_limit"`
+	RowOffset  int32          `json:"row_offset"`
+}
+
+func (q *Queries) ListAuditLog(ctx context.Context, arg ListAuditLogParams) ([]AdminAuditLog, error) {
+	rows, err := q.db.QueryContext(ctx, listAuditLog,
+		arg.ActorID,
+		arg.EntityType,
+		arg.EntityID,
+		arg.Action,
+		arg.Since,
+		arg.Until,
+		arg.RowLimit,
+		arg.RowOffset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []AdminAuditLog{}
+	for rows.Next() {
+		var i AdminAuditLog
+		if err := rows.Scan(
+			&i.ID,
+			&i.ActorID,
+			&i.ActorEmail,
+			&i.Action,
+			&i.EntityType,
+			&i.EntityID,
+			&i.Before,
+			&i.After,
+			&i.Diff,
+			&i.RequestID,
+			&i.Ip,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listDailyRewardRedemptions = `-- name: ListDailyRewardRedemptions :many
+SELECT reward_id, redeemed FROM reward_daily_redemptions WHERE day = $1
+`
+
+type ListDailyRewardRedemptionsRow struct {
+	RewardID uuid.UUID `json:"reward_id"`
+	Redeemed int32     `json:"redeemed"`
+}
+
+func (q *Queries) ListDailyRewardRedemptions(ctx context.Context, day time.Time) ([]ListDailyRewardRedemptionsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listDailyRewardRedemptions, day)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListDailyRewardRedemptionsRow{}
+	for rows.Next() {
+		var i ListDailyRewardRedemptionsRow
+		if err := rows.Scan(&i.RewardID, &i.Redeemed); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listDueChargingCredits = `-- name: ListDueChargingCredits :many
+SELECT id, user_id, redemption_id, amount, remaining, currency, points_spent, status, refunded_points, expires_at, created_at, updated_at FROM charging_credits
+WHERE status = 'ACTIVE' AND expires_at <= $1::timestamptz
+ORDER BY expires_at ASC
+LIMIT $2::int
+FOR UPDATE SKIP LOCKED
+`
+
+type ListDueChargingCreditsParams struct {
+	Now       time.Time `json:"now"`
+	BatchSize int32     `json:"batch_size"`
+}
+
+// ListDueChargingCredits claims a batch of active credits past their expiry;
+// credits locked by a concurrent run are skipped
+func (q *Queries) ListDueChargingCredits(ctx context.Context, arg ListDueChargingCreditsParams) ([]ChargingCredit, error) {
+	rows, err := q.db.QueryContext(ctx, listDueChargingCredits, arg.Now, arg.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ChargingCredit{}
+	for rows.Next() {
+		var i ChargingCredit
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.RedemptionID,
+			&i.Amount,
+			&i.Remaining,
+			&i.Currency,
+			&i.PointsSpent,
+			&i.Status,
+			&i.RefundedPoints,
+			&i.ExpiresAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMaterializedSegments = `-- name: ListMaterializedSegments :many
+SELECT id, name, description, criteria, active, version, materialized, materialized_at, created_by, created_at FROM segments WHERE materialized = true AND active = true ORDER BY name
+`
+
+func (q *Queries) ListMaterializedSegments(ctx context.Context) ([]Segment, error) {
+	rows, err := q.db.QueryContext(ctx, listMaterializedSegments)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Segment{}
+	for rows.Next() {
+		var i Segment
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Description,
+			&i.Criteria,
+			&i.Active,
+			&i.Version,
+			&i.Materialized,
+			&i.MaterializedAt,
+			&i.CreatedBy,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOpenRedemptionPayments = `-- name: ListOpenRedemptionPayments :many
+SELECT id, redemption_id, provider, intent_id, amount, currency, status, failure_reason, expires_at, created_at, updated_at FROM redemption_payments
+WHERE status = 'REQUIRES_PAYMENT' AND id > $1::uuid
+ORDER BY id ASC
+LIMIT $2::int
 `
 
-func (q *Queries) DeleteRule(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.ExecContext(ctx, deleteRule, id)
-	return err
+type ListOpenRedemptionPaymentsParams struct {
+	After     uuid.UUID `json:"after"`
+	BatchSize int32     `json:"batch_size"`
 }
 
-const getPendingRedemptionsOlderThan = `-- name: GetPendingRedemptionsOlderThan :many
-SELECT id, user_id, reward_id, points_spent, status, created_at, updated_at FROM redemptions
-WHERE status = 'PENDING' AND created_at < $1
-ORDER BY created_at ASC
-`
-
-func (q *Queries) GetPendingRedemptionsOlderThan(ctx context.Context, createdAt time.Time) ([]Redemption, error) {
-	rows, err := q.db.QueryContext(ctx, getPendingRedemptionsOlderThan, createdAt)
+// ListOpenRedemptionPayments lists a batch of unpaid payments after a cursor
+// to check with the provider; each is locked again before it is settled
+func (q *Queries) ListOpenRedemptionPayments(ctx context.Context, arg ListOpenRedemptionPaymentsParams) ([]RedemptionPayment, error) {
+	rows, err := q.db.QueryContext(ctx, listOpenRedemptionPayments, arg.After, arg.BatchSize)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []Redemption{}
+	items := []RedemptionPayment{}
 	for rows.Next() {
-		var i Redemption
+		var i RedemptionPayment
 		if err := rows.Scan(
 			&i.ID,
-			&i.UserID,
-			&i.RewardID,
-			&i.PointsSpent,
+			&i.RedemptionID,
+			&i.Provider,
+			&i.IntentID,
+			&i.Amount,
+			&i.Currency,
 			&i.Status,
+			&i.FailureReason,
+			&i.ExpiresAt,
 			&i.CreatedAt,
 			&i.UpdatedAt,
 		); err != nil {
@@ -250,28 +2248,25 @@ func (q *Queries) GetPendingRedemptionsOlderThan(ctx context.Context, createdAt
 	return items, nil
 }
 
-const getPointsEventsByUser = `-- name: GetPointsEventsByUser :many
-SELECT id, user_id, event_type, ref_id, points, meta, occurred_at FROM points_events
-WHERE user_id = $1
-ORDER BY occurred_at DESC
+const listRedemptionStatusEvents = `-- name: ListRedemptionStatusEvents :many
+SELECT id, redemption_id, status, occurred_at FROM redemption_status_events
+WHERE redemption_id = $1
+ORDER BY occurred_at ASC
 `
 
-func (q *Queries) GetPointsEventsByUser(ctx context.Context, userID uuid.UUID) ([]PointsEvent, error) {
-	rows, err := q.db.QueryContext(ctx, getPointsEventsByUser, userID)
+func (q *Queries) ListRedemptionStatusEvents(ctx context.Context, redemptionID uuid.UUID) ([]RedemptionStatusEvent, error) {
+	rows, err := q.db.QueryContext(ctx, listRedemptionStatusEvents, redemptionID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []PointsEvent{}
+	items := []RedemptionStatusEvent{}
 	for rows.Next() {
-		var i PointsEvent
+		var i RedemptionStatusEvent
 		if err := rows.Scan(
 			&i.ID,
-			&i.UserID,
-			&i.EventType,
-			&i.RefID,
-			&i.Points,
-			&i.Meta,
+			&i.RedemptionID,
+			&i.Status,
 			&i.OccurredAt,
 		); err != nil {
 			return nil, err
@@ -287,47 +2282,98 @@ func (q *Queries) GetPointsEventsByUser(ctx context.Context, userID uuid.UUID) (
 	return items, nil
 }
 
-const getRedemption = `-- name: GetRedemption :one
-SELECT id, user_id, reward_id, points_spent, status, created_at, updated_at FROM redemptions
-WHERE id = $1 LIMIT 1
+const listRewards = `-- name: ListRewards :many
+SELECT id, name, description, cost, segment, min_tier, reward_type, credit_amount, credit_valid_days, stock_total, stock_redeemed, daily_stock, max_per_user, max_per_user_monthly, cooldown_seconds, pending_ttl_seconds, available_from, available_until, available_days, min_points, cash_price, price_rules, category, tags, image_url, terms, partner_name, partner_url, partner_logo_url, sort_order, featured, code_source, code_format, code_low_threshold, code_low_alerted_at, fulfillment_adapter, fulfillment_url, fulfillment_secret, active, version, created_by, created_at FROM rewards_catalog WHERE active = $1 ORDER BY created_at DESC
 `
 
-func (q *Queries) GetRedemption(ctx context.Context, id uuid.UUID) (Redemption, error) {
-	row := q.db.QueryRowContext(ctx, getRedemption, id)
-	var i Redemption
-	err := row.Scan(
-		&i.ID,
-		&i.UserID,
-		&i.RewardID,
-		&i.PointsSpent,
-		&i.Status,
-		&i.CreatedAt,
-		&i.UpdatedAt,
-	)
-	return i, err
+// Enhanced rewards queries
+func (q *Queries) ListRewards(ctx context.Context, active bool) ([]RewardsCatalog, error) {
+	rows, err := q.db.QueryContext(ctx, listRewards, active)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []RewardsCatalog{}
+	for rows.Next() {
+		var i RewardsCatalog
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Description,
+			&i.Cost,
+			&i.Segment,
+			&i.MinTier,
+			&i.RewardType,
+			&i.CreditAmount,
+			&i.CreditValidDays,
+			&i.StockTotal,
+			&i.StockRedeemed,
+			&i.DailyStock,
+			&i.MaxPerUser,
+			&i.MaxPerUserMonthly,
+			&i.CooldownSeconds,
+			&i.PendingTtlSeconds,
+			&i.AvailableFrom,
+			&i.AvailableUntil,
+			&i.AvailableDays,
+			&i.MinPoints,
+			&i.CashPrice,
+			&i.PriceRules,
+			&i.Category,
+			&i.Tags,
+			&i.ImageUrl,
+			&i.Terms,
+			&i.PartnerName,
+			&i.PartnerUrl,
+			&i.PartnerLogoUrl,
+			&i.SortOrder,
+			&i.Featured,
+			&i.CodeSource,
+			&i.CodeFormat,
+			&i.CodeLowThreshold,
+			&i.CodeLowAlertedAt,
+			&i.FulfillmentAdapter,
+			&i.FulfillmentUrl,
+			&i.FulfillmentSecret,
+			&i.Active,
+			&i.Version,
+			&i.CreatedBy,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-const getRedemptionsByUser = `-- name: GetRedemptionsByUser :many
-SELECT id, user_id, reward_id, points_spent, status, created_at, updated_at FROM redemptions
-WHERE user_id = $1
-ORDER BY created_at DESC
+const listRules = `-- name: ListRules :many
+SELECT id, name, description, config, active, version, created_by, created_at, updated_at FROM rules ORDER BY created_at DESC
 `
 
-func (q *Queries) GetRedemptionsByUser(ctx context.Context, userID uuid.UUID) ([]Redemption, error) {
-	rows, err := q.db.QueryContext(ctx, getRedemptionsByUser, userID)
+func (q *Queries) ListRules(ctx context.Context) ([]Rule, error) {
+	rows, err := q.db.QueryContext(ctx, listRules)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []Redemption{}
+	items := []Rule{}
 	for rows.Next() {
-		var i Redemption
+		var i Rule
 		if err := rows.Scan(
 			&i.ID,
-			&i.UserID,
-			&i.RewardID,
-			&i.PointsSpent,
-			&i.Status,
+			&i.Name,
+			&i.Description,
+			&i.Config,
+			&i.Active,
+			&i.Version,
+			&i.CreatedBy,
 			&i.CreatedAt,
 			&i.UpdatedAt,
 		); err != nil {
@@ -344,49 +2390,107 @@ func (q *Queries) GetRedemptionsByUser(ctx context.Context, userID uuid.UUID) ([
 	return items, nil
 }
 
-const getReward = `-- name: GetReward :one
-SELECT id, name, description, cost, segment, active, created_by, created_at FROM rewards_catalog
-WHERE id = $1 LIMIT 1
+const listSegmentMemberFacts = `-- name: ListSegmentMemberFacts :many
+SELECT u.id, u.created_at,
+       COALESCE((SELECT SUM(pe.points) FROM points_events pe WHERE pe.user_id = u.id), 0)::bigint AS balance,
+       COALESCE((SELECT SUM((pe.meta->>'kwh')::double precision) FROM points_events pe
+                 WHERE pe.user_id = u.id AND pe.event_type = 'CHARGE_KWH'), 0)::double precision AS lifetime_kwh,
+       p.city, p.vehicle_type, p.vehicle_make, p.vehicle_model, p.battery_kwh,
+       p.locale, p.timezone, p.preferred_language,
+       COALESCE(p.attributes, '{}')::jsonb AS attributes,
+       t.tier
+FROM segment_members sm
+JOIN users u ON u.id = sm.user_id
+LEFT JOIN user_profiles p ON p.user_id = u.id
+LEFT JOIN user_tiers t ON t.user_id = u.id
+WHERE sm.segment_id = $1
+ORDER BY u.id
+LIMIT $2::int
 `
 
-func (q *Queries) GetReward(ctx context.Context, id uuid.UUID) (RewardsCatalog, error) {
-	row := q.db.QueryRowContext(ctx, getReward, id)
-	var i RewardsCatalog
-	err := row.Scan(
-		&i.ID,
-		&i.Name,
-		&i.Description,
-		&i.Cost,
-		&i.Segment,
-		&i.Active,
-		&i.CreatedBy,
-		&i.CreatedAt,
-	)
-	return i, err
+type ListSegmentMemberFactsParams struct {
+	SegmentID  uuid.UUID `json:"segment_id"`
+	SampleSize int32     `json:"sample_size"`
 }
 
-const getRewardsCatalog = `-- name: GetRewardsCatalog :many
-SELECT id, name, description, cost, segment, active, created_by, created_at FROM rewards_catalog
-WHERE active = true
-ORDER BY cost ASC
+type ListSegmentMemberFactsRow struct {
+	ID                uuid.UUID       `json:"id"`
+	CreatedAt         time.Time       `json:"created_at"`
+	Balance           int64           `json:"balance"`
+	LifetimeKwh       float64         `json:"lifetime_kwh"`
+	City              sql.NullString  `json:"city"`
+	VehicleType       sql.NullString  `json:"vehicle_type"`
+	VehicleMake       sql.NullString  `json:"vehicle_make"`
+	VehicleModel      sql.NullString  `json:"vehicle_model"`
+	BatteryKwh        sql.NullFloat64 `json:"battery_kwh"`
+	Locale            sql.NullString  `json:"locale"`
+	Timezone          sql.NullString  `json:"timezone"`
+	PreferredLanguage sql.NullString  `json:"preferred_language"`
+	Attributes        json.RawMessage `json:"attributes"`
+	Tier              sql.NullString  `json:"tier"`
+}
+
+// ListSegmentMemberFacts returns the segment facts of a sample of a materialized segment's members
+func (q *Queries) ListSegmentMemberFacts(ctx context.Context, arg ListSegmentMemberFactsParams) ([]ListSegmentMemberFactsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listSegmentMemberFacts, arg.SegmentID, arg.SampleSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListSegmentMemberFactsRow{}
+	for rows.Next() {
+		var i ListSegmentMemberFactsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.Balance,
+			&i.LifetimeKwh,
+			&i.City,
+			&i.VehicleType,
+			&i.VehicleMake,
+			&i.VehicleModel,
+			&i.BatteryKwh,
+			&i.Locale,
+			&i.Timezone,
+			&i.PreferredLanguage,
+			&i.Attributes,
+			&i.Tier,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSegments = `-- name: ListSegments :many
+SELECT id, name, description, criteria, active, version, materialized, materialized_at, created_by, created_at FROM segments ORDER BY created_at DESC
 `
 
-func (q *Queries) GetRewardsCatalog(ctx context.Context) ([]RewardsCatalog, error) {
-	rows, err := q.db.QueryContext(ctx, getRewardsCatalog)
+func (q *Queries) ListSegments(ctx context.Context) ([]Segment, error) {
+	rows, err := q.db.QueryContext(ctx, listSegments)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []RewardsCatalog{}
+	items := []Segment{}
 	for rows.Next() {
-		var i RewardsCatalog
+		var i Segment
 		if err := rows.Scan(
 			&i.ID,
 			&i.Name,
 			&i.Description,
-			&i.Cost,
-			&i.Segment,
+			&i.Criteria,
 			&i.Active,
+			&i.Version,
+			&i.Materialized,
+			&i.MaterializedAt,
 			&i.CreatedBy,
 			&i.CreatedAt,
 		); err != nil {
@@ -403,105 +2507,233 @@ func (q *Queries) GetRewardsCatalog(ctx context.Context) ([]RewardsCatalog, erro
 	return items, nil
 }
 
-const getRule = `-- name: GetRule :one
-SELECT id, name, description, config, active, created_by, created_at, updated_at FROM rules WHERE id = $1
+const listSessionCreditConsumptions = `-- name: ListSessionCreditConsumptions :many
+SELECT id, credit_id, user_id, session_id, amount, created_at FROM credit_consumptions
+WHERE user_id = $1 AND session_id = $2
+ORDER BY created_at ASC, id ASC
 `
 
-func (q *Queries) GetRule(ctx context.Context, id uuid.UUID) (Rule, error) {
-	row := q.db.QueryRowContext(ctx, getRule, id)
-	var i Rule
-	err := row.Scan(
-		&i.ID,
-		&i.Name,
-		&i.Description,
-		&i.Config,
-		&i.Active,
-		&i.CreatedBy,
-		&i.CreatedAt,
-		&i.UpdatedAt,
-	)
-	return i, err
+type ListSessionCreditConsumptionsParams struct {
+	UserID    uuid.UUID `json:"user_id"`
+	SessionID string    `json:"session_id"`
 }
 
-const getSegment = `-- name: GetSegment :one
-SELECT id, name, description, criteria, active, created_by, created_at FROM segments WHERE id = $1
+func (q *Queries) ListSessionCreditConsumptions(ctx context.Context, arg ListSessionCreditConsumptionsParams) ([]CreditConsumption, error) {
+	rows, err := q.db.QueryContext(ctx, listSessionCreditConsumptions, arg.UserID, arg.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []CreditConsumption{}
+	for rows.Next() {
+		var i CreditConsumption
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreditID,
+			&i.UserID,
+			&i.SessionID,
+			&i.Amount,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUserChargingCredits = `-- name: ListUserChargingCredits :many
+SELECT id, user_id, redemption_id, amount, remaining, currency, points_spent, status, refunded_points, expires_at, created_at, updated_at FROM charging_credits
+WHERE user_id = $1 AND status = 'ACTIVE' AND expires_at > $2::timestamptz
+ORDER BY expires_at ASC, created_at ASC
 `
 
-func (q *Queries) GetSegment(ctx context.Context, id uuid.UUID) (Segment, error) {
-	row := q.db.QueryRowContext(ctx, getSegment, id)
-	var i Segment
-	err := row.Scan(
-		&i.ID,
-		&i.Name,
-		&i.Description,
-		&i.Criteria,
-		&i.Active,
-		&i.CreatedBy,
-		&i.CreatedAt,
-	)
-	return i, err
+type ListUserChargingCreditsParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	Now    time.Time `json:"now"`
 }
 
-const getUser = `-- name: GetUser :one
-SELECT id, phone, created_at FROM users
-WHERE id = $1 LIMIT 1
+func (q *Queries) ListUserChargingCredits(ctx context.Context, arg ListUserChargingCreditsParams) ([]ChargingCredit, error) {
+	rows, err := q.db.QueryContext(ctx, listUserChargingCredits, arg.UserID, arg.Now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ChargingCredit{}
+	for rows.Next() {
+		var i ChargingCredit
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.RedemptionID,
+			&i.Amount,
+			&i.Remaining,
+			&i.Currency,
+			&i.PointsSpent,
+			&i.Status,
+			&i.RefundedPoints,
+			&i.ExpiresAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUserRedemptions = `-- name: ListUserRedemptions :many
+SELECT r.id, r.reward_id, r.points_spent, r.base_cost, r.status, r.voucher_code, r.expires_at, r.created_at, r.updated_at,
+       c.name AS reward_name, c.reward_type
+FROM redemptions r
+JOIN rewards_catalog c ON c.id = r.reward_id
+WHERE r.user_id = $1
+  AND ($2::text IS NULL OR r.status = $2)
+ORDER BY r.created_at DESC, r.id DESC
+LIMIT $3::int OFFSET $4::int
 `
 
-func (q *Queries) GetUser(ctx context.Context, id uuid.UUID) (User, error) {
-	row := q.db.QueryRowContext(ctx, getUser, id)
-	var i User
-	err := row.Scan(&i.ID, &i.Phone, &i.CreatedAt)
-	return i, err
+type ListUserRedemptionsParams struct {
+	UserID    uuid.UUID      `json:"user_id"`
+	Status    sql.NullString `json:"status"`
+	RowLimit  int32          `json:"row_limit"`
+	RowOffset int32          `json:"row_offset"`
+}
+
+type ListUserRedemptionsRow struct {
+	ID          uuid.UUID      `json:"id"`
+	RewardID    uuid.UUID      `json:"reward_id"`
+	PointsSpent int32          `json:"points_spent"`
+	BaseCost    sql.NullInt32  `json:"base_cost"`
+	Status      string         `json:"status"`
+	VoucherCode sql.NullString `json:"voucher_code"`
+	ExpiresAt   time.Time      `json:"expires_at"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	RewardName  string         `json:"reward_name"`
+	RewardType  string         `json:"reward_type"`
+}
+
+// Redemption history queries
+// ListUserRedemptions pages through a user's redemptions, newest first,
+// optionally limited to one status
+func (q *Queries) ListUserRedemptions(ctx context.Context, arg ListUserRedemptionsParams) ([]ListUserRedemptionsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listUserRedemptions,
+		arg.UserID,
+		arg.Status,
+		arg.RowLimit,
+		arg.RowOffset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListUserRedemptionsRow{}
+	for rows.Next() {
+		var i ListUserRedemptionsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.RewardID,
+			&i.PointsSpent,
+			&i.BaseCost,
+			&i.Status,
+			&i.VoucherCode,
+			&i.ExpiresAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.RewardName,
+			&i.RewardType,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-const getUserByPhone = `-- name: GetUserByPhone :one
-SELECT id, phone, created_at FROM users
-WHERE phone = $1 LIMIT 1
+const listUserSegmentFacts = `-- name: ListUserSegmentFacts :many
+SELECT u.id, u.created_at,
+       COALESCE((SELECT SUM(pe.points) FROM points_events pe WHERE pe.user_id = u.id), 0)::bigint AS balance,
+       COALESCE((SELECT SUM((pe.meta->>'kwh')::double precision) FROM points_events pe
+                 WHERE pe.user_id = u.id AND pe.event_type = 'CHARGE_KWH'), 0)::double precision AS lifetime_kwh,
+       p.city, p.vehicle_type, p.vehicle_make, p.vehicle_model, p.battery_kwh,
+       p.locale, p.timezone, p.preferred_language,
+       COALESCE(p.attributes, '{}')::jsonb AS attributes,
+       t.tier
+FROM users u
+LEFT JOIN user_profiles p ON p.user_id = u.id
+LEFT JOIN user_tiers t ON t.user_id = u.id
+WHERE u.id > $1::uuid
+ORDER BY u.id
+LIMIT $2::int
 `
 
-func (q *Queries) GetUserByPhone(ctx context.Context, phone string) (User, error) {
-	row := q.db.QueryRowContext(ctx, getUserByPhone, phone)
-	var i User
-	err := row.Scan(&i.ID, &i.Phone, &i.CreatedAt)
-	return i, err
+type ListUserSegmentFactsParams struct {
+	AfterID   uuid.UUID `json:"after_id"`
+	BatchSize int32     `json:"batch_size"`
 }
 
-const getUserPointsBalance = `-- name: GetUserPointsBalance :one
-SELECT COALESCE(SUM(points), 0)::bigint as balance
-FROM points_events
-WHERE user_id = $1
-`
-
-func (q *Queries) GetUserPointsBalance(ctx context.Context, userID uuid.UUID) (int64, error) {
-	row := q.db.QueryRowContext(ctx, getUserPointsBalance, userID)
-	var balance int64
-	err := row.Scan(&balance)
-	return balance, err
+type ListUserSegmentFactsRow struct {
+	ID                uuid.UUID       `json:"id"`
+	CreatedAt         time.Time       `json:"created_at"`
+	Balance           int64           `json:"balance"`
+	LifetimeKwh       float64         `json:"lifetime_kwh"`
+	City              sql.NullString  `json:"city"`
+	VehicleType       sql.NullString  `json:"vehicle_type"`
+	VehicleMake       sql.NullString  `json:"vehicle_make"`
+	VehicleModel      sql.NullString  `json:"vehicle_model"`
+	BatteryKwh        sql.NullFloat64 `json:"battery_kwh"`
+	Locale            sql.NullString  `json:"locale"`
+	Timezone          sql.NullString  `json:"timezone"`
+	PreferredLanguage sql.NullString  `json:"preferred_language"`
+	Attributes        json.RawMessage `json:"attributes"`
+	Tier              sql.NullString  `json:"tier"`
 }
 
-const listRewards = `-- name: ListRewards :many
-SELECT id, name, description, cost, segment, active, created_by, created_at FROM rewards_catalog WHERE active = $1 ORDER BY created_at DESC
-`
-
-// Enhanced rewards queries
-func (q *Queries) ListRewards(ctx context.Context, active bool) ([]RewardsCatalog, error) {
-	rows, err := q.db.QueryContext(ctx, listRewards, active)
+// ListUserSegmentFacts pages through the segment facts of all users in ID order
+func (q *Queries) ListUserSegmentFacts(ctx context.Context, arg ListUserSegmentFactsParams) ([]ListUserSegmentFactsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listUserSegmentFacts, arg.AfterID, arg.BatchSize)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []RewardsCatalog{}
+	items := []ListUserSegmentFactsRow{}
 	for rows.Next() {
-		var i RewardsCatalog
+		var i ListUserSegmentFactsRow
 		if err := rows.Scan(
 			&i.ID,
-			&i.Name,
-			&i.Description,
-			&i.Cost,
-			&i.Segment,
-			&i.Active,
-			&i.CreatedBy,
 			&i.CreatedAt,
+			&i.Balance,
+			&i.LifetimeKwh,
+			&i.City,
+			&i.VehicleType,
+			&i.VehicleMake,
+			&i.VehicleModel,
+			&i.BatteryKwh,
+			&i.Locale,
+			&i.Timezone,
+			&i.PreferredLanguage,
+			&i.Attributes,
+			&i.Tier,
 		); err != nil {
 			return nil, err
 		}
@@ -516,28 +2748,52 @@ func (q *Queries) ListRewards(ctx context.Context, active bool) ([]RewardsCatalo
 	return items, nil
 }
 
-const listRules = `-- name: ListRules :many
-SELECT id, name, description, config, active, created_by, created_at, updated_at FROM rules ORDER BY created_at DESC
+const listUserTierStats = `-- name: ListUserTierStats :many
+SELECT u.id,
+       t.tier, t.downgrade_at,
+       COALESCE((SELECT SUM(pe.points) FROM points_events pe
+                 WHERE pe.user_id = u.id AND pe.points > 0 AND pe.event_type NOT IN ('REDEMPTION_REFUND', 'CREDIT_REFUND')
+                   AND pe.created_at >= $1::timestamptz), 0)::bigint AS earned_points,
+       COALESCE((SELECT SUM((pe.meta->>'kwh')::double precision) FROM points_events pe
+                 WHERE pe.user_id = u.id AND pe.event_type = 'CHARGE_KWH' AND pe.created_at >= $1::timestamptz), 0)::double precision AS kwh
+FROM users u
+LEFT JOIN user_tiers t ON t.user_id = u.id
+WHERE u.id > $2::uuid
+ORDER BY u.id
+LIMIT $3::int
 `
 
-func (q *Queries) ListRules(ctx context.Context) ([]Rule, error) {
-	rows, err := q.db.QueryContext(ctx, listRules)
+type ListUserTierStatsParams struct {
+	Since     time.Time `json:"since"`
+	AfterID   uuid.UUID `json:"after_id"`
+	BatchSize int32     `json:"batch_size"`
+}
+
+type ListUserTierStatsRow struct {
+	ID           uuid.UUID      `json:"id"`
+	Tier         sql.NullString `json:"tier"`
+	DowngradeAt  sql.NullTime   `json:"downgrade_at"`
+	EarnedPoints int64          `json:"earned_points"`
+	Kwh          float64        `json:"kwh"`
+}
+
+// ListUserTierStats pages through every user's current tier and qualification window stats in ID order,
+// counting earned points as GetUserTierStats does
+func (q *Queries) ListUserTierStats(ctx context.Context, arg ListUserTierStatsParams) ([]ListUserTierStatsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listUserTierStats, arg.Since, arg.AfterID, arg.BatchSize)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []Rule{}
+	items := []ListUserTierStatsRow{}
 	for rows.Next() {
-		var i Rule
+		var i ListUserTierStatsRow
 		if err := rows.Scan(
 			&i.ID,
-			&i.Name,
-			&i.Description,
-			&i.Config,
-			&i.Active,
-			&i.CreatedBy,
-			&i.CreatedAt,
-			&i.UpdatedAt,
+			&i.Tier,
+			&i.DowngradeAt,
+			&i.EarnedPoints,
+			&i.Kwh,
 		); err != nil {
 			return nil, err
 		}
@@ -552,27 +2808,109 @@ func (q *Queries) ListRules(ctx context.Context) ([]Rule, error) {
 	return items, nil
 }
 
-const listSegments = `-- name: ListSegments :many
-SELECT id, name, description, criteria, active, created_by, created_at FROM segments ORDER BY created_at DESC
+const lockOTPPhone = `-- name: LockOTPPhone :exec
+SELECT pg_advisory_xact_lock(hashtext($1::text))
 `
 
-func (q *Queries) ListSegments(ctx context.Context) ([]Segment, error) {
-	rows, err := q.db.QueryContext(ctx, listSegments)
+// LockOTPPhone serializes code requests for a phone number until the
+// transaction ends, so concurrent requests cannot all pass the rate limits
+func (q *Queries) LockOTPPhone(ctx context.Context, phone string) error {
+	_, err := q.db.ExecContext(ctx, lockOTPPhone, phone)
+	return err
+}
+
+const lockPendingFulfillmentDelivery = `-- name: LockPendingFulfillmentDelivery :one
+SELECT id, redemption_id, reward_id, adapter, payload, status, attempts, last_error, response_status, next_attempt_at, locked_until, created_at, updated_at FROM fulfillment_deliveries
+WHERE redemption_id = $1 AND status = 'PENDING'
+FOR UPDATE
+`
+
+// Cancellation queries
+// LockPendingFulfillmentDelivery locks a redemption's pending delivery against
+// new attempts
+func (q *Queries) LockPendingFulfillmentDelivery(ctx context.Context, redemptionID uuid.UUID) (FulfillmentDelivery, error) {
+	row := q.db.QueryRowContext(ctx, lockPendingFulfillmentDelivery, redemptionID)
+	var i FulfillmentDelivery
+	err := row.Scan(
+		&i.ID,
+		&i.RedemptionID,
+		&i.RewardID,
+		&i.Adapter,
+		&i.Payload,
+		&i.Status,
+		&i.Attempts,
+		&i.LastError,
+		&i.ResponseStatus,
+		&i.NextAttemptAt,
+		&i.LockedUntil,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const lockRedemptionPayment = `-- name: LockRedemptionPayment :one
+SELECT id, redemption_id, provider, intent_id, amount, currency, status, failure_reason, expires_at, created_at, updated_at FROM redemption_payments
+WHERE redemption_id = $1
+FOR UPDATE
+`
+
+// LockRedemptionPayment loads a redemption's payment for settling it
+func (q *Queries) LockRedemptionPayment(ctx context.Context, redemptionID uuid.UUID) (RedemptionPayment, error) {
+	row := q.db.QueryRowContext(ctx, lockRedemptionPayment, redemptionID)
+	var i RedemptionPayment
+	err := row.Scan(
+		&i.ID,
+		&i.RedemptionID,
+		&i.Provider,
+		&i.IntentID,
+		&i.Amount,
+		&i.Currency,
+		&i.Status,
+		&i.FailureReason,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const lockUserChargingCredits = `-- name: LockUserChargingCredits :many
+SELECT id, user_id, redemption_id, amount, remaining, currency, points_spent, status, refunded_points, expires_at, created_at, updated_at FROM charging_credits
+WHERE user_id = $1 AND status = 'ACTIVE' AND expires_at > $2::timestamptz
+ORDER BY expires_at ASC, created_at ASC
+FOR UPDATE
+`
+
+type LockUserChargingCreditsParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	Now    time.Time `json:"now"`
+}
+
+// LockUserChargingCredits locks a user's usable credits, soonest to expire
+// first, so concurrent consume calls apply them one at a time
+func (q *Queries) LockUserChargingCredits(ctx context.Context, arg LockUserChargingCreditsParams) ([]ChargingCredit, error) {
+	rows, err := q.db.QueryContext(ctx, lockUserChargingCredits, arg.UserID, arg.Now)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []Segment{}
+	items := []ChargingCredit{}
 	for rows.Next() {
-		var i Segment
+		var i ChargingCredit
 		if err := rows.Scan(
 			&i.ID,
-			&i.Name,
-			&i.Description,
-			&i.Criteria,
-			&i.Active,
-			&i.CreatedBy,
+			&i.UserID,
+			&i.RedemptionID,
+			&i.Amount,
+			&i.Remaining,
+			&i.Currency,
+			&i.PointsSpent,
+			&i.Status,
+			&i.RefundedPoints,
+			&i.ExpiresAt,
 			&i.CreatedAt,
+			&i.UpdatedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -587,11 +2925,247 @@ func (q *Queries) ListSegments(ctx context.Context) ([]Segment, error) {
 	return items, nil
 }
 
+const lockUserRedemptions = `-- name: LockUserRedemptions :exec
+SELECT id FROM users WHERE id = $1 FOR UPDATE
+`
+
+// Per-user redemption limit queries
+// LockUserRedemptions serializes a user's redemptions so limits and the
+// balance are checked against committed redemptions only
+func (q *Queries) LockUserRedemptions(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, lockUserRedemptions, id)
+	return err
+}
+
+const markOTPVerified = `-- name: MarkOTPVerified :execrows
+UPDATE otp_challenges SET verified_at = NOW()
+WHERE id = $1 AND verified_at IS NULL
+`
+
+// MarkOTPVerified consumes a challenge; it affects no rows if the challenge was already used
+func (q *Queries) MarkOTPVerified(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markOTPVerified, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const markOutboxEventDead = `-- name: MarkOutboxEventDead :exec
+UPDATE event_outbox
+SET attempts = attempts + 1, last_error = $2, dead_at = NOW()
+WHERE id = $1
+`
+
+type MarkOutboxEventDeadParams struct {
+	ID        int64          `json:"id"`
+	LastError sql.NullString `json:"last_error"`
+}
+
+func (q *Queries) MarkOutboxEventDead(ctx context.Context, arg MarkOutboxEventDeadParams) error {
+	_, err := q.db.ExecContext(ctx, markOutboxEventDead, arg.ID, arg.LastError)
+	return err
+}
+
+const markOutboxEventFailed = `-- name: MarkOutboxEventFailed :exec
+UPDATE event_outbox
+SET attempts = attempts + 1, last_error = $2, next_attempt_at = $3
+WHERE id = $1
+`
+
+type MarkOutboxEventFailedParams struct {
+	ID            int64          `json:"id"`
+	LastError     sql.NullString `json:"last_error"`
+	NextAttemptAt time.Time      `json:"next_attempt_at"`
+}
+
+func (q *Queries) MarkOutboxEventFailed(ctx context.Context, arg MarkOutboxEventFailedParams) error {
+	_, err := q.db.ExecContext(ctx, markOutboxEventFailed, arg.ID, arg.LastError, arg.NextAttemptAt)
+	return err
+}
+
+const markOutboxEventPublished = `-- name: MarkOutboxEventPublished :exec
+UPDATE event_outbox
+SET published_at = NOW(), last_error = NULL
+WHERE id = $1
+`
+
+func (q *Queries) MarkOutboxEventPublished(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, markOutboxEventPublished, id)
+	return err
+}
+
+const markRewardCodesLow = `-- name: MarkRewardCodesLow :execrows
+UPDATE rewards_catalog SET code_low_alerted_at = NOW()
+WHERE id = $1 AND code_low_alerted_at IS NULL
+`
+
+// MarkRewardCodesLow records that a reward's low-stock alert fired; no row is
+// affected when it already fired since the codes were last topped up
+func (q *Queries) MarkRewardCodesLow(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markRewardCodesLow, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const releaseDailyRewardStock = `-- name: ReleaseDailyRewardStock :exec
+UPDATE reward_daily_redemptions SET redeemed = redeemed - 1
+WHERE reward_id = $1 AND day = $2 AND redeemed > 0
+`
+
+type ReleaseDailyRewardStockParams struct {
+	RewardID uuid.UUID `json:"reward_id"`
+	Day      time.Time `json:"day"`
+}
+
+func (q *Queries) ReleaseDailyRewardStock(ctx context.Context, arg ReleaseDailyRewardStockParams) error {
+	_, err := q.db.ExecContext(ctx, releaseDailyRewardStock, arg.RewardID, arg.Day)
+	return err
+}
+
+const releaseRewardCode = `-- name: ReleaseRewardCode :execrows
+UPDATE reward_codes SET redemption_id = NULL, claimed_at = NULL
+WHERE redemption_id = $1
+  AND reward_id IN (SELECT id FROM rewards_catalog WHERE code_source = 'pool')
+`
+
+// ReleaseRewardCode returns the pool code claimed by a redemption to its
+// pool; generated codes affect no row
+func (q *Queries) ReleaseRewardCode(ctx context.Context, redemptionID uuid.NullUUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, releaseRewardCode, redemptionID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const releaseRewardStock = `-- name: ReleaseRewardStock :exec
+UPDATE rewards_catalog SET stock_redeemed = stock_redeemed - 1
+WHERE id = $1 AND stock_redeemed > 0
+`
+
+// ReleaseRewardStock returns a unit claimed by a cancelled redemption
+func (q *Queries) ReleaseRewardStock(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, releaseRewardStock, id)
+	return err
+}
+
+const retryFulfillmentDelivery = `-- name: RetryFulfillmentDelivery :execrows
+UPDATE fulfillment_deliveries
+SET attempts = attempts + 1, last_error = $1, response_status = $2,
+    next_attempt_at = $3, locked_until = NULL
+WHERE id = $4 AND status = 'PENDING' AND locked_until = $5::timestamptz
+`
+
+type RetryFulfillmentDeliveryParams struct {
+	LastError      sql.NullString `json:"last_error"`
+	ResponseStatus sql.NullInt32  `json:"response_status"`
+	NextAttemptAt  time.Time      `json:"next_attempt_at"`
+	ID             uuid.UUID      `json:"id"`
+	LockedUntil    time.Time      `json:"locked_until"`
+}
+
+// RetryFulfillmentDelivery records a failed attempt to be retried at
+// next_attempt_at; deliveries whose lease was lost affect no row
+func (q *Queries) RetryFulfillmentDelivery(ctx context.Context, arg RetryFulfillmentDeliveryParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, retryFulfillmentDelivery,
+		arg.LastError,
+		arg.ResponseStatus,
+		arg.NextAttemptAt,
+		arg.ID,
+		arg.LockedUntil,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setRedemptionPaymentIntent = `-- name: SetRedemptionPaymentIntent :exec
+UPDATE redemption_payments SET intent_id = $2 WHERE id = $1
+`
+
+type SetRedemptionPaymentIntentParams struct {
+	ID       uuid.UUID      `json:"id"`
+	IntentID sql.NullString `json:"intent_id"`
+}
+
+func (q *Queries) SetRedemptionPaymentIntent(ctx context.Context, arg SetRedemptionPaymentIntentParams) error {
+	_, err := q.db.ExecContext(ctx, setRedemptionPaymentIntent, arg.ID, arg.IntentID)
+	return err
+}
+
+const setRedemptionVoucherCode = `-- name: SetRedemptionVoucherCode :exec
+UPDATE redemptions SET voucher_code = $2 WHERE id = $1
+`
+
+type SetRedemptionVoucherCodeParams struct {
+	ID          uuid.UUID      `json:"id"`
+	VoucherCode sql.NullString `json:"voucher_code"`
+}
+
+func (q *Queries) SetRedemptionVoucherCode(ctx context.Context, arg SetRedemptionVoucherCodeParams) error {
+	_, err := q.db.ExecContext(ctx, setRedemptionVoucherCode, arg.ID, arg.VoucherCode)
+	return err
+}
+
+const setSegmentMaterializedAt = `-- name: SetSegmentMaterializedAt :exec
+UPDATE segments SET materialized_at = $2 WHERE id = $1
+`
+
+type SetSegmentMaterializedAtParams struct {
+	ID             uuid.UUID    `json:"id"`
+	MaterializedAt sql.NullTime `json:"materialized_at"`
+}
+
+func (q *Queries) SetSegmentMaterializedAt(ctx context.Context, arg SetSegmentMaterializedAtParams) error {
+	_, err := q.db.ExecContext(ctx, setSegmentMaterializedAt, arg.ID, arg.MaterializedAt)
+	return err
+}
+
+const settleAwaitingRedemption = `-- name: SettleAwaitingRedemption :one
+UPDATE redemptions SET status = $1,
+    expires_at = COALESCE($2::timestamptz, expires_at)
+WHERE id = $3 AND status = 'AWAITING_PAYMENT'
+RETURNING id, user_id, reward_id, points_spent, status, voucher_code, expires_at, base_cost, price_rule, checkout_id, created_at, updated_at
+`
+
+type SettleAwaitingRedemptionParams struct {
+	Status    string       `json:"status"`
+	ExpiresAt sql.NullTime `json:"expires_at"`
+	ID        uuid.UUID    `json:"id"`
+}
+
+// SettleAwaitingRedemption moves a redemption awaiting payment to its
+// outcome, restarting its expiry if one is given; no row is returned once it
+// has left AWAITING_PAYMENT
+func (q *Queries) SettleAwaitingRedemption(ctx context.Context, arg SettleAwaitingRedemptionParams) (Redemption, error) {
+	row := q.db.QueryRowContext(ctx, settleAwaitingRedemption, arg.Status, arg.ExpiresAt, arg.ID)
+	var i Redemption
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.RewardID,
+		&i.PointsSpent,
+		&i.Status,
+		&i.VoucherCode,
+		&i.ExpiresAt,
+		&i.BaseCost,
+		&i.PriceRule,
+		&i.CheckoutID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
 const updateRedemptionStatus = `-- name: UpdateRedemptionStatus :one
 UPDATE redemptions
 SET status = $2
 WHERE id = $1
-RETURNING id, user_id, reward_id, points_spent, status, created_at, updated_at
+RETURNING id, user_id, reward_id, points_spent, status, voucher_code, expires_at, base_cost, price_rule, checkout_id, created_at, updated_at
 `
 
 type UpdateRedemptionStatusParams struct {
@@ -608,6 +3182,11 @@ func (q *Queries) UpdateRedemptionStatus(ctx context.Context, arg UpdateRedempti
 		&i.RewardID,
 		&i.PointsSpent,
 		&i.Status,
+		&i.VoucherCode,
+		&i.ExpiresAt,
+		&i.BaseCost,
+		&i.PriceRule,
+		&i.CheckoutID,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 	)
@@ -615,19 +3194,62 @@ func (q *Queries) UpdateRedemptionStatus(ctx context.Context, arg UpdateRedempti
 }
 
 const updateReward = `-- name: UpdateReward :one
-UPDATE rewards_catalog SET name = $2, description = $3, cost = $4, segment = $5, active = $6 
-WHERE id = $1 RETURNING id, name, description, cost, segment, active, created_by, created_at
+UPDATE rewards_catalog SET name = $2, description = $3, cost = $4, segment = $5,
+    active = $6 AND ($37::int IS NULL OR stock_redeemed < $37::int),
+    min_tier = $7, stock_total = $37::int, daily_stock = $8,
+    max_per_user = $9, max_per_user_monthly = $10, cooldown_seconds = $11,
+    code_source = $12, code_format = $13, code_low_threshold = $14,
+    reward_type = $15, credit_amount = $16, credit_valid_days = $17,
+    fulfillment_adapter = $18, fulfillment_url = $19, fulfillment_secret = $20, pending_ttl_seconds = $21,
+    available_from = $22, available_until = $23, available_days = $24,
+    min_points = $25, cash_price = $26, price_rules = $27,
+    category = $28, tags = $29, image_url = $30, terms = $31,
+    partner_name = $32, partner_url = $33, partner_logo_url = $34, sort_order = $35, featured = $36,
+    version = version + 1 
+WHERE id = $1 RETURNING id, name, description, cost, segment, min_tier, reward_type, credit_amount, credit_valid_days, stock_total, stock_redeemed, daily_stock, max_per_user, max_per_user_monthly, cooldown_seconds, pending_ttl_seconds, available_from, available_until, available_days, min_points, cash_price, price_rules, category, tags, image_url, terms, partner_name, partner_url, partner_logo_url, sort_order, featured, code_source, code_format, code_low_threshold, code_low_alerted_at, fulfillment_adapter, fulfillment_url, fulfillment_secret, active, version, created_by, created_at
 `
 
 type UpdateRewardParams struct {
-	ID          uuid.UUID             `json:"id"`
-	Name        string                `json:"name"`
-	Description sql.NullString        `json:"description"`
-	Cost        int32                 `json:"cost"`
-	Segment     pqtype.NullRawMessage `json:"segment"`
-	Active      bool                  `json:"active"`
+	ID                 uuid.UUID             `json:"id"`
+	Name               string                `json:"name"`
+	Description        sql.NullString        `json:"description"`
+	Cost               int32                 `json:"cost"`
+	Segment            pqtype.NullRawMessage `json:"segment"`
+	Active             bool                  `json:"active"`
+	MinTier            sql.NullString        `json:"min_tier"`
+	DailyStock         sql.NullInt32         `json:"daily_stock"`
+	MaxPerUser         sql.NullInt32         `json:"max_per_user"`
+	MaxPerUserMonthly  sql.NullInt32         `json:"max_per_user_monthly"`
+	CooldownSeconds    sql.NullInt32         `json:"cooldown_seconds"`
+	CodeSource         sql.NullString        `json:"code_source"`
+	CodeFormat         sql.NullString        `json:"code_format"`
+	CodeLowThreshold   sql.NullInt32         `json:"code_low_threshold"`
+	RewardType         string                `json:"reward_type"`
+	CreditAmount       sql.NullInt64         `json:"credit_amount"`
+	CreditValidDays    sql.NullInt32         `json:"credit_valid_days"`
+	FulfillmentAdapter sql.NullString        `json:"fulfillment_adapter"`
+	FulfillmentUrl     sql.NullString        `json:"fulfillment_url"`
+	FulfillmentSecret  sql.NullString        `json:"fulfillment_secret"`
+	PendingTtlSeconds  sql.NullInt32         `json:"pending_ttl_seconds"`
+	AvailableFrom      sql.NullTime          `json:"available_from"`
+	AvailableUntil     sql.NullTime          `json:"available_until"`
+	AvailableDays      sql.NullInt32         `json:"available_days"`
+	MinPoints          sql.NullInt32         `json:"min_points"`
+	CashPrice          sql.NullInt64         `json:"cash_price"`
+	PriceRules         pqtype.NullRawMessage `json:"price_rules"`
+	Category           sql.NullString        `json:"category"`
+	Tags               pqtype.NullRawMessage `json:"tags"`
+	ImageUrl           sql.NullString        `json:"image_url"`
+	Terms              sql.NullString        `json:"terms"`
+	PartnerName        sql.NullString        `json:"partner_name"`
+	PartnerUrl         sql.NullString        `json:"partner_url"`
+	PartnerLogoUrl     sql.NullString        `json:"partner_logo_url"`
+	SortOrder          int32                 `json:"sort_order"`
+	Featured           bool                  `json:"featured"`
+	StockTotal         sql.NullInt32         `json:"stock_total"`
 }
 
+// UpdateReward keeps a reward inactive while its new stock_total is already used up
 func (q *Queries) UpdateReward(ctx context.Context, arg UpdateRewardParams) (RewardsCatalog, error) {
 	row := q.db.QueryRowContext(ctx, updateReward,
 		arg.ID,
@@ -636,6 +3258,37 @@ func (q *Queries) UpdateReward(ctx context.Context, arg UpdateRewardParams) (Rew
 		arg.Cost,
 		arg.Segment,
 		arg.Active,
+		arg.MinTier,
+		arg.DailyStock,
+		arg.MaxPerUser,
+		arg.MaxPerUserMonthly,
+		arg.CooldownSeconds,
+		arg.CodeSource,
+		arg.CodeFormat,
+		arg.CodeLowThreshold,
+		arg.RewardType,
+		arg.CreditAmount,
+		arg.CreditValidDays,
+		arg.FulfillmentAdapter,
+		arg.FulfillmentUrl,
+		arg.FulfillmentSecret,
+		arg.PendingTtlSeconds,
+		arg.AvailableFrom,
+		arg.AvailableUntil,
+		arg.AvailableDays,
+		arg.MinPoints,
+		arg.CashPrice,
+		arg.PriceRules,
+		arg.Category,
+		arg.Tags,
+		arg.ImageUrl,
+		arg.Terms,
+		arg.PartnerName,
+		arg.PartnerUrl,
+		arg.PartnerLogoUrl,
+		arg.SortOrder,
+		arg.Featured,
+		arg.StockTotal,
 	)
 	var i RewardsCatalog
 	err := row.Scan(
@@ -644,7 +3297,41 @@ func (q *Queries) UpdateReward(ctx context.Context, arg UpdateRewardParams) (Rew
 		&i.Description,
 		&i.Cost,
 		&i.Segment,
+		&i.MinTier,
+		&i.RewardType,
+		&i.CreditAmount,
+		&i.CreditValidDays,
+		&i.StockTotal,
+		&i.StockRedeemed,
+		&i.DailyStock,
+		&i.MaxPerUser,
+		&i.MaxPerUserMonthly,
+		&i.CooldownSeconds,
+		&i.PendingTtlSeconds,
+		&i.AvailableFrom,
+		&i.AvailableUntil,
+		&i.AvailableDays,
+		&i.MinPoints,
+		&i.CashPrice,
+		&i.PriceRules,
+		&i.Category,
+		&i.Tags,
+		&i.ImageUrl,
+		&i.Terms,
+		&i.PartnerName,
+		&i.PartnerUrl,
+		&i.PartnerLogoUrl,
+		&i.SortOrder,
+		&i.Featured,
+		&i.CodeSource,
+		&i.CodeFormat,
+		&i.CodeLowThreshold,
+		&i.CodeLowAlertedAt,
+		&i.FulfillmentAdapter,
+		&i.FulfillmentUrl,
+		&i.FulfillmentSecret,
 		&i.Active,
+		&i.Version,
 		&i.CreatedBy,
 		&i.CreatedAt,
 	)
@@ -652,8 +3339,8 @@ func (q *Queries) UpdateReward(ctx context.Context, arg UpdateRewardParams) (Rew
 }
 
 const updateRule = `-- name: UpdateRule :one
-UPDATE rules SET name = $2, description = $3, config = $4, active = $5, updated_at = NOW() 
-WHERE id = $1 RETURNING id, name, description, config, active, created_by, created_at, updated_at
+UPDATE rules SET name = $2, description = $3, config = $4, active = $5, version = version + 1, updated_at = NOW() 
+WHERE id = $1 RETURNING id, name, description, config, active, version, created_by, created_at, updated_at
 `
 
 type UpdateRuleParams struct {
@@ -679,6 +3366,7 @@ func (q *Queries) UpdateRule(ctx context.Context, arg UpdateRuleParams) (Rule, e
 		&i.Description,
 		&i.Config,
 		&i.Active,
+		&i.Version,
 		&i.CreatedBy,
 		&i.CreatedAt,
 		&i.UpdatedAt,
@@ -687,16 +3375,17 @@ func (q *Queries) UpdateRule(ctx context.Context, arg UpdateRuleParams) (Rule, e
 }
 
 const updateSegment = `-- name: UpdateSegment :one
-UPDATE segments SET name = $2, description = $3, criteria = $4, active = $5 
-WHERE id = $1 RETURNING id, name, description, criteria, active, created_by, created_at
+UPDATE segments SET name = $2, description = $3, criteria = $4, active = $5, materialized = $6, version = version + 1 
+WHERE id = $1 RETURNING id, name, description, criteria, active, version, materialized, materialized_at, created_by, created_at
 `
 
 type UpdateSegmentParams struct {
-	ID          uuid.UUID       `json:"id"`
-	Name        string          `json:"name"`
-	Description sql.NullString  `json:"description"`
-	Criteria    json.RawMessage `json:"criteria"`
-	Active      bool            `json:"active"`
+	ID           uuid.UUID       `json:"id"`
+	Name         string          `json:"name"`
+	Description  sql.NullString  `json:"description"`
+	Criteria     json.RawMessage `json:"criteria"`
+	Active       bool            `json:"active"`
+	Materialized bool            `json:"materialized"`
 }
 
 func (q *Queries) UpdateSegment(ctx context.Context, arg UpdateSegmentParams) (Segment, error) {
@@ -706,6 +3395,7 @@ func (q *Queries) UpdateSegment(ctx context.Context, arg UpdateSegmentParams) (S
 		arg.Description,
 		arg.Criteria,
 		arg.Active,
+		arg.Materialized,
 	)
 	var i Segment
 	err := row.Scan(
@@ -714,8 +3404,148 @@ func (q *Queries) UpdateSegment(ctx context.Context, arg UpdateSegmentParams) (S
 		&i.Description,
 		&i.Criteria,
 		&i.Active,
+		&i.Version,
+		&i.Materialized,
+		&i.MaterializedAt,
 		&i.CreatedBy,
 		&i.CreatedAt,
 	)
 	return i, err
 }
+
+const upsertSegmentMember = `-- name: UpsertSegmentMember :exec
+INSERT INTO segment_members (segment_id, user_id, refreshed_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (segment_id, user_id) DO UPDATE SET refreshed_at = EXCLUDED.refreshed_at
+`
+
+type UpsertSegmentMemberParams struct {
+	SegmentID   uuid.UUID `json:"segment_id"`
+	UserID      uuid.UUID `json:"user_id"`
+	RefreshedAt time.Time `json:"refreshed_at"`
+}
+
+func (q *Queries) UpsertSegmentMember(ctx context.Context, arg UpsertSegmentMemberParams) error {
+	_, err := q.db.ExecContext(ctx, upsertSegmentMember, arg.SegmentID, arg.UserID, arg.RefreshedAt)
+	return err
+}
+
+const upsertUserProfile = `-- name: UpsertUserProfile :one
+INSERT INTO user_profiles (user_id, name, email, locale, timezone, city, vehicle_type,
+                           vehicle_make, vehicle_model, battery_kwh, preferred_language, attributes)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+ON CONFLICT (user_id) DO UPDATE SET
+    name = EXCLUDED.name, email = EXCLUDED.email, locale = EXCLUDED.locale,
+    timezone = EXCLUDED.timezone, city = EXCLUDED.city, vehicle_type = EXCLUDED.vehicle_type,
+    vehicle_make = EXCLUDED.vehicle_make, vehicle_model = EXCLUDED.vehicle_model,
+    battery_kwh = EXCLUDED.battery_kwh, preferred_language = EXCLUDED.preferred_language,
+    attributes = EXCLUDED.attributes
+RETURNING user_id, name, email, locale, timezone, city, vehicle_type, vehicle_make, vehicle_model, battery_kwh, preferred_language, attributes, created_at, updated_at
+`
+
+type UpsertUserProfileParams struct {
+	UserID            uuid.UUID       `json:"user_id"`
+	Name              sql.NullString  `json:"name"`
+	Email             sql.NullString  `json:"email"`
+	Locale            sql.NullString  `json:"locale"`
+	Timezone          sql.NullString  `json:"timezone"`
+	City              sql.NullString  `json:"city"`
+	VehicleType       sql.NullString  `json:"vehicle_type"`
+	VehicleMake       sql.NullString  `json:"vehicle_make"`
+	VehicleModel      sql.NullString  `json:"vehicle_model"`
+	BatteryKwh        sql.NullFloat64 `json:"battery_kwh"`
+	PreferredLanguage sql.NullString  `json:"preferred_language"`
+	Attributes        json.RawMessage `json:"attributes"`
+}
+
+func (q *Queries) UpsertUserProfile(ctx context.Context, arg UpsertUserProfileParams) (UserProfile, error) {
+	row := q.db.QueryRowContext(ctx, upsertUserProfile,
+		arg.UserID,
+		arg.Name,
+		arg.Email,
+		arg.Locale,
+		arg.Timezone,
+		arg.City,
+		arg.VehicleType,
+		arg.VehicleMake,
+		arg.VehicleModel,
+		arg.BatteryKwh,
+		arg.PreferredLanguage,
+		arg.Attributes,
+	)
+	var i UserProfile
+	err := row.Scan(
+		&i.UserID,
+		&i.Name,
+		&i.Email,
+		&i.Locale,
+		&i.Timezone,
+		&i.City,
+		&i.VehicleType,
+		&i.VehicleMake,
+		&i.VehicleModel,
+		&i.BatteryKwh,
+		&i.PreferredLanguage,
+		&i.Attributes,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const upsertUserTier = `-- name: UpsertUserTier :one
+INSERT INTO user_tiers (user_id, tier, tier_since, rolling_kwh, rolling_points, downgrade_at, evaluated_at)
+VALUES ($1, $2, $6::timestamptz, $3, $4, $5, $6::timestamptz)
+ON CONFLICT (user_id) DO UPDATE SET
+    tier = EXCLUDED.tier,
+    tier_since = CASE WHEN user_tiers.tier = EXCLUDED.tier THEN user_tiers.tier_since ELSE EXCLUDED.tier_since END,
+    rolling_kwh = EXCLUDED.rolling_kwh,
+    rolling_points = EXCLUDED.rolling_points,
+    downgrade_at = EXCLUDED.downgrade_at,
+    evaluated_at = EXCLUDED.evaluated_at
+RETURNING user_id, tier, tier_since, rolling_kwh, rolling_points, downgrade_at, evaluated_at
+`
+
+type UpsertUserTierParams struct {
+	UserID        uuid.UUID    `json:"user_id"`
+	Tier          string       `json:"tier"`
+	RollingKwh    float64      `json:"rolling_kwh"`
+	RollingPoints int64        `json:"rolling_points"`
+	DowngradeAt   sql.NullTime `json:"downgrade_at"`
+	EvaluatedAt   time.Time    `json:"evaluated_at"`
+}
+
+// UpsertUserTier records an evaluation; tier_since only moves when the tier changes
+func (q *Queries) UpsertUserTier(ctx context.Context, arg UpsertUserTierParams) (UserTier, error) {
+	row := q.db.QueryRowContext(ctx, upsertUserTier,
+		arg.UserID,
+		arg.Tier,
+		arg.RollingKwh,
+		arg.RollingPoints,
+		arg.DowngradeAt,
+		arg.EvaluatedAt,
+	)
+	var i UserTier
+	err := row.Scan(
+		&i.UserID,
+		&i.Tier,
+		&i.TierSince,
+		&i.RollingKwh,
+		&i.RollingPoints,
+		&i.DowngradeAt,
+		&i.EvaluatedAt,
+	)
+	return i, err
+}
+
+const voidRewardCode = `-- name: VoidRewardCode :exec
+UPDATE reward_codes SET voided_at = NOW()
+WHERE redemption_id = $1 AND voided_at IS NULL
+`
+
+// VoidRewardCode voids the code issued to a redemption; it stays claimed, so
+// it is never issued again
+func (q *Queries) VoidRewardCode(ctx context.Context, redemptionID uuid.NullUUID) error {
+	_, err := q.db.ExecContext(ctx, voidRewardCode, redemptionID)
+	return err
+}
@@ -0,0 +1,20 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// WithTx runs fn with queries bound to a new transaction on conn, committing
+// if fn succeeds and rolling back otherwise
+func WithTx(ctx context.Context, conn *sql.DB, fn func(q *Queries) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(New(tx)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
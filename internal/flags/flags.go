@@ -0,0 +1,60 @@
+// Package flags evaluates go-feature-flag flags for app users.
+//
+// Flags are evaluated against a context built from the user's segment facts,
+// so flag rules can target the same attributes as segment criteria, e.g.
+//
+//	targeting:
+//	  - query: city eq "Pune" and lifetime_kwh ge 500
+//	    variation: enabled
+//
+// Custom profile attributes are nested under "attributes".
+package flags
+
+import (
+	"time"
+
+	"encore.app/internal/segments"
+	ffclient "github.com/thomaspoignant/go-feature-flag"
+	"github.com/thomaspoignant/go-feature-flag/ffcontext"
+)
+
+// Context builds the evaluation context of a user. Unknown attributes are left out.
+func Context(u *segments.User) ffcontext.Context {
+	b := ffcontext.NewEvaluationContextBuilder(u.ID).
+		AddCustom(segments.AttrLifetimeKWH, u.LifetimeKWH).
+		AddCustom(segments.AttrBalance, u.Balance)
+	if !u.SignupDate.IsZero() {
+		b.AddCustom(segments.AttrSignupDate, u.SignupDate.Format(time.RFC3339))
+	}
+	for attr, value := range map[string]string{
+		segments.AttrTier:              u.Tier,
+		segments.AttrCity:              u.City,
+		segments.AttrVehicleType:       u.VehicleType,
+		segments.AttrVehicleMake:       u.VehicleMake,
+		segments.AttrVehicleModel:      u.VehicleModel,
+		segments.AttrLocale:            u.Locale,
+		segments.AttrTimezone:          u.Timezone,
+		segments.AttrPreferredLanguage: u.PreferredLanguage,
+	} {
+		if value != "" {
+			b.AddCustom(attr, value)
+		}
+	}
+	if u.BatteryKWH != 0 {
+		b.AddCustom(segments.AttrBatteryKWH, u.BatteryKWH)
+	}
+	if len(u.Attributes) > 0 {
+		b.AddCustom("attributes", u.Attributes)
+	}
+	return b.Build()
+}
+
+// Bool evaluates a boolean flag for a user, returning defaultValue when the
+// flag cannot be evaluated (e.g. go-feature-flag is not initialized)
+func Bool(key string, u *segments.User, defaultValue bool) bool {
+	value, err := ffclient.BoolVariation(key, Context(u), defaultValue)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
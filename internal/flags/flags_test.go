@@ -0,0 +1,51 @@
+package flags
+
+import (
+	"testing"
+	"time"
+
+	"encore.app/internal/segments"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContext(t *testing.T) {
+	u := &segments.User{
+		ID:                "550e8400-e29b-41d4-a716-446655440000",
+		LifetimeKWH:       612.5,
+		Balance:           850,
+		SignupDate:        time.Date(2024, 3, 2, 9, 0, 0, 0, time.UTC),
+		City:              "Pune",
+		VehicleType:       "4W",
+		VehicleMake:       "Tata",
+		BatteryKWH:        40.5,
+		PreferredLanguage: "mr",
+		Attributes:        map[string]interface{}{"fleet": true},
+	}
+
+	ctx := Context(u)
+	assert.Equal(t, u.ID, ctx.GetKey())
+	assert.Equal(t, map[string]interface{}{
+		"lifetime_kwh":       612.5,
+		"balance":            int64(850),
+		"signup_date":        "2024-03-02T09:00:00Z",
+		"city":               "Pune",
+		"vehicle_type":       "4W",
+		"vehicle_make":       "Tata",
+		"battery_kwh":        40.5,
+		"preferred_language": "mr",
+		"attributes":         map[string]interface{}{"fleet": true},
+	}, ctx.GetCustom())
+}
+
+func TestContext_UnknownAttributesAreOmitted(t *testing.T) {
+	ctx := Context(&segments.User{ID: "u1"})
+	assert.Equal(t, map[string]interface{}{
+		"lifetime_kwh": 0.0,
+		"balance":      int64(0),
+	}, ctx.GetCustom())
+}
+
+func TestBool_DefaultsWhenUninitialized(t *testing.T) {
+	assert.True(t, Bool("early-access", &segments.User{ID: "u1"}, true))
+	assert.False(t, Bool("early-access", &segments.User{ID: "u1"}, false))
+}
@@ -0,0 +1,381 @@
+// Package fulfillment delivers redemptions to the partners fulfilling them.
+//
+// A reward with a fulfillment_adapter has each redemption queued in the
+// fulfillment_deliveries table when its RedemptionCreated event arrives. A
+// Dispatcher leases due deliveries, hands them to the reward's adapter outside
+// any transaction and records each outcome, retrying failed attempts with
+// exponential backoff. The redemption becomes FULFILLED when the
+// partner accepts it and FAILED, with its points refunded, when the partner
+// rejects it or every attempt fails.
+package fulfillment
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"encore.app/internal/db"
+	"encore.app/internal/inventory"
+	"encore.app/internal/vouchers"
+	"github.com/google/uuid"
+)
+
+// Adapters
+const (
+	AdapterWebhook = "webhook"
+)
+
+// Delivery statuses
+const (
+	StatusPending   = "PENDING"
+	StatusDelivered = "DELIVERED"
+	StatusFailed    = "FAILED"
+)
+
+// Redemption statuses set by fulfillment
+const (
+	RedemptionFulfilled = "FULFILLED"
+	RedemptionFailed    = "FAILED"
+)
+
+// RefundEventType is the points event type refunding a failed redemption
+const RefundEventType = "REDEMPTION_REFUND"
+
+// Dispatcher defaults
+const (
+	DefaultBatchSize   = 50
+	DefaultMaxAttempts = 8
+	DefaultMinBackoff  = 30 * time.Second
+	DefaultMaxBackoff  = time.Hour
+	DefaultLease       = 5 * time.Minute
+)
+
+// Errors returned by the package
+var (
+	ErrInvalidAdapter = errors.New("fulfillment_adapter must be webhook")
+	ErrURLRequired    = errors.New("webhook fulfillment needs an https fulfillment_url on a public host")
+	ErrSecretRequired = errors.New("webhook fulfillment needs a fulfillment_secret of at least 16 characters")
+	// ErrRejected wraps adapter errors the partner will not accept on retry
+	ErrRejected = errors.New("partner rejected the redemption")
+)
+
+// minSecretLength keeps webhook signatures hard to forge
+const minSecretLength = 16
+
+// Adapter delivers a redemption to a partner. Deliver returns the partner's
+// status code, if any, and an error wrapping ErrRejected when retrying is
+// pointless; other errors are retried.
+type Adapter interface {
+	Deliver(ctx context.Context, delivery db.FulfillmentDelivery, reward db.RewardsCatalog) (int, error)
+}
+
+// ValidateReward checks a reward's fulfillment settings
+func ValidateReward(adapter, url, secret sql.NullString) error {
+	if !adapter.Valid || adapter.String == "" {
+		return nil
+	}
+	if adapter.String != AdapterWebhook {
+		return ErrInvalidAdapter
+	}
+	if !validURL(url.String) {
+		return ErrURLRequired
+	}
+	if len(secret.String) < minSecretLength {
+		return ErrSecretRequired
+	}
+	return nil
+}
+
+// Enqueuer is the subset of *db.Queries used to queue deliveries
+type Enqueuer interface {
+	CreateFulfillmentDelivery(ctx context.Context, arg db.CreateFulfillmentDeliveryParams) (int64, error)
+}
+
+// Enqueue queues a redemption of reward for delivery to its partner with
+// event as the payload. It reports whether a delivery was queued: rewards
+// without an adapter and redemptions already queued are skipped.
+func Enqueue(ctx context.Context, q Enqueuer, reward db.RewardsCatalog, redemptionID uuid.UUID, event interface{}) (bool, error) {
+	if !reward.FulfillmentAdapter.Valid || reward.FulfillmentAdapter.String == "" {
+		return false, nil
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return false, fmt.Errorf("encode fulfillment payload: %w", err)
+	}
+	n, err := q.CreateFulfillmentDelivery(ctx, db.CreateFulfillmentDeliveryParams{
+		RedemptionID: redemptionID,
+		RewardID:     reward.ID,
+		Adapter:      reward.FulfillmentAdapter.String,
+		Payload:      payload,
+	})
+	return n > 0, err
+}
+
+// Store is the subset of *db.Queries used to deliver
+type Store interface {
+	ClaimDueFulfillmentDeliveries(ctx context.Context, arg db.ClaimDueFulfillmentDeliveriesParams) ([]db.FulfillmentDelivery, error)
+	GetReward(ctx context.Context, id uuid.UUID) (db.RewardsCatalog, error)
+	GetRedemption(ctx context.Context, id uuid.UUID) (db.Redemption, error)
+	FinishFulfillmentDelivery(ctx context.Context, arg db.FinishFulfillmentDeliveryParams) (int64, error)
+	RetryFulfillmentDelivery(ctx context.Context, arg db.RetryFulfillmentDeliveryParams) (int64, error)
+	FinishPendingRedemption(ctx context.Context, arg db.FinishPendingRedemptionParams) (int64, error)
+	CreatePointsEvent(ctx context.Context, arg db.CreatePointsEventParams) (db.PointsEvent, error)
+	SetRedemptionVoucherCode(ctx context.Context, arg db.SetRedemptionVoucherCodeParams) error
+	inventory.Releaser
+	vouchers.Withdrawer
+}
+
+// TxFunc runs fn inside a database transaction
+type TxFunc func(ctx context.Context, fn func(q Store) error) error
+
+// DBTx returns a TxFunc running transactions on conn
+func DBTx(conn *sql.DB) TxFunc {
+	return func(ctx context.Context, fn func(q Store) error) error {
+		return db.WithTx(ctx, conn, func(q *db.Queries) error {
+			return fn(q)
+		})
+	}
+}
+
+// Config configures a Dispatcher
+type Config struct {
+	BatchSize int
+	// MaxAttempts is the number of attempts before a delivery fails
+	MaxAttempts int32
+	// Failed attempts are retried after MinBackoff, doubling per attempt up
+	// to MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Lease is how long a claimed delivery is reserved for its attempt. It
+	// must outlast the adapters' timeouts; a delivery whose run dies is
+	// attempted again once its lease ends.
+	Lease time.Duration
+}
+
+// Summary counts the outcomes of a batch
+type Summary struct {
+	Delivered int
+	Failed    int
+	Retried   int
+}
+
+// Attempts returns the number of deliveries attempted
+func (s Summary) Attempts() int {
+	return s.Delivered + s.Failed + s.Retried
+}
+
+// Dispatcher hands due deliveries to their adapters
+type Dispatcher struct {
+	cfg      Config
+	adapters map[string]Adapter
+	now      func() time.Time
+}
+
+// NewDispatcher creates a dispatcher, filling in defaults for unset config
+// values
+func NewDispatcher(cfg Config, adapters map[string]Adapter) *Dispatcher {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultMaxAttempts
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = DefaultMinBackoff
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = DefaultMaxBackoff
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = DefaultLease
+	}
+	return &Dispatcher{cfg: cfg, adapters: adapters, now: time.Now}
+}
+
+// BatchSize returns the most deliveries attempted per batch
+func (d *Dispatcher) BatchSize() int {
+	return d.cfg.BatchSize
+}
+
+// Backoff returns the delay before the given retry attempt
+func (d *Dispatcher) Backoff(attempt int32) time.Duration {
+	b := d.cfg.MinBackoff
+	for i := int32(1); i < attempt; i++ {
+		b *= 2
+		if b >= d.cfg.MaxBackoff {
+			return d.cfg.MaxBackoff
+		}
+	}
+	return b
+}
+
+// DeliverDue leases and attempts one batch of due deliveries. The batch is
+// claimed in a short transaction, the partners are called outside any
+// transaction and each outcome is recorded in a transaction of its own, so
+// no row stays locked while a partner responds. Deliveries leased by a
+// concurrent batch are skipped, and outcomes of deliveries cancelled or
+// re-leased meanwhile are dropped.
+func (d *Dispatcher) DeliverDue(ctx context.Context, tx TxFunc) (Summary, error) {
+	var deliveries []db.FulfillmentDelivery
+	rewards := make(map[uuid.UUID]db.RewardsCatalog)
+	err := tx(ctx, func(q Store) error {
+		now := d.now()
+		var err error
+		deliveries, err = q.ClaimDueFulfillmentDeliveries(ctx, db.ClaimDueFulfillmentDeliveriesParams{
+			LockedUntil: now.Add(d.cfg.Lease),
+			Now:         now,
+			BatchSize:   int32(d.cfg.BatchSize),
+		})
+		if err != nil {
+			return err
+		}
+		for _, delivery := range deliveries {
+			if _, ok := rewards[delivery.RewardID]; ok {
+				continue
+			}
+			reward, err := q.GetReward(ctx, delivery.RewardID)
+			if err != nil {
+				return fmt.Errorf("load reward: %w", err)
+			}
+			rewards[delivery.RewardID] = reward
+		}
+		return nil
+	})
+	if err != nil {
+		return Summary{}, err
+	}
+
+	var summary Summary
+	for _, delivery := range deliveries {
+		status, attemptErr := d.attempt(ctx, delivery, rewards[delivery.RewardID])
+		var result outcome
+		err := tx(ctx, func(q Store) error {
+			var err error
+			result, err = d.record(ctx, q, delivery, status, attemptErr)
+			return err
+		})
+		if err != nil {
+			return summary, err
+		}
+		summary.add(result)
+	}
+	return summary, nil
+}
+
+// attempt hands a delivery to its reward's adapter
+func (d *Dispatcher) attempt(ctx context.Context, delivery db.FulfillmentDelivery, reward db.RewardsCatalog) (int, error) {
+	adapter, ok := d.adapters[delivery.Adapter]
+	if !ok {
+		return 0, fmt.Errorf("%w: unknown adapter %q", ErrRejected, delivery.Adapter)
+	}
+	return adapter.Deliver(ctx, delivery, reward)
+}
+
+// outcome is the recorded result of an attempt
+type outcome int
+
+const (
+	// outcomeLost drops the result of a delivery whose lease was lost
+	outcomeLost outcome = iota
+	outcomeDelivered
+	outcomeFailed
+	outcomeRetried
+)
+
+// add counts an attempt's outcome
+func (s *Summary) add(o outcome) {
+	switch o {
+	case outcomeDelivered:
+		s.Delivered++
+	case outcomeFailed:
+		s.Failed++
+	case outcomeRetried:
+		s.Retried++
+	}
+}
+
+// record stores the outcome of an attempt
+func (d *Dispatcher) record(ctx context.Context, q Store, delivery db.FulfillmentDelivery, status int, cause error) (outcome, error) {
+	if cause == nil {
+		return d.finish(ctx, q, delivery, StatusDelivered, RedemptionFulfilled, status, nil)
+	}
+	if errors.Is(cause, ErrRejected) || delivery.Attempts+1 >= d.cfg.MaxAttempts {
+		return d.finish(ctx, q, delivery, StatusFailed, RedemptionFailed, status, cause)
+	}
+	n, err := q.RetryFulfillmentDelivery(ctx, db.RetryFulfillmentDeliveryParams{
+		ID:             delivery.ID,
+		LockedUntil:    delivery.LockedUntil.Time,
+		LastError:      sql.NullString{String: cause.Error(), Valid: true},
+		ResponseStatus: nullStatus(status),
+		NextAttemptAt:  d.now().Add(d.Backoff(delivery.Attempts + 1)),
+	})
+	if err != nil || n == 0 {
+		return outcomeLost, err
+	}
+	return outcomeRetried, nil
+}
+
+// finish settles a delivery and its redemption. A failed redemption gets its
+// points back and, as when it is cancelled, returns its unit of stock and
+// gives up its voucher code; redemptions no longer pending, such as expired
+// ones, are left as they are.
+func (d *Dispatcher) finish(ctx context.Context, q Store, delivery db.FulfillmentDelivery, status, redemptionStatus string, responseStatus int, cause error) (outcome, error) {
+	result := outcomeDelivered
+	if status == StatusFailed {
+		result = outcomeFailed
+	}
+	var lastError sql.NullString
+	if cause != nil {
+		lastError = sql.NullString{String: cause.Error(), Valid: true}
+	}
+	n, err := q.FinishFulfillmentDelivery(ctx, db.FinishFulfillmentDeliveryParams{
+		ID:             delivery.ID,
+		LockedUntil:    delivery.LockedUntil.Time,
+		Status:         status,
+		LastError:      lastError,
+		ResponseStatus: nullStatus(responseStatus),
+	})
+	if err != nil || n == 0 {
+		return outcomeLost, err
+	}
+	settled, err := q.FinishPendingRedemption(ctx, db.FinishPendingRedemptionParams{
+		ID:     delivery.RedemptionID,
+		Status: redemptionStatus,
+	})
+	if err != nil || settled == 0 || redemptionStatus != RedemptionFailed {
+		return result, err
+	}
+	redemption, err := q.GetRedemption(ctx, delivery.RedemptionID)
+	if err != nil {
+		return result, err
+	}
+	_, err = q.CreatePointsEvent(ctx, db.CreatePointsEventParams{
+		UserID:    redemption.UserID,
+		EventType: RefundEventType,
+		RefID:     sql.NullString{String: redemption.ID.String(), Valid: true},
+		Points:    redemption.PointsSpent,
+	})
+	if err != nil {
+		return result, err
+	}
+	if err := inventory.Release(ctx, q, redemption.RewardID, redemption.CreatedAt); err != nil {
+		return result, fmt.Errorf("release stock: %w", err)
+	}
+	if !redemption.VoucherCode.Valid {
+		return result, nil
+	}
+	// The code was shown while the redemption was pending, so it is voided
+	// rather than reissued
+	if err := vouchers.Withdraw(ctx, q, redemption.ID, true); err != nil {
+		return result, fmt.Errorf("withdraw voucher code: %w", err)
+	}
+	return result, q.SetRedemptionVoucherCode(ctx, db.SetRedemptionVoucherCodeParams{ID: redemption.ID})
+}
+
+// nullStatus converts a response status, 0 when there was no response
+func nullStatus(status int) sql.NullInt32 {
+	return sql.NullInt32{Int32: int32(status), Valid: status != 0}
+}
@@ -0,0 +1,421 @@
+package fulfillment
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"encore.app/internal/db"
+	"encore.app/internal/inventory"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStore keeps deliveries, redemptions and points events in memory
+type fakeStore struct {
+	deliveries  []db.FulfillmentDelivery
+	redemptions map[uuid.UUID]*db.Redemption
+	reward      db.RewardsCatalog
+	events      []db.CreatePointsEventParams
+	inTx        bool
+	// released are the days stock was returned for; voided the redemptions
+	// whose voucher codes were voided
+	released []db.ReleaseDailyRewardStockParams
+	voided   []uuid.UUID
+}
+
+// tx runs fn as a transaction on the store
+func (f *fakeStore) tx(ctx context.Context, fn func(q Store) error) error {
+	f.inTx = true
+	defer func() { f.inTx = false }()
+	return fn(f)
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		redemptions: make(map[uuid.UUID]*db.Redemption),
+		reward:      webhookReward("https://partner.example/redemptions"),
+	}
+}
+
+// add queues a delivery for a new pending redemption
+func (f *fakeStore) add(attempts int32) *db.FulfillmentDelivery {
+	r := &db.Redemption{ID: uuid.New(), UserID: uuid.New(), RewardID: f.reward.ID, PointsSpent: 500, Status: "PENDING"}
+	f.redemptions[r.ID] = r
+	f.deliveries = append(f.deliveries, db.FulfillmentDelivery{
+		ID:           uuid.New(),
+		RedemptionID: r.ID,
+		RewardID:     f.reward.ID,
+		Adapter:      AdapterWebhook,
+		Payload:      []byte(`{"redemption_id":"` + r.ID.String() + `"}`),
+		Status:       StatusPending,
+		Attempts:     attempts,
+	})
+	return &f.deliveries[len(f.deliveries)-1]
+}
+
+func (f *fakeStore) ClaimDueFulfillmentDeliveries(ctx context.Context, arg db.ClaimDueFulfillmentDeliveriesParams) ([]db.FulfillmentDelivery, error) {
+	var due []db.FulfillmentDelivery
+	for i := range f.deliveries {
+		d := &f.deliveries[i]
+		leased := d.LockedUntil.Valid && d.LockedUntil.Time.After(arg.Now)
+		if d.Status == StatusPending && !d.NextAttemptAt.After(arg.Now) && !leased && int32(len(due)) < arg.BatchSize {
+			d.LockedUntil = sql.NullTime{Time: arg.LockedUntil, Valid: true}
+			due = append(due, *d)
+		}
+	}
+	return due, nil
+}
+
+func (f *fakeStore) GetReward(ctx context.Context, id uuid.UUID) (db.RewardsCatalog, error) {
+	return f.reward, nil
+}
+
+func (f *fakeStore) GetRedemption(ctx context.Context, id uuid.UUID) (db.Redemption, error) {
+	r, ok := f.redemptions[id]
+	if !ok {
+		return db.Redemption{}, sql.ErrNoRows
+	}
+	return *r, nil
+}
+
+func (f *fakeStore) delivery(id uuid.UUID) *db.FulfillmentDelivery {
+	for i := range f.deliveries {
+		if f.deliveries[i].ID == id {
+			return &f.deliveries[i]
+		}
+	}
+	return nil
+}
+
+// leased returns the delivery if it is pending and still leased until
+// lockedUntil
+func (f *fakeStore) leased(id uuid.UUID, lockedUntil time.Time) *db.FulfillmentDelivery {
+	d := f.delivery(id)
+	if d == nil || d.Status != StatusPending || !d.LockedUntil.Valid || !d.LockedUntil.Time.Equal(lockedUntil) {
+		return nil
+	}
+	return d
+}
+
+func (f *fakeStore) FinishFulfillmentDelivery(ctx context.Context, arg db.FinishFulfillmentDeliveryParams) (int64, error) {
+	d := f.leased(arg.ID, arg.LockedUntil)
+	if d == nil {
+		return 0, nil
+	}
+	d.Status = arg.Status
+	d.Attempts++
+	d.LastError = arg.LastError
+	d.ResponseStatus = arg.ResponseStatus
+	d.LockedUntil = sql.NullTime{}
+	return 1, nil
+}
+
+func (f *fakeStore) RetryFulfillmentDelivery(ctx context.Context, arg db.RetryFulfillmentDeliveryParams) (int64, error) {
+	d := f.leased(arg.ID, arg.LockedUntil)
+	if d == nil {
+		return 0, nil
+	}
+	d.Attempts++
+	d.LastError = arg.LastError
+	d.ResponseStatus = arg.ResponseStatus
+	d.NextAttemptAt = arg.NextAttemptAt
+	d.LockedUntil = sql.NullTime{}
+	return 1, nil
+}
+
+func (f *fakeStore) FinishPendingRedemption(ctx context.Context, arg db.FinishPendingRedemptionParams) (int64, error) {
+	r := f.redemptions[arg.ID]
+	if r.Status != "PENDING" {
+		return 0, nil
+	}
+	r.Status = arg.Status
+	return 1, nil
+}
+
+func (f *fakeStore) CreatePointsEvent(ctx context.Context, arg db.CreatePointsEventParams) (db.PointsEvent, error) {
+	f.events = append(f.events, arg)
+	return db.PointsEvent{ID: uuid.New()}, nil
+}
+
+func (f *fakeStore) ReleaseRewardStock(ctx context.Context, id uuid.UUID) error {
+	return nil
+}
+
+func (f *fakeStore) ReleaseDailyRewardStock(ctx context.Context, arg db.ReleaseDailyRewardStockParams) error {
+	f.released = append(f.released, arg)
+	return nil
+}
+
+func (f *fakeStore) ReleaseRewardCode(ctx context.Context, redemptionID uuid.NullUUID) (int64, error) {
+	return 0, nil
+}
+
+func (f *fakeStore) VoidRewardCode(ctx context.Context, redemptionID uuid.NullUUID) error {
+	f.voided = append(f.voided, redemptionID.UUID)
+	return nil
+}
+
+func (f *fakeStore) SetRedemptionVoucherCode(ctx context.Context, arg db.SetRedemptionVoucherCodeParams) error {
+	f.redemptions[arg.ID].VoucherCode = arg.VoucherCode
+	return nil
+}
+
+func (f *fakeStore) CreateFulfillmentDelivery(ctx context.Context, arg db.CreateFulfillmentDeliveryParams) (int64, error) {
+	for _, d := range f.deliveries {
+		if d.RedemptionID == arg.RedemptionID {
+			return 0, nil
+		}
+	}
+	f.deliveries = append(f.deliveries, db.FulfillmentDelivery{ID: uuid.New(), RedemptionID: arg.RedemptionID, Adapter: arg.Adapter, Payload: arg.Payload, Status: StatusPending})
+	return 1, nil
+}
+
+// fakeAdapter returns the queued outcomes in turn, calling during first
+// when set
+type fakeAdapter struct {
+	outcomes []error
+	calls    int
+	during   func(delivery db.FulfillmentDelivery)
+}
+
+func (a *fakeAdapter) Deliver(ctx context.Context, delivery db.FulfillmentDelivery, reward db.RewardsCatalog) (int, error) {
+	if a.during != nil {
+		a.during(delivery)
+	}
+	err := a.outcomes[a.calls%len(a.outcomes)]
+	a.calls++
+	switch {
+	case err == nil:
+		return 200, nil
+	case errors.Is(err, ErrRejected):
+		return 422, err
+	}
+	return 503, err
+}
+
+func webhookReward(url string) db.RewardsCatalog {
+	return db.RewardsCatalog{
+		ID:                 uuid.New(),
+		Name:               "Coffee at Brew & Charge",
+		Cost:               500,
+		Active:             true,
+		FulfillmentAdapter: sql.NullString{String: AdapterWebhook, Valid: true},
+		FulfillmentUrl:     sql.NullString{String: url, Valid: true},
+		FulfillmentSecret:  sql.NullString{String: "whsec_0123456789abcdef", Valid: true},
+	}
+}
+
+func TestValidateReward(t *testing.T) {
+	valid := func(s string) sql.NullString { return sql.NullString{String: s, Valid: true} }
+	tests := []struct {
+		name    string
+		adapter sql.NullString
+		url     sql.NullString
+		secret  sql.NullString
+		want    error
+	}{
+		{"no adapter", sql.NullString{}, sql.NullString{}, sql.NullString{}, nil},
+		{"webhook", valid("webhook"), valid("https://partner.example/hook"), valid("whsec_0123456789abcdef"), nil},
+		{"unknown adapter", valid("email"), valid("https://partner.example/hook"), valid("whsec_0123456789abcdef"), ErrInvalidAdapter},
+		{"relative url", valid("webhook"), valid("/hook"), valid("whsec_0123456789abcdef"), ErrURLRequired},
+		{"plain http", valid("webhook"), valid("http://partner.example/hook"), valid("whsec_0123456789abcdef"), ErrURLRequired},
+		{"localhost", valid("webhook"), valid("https://localhost:8443/hook"), valid("whsec_0123456789abcdef"), ErrURLRequired},
+		{"loopback address", valid("webhook"), valid("https://127.0.0.1/hook"), valid("whsec_0123456789abcdef"), ErrURLRequired},
+		{"private address", valid("webhook"), valid("https://10.0.0.12/hook"), valid("whsec_0123456789abcdef"), ErrURLRequired},
+		{"link-local address", valid("webhook"), valid("https://169.254.169.254/latest"), valid("whsec_0123456789abcdef"), ErrURLRequired},
+		{"ipv6 loopback", valid("webhook"), valid("https://[::1]/hook"), valid("whsec_0123456789abcdef"), ErrURLRequired},
+		{"public address", valid("webhook"), valid("https://203.0.114.10/hook"), valid("whsec_0123456789abcdef"), nil},
+		{"short secret", valid("webhook"), valid("https://partner.example/hook"), valid("secret"), ErrSecretRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ValidateReward(tt.adapter, tt.url, tt.secret))
+		})
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	q := newFakeStore()
+	redemptionID := uuid.New()
+
+	queued, err := Enqueue(context.Background(), q, q.reward, redemptionID, map[string]string{"redemption_id": redemptionID.String()})
+	require.NoError(t, err)
+	assert.True(t, queued)
+
+	// A redelivered event queues nothing more
+	queued, err = Enqueue(context.Background(), q, q.reward, redemptionID, map[string]string{"redemption_id": redemptionID.String()})
+	require.NoError(t, err)
+	assert.False(t, queued)
+
+	queued, err = Enqueue(context.Background(), q, db.RewardsCatalog{ID: uuid.New()}, uuid.New(), struct{}{})
+	require.NoError(t, err)
+	assert.False(t, queued)
+	assert.Len(t, q.deliveries, 1)
+}
+
+func TestDispatcher_DeliverDue(t *testing.T) {
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	q := newFakeStore()
+	accepted := q.add(0)
+	rejected := q.add(0)
+	flaky := q.add(0)
+	exhausted := q.add(DefaultMaxAttempts - 1)
+
+	adapter := &fakeAdapter{outcomes: []error{
+		nil,
+		fmt.Errorf("%w: unknown voucher", ErrRejected),
+		errors.New("connection refused"),
+		errors.New("connection refused"),
+	}}
+	d := NewDispatcher(Config{}, map[string]Adapter{AdapterWebhook: adapter})
+	d.now = func() time.Time { return now }
+
+	summary, err := d.DeliverDue(context.Background(), q.tx)
+	require.NoError(t, err)
+	assert.Equal(t, Summary{Delivered: 1, Failed: 2, Retried: 1}, summary)
+
+	assert.Equal(t, StatusDelivered, q.delivery(accepted.ID).Status)
+	assert.Equal(t, RedemptionFulfilled, q.redemptions[accepted.RedemptionID].Status)
+
+	assert.Equal(t, StatusFailed, q.delivery(rejected.ID).Status)
+	assert.Equal(t, int32(422), q.delivery(rejected.ID).ResponseStatus.Int32)
+	assert.Equal(t, RedemptionFailed, q.redemptions[rejected.RedemptionID].Status)
+
+	retry := q.delivery(flaky.ID)
+	assert.Equal(t, StatusPending, retry.Status)
+	assert.Equal(t, int32(1), retry.Attempts)
+	assert.Equal(t, now.Add(DefaultMinBackoff), retry.NextAttemptAt)
+	assert.Equal(t, "connection refused", retry.LastError.String)
+
+	assert.Equal(t, StatusFailed, q.delivery(exhausted.ID).Status)
+	assert.Equal(t, RedemptionFailed, q.redemptions[exhausted.RedemptionID].Status)
+
+	// Failed redemptions get their points back
+	require.Len(t, q.events, 2)
+	assert.Equal(t, RefundEventType, q.events[0].EventType)
+	assert.Equal(t, int32(500), q.events[0].Points)
+	assert.Equal(t, rejected.RedemptionID.String(), q.events[0].RefID.String)
+
+	// The retry is not due until its backoff has passed
+	summary, err = d.DeliverDue(context.Background(), q.tx)
+	require.NoError(t, err)
+	assert.Zero(t, summary.Attempts())
+}
+
+func TestDispatcher_ExpiredRedemptionNotRefunded(t *testing.T) {
+	q := newFakeStore()
+	delivery := q.add(0)
+	q.redemptions[delivery.RedemptionID].Status = "EXPIRED"
+
+	d := NewDispatcher(Config{}, map[string]Adapter{AdapterWebhook: &fakeAdapter{outcomes: []error{ErrRejected}}})
+	summary, err := d.DeliverDue(context.Background(), q.tx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.Failed)
+	assert.Equal(t, "EXPIRED", q.redemptions[delivery.RedemptionID].Status)
+	assert.Empty(t, q.events)
+	assert.Empty(t, q.released)
+	assert.Empty(t, q.voided)
+}
+
+func TestDispatcher_FailedReturnsStockAndCode(t *testing.T) {
+	q := newFakeStore()
+	withCode, withoutCode := q.add(0), q.add(0)
+	createdAt := time.Date(2024, 6, 1, 23, 30, 0, 0, time.UTC)
+	for _, delivery := range []*db.FulfillmentDelivery{withCode, withoutCode} {
+		q.redemptions[delivery.RedemptionID].CreatedAt = createdAt
+	}
+	q.redemptions[withCode.RedemptionID].VoucherCode = sql.NullString{String: "ACME-7KQ2", Valid: true}
+
+	d := NewDispatcher(Config{}, map[string]Adapter{AdapterWebhook: &fakeAdapter{outcomes: []error{ErrRejected}}})
+	summary, err := d.DeliverDue(context.Background(), q.tx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, summary.Failed)
+
+	// Both units go back to the stock of the day they were claimed
+	want := db.ReleaseDailyRewardStockParams{RewardID: q.reward.ID, Day: inventory.Day(createdAt)}
+	assert.Equal(t, []db.ReleaseDailyRewardStockParams{want, want}, q.released)
+
+	// The code the user saw is voided and no longer shown
+	assert.Equal(t, []uuid.UUID{withCode.RedemptionID}, q.voided)
+	assert.False(t, q.redemptions[withCode.RedemptionID].VoucherCode.Valid)
+}
+
+func TestDispatcher_DeliversOutsideTransaction(t *testing.T) {
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	q := newFakeStore()
+	delivery := q.add(0)
+
+	var concurrent Summary
+	adapter := &fakeAdapter{outcomes: []error{nil}}
+	d := NewDispatcher(Config{}, map[string]Adapter{AdapterWebhook: adapter})
+	d.now = func() time.Time { return now }
+	adapter.during = func(db.FulfillmentDelivery) {
+		assert.False(t, q.inTx, "partner called inside a transaction")
+		assert.Equal(t, now.Add(DefaultLease), q.delivery(delivery.ID).LockedUntil.Time)
+		// A concurrent batch skips the leased delivery
+		var err error
+		other := NewDispatcher(Config{}, map[string]Adapter{AdapterWebhook: &fakeAdapter{outcomes: []error{nil}}})
+		other.now = d.now
+		concurrent, err = other.DeliverDue(context.Background(), q.tx)
+		require.NoError(t, err)
+	}
+
+	summary, err := d.DeliverDue(context.Background(), q.tx)
+	require.NoError(t, err)
+	assert.Equal(t, Summary{Delivered: 1}, summary)
+	assert.Zero(t, concurrent.Attempts())
+	assert.Equal(t, 1, adapter.calls)
+	assert.False(t, q.delivery(delivery.ID).LockedUntil.Valid)
+}
+
+func TestDispatcher_LostLease(t *testing.T) {
+	q := newFakeStore()
+	cancelled := q.add(0)
+
+	// The redemption is cancelled while the partner is called
+	adapter := &fakeAdapter{outcomes: []error{nil}, during: func(delivery db.FulfillmentDelivery) {
+		q.delivery(delivery.ID).Status = "CANCELLED"
+		q.redemptions[delivery.RedemptionID].Status = "CANCELLED"
+	}}
+	d := NewDispatcher(Config{}, map[string]Adapter{AdapterWebhook: adapter})
+
+	summary, err := d.DeliverDue(context.Background(), q.tx)
+	require.NoError(t, err)
+	assert.Zero(t, summary.Attempts())
+	assert.Equal(t, "CANCELLED", q.delivery(cancelled.ID).Status)
+	assert.Zero(t, q.delivery(cancelled.ID).Attempts)
+	assert.Equal(t, "CANCELLED", q.redemptions[cancelled.RedemptionID].Status)
+}
+
+func TestDispatcher_ExpiredLeaseRetried(t *testing.T) {
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	q := newFakeStore()
+	stuck := q.add(0)
+	// A run died mid-attempt, leaving its lease behind
+	stuck.LockedUntil = sql.NullTime{Time: now.Add(time.Minute), Valid: true}
+
+	d := NewDispatcher(Config{}, map[string]Adapter{AdapterWebhook: &fakeAdapter{outcomes: []error{nil}}})
+	d.now = func() time.Time { return now }
+	summary, err := d.DeliverDue(context.Background(), q.tx)
+	require.NoError(t, err)
+	assert.Zero(t, summary.Attempts())
+
+	now = now.Add(2 * time.Minute)
+	summary, err = d.DeliverDue(context.Background(), q.tx)
+	require.NoError(t, err)
+	assert.Equal(t, Summary{Delivered: 1}, summary)
+	assert.Equal(t, StatusDelivered, q.delivery(stuck.ID).Status)
+}
+
+func TestDispatcher_Backoff(t *testing.T) {
+	d := NewDispatcher(Config{MinBackoff: time.Minute, MaxBackoff: 10 * time.Minute}, nil)
+	assert.Equal(t, time.Minute, d.Backoff(1))
+	assert.Equal(t, 2*time.Minute, d.Backoff(2))
+	assert.Equal(t, 8*time.Minute, d.Backoff(4))
+	assert.Equal(t, 10*time.Minute, d.Backoff(5))
+}
@@ -0,0 +1,203 @@
+package fulfillment
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"encore.app/internal/db"
+)
+
+// Webhook request headers
+const (
+	// SignatureHeader carries "t=<unix seconds>,v1=<hex HMAC-SHA256>" signing
+	// "<t>.<body>" with the reward's fulfillment_secret
+	SignatureHeader = "X-Urja-Signature"
+	// EventHeader names the event delivered
+	EventHeader = "X-Urja-Event"
+	// DeliveryHeader is stable across retries, so partners can drop duplicates
+	DeliveryHeader = "X-Urja-Delivery"
+)
+
+// EventRedemptionCreated is the EventHeader value of redemption deliveries
+const EventRedemptionCreated = "redemption.created"
+
+// DefaultWebhookTimeout bounds a partner's response time
+const DefaultWebhookTimeout = 10 * time.Second
+
+// DefaultSignatureTolerance is how old a signature Verify accepts
+const DefaultSignatureTolerance = 5 * time.Minute
+
+// maxErrorBody bounds the partner response kept in errors
+const maxErrorBody = 512
+
+// ErrPrivateAddress is returned when a partner host resolves to a private,
+// loopback or otherwise internal address
+var ErrPrivateAddress = errors.New("partner address is not public")
+
+// Signature verification errors
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrSignatureExpired = errors.New("webhook signature timestamp outside tolerance")
+)
+
+// Webhook delivers redemptions as signed JSON POSTs to the reward's
+// fulfillment_url. 2xx responses fulfill the redemption; 408, 429 and 5xx
+// responses and network errors are retried; other responses reject it.
+type Webhook struct {
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewWebhook creates a webhook adapter; a nil client uses one with
+// DefaultWebhookTimeout that only connects to public addresses
+func NewWebhook(client *http.Client) *Webhook {
+	if client == nil {
+		client = publicClient()
+	}
+	return &Webhook{client: client, now: time.Now}
+}
+
+// publicClient returns a client refusing to connect to internal addresses,
+// whatever the partner's host resolves to at delivery time and wherever it
+// redirects. It connects directly, as a proxy would hide the address.
+func publicClient() *http.Client {
+	dialer := &net.Dialer{Timeout: DefaultWebhookTimeout, Control: dialPublic}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: DefaultWebhookTimeout, Transport: transport}
+}
+
+// dialPublic refuses connections to addresses that are not public
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+	}
+	return nil
+}
+
+// Deliver posts the delivery's payload to the partner
+func (w *Webhook) Deliver(ctx context.Context, delivery db.FulfillmentDelivery, reward db.RewardsCatalog) (int, error) {
+	if err := ValidateReward(reward.FulfillmentAdapter, reward.FulfillmentUrl, reward.FulfillmentSecret); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reward.FulfillmentUrl.String, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, EventRedemptionCreated)
+	req.Header.Set(DeliveryHeader, delivery.ID.String())
+	req.Header.Set(SignatureHeader, Sign(reward.FulfillmentSecret.String, w.now().Unix(), delivery.Payload))
+
+	resp, err := w.client.Do(req)
+	if errors.Is(err, ErrPrivateAddress) {
+		return 0, fmt.Errorf("%w: %w", ErrRejected, err)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return resp.StatusCode, nil
+	case retryable(resp.StatusCode):
+		return resp.StatusCode, fmt.Errorf("partner responded %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return resp.StatusCode, fmt.Errorf("%w: partner responded %d: %s", ErrRejected, resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+// retryable reports whether a partner response may succeed on retry
+func retryable(status int) bool {
+	return status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+}
+
+// Sign returns the SignatureHeader value for body sent at timestamp
+func Sign(secret string, timestamp int64, body []byte) string {
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, signature(secret, timestamp, body))
+}
+
+// Verify checks a SignatureHeader value against body, as partners do on
+// receipt. Signatures older or newer than tolerance are refused to stop
+// replays.
+func Verify(secret, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	var timestamp int64
+	var sig string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			t, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return ErrInvalidSignature
+			}
+			timestamp = t
+		case "v1":
+			sig = value
+		}
+	}
+	if timestamp == 0 || sig == "" {
+		return ErrInvalidSignature
+	}
+	if d := now.Sub(time.Unix(timestamp, 0)); d > tolerance || d < -tolerance {
+		return ErrSignatureExpired
+	}
+	if !hmac.Equal([]byte(sig), []byte(signature(secret, timestamp, body))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// signature is the hex HMAC-SHA256 of "<timestamp>.<body>"
+func signature(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validURL reports whether s is an absolute https URL whose host is not
+// local or an internal address
+func validURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "https" {
+		return false
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return publicIP(ip)
+	}
+	return true
+}
+
+// cgnat is the shared address space carriers use internally (RFC 6598)
+var cgnat = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicIP reports whether ip is a public unicast address
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || cgnat.Contains(ip))
+}
@@ -0,0 +1,170 @@
+package fulfillment
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"encore.app/internal/db"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubPartner is a local partner endpoint answering with the queued status
+// codes in turn and recording what it received
+type stubPartner struct {
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+	verified []error
+}
+
+func (p *stubPartner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	p.requests = append(p.requests, r)
+	p.bodies = append(p.bodies, body)
+	p.verified = append(p.verified, Verify("whsec_0123456789abcdef", r.Header.Get(SignatureHeader), body, time.Now(), DefaultSignatureTolerance))
+	status := p.statuses[(len(p.requests)-1)%len(p.statuses)]
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte("stub response"))
+}
+
+// partnerURL is where tests reach the local partner; its TLS certificate
+// covers example.com
+const partnerURL = "https://example.com/redemptions"
+
+// partnerClient returns a client trusting server that connects to it for
+// every host, so rewards can use a public partnerURL
+func partnerClient(server *httptest.Server) *http.Client {
+	client := server.Client()
+	transport := client.Transport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+	}
+	client.Transport = transport
+	return client
+}
+
+func TestWebhook_Deliver(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		wantErr      bool
+		wantRejected bool
+	}{
+		{"accepted", http.StatusOK, false, false},
+		{"accepted async", http.StatusAccepted, false, false},
+		{"partner down", http.StatusServiceUnavailable, true, false},
+		{"rate limited", http.StatusTooManyRequests, true, false},
+		{"rejected", http.StatusUnprocessableEntity, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			partner := &stubPartner{statuses: []int{tt.status}}
+			server := httptest.NewTLSServer(partner)
+			defer server.Close()
+
+			delivery := db.FulfillmentDelivery{ID: uuid.New(), Payload: []byte(`{"redemption_id":"r-1"}`)}
+			status, err := NewWebhook(partnerClient(server)).Deliver(context.Background(), delivery, webhookReward(partnerURL))
+			assert.Equal(t, tt.status, status)
+			if tt.wantErr {
+				require.Error(t, err)
+				assert.Equal(t, tt.wantRejected, isRejected(err))
+			} else {
+				require.NoError(t, err)
+			}
+
+			require.Len(t, partner.requests, 1)
+			r := partner.requests[0]
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			assert.Equal(t, EventRedemptionCreated, r.Header.Get(EventHeader))
+			assert.Equal(t, delivery.ID.String(), r.Header.Get(DeliveryHeader))
+			assert.JSONEq(t, `{"redemption_id":"r-1"}`, string(partner.bodies[0]))
+			assert.NoError(t, partner.verified[0])
+		})
+	}
+}
+
+func TestWebhook_PartnerUnreachable(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	client := partnerClient(server)
+	server.Close()
+
+	_, err := NewWebhook(client).Deliver(context.Background(), db.FulfillmentDelivery{ID: uuid.New(), Payload: []byte(`{}`)}, webhookReward(partnerURL))
+	require.Error(t, err)
+	assert.False(t, isRejected(err))
+}
+
+func TestWebhook_InternalAddressRejected(t *testing.T) {
+	partner := &stubPartner{statuses: []int{http.StatusOK}}
+	server := httptest.NewTLSServer(partner)
+	defer server.Close()
+
+	// The default client refuses the loopback address the host resolves to
+	webhook := NewWebhook(nil)
+	webhook.client.Transport.(*http.Transport).TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig
+	dial := webhook.client.Transport.(*http.Transport).DialContext
+	webhook.client.Transport.(*http.Transport).DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return dial(ctx, network, server.Listener.Addr().String())
+	}
+
+	_, err := webhook.Deliver(context.Background(), db.FulfillmentDelivery{ID: uuid.New(), Payload: []byte(`{}`)}, webhookReward(partnerURL))
+	require.Error(t, err)
+	assert.True(t, isRejected(err))
+	assert.ErrorIs(t, err, ErrPrivateAddress)
+	assert.Empty(t, partner.requests)
+
+	// Stored rewards are checked again before delivery
+	_, err = NewWebhook(partnerClient(server)).Deliver(context.Background(), db.FulfillmentDelivery{ID: uuid.New(), Payload: []byte(`{}`)}, webhookReward(server.URL))
+	assert.True(t, isRejected(err))
+	assert.Empty(t, partner.requests)
+}
+
+func TestWebhook_RetriesUntilAccepted(t *testing.T) {
+	partner := &stubPartner{statuses: []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusOK}}
+	server := httptest.NewTLSServer(partner)
+	defer server.Close()
+
+	q := newFakeStore()
+	q.reward = webhookReward(partnerURL)
+	delivery := q.add(0)
+
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	d := NewDispatcher(Config{}, map[string]Adapter{AdapterWebhook: NewWebhook(partnerClient(server))})
+	for i := 0; i < 3; i++ {
+		d.now = func() time.Time { return now }
+		_, err := d.DeliverDue(context.Background(), q.tx)
+		require.NoError(t, err)
+		now = now.Add(time.Hour)
+	}
+
+	assert.Len(t, partner.requests, 3)
+	assert.Equal(t, StatusDelivered, q.delivery(delivery.ID).Status)
+	assert.Equal(t, int32(3), q.delivery(delivery.ID).Attempts)
+	assert.Equal(t, RedemptionFulfilled, q.redemptions[delivery.RedemptionID].Status)
+	// Every attempt carries the same delivery ID
+	assert.Equal(t, partner.requests[0].Header.Get(DeliveryHeader), partner.requests[2].Header.Get(DeliveryHeader))
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1717236000, 0)
+	body := []byte(`{"redemption_id":"r-1"}`)
+	header := Sign("whsec_0123456789abcdef", now.Unix(), body)
+
+	assert.NoError(t, Verify("whsec_0123456789abcdef", header, body, now, DefaultSignatureTolerance))
+	assert.ErrorIs(t, Verify("whsec_other_secret_value", header, body, now, DefaultSignatureTolerance), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("whsec_0123456789abcdef", header, []byte(`{"redemption_id":"r-2"}`), now, DefaultSignatureTolerance), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("whsec_0123456789abcdef", header, body, now.Add(time.Hour), DefaultSignatureTolerance), ErrSignatureExpired)
+	assert.ErrorIs(t, Verify("whsec_0123456789abcdef", "v1=abc", body, now, DefaultSignatureTolerance), ErrInvalidSignature)
+}
+
+func isRejected(err error) bool {
+	return errors.Is(err, ErrRejected)
+}
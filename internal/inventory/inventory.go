@@ -0,0 +1,160 @@
+// Package inventory tracks reward stock.
+//
+// A reward can limit the units available in total (stock_total) and per UTC
+// day (daily_stock). Units are claimed inside the redemption's transaction so
+// they are returned if the redemption fails, and taking the last unit of the
+// total stock deactivates the reward. The deactivation is queued to the
+// outbox in the same transaction, so consumers of reward change events learn
+// the reward went inactive.
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"encore.app/internal/db"
+	"encore.app/internal/outbox"
+	"github.com/google/uuid"
+)
+
+// Errors returned by Claim
+var (
+	ErrSoldOut      = errors.New("reward is sold out")
+	ErrSoldOutToday = errors.New("reward is sold out for today")
+)
+
+// RewardOutboxTopic is the outbox topic of reward change events; the admin
+// service's relay publishes them as RewardUpdated events
+const RewardOutboxTopic = "reward-updated"
+
+// SoldOut is queued to RewardOutboxTopic when Claim deactivates a reward. The
+// admin service publishes it as an "updated" RewardUpdated event carrying the
+// reward's state rendered from Snapshot.
+type SoldOut struct {
+	RewardID   uuid.UUID `json:"reward_id"`
+	Action     string    `json:"action"`
+	RewardName string    `json:"reward_name"`
+	Version    int32     `json:"version"`
+	Timestamp  time.Time `json:"timestamp"`
+	// Snapshot is the deactivated reward without its fulfillment secret
+	Snapshot db.RewardsCatalog `json:"snapshot"`
+}
+
+// Store is the subset of *db.Queries used to claim stock
+type Store interface {
+	ClaimRewardStock(ctx context.Context, id uuid.UUID) (db.RewardsCatalog, error)
+	ClaimDailyRewardStock(ctx context.Context, arg db.ClaimDailyRewardStockParams) (int64, error)
+	outbox.Enqueuer
+}
+
+// Day returns the UTC day daily stock is counted against
+func Day(now time.Time) time.Time {
+	y, m, d := now.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// Claim takes one unit of a reward's total and daily stock. It returns
+// ErrSoldOut when the reward has no units left or is inactive. Taking the
+// last unit queues a SoldOut event, so call it in a transaction.
+func Claim(ctx context.Context, q Store, rewardID uuid.UUID, now time.Time) (db.RewardsCatalog, error) {
+	reward, err := q.ClaimRewardStock(ctx, rewardID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return reward, ErrSoldOut
+		}
+		return reward, err
+	}
+
+	if reward.DailyStock.Valid {
+		if reward.DailyStock.Int32 <= 0 {
+			return reward, ErrSoldOutToday
+		}
+		claimed, err := q.ClaimDailyRewardStock(ctx, db.ClaimDailyRewardStockParams{
+			RewardID:   rewardID,
+			Day:        Day(now),
+			DailyStock: reward.DailyStock.Int32,
+		})
+		if err != nil {
+			return reward, err
+		}
+		if claimed == 0 {
+			return reward, ErrSoldOutToday
+		}
+	}
+
+	// ClaimRewardStock only returns active rewards it did not deactivate
+	if !reward.Active {
+		if err := enqueueSoldOut(ctx, q, reward, now); err != nil {
+			return reward, err
+		}
+	}
+	return reward, nil
+}
+
+// enqueueSoldOut queues the SoldOut event of a reward Claim deactivated
+func enqueueSoldOut(ctx context.Context, q outbox.Enqueuer, reward db.RewardsCatalog, now time.Time) error {
+	snapshot := reward
+	snapshot.FulfillmentSecret = sql.NullString{}
+	return outbox.Enqueue(ctx, q, RewardOutboxTopic, reward.ID.String(), &SoldOut{
+		RewardID:   reward.ID,
+		Action:     "updated",
+		RewardName: reward.Name,
+		Version:    reward.Version,
+		Timestamp:  now,
+		Snapshot:   snapshot,
+	})
+}
+
+// Releaser is the subset of *db.Queries used to return stock
+type Releaser interface {
+	ReleaseRewardStock(ctx context.Context, id uuid.UUID) error
+	ReleaseDailyRewardStock(ctx context.Context, arg db.ReleaseDailyRewardStockParams) error
+}
+
+// Release returns the unit a cancelled redemption claimed at claimedAt to the
+// reward's total stock and to that day's stock. A reward deactivated when it
+// sold out stays inactive until it is re-listed.
+func Release(ctx context.Context, q Releaser, rewardID uuid.UUID, claimedAt time.Time) error {
+	if err := q.ReleaseRewardStock(ctx, rewardID); err != nil {
+		return err
+	}
+	return q.ReleaseDailyRewardStock(ctx, db.ReleaseDailyRewardStockParams{
+		RewardID: rewardID,
+		Day:      Day(claimedAt),
+	})
+}
+
+// Remaining returns the units of a reward left in total and today, nil where
+// the stock is unlimited
+func Remaining(reward db.RewardsCatalog, redeemedToday int32) (total, today *int32) {
+	if reward.StockTotal.Valid {
+		n := reward.StockTotal.Int32 - reward.StockRedeemed
+		if n < 0 {
+			n = 0
+		}
+		total = &n
+	}
+	if reward.DailyStock.Valid {
+		n := reward.DailyStock.Int32 - redeemedToday
+		if n < 0 {
+			n = 0
+		}
+		today = &n
+	}
+	return total, today
+}
+
+// RedeemedToday returns the units of each reward claimed on now's UTC day
+func RedeemedToday(ctx context.Context, q *db.Queries, now time.Time) (map[uuid.UUID]int32, error) {
+	rows, err := q.ListDailyRewardRedemptions(ctx, Day(now))
+	if err != nil {
+		return nil, err
+	}
+	redeemed := make(map[uuid.UUID]int32, len(rows))
+	for _, row := range rows {
+		redeemed[row.RewardID] = row.Redeemed
+	}
+	return redeemed, nil
+}
@@ -0,0 +1,174 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"encore.app/internal/db"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStore applies the stock queries to a single reward and records the
+// queued events
+type fakeStore struct {
+	reward db.RewardsCatalog
+	daily  map[time.Time]int32
+	events []db.EnqueueOutboxEventParams
+}
+
+func (f *fakeStore) EnqueueOutboxEvent(ctx context.Context, arg db.EnqueueOutboxEventParams) (db.EventOutbox, error) {
+	f.events = append(f.events, arg)
+	return db.EventOutbox{}, nil
+}
+
+func (f *fakeStore) ClaimRewardStock(ctx context.Context, id uuid.UUID) (db.RewardsCatalog, error) {
+	r := &f.reward
+	if id != r.ID || !r.Active || (r.StockTotal.Valid && r.StockRedeemed >= r.StockTotal.Int32) {
+		return db.RewardsCatalog{}, sql.ErrNoRows
+	}
+	r.StockRedeemed++
+	if r.StockTotal.Valid && r.StockRedeemed >= r.StockTotal.Int32 {
+		r.Active = false
+		r.Version++
+	}
+	return *r, nil
+}
+
+func (f *fakeStore) ClaimDailyRewardStock(ctx context.Context, arg db.ClaimDailyRewardStockParams) (int64, error) {
+	if f.daily[arg.Day] >= arg.DailyStock {
+		return 0, nil
+	}
+	f.daily[arg.Day]++
+	return 1, nil
+}
+
+func (f *fakeStore) ReleaseRewardStock(ctx context.Context, id uuid.UUID) error {
+	if id == f.reward.ID && f.reward.StockRedeemed > 0 {
+		f.reward.StockRedeemed--
+	}
+	return nil
+}
+
+func (f *fakeStore) ReleaseDailyRewardStock(ctx context.Context, arg db.ReleaseDailyRewardStockParams) error {
+	if f.daily[arg.Day] > 0 {
+		f.daily[arg.Day]--
+	}
+	return nil
+}
+
+func stockedReward(total, daily int32) db.RewardsCatalog {
+	r := db.RewardsCatalog{ID: uuid.New(), Name: "Coupon", Cost: 100, Active: true}
+	if total >= 0 {
+		r.StockTotal = sql.NullInt32{Int32: total, Valid: true}
+	}
+	if daily >= 0 {
+		r.DailyStock = sql.NullInt32{Int32: daily, Valid: true}
+	}
+	return r
+}
+
+func TestClaim_Total(t *testing.T) {
+	q := &fakeStore{reward: stockedReward(2, -1), daily: map[time.Time]int32{}}
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	_, err := Claim(context.Background(), q, q.reward.ID, now)
+	require.NoError(t, err)
+	assert.True(t, q.reward.Active)
+
+	// The last unit deactivates the reward
+	_, err = Claim(context.Background(), q, q.reward.ID, now)
+	require.NoError(t, err)
+	assert.False(t, q.reward.Active)
+
+	_, err = Claim(context.Background(), q, q.reward.ID, now)
+	assert.ErrorIs(t, err, ErrSoldOut)
+	assert.Equal(t, int32(2), q.reward.StockRedeemed)
+}
+
+func TestClaim_SoldOutEvent(t *testing.T) {
+	q := &fakeStore{reward: stockedReward(2, -1), daily: map[time.Time]int32{}}
+	q.reward.Version = 3
+	q.reward.FulfillmentSecret = sql.NullString{String: "partner-key", Valid: true}
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	_, err := Claim(context.Background(), q, q.reward.ID, now)
+	require.NoError(t, err)
+	assert.Empty(t, q.events)
+
+	// Taking the last unit queues the reward's new state
+	_, err = Claim(context.Background(), q, q.reward.ID, now)
+	require.NoError(t, err)
+	require.Len(t, q.events, 1)
+	assert.Equal(t, RewardOutboxTopic, q.events[0].Topic)
+	assert.Equal(t, q.reward.ID.String(), q.events[0].OrderingKey)
+
+	var event SoldOut
+	require.NoError(t, json.Unmarshal(q.events[0].Payload, &event))
+	assert.Equal(t, "updated", event.Action)
+	assert.Equal(t, int32(4), event.Version)
+	assert.Equal(t, now, event.Timestamp)
+	assert.False(t, event.Snapshot.Active)
+	assert.Equal(t, int32(2), event.Snapshot.StockRedeemed)
+	assert.False(t, event.Snapshot.FulfillmentSecret.Valid, "the secret is not written to the outbox")
+}
+
+func TestClaim_Daily(t *testing.T) {
+	q := &fakeStore{reward: stockedReward(-1, 1), daily: map[time.Time]int32{}}
+	day1 := time.Date(2024, 6, 1, 23, 0, 0, 0, time.UTC)
+
+	_, err := Claim(context.Background(), q, q.reward.ID, day1)
+	require.NoError(t, err)
+	_, err = Claim(context.Background(), q, q.reward.ID, day1.Add(30*time.Minute))
+	assert.ErrorIs(t, err, ErrSoldOutToday)
+
+	// Stock resets at midnight UTC
+	_, err = Claim(context.Background(), q, q.reward.ID, day1.Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.True(t, q.reward.Active, "daily limits do not deactivate the reward")
+
+	q.reward.DailyStock.Int32 = 0
+	_, err = Claim(context.Background(), q, q.reward.ID, day1)
+	assert.ErrorIs(t, err, ErrSoldOutToday)
+}
+
+func TestRelease(t *testing.T) {
+	q := &fakeStore{reward: stockedReward(1, 1), daily: map[time.Time]int32{}}
+	now := time.Date(2024, 6, 1, 23, 30, 0, 0, time.UTC)
+
+	_, err := Claim(context.Background(), q, q.reward.ID, now)
+	require.NoError(t, err)
+	assert.False(t, q.reward.Active)
+
+	// The unit goes back to the total and to the day it was claimed on
+	require.NoError(t, Release(context.Background(), q, q.reward.ID, now))
+	assert.Zero(t, q.reward.StockRedeemed)
+	assert.Zero(t, q.daily[Day(now)])
+	assert.False(t, q.reward.Active)
+
+	// Nothing is released twice
+	require.NoError(t, Release(context.Background(), q, q.reward.ID, now))
+	assert.Zero(t, q.reward.StockRedeemed)
+}
+
+func TestRemaining(t *testing.T) {
+	total, today := Remaining(stockedReward(-1, -1), 0)
+	assert.Nil(t, total)
+	assert.Nil(t, today)
+
+	r := stockedReward(100, 10)
+	r.StockRedeemed = 97
+	total, today = Remaining(r, 4)
+	assert.Equal(t, int32(3), *total)
+	assert.Equal(t, int32(6), *today)
+
+	// Lowering the stock below what was redeemed never shows negative stock
+	r.StockTotal.Int32 = 50
+	total, today = Remaining(r, 12)
+	assert.Equal(t, int32(0), *total)
+	assert.Equal(t, int32(0), *today)
+}
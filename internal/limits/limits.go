@@ -0,0 +1,164 @@
+// Package limits enforces per-user redemption limits on rewards.
+//
+// A reward can cap how often one user redeems it in total (max_per_user) and
+// per UTC calendar month (max_per_user_monthly), and require a cooldown
+// between a user's redemptions (cooldown_seconds). Only redemptions that are
+// awaiting payment, pending or fulfilled count; expired ones do not.
+package limits
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"encore.app/internal/db"
+	"github.com/google/uuid"
+)
+
+// Errors wrapped by *Error, one per limit
+var (
+	ErrLifetimeLimit = errors.New("lifetime redemption limit reached")
+	ErrMonthlyLimit  = errors.New("monthly redemption limit reached")
+	ErrCooldown      = errors.New("reward is cooling down")
+)
+
+// Reasons reported by *Error
+const (
+	ReasonLifetimeLimit = "lifetime_limit"
+	ReasonMonthlyLimit  = "monthly_limit"
+	ReasonCooldown      = "cooldown"
+)
+
+// Error reports a limit the user has reached. It unwraps to ErrLifetimeLimit,
+// ErrMonthlyLimit or ErrCooldown.
+type Error struct {
+	// Reason is one of the Reason constants
+	Reason string
+	// Limit is the redemption count allowed, 0 for cooldowns
+	Limit int32
+	// Cooldown is the reward's cooldown, 0 for count limits
+	Cooldown time.Duration
+	// AvailableAt is when the user may redeem again; zero if never
+	AvailableAt time.Time
+}
+
+func (e *Error) Error() string {
+	switch e.Reason {
+	case ReasonLifetimeLimit:
+		return fmt.Sprintf("reward can be redeemed at most %s per user", times(e.Limit))
+	case ReasonMonthlyLimit:
+		return fmt.Sprintf("reward can be redeemed at most %s per user each month", times(e.Limit))
+	case ReasonCooldown:
+		return fmt.Sprintf("reward can be redeemed again at %s", e.AvailableAt.UTC().Format(time.RFC3339))
+	}
+	return "redemption limit reached"
+}
+
+// times formats a redemption count for error messages
+func times(n int32) string {
+	if n == 1 {
+		return "once"
+	}
+	return fmt.Sprintf("%d times", n)
+}
+
+func (e *Error) Unwrap() error {
+	switch e.Reason {
+	case ReasonLifetimeLimit:
+		return ErrLifetimeLimit
+	case ReasonMonthlyLimit:
+		return ErrMonthlyLimit
+	case ReasonCooldown:
+		return ErrCooldown
+	}
+	return nil
+}
+
+// Usage is a user's redemption history for one reward
+type Usage struct {
+	Total     int32
+	ThisMonth int32
+	// LastRedeemedAt is zero when the user never redeemed the reward
+	LastRedeemedAt time.Time
+}
+
+// Store is the subset of *db.Queries used to load a user's usage
+type Store interface {
+	CountUserRewardRedemptions(ctx context.Context, arg db.CountUserRewardRedemptionsParams) (db.CountUserRewardRedemptionsRow, error)
+	GetLastUserRewardRedemption(ctx context.Context, arg db.GetLastUserRewardRedemptionParams) (time.Time, error)
+}
+
+// MonthStart returns the start of now's UTC calendar month
+func MonthStart(now time.Time) time.Time {
+	y, m, _ := now.UTC().Date()
+	return time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Limited reports whether a reward has any per-user limit
+func Limited(reward db.RewardsCatalog) bool {
+	return reward.MaxPerUser.Valid || reward.MaxPerUserMonthly.Valid || reward.CooldownSeconds.Valid
+}
+
+// Check returns an *Error for the first limit the usage reaches, checking the
+// lifetime limit, then the monthly limit, then the cooldown
+func Check(reward db.RewardsCatalog, usage Usage, now time.Time) error {
+	if reward.MaxPerUser.Valid && usage.Total >= reward.MaxPerUser.Int32 {
+		return &Error{Reason: ReasonLifetimeLimit, Limit: reward.MaxPerUser.Int32}
+	}
+	if reward.MaxPerUserMonthly.Valid && usage.ThisMonth >= reward.MaxPerUserMonthly.Int32 {
+		return &Error{
+			Reason:      ReasonMonthlyLimit,
+			Limit:       reward.MaxPerUserMonthly.Int32,
+			AvailableAt: MonthStart(now).AddDate(0, 1, 0),
+		}
+	}
+	if reward.CooldownSeconds.Valid && !usage.LastRedeemedAt.IsZero() {
+		cooldown := time.Duration(reward.CooldownSeconds.Int32) * time.Second
+		if next := usage.LastRedeemedAt.Add(cooldown); now.Before(next) {
+			return &Error{Reason: ReasonCooldown, Cooldown: cooldown, AvailableAt: next}
+		}
+	}
+	return nil
+}
+
+// Load returns a user's usage of a reward
+func Load(ctx context.Context, q Store, userID, rewardID uuid.UUID, now time.Time) (Usage, error) {
+	counts, err := q.CountUserRewardRedemptions(ctx, db.CountUserRewardRedemptionsParams{
+		MonthStart: MonthStart(now),
+		UserID:     userID,
+		RewardID:   rewardID,
+	})
+	if err != nil {
+		return Usage{}, err
+	}
+	usage := Usage{Total: counts.Total, ThisMonth: counts.ThisMonth}
+	if counts.Total == 0 {
+		return usage, nil
+	}
+
+	last, err := q.GetLastUserRewardRedemption(ctx, db.GetLastUserRewardRedemptionParams{
+		UserID:   userID,
+		RewardID: rewardID,
+	})
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return Usage{}, err
+	}
+	usage.LastRedeemedAt = last
+	return usage, nil
+}
+
+// Enforce checks a reward's per-user limits against the user's usage. Call it
+// in the redemption's transaction after locking the user's redemptions so
+// concurrent redemptions cannot both pass.
+func Enforce(ctx context.Context, q Store, userID uuid.UUID, reward db.RewardsCatalog, now time.Time) error {
+	if !Limited(reward) {
+		return nil
+	}
+	usage, err := Load(ctx, q, userID, reward.ID, now)
+	if err != nil {
+		return err
+	}
+	return Check(reward, usage, now)
+}
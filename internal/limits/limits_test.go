@@ -0,0 +1,154 @@
+package limits
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"encore.app/internal/db"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStore answers the usage queries from a list of redemption times
+type fakeStore struct {
+	redeemedAt []time.Time
+	calls      int
+}
+
+func (f *fakeStore) CountUserRewardRedemptions(ctx context.Context, arg db.CountUserRewardRedemptionsParams) (db.CountUserRewardRedemptionsRow, error) {
+	f.calls++
+	var row db.CountUserRewardRedemptionsRow
+	for _, at := range f.redeemedAt {
+		row.Total++
+		if !at.Before(arg.MonthStart) {
+			row.ThisMonth++
+		}
+	}
+	return row, nil
+}
+
+func (f *fakeStore) GetLastUserRewardRedemption(ctx context.Context, arg db.GetLastUserRewardRedemptionParams) (time.Time, error) {
+	f.calls++
+	var last time.Time
+	for _, at := range f.redeemedAt {
+		if at.After(last) {
+			last = at
+		}
+	}
+	if last.IsZero() {
+		return last, sql.ErrNoRows
+	}
+	return last, nil
+}
+
+func limitedReward(maxTotal, maxMonthly, cooldownSeconds int32) db.RewardsCatalog {
+	r := db.RewardsCatalog{ID: uuid.New(), Name: "Free charge", Cost: 100, Active: true}
+	if maxTotal >= 0 {
+		r.MaxPerUser = sql.NullInt32{Int32: maxTotal, Valid: true}
+	}
+	if maxMonthly >= 0 {
+		r.MaxPerUserMonthly = sql.NullInt32{Int32: maxMonthly, Valid: true}
+	}
+	if cooldownSeconds >= 0 {
+		r.CooldownSeconds = sql.NullInt32{Int32: cooldownSeconds, Valid: true}
+	}
+	return r
+}
+
+func TestCheck(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		reward      db.RewardsCatalog
+		usage       Usage
+		wantErr     error
+		wantAvailAt time.Time
+	}{
+		{
+			name:   "no limits",
+			reward: limitedReward(-1, -1, -1),
+			usage:  Usage{Total: 50, ThisMonth: 10, LastRedeemedAt: now.Add(-time.Second)},
+		},
+		{
+			name:   "under lifetime limit",
+			reward: limitedReward(3, -1, -1),
+			usage:  Usage{Total: 2, ThisMonth: 2},
+		},
+		{
+			name:    "lifetime limit reached",
+			reward:  limitedReward(3, -1, -1),
+			usage:   Usage{Total: 3},
+			wantErr: ErrLifetimeLimit,
+		},
+		{
+			name:        "monthly limit reached",
+			reward:      limitedReward(10, 2, -1),
+			usage:       Usage{Total: 5, ThisMonth: 2},
+			wantErr:     ErrMonthlyLimit,
+			wantAvailAt: time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:        "cooling down",
+			reward:      limitedReward(-1, -1, 3600),
+			usage:       Usage{Total: 1, ThisMonth: 1, LastRedeemedAt: now.Add(-20 * time.Minute)},
+			wantErr:     ErrCooldown,
+			wantAvailAt: now.Add(40 * time.Minute),
+		},
+		{
+			name:   "cooldown elapsed",
+			reward: limitedReward(-1, -1, 3600),
+			usage:  Usage{Total: 1, ThisMonth: 1, LastRedeemedAt: now.Add(-time.Hour)},
+		},
+		{
+			name:   "cooldown without history",
+			reward: limitedReward(-1, -1, 3600),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Check(tt.reward, tt.usage, now)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, tt.wantErr)
+			var e *Error
+			require.ErrorAs(t, err, &e)
+			assert.Equal(t, tt.wantAvailAt, e.AvailableAt)
+		})
+	}
+}
+
+func TestEnforce(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	userID := uuid.New()
+
+	// Unlimited rewards skip the usage queries
+	q := &fakeStore{redeemedAt: []time.Time{now.Add(-time.Minute)}}
+	require.NoError(t, Enforce(context.Background(), q, userID, limitedReward(-1, -1, -1), now))
+	assert.Zero(t, q.calls)
+
+	// Only this month's redemptions count against the monthly limit
+	q = &fakeStore{redeemedAt: []time.Time{
+		time.Date(2024, 5, 30, 9, 0, 0, 0, time.UTC),
+		time.Date(2024, 6, 2, 9, 0, 0, 0, time.UTC),
+	}}
+	reward := limitedReward(-1, 2, 86400)
+	require.NoError(t, Enforce(context.Background(), q, userID, reward, now))
+
+	q.redeemedAt = append(q.redeemedAt, now.Add(-time.Hour))
+	err := Enforce(context.Background(), q, userID, reward, now)
+	assert.ErrorIs(t, err, ErrMonthlyLimit)
+}
+
+func TestMonthStart(t *testing.T) {
+	// Months are counted in UTC
+	ist := time.FixedZone("IST", 5*3600+1800)
+	now := time.Date(2024, 7, 1, 2, 0, 0, 0, ist)
+	assert.Equal(t, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), MonthStart(now))
+}
@@ -0,0 +1,264 @@
+// Package otp issues and verifies one-time login codes sent by SMS.
+//
+// Each request stores a challenge holding a hash of the code. Requests are
+// rate limited per phone number, and a challenge is consumed by the first
+// successful verification or locked after too many attempts. Both limits
+// hold under concurrent requests: requests for a phone are serialized, and
+// each attempt is counted before its code is compared.
+package otp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+
+	"encore.app/internal/db"
+	"github.com/google/uuid"
+)
+
+// Errors returned by Manager
+var (
+	ErrInvalidPhone      = errors.New("phone number must be in E.164 format, e.g. +919876543210")
+	ErrInvalidCode       = errors.New("invalid code")
+	ErrCodeExpired       = errors.New("code expired")
+	ErrTooManyAttempts   = errors.New("too many attempts")
+	ErrRateLimited       = errors.New("too many code requests")
+	ErrSenderUnavailable = errors.New("failed to send code")
+)
+
+// RateLimitError is returned when a phone number has requested too many codes
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%v, retry in %s", ErrRateLimited, e.RetryAfter.Round(time.Second))
+}
+
+// Unwrap lets errors.Is match ErrRateLimited
+func (e *RateLimitError) Unwrap() error { return ErrRateLimited }
+
+// Config controls code lifetime and rate limits
+type Config struct {
+	CodeLength int
+	CodeTTL    time.Duration
+	// MaxAttempts is how many wrong codes lock a challenge
+	MaxAttempts int32
+	// ResendInterval is the minimum time between two requests for a phone
+	ResendInterval time.Duration
+	// MaxRequests requests are allowed per phone within RequestWindow
+	MaxRequests   int64
+	RequestWindow time.Duration
+}
+
+// DefaultConfig returns the production defaults
+func DefaultConfig() Config {
+	return Config{
+		CodeLength:     6,
+		CodeTTL:        5 * time.Minute,
+		MaxAttempts:    5,
+		ResendInterval: 30 * time.Second,
+		MaxRequests:    5,
+		RequestWindow:  time.Hour,
+	}
+}
+
+// Store is the subset of *db.Queries used to persist challenges
+type Store interface {
+	CreateOTPChallenge(ctx context.Context, arg db.CreateOTPChallengeParams) (db.OtpChallenge, error)
+	GetLatestOTPChallenge(ctx context.Context, phone string) (db.OtpChallenge, error)
+	CountOTPChallengesSince(ctx context.Context, arg db.CountOTPChallengesSinceParams) (int64, error)
+	LockOTPPhone(ctx context.Context, phone string) error
+	ClaimOTPAttempt(ctx context.Context, arg db.ClaimOTPAttemptParams) (int64, error)
+	MarkOTPVerified(ctx context.Context, id uuid.UUID) (int64, error)
+}
+
+// TxFunc runs fn inside a database transaction
+type TxFunc func(ctx context.Context, fn func(q Store) error) error
+
+// DBTx returns a TxFunc running transactions on conn
+func DBTx(conn *sql.DB) TxFunc {
+	return func(ctx context.Context, fn func(q Store) error) error {
+		return db.WithTx(ctx, conn, func(q *db.Queries) error {
+			return fn(q)
+		})
+	}
+}
+
+// Challenge describes a code that was sent
+type Challenge struct {
+	Phone       string
+	ExpiresAt   time.Time
+	ResendAfter time.Time
+}
+
+// Manager issues and verifies codes
+type Manager struct {
+	cfg    Config
+	store  Store
+	tx     TxFunc
+	sender Sender
+	now    func() time.Time
+}
+
+// NewManager creates a manager verifying codes with store and issuing them in
+// transactions run by tx
+func NewManager(cfg Config, store Store, tx TxFunc, sender Sender) *Manager {
+	return &Manager{cfg: cfg, store: store, tx: tx, sender: sender, now: time.Now}
+}
+
+var e164 = regexp.MustCompile(`^\+[1-9][0-9]{7,14}$`)
+
+// NormalizePhone strips spaces, dashes and parentheses and checks the result is E.164
+func NormalizePhone(phone string) (string, error) {
+	phone = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '(', ')':
+			return -1
+		}
+		return r
+	}, phone)
+	if !e164.MatchString(phone) {
+		return "", ErrInvalidPhone
+	}
+	return phone, nil
+}
+
+// Request sends a new code to phone, subject to the rate limits
+func (m *Manager) Request(ctx context.Context, phone string) (*Challenge, error) {
+	phone, err := NormalizePhone(phone)
+	if err != nil {
+		return nil, err
+	}
+	now := m.now()
+
+	// Check the rate limits and store the challenge in one transaction,
+	// holding the phone's lock so concurrent requests cannot all pass the
+	// limits; the code is sent after commit
+	var code string
+	var challenge db.OtpChallenge
+	err = m.tx(ctx, func(q Store) error {
+		if err := q.LockOTPPhone(ctx, phone); err != nil {
+			return err
+		}
+
+		latest, err := q.GetLatestOTPChallenge(ctx, phone)
+		switch {
+		case err == nil:
+			if wait := latest.CreatedAt.Add(m.cfg.ResendInterval).Sub(now); wait > 0 {
+				return &RateLimitError{RetryAfter: wait}
+			}
+		case !errors.Is(err, sql.ErrNoRows):
+			return err
+		}
+
+		recent, err := q.CountOTPChallengesSince(ctx, db.CountOTPChallengesSinceParams{
+			Phone: phone,
+			Since: now.Add(-m.cfg.RequestWindow),
+		})
+		if err != nil {
+			return err
+		}
+		if recent >= m.cfg.MaxRequests {
+			return &RateLimitError{RetryAfter: m.cfg.RequestWindow}
+		}
+
+		code, err = m.generateCode()
+		if err != nil {
+			return err
+		}
+		challenge, err = q.CreateOTPChallenge(ctx, db.CreateOTPChallengeParams{
+			Phone:     phone,
+			CodeHash:  hashCode(phone, code),
+			ExpiresAt: now.Add(m.cfg.CodeTTL),
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	message := fmt.Sprintf("%s is your Urja Rewards login code. It expires in %d minutes.", code, int(m.cfg.CodeTTL.Minutes()))
+	if err := m.sender.Send(ctx, phone, message); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSenderUnavailable, err)
+	}
+	return &Challenge{
+		Phone:       phone,
+		ExpiresAt:   challenge.ExpiresAt,
+		ResendAfter: now.Add(m.cfg.ResendInterval),
+	}, nil
+}
+
+// Verify checks code against the latest challenge for phone and consumes it.
+// It returns the normalized phone number.
+func (m *Manager) Verify(ctx context.Context, phone, code string) (string, error) {
+	phone, err := NormalizePhone(phone)
+	if err != nil {
+		return "", err
+	}
+
+	challenge, err := m.store.GetLatestOTPChallenge(ctx, phone)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrInvalidCode
+		}
+		return "", err
+	}
+	if challenge.VerifiedAt.Valid {
+		return "", ErrInvalidCode
+	}
+	if !m.now().Before(challenge.ExpiresAt) {
+		return "", ErrCodeExpired
+	}
+
+	// Count the attempt before comparing the code, so concurrent guesses
+	// cannot exceed MaxAttempts between reading and counting attempts
+	claimed, err := m.store.ClaimOTPAttempt(ctx, db.ClaimOTPAttemptParams{
+		ID:          challenge.ID,
+		MaxAttempts: m.cfg.MaxAttempts,
+	})
+	if err != nil {
+		return "", err
+	}
+	if claimed == 0 {
+		return "", ErrTooManyAttempts
+	}
+	if subtle.ConstantTimeCompare([]byte(hashCode(phone, strings.TrimSpace(code))), []byte(challenge.CodeHash)) != 1 {
+		return "", ErrInvalidCode
+	}
+
+	// Only one concurrent verification can consume the challenge
+	consumed, err := m.store.MarkOTPVerified(ctx, challenge.ID)
+	if err != nil {
+		return "", err
+	}
+	if consumed == 0 {
+		return "", ErrInvalidCode
+	}
+	return phone, nil
+}
+
+// generateCode returns a random numeric code
+func (m *Manager) generateCode() (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(m.cfg.CodeLength)), nil)
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", m.cfg.CodeLength, n), nil
+}
+
+// hashCode hashes a code together with its phone number
+func hashCode(phone, code string) string {
+	sum := sha256.Sum256([]byte(phone + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
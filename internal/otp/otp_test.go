@@ -0,0 +1,343 @@
+package otp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"encore.app/internal/db"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testNow = time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// fakeStore keeps challenges in memory. Each query is atomic, as in the
+// database, and LockOTPPhone holds a lock until the transaction ends.
+type fakeStore struct {
+	mu         sync.Mutex
+	challenges []db.OtpChallenge
+	now        time.Time
+	// phones is held by a transaction that locked a phone
+	phones sync.Mutex
+	// locked is whether the running transaction holds phones
+	locked bool
+	// delay slows down reading the latest challenge, so concurrent calls
+	// interleave between reading and writing
+	delay time.Duration
+}
+
+// tx runs fn as a transaction, releasing the phone lock it took on return
+func (f *fakeStore) tx(ctx context.Context, fn func(q Store) error) error {
+	tx := &fakeTx{fakeStore: f}
+	defer func() {
+		if tx.locked {
+			f.phones.Unlock()
+		}
+	}()
+	return fn(tx)
+}
+
+// fakeTx is a transaction of fakeStore
+type fakeTx struct {
+	*fakeStore
+	locked bool
+}
+
+func (t *fakeTx) LockOTPPhone(ctx context.Context, phone string) error {
+	t.phones.Lock()
+	t.locked = true
+	return nil
+}
+
+func (f *fakeStore) LockOTPPhone(ctx context.Context, phone string) error {
+	panic("LockOTPPhone outside a transaction")
+}
+
+func (f *fakeStore) CreateOTPChallenge(ctx context.Context, arg db.CreateOTPChallengeParams) (db.OtpChallenge, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := db.OtpChallenge{
+		ID:        uuid.New(),
+		Phone:     arg.Phone,
+		CodeHash:  arg.CodeHash,
+		ExpiresAt: arg.ExpiresAt,
+		CreatedAt: f.now,
+	}
+	f.challenges = append(f.challenges, c)
+	return c, nil
+}
+
+func (f *fakeStore) GetLatestOTPChallenge(ctx context.Context, phone string) (db.OtpChallenge, error) {
+	defer time.Sleep(f.delay)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.challenges) - 1; i >= 0; i-- {
+		if f.challenges[i].Phone == phone {
+			return f.challenges[i], nil
+		}
+	}
+	return db.OtpChallenge{}, sql.ErrNoRows
+}
+
+func (f *fakeStore) CountOTPChallengesSince(ctx context.Context, arg db.CountOTPChallengesSinceParams) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var n int64
+	for _, c := range f.challenges {
+		if c.Phone == arg.Phone && !c.CreatedAt.Before(arg.Since) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (f *fakeStore) ClaimOTPAttempt(ctx context.Context, arg db.ClaimOTPAttemptParams) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.challenges {
+		c := &f.challenges[i]
+		if c.ID == arg.ID && !c.VerifiedAt.Valid && c.Attempts < arg.MaxAttempts {
+			c.Attempts++
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (f *fakeStore) MarkOTPVerified(ctx context.Context, id uuid.UUID) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.challenges {
+		if f.challenges[i].ID == id && !f.challenges[i].VerifiedAt.Valid {
+			f.challenges[i].VerifiedAt = sql.NullTime{Time: testNow, Valid: true}
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+type failingSender struct{}
+
+func (failingSender) Send(ctx context.Context, phone, message string) error {
+	return errors.New("provider down")
+}
+
+var codePattern = regexp.MustCompile(`^[0-9]{6}`)
+
+func newTestManager() (*Manager, *fakeStore, *FakeSender) {
+	store := &fakeStore{now: testNow}
+	sender := &FakeSender{}
+	m := NewManager(DefaultConfig(), store, store.tx, sender)
+	m.now = func() time.Time { return store.now }
+	return m, store, sender
+}
+
+// sentCode extracts the code from the last message sent to phone
+func sentCode(t *testing.T, sender *FakeSender, phone string) string {
+	t.Helper()
+	msg, ok := sender.Last(phone)
+	require.True(t, ok, "no message sent to %s", phone)
+	code := codePattern.FindString(msg.Body)
+	require.NotEmpty(t, code)
+	return code
+}
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"+919876543210", "+919876543210", false},
+		{"+91 98765-43210", "+919876543210", false},
+		{"+1 (415) 555-0100", "+14155550100", false},
+		{"9876543210", "", true},
+		{"+0123456789", "", true},
+		{"+91abc", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := NormalizePhone(tt.in)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidPhone)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRequestAndVerify(t *testing.T) {
+	m, store, sender := newTestManager()
+	ctx := context.Background()
+
+	challenge, err := m.Request(ctx, "+91 98765 43210")
+	require.NoError(t, err)
+	assert.Equal(t, "+919876543210", challenge.Phone)
+	assert.Equal(t, testNow.Add(5*time.Minute), challenge.ExpiresAt)
+	assert.Equal(t, testNow.Add(30*time.Second), challenge.ResendAfter)
+
+	code := sentCode(t, sender, "+919876543210")
+	assert.NotContains(t, store.challenges[0].CodeHash, code, "codes are stored hashed")
+
+	phone, err := m.Verify(ctx, "+919876543210", code)
+	require.NoError(t, err)
+	assert.Equal(t, "+919876543210", phone)
+
+	// A code can only be used once
+	_, err = m.Verify(ctx, "+919876543210", code)
+	assert.ErrorIs(t, err, ErrInvalidCode)
+}
+
+func TestVerify_Failures(t *testing.T) {
+	ctx := context.Background()
+	const phone = "+919876543210"
+
+	t.Run("no challenge", func(t *testing.T) {
+		m, _, _ := newTestManager()
+		_, err := m.Verify(ctx, phone, "123456")
+		assert.ErrorIs(t, err, ErrInvalidCode)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		m, store, sender := newTestManager()
+		_, err := m.Request(ctx, phone)
+		require.NoError(t, err)
+		store.now = store.now.Add(5 * time.Minute)
+		_, err = m.Verify(ctx, phone, sentCode(t, sender, phone))
+		assert.ErrorIs(t, err, ErrCodeExpired)
+	})
+
+	t.Run("too many attempts", func(t *testing.T) {
+		m, store, sender := newTestManager()
+		_, err := m.Request(ctx, phone)
+		require.NoError(t, err)
+		for i := 0; i < 5; i++ {
+			_, err = m.Verify(ctx, phone, "wrong")
+			assert.ErrorIs(t, err, ErrInvalidCode)
+		}
+		assert.Equal(t, int32(5), store.challenges[0].Attempts)
+
+		// Even the right code is rejected once the challenge is locked
+		_, err = m.Verify(ctx, phone, sentCode(t, sender, phone))
+		assert.ErrorIs(t, err, ErrTooManyAttempts)
+	})
+}
+
+func TestRequest_RateLimit(t *testing.T) {
+	m, store, _ := newTestManager()
+	ctx := context.Background()
+	const phone = "+919876543210"
+
+	_, err := m.Request(ctx, phone)
+	require.NoError(t, err)
+
+	// Resending too soon
+	_, err = m.Request(ctx, phone)
+	var rl *RateLimitError
+	require.ErrorAs(t, err, &rl)
+	assert.Equal(t, 30*time.Second, rl.RetryAfter)
+	assert.ErrorIs(t, err, ErrRateLimited)
+
+	// Other numbers are unaffected
+	_, err = m.Request(ctx, "+14155550100")
+	require.NoError(t, err)
+
+	// Requests are capped per window even when spaced out
+	for i := 1; i < 5; i++ {
+		store.now = store.now.Add(time.Minute)
+		_, err = m.Request(ctx, phone)
+		require.NoError(t, err)
+	}
+	store.now = store.now.Add(time.Minute)
+	_, err = m.Request(ctx, phone)
+	assert.ErrorIs(t, err, ErrRateLimited)
+}
+
+func TestVerify_ConcurrentGuesses(t *testing.T) {
+	m, store, sender := newTestManager()
+	ctx := context.Background()
+	const phone = "+919876543210"
+	_, err := m.Request(ctx, phone)
+	require.NoError(t, err)
+	store.delay = time.Millisecond
+
+	// Parallel wrong guesses get no more than MaxAttempts tries between them
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := m.Verify(ctx, phone, "wrong")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	counts := map[error]int{}
+	for err := range errs {
+		counts[err]++
+	}
+	assert.Equal(t, map[error]int{ErrInvalidCode: 5, ErrTooManyAttempts: 15}, counts)
+	assert.Equal(t, int32(5), store.challenges[0].Attempts)
+
+	_, err = m.Verify(ctx, phone, sentCode(t, sender, phone))
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+}
+
+func TestRequest_Concurrent(t *testing.T) {
+	m, store, sender := newTestManager()
+	store.delay = time.Millisecond
+	const phone = "+919876543210"
+
+	// Parallel requests for one phone send a single code
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	sent := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := m.Request(context.Background(), phone)
+			if err == nil {
+				mu.Lock()
+				sent++
+				mu.Unlock()
+				return
+			}
+			assert.ErrorIs(t, err, ErrRateLimited)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, sent)
+	assert.Len(t, store.challenges, 1)
+	assert.Len(t, sender.Messages(), 1)
+}
+
+func TestRequest_SenderFailure(t *testing.T) {
+	m, _, _ := newTestManager()
+	m.sender = failingSender{}
+	_, err := m.Request(context.Background(), "+919876543210")
+	assert.ErrorIs(t, err, ErrSenderUnavailable)
+}
+
+func TestNewSender(t *testing.T) {
+	_, err := NewSender("")
+	assert.ErrorIs(t, err, ErrNoSender)
+
+	_, err = NewSender("twilio")
+	assert.Error(t, err)
+
+	sender, err := NewSender(SenderFake)
+	require.NoError(t, err)
+	assert.IsType(t, &FakeSender{}, sender)
+}
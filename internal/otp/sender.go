@@ -0,0 +1,80 @@
+package otp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Sender delivers text messages to phone numbers. Production deployments plug
+// in their SMS provider; FakeSender is used locally and in tests.
+type Sender interface {
+	Send(ctx context.Context, phone, message string) error
+}
+
+// SenderFake names FakeSender in NewSender. It must only be selected for
+// local development: codes never reach the phone and are logged instead.
+const SenderFake = "fake"
+
+// ErrNoSender is returned by NewSender when no SMS sender is configured
+var ErrNoSender = errors.New("no SMS sender configured")
+
+// NewSender returns the sender named by kind, as configured by the deployment.
+// There is no default: an empty kind is ErrNoSender, so a deployment without
+// an SMS provider cannot send codes rather than falling back to the fake.
+func NewSender(kind string) (Sender, error) {
+	switch kind {
+	case "":
+		return nil, ErrNoSender
+	case SenderFake:
+		return &FakeSender{Log: true}, nil
+	}
+	return nil, fmt.Errorf("unknown SMS sender %q", kind)
+}
+
+// Message is a text message recorded by FakeSender
+type Message struct {
+	Phone string
+	Body  string
+}
+
+// FakeSender records messages instead of sending them. With Log set it also
+// logs them, so codes can be read from the console during local development.
+type FakeSender struct {
+	mu       sync.Mutex
+	messages []Message
+	// Log enables logging each message, codes included
+	Log bool
+}
+
+// Send records the message
+func (f *FakeSender) Send(ctx context.Context, phone, message string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages = append(f.messages, Message{Phone: phone, Body: message})
+	if f.Log {
+		log.Printf("otp: fake SMS to %s: %s", phone, message)
+	}
+	return nil
+}
+
+// Messages returns the recorded messages in the order they were sent
+func (f *FakeSender) Messages() []Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Message(nil), f.messages...)
+}
+
+// Last returns the most recent message sent to phone
+func (f *FakeSender) Last(phone string) (Message, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.messages) - 1; i >= 0; i-- {
+		if f.messages[i].Phone == phone {
+			return f.messages[i], true
+		}
+	}
+	return Message{}, false
+}
@@ -0,0 +1,45 @@
+//go:build encore
+// +build encore
+
+package outbox
+
+import (
+	"time"
+
+	"encore.dev/metrics"
+)
+
+// TopicLabels labels outbox metrics by topic
+type TopicLabels struct {
+	Topic string
+}
+
+var (
+	publishedEvents = metrics.NewCounterGroup[TopicLabels, uint64]("outbox_published_events", metrics.CounterConfig{})
+	failedPublishes = metrics.NewCounterGroup[TopicLabels, uint64]("outbox_failed_publishes", metrics.CounterConfig{})
+	deadEvents      = metrics.NewCounterGroup[TopicLabels, uint64]("outbox_dead_events", metrics.CounterConfig{})
+	pendingEvents   = metrics.NewGaugeGroup[TopicLabels, float64]("outbox_pending_events", metrics.GaugeConfig{})
+	lagSeconds      = metrics.NewGaugeGroup[TopicLabels, float64]("outbox_lag_seconds", metrics.GaugeConfig{})
+)
+
+// DefaultMetrics reports relay measurements as Encore metrics
+var DefaultMetrics Metrics = encoreMetrics{}
+
+type encoreMetrics struct{}
+
+func (encoreMetrics) Published(topic string) {
+	publishedEvents.With(TopicLabels{Topic: topic}).Increment()
+}
+
+func (encoreMetrics) Failed(topic string) {
+	failedPublishes.With(TopicLabels{Topic: topic}).Increment()
+}
+
+func (encoreMetrics) Dead(topic string) {
+	deadEvents.With(TopicLabels{Topic: topic}).Increment()
+}
+
+func (encoreMetrics) Lag(topic string, pending int64, oldest time.Duration) {
+	pendingEvents.With(TopicLabels{Topic: topic}).Set(float64(pending))
+	lagSeconds.With(TopicLabels{Topic: topic}).Set(oldest.Seconds())
+}
@@ -0,0 +1,16 @@
+//go:build !encore
+// +build !encore
+
+package outbox
+
+import "time"
+
+// DefaultMetrics discards relay measurements in non-Encore builds
+var DefaultMetrics Metrics = nopMetrics{}
+
+type nopMetrics struct{}
+
+func (nopMetrics) Published(topic string)                                {}
+func (nopMetrics) Failed(topic string)                                   {}
+func (nopMetrics) Dead(topic string)                                     {}
+func (nopMetrics) Lag(topic string, pending int64, oldest time.Duration) {}
@@ -0,0 +1,69 @@
+// Package outbox implements the transactional outbox: pub/sub events are
+// written to the event_outbox table in the same transaction as the change that
+// produced them, and a Relay publishes them afterwards with retries. A crash or
+// a failed publish therefore delays an event but never loses it.
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"encore.app/internal/db"
+)
+
+// Publisher publishes one outbox payload to its topic
+type Publisher func(ctx context.Context, payload json.RawMessage) error
+
+// Topic is the publishing side of a pub/sub topic carrying *T messages.
+// Encore topics and the mock topics of non-Encore builds both satisfy it.
+type Topic[T any] interface {
+	Publish(ctx context.Context, msg *T) (string, error)
+}
+
+// JSONPublisher returns a Publisher that decodes payloads as T and publishes
+// them to topic
+func JSONPublisher[T any](topic Topic[T]) Publisher {
+	return func(ctx context.Context, payload json.RawMessage) error {
+		var msg T
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			return fmt.Errorf("decode outbox payload: %w", err)
+		}
+		_, err := topic.Publish(ctx, &msg)
+		return err
+	}
+}
+
+// Enqueuer writes events to the outbox; *db.Queries implements it
+type Enqueuer interface {
+	EnqueueOutboxEvent(ctx context.Context, arg db.EnqueueOutboxEventParams) (db.EventOutbox, error)
+}
+
+// Enqueue adds msg to the outbox for topic. Events with the same ordering key
+// (typically the user ID) are published in the order they were enqueued. Call
+// it with transaction-bound queries so the event commits with the change.
+func Enqueue(ctx context.Context, q Enqueuer, topic, orderingKey string, msg interface{}) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("encode outbox payload: %w", err)
+	}
+	_, err = q.EnqueueOutboxEvent(ctx, db.EnqueueOutboxEventParams{
+		Topic:       topic,
+		OrderingKey: orderingKey,
+		Payload:     payload,
+	})
+	return err
+}
+
+// TxFunc runs fn inside a database transaction
+type TxFunc func(ctx context.Context, fn func(q Queries) error) error
+
+// DBTx returns a TxFunc running transactions on conn
+func DBTx(conn *sql.DB) TxFunc {
+	return func(ctx context.Context, fn func(q Queries) error) error {
+		return db.WithTx(ctx, conn, func(q *db.Queries) error {
+			return fn(q)
+		})
+	}
+}
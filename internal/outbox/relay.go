@@ -0,0 +1,216 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"encore.app/internal/db"
+)
+
+// Relay defaults
+const (
+	DefaultBatchSize    = 100
+	DefaultPollInterval = time.Second
+	DefaultMinBackoff   = time.Second
+	DefaultMaxBackoff   = 5 * time.Minute
+	DefaultMaxAttempts  = 20
+	DefaultRetention    = 7 * 24 * time.Hour
+	cleanupInterval     = time.Hour
+)
+
+// Queries is the subset of *db.Queries used by the relay
+type Queries interface {
+	ClaimOutboxBatch(ctx context.Context, arg db.ClaimOutboxBatchParams) ([]db.EventOutbox, error)
+	MarkOutboxEventPublished(ctx context.Context, id int64) error
+	MarkOutboxEventFailed(ctx context.Context, arg db.MarkOutboxEventFailedParams) error
+	MarkOutboxEventDead(ctx context.Context, arg db.MarkOutboxEventDeadParams) error
+	GetOutboxLag(ctx context.Context, topic string) (db.GetOutboxLagRow, error)
+	DeletePublishedOutboxEvents(ctx context.Context, publishedBefore time.Time) (int64, error)
+}
+
+// Metrics receives relay measurements
+type Metrics interface {
+	// Published counts an event delivered to its topic
+	Published(topic string)
+	// Failed counts a failed publish attempt
+	Failed(topic string)
+	// Dead counts an event dead-lettered after its last attempt
+	Dead(topic string)
+	// Lag reports the unpublished backlog and the age of its oldest event
+	Lag(topic string, pending int64, oldest time.Duration)
+}
+
+// Config configures a relay for one topic
+type Config struct {
+	Topic   string
+	Publish Publisher
+
+	BatchSize    int
+	PollInterval time.Duration
+	// Failed events are retried after MinBackoff, doubling per attempt up to MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// An event failing MaxAttempts times is dead-lettered: it is no longer
+	// retried and stops holding back its key
+	MaxAttempts int32
+	// Published events are deleted once older than Retention
+	Retention time.Duration
+}
+
+// Relay publishes a topic's outbox events. Each batch is claimed with
+// FOR UPDATE SKIP LOCKED, so several relays can run side by side, and only
+// the oldest pending event per ordering key is claimed, so a key's events are
+// published strictly in order: a failing event holds back the later events of
+// its key (and only its key) until it succeeds or is dead-lettered.
+type Relay struct {
+	cfg     Config
+	tx      TxFunc
+	metrics Metrics
+	now     func() time.Time
+}
+
+// NewRelay creates a relay, filling in defaults for unset config values
+func NewRelay(cfg Config, tx TxFunc, metrics Metrics) *Relay {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = DefaultPollInterval
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = DefaultMinBackoff
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = DefaultMaxBackoff
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultMaxAttempts
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = DefaultRetention
+	}
+	return &Relay{cfg: cfg, tx: tx, metrics: metrics, now: time.Now}
+}
+
+// RelayOnce claims and publishes one batch, returning how many events were published
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	published := 0
+	err := r.tx(ctx, func(q Queries) error {
+		events, err := q.ClaimOutboxBatch(ctx, db.ClaimOutboxBatchParams{
+			Topic:     r.cfg.Topic,
+			BatchSize: int32(r.cfg.BatchSize),
+		})
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := r.cfg.Publish(ctx, event.Payload); err != nil {
+				r.metrics.Failed(r.cfg.Topic)
+				if err := r.fail(ctx, q, event, err); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := q.MarkOutboxEventPublished(ctx, event.ID); err != nil {
+				return err
+			}
+			r.metrics.Published(r.cfg.Topic)
+			published++
+		}
+		return nil
+	})
+	return published, err
+}
+
+// fail records a failed publish, scheduling a retry or, on the event's last
+// attempt, dead-lettering it
+func (r *Relay) fail(ctx context.Context, q Queries, event db.EventOutbox, publishErr error) error {
+	lastError := sql.NullString{String: publishErr.Error(), Valid: true}
+	if event.Attempts+1 >= r.cfg.MaxAttempts {
+		log.Printf("outbox: dead-lettering %s event %d after %d attempts: %v", r.cfg.Topic, event.ID, event.Attempts+1, publishErr)
+		r.metrics.Dead(r.cfg.Topic)
+		return q.MarkOutboxEventDead(ctx, db.MarkOutboxEventDeadParams{ID: event.ID, LastError: lastError})
+	}
+	return q.MarkOutboxEventFailed(ctx, db.MarkOutboxEventFailedParams{
+		ID:            event.ID,
+		LastError:     lastError,
+		NextAttemptAt: r.now().Add(r.Backoff(event.Attempts + 1)),
+	})
+}
+
+// Backoff returns the delay before the given retry attempt
+func (r *Relay) Backoff(attempt int32) time.Duration {
+	d := r.cfg.MinBackoff
+	for i := int32(1); i < attempt; i++ {
+		d *= 2
+		if d >= r.cfg.MaxBackoff {
+			return r.cfg.MaxBackoff
+		}
+	}
+	return d
+}
+
+// ReportLag reports the topic's unpublished backlog to the metrics
+func (r *Relay) ReportLag(ctx context.Context) error {
+	return r.tx(ctx, func(q Queries) error {
+		lag, err := q.GetOutboxLag(ctx, r.cfg.Topic)
+		if err != nil {
+			return err
+		}
+		oldest := time.Duration(0)
+		if lag.Pending > 0 {
+			oldest = r.now().Sub(lag.OldestCreatedAt)
+		}
+		r.metrics.Lag(r.cfg.Topic, lag.Pending, oldest)
+		return nil
+	})
+}
+
+// Cleanup deletes events published more than retention ago
+func (r *Relay) Cleanup(ctx context.Context, retention time.Duration) (int64, error) {
+	var deleted int64
+	err := r.tx(ctx, func(q Queries) error {
+		var err error
+		deleted, err = q.DeletePublishedOutboxEvents(ctx, r.now().Add(-retention))
+		return err
+	})
+	return deleted, err
+}
+
+// Run relays events until ctx is cancelled, draining full batches back to back
+// and polling every PollInterval once caught up. Published events past their
+// retention are cleaned up hourly.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+	var lastCleanup time.Time
+	for {
+		for {
+			n, err := r.RelayOnce(ctx)
+			if err != nil {
+				log.Printf("outbox: relay %s failed: %v", r.cfg.Topic, err)
+				break
+			}
+			if n < r.cfg.BatchSize {
+				break
+			}
+		}
+		if err := r.ReportLag(ctx); err != nil {
+			log.Printf("outbox: lag report for %s failed: %v", r.cfg.Topic, err)
+		}
+		if r.now().Sub(lastCleanup) >= cleanupInterval {
+			if _, err := r.Cleanup(ctx, r.cfg.Retention); err != nil {
+				log.Printf("outbox: cleanup for %s failed: %v", r.cfg.Topic, err)
+			}
+			lastCleanup = r.now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
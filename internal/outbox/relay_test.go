@@ -0,0 +1,305 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"encore.app/internal/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeQueries is an in-memory event_outbox table
+type fakeQueries struct {
+	events []*db.EventOutbox
+	now    time.Time
+}
+
+func (f *fakeQueries) EnqueueOutboxEvent(ctx context.Context, arg db.EnqueueOutboxEventParams) (db.EventOutbox, error) {
+	e := &db.EventOutbox{
+		ID:            int64(len(f.events) + 1),
+		Topic:         arg.Topic,
+		OrderingKey:   arg.OrderingKey,
+		Payload:       arg.Payload,
+		NextAttemptAt: f.now,
+		CreatedAt:     f.now,
+	}
+	f.events = append(f.events, e)
+	return *e, nil
+}
+
+func (f *fakeQueries) ClaimOutboxBatch(ctx context.Context, arg db.ClaimOutboxBatchParams) ([]db.EventOutbox, error) {
+	seen := map[string]bool{}
+	var batch []db.EventOutbox
+	for _, e := range f.events {
+		if e.Topic != arg.Topic || e.PublishedAt.Valid || e.DeadAt.Valid {
+			continue
+		}
+		head := !seen[e.OrderingKey]
+		seen[e.OrderingKey] = true
+		if head && !e.NextAttemptAt.After(f.now) && len(batch) < int(arg.BatchSize) {
+			batch = append(batch, *e)
+		}
+	}
+	return batch, nil
+}
+
+func (f *fakeQueries) MarkOutboxEventPublished(ctx context.Context, id int64) error {
+	f.events[id-1].PublishedAt.Valid = true
+	f.events[id-1].PublishedAt.Time = f.now
+	return nil
+}
+
+func (f *fakeQueries) MarkOutboxEventFailed(ctx context.Context, arg db.MarkOutboxEventFailedParams) error {
+	e := f.events[arg.ID-1]
+	e.Attempts++
+	e.LastError = arg.LastError
+	e.NextAttemptAt = arg.NextAttemptAt
+	return nil
+}
+
+func (f *fakeQueries) MarkOutboxEventDead(ctx context.Context, arg db.MarkOutboxEventDeadParams) error {
+	e := f.events[arg.ID-1]
+	e.Attempts++
+	e.LastError = arg.LastError
+	e.DeadAt.Valid = true
+	e.DeadAt.Time = f.now
+	return nil
+}
+
+func (f *fakeQueries) GetOutboxLag(ctx context.Context, topic string) (db.GetOutboxLagRow, error) {
+	row := db.GetOutboxLagRow{OldestCreatedAt: f.now}
+	for _, e := range f.events {
+		if e.Topic == topic && !e.PublishedAt.Valid && !e.DeadAt.Valid {
+			row.Pending++
+			if e.CreatedAt.Before(row.OldestCreatedAt) {
+				row.OldestCreatedAt = e.CreatedAt
+			}
+		}
+	}
+	return row, nil
+}
+
+func (f *fakeQueries) DeletePublishedOutboxEvents(ctx context.Context, publishedBefore time.Time) (int64, error) {
+	var n int64
+	for _, e := range f.events {
+		if e.PublishedAt.Valid && e.PublishedAt.Time.Before(publishedBefore) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (f *fakeQueries) tx(ctx context.Context, fn func(q Queries) error) error {
+	return fn(f)
+}
+
+type fakeMetrics struct {
+	published, failed, dead int
+	pending                 int64
+	oldest                  time.Duration
+}
+
+func (m *fakeMetrics) Published(topic string) { m.published++ }
+func (m *fakeMetrics) Failed(topic string)    { m.failed++ }
+func (m *fakeMetrics) Dead(topic string)      { m.dead++ }
+func (m *fakeMetrics) Lag(topic string, pending int64, oldest time.Duration) {
+	m.pending, m.oldest = pending, oldest
+}
+
+type message struct {
+	UserID string `json:"user_id"`
+	Seq    int    `json:"seq"`
+}
+
+// recordingTopic records published messages and fails for selected sequence numbers
+type recordingTopic struct {
+	published []message
+	failSeq   map[int]bool
+}
+
+func (t *recordingTopic) Publish(ctx context.Context, msg *message) (string, error) {
+	if t.failSeq[msg.Seq] {
+		return "", errors.New("broker unavailable")
+	}
+	t.published = append(t.published, *msg)
+	return "id", nil
+}
+
+func newTestRelay(q *fakeQueries, topic *recordingTopic, m *fakeMetrics) *Relay {
+	r := NewRelay(Config{
+		Topic:      "points",
+		Publish:    JSONPublisher[message](topic),
+		MinBackoff: time.Second,
+		MaxBackoff: 10 * time.Second,
+	}, q.tx, m)
+	r.now = func() time.Time { return q.now }
+	return r
+}
+
+func TestEnqueue(t *testing.T) {
+	q := &fakeQueries{now: time.Now()}
+	require.NoError(t, Enqueue(context.Background(), q, "points", "u1", &message{UserID: "u1", Seq: 1}))
+
+	require.Len(t, q.events, 1)
+	assert.Equal(t, "points", q.events[0].Topic)
+	assert.Equal(t, "u1", q.events[0].OrderingKey)
+	var got message
+	require.NoError(t, json.Unmarshal(q.events[0].Payload, &got))
+	assert.Equal(t, message{UserID: "u1", Seq: 1}, got)
+}
+
+func TestRelay_PublishesInOrderPerKey(t *testing.T) {
+	ctx := context.Background()
+	q := &fakeQueries{now: time.Now()}
+	for i, user := range []string{"u1", "u2", "u1", "u1", "u2"} {
+		require.NoError(t, Enqueue(ctx, q, "points", user, &message{UserID: user, Seq: i + 1}))
+	}
+	topic := &recordingTopic{}
+	m := &fakeMetrics{}
+	r := newTestRelay(q, topic, m)
+
+	total := 0
+	for {
+		n, err := r.RelayOnce(ctx)
+		require.NoError(t, err)
+		if n == 0 {
+			break
+		}
+		total += n
+	}
+
+	assert.Equal(t, 5, total)
+	assert.Equal(t, 5, m.published)
+	seqByUser := map[string][]int{}
+	for _, msg := range topic.published {
+		seqByUser[msg.UserID] = append(seqByUser[msg.UserID], msg.Seq)
+	}
+	assert.Equal(t, []int{1, 3, 4}, seqByUser["u1"])
+	assert.Equal(t, []int{2, 5}, seqByUser["u2"])
+}
+
+func TestRelay_FailureHoldsBackOnlyItsKey(t *testing.T) {
+	ctx := context.Background()
+	q := &fakeQueries{now: time.Now()}
+	require.NoError(t, Enqueue(ctx, q, "points", "u1", &message{UserID: "u1", Seq: 1}))
+	require.NoError(t, Enqueue(ctx, q, "points", "u1", &message{UserID: "u1", Seq: 2}))
+	require.NoError(t, Enqueue(ctx, q, "points", "u2", &message{UserID: "u2", Seq: 3}))
+	topic := &recordingTopic{failSeq: map[int]bool{1: true}}
+	m := &fakeMetrics{}
+	r := newTestRelay(q, topic, m)
+
+	n, err := r.RelayOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []message{{UserID: "u2", Seq: 3}}, topic.published)
+	assert.Equal(t, 1, m.failed)
+	assert.Equal(t, int32(1), q.events[0].Attempts)
+	assert.Equal(t, "broker unavailable", q.events[0].LastError.String)
+	assert.Equal(t, q.now.Add(time.Second), q.events[0].NextAttemptAt)
+
+	// Not due yet: nothing is published, including u1's second event
+	n, err = r.RelayOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// Once due and the broker recovers, u1's events go out in order
+	q.now = q.now.Add(time.Second)
+	topic.failSeq = nil
+	for i := 0; i < 2; i++ {
+		_, err = r.RelayOnce(ctx)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []message{{UserID: "u2", Seq: 3}, {UserID: "u1", Seq: 1}, {UserID: "u1", Seq: 2}}, topic.published)
+}
+
+func TestRelay_DeadLettersAfterMaxAttempts(t *testing.T) {
+	ctx := context.Background()
+	q := &fakeQueries{now: time.Now()}
+	require.NoError(t, Enqueue(ctx, q, "points", "u1", &message{UserID: "u1", Seq: 1}))
+	require.NoError(t, Enqueue(ctx, q, "points", "u1", &message{UserID: "u1", Seq: 2}))
+	topic := &recordingTopic{failSeq: map[int]bool{1: true}}
+	m := &fakeMetrics{}
+	r := newTestRelay(q, topic, m)
+	r.cfg.MaxAttempts = 3
+
+	// The first event fails every attempt, holding back the second
+	for attempt := 1; attempt < 3; attempt++ {
+		n, err := r.RelayOnce(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 0, n)
+		assert.False(t, q.events[0].DeadAt.Valid)
+		q.now = q.now.Add(time.Minute)
+	}
+
+	// Its last attempt dead-letters it and the second event goes out
+	n, err := r.RelayOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.True(t, q.events[0].DeadAt.Valid)
+	assert.Equal(t, int32(3), q.events[0].Attempts)
+	assert.Equal(t, "broker unavailable", q.events[0].LastError.String)
+	assert.Equal(t, 3, m.failed)
+	assert.Equal(t, 1, m.dead)
+
+	n, err = r.RelayOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []message{{UserID: "u1", Seq: 2}}, topic.published)
+
+	// Dead events are no longer retried or counted as lag
+	n, err = r.RelayOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	require.NoError(t, r.ReportLag(ctx))
+	assert.Equal(t, int64(0), m.pending)
+}
+
+func TestRelay_IgnoresOtherTopics(t *testing.T) {
+	ctx := context.Background()
+	q := &fakeQueries{now: time.Now()}
+	require.NoError(t, Enqueue(ctx, q, "redemptions", "u1", &message{UserID: "u1", Seq: 1}))
+	topic := &recordingTopic{}
+	r := newTestRelay(q, topic, &fakeMetrics{})
+
+	n, err := r.RelayOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.Empty(t, topic.published)
+}
+
+func TestRelay_Backoff(t *testing.T) {
+	r := newTestRelay(&fakeQueries{}, &recordingTopic{}, &fakeMetrics{})
+
+	var got []time.Duration
+	for attempt := int32(1); attempt <= 6; attempt++ {
+		got = append(got, r.Backoff(attempt))
+	}
+	assert.Equal(t, []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second,
+	}, got)
+}
+
+func TestRelay_ReportLag(t *testing.T) {
+	ctx := context.Background()
+	start := time.Now()
+	q := &fakeQueries{now: start}
+	require.NoError(t, Enqueue(ctx, q, "points", "u1", &message{UserID: "u1", Seq: 1}))
+	q.now = start.Add(30 * time.Second)
+	require.NoError(t, Enqueue(ctx, q, "points", "u2", &message{UserID: "u2", Seq: 2}))
+	m := &fakeMetrics{}
+	r := newTestRelay(q, &recordingTopic{}, m)
+
+	require.NoError(t, r.ReportLag(ctx))
+	assert.Equal(t, int64(2), m.pending)
+	assert.Equal(t, 30*time.Second, m.oldest)
+
+	_, err := r.RelayOnce(ctx)
+	require.NoError(t, err)
+	require.NoError(t, r.ReportLag(ctx))
+	assert.Equal(t, int64(0), m.pending)
+	assert.Equal(t, time.Duration(0), m.oldest)
+}
@@ -0,0 +1,121 @@
+package payments
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+)
+
+// Outcomes the fake gives new intents
+const (
+	// OutcomeSucceed pays intents as soon as they are created
+	OutcomeSucceed = "succeed"
+	// OutcomeFail declines intents as soon as they are created
+	OutcomeFail = "fail"
+	// OutcomeManual leaves intents unpaid until Succeed or Fail is called
+	OutcomeManual = "manual"
+)
+
+// Fake is an in-memory Provider. It keeps intents for the life of the
+// process, so it is only suitable for local development and tests.
+type Fake struct {
+	outcome string
+
+	mu      sync.Mutex
+	intents map[string]*Intent
+	keys    map[string]string
+}
+
+var _ Provider = (*Fake)(nil)
+
+// NewFake creates a fake provider giving new intents outcome; an empty or
+// unknown outcome is OutcomeSucceed
+func NewFake(outcome string) *Fake {
+	if outcome != OutcomeFail && outcome != OutcomeManual {
+		outcome = OutcomeSucceed
+	}
+	return &Fake{outcome: outcome, intents: map[string]*Intent{}, keys: map[string]string{}}
+}
+
+// Name implements Provider
+func (f *Fake) Name() string {
+	return "fake"
+}
+
+// CreateIntent implements Provider
+func (f *Fake) CreateIntent(ctx context.Context, req IntentRequest) (Intent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if id, ok := f.keys[req.IdempotencyKey]; ok && req.IdempotencyKey != "" {
+		return *f.intents[id], nil
+	}
+	intent := &Intent{
+		ID:           "pi_fake_" + randomHex(8),
+		ClientSecret: "secret_fake_" + randomHex(16),
+		Amount:       req.Amount,
+		Currency:     req.Currency,
+		Status:       StatusRequiresPayment,
+	}
+	switch f.outcome {
+	case OutcomeSucceed:
+		intent.Status = StatusSucceeded
+	case OutcomeFail:
+		intent.Status = StatusFailed
+		intent.FailureReason = "card declined"
+	}
+	f.intents[intent.ID] = intent
+	if req.IdempotencyKey != "" {
+		f.keys[req.IdempotencyKey] = intent.ID
+	}
+	return *intent, nil
+}
+
+// GetIntent implements Provider
+func (f *Fake) GetIntent(ctx context.Context, id string) (Intent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	intent, ok := f.intents[id]
+	if !ok {
+		return Intent{}, ErrIntentNotFound
+	}
+	return *intent, nil
+}
+
+// CancelIntent implements Provider
+func (f *Fake) CancelIntent(ctx context.Context, id string) (Intent, error) {
+	return f.settle(id, StatusCancelled, "")
+}
+
+// Succeed marks an unpaid intent as paid
+func (f *Fake) Succeed(id string) (Intent, error) {
+	return f.settle(id, StatusSucceeded, "")
+}
+
+// Fail marks an unpaid intent as declined for reason
+func (f *Fake) Fail(id, reason string) (Intent, error) {
+	return f.settle(id, StatusFailed, reason)
+}
+
+// settle gives an unpaid intent its final status; settled intents are
+// returned unchanged
+func (f *Fake) settle(id, status, reason string) (Intent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	intent, ok := f.intents[id]
+	if !ok {
+		return Intent{}, ErrIntentNotFound
+	}
+	if !intent.Settled() {
+		intent.Status = status
+		intent.FailureReason = reason
+	}
+	return *intent, nil
+}
+
+// randomHex returns n random bytes, hex encoded
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
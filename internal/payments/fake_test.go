@@ -0,0 +1,78 @@
+package payments
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeOutcomes(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		outcome string
+		want    string
+	}{
+		{"", StatusSucceeded},
+		{OutcomeSucceed, StatusSucceeded},
+		{OutcomeFail, StatusFailed},
+		{OutcomeManual, StatusRequiresPayment},
+	}
+	for _, tt := range tests {
+		t.Run(tt.outcome, func(t *testing.T) {
+			f := NewFake(tt.outcome)
+			intent, err := f.CreateIntent(ctx, IntentRequest{Amount: 4900, Currency: Currency})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, intent.Status)
+			assert.Equal(t, int64(4900), intent.Amount)
+			assert.NotEmpty(t, intent.ClientSecret)
+
+			got, err := f.GetIntent(ctx, intent.ID)
+			require.NoError(t, err)
+			assert.Equal(t, intent, got)
+		})
+	}
+}
+
+func TestFakeIdempotency(t *testing.T) {
+	ctx := context.Background()
+	f := NewFake(OutcomeManual)
+	first, err := f.CreateIntent(ctx, IntentRequest{Amount: 100, IdempotencyKey: "redemption-1"})
+	require.NoError(t, err)
+	again, err := f.CreateIntent(ctx, IntentRequest{Amount: 100, IdempotencyKey: "redemption-1"})
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, again.ID)
+
+	other, err := f.CreateIntent(ctx, IntentRequest{Amount: 100, IdempotencyKey: "redemption-2"})
+	require.NoError(t, err)
+	assert.NotEqual(t, first.ID, other.ID)
+}
+
+func TestFakeSettle(t *testing.T) {
+	ctx := context.Background()
+	f := NewFake(OutcomeManual)
+	paid, _ := f.CreateIntent(ctx, IntentRequest{Amount: 100})
+	_, err := f.Succeed(paid.ID)
+	require.NoError(t, err)
+
+	// A paid intent cannot be cancelled
+	got, err := f.CancelIntent(ctx, paid.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusSucceeded, got.Status)
+
+	declined, _ := f.CreateIntent(ctx, IntentRequest{Amount: 100})
+	got, err = f.Fail(declined.ID, "insufficient funds")
+	require.NoError(t, err)
+	assert.Equal(t, StatusFailed, got.Status)
+	assert.Equal(t, "insufficient funds", got.FailureReason)
+
+	abandoned, _ := f.CreateIntent(ctx, IntentRequest{Amount: 100})
+	got, err = f.CancelIntent(ctx, abandoned.ID)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCancelled, got.Status)
+	assert.True(t, got.Settled())
+
+	_, err = f.GetIntent(ctx, "pi_unknown")
+	assert.ErrorIs(t, err, ErrIntentNotFound)
+}
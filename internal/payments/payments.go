@@ -0,0 +1,87 @@
+// Package payments takes the cash part of points + cash redemptions.
+//
+// A Provider creates a payment intent for the top-up, which the app completes
+// with the provider's SDK using the intent's client secret. The provider is
+// then asked for the intent's outcome; the client's word is never taken for
+// it. Fake is an in-memory provider for local development and tests.
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Intent statuses, also used for the status of redemption payments
+const (
+	StatusRequiresPayment = "REQUIRES_PAYMENT"
+	StatusSucceeded       = "SUCCEEDED"
+	StatusFailed          = "FAILED"
+	StatusCancelled       = "CANCELLED"
+)
+
+// Currency is the currency of cash top-ups
+const Currency = "INR"
+
+// ErrIntentNotFound is returned for intents the provider does not know
+var ErrIntentNotFound = errors.New("payment intent not found")
+
+// ProviderFake names the Fake in NewProvider. It must only be selected for
+// local development and tests: no money is taken.
+const ProviderFake = "fake"
+
+// ErrNoProvider is returned by NewProvider when no provider is configured
+var ErrNoProvider = errors.New("no payment provider configured")
+
+// NewProvider returns the provider named by kind, as configured by the
+// deployment; fakeOutcome sets the outcome of the Fake's intents. There is
+// no default: an empty kind is ErrNoProvider, so a deployment without a
+// payment provider cannot take cash rather than falling back to the fake.
+func NewProvider(kind, fakeOutcome string) (Provider, error) {
+	switch kind {
+	case "":
+		return nil, ErrNoProvider
+	case ProviderFake:
+		return NewFake(fakeOutcome), nil
+	}
+	return nil, fmt.Errorf("unknown payment provider %q", kind)
+}
+
+// IntentRequest asks a provider for a payment intent
+type IntentRequest struct {
+	// Amount is in paise
+	Amount   int64
+	Currency string
+	// IdempotencyKey makes retried requests return the first intent
+	IdempotencyKey string
+	Description    string
+}
+
+// Intent is a provider's payment intent
+type Intent struct {
+	ID string
+	// ClientSecret lets the app complete the payment with the provider
+	ClientSecret string
+	Amount       int64
+	Currency     string
+	// Status is one of the Status constants
+	Status string
+	// FailureReason explains a failed intent
+	FailureReason string
+}
+
+// Settled reports whether the intent has a final outcome
+func (i Intent) Settled() bool {
+	return i.Status != StatusRequiresPayment
+}
+
+// Provider is a payment provider
+type Provider interface {
+	// Name identifies the provider in stored payments
+	Name() string
+	CreateIntent(ctx context.Context, req IntentRequest) (Intent, error)
+	GetIntent(ctx context.Context, id string) (Intent, error)
+	// CancelIntent cancels an unpaid intent and returns its final state; an
+	// intent paid in the meantime stays succeeded
+	CancelIntent(ctx context.Context, id string) (Intent, error)
+}
@@ -0,0 +1,21 @@
+package payments
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewProvider(t *testing.T) {
+	_, err := NewProvider("", OutcomeSucceed)
+	assert.ErrorIs(t, err, ErrNoProvider)
+
+	provider, err := NewProvider(ProviderFake, OutcomeManual)
+	require.NoError(t, err)
+	require.IsType(t, &Fake{}, provider)
+	assert.Equal(t, OutcomeManual, provider.(*Fake).outcome)
+
+	_, err = NewProvider("stripe", "")
+	assert.Error(t, err)
+}
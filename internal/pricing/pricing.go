@@ -0,0 +1,172 @@
+// Package pricing evaluates a reward's price rules.
+//
+// A reward's cost is its base price. Its price_rules can lower it for some
+// users: tier_discount for a loyalty tier and above, segment_price for a
+// segment audience, and sale for everyone. Any rule can be limited to a window
+// with starts_at and ends_at; a sale must end. A user pays the lowest price of
+// the rules that apply to them, never the sum of several discounts.
+package pricing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"encore.app/internal/db"
+	"encore.app/internal/segments"
+	"encore.app/internal/tiers"
+)
+
+// Rule types
+const (
+	TypeTierDiscount = "tier_discount"
+	TypeSegmentPrice = "segment_price"
+	TypeSale         = "sale"
+)
+
+// Rule is one of a reward's price rules. It sets either a fixed Cost or a
+// PercentOff the base cost.
+type Rule struct {
+	// Type is one of the Type constants
+	Type string `json:"type"`
+	// Tier is the lowest tier a tier_discount applies to
+	Tier string `json:"tier,omitempty"`
+	// Segment is the audience of a segment_price, in the format of
+	// rewards_catalog.segment
+	Segment json.RawMessage `json:"segment,omitempty"`
+	// Cost is the price the rule sets
+	Cost *int32 `json:"cost,omitempty"`
+	// PercentOff is the discount the rule gives, 1 to 100
+	PercentOff int32 `json:"percent_off,omitempty"`
+	// StartsAt and EndsAt limit the rule to a window; EndsAt is exclusive
+	StartsAt *time.Time `json:"starts_at,omitempty"`
+	EndsAt   *time.Time `json:"ends_at,omitempty"`
+}
+
+// Price is a reward's price for one user
+type Price struct {
+	// Cost is the points the user pays
+	Cost int32 `json:"cost"`
+	// BaseCost is the reward's cost before price rules
+	BaseCost int32 `json:"base_cost"`
+	// Rule is the type of the rule that set Cost, "" at the base cost
+	Rule string `json:"rule,omitempty"`
+	// EndsAt is when the rule that set Cost stops applying, if it ends
+	EndsAt *time.Time `json:"ends_at,omitempty"`
+}
+
+// Discounted reports whether a rule lowered the price
+func (p Price) Discounted() bool {
+	return p.Rule != ""
+}
+
+// Parse decodes and validates a reward's price_rules column; a null column
+// has no rules
+func Parse(raw []byte) ([]Rule, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var rules []Rule
+	if err := json.Unmarshal(raw, &rules); err != nil {
+		return nil, err
+	}
+	for i, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+	}
+	return rules, nil
+}
+
+// validate checks a rule has the fields its type needs
+func (r Rule) validate() error {
+	switch r.Type {
+	case TypeTierDiscount:
+		if !tiers.Valid(r.Tier) {
+			return fmt.Errorf("unknown tier %q", r.Tier)
+		}
+	case TypeSegmentPrice:
+		if len(r.Segment) == 0 {
+			return errors.New("segment is required")
+		}
+		if _, err := segments.ParseTarget(r.Segment); err != nil {
+			return fmt.Errorf("invalid segment: %w", err)
+		}
+	case TypeSale:
+		if r.EndsAt == nil {
+			return errors.New("ends_at is required")
+		}
+	default:
+		return fmt.Errorf("unknown type %q", r.Type)
+	}
+	if (r.Cost == nil) == (r.PercentOff == 0) {
+		return errors.New("set one of cost or percent_off")
+	}
+	if r.Cost != nil && *r.Cost < 0 {
+		return errors.New("cost must not be negative")
+	}
+	if r.PercentOff < 0 || r.PercentOff > 100 {
+		return errors.New("percent_off must be between 1 and 100")
+	}
+	if r.StartsAt != nil && r.EndsAt != nil && !r.EndsAt.After(*r.StartsAt) {
+		return errors.New("ends_at must be after starts_at")
+	}
+	return nil
+}
+
+// active reports whether now falls inside the rule's window
+func (r Rule) active(now time.Time) bool {
+	if r.StartsAt != nil && now.Before(*r.StartsAt) {
+		return false
+	}
+	return r.EndsAt == nil || now.Before(*r.EndsAt)
+}
+
+// applies reports whether the rule prices the reward for the user at now
+func (r Rule) applies(ctx context.Context, q segments.Querier, u *segments.User, now time.Time) (bool, error) {
+	if !r.active(now) {
+		return false, nil
+	}
+	switch r.Type {
+	case TypeTierDiscount:
+		return tiers.Eligible(u.Tier, r.Tier), nil
+	case TypeSegmentPrice:
+		target, err := segments.ParseTarget(r.Segment)
+		if err != nil {
+			return false, err
+		}
+		return target.Matches(ctx, q, u, now)
+	}
+	return true, nil
+}
+
+// cost returns the price the rule sets on base
+func (r Rule) cost(base int32) int32 {
+	if r.Cost != nil {
+		return *r.Cost
+	}
+	return base - int32(int64(base)*int64(r.PercentOff)/100)
+}
+
+// Evaluate returns the reward's price for the user at now: the lowest price
+// set by a rule that applies to them, or the base cost. Rules never raise
+// the price.
+func Evaluate(ctx context.Context, q segments.Querier, reward db.RewardsCatalog, u *segments.User, now time.Time) (Price, error) {
+	price := Price{Cost: reward.Cost, BaseCost: reward.Cost}
+	rules, err := Parse(reward.PriceRules.RawMessage)
+	if err != nil {
+		return price, fmt.Errorf("invalid price rules on reward %s: %w", reward.ID, err)
+	}
+	for _, rule := range rules {
+		ok, err := rule.applies(ctx, q, u, now)
+		if err != nil {
+			return price, fmt.Errorf("failed to evaluate price rule: %w", err)
+		}
+		if cost := rule.cost(reward.Cost); ok && cost < price.Cost {
+			price.Cost, price.Rule, price.EndsAt = cost, rule.Type, rule.EndsAt
+		}
+	}
+	return price, nil
+}
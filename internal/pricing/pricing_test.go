@@ -0,0 +1,107 @@
+package pricing
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"encore.app/internal/db"
+	"encore.app/internal/segments"
+	"github.com/sqlc-dev/pqtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rewardWithRules(t *testing.T, rules string) db.RewardsCatalog {
+	t.Helper()
+	require.True(t, json.Valid([]byte(rules)))
+	return db.RewardsCatalog{Cost: 500, PriceRules: pqtype.NullRawMessage{RawMessage: json.RawMessage(rules), Valid: true}}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		ok    bool
+	}{
+		{"null", `null`, true},
+		{"tier discount", `[{"type": "tier_discount", "tier": "gold", "percent_off": 20}]`, true},
+		{"segment price", `[{"type": "segment_price", "segment": {"name": "power-chargers"}, "cost": 400}]`, true},
+		{"sale", `[{"type": "sale", "cost": 300, "ends_at": "2024-06-08T00:00:00Z"}]`, true},
+		{"unknown type", `[{"type": "coupon", "cost": 300}]`, false},
+		{"unknown tier", `[{"type": "tier_discount", "tier": "bronze", "cost": 300}]`, false},
+		{"missing segment", `[{"type": "segment_price", "cost": 300}]`, false},
+		{"invalid segment criteria", `[{"type": "segment_price", "segment": {"criteria": {"op": "gte"}}, "cost": 300}]`, false},
+		{"open-ended sale", `[{"type": "sale", "cost": 300}]`, false},
+		{"no price", `[{"type": "tier_discount", "tier": "gold"}]`, false},
+		{"cost and percent", `[{"type": "tier_discount", "tier": "gold", "cost": 300, "percent_off": 20}]`, false},
+		{"negative cost", `[{"type": "tier_discount", "tier": "gold", "cost": -1}]`, false},
+		{"percent over 100", `[{"type": "tier_discount", "tier": "gold", "percent_off": 101}]`, false},
+		{"empty window", `[{"type": "sale", "cost": 300, "starts_at": "2024-06-08T00:00:00Z", "ends_at": "2024-06-01T00:00:00Z"}]`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.rules))
+			if tt.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	saleEnd := time.Date(2024, 6, 8, 0, 0, 0, 0, time.UTC)
+	reward := rewardWithRules(t, `[
+		{"type": "tier_discount", "tier": "gold", "percent_off": 20},
+		{"type": "segment_price", "segment": {"criteria": {"attr": "lifetime_kwh", "op": "gte", "value": 500}}, "cost": 350},
+		{"type": "sale", "cost": 450, "ends_at": "2024-06-08T00:00:00Z"},
+		{"type": "sale", "cost": 100, "starts_at": "2024-07-01T00:00:00Z", "ends_at": "2024-07-08T00:00:00Z"}
+	]`)
+
+	tests := []struct {
+		name string
+		user *segments.User
+		want Price
+	}{
+		{"sale for everyone", &segments.User{}, Price{Cost: 450, BaseCost: 500, Rule: TypeSale, EndsAt: &saleEnd}},
+		{"tier discount", &segments.User{Tier: "gold"}, Price{Cost: 400, BaseCost: 500, Rule: TypeTierDiscount}},
+		{"higher tier", &segments.User{Tier: "platinum"}, Price{Cost: 400, BaseCost: 500, Rule: TypeTierDiscount}},
+		{"lowest of several", &segments.User{Tier: "gold", LifetimeKWH: 600}, Price{Cost: 350, BaseCost: 500, Rule: TypeSegmentPrice}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Evaluate(ctx, nil, reward, tt.user, now)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.True(t, got.Discounted())
+		})
+	}
+
+	t.Run("after the sale", func(t *testing.T) {
+		got, err := Evaluate(ctx, nil, reward, &segments.User{}, saleEnd)
+		require.NoError(t, err)
+		assert.Equal(t, Price{Cost: 500, BaseCost: 500}, got)
+		assert.False(t, got.Discounted())
+	})
+	t.Run("upcoming sale", func(t *testing.T) {
+		got, err := Evaluate(ctx, nil, reward, &segments.User{}, time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC))
+		require.NoError(t, err)
+		assert.Equal(t, int32(100), got.Cost)
+	})
+}
+
+func TestEvaluate_NeverRaises(t *testing.T) {
+	reward := rewardWithRules(t, `[{"type": "tier_discount", "tier": "silver", "cost": 800}]`)
+	got, err := Evaluate(context.Background(), nil, reward, &segments.User{Tier: "gold"}, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, Price{Cost: 500, BaseCost: 500}, got)
+
+	got, err = Evaluate(context.Background(), nil, db.RewardsCatalog{Cost: 500}, &segments.User{}, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, Price{Cost: 500, BaseCost: 500}, got)
+}
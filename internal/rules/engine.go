@@ -12,6 +12,7 @@ import (
 type RulesConfig struct {
 	Rules    map[string]Rule `yaml:"rules"`
 	Settings Settings        `yaml:"settings"`
+	Tiers    TierConfig      `yaml:"tiers"`
 }
 
 // Rule represents a single rule configuration
@@ -32,11 +33,31 @@ type Settings struct {
 	EnableFirstChargeBonus bool `yaml:"enable_first_charge_bonus"`
 }
 
+// TierConfig describes how users qualify for loyalty tiers
+type TierConfig struct {
+	// WindowDays is the rolling qualification window, 365 when unset
+	WindowDays int `yaml:"window_days"`
+	// DowngradeGraceDays is how long a user keeps a tier they no longer qualify for
+	DowngradeGraceDays int                  `yaml:"downgrade_grace_days"`
+	Levels             map[string]TierLevel `yaml:"levels"`
+}
+
+// TierLevel is the qualification threshold and benefits of one tier. A user
+// qualifies by reaching either threshold within the window.
+type TierLevel struct {
+	MinKWH         float64 `yaml:"min_kwh"`
+	MinPoints      int64   `yaml:"min_points"`
+	EarnMultiplier float64 `yaml:"earn_multiplier,omitempty"`
+	Description    string  `yaml:"description"`
+}
+
 // EventPayload represents the data passed to rule evaluation
 type EventPayload struct {
 	EventType string                 `json:"event_type"`
 	UserID    string                 `json:"user_id"`
 	Data      map[string]interface{} `json:"data"`
+	// Tier is the user's loyalty tier; its earn multiplier applies to the points
+	Tier string `json:"tier,omitempty"`
 }
 
 // Engine represents the rules engine
@@ -61,6 +82,15 @@ func NewEngine(configPath string) (*Engine, error) {
 
 // EvaluateRules evaluates rules for a given event payload
 func (e *Engine) EvaluateRules(ctx context.Context, payload *EventPayload) (int, error) {
+	points, err := e.evaluate(payload)
+	if err != nil {
+		return 0, err
+	}
+	return e.applyTierMultiplier(points, payload.Tier), nil
+}
+
+// evaluate calculates the base points of an event
+func (e *Engine) evaluate(payload *EventPayload) (int, error) {
 	switch payload.EventType {
 	case "CHARGE_KWH":
 		return e.evaluateChargeKWH(payload)
@@ -162,6 +192,29 @@ func (e *Engine) evaluateDailyLogin(payload *EventPayload) (int, error) {
 	return points, nil
 }
 
+// TierMultiplier returns the earn multiplier of a tier, 1 for no tier
+func (e *Engine) TierMultiplier(tier string) float64 {
+	level, ok := e.config.Tiers.Levels[tier]
+	if !ok || level.EarnMultiplier <= 0 {
+		return 1
+	}
+	return level.EarnMultiplier
+}
+
+// applyTierMultiplier scales points by the tier's earn multiplier. Multiplied
+// points are still capped by max_points_per_event.
+func (e *Engine) applyTierMultiplier(points int, tier string) int {
+	multiplier := e.TierMultiplier(tier)
+	if multiplier == 1 {
+		return points
+	}
+	points = int(float64(points) * multiplier)
+	if max := e.config.Settings.MaxPointsPerEvent; max > 0 && points > max {
+		points = max
+	}
+	return points
+}
+
 // GetConfig returns the current rules configuration
 func (e *Engine) GetConfig() *RulesConfig {
 	return e.config
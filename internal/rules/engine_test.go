@@ -266,3 +266,73 @@ settings:
 	assert.Equal(t, 0, points)
 	assert.Contains(t, err.Error(), "unknown event type")
 }
+
+func TestEvaluateRules_TierMultiplier(t *testing.T) {
+	// Create a temporary rules file for testing
+	tempRules := `rules:
+  charge_kwh:
+    points_per_kwh: 10
+    description: "Points earned per kWh charged"
+  referral:
+    points: 300
+    description: "Points earned for successful referral"
+settings:
+  max_points_per_day: 1000
+  max_points_per_event: 500
+tiers:
+  window_days: 365
+  downgrade_grace_days: 30
+  levels:
+    silver:
+      min_kwh: 0
+      min_points: 0
+    gold:
+      min_kwh: 500
+      min_points: 5000
+      earn_multiplier: 1.25
+    platinum:
+      min_kwh: 2000
+      min_points: 20000
+      earn_multiplier: 1.5`
+
+	tmpfile, err := os.CreateTemp("", "rules-*.yaml")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name())
+
+	_, err = tmpfile.Write([]byte(tempRules))
+	require.NoError(t, err)
+	tmpfile.Close()
+
+	engine, err := NewEngine(tmpfile.Name())
+	require.NoError(t, err)
+	assert.Equal(t, 30, engine.GetConfig().Tiers.DowngradeGraceDays)
+
+	tests := []struct {
+		name      string
+		eventType string
+		kwh       float64
+		tier      string
+		want      int
+	}{
+		{"no tier", "CHARGE_KWH", 8, "", 80},
+		{"silver has no multiplier", "CHARGE_KWH", 8, "silver", 80},
+		{"gold", "CHARGE_KWH", 8, "gold", 100},
+		{"platinum", "REFERRAL", 0, "platinum", 450},
+		{"multiplied points are capped", "CHARGE_KWH", 40, "platinum", 500},
+		{"unknown tier", "CHARGE_KWH", 8, "diamond", 80},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := &EventPayload{
+				EventType: tt.eventType,
+				UserID:    "test-user",
+				Data:      map[string]interface{}{"kwh": tt.kwh},
+				Tier:      tt.tier,
+			}
+			points, err := engine.EvaluateRules(context.Background(), payload)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, points)
+		})
+	}
+}
@@ -0,0 +1,346 @@
+// Package segments evaluates segment criteria against users.
+//
+// Criteria are JSON documents stored in segments.criteria. A criteria node is
+// either a condition on a user attribute or a combination of nodes:
+//
+//	{"all": [
+//	  {"attr": "lifetime_kwh", "op": "gte", "value": 500},
+//	  {"attr": "city", "op": "in", "value": ["Bengaluru", "Pune"]},
+//	  {"any": [
+//	    {"attr": "tier", "op": "eq", "value": "gold"},
+//	    {"attr": "signup_date", "op": "within_days", "value": 30}
+//	  ]},
+//	  {"not": {"attr": "vehicle_type", "op": "eq", "value": "2W"}}
+//	]}
+//
+// An empty document matches every user.
+package segments
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Operators
+const (
+	OpEq         = "eq"
+	OpNeq        = "neq"
+	OpGt         = "gt"
+	OpGte        = "gte"
+	OpLt         = "lt"
+	OpLte        = "lte"
+	OpIn         = "in"
+	OpNotIn      = "not_in"
+	OpExists     = "exists"
+	OpBefore     = "before"
+	OpAfter      = "after"
+	OpWithinDays = "within_days"
+)
+
+// Criteria is a parsed criteria node
+type Criteria struct {
+	All []*Criteria `json:"all,omitempty"`
+	Any []*Criteria `json:"any,omitempty"`
+	Not *Criteria   `json:"not,omitempty"`
+
+	Attr  string          `json:"attr,omitempty"`
+	Op    string          `json:"op,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+
+	// Decoded comparison values, set by Parse
+	number  float64
+	text    string
+	texts   []string
+	date    time.Time
+	generic interface{}
+	list    []interface{}
+}
+
+// Parse decodes and validates criteria. Empty or null criteria match everyone.
+func Parse(raw []byte) (*Criteria, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return &Criteria{}, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	var c Criteria
+	if err := dec.Decode(&c); err != nil {
+		return nil, fmt.Errorf("invalid criteria: %w", err)
+	}
+	if err := c.validate("$"); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Evaluate parses criteria and reports whether the user matches them
+func Evaluate(raw []byte, u *User, now time.Time) (bool, error) {
+	c, err := Parse(raw)
+	if err != nil {
+		return false, err
+	}
+	return c.Match(u, now), nil
+}
+
+// validate checks the node's shape and decodes its comparison value
+func (c *Criteria) validate(path string) error {
+	kinds := 0
+	if c.All != nil {
+		kinds++
+	}
+	if c.Any != nil {
+		kinds++
+	}
+	if c.Not != nil {
+		kinds++
+	}
+	if c.Attr != "" || c.Op != "" {
+		kinds++
+	}
+	if kinds > 1 {
+		return fmt.Errorf("%s: a criteria node must have exactly one of all, any, not or attr", path)
+	}
+
+	for i, child := range c.All {
+		if err := child.validate(fmt.Sprintf("%s.all[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	for i, child := range c.Any {
+		if err := child.validate(fmt.Sprintf("%s.any[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	if c.Not != nil {
+		if err := c.Not.validate(path + ".not"); err != nil {
+			return err
+		}
+	}
+	if c.Attr == "" && c.Op == "" {
+		return nil
+	}
+	return c.validateCondition(path)
+}
+
+// validateCondition checks an attribute condition
+func (c *Criteria) validateCondition(path string) error {
+	if c.Attr == "" {
+		return fmt.Errorf("%s: attr is required", path)
+	}
+	kind, ok := attributeKind(c.Attr)
+	if !ok {
+		return fmt.Errorf("%s: unknown attribute %q", path, c.Attr)
+	}
+	if c.Op == OpExists {
+		return nil
+	}
+	if len(c.Value) == 0 {
+		return fmt.Errorf("%s: value is required for op %q", path, c.Op)
+	}
+
+	valueErr := func(want string) error {
+		return fmt.Errorf("%s: op %q on %s needs %s value", path, c.Op, c.Attr, want)
+	}
+	switch kind {
+	case kindNumber:
+		switch c.Op {
+		case OpEq, OpNeq, OpGt, OpGte, OpLt, OpLte:
+			if json.Unmarshal(c.Value, &c.number) != nil {
+				return valueErr("a number")
+			}
+			return nil
+		}
+	case kindString:
+		switch c.Op {
+		case OpEq, OpNeq:
+			if json.Unmarshal(c.Value, &c.text) != nil {
+				return valueErr("a string")
+			}
+			return nil
+		case OpIn, OpNotIn:
+			if json.Unmarshal(c.Value, &c.texts) != nil {
+				return valueErr("a list of strings")
+			}
+			return nil
+		}
+	case kindDate:
+		switch c.Op {
+		case OpBefore, OpAfter:
+			var s string
+			if json.Unmarshal(c.Value, &s) != nil {
+				return valueErr("a date")
+			}
+			date, err := parseDate(s)
+			if err != nil {
+				return valueErr("a YYYY-MM-DD or RFC 3339 date")
+			}
+			c.date = date
+			return nil
+		case OpWithinDays:
+			if json.Unmarshal(c.Value, &c.number) != nil || c.number < 0 {
+				return valueErr("a non-negative number of days")
+			}
+			return nil
+		}
+	case kindAny:
+		switch c.Op {
+		case OpEq, OpNeq:
+			if json.Unmarshal(c.Value, &c.generic) != nil {
+				return valueErr("a JSON")
+			}
+			return nil
+		case OpGt, OpGte, OpLt, OpLte:
+			if json.Unmarshal(c.Value, &c.number) != nil {
+				return valueErr("a number")
+			}
+			return nil
+		case OpIn, OpNotIn:
+			if json.Unmarshal(c.Value, &c.list) != nil {
+				return valueErr("a list")
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: op %q is not supported for attribute %q", path, c.Op, c.Attr)
+}
+
+// Match reports whether the user matches the criteria at time now
+func (c *Criteria) Match(u *User, now time.Time) bool {
+	switch {
+	case c.All != nil:
+		for _, child := range c.All {
+			if !child.Match(u, now) {
+				return false
+			}
+		}
+		return true
+	case c.Any != nil:
+		for _, child := range c.Any {
+			if child.Match(u, now) {
+				return true
+			}
+		}
+		return false
+	case c.Not != nil:
+		return !c.Not.Match(u, now)
+	case c.Attr != "":
+		return c.matchCondition(u, now)
+	default:
+		return true
+	}
+}
+
+// matchCondition evaluates an attribute condition; missing values never match
+// except for neq, not_in and a negated exists
+func (c *Criteria) matchCondition(u *User, now time.Time) bool {
+	value, ok := u.Attribute(c.Attr)
+	if c.Op == OpExists {
+		return ok
+	}
+	if !ok {
+		return c.Op == OpNeq || c.Op == OpNotIn
+	}
+	if kind, _ := attributeKind(c.Attr); kind == kindAny {
+		return c.matchCustom(value)
+	}
+
+	switch v := value.(type) {
+	case float64:
+		return compareNumber(c.Op, v, c.number)
+	case string:
+		switch c.Op {
+		case OpEq:
+			return strings.EqualFold(c.text, v)
+		case OpNeq:
+			return !strings.EqualFold(c.text, v)
+		case OpIn:
+			return containsFold(c.texts, v)
+		case OpNotIn:
+			return !containsFold(c.texts, v)
+		}
+	case time.Time:
+		switch c.Op {
+		case OpBefore:
+			return v.Before(c.date)
+		case OpAfter:
+			return v.After(c.date)
+		case OpWithinDays:
+			return !v.Before(now.Add(-time.Duration(c.number * float64(24*time.Hour))))
+		}
+	}
+	return false
+}
+
+// matchCustom evaluates a condition on a custom attribute of any JSON type
+func (c *Criteria) matchCustom(v interface{}) bool {
+	switch c.Op {
+	case OpGt, OpGte, OpLt, OpLte:
+		n, ok := v.(float64)
+		return ok && compareNumber(c.Op, n, c.number)
+	case OpEq:
+		return equalJSON(v, c.generic)
+	case OpNeq:
+		return !equalJSON(v, c.generic)
+	case OpIn, OpNotIn:
+		found := false
+		for _, item := range c.list {
+			if equalJSON(v, item) {
+				found = true
+				break
+			}
+		}
+		return found == (c.Op == OpIn)
+	}
+	return false
+}
+
+func compareNumber(op string, v, want float64) bool {
+	switch op {
+	case OpEq:
+		return v == want
+	case OpNeq:
+		return v != want
+	case OpGt:
+		return v > want
+	case OpGte:
+		return v >= want
+	case OpLt:
+		return v < want
+	case OpLte:
+		return v <= want
+	}
+	return false
+}
+
+func containsFold(list []string, v string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// equalJSON compares decoded JSON values, ignoring case for strings
+func equalJSON(a, b interface{}) bool {
+	as, aok := a.(string)
+	bs, bok := b.(string)
+	if aok && bok {
+		return strings.EqualFold(as, bs)
+	}
+	ab, _ := json.Marshal(a)
+	bb, _ := json.Marshal(b)
+	return bytes.Equal(ab, bb)
+}
+
+// parseDate accepts a calendar date or an RFC 3339 timestamp
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
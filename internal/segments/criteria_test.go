@@ -0,0 +1,137 @@
+package segments
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testNow = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func testUser() *User {
+	return &User{
+		ID:          "550e8400-e29b-41d4-a716-446655440000",
+		LifetimeKWH: 620.5,
+		Balance:     1200,
+		SignupDate:  time.Date(2024, 5, 20, 0, 0, 0, 0, time.UTC),
+		Tier:        "gold",
+		City:        "Bengaluru",
+		VehicleType: "4W",
+		VehicleMake: "Tata",
+		BatteryKWH:  40.5,
+		Locale:      "en-IN",
+		Attributes: map[string]interface{}{
+			"fleet":    true,
+			"sessions": 42,
+			"plan":     "commuter",
+		},
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name     string
+		criteria string
+		want     bool
+	}{
+		{"empty matches everyone", `{}`, true},
+		{"null matches everyone", `null`, true},
+		{"lifetime kwh gte", `{"attr": "lifetime_kwh", "op": "gte", "value": 500}`, true},
+		{"lifetime kwh lt", `{"attr": "lifetime_kwh", "op": "lt", "value": 500}`, false},
+		{"balance gt", `{"attr": "balance", "op": "gt", "value": 1000}`, true},
+		{"city eq ignores case", `{"attr": "city", "op": "eq", "value": "bengaluru"}`, true},
+		{"city in", `{"attr": "city", "op": "in", "value": ["Pune", "Bengaluru"]}`, true},
+		{"city not_in", `{"attr": "city", "op": "not_in", "value": ["Pune", "Bengaluru"]}`, false},
+		{"tier neq", `{"attr": "tier", "op": "neq", "value": "silver"}`, true},
+		{"vehicle type eq", `{"attr": "vehicle_type", "op": "eq", "value": "2W"}`, false},
+		{"vehicle make in", `{"attr": "vehicle_make", "op": "in", "value": ["tata", "MG"]}`, true},
+		{"battery kwh gte", `{"attr": "battery_kwh", "op": "gte", "value": 40}`, true},
+		{"locale eq", `{"attr": "locale", "op": "eq", "value": "en-IN"}`, true},
+		{"unknown language", `{"attr": "preferred_language", "op": "exists"}`, false},
+		{"signup after", `{"attr": "signup_date", "op": "after", "value": "2024-05-01"}`, true},
+		{"signup before", `{"attr": "signup_date", "op": "before", "value": "2024-05-01T00:00:00Z"}`, false},
+		{"signup within days", `{"attr": "signup_date", "op": "within_days", "value": 30}`, true},
+		{"signup not within days", `{"attr": "signup_date", "op": "within_days", "value": 7}`, false},
+		{"custom bool", `{"attr": "attributes.fleet", "op": "eq", "value": true}`, true},
+		{"custom number compares after normalisation", `{"attr": "attributes.sessions", "op": "gte", "value": 40}`, true},
+		{"custom in", `{"attr": "attributes.plan", "op": "in", "value": ["commuter", "business"]}`, true},
+		{"custom exists", `{"attr": "attributes.referrer", "op": "exists"}`, false},
+		{"missing custom neq matches", `{"attr": "attributes.referrer", "op": "neq", "value": "x"}`, true},
+		{"missing custom eq does not match", `{"attr": "attributes.referrer", "op": "eq", "value": "x"}`, false},
+		{"all", `{"all": [
+			{"attr": "lifetime_kwh", "op": "gte", "value": 500},
+			{"attr": "city", "op": "eq", "value": "Bengaluru"}
+		]}`, true},
+		{"all with one failing", `{"all": [
+			{"attr": "lifetime_kwh", "op": "gte", "value": 500},
+			{"attr": "city", "op": "eq", "value": "Pune"}
+		]}`, false},
+		{"any", `{"any": [
+			{"attr": "tier", "op": "eq", "value": "platinum"},
+			{"attr": "vehicle_type", "op": "eq", "value": "4W"}
+		]}`, true},
+		{"not", `{"not": {"attr": "tier", "op": "eq", "value": "gold"}}`, false},
+		{"nested", `{"all": [
+			{"any": [{"attr": "city", "op": "eq", "value": "Pune"}, {"attr": "balance", "op": "gte", "value": 1000}]},
+			{"not": {"attr": "vehicle_type", "op": "eq", "value": "2W"}}
+		]}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Evaluate([]byte(tt.criteria), testUser(), testNow)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEvaluate_UnknownValues(t *testing.T) {
+	u := &User{ID: "u1"}
+
+	for _, criteria := range []string{
+		`{"attr": "city", "op": "eq", "value": "Pune"}`,
+		`{"attr": "tier", "op": "in", "value": ["gold"]}`,
+		`{"attr": "signup_date", "op": "within_days", "value": 30}`,
+		`{"attr": "battery_kwh", "op": "lt", "value": 100}`,
+	} {
+		got, err := Evaluate([]byte(criteria), u, testNow)
+		require.NoError(t, err)
+		assert.False(t, got, criteria)
+	}
+
+	got, err := Evaluate([]byte(`{"attr": "city", "op": "not_in", "value": ["Pune"]}`), u, testNow)
+	require.NoError(t, err)
+	assert.True(t, got)
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		criteria string
+		errMsg   string
+	}{
+		{"malformed json", `{"attr":`, "invalid criteria"},
+		{"unknown field", `{"attribute": "city"}`, "invalid criteria"},
+		{"unknown attribute", `{"attr": "shoe_size", "op": "eq", "value": 9}`, `unknown attribute "shoe_size"`},
+		{"unsupported op", `{"attr": "city", "op": "gt", "value": "Pune"}`, `op "gt" is not supported`},
+		{"missing value", `{"attr": "balance", "op": "gte"}`, "value is required"},
+		{"wrong value type", `{"attr": "balance", "op": "gte", "value": "lots"}`, "needs a number value"},
+		{"bad date", `{"attr": "signup_date", "op": "after", "value": "yesterday"}`, "needs a YYYY-MM-DD or RFC 3339 date value"},
+		{"negative days", `{"attr": "signup_date", "op": "within_days", "value": -1}`, "non-negative"},
+		{"mixed node", `{"all": [], "attr": "city", "op": "eq", "value": "Pune"}`, "exactly one of"},
+		{"nested error has path", `{"all": [{}, {"not": {"attr": "city", "op": "in", "value": "Pune"}}]}`, "$.all[1].not"},
+		{"op without attr", `{"op": "eq", "value": 1}`, "attr is required"},
+		{"empty custom key", `{"attr": "attributes.", "op": "exists"}`, "unknown attribute"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.criteria))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package segments
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"encore.app/internal/db"
+	"github.com/google/uuid"
+)
+
+// Querier is the subset of *db.Queries used to load users and segments
+type Querier interface {
+	GetUserSegmentFacts(ctx context.Context, id uuid.UUID) (db.GetUserSegmentFactsRow, error)
+	GetSegmentByName(ctx context.Context, name string) (db.Segment, error)
+}
+
+// LoadUser loads the facts about a user that criteria are evaluated against
+func LoadUser(ctx context.Context, q Querier, userID uuid.UUID) (*User, error) {
+	facts, err := q.GetUserSegmentFacts(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return UserFromFacts(facts)
+}
+
+// UserFromFacts builds a User from a row of the segment facts queries. Rows of
+// ListUserSegmentFacts and ListSegmentMemberFacts convert to this type.
+func UserFromFacts(facts db.GetUserSegmentFactsRow) (*User, error) {
+	u := &User{
+		ID:                facts.ID.String(),
+		LifetimeKWH:       facts.LifetimeKwh,
+		Balance:           facts.Balance,
+		SignupDate:        facts.CreatedAt,
+		Tier:              facts.Tier.String,
+		City:              facts.City.String,
+		VehicleType:       facts.VehicleType.String,
+		VehicleMake:       facts.VehicleMake.String,
+		VehicleModel:      facts.VehicleModel.String,
+		BatteryKWH:        facts.BatteryKwh.Float64,
+		Locale:            facts.Locale.String,
+		Timezone:          facts.Timezone.String,
+		PreferredLanguage: facts.PreferredLanguage.String,
+	}
+	if len(facts.Attributes) > 0 {
+		if err := json.Unmarshal(facts.Attributes, &u.Attributes); err != nil {
+			return nil, fmt.Errorf("invalid attributes for user %s: %w", u.ID, err)
+		}
+	}
+	return u, nil
+}
+
+// IsMember reports whether the user belongs to the named segment. Unknown and
+// inactive segments have no members.
+func IsMember(ctx context.Context, q Querier, name string, u *User, now time.Time) (bool, error) {
+	segment, err := q.GetSegmentByName(ctx, name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	if !segment.Active {
+		return false, nil
+	}
+	return Evaluate(segment.Criteria, u, now)
+}
+
+// Target is the audience of a reward, stored in rewards_catalog.segment. It
+// names a segment, carries inline criteria, or both (the user must match each).
+// Other keys, such as a feature-flag "type", are left to the caller.
+type Target struct {
+	Name     string          `json:"name,omitempty"`
+	Criteria json.RawMessage `json:"criteria,omitempty"`
+}
+
+// ParseTarget decodes a reward's segment column; a null column targets everyone
+func ParseTarget(raw []byte) (Target, error) {
+	var t Target
+	if len(raw) == 0 {
+		return t, nil
+	}
+	if err := json.Unmarshal(raw, &t); err != nil {
+		return t, err
+	}
+	if len(t.Criteria) > 0 {
+		if _, err := Parse(t.Criteria); err != nil {
+			return t, err
+		}
+	}
+	return t, nil
+}
+
+// Matches reports whether the user is in the target audience
+func (t Target) Matches(ctx context.Context, q Querier, u *User, now time.Time) (bool, error) {
+	if len(t.Criteria) > 0 {
+		ok, err := Evaluate(t.Criteria, u, now)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	if t.Name != "" {
+		return IsMember(ctx, q, t.Name, u, now)
+	}
+	return true, nil
+}
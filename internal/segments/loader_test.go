@@ -0,0 +1,152 @@
+package segments
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"encore.app/internal/db"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeQuerier struct {
+	facts    map[uuid.UUID]db.GetUserSegmentFactsRow
+	segments map[string]db.Segment
+}
+
+func (f *fakeQuerier) GetUserSegmentFacts(ctx context.Context, id uuid.UUID) (db.GetUserSegmentFactsRow, error) {
+	row, ok := f.facts[id]
+	if !ok {
+		return row, sql.ErrNoRows
+	}
+	return row, nil
+}
+
+func (f *fakeQuerier) GetSegmentByName(ctx context.Context, name string) (db.Segment, error) {
+	s, ok := f.segments[name]
+	if !ok {
+		return s, sql.ErrNoRows
+	}
+	return s, nil
+}
+
+func newFakeQuerier() *fakeQuerier {
+	return &fakeQuerier{
+		facts: map[uuid.UUID]db.GetUserSegmentFactsRow{},
+		segments: map[string]db.Segment{
+			"power-chargers": {
+				Name:     "power-chargers",
+				Criteria: json.RawMessage(`{"attr": "lifetime_kwh", "op": "gte", "value": 500}`),
+				Active:   true,
+			},
+			"retired": {
+				Name:     "retired",
+				Criteria: json.RawMessage(`{}`),
+				Active:   false,
+			},
+		},
+	}
+}
+
+func TestLoadUser(t *testing.T) {
+	q := newFakeQuerier()
+	id := uuid.New()
+	signup := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	q.facts[id] = db.GetUserSegmentFactsRow{ID: id, CreatedAt: signup, Balance: 300, LifetimeKwh: 42.5}
+
+	u, err := LoadUser(context.Background(), q, id)
+	require.NoError(t, err)
+	assert.Equal(t, &User{ID: id.String(), LifetimeKWH: 42.5, Balance: 300, SignupDate: signup}, u)
+
+	_, err = LoadUser(context.Background(), q, uuid.New())
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestLoadUser_Profile(t *testing.T) {
+	q := newFakeQuerier()
+	id := uuid.New()
+	q.facts[id] = db.GetUserSegmentFactsRow{
+		ID:          id,
+		City:        sql.NullString{String: "Pune", Valid: true},
+		VehicleType: sql.NullString{String: "4W", Valid: true},
+		BatteryKwh:  sql.NullFloat64{Float64: 40.5, Valid: true},
+		Locale:      sql.NullString{String: "en-IN", Valid: true},
+		Attributes:  json.RawMessage(`{"fleet": true}`),
+		Tier:        sql.NullString{String: "gold", Valid: true},
+	}
+
+	u, err := LoadUser(context.Background(), q, id)
+	require.NoError(t, err)
+	assert.Equal(t, "Pune", u.City)
+	assert.Equal(t, "4W", u.VehicleType)
+	assert.Equal(t, 40.5, u.BatteryKWH)
+	assert.Equal(t, "en-IN", u.Locale)
+	assert.Equal(t, "gold", u.Tier)
+	assert.Equal(t, map[string]interface{}{"fleet": true}, u.Attributes)
+
+	q.facts[id] = db.GetUserSegmentFactsRow{ID: id, Attributes: json.RawMessage(`[`)}
+	_, err = LoadUser(context.Background(), q, id)
+	assert.Error(t, err)
+}
+
+func TestIsMember(t *testing.T) {
+	q := newFakeQuerier()
+	ctx := context.Background()
+
+	ok, err := IsMember(ctx, q, "power-chargers", &User{LifetimeKWH: 600}, testNow)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = IsMember(ctx, q, "power-chargers", &User{LifetimeKWH: 10}, testNow)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = IsMember(ctx, q, "retired", &User{}, testNow)
+	require.NoError(t, err)
+	assert.False(t, ok, "inactive segments have no members")
+
+	ok, err = IsMember(ctx, q, "missing", &User{}, testNow)
+	require.NoError(t, err)
+	assert.False(t, ok, "unknown segments have no members")
+}
+
+func TestTarget(t *testing.T) {
+	q := newFakeQuerier()
+	ctx := context.Background()
+	heavy := &User{LifetimeKWH: 600, City: "Pune"}
+	light := &User{LifetimeKWH: 10, City: "Pune"}
+
+	tests := []struct {
+		name   string
+		raw    string
+		user   *User
+		want   bool
+		parseE bool
+	}{
+		{"no target", ``, light, true, false},
+		{"named segment", `{"name": "power-chargers"}`, heavy, true, false},
+		{"named segment excludes", `{"name": "power-chargers"}`, light, false, false},
+		{"inline criteria", `{"criteria": {"attr": "city", "op": "eq", "value": "Pune"}}`, light, true, false},
+		{"both must match", `{"name": "power-chargers", "criteria": {"attr": "city", "op": "eq", "value": "Pune"}}`, light, false, false},
+		{"other keys are ignored", `{"type": "early-access"}`, light, true, false},
+		{"invalid criteria", `{"criteria": {"attr": "nope", "op": "eq", "value": 1}}`, light, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, err := ParseTarget([]byte(tt.raw))
+			if tt.parseE {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			got, err := target.Matches(ctx, q, tt.user, testNow)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
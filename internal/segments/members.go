@@ -0,0 +1,160 @@
+package segments
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"encore.app/internal/db"
+	"github.com/google/uuid"
+)
+
+// userBatchSize is how many users are loaded per query when scanning all users
+const userBatchSize = 500
+
+// UserLister is the subset of *db.Queries used to scan all users
+type UserLister interface {
+	ListUserSegmentFacts(ctx context.Context, arg db.ListUserSegmentFactsParams) ([]db.ListUserSegmentFactsRow, error)
+}
+
+// MemberQuerier is the subset of *db.Queries used to materialize segments
+type MemberQuerier interface {
+	Querier
+	UserLister
+	ListMaterializedSegments(ctx context.Context) ([]db.Segment, error)
+	UpsertSegmentMember(ctx context.Context, arg db.UpsertSegmentMemberParams) error
+	DeleteSegmentMember(ctx context.Context, arg db.DeleteSegmentMemberParams) error
+	DeleteStaleSegmentMembers(ctx context.Context, arg db.DeleteStaleSegmentMembersParams) (int64, error)
+	SetSegmentMaterializedAt(ctx context.Context, arg db.SetSegmentMaterializedAtParams) error
+}
+
+// ForEachUser calls fn for every user in ID order, loading them in batches
+func ForEachUser(ctx context.Context, q UserLister, fn func(u *User, id uuid.UUID) error) error {
+	after := uuid.Nil
+	for {
+		rows, err := q.ListUserSegmentFacts(ctx, db.ListUserSegmentFactsParams{
+			AfterID:   after,
+			BatchSize: userBatchSize,
+		})
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			u, err := UserFromFacts(db.GetUserSegmentFactsRow(row))
+			if err != nil {
+				return err
+			}
+			if err := fn(u, row.ID); err != nil {
+				return err
+			}
+		}
+		if len(rows) < userBatchSize {
+			return nil
+		}
+		after = rows[len(rows)-1].ID
+	}
+}
+
+// Preview is the audience of criteria at the time they were evaluated
+type Preview struct {
+	Count  int64
+	Sample []*User
+}
+
+// PreviewCriteria evaluates criteria against every user and returns how many
+// match along with the first sampleSize matches in ID order
+func PreviewCriteria(ctx context.Context, q UserLister, c *Criteria, sampleSize int, now time.Time) (*Preview, error) {
+	p := &Preview{Sample: []*User{}}
+	err := ForEachUser(ctx, q, func(u *User, _ uuid.UUID) error {
+		if !c.Match(u, now) {
+			return nil
+		}
+		p.Count++
+		if len(p.Sample) < sampleSize {
+			p.Sample = append(p.Sample, u)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Refresh recomputes the members of a materialized segment and returns how
+// many there are. Matching users are stamped with now and members that were
+// not stamped are removed, so concurrent per-user updates are not lost.
+func Refresh(ctx context.Context, q MemberQuerier, segment db.Segment, now time.Time) (int64, error) {
+	c, err := Parse(segment.Criteria)
+	if err != nil {
+		return 0, fmt.Errorf("segment %s: %w", segment.Name, err)
+	}
+
+	var members int64
+	err = ForEachUser(ctx, q, func(u *User, id uuid.UUID) error {
+		if !c.Match(u, now) {
+			return nil
+		}
+		members++
+		return q.UpsertSegmentMember(ctx, db.UpsertSegmentMemberParams{
+			SegmentID:   segment.ID,
+			UserID:      id,
+			RefreshedAt: now,
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := q.DeleteStaleSegmentMembers(ctx, db.DeleteStaleSegmentMembersParams{
+		SegmentID:       segment.ID,
+		RefreshedBefore: now,
+	}); err != nil {
+		return 0, err
+	}
+	if err := q.SetSegmentMaterializedAt(ctx, db.SetSegmentMaterializedAtParams{
+		ID:             segment.ID,
+		MaterializedAt: sql.NullTime{Time: now, Valid: true},
+	}); err != nil {
+		return 0, err
+	}
+	return members, nil
+}
+
+// RefreshUser re-evaluates a single user against every materialized segment,
+// adding or removing their membership as needed
+func RefreshUser(ctx context.Context, q MemberQuerier, userID uuid.UUID, now time.Time) error {
+	materialized, err := q.ListMaterializedSegments(ctx)
+	if err != nil || len(materialized) == 0 {
+		return err
+	}
+
+	u, err := LoadUser(ctx, q, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, segment := range materialized {
+		c, err := Parse(segment.Criteria)
+		if err != nil {
+			return fmt.Errorf("segment %s: %w", segment.Name, err)
+		}
+		if c.Match(u, now) {
+			err = q.UpsertSegmentMember(ctx, db.UpsertSegmentMemberParams{
+				SegmentID:   segment.ID,
+				UserID:      userID,
+				RefreshedAt: now,
+			})
+		} else {
+			err = q.DeleteSegmentMember(ctx, db.DeleteSegmentMemberParams{
+				SegmentID: segment.ID,
+				UserID:    userID,
+			})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package segments
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+
+	"encore.app/internal/db"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memberKey struct {
+	segment uuid.UUID
+	user    uuid.UUID
+}
+
+type fakeMemberQuerier struct {
+	*fakeQuerier
+	materialized   []db.Segment
+	members        map[memberKey]time.Time
+	materializedAt map[uuid.UUID]time.Time
+	pages          int
+}
+
+func newFakeMemberQuerier() *fakeMemberQuerier {
+	return &fakeMemberQuerier{
+		fakeQuerier:    newFakeQuerier(),
+		members:        map[memberKey]time.Time{},
+		materializedAt: map[uuid.UUID]time.Time{},
+	}
+}
+
+func (f *fakeMemberQuerier) addUser(kwh float64) uuid.UUID {
+	id := uuid.New()
+	f.facts[id] = db.GetUserSegmentFactsRow{ID: id, CreatedAt: testNow, LifetimeKwh: kwh}
+	return id
+}
+
+func (f *fakeMemberQuerier) ListUserSegmentFacts(ctx context.Context, arg db.ListUserSegmentFactsParams) ([]db.ListUserSegmentFactsRow, error) {
+	f.pages++
+	var rows []db.ListUserSegmentFactsRow
+	for _, facts := range f.facts {
+		if facts.ID.String() > arg.AfterID.String() {
+			rows = append(rows, db.ListUserSegmentFactsRow(facts))
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].ID.String() < rows[j].ID.String() })
+	if len(rows) > int(arg.BatchSize) {
+		rows = rows[:arg.BatchSize]
+	}
+	return rows, nil
+}
+
+func (f *fakeMemberQuerier) ListMaterializedSegments(ctx context.Context) ([]db.Segment, error) {
+	return f.materialized, nil
+}
+
+func (f *fakeMemberQuerier) UpsertSegmentMember(ctx context.Context, arg db.UpsertSegmentMemberParams) error {
+	f.members[memberKey{arg.SegmentID, arg.UserID}] = arg.RefreshedAt
+	return nil
+}
+
+func (f *fakeMemberQuerier) DeleteSegmentMember(ctx context.Context, arg db.DeleteSegmentMemberParams) error {
+	delete(f.members, memberKey{arg.SegmentID, arg.UserID})
+	return nil
+}
+
+func (f *fakeMemberQuerier) DeleteStaleSegmentMembers(ctx context.Context, arg db.DeleteStaleSegmentMembersParams) (int64, error) {
+	var n int64
+	for k, refreshed := range f.members {
+		if k.segment == arg.SegmentID && refreshed.Before(arg.RefreshedBefore) {
+			delete(f.members, k)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (f *fakeMemberQuerier) SetSegmentMaterializedAt(ctx context.Context, arg db.SetSegmentMaterializedAtParams) error {
+	f.materializedAt[arg.ID] = arg.MaterializedAt.Time
+	return nil
+}
+
+func (f *fakeMemberQuerier) isMember(segment, user uuid.UUID) bool {
+	_, ok := f.members[memberKey{segment, user}]
+	return ok
+}
+
+func heavyChargers() db.Segment {
+	return db.Segment{
+		ID:           uuid.New(),
+		Name:         "heavy-chargers",
+		Criteria:     json.RawMessage(`{"attr": "lifetime_kwh", "op": "gte", "value": 500}`),
+		Active:       true,
+		Materialized: true,
+	}
+}
+
+func TestPreviewCriteria(t *testing.T) {
+	q := newFakeMemberQuerier()
+	for i := 0; i < userBatchSize+10; i++ {
+		q.addUser(float64(i))
+	}
+
+	c, err := Parse([]byte(`{"attr": "lifetime_kwh", "op": "gte", "value": 500}`))
+	require.NoError(t, err)
+
+	preview, err := PreviewCriteria(context.Background(), q, c, 5, testNow)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), preview.Count)
+	assert.Len(t, preview.Sample, 5)
+	assert.Equal(t, 2, q.pages, "users are loaded in batches")
+	for _, u := range preview.Sample {
+		assert.GreaterOrEqual(t, u.LifetimeKWH, 500.0)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	q := newFakeMemberQuerier()
+	segment := heavyChargers()
+	heavy := q.addUser(800)
+	light := q.addUser(10)
+
+	// A member from an earlier refresh who no longer matches is removed
+	earlier := testNow.Add(-time.Hour)
+	q.members[memberKey{segment.ID, light}] = earlier
+
+	n, err := Refresh(context.Background(), q, segment, testNow)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.True(t, q.isMember(segment.ID, heavy))
+	assert.False(t, q.isMember(segment.ID, light))
+	assert.Equal(t, testNow, q.materializedAt[segment.ID])
+}
+
+func TestRefresh_InvalidCriteria(t *testing.T) {
+	q := newFakeMemberQuerier()
+	segment := heavyChargers()
+	segment.Criteria = json.RawMessage(`{"attr": "shoe_size", "op": "eq", "value": 9}`)
+
+	_, err := Refresh(context.Background(), q, segment, testNow)
+	assert.ErrorContains(t, err, "heavy-chargers")
+}
+
+func TestRefreshUser(t *testing.T) {
+	q := newFakeMemberQuerier()
+	segment := heavyChargers()
+	q.materialized = []db.Segment{segment}
+	id := q.addUser(450)
+
+	require.NoError(t, RefreshUser(context.Background(), q, id, testNow))
+	assert.False(t, q.isMember(segment.ID, id))
+
+	// Charging past the threshold adds the user
+	q.facts[id] = db.GetUserSegmentFactsRow{ID: id, CreatedAt: testNow, LifetimeKwh: 520}
+	require.NoError(t, RefreshUser(context.Background(), q, id, testNow))
+	assert.True(t, q.isMember(segment.ID, id))
+
+	// Criteria changes take the user out again
+	q.materialized[0].Criteria = json.RawMessage(`{"attr": "lifetime_kwh", "op": "gte", "value": 1000}`)
+	require.NoError(t, RefreshUser(context.Background(), q, id, testNow))
+	assert.False(t, q.isMember(segment.ID, id))
+}
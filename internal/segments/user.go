@@ -0,0 +1,142 @@
+package segments
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Built-in attributes criteria can refer to. Custom profile attributes are
+// addressed as "attributes.<key>".
+const (
+	AttrUserID      = "user_id"
+	AttrLifetimeKWH = "lifetime_kwh"
+	AttrBalance     = "balance"
+	AttrSignupDate  = "signup_date"
+	AttrTier        = "tier"
+	AttrCity        = "city"
+	AttrVehicleType = "vehicle_type"
+	// Profile attributes
+	AttrVehicleMake       = "vehicle_make"
+	AttrVehicleModel      = "vehicle_model"
+	AttrBatteryKWH        = "battery_kwh"
+	AttrLocale            = "locale"
+	AttrTimezone          = "timezone"
+	AttrPreferredLanguage = "preferred_language"
+
+	customAttrPrefix = "attributes."
+)
+
+type attrKind int
+
+const (
+	kindNumber attrKind = iota
+	kindString
+	kindDate
+	kindAny
+)
+
+var builtinAttributes = map[string]attrKind{
+	AttrUserID:      kindString,
+	AttrLifetimeKWH: kindNumber,
+	AttrBalance:     kindNumber,
+	AttrSignupDate:  kindDate,
+	AttrTier:        kindString,
+	AttrCity:        kindString,
+	AttrVehicleType: kindString,
+
+	AttrVehicleMake:       kindString,
+	AttrVehicleModel:      kindString,
+	AttrBatteryKWH:        kindNumber,
+	AttrLocale:            kindString,
+	AttrTimezone:          kindString,
+	AttrPreferredLanguage: kindString,
+}
+
+// attributeKind returns the value kind of a criteria attribute
+func attributeKind(attr string) (attrKind, bool) {
+	if kind, ok := builtinAttributes[attr]; ok {
+		return kind, true
+	}
+	if strings.HasPrefix(attr, customAttrPrefix) && len(attr) > len(customAttrPrefix) {
+		return kindAny, true
+	}
+	return 0, false
+}
+
+// User holds the facts about a user that criteria are evaluated against.
+// Empty string fields and a zero BatteryKWH are treated as unknown.
+type User struct {
+	ID                string
+	LifetimeKWH       float64
+	Balance           int64
+	SignupDate        time.Time
+	Tier              string
+	City              string
+	VehicleType       string
+	VehicleMake       string
+	VehicleModel      string
+	BatteryKWH        float64
+	Locale            string
+	Timezone          string
+	PreferredLanguage string
+	// Attributes are custom profile attributes as decoded JSON values
+	Attributes map[string]interface{}
+}
+
+// Attribute returns the value of a criteria attribute and whether it is known
+func (u *User) Attribute(attr string) (interface{}, bool) {
+	switch attr {
+	case AttrUserID:
+		return u.ID, u.ID != ""
+	case AttrLifetimeKWH:
+		return u.LifetimeKWH, true
+	case AttrBalance:
+		return float64(u.Balance), true
+	case AttrSignupDate:
+		return u.SignupDate, !u.SignupDate.IsZero()
+	case AttrTier:
+		return u.Tier, u.Tier != ""
+	case AttrCity:
+		return u.City, u.City != ""
+	case AttrVehicleType:
+		return u.VehicleType, u.VehicleType != ""
+	case AttrVehicleMake:
+		return u.VehicleMake, u.VehicleMake != ""
+	case AttrVehicleModel:
+		return u.VehicleModel, u.VehicleModel != ""
+	case AttrBatteryKWH:
+		return u.BatteryKWH, u.BatteryKWH != 0
+	case AttrLocale:
+		return u.Locale, u.Locale != ""
+	case AttrTimezone:
+		return u.Timezone, u.Timezone != ""
+	case AttrPreferredLanguage:
+		return u.PreferredLanguage, u.PreferredLanguage != ""
+	}
+	if key := strings.TrimPrefix(attr, customAttrPrefix); key != attr {
+		v, ok := u.Attributes[key]
+		if !ok || v == nil {
+			return nil, false
+		}
+		return normalizeJSON(v), true
+	}
+	return nil, false
+}
+
+// normalizeJSON converts a Go value to its decoded JSON form, e.g. int to float64
+func normalizeJSON(v interface{}) interface{} {
+	switch v.(type) {
+	case string, float64, bool:
+		return v
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return v
+	}
+	return out
+}
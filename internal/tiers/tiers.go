@@ -0,0 +1,117 @@
+// Package tiers computes loyalty tiers from the rolling ledger.
+//
+// A user qualifies for the highest tier whose kWh or points threshold they
+// reached within the qualification window (see rules.TierConfig). Upgrades
+// apply immediately. A user who no longer qualifies keeps their tier for the
+// downgrade grace period and drops to the tier they qualify for if they have
+// not requalified by then.
+package tiers
+
+import (
+	"database/sql"
+	"time"
+
+	"encore.app/internal/rules"
+)
+
+// Tier names, lowest first
+const (
+	Silver   = "silver"
+	Gold     = "gold"
+	Platinum = "platinum"
+)
+
+// defaultWindowDays is the qualification window when none is configured
+const defaultWindowDays = 365
+
+// Names lists the tiers from lowest to highest
+var Names = []string{Silver, Gold, Platinum}
+
+// Rank returns the position of a tier in Names, or -1 for no or an unknown tier
+func Rank(tier string) int {
+	for i, name := range Names {
+		if name == tier {
+			return i
+		}
+	}
+	return -1
+}
+
+// Valid reports whether tier is a known tier name
+func Valid(tier string) bool {
+	return Rank(tier) >= 0
+}
+
+// Eligible reports whether a user in tier may use something limited to
+// minTier and above. An empty minTier is open to everyone.
+func Eligible(tier, minTier string) bool {
+	if minTier == "" {
+		return true
+	}
+	return Rank(tier) >= Rank(minTier)
+}
+
+// WindowStart returns the start of the qualification window ending at now
+func WindowStart(cfg rules.TierConfig, now time.Time) time.Time {
+	days := cfg.WindowDays
+	if days <= 0 {
+		days = defaultWindowDays
+	}
+	return now.AddDate(0, 0, -days)
+}
+
+// Qualify returns the highest configured tier reached by kWh or points earned
+// within the window, or "" when no tier's threshold is met
+func Qualify(cfg rules.TierConfig, kwh float64, points int64) string {
+	for i := len(Names) - 1; i >= 0; i-- {
+		level, ok := cfg.Levels[Names[i]]
+		if !ok {
+			continue
+		}
+		if kwh >= level.MinKWH || points >= level.MinPoints {
+			return Names[i]
+		}
+	}
+	return ""
+}
+
+// Decision is the outcome of evaluating a user's tier
+type Decision struct {
+	From string
+	Tier string
+	// DowngradeAt is when the user drops a tier unless they requalify first
+	DowngradeAt sql.NullTime
+}
+
+// Changed reports whether the evaluation moved the user to another tier
+func (d Decision) Changed() bool {
+	return d.Tier != d.From
+}
+
+// Upgrade reports whether the user moved up
+func (d Decision) Upgrade() bool {
+	return Rank(d.Tier) > Rank(d.From)
+}
+
+// Decide applies the grace period to the tier a user qualifies for. current
+// and downgradeAt are the stored state; both are empty for unevaluated users.
+func Decide(cfg rules.TierConfig, current string, downgradeAt sql.NullTime, qualified string, now time.Time) Decision {
+	d := Decision{From: current, Tier: qualified}
+	if Rank(qualified) >= Rank(current) {
+		return d
+	}
+
+	grace := time.Duration(cfg.DowngradeGraceDays) * 24 * time.Hour
+	switch {
+	case grace <= 0:
+		return d
+	case !downgradeAt.Valid:
+		// Start the grace period
+		d.Tier = current
+		d.DowngradeAt = sql.NullTime{Time: now.Add(grace), Valid: true}
+	case now.Before(downgradeAt.Time):
+		d.Tier = current
+		d.DowngradeAt = downgradeAt
+	}
+	return d
+}
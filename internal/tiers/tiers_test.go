@@ -0,0 +1,91 @@
+package tiers
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"encore.app/internal/rules"
+	"github.com/stretchr/testify/assert"
+)
+
+var testNow = time.Date(2025, 3, 1, 2, 0, 0, 0, time.UTC)
+
+var testConfig = rules.TierConfig{
+	WindowDays:         365,
+	DowngradeGraceDays: 30,
+	Levels: map[string]rules.TierLevel{
+		Silver:   {},
+		Gold:     {MinKWH: 500, MinPoints: 5000, EarnMultiplier: 1.25},
+		Platinum: {MinKWH: 2000, MinPoints: 20000, EarnMultiplier: 1.5},
+	},
+}
+
+func TestQualify(t *testing.T) {
+	tests := []struct {
+		name   string
+		kwh    float64
+		points int64
+		want   string
+	}{
+		{"new user", 0, 0, Silver},
+		{"gold by kwh", 500, 0, Gold},
+		{"gold by points", 10, 5000, Gold},
+		{"platinum by kwh", 2500, 100, Platinum},
+		{"just below gold", 499.9, 4999, Silver},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Qualify(testConfig, tt.kwh, tt.points))
+		})
+	}
+
+	// Without a base tier users below every threshold have no tier
+	cfg := rules.TierConfig{Levels: map[string]rules.TierLevel{Gold: {MinKWH: 500, MinPoints: 5000}}}
+	assert.Equal(t, "", Qualify(cfg, 10, 10))
+}
+
+func TestDecide(t *testing.T) {
+	pending := sql.NullTime{Time: testNow.Add(10 * 24 * time.Hour), Valid: true}
+	lapsed := sql.NullTime{Time: testNow.Add(-time.Hour), Valid: true}
+	graceEnd := sql.NullTime{Time: testNow.Add(30 * 24 * time.Hour), Valid: true}
+
+	tests := []struct {
+		name        string
+		current     string
+		downgradeAt sql.NullTime
+		qualified   string
+		want        Decision
+	}{
+		{"first evaluation", "", sql.NullTime{}, Gold, Decision{From: "", Tier: Gold}},
+		{"unchanged", Gold, sql.NullTime{}, Gold, Decision{From: Gold, Tier: Gold}},
+		{"upgrade is immediate", Silver, sql.NullTime{}, Platinum, Decision{From: Silver, Tier: Platinum}},
+		{"requalifying cancels a pending downgrade", Gold, pending, Gold, Decision{From: Gold, Tier: Gold}},
+		{"drop starts the grace period", Platinum, sql.NullTime{}, Silver, Decision{From: Platinum, Tier: Platinum, DowngradeAt: graceEnd}},
+		{"within the grace period", Platinum, pending, Silver, Decision{From: Platinum, Tier: Platinum, DowngradeAt: pending}},
+		{"grace period over", Platinum, lapsed, Silver, Decision{From: Platinum, Tier: Silver}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Decide(testConfig, tt.current, tt.downgradeAt, tt.qualified, testNow))
+		})
+	}
+
+	// Without a grace period downgrades are immediate
+	cfg := testConfig
+	cfg.DowngradeGraceDays = 0
+	assert.Equal(t, Decision{From: Gold, Tier: Silver}, Decide(cfg, Gold, sql.NullTime{}, Silver, testNow))
+}
+
+func TestEligible(t *testing.T) {
+	assert.True(t, Eligible("", ""))
+	assert.True(t, Eligible(Gold, Gold))
+	assert.True(t, Eligible(Platinum, Gold))
+	assert.False(t, Eligible(Silver, Gold))
+	assert.False(t, Eligible("", Silver))
+}
+
+func TestWindowStart(t *testing.T) {
+	assert.Equal(t, testNow.AddDate(-1, 0, 0), WindowStart(rules.TierConfig{}, testNow))
+	assert.Equal(t, testNow.AddDate(0, 0, -90), WindowStart(rules.TierConfig{WindowDays: 90}, testNow))
+}
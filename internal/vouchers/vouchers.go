@@ -0,0 +1,276 @@
+// Package vouchers issues voucher codes with redemptions.
+//
+// A reward's code_source selects where codes come from: "pool" claims an
+// unused code uploaded by an admin (typically a partner's coupon codes) and
+// "generated" creates a unique code locally from the reward's code_format.
+// Codes are issued inside the redemption's transaction, so a failed
+// redemption returns its pool code. Codes of redemptions withdrawn later are
+// taken back with Withdraw.
+package vouchers
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	"encore.app/internal/db"
+	"github.com/google/uuid"
+)
+
+// Code sources
+const (
+	SourcePool      = "pool"
+	SourceGenerated = "generated"
+)
+
+// DefaultFormat is used by rewards generating codes without a code_format
+const DefaultFormat = "****-****-****"
+
+// Format placeholders; other characters, such as a partner prefix, are copied
+// to the code as they are
+const (
+	// alphanumeric is replaced by a letter or digit, leaving out the
+	// look-alikes 0, O, 1 and I
+	alphanumeric = '*'
+	letter       = '?'
+	digit        = '#'
+)
+
+const (
+	alphanumericChars = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	letterChars       = "ABCDEFGHJKLMNPQRSTUVWXYZ"
+	digitChars        = "0123456789"
+)
+
+// Code limits
+const (
+	// MaxCodeLength bounds uploaded and generated codes
+	MaxCodeLength = 64
+	// minRandomChars keeps generated codes hard to guess and collisions rare
+	minRandomChars = 6
+	// maxGenerateAttempts bounds retries after a generated code collides
+	maxGenerateAttempts = 5
+)
+
+// Errors returned by the package
+var (
+	ErrPoolEmpty       = errors.New("reward has no voucher codes left")
+	ErrInvalidSource   = errors.New("code_source must be pool or generated")
+	ErrInvalidFormat   = fmt.Errorf("code format needs at least %d of the placeholders *, ? and # and at most %d characters", minRandomChars, MaxCodeLength)
+	ErrCodeCollision   = errors.New("could not generate a unique voucher code")
+	ErrNoCodes         = errors.New("no codes found")
+	ErrInvalidCodeLine = errors.New("invalid code")
+)
+
+// Store is the subset of *db.Queries used to issue codes
+type Store interface {
+	ClaimRewardCode(ctx context.Context, arg db.ClaimRewardCodeParams) (db.RewardCode, error)
+	IssueRewardCode(ctx context.Context, arg db.IssueRewardCodeParams) (int64, error)
+	CountRewardCodes(ctx context.Context, rewardID uuid.UUID) (db.CountRewardCodesRow, error)
+	MarkRewardCodesLow(ctx context.Context, id uuid.UUID) (int64, error)
+}
+
+// Withdrawer is the subset of *db.Queries used to withdraw codes
+type Withdrawer interface {
+	ReleaseRewardCode(ctx context.Context, redemptionID uuid.NullUUID) (int64, error)
+	VoidRewardCode(ctx context.Context, redemptionID uuid.NullUUID) error
+}
+
+// Withdraw takes back the code issued to a redemption that will not be
+// fulfilled. A pool code the user never saw returns to the pool; any other
+// code is voided and never issued again.
+func Withdraw(ctx context.Context, q Withdrawer, redemptionID uuid.UUID, revealed bool) error {
+	id := uuid.NullUUID{UUID: redemptionID, Valid: true}
+	if !revealed {
+		released, err := q.ReleaseRewardCode(ctx, id)
+		if err != nil || released > 0 {
+			return err
+		}
+	}
+	return q.VoidRewardCode(ctx, id)
+}
+
+// Issued is a code issued with a redemption
+type Issued struct {
+	Code string
+	// Available is the number of pool codes left, 0 for generated codes
+	Available int32
+	// LowStock is set when this claim took the pool to its low-stock
+	// threshold and the alert has not fired since the last upload
+	LowStock bool
+}
+
+// ValidateSource checks a reward's code_source
+func ValidateSource(source string) error {
+	switch source {
+	case "", SourcePool, SourceGenerated:
+		return nil
+	}
+	return ErrInvalidSource
+}
+
+// ValidateFormat checks a code format has enough placeholders to generate
+// unique codes
+func ValidateFormat(format string) error {
+	if len(format) > MaxCodeLength {
+		return ErrInvalidFormat
+	}
+	random := 0
+	for _, c := range format {
+		switch c {
+		case alphanumeric, letter, digit:
+			random++
+		}
+	}
+	if random < minRandomChars {
+		return ErrInvalidFormat
+	}
+	return nil
+}
+
+// Generate returns a random code in format
+func Generate(format string) (string, error) {
+	if format == "" {
+		format = DefaultFormat
+	}
+	if err := ValidateFormat(format); err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, c := range format {
+		var chars string
+		switch c {
+		case alphanumeric:
+			chars = alphanumericChars
+		case letter:
+			chars = letterChars
+		case digit:
+			chars = digitChars
+		default:
+			b.WriteRune(c)
+			continue
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(chars[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// ParseCSV reads voucher codes from the first column of a CSV file. A header
+// row named "code" is skipped, blank rows are ignored and repeated codes are
+// returned once.
+func ParseCSV(r io.Reader) ([]string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var codes []string
+	seen := make(map[string]bool)
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		code := strings.TrimSpace(record[0])
+		if line == 1 {
+			// Spreadsheet exports may start with a byte order mark
+			code = strings.TrimPrefix(code, "\ufeff")
+			if strings.EqualFold(code, "code") {
+				continue
+			}
+		}
+		if code == "" || seen[code] {
+			continue
+		}
+		if len(code) > MaxCodeLength || strings.ContainsAny(code, " \t") {
+			return nil, fmt.Errorf("%w on line %d: %q", ErrInvalidCodeLine, line, code)
+		}
+		seen[code] = true
+		codes = append(codes, code)
+	}
+	if len(codes) == 0 {
+		return nil, ErrNoCodes
+	}
+	return codes, nil
+}
+
+// Issue issues a code for a redemption of reward. It returns an empty Issued
+// for rewards without a code_source and ErrPoolEmpty when a pool has no codes
+// left.
+func Issue(ctx context.Context, q Store, reward db.RewardsCatalog, redemptionID uuid.UUID) (Issued, error) {
+	switch reward.CodeSource.String {
+	case SourcePool:
+		return claim(ctx, q, reward, redemptionID)
+	case SourceGenerated:
+		return generate(ctx, q, reward, redemptionID)
+	}
+	return Issued{}, nil
+}
+
+// claim takes the oldest unused code of the reward's pool
+func claim(ctx context.Context, q Store, reward db.RewardsCatalog, redemptionID uuid.UUID) (Issued, error) {
+	code, err := q.ClaimRewardCode(ctx, db.ClaimRewardCodeParams{
+		RedemptionID: uuid.NullUUID{UUID: redemptionID, Valid: true},
+		RewardID:     reward.ID,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Issued{}, ErrPoolEmpty
+		}
+		return Issued{}, err
+	}
+	issued := Issued{Code: code.Code}
+
+	if !reward.CodeLowThreshold.Valid {
+		return issued, nil
+	}
+	counts, err := q.CountRewardCodes(ctx, reward.ID)
+	if err != nil {
+		return Issued{}, err
+	}
+	issued.Available = counts.Available
+	if counts.Available <= reward.CodeLowThreshold.Int32 {
+		// Only the first claim at or below the threshold raises the alert
+		marked, err := q.MarkRewardCodesLow(ctx, reward.ID)
+		if err != nil {
+			return Issued{}, err
+		}
+		issued.LowStock = marked > 0
+	}
+	return issued, nil
+}
+
+// generate creates a code in the reward's format, retrying if the reward
+// already issued it
+func generate(ctx context.Context, q Store, reward db.RewardsCatalog, redemptionID uuid.UUID) (Issued, error) {
+	for attempt := 0; attempt < maxGenerateAttempts; attempt++ {
+		code, err := Generate(reward.CodeFormat.String)
+		if err != nil {
+			return Issued{}, err
+		}
+		inserted, err := q.IssueRewardCode(ctx, db.IssueRewardCodeParams{
+			RewardID:     reward.ID,
+			Code:         code,
+			RedemptionID: uuid.NullUUID{UUID: redemptionID, Valid: true},
+		})
+		if err != nil {
+			return Issued{}, err
+		}
+		if inserted > 0 {
+			return Issued{Code: code}, nil
+		}
+	}
+	return Issued{}, ErrCodeCollision
+}
@@ -0,0 +1,177 @@
+package vouchers
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"testing"
+
+	"encore.app/internal/db"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStore keeps one reward's codes in upload order
+type fakeStore struct {
+	codes    []db.RewardCode
+	alerted  bool
+	collides int
+}
+
+func (f *fakeStore) ClaimRewardCode(ctx context.Context, arg db.ClaimRewardCodeParams) (db.RewardCode, error) {
+	for i := range f.codes {
+		if !f.codes[i].RedemptionID.Valid {
+			f.codes[i].RedemptionID = arg.RedemptionID
+			return f.codes[i], nil
+		}
+	}
+	return db.RewardCode{}, sql.ErrNoRows
+}
+
+func (f *fakeStore) IssueRewardCode(ctx context.Context, arg db.IssueRewardCodeParams) (int64, error) {
+	if f.collides > 0 {
+		f.collides--
+		return 0, nil
+	}
+	f.codes = append(f.codes, db.RewardCode{RewardID: arg.RewardID, Code: arg.Code, RedemptionID: arg.RedemptionID})
+	return 1, nil
+}
+
+func (f *fakeStore) CountRewardCodes(ctx context.Context, rewardID uuid.UUID) (db.CountRewardCodesRow, error) {
+	var row db.CountRewardCodesRow
+	for _, c := range f.codes {
+		row.Total++
+		if !c.RedemptionID.Valid {
+			row.Available++
+		}
+	}
+	return row, nil
+}
+
+func (f *fakeStore) MarkRewardCodesLow(ctx context.Context, id uuid.UUID) (int64, error) {
+	if f.alerted {
+		return 0, nil
+	}
+	f.alerted = true
+	return 1, nil
+}
+
+func poolReward(threshold int32) db.RewardsCatalog {
+	r := db.RewardsCatalog{
+		ID:         uuid.New(),
+		Name:       "Partner coupon",
+		Cost:       100,
+		Active:     true,
+		CodeSource: sql.NullString{String: SourcePool, Valid: true},
+	}
+	if threshold >= 0 {
+		r.CodeLowThreshold = sql.NullInt32{Int32: threshold, Valid: true}
+	}
+	return r
+}
+
+func TestIssue_Pool(t *testing.T) {
+	q := &fakeStore{}
+	for _, code := range []string{"AAA111", "BBB222", "CCC333"} {
+		q.codes = append(q.codes, db.RewardCode{Code: code})
+	}
+	reward := poolReward(1)
+
+	issued, err := Issue(context.Background(), q, reward, uuid.New())
+	require.NoError(t, err)
+	assert.Equal(t, "AAA111", issued.Code)
+	assert.Equal(t, int32(2), issued.Available)
+	assert.False(t, issued.LowStock)
+
+	// Reaching the threshold raises the alert once
+	issued, err = Issue(context.Background(), q, reward, uuid.New())
+	require.NoError(t, err)
+	assert.Equal(t, "BBB222", issued.Code)
+	assert.True(t, issued.LowStock)
+
+	issued, err = Issue(context.Background(), q, reward, uuid.New())
+	require.NoError(t, err)
+	assert.Equal(t, "CCC333", issued.Code)
+	assert.False(t, issued.LowStock)
+
+	_, err = Issue(context.Background(), q, reward, uuid.New())
+	assert.ErrorIs(t, err, ErrPoolEmpty)
+}
+
+func TestIssue_Generated(t *testing.T) {
+	reward := db.RewardsCatalog{
+		ID:         uuid.New(),
+		CodeSource: sql.NullString{String: SourceGenerated, Valid: true},
+		CodeFormat: sql.NullString{String: "URJA-#####-??", Valid: true},
+	}
+
+	// A collision is retried with a new code
+	q := &fakeStore{collides: 1}
+	issued, err := Issue(context.Background(), q, reward, uuid.New())
+	require.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^URJA-[0-9]{5}-[A-Z]{2}$`), issued.Code)
+
+	q = &fakeStore{collides: maxGenerateAttempts}
+	_, err = Issue(context.Background(), q, reward, uuid.New())
+	assert.ErrorIs(t, err, ErrCodeCollision)
+
+	// Rewards without a code source issue nothing
+	issued, err = Issue(context.Background(), &fakeStore{}, db.RewardsCatalog{ID: uuid.New()}, uuid.New())
+	require.NoError(t, err)
+	assert.Empty(t, issued.Code)
+}
+
+func TestGenerate(t *testing.T) {
+	code, err := Generate("")
+	require.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[A-HJ-NP-Z2-9]{4}-[A-HJ-NP-Z2-9]{4}-[A-HJ-NP-Z2-9]{4}$`), code)
+
+	_, err = Generate("FREE-**")
+	assert.ErrorIs(t, err, ErrInvalidFormat)
+	_, err = Generate(strings.Repeat("X", MaxCodeLength+1))
+	assert.ErrorIs(t, err, ErrInvalidFormat)
+}
+
+func TestParseCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr error
+	}{
+		{
+			name:  "header and extra columns",
+			input: "code,partner\nAB12CD,acme\nEF34GH,acme\n",
+			want:  []string{"AB12CD", "EF34GH"},
+		},
+		{
+			name:  "no header, blanks and duplicates",
+			input: "\ufeffAB12CD\n\n  EF34GH \nAB12CD\n",
+			want:  []string{"AB12CD", "EF34GH"},
+		},
+		{
+			name:    "code with spaces",
+			input:   "code\nAB 12\n",
+			wantErr: ErrInvalidCodeLine,
+		},
+		{
+			name:    "only a header",
+			input:   "code\n",
+			wantErr: ErrNoCodes,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codes, err := ParseCSV(strings.NewReader(tt.input))
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, codes)
+		})
+	}
+}
@@ -1,13 +1,22 @@
+//go:build encore
+// +build encore
+
 package accrual
 
 import (
+	"context"
+	"database/sql"
+
 	"encore.app/internal/db"
+	"encore.app/internal/outbox"
 	"encore.app/internal/rules"
 	"encore.dev/pubsub"
+	"encore.dev/storage/sqldb"
 )
 
 //encore:service
 type Service struct {
+	conn   *sql.DB
 	db     *db.Queries
 	engine *rules.Engine
 }
@@ -40,11 +49,23 @@ var UserPointsUpdatedTopic = pubsub.NewTopic[*UserPointsUpdated]("user-points-up
 	DeliveryGuarantee: pubsub.AtLeastOnce,
 })
 
+// rewardsDB is the shared rewards database
+var rewardsDB = sqldb.Named("rewards")
+
 // init initializes the accrual service
 func init() {
 	// Service will be initialized by Encore
 }
 
+// initService connects the service to the database and starts the outbox
+// relay that publishes UserPointsUpdated events
+func initService() (*Service, error) {
+	conn := rewardsDB.Stdlib()
+	relay := newPointsRelay(conn, outbox.DefaultMetrics)
+	go relay.Run(context.Background())
+	return &Service{conn: conn, db: db.New(conn)}, nil
+}
+
 // isTestMode checks if we're running in test mode
 func isTestMode() bool {
 	// Simple check - in a real implementation, you might use build tags or environment variables
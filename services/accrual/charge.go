@@ -3,16 +3,27 @@ package accrual
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 
+	"encore.app/internal/auth"
 	"encore.app/internal/db"
+	"encore.app/internal/outbox"
 	"encore.app/internal/rules"
 
 	"github.com/google/uuid"
 	"github.com/sqlc-dev/pqtype"
 )
 
-//encore:api public method=POST path=/v1/events/charge
+// Charge records a charging session reported by the charge-point backend.
+// It must be called with a service API key; user tokens are rejected.
+//
+//encore:api auth method=POST path=/v1/events/charge
 func (s *Service) Charge(ctx context.Context, event *ChargeEvent) (*ChargeResponse, error) {
+	if _, err := auth.RequireService(auth.CurrentPrincipal()); err != nil {
+		return nil, err
+	}
+
 	// Initialize rules engine
 	engine, err := rules.NewEngine("rules.yaml")
 	if err != nil {
@@ -26,6 +37,12 @@ func (s *Service) Charge(ctx context.Context, event *ChargeEvent) (*ChargeRespon
 		return nil, err
 	}
 
+	// The user's loyalty tier scales the points they earn
+	tier, err := s.userTier(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
 	var points int32
 
 	// Use rules engine to calculate points if available
@@ -36,6 +53,7 @@ func (s *Service) Charge(ctx context.Context, event *ChargeEvent) (*ChargeRespon
 			Data: map[string]interface{}{
 				"kwh": event.KWH,
 			},
+			Tier: tier,
 		}
 
 		calculatedPoints, err := engine.EvaluateRules(ctx, payload)
@@ -48,30 +66,41 @@ func (s *Service) Charge(ctx context.Context, event *ChargeEvent) (*ChargeRespon
 		points = int32(event.KWH * 10)
 	}
 
-	// Create points event
-	pointsEvent, err := s.db.CreatePointsEvent(ctx, db.CreatePointsEventParams{
-		UserID:    userID,
-		EventType: "CHARGE_KWH",
-		RefID:     sql.NullString{String: event.SessionID, Valid: true},
-		Points:    points,
-		Meta:      pqtype.NullRawMessage{},
-	})
+	// Keep the charged energy on the ledger entry; segments and tiers use kWh
+	metaFields := map[string]interface{}{"kwh": event.KWH}
+	if tier != "" {
+		metaFields["tier"] = tier
+	}
+	meta, err := json.Marshal(metaFields)
 	if err != nil {
 		return nil, err
 	}
 
-	// Publish UserPointsUpdated event
-	_, err = UserPointsUpdatedTopic.Publish(ctx, &UserPointsUpdated{
-		UserID:    event.UserID,
-		EventID:   pointsEvent.ID.String(),
-		Points:    points,
-		EventType: "CHARGE_KWH",
-		SessionID: event.SessionID,
+	// Record the points event and its UserPointsUpdated notification in one
+	// transaction; the outbox relay publishes the notification after commit
+	var pointsEvent db.PointsEvent
+	err = db.WithTx(ctx, s.conn, func(q *db.Queries) error {
+		var err error
+		pointsEvent, err = q.CreatePointsEvent(ctx, db.CreatePointsEventParams{
+			UserID:    userID,
+			EventType: "CHARGE_KWH",
+			RefID:     sql.NullString{String: event.SessionID, Valid: true},
+			Points:    points,
+			Meta:      pqtype.NullRawMessage{RawMessage: meta, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+		return outbox.Enqueue(ctx, q, pointsOutboxTopic, userID.String(), &UserPointsUpdated{
+			UserID:    event.UserID,
+			EventID:   pointsEvent.ID.String(),
+			Points:    points,
+			EventType: "CHARGE_KWH",
+			SessionID: event.SessionID,
+		})
 	})
 	if err != nil {
-		// Log error but don't fail the request
-		// In production, you might want to handle this differently
-		// For now, we'll just return the response even if publishing fails
+		return nil, err
 	}
 
 	return &ChargeResponse{
@@ -80,3 +109,15 @@ func (s *Service) Charge(ctx context.Context, event *ChargeEvent) (*ChargeRespon
 		SessionID: event.SessionID,
 	}, nil
 }
+
+// userTier returns the user's loyalty tier, or "" before their first evaluation
+func (s *Service) userTier(ctx context.Context, userID uuid.UUID) (string, error) {
+	t, err := s.db.GetUserTier(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+	return t.Tier, nil
+}
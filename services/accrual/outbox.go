@@ -0,0 +1,19 @@
+package accrual
+
+import (
+	"database/sql"
+
+	"encore.app/internal/outbox"
+)
+
+// pointsOutboxTopic is the outbox topic of UserPointsUpdated events
+const pointsOutboxTopic = "user-points-updated"
+
+// newPointsRelay creates the relay publishing outboxed UserPointsUpdated
+// events to UserPointsUpdatedTopic
+func newPointsRelay(conn *sql.DB, metrics outbox.Metrics) *outbox.Relay {
+	return outbox.NewRelay(outbox.Config{
+		Topic:   pointsOutboxTopic,
+		Publish: outbox.JSONPublisher[UserPointsUpdated](UserPointsUpdatedTopic),
+	}, outbox.DBTx(conn), metrics)
+}
@@ -17,6 +17,77 @@ const (
 	BearerAuthScopes = "BearerAuth.Scopes"
 )
 
+// Defines values for CodeSource.
+const (
+	Generated CodeSource = "generated"
+	Pool      CodeSource = "pool"
+)
+
+// Defines values for FulfillmentAdapter.
+const (
+	Webhook FulfillmentAdapter = "webhook"
+)
+
+// Defines values for PriceRuleType.
+const (
+	Sale         PriceRuleType = "sale"
+	SegmentPrice PriceRuleType = "segment_price"
+	TierDiscount PriceRuleType = "tier_discount"
+)
+
+// Defines values for RewardType.
+const (
+	ChargingCredit RewardType = "charging_credit"
+	Standard       RewardType = "standard"
+)
+
+// Defines values for SegmentPreviewSource.
+const (
+	Live         SegmentPreviewSource = "live"
+	Materialized SegmentPreviewSource = "materialized"
+)
+
+// Defines values for Tier.
+const (
+	Gold     Tier = "gold"
+	Platinum Tier = "platinum"
+	Silver   Tier = "silver"
+)
+
+// Defines values for Weekday.
+const (
+	Fri Weekday = "fri"
+	Mon Weekday = "mon"
+	Sat Weekday = "sat"
+	Sun Weekday = "sun"
+	Thu Weekday = "thu"
+	Tue Weekday = "tue"
+	Wed Weekday = "wed"
+)
+
+// AuditLogEntry defines model for AuditLogEntry.
+type AuditLogEntry struct {
+	Action     *string                 `json:"action,omitempty"`
+	ActorEmail *string                 `json:"actor_email,omitempty"`
+	ActorId    *openapi_types.UUID     `json:"actor_id,omitempty"`
+	After      *map[string]interface{} `json:"after"`
+	Before     *map[string]interface{} `json:"before"`
+	CreatedAt  *time.Time              `json:"created_at,omitempty"`
+
+	// Diff Changed fields, each with its before and after value
+	Diff     *map[string]interface{} `json:"diff,omitempty"`
+	EntityId *openapi_types.UUID     `json:"entity_id,omitempty"`
+
+	// EntityType One of rule, reward, segment or adjustment
+	EntityType *string             `json:"entity_type,omitempty"`
+	Id         *openapi_types.UUID `json:"id,omitempty"`
+	Ip         *string             `json:"ip,omitempty"`
+	RequestId  *string             `json:"request_id,omitempty"`
+}
+
+// CodeSource Where redemptions get their voucher code: pool claims an uploaded code, generated creates a unique code from code_format. Omit for rewards without codes.
+type CodeSource string
+
 // Error defines model for Error.
 type Error struct {
 	Code    *string                 `json:"code,omitempty"`
@@ -24,18 +95,166 @@ type Error struct {
 	Message *string                 `json:"message,omitempty"`
 }
 
+// FulfillmentAdapter How redemptions are delivered to the partner fulfilling them: webhook posts each RedemptionCreated event, signed with fulfillment_secret, to fulfillment_url. Omit for rewards fulfilled outside the platform.
+type FulfillmentAdapter string
+
+// PointsAdjustment defines model for PointsAdjustment.
+type PointsAdjustment struct {
+	CreatedAt *time.Time          `json:"created_at,omitempty"`
+	Id        *openapi_types.UUID `json:"id,omitempty"`
+	Points    *int                `json:"points,omitempty"`
+	Reason    *string             `json:"reason,omitempty"`
+	UserId    *openapi_types.UUID `json:"user_id,omitempty"`
+}
+
+// PriceRule defines model for PriceRule.
+type PriceRule struct {
+	// Cost Price the rule sets; set this or percent_off
+	Cost *int `json:"cost,omitempty"`
+
+	// EndsAt When the rule stops applying; required for sales
+	EndsAt *time.Time `json:"ends_at,omitempty"`
+
+	// PercentOff Discount off the reward's cost; set this or cost
+	PercentOff *int `json:"percent_off,omitempty"`
+
+	// Segment Audience of a segment_price, in the same format as the reward's segment
+	Segment *map[string]interface{} `json:"segment,omitempty"`
+
+	// StartsAt When the rule starts applying; omit for immediately
+	StartsAt *time.Time `json:"starts_at,omitempty"`
+
+	// Tier Loyalty tier; rewards with a min_tier are only offered to that tier and above
+	Tier *Tier `json:"tier,omitempty"`
+
+	// Type Who a price rule applies to: tier_discount to users in tier and above, segment_price to users in segment, sale to everyone until ends_at.
+	Type PriceRuleType `json:"type"`
+}
+
+// PriceRuleType Who a price rule applies to: tier_discount to users in tier and above, segment_price to users in segment, sale to everyone until ends_at.
+type PriceRuleType string
+
 // Reward defines model for Reward.
 type Reward struct {
-	Active      *bool                   `json:"active,omitempty"`
-	Cost        *int                    `json:"cost,omitempty"`
-	CreatedAt   *time.Time              `json:"created_at,omitempty"`
-	CreatedBy   *openapi_types.UUID     `json:"created_by"`
-	Description *string                 `json:"description,omitempty"`
-	Id          *openapi_types.UUID     `json:"id,omitempty"`
-	Name        *string                 `json:"name,omitempty"`
-	Segment     *map[string]interface{} `json:"segment"`
+	// Active Set to false automatically when the last unit of stock is redeemed
+	Active *bool `json:"active,omitempty"`
+
+	// AvailableDays UTC weekdays the reward is available on; null for every day
+	AvailableDays *[]Weekday `json:"available_days"`
+
+	// AvailableFrom When the reward becomes available; null for immediately
+	AvailableFrom *time.Time `json:"available_from"`
+
+	// AvailableNow Whether the reward is active and inside its availability window now
+	AvailableNow *bool `json:"available_now,omitempty"`
+
+	// AvailableUntil When the reward stops being available; null for never
+	AvailableUntil *time.Time `json:"available_until"`
+
+	// CashPrice Cash top-up in paise when paying with points and cash; null for no cash option
+	CashPrice *int64 `json:"cash_price"`
+
+	// Category Lowercase slug the catalog is filtered by, e.g. charging; null for none
+	Category *string `json:"category"`
+
+	// CodeFormat Format of generated codes; null for the default ****-****-****
+	CodeFormat *string `json:"code_format"`
+
+	// CodeLowThreshold Raise a low-stock alert when this many pool codes are left; null for no alert
+	CodeLowThreshold *int `json:"code_low_threshold"`
+
+	// CodeSource Where redemptions get their voucher code: pool claims an uploaded code, generated creates a unique code from code_format. Omit for rewards without codes.
+	CodeSource *CodeSource `json:"code_source,omitempty"`
+
+	// CooldownSeconds Minimum seconds between a user's redemptions; null for none
+	CooldownSeconds *int                `json:"cooldown_seconds"`
+	Cost            *int                `json:"cost,omitempty"`
+	CreatedAt       *time.Time          `json:"created_at,omitempty"`
+	CreatedBy       *openapi_types.UUID `json:"created_by"`
+
+	// CreditAmount Charging credit issued per redemption, in paise; set for charging_credit rewards
+	CreditAmount *int64 `json:"credit_amount"`
+
+	// CreditValidDays Days a charging credit stays usable; null for the default of 30
+	CreditValidDays *int `json:"credit_valid_days"`
+
+	// DailyStock Units available per UTC day; null for unlimited
+	DailyStock *int `json:"daily_stock"`
+
+	// DailyStockRemaining Units left today; null for unlimited
+	DailyStockRemaining *int    `json:"daily_stock_remaining"`
+	Description         *string `json:"description,omitempty"`
+
+	// Featured Featured rewards are listed first in the catalog and highlighted in the app
+	Featured *bool `json:"featured,omitempty"`
+
+	// FulfillmentAdapter How redemptions are delivered to the partner fulfilling them: webhook posts each RedemptionCreated event, signed with fulfillment_secret, to fulfillment_url. Omit for rewards fulfilled outside the platform.
+	FulfillmentAdapter *FulfillmentAdapter `json:"fulfillment_adapter,omitempty"`
+
+	// FulfillmentUrl Partner endpoint receiving webhook deliveries
+	FulfillmentUrl *string             `json:"fulfillment_url"`
+	Id             *openapi_types.UUID `json:"id,omitempty"`
+
+	// ImageUrl Image shown in the catalog; http or https
+	ImageUrl *string `json:"image_url"`
+
+	// MaxPerUser Redemptions allowed per user in total; null for unlimited
+	MaxPerUser *int `json:"max_per_user"`
+
+	// MaxPerUserMonthly Redemptions allowed per user per UTC calendar month; null for unlimited
+	MaxPerUserMonthly *int `json:"max_per_user_monthly"`
+
+	// MinPoints Points spent when paying with points and cash; null for no cash option
+	MinPoints *int `json:"min_points"`
+
+	// MinTier Loyalty tier; rewards with a min_tier are only offered to that tier and above
+	MinTier *Tier   `json:"min_tier,omitempty"`
+	Name    *string `json:"name,omitempty"`
+
+	// PartnerLogoUrl Logo of the partner providing the reward; http or https
+	PartnerLogoUrl *string `json:"partner_logo_url"`
+
+	// PartnerName Partner providing the reward; null for none
+	PartnerName *string `json:"partner_name"`
+
+	// PartnerUrl Partner website; http or https
+	PartnerUrl *string `json:"partner_url"`
+
+	// PendingTtlSeconds Seconds a redemption may stay pending before it expires; null for the default of 24 hours
+	PendingTtlSeconds *int `json:"pending_ttl_seconds"`
+
+	// PriceRules Rules lowering cost for some users; each user pays the lowest price that applies to them. Null for none.
+	PriceRules *[]PriceRule `json:"price_rules"`
+
+	// RewardType What redeeming the reward gives: standard rewards are fulfilled outside the platform, charging_credit rewards issue a credit the charge-point backend applies to the user's next sessions.
+	RewardType *RewardType             `json:"reward_type,omitempty"`
+	Segment    *map[string]interface{} `json:"segment"`
+
+	// SortOrder Position in the catalog, lowest first after featured rewards; 0 by default
+	SortOrder *int `json:"sort_order,omitempty"`
+
+	// StockRedeemed Units claimed by redemptions
+	StockRedeemed *int `json:"stock_redeemed,omitempty"`
+
+	// StockRemaining Units left; null for unlimited
+	StockRemaining *int `json:"stock_remaining"`
+
+	// StockTotal Units available in total; null for unlimited
+	StockTotal *int `json:"stock_total"`
+
+	// Tags Lowercase slugs the catalog is filtered and searched by, at most 20; null for none
+	Tags *[]string `json:"tags"`
+
+	// Terms Terms & conditions shown before redeeming
+	Terms *string `json:"terms"`
+
+	// Version Incremented on every change
+	Version *int `json:"version,omitempty"`
 }
 
+// RewardType What redeeming the reward gives: standard rewards are fulfilled outside the platform, charging_credit rewards issue a credit the charge-point backend applies to the user's next sessions.
+type RewardType string
+
 // Rule defines model for Rule.
 type Rule struct {
 	Active      *bool                   `json:"active,omitempty"`
@@ -45,6 +264,9 @@ type Rule struct {
 	Id          *openapi_types.UUID     `json:"id,omitempty"`
 	Name        *string                 `json:"name,omitempty"`
 	UpdatedAt   *time.Time              `json:"updated_at,omitempty"`
+
+	// Version Incremented on every change
+	Version *int `json:"version,omitempty"`
 }
 
 // Segment defines model for Segment.
@@ -54,7 +276,91 @@ type Segment struct {
 	Criteria    *map[string]interface{} `json:"criteria,omitempty"`
 	Description *string                 `json:"description,omitempty"`
 	Id          *openapi_types.UUID     `json:"id,omitempty"`
-	Name        *string                 `json:"name,omitempty"`
+
+	// Materialized Keep the segment's members in segment_members
+	Materialized *bool `json:"materialized,omitempty"`
+
+	// MaterializedAt Last full refresh of the segment's members
+	MaterializedAt *time.Time `json:"materialized_at"`
+	Name           *string    `json:"name,omitempty"`
+
+	// Version Incremented on every change
+	Version *int `json:"version,omitempty"`
+}
+
+// SegmentMember defines model for SegmentMember.
+type SegmentMember struct {
+	Balance     *int                `json:"balance,omitempty"`
+	LifetimeKwh *float32            `json:"lifetime_kwh,omitempty"`
+	SignupDate  *time.Time          `json:"signup_date,omitempty"`
+	UserId      *openapi_types.UUID `json:"user_id,omitempty"`
+}
+
+// SegmentPreview defines model for SegmentPreview.
+type SegmentPreview struct {
+	// AsOf When the members were evaluated
+	AsOf *time.Time `json:"as_of,omitempty"`
+
+	// Count Number of users matching the segment
+	Count     *int                `json:"count,omitempty"`
+	Sample    *[]SegmentMember    `json:"sample,omitempty"`
+	SegmentId *openapi_types.UUID `json:"segment_id,omitempty"`
+
+	// Source live when criteria were evaluated against all users, materialized when read from segment_members
+	Source *SegmentPreviewSource `json:"source,omitempty"`
+}
+
+// SegmentPreviewSource live when criteria were evaluated against all users, materialized when read from segment_members
+type SegmentPreviewSource string
+
+// Tier Loyalty tier; rewards with a min_tier are only offered to that tier and above
+type Tier string
+
+// VoucherCodePool defines model for VoucherCodePool.
+type VoucherCodePool struct {
+	// Added Codes added by the upload; only set on upload
+	Added *int `json:"added,omitempty"`
+
+	// Available Codes not yet issued
+	Available *int `json:"available,omitempty"`
+
+	// Issued Codes issued with redemptions
+	Issued *int `json:"issued,omitempty"`
+
+	// LowThreshold Available count that raises the low-stock alert
+	LowThreshold *int                `json:"low_threshold"`
+	RewardId     *openapi_types.UUID `json:"reward_id,omitempty"`
+
+	// Skipped Uploaded codes already in the pool; only set on upload
+	Skipped *int `json:"skipped,omitempty"`
+
+	// Total Codes uploaded or generated
+	Total *int `json:"total,omitempty"`
+}
+
+// Weekday Day of the week, in UTC
+type Weekday string
+
+// PostAdjustmentsJSONBody defines parameters for PostAdjustments.
+type PostAdjustmentsJSONBody struct {
+	// Points Points to credit (positive) or debit (negative)
+	Points int                `json:"points"`
+	Reason string             `json:"reason"`
+	UserId openapi_types.UUID `json:"user_id"`
+}
+
+// GetAuditLogParams defines parameters for GetAuditLog.
+type GetAuditLogParams struct {
+	ActorId *openapi_types.UUID `form:"actor_id,omitempty" json:"actor_id,omitempty"`
+
+	// EntityType One of rule, reward, segment or adjustment
+	EntityType *string             `form:"entity_type,omitempty" json:"entity_type,omitempty"`
+	EntityId   *openapi_types.UUID `form:"entity_id,omitempty" json:"entity_id,omitempty"`
+	Action     *string             `form:"action,omitempty" json:"action,omitempty"`
+	Since      *time.Time          `form:"since,omitempty" json:"since,omitempty"`
+	Until      *time.Time          `form:"until,omitempty" json:"until,omitempty"`
+	Limit      *int                `form:"limit,omitempty" json:"limit,omitempty"`
+	Offset     *int                `form:"offset,omitempty" json:"offset,omitempty"`
 }
 
 // GetRewardsParams defines parameters for GetRewards.
@@ -65,20 +371,206 @@ type GetRewardsParams struct {
 
 // PostRewardsJSONBody defines parameters for PostRewards.
 type PostRewardsJSONBody struct {
-	Active      *bool                   `json:"active,omitempty"`
-	Cost        int                     `json:"cost"`
-	Description *string                 `json:"description,omitempty"`
-	Name        string                  `json:"name"`
-	Segment     *map[string]interface{} `json:"segment,omitempty"`
+	Active *bool `json:"active,omitempty"`
+
+	// AvailableDays UTC weekdays the reward is available on; omit or null for every day
+	AvailableDays *[]Weekday `json:"available_days"`
+
+	// AvailableFrom When the reward becomes available; omit or null for immediately
+	AvailableFrom *time.Time `json:"available_from"`
+
+	// AvailableUntil When the reward stops being available, after available_from; omit or null for never
+	AvailableUntil *time.Time `json:"available_until"`
+
+	// CashPrice Cash top-up in paise when paying with points and cash; set together with min_points
+	CashPrice *int64 `json:"cash_price"`
+
+	// Category Lowercase slug the catalog is filtered by, e.g. charging; omit or null for none
+	Category *string `json:"category"`
+
+	// CodeFormat Format of generated codes; * is a letter or digit, ? a letter, # a digit
+	CodeFormat *string `json:"code_format"`
+
+	// CodeLowThreshold Raise a low-stock alert when this many pool codes are left
+	CodeLowThreshold *int `json:"code_low_threshold"`
+
+	// CodeSource Where redemptions get their voucher code: pool claims an uploaded code, generated creates a unique code from code_format. Omit for rewards without codes.
+	CodeSource *CodeSource `json:"code_source,omitempty"`
+
+	// CooldownSeconds Minimum seconds between a user's redemptions; omit or null for none
+	CooldownSeconds *int `json:"cooldown_seconds"`
+	Cost            int  `json:"cost"`
+
+	// CreditAmount Charging credit issued per redemption, in paise; required for charging_credit rewards
+	CreditAmount *int64 `json:"credit_amount"`
+
+	// CreditValidDays Days a charging credit stays usable; omit or null for 30
+	CreditValidDays *int `json:"credit_valid_days"`
+
+	// DailyStock Units available per UTC day; omit or null for unlimited
+	DailyStock  *int    `json:"daily_stock"`
+	Description *string `json:"description,omitempty"`
+
+	// Featured Featured rewards are listed first in the catalog and highlighted in the app
+	Featured *bool `json:"featured,omitempty"`
+
+	// FulfillmentAdapter How redemptions are delivered to the partner fulfilling them: webhook posts each RedemptionCreated event, signed with fulfillment_secret, to fulfillment_url. Omit for rewards fulfilled outside the platform.
+	FulfillmentAdapter *FulfillmentAdapter `json:"fulfillment_adapter,omitempty"`
+
+	// FulfillmentSecret Key signing webhook deliveries, at least 16 characters; never returned. On update, omit it to keep the current key.
+	FulfillmentSecret *string `json:"fulfillment_secret"`
+
+	// FulfillmentUrl Partner endpoint receiving webhook deliveries; http or https
+	FulfillmentUrl *string `json:"fulfillment_url"`
+
+	// ImageUrl Image shown in the catalog; http or https
+	ImageUrl *string `json:"image_url"`
+
+	// MaxPerUser Redemptions allowed per user in total; omit or null for unlimited
+	MaxPerUser *int `json:"max_per_user"`
+
+	// MaxPerUserMonthly Redemptions allowed per user per UTC calendar month; omit or null for unlimited
+	MaxPerUserMonthly *int `json:"max_per_user_monthly"`
+
+	// MinPoints Points spent when paying with points and cash, less than cost; set together with cash_price, or omit both for no cash option
+	MinPoints *int `json:"min_points"`
+
+	// MinTier Loyalty tier; rewards with a min_tier are only offered to that tier and above
+	MinTier *Tier  `json:"min_tier,omitempty"`
+	Name    string `json:"name"`
+
+	// PartnerLogoUrl Logo of the partner providing the reward; http or https
+	PartnerLogoUrl *string `json:"partner_logo_url"`
+
+	// PartnerName Partner providing the reward; omit or null for none
+	PartnerName *string `json:"partner_name"`
+
+	// PartnerUrl Partner website; http or https
+	PartnerUrl *string `json:"partner_url"`
+
+	// PendingTtlSeconds Seconds a redemption may stay pending before it expires; omit or null for 24 hours
+	PendingTtlSeconds *int `json:"pending_ttl_seconds"`
+
+	// PriceRules Rules lowering cost for some users; each user pays the lowest price that applies to them. Omit or null for none.
+	PriceRules *[]PriceRule `json:"price_rules"`
+
+	// RewardType What redeeming the reward gives: standard rewards are fulfilled outside the platform, charging_credit rewards issue a credit the charge-point backend applies to the user's next sessions.
+	RewardType *RewardType             `json:"reward_type,omitempty"`
+	Segment    *map[string]interface{} `json:"segment,omitempty"`
+
+	// SortOrder Position in the catalog, lowest first after featured rewards; 0 by default
+	SortOrder *int `json:"sort_order,omitempty"`
+
+	// StockTotal Units available in total; omit or null for unlimited
+	StockTotal *int `json:"stock_total"`
+
+	// Tags Lowercase slugs the catalog is filtered and searched by, at most 20; omit or null for none
+	Tags *[]string `json:"tags"`
+
+	// Terms Terms & conditions shown before redeeming
+	Terms *string `json:"terms"`
 }
 
 // PutRewardsRewardIdJSONBody defines parameters for PutRewardsRewardId.
 type PutRewardsRewardIdJSONBody struct {
-	Active      *bool                   `json:"active,omitempty"`
-	Cost        *int                    `json:"cost,omitempty"`
-	Description *string                 `json:"description,omitempty"`
-	Name        *string                 `json:"name,omitempty"`
-	Segment     *map[string]interface{} `json:"segment,omitempty"`
+	Active *bool `json:"active,omitempty"`
+
+	// AvailableDays UTC weekdays the reward is available on; omit or null for every day
+	AvailableDays *[]Weekday `json:"available_days"`
+
+	// AvailableFrom When the reward becomes available; omit or null for immediately
+	AvailableFrom *time.Time `json:"available_from"`
+
+	// AvailableUntil When the reward stops being available, after available_from; omit or null for never
+	AvailableUntil *time.Time `json:"available_until"`
+
+	// CashPrice Cash top-up in paise when paying with points and cash; set together with min_points
+	CashPrice *int64 `json:"cash_price"`
+
+	// Category Lowercase slug the catalog is filtered by, e.g. charging; omit or null for none
+	Category *string `json:"category"`
+
+	// CodeFormat Format of generated codes; * is a letter or digit, ? a letter, # a digit
+	CodeFormat *string `json:"code_format"`
+
+	// CodeLowThreshold Raise a low-stock alert when this many pool codes are left
+	CodeLowThreshold *int `json:"code_low_threshold"`
+
+	// CodeSource Where redemptions get their voucher code: pool claims an uploaded code, generated creates a unique code from code_format. Omit for rewards without codes.
+	CodeSource *CodeSource `json:"code_source,omitempty"`
+
+	// CooldownSeconds Minimum seconds between a user's redemptions; omit or null for none
+	CooldownSeconds *int `json:"cooldown_seconds"`
+	Cost            *int `json:"cost,omitempty"`
+
+	// CreditAmount Charging credit issued per redemption, in paise; required for charging_credit rewards
+	CreditAmount *int64 `json:"credit_amount"`
+
+	// CreditValidDays Days a charging credit stays usable; omit or null for 30
+	CreditValidDays *int `json:"credit_valid_days"`
+
+	// DailyStock Units available per UTC day; omit or null for unlimited
+	DailyStock  *int    `json:"daily_stock"`
+	Description *string `json:"description,omitempty"`
+
+	// Featured Featured rewards are listed first in the catalog and highlighted in the app
+	Featured *bool `json:"featured,omitempty"`
+
+	// FulfillmentAdapter How redemptions are delivered to the partner fulfilling them: webhook posts each RedemptionCreated event, signed with fulfillment_secret, to fulfillment_url. Omit for rewards fulfilled outside the platform.
+	FulfillmentAdapter *FulfillmentAdapter `json:"fulfillment_adapter,omitempty"`
+
+	// FulfillmentSecret Key signing webhook deliveries, at least 16 characters; never returned. On update, omit it to keep the current key.
+	FulfillmentSecret *string `json:"fulfillment_secret"`
+
+	// FulfillmentUrl Partner endpoint receiving webhook deliveries; http or https
+	FulfillmentUrl *string `json:"fulfillment_url"`
+
+	// ImageUrl Image shown in the catalog; http or https
+	ImageUrl *string `json:"image_url"`
+
+	// MaxPerUser Redemptions allowed per user in total; omit or null for unlimited
+	MaxPerUser *int `json:"max_per_user"`
+
+	// MaxPerUserMonthly Redemptions allowed per user per UTC calendar month; omit or null for unlimited
+	MaxPerUserMonthly *int `json:"max_per_user_monthly"`
+
+	// MinPoints Points spent when paying with points and cash, less than cost; set together with cash_price, or omit both for no cash option
+	MinPoints *int `json:"min_points"`
+
+	// MinTier Loyalty tier; rewards with a min_tier are only offered to that tier and above
+	MinTier *Tier   `json:"min_tier,omitempty"`
+	Name    *string `json:"name,omitempty"`
+
+	// PartnerLogoUrl Logo of the partner providing the reward; http or https
+	PartnerLogoUrl *string `json:"partner_logo_url"`
+
+	// PartnerName Partner providing the reward; omit or null for none
+	PartnerName *string `json:"partner_name"`
+
+	// PartnerUrl Partner website; http or https
+	PartnerUrl *string `json:"partner_url"`
+
+	// PendingTtlSeconds Seconds a redemption may stay pending before it expires; omit or null for 24 hours
+	PendingTtlSeconds *int `json:"pending_ttl_seconds"`
+
+	// PriceRules Rules lowering cost for some users; each user pays the lowest price that applies to them. Omit or null for none.
+	PriceRules *[]PriceRule `json:"price_rules"`
+
+	// RewardType What redeeming the reward gives: standard rewards are fulfilled outside the platform, charging_credit rewards issue a credit the charge-point backend applies to the user's next sessions.
+	RewardType *RewardType             `json:"reward_type,omitempty"`
+	Segment    *map[string]interface{} `json:"segment,omitempty"`
+
+	// SortOrder Position in the catalog, lowest first after featured rewards; 0 by default
+	SortOrder *int `json:"sort_order,omitempty"`
+
+	// StockTotal Units available in total; omit or null for unlimited
+	StockTotal *int `json:"stock_total"`
+
+	// Tags Lowercase slugs the catalog is filtered and searched by, at most 20; omit or null for none
+	Tags *[]string `json:"tags"`
+
+	// Terms Terms & conditions shown before redeeming
+	Terms *string `json:"terms"`
 }
 
 // PostRulesJSONBody defines parameters for PostRules.
@@ -99,20 +591,33 @@ type PutRulesRuleIdJSONBody struct {
 
 // PostSegmentsJSONBody defines parameters for PostSegments.
 type PostSegmentsJSONBody struct {
-	Active      *bool                  `json:"active,omitempty"`
-	Criteria    map[string]interface{} `json:"criteria"`
-	Description *string                `json:"description,omitempty"`
-	Name        string                 `json:"name"`
+	Active       *bool                  `json:"active,omitempty"`
+	Criteria     map[string]interface{} `json:"criteria"`
+	Description  *string                `json:"description,omitempty"`
+	Materialized *bool                  `json:"materialized,omitempty"`
+	Name         string                 `json:"name"`
 }
 
 // PutSegmentsSegmentIdJSONBody defines parameters for PutSegmentsSegmentId.
 type PutSegmentsSegmentIdJSONBody struct {
-	Active      *bool                   `json:"active,omitempty"`
-	Criteria    *map[string]interface{} `json:"criteria,omitempty"`
-	Description *string                 `json:"description,omitempty"`
-	Name        *string                 `json:"name,omitempty"`
+	Active       *bool                   `json:"active,omitempty"`
+	Criteria     *map[string]interface{} `json:"criteria,omitempty"`
+	Description  *string                 `json:"description,omitempty"`
+	Materialized *bool                   `json:"materialized,omitempty"`
+	Name         *string                 `json:"name,omitempty"`
+}
+
+// GetSegmentsSegmentIdPreviewParams defines parameters for GetSegmentsSegmentIdPreview.
+type GetSegmentsSegmentIdPreviewParams struct {
+	SampleSize *int `form:"sample_size,omitempty" json:"sample_size,omitempty"`
+
+	// Live Evaluate the criteria even if the segment is materialized
+	Live *bool `form:"live,omitempty" json:"live,omitempty"`
 }
 
+// PostAdjustmentsJSONRequestBody defines body for PostAdjustments for application/json ContentType.
+type PostAdjustmentsJSONRequestBody PostAdjustmentsJSONBody
+
 // PostRewardsJSONRequestBody defines body for PostRewards for application/json ContentType.
 type PostRewardsJSONRequestBody PostRewardsJSONBody
 
@@ -133,6 +638,12 @@ type PutSegmentsSegmentIdJSONRequestBody PutSegmentsSegmentIdJSONBody
 
 // ServerInterface represents all server handlers.
 type ServerInterface interface {
+	// Manually adjust a user's points balance
+	// (POST /adjustments)
+	PostAdjustments(ctx echo.Context) error
+	// Query the admin audit log
+	// (GET /audit-log)
+	GetAuditLog(ctx echo.Context, params GetAuditLogParams) error
 	// Health check
 	// (GET /health)
 	GetHealth(ctx echo.Context) error
@@ -148,6 +659,12 @@ type ServerInterface interface {
 	// Update a reward
 	// (PUT /rewards/{rewardId})
 	PutRewardsRewardId(ctx echo.Context, rewardId openapi_types.UUID) error
+	// Get a reward's voucher code pool
+	// (GET /rewards/{rewardId}/codes)
+	GetRewardsRewardIdCodes(ctx echo.Context, rewardId openapi_types.UUID) error
+	// Upload voucher codes to a reward's pool
+	// (POST /rewards/{rewardId}/codes)
+	PostRewardsRewardIdCodes(ctx echo.Context, rewardId openapi_types.UUID) error
 	// List all rules
 	// (GET /rules)
 	GetRules(ctx echo.Context) error
@@ -175,6 +692,9 @@ type ServerInterface interface {
 	// Update a segment
 	// (PUT /segments/{segmentId})
 	PutSegmentsSegmentId(ctx echo.Context, segmentId openapi_types.UUID) error
+	// Preview the users a segment targets
+	// (GET /segments/{segmentId}/preview)
+	GetSegmentsSegmentIdPreview(ctx echo.Context, segmentId openapi_types.UUID, params GetSegmentsSegmentIdPreviewParams) error
 }
 
 // ServerInterfaceWrapper converts echo contexts to parameters.
@@ -182,6 +702,86 @@ type ServerInterfaceWrapper struct {
 	Handler ServerInterface
 }
 
+// PostAdjustments converts echo context to params.
+func (w *ServerInterfaceWrapper) PostAdjustments(ctx echo.Context) error {
+	var err error
+
+	ctx.Set(BearerAuthScopes, []string{"adjustments:approve"})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.PostAdjustments(ctx)
+	return err
+}
+
+// GetAuditLog converts echo context to params.
+func (w *ServerInterfaceWrapper) GetAuditLog(ctx echo.Context) error {
+	var err error
+
+	ctx.Set(BearerAuthScopes, []string{"audit:read"})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params GetAuditLogParams
+	// ------------- Optional query parameter "actor_id" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "actor_id", ctx.QueryParams(), &params.ActorId)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter actor_id: %s", err))
+	}
+
+	// ------------- Optional query parameter "entity_type" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "entity_type", ctx.QueryParams(), &params.EntityType)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter entity_type: %s", err))
+	}
+
+	// ------------- Optional query parameter "entity_id" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "entity_id", ctx.QueryParams(), &params.EntityId)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter entity_id: %s", err))
+	}
+
+	// ------------- Optional query parameter "action" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "action", ctx.QueryParams(), &params.Action)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter action: %s", err))
+	}
+
+	// ------------- Optional query parameter "since" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "since", ctx.QueryParams(), &params.Since)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter since: %s", err))
+	}
+
+	// ------------- Optional query parameter "until" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "until", ctx.QueryParams(), &params.Until)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter until: %s", err))
+	}
+
+	// ------------- Optional query parameter "limit" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "limit", ctx.QueryParams(), &params.Limit)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter limit: %s", err))
+	}
+
+	// ------------- Optional query parameter "offset" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "offset", ctx.QueryParams(), &params.Offset)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter offset: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.GetAuditLog(ctx, params)
+	return err
+}
+
 // GetHealth converts echo context to params.
 func (w *ServerInterfaceWrapper) GetHealth(ctx echo.Context) error {
 	var err error
@@ -195,7 +795,7 @@ func (w *ServerInterfaceWrapper) GetHealth(ctx echo.Context) error {
 func (w *ServerInterfaceWrapper) GetRewards(ctx echo.Context) error {
 	var err error
 
-	ctx.Set(BearerAuthScopes, []string{})
+	ctx.Set(BearerAuthScopes, []string{"rewards:read"})
 
 	// Parameter object where we will unmarshal all parameters from the context
 	var params GetRewardsParams
@@ -222,7 +822,7 @@ func (w *ServerInterfaceWrapper) GetRewards(ctx echo.Context) error {
 func (w *ServerInterfaceWrapper) PostRewards(ctx echo.Context) error {
 	var err error
 
-	ctx.Set(BearerAuthScopes, []string{})
+	ctx.Set(BearerAuthScopes, []string{"rewards:write"})
 
 	// Invoke the callback with all the unmarshaled arguments
 	err = w.Handler.PostRewards(ctx)
@@ -240,7 +840,7 @@ func (w *ServerInterfaceWrapper) GetRewardsRewardId(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter rewardId: %s", err))
 	}
 
-	ctx.Set(BearerAuthScopes, []string{})
+	ctx.Set(BearerAuthScopes, []string{"rewards:read"})
 
 	// Invoke the callback with all the unmarshaled arguments
 	err = w.Handler.GetRewardsRewardId(ctx, rewardId)
@@ -258,18 +858,54 @@ func (w *ServerInterfaceWrapper) PutRewardsRewardId(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter rewardId: %s", err))
 	}
 
-	ctx.Set(BearerAuthScopes, []string{})
+	ctx.Set(BearerAuthScopes, []string{"rewards:write"})
 
 	// Invoke the callback with all the unmarshaled arguments
 	err = w.Handler.PutRewardsRewardId(ctx, rewardId)
 	return err
 }
 
+// GetRewardsRewardIdCodes converts echo context to params.
+func (w *ServerInterfaceWrapper) GetRewardsRewardIdCodes(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "rewardId" -------------
+	var rewardId openapi_types.UUID
+
+	err = runtime.BindStyledParameterWithOptions("simple", "rewardId", ctx.Param("rewardId"), &rewardId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter rewardId: %s", err))
+	}
+
+	ctx.Set(BearerAuthScopes, []string{"rewards:read"})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.GetRewardsRewardIdCodes(ctx, rewardId)
+	return err
+}
+
+// PostRewardsRewardIdCodes converts echo context to params.
+func (w *ServerInterfaceWrapper) PostRewardsRewardIdCodes(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "rewardId" -------------
+	var rewardId openapi_types.UUID
+
+	err = runtime.BindStyledParameterWithOptions("simple", "rewardId", ctx.Param("rewardId"), &rewardId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter rewardId: %s", err))
+	}
+
+	ctx.Set(BearerAuthScopes, []string{"rewards:write"})
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.PostRewardsRewardIdCodes(ctx, rewardId)
+	return err
+}
+
 // GetRules converts echo context to params.
 func (w *ServerInterfaceWrapper) GetRules(ctx echo.Context) error {
 	var err error
 
-	ctx.Set(BearerAuthScopes, []string{})
+	ctx.Set(BearerAuthScopes, []string{"rules:read"})
 
 	// Invoke the callback with all the unmarshaled arguments
 	err = w.Handler.GetRules(ctx)
@@ -280,7 +916,7 @@ func (w *ServerInterfaceWrapper) GetRules(ctx echo.Context) error {
 func (w *ServerInterfaceWrapper) PostRules(ctx echo.Context) error {
 	var err error
 
-	ctx.Set(BearerAuthScopes, []string{})
+	ctx.Set(BearerAuthScopes, []string{"rules:write"})
 
 	// Invoke the callback with all the unmarshaled arguments
 	err = w.Handler.PostRules(ctx)
@@ -298,7 +934,7 @@ func (w *ServerInterfaceWrapper) DeleteRulesRuleId(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter ruleId: %s", err))
 	}
 
-	ctx.Set(BearerAuthScopes, []string{})
+	ctx.Set(BearerAuthScopes, []string{"rules:write"})
 
 	// Invoke the callback with all the unmarshaled arguments
 	err = w.Handler.DeleteRulesRuleId(ctx, ruleId)
@@ -316,7 +952,7 @@ func (w *ServerInterfaceWrapper) GetRulesRuleId(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter ruleId: %s", err))
 	}
 
-	ctx.Set(BearerAuthScopes, []string{})
+	ctx.Set(BearerAuthScopes, []string{"rules:read"})
 
 	// Invoke the callback with all the unmarshaled arguments
 	err = w.Handler.GetRulesRuleId(ctx, ruleId)
@@ -334,7 +970,7 @@ func (w *ServerInterfaceWrapper) PutRulesRuleId(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter ruleId: %s", err))
 	}
 
-	ctx.Set(BearerAuthScopes, []string{})
+	ctx.Set(BearerAuthScopes, []string{"rules:write"})
 
 	// Invoke the callback with all the unmarshaled arguments
 	err = w.Handler.PutRulesRuleId(ctx, ruleId)
@@ -345,7 +981,7 @@ func (w *ServerInterfaceWrapper) PutRulesRuleId(ctx echo.Context) error {
 func (w *ServerInterfaceWrapper) GetSegments(ctx echo.Context) error {
 	var err error
 
-	ctx.Set(BearerAuthScopes, []string{})
+	ctx.Set(BearerAuthScopes, []string{"segments:read"})
 
 	// Invoke the callback with all the unmarshaled arguments
 	err = w.Handler.GetSegments(ctx)
@@ -356,7 +992,7 @@ func (w *ServerInterfaceWrapper) GetSegments(ctx echo.Context) error {
 func (w *ServerInterfaceWrapper) PostSegments(ctx echo.Context) error {
 	var err error
 
-	ctx.Set(BearerAuthScopes, []string{})
+	ctx.Set(BearerAuthScopes, []string{"segments:write"})
 
 	// Invoke the callback with all the unmarshaled arguments
 	err = w.Handler.PostSegments(ctx)
@@ -374,7 +1010,7 @@ func (w *ServerInterfaceWrapper) GetSegmentsSegmentId(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter segmentId: %s", err))
 	}
 
-	ctx.Set(BearerAuthScopes, []string{})
+	ctx.Set(BearerAuthScopes, []string{"segments:read"})
 
 	// Invoke the callback with all the unmarshaled arguments
 	err = w.Handler.GetSegmentsSegmentId(ctx, segmentId)
@@ -392,13 +1028,47 @@ func (w *ServerInterfaceWrapper) PutSegmentsSegmentId(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter segmentId: %s", err))
 	}
 
-	ctx.Set(BearerAuthScopes, []string{})
+	ctx.Set(BearerAuthScopes, []string{"segments:write"})
 
 	// Invoke the callback with all the unmarshaled arguments
 	err = w.Handler.PutSegmentsSegmentId(ctx, segmentId)
 	return err
 }
 
+// GetSegmentsSegmentIdPreview converts echo context to params.
+func (w *ServerInterfaceWrapper) GetSegmentsSegmentIdPreview(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "segmentId" -------------
+	var segmentId openapi_types.UUID
+
+	err = runtime.BindStyledParameterWithOptions("simple", "segmentId", ctx.Param("segmentId"), &segmentId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter segmentId: %s", err))
+	}
+
+	ctx.Set(BearerAuthScopes, []string{"segments:read", "users:pii"})
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params GetSegmentsSegmentIdPreviewParams
+	// ------------- Optional query parameter "sample_size" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "sample_size", ctx.QueryParams(), &params.SampleSize)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter sample_size: %s", err))
+	}
+
+	// ------------- Optional query parameter "live" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "live", ctx.QueryParams(), &params.Live)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter live: %s", err))
+	}
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.GetSegmentsSegmentIdPreview(ctx, segmentId, params)
+	return err
+}
+
 // This is a simple interface which specifies echo.Route addition functions which
 // are present on both echo.Echo and echo.Group, since we want to allow using
 // either of them for path registration
@@ -427,11 +1097,15 @@ func RegisterHandlersWithBaseURL(router EchoRouter, si ServerInterface, baseURL
 		Handler: si,
 	}
 
+	router.POST(baseURL+"/adjustments", wrapper.PostAdjustments)
+	router.GET(baseURL+"/audit-log", wrapper.GetAuditLog)
 	router.GET(baseURL+"/health", wrapper.GetHealth)
 	router.GET(baseURL+"/rewards", wrapper.GetRewards)
 	router.POST(baseURL+"/rewards", wrapper.PostRewards)
 	router.GET(baseURL+"/rewards/:rewardId", wrapper.GetRewardsRewardId)
 	router.PUT(baseURL+"/rewards/:rewardId", wrapper.PutRewardsRewardId)
+	router.GET(baseURL+"/rewards/:rewardId/codes", wrapper.GetRewardsRewardIdCodes)
+	router.POST(baseURL+"/rewards/:rewardId/codes", wrapper.PostRewardsRewardIdCodes)
 	router.GET(baseURL+"/rules", wrapper.GetRules)
 	router.POST(baseURL+"/rules", wrapper.PostRules)
 	router.DELETE(baseURL+"/rules/:ruleId", wrapper.DeleteRulesRuleId)
@@ -441,5 +1115,6 @@ func RegisterHandlersWithBaseURL(router EchoRouter, si ServerInterface, baseURL
 	router.POST(baseURL+"/segments", wrapper.PostSegments)
 	router.GET(baseURL+"/segments/:segmentId", wrapper.GetSegmentsSegmentId)
 	router.PUT(baseURL+"/segments/:segmentId", wrapper.PutSegmentsSegmentId)
+	router.GET(baseURL+"/segments/:segmentId/preview", wrapper.GetSegmentsSegmentIdPreview)
 
 }
@@ -1,44 +1,55 @@
-//encore:service
 package admin
 
 import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	"encore.app/internal/auth"
+	"encore.app/internal/availability"
+	"encore.app/internal/catalog"
+	"encore.app/internal/credits"
 	"encore.app/internal/db"
-	"encore.dev/pubsub"
+	"encore.app/internal/fulfillment"
+	"encore.app/internal/inventory"
+	"encore.app/internal/pricing"
+	"encore.app/internal/segments"
+	"encore.app/internal/tiers"
+	"encore.app/internal/vouchers"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	openapi_types "github.com/oapi-codegen/runtime/types"
 	"github.com/sqlc-dev/pqtype"
 	ffclient "github.com/thomaspoignant/go-feature-flag"
 	"github.com/thomaspoignant/go-feature-flag/ffcontext"
+	"github.com/thomaspoignant/go-feature-flag/retriever/fileretriever"
 	"gopkg.in/yaml.v3"
 )
 
 // Service configuration
 var (
-	// Database queries
+	// Database connection and queries, set up by init in Encore builds
+	conn    *sql.DB
 	queries *db.Queries
 )
 
-// Pub/Sub topics for admin events
-var (
-	RuleUpdated    = pubsub.NewTopic[*RuleUpdateEvent]("rule-updated", pubsub.TopicConfig{DeliveryGuarantee: pubsub.AtLeastOnce})
-	RewardUpdated  = pubsub.NewTopic[*RewardUpdateEvent]("reward-updated", pubsub.TopicConfig{DeliveryGuarantee: pubsub.AtLeastOnce})
-	SegmentUpdated = pubsub.NewTopic[*SegmentUpdateEvent]("segment-updated", pubsub.TopicConfig{DeliveryGuarantee: pubsub.AtLeastOnce})
-)
-
-// Event types for pub/sub
+// Event types for pub/sub. Each event carries the entity's full state after
+// the change (the last known state for deletions) and its version, so
+// consumers can apply events idempotently and ignore stale ones.
 type RuleUpdateEvent struct {
 	RuleID    uuid.UUID `json:"rule_id"`
 	Action    string    `json:"action"` // "created", "updated", "deleted"
 	RuleName  string    `json:"rule_name"`
+	Version   int32     `json:"version"`
+	Rule      *Rule     `json:"rule"`
 	UpdatedBy uuid.UUID `json:"updated_by"`
 	Timestamp time.Time `json:"timestamp"`
 }
@@ -47,6 +58,8 @@ type RewardUpdateEvent struct {
 	RewardID   uuid.UUID `json:"reward_id"`
 	Action     string    `json:"action"` // "created", "updated"
 	RewardName string    `json:"reward_name"`
+	Version    int32     `json:"version"`
+	Reward     *Reward   `json:"reward"`
 	UpdatedBy  uuid.UUID `json:"updated_by"`
 	Timestamp  time.Time `json:"timestamp"`
 }
@@ -55,6 +68,8 @@ type SegmentUpdateEvent struct {
 	SegmentID   uuid.UUID `json:"segment_id"`
 	Action      string    `json:"action"` // "created", "updated"
 	SegmentName string    `json:"segment_name"`
+	Version     int32     `json:"version"`
+	Segment     *Segment  `json:"segment"`
 	UpdatedBy   uuid.UUID `json:"updated_by"`
 	Timestamp   time.Time `json:"timestamp"`
 }
@@ -64,61 +79,81 @@ type Claims struct {
 	UserID uuid.UUID `json:"user_id"`
 	Email  string    `json:"email"`
 	Role   string    `json:"role"`
+	Roles  []string  `json:"roles"`
 }
 
 // AdminService implements the ServerInterface
 type AdminService struct{}
 
-// init initializes the service
-func init() {
-	// Initialize database queries (Encore injects DB connection)
-	queries = db.New(nil)
+// Service serves the admin API
+//
+//encore:service
+type Service struct {
+	// router serves the admin routes under adminBasePath
+	router *echo.Echo
 }
 
-// RBAC middleware to check for product-admin role
-func requireProductAdmin(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		// Get user from context (set by JWT middleware)
-		user := c.Get("user")
-		if user == nil {
-			return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
-		}
+// adminBasePath is the path the admin API is served under
+const adminBasePath = "/admin"
 
-		claims, ok := user.(*Claims)
-		if !ok {
-			return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token claims")
-		}
+// withTx runs fn with queries bound to a transaction, committing if fn succeeds
+func withTx(ctx context.Context, fn func(q *db.Queries) error) error {
+	return db.WithTx(ctx, conn, fn)
+}
 
-		// Check for product-admin role
-		if claims.Role != "product-admin" {
-			return echo.NewHTTPError(http.StatusForbidden, "Insufficient permissions")
+// jwtMiddleware returns middleware authenticating requests with admin JWTs
+// checked by v. Without a verifier every request is refused as unavailable.
+func jwtMiddleware(v *auth.Verifier) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if v == nil {
+				return echo.NewHTTPError(http.StatusServiceUnavailable, "Admin authentication is not configured")
+			}
+			authHeader := c.Request().Header.Get("Authorization")
+			if authHeader == "" {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Authorization header required")
+			}
+			// Extract Bearer token
+			if len(authHeader) < 7 || authHeader[:7] != "Bearer " {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid authorization header format")
+			}
+			tokenClaims, err := v.Verify(authHeader[7:])
+			if err != nil {
+				// Verification details (key lookup, algorithm, JWKS errors) are
+				// logged rather than returned to unauthenticated callers
+				log.Printf("admin: token rejected: %v", err)
+				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token")
+			}
+			claims, err := claimsFromToken(tokenClaims)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token claims")
+			}
+			c.Set("user", claims)
+			return next(c)
 		}
-
-		return next(c)
 	}
 }
 
-// JWT middleware for authentication
-func jwtMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		authHeader := c.Request().Header.Get("Authorization")
-		if authHeader == "" {
-			return echo.NewHTTPError(http.StatusUnauthorized, "Authorization header required")
-		}
-		// Extract Bearer token
-		if len(authHeader) < 7 || authHeader[:7] != "Bearer " {
-			return echo.NewHTTPError(http.StatusUnauthorized, "Invalid authorization header format")
-		}
-		// TODO: Implement proper JWT validation
-		// For now, create a mock user for testing
-		claims := &Claims{
-			UserID: uuid.New(),
-			Email:  "admin@urja.com",
-			Role:   "product-admin",
-		}
-		c.Set("user", claims)
-		return next(c)
+// claimsFromToken maps verified token claims onto the admin Claims
+func claimsFromToken(tc *auth.Claims) (*Claims, error) {
+	userID, err := uuid.Parse(tc.UserIDOrSubject())
+	if err != nil {
+		return nil, err
 	}
+	role := tc.Role
+	if role == "" && len(tc.Roles) > 0 {
+		role = tc.Roles[0]
+	}
+	roles := tc.Roles
+	if tc.Role != "" {
+		roles = append([]string{tc.Role}, roles...)
+	}
+	return &Claims{
+		UserID: userID,
+		Email:  tc.Email,
+		Role:   role,
+		Roles:  roles,
+	}, nil
 }
 
 // Health check endpoint
@@ -138,19 +173,7 @@ func (s *AdminService) GetRules(ctx echo.Context) error {
 	}
 	var response []Rule
 	for _, rule := range rules {
-		var config map[string]interface{}
-		if err := json.Unmarshal(rule.Config, &config); err != nil {
-			config = nil
-		}
-		response = append(response, Rule{
-			Id:          (*openapi_types.UUID)(&rule.ID),
-			Name:        &rule.Name,
-			Description: &rule.Description.String,
-			Config:      &config,
-			Active:      &rule.Active,
-			CreatedAt:   &rule.CreatedAt,
-			UpdatedAt:   &rule.UpdatedAt,
-		})
+		response = append(response, ruleFromDB(rule))
 	}
 	return ctx.JSON(http.StatusOK, response)
 }
@@ -170,29 +193,29 @@ func (s *AdminService) PostRules(ctx echo.Context) error {
 		desc = sql.NullString{String: *req.Description, Valid: true}
 	}
 	createdBy := uuid.NullUUID{UUID: user.UserID, Valid: true}
-	rule, err := queries.CreateRule(ctx.Request().Context(), db.CreateRuleParams{
-		ID:          uuid.New(),
-		Name:        req.Name,
-		Description: desc,
-		Config:      configBytes,
-		Active:      true,
-		CreatedBy:   createdBy,
+	var rule db.Rule
+	err = withTx(ctx.Request().Context(), func(q *db.Queries) error {
+		var err error
+		rule, err = q.CreateRule(ctx.Request().Context(), db.CreateRuleParams{
+			ID:          uuid.New(),
+			Name:        req.Name,
+			Description: desc,
+			Config:      configBytes,
+			Active:      true,
+			CreatedBy:   createdBy,
+		})
+		if err != nil {
+			return err
+		}
+		if err := recordAudit(ctx, q, "rule.created", auditEntityRule, rule.ID, nil, rule); err != nil {
+			return err
+		}
+		return enqueueRuleEvent(ctx, q, "created", rule, rule.Version)
 	})
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create rule")
 	}
-	var config map[string]interface{}
-	_ = json.Unmarshal(rule.Config, &config)
-	response := Rule{
-		Id:          (*openapi_types.UUID)(&rule.ID),
-		Name:        &rule.Name,
-		Description: &rule.Description.String,
-		Config:      &config,
-		Active:      &rule.Active,
-		CreatedAt:   &rule.CreatedAt,
-		UpdatedAt:   &rule.UpdatedAt,
-	}
-	return ctx.JSON(http.StatusCreated, response)
+	return ctx.JSON(http.StatusCreated, ruleFromDB(rule))
 }
 
 func (s *AdminService) GetRulesRuleId(ctx echo.Context, ruleId openapi_types.UUID) error {
@@ -203,18 +226,7 @@ func (s *AdminService) GetRulesRuleId(ctx echo.Context, ruleId openapi_types.UUI
 		}
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to retrieve rule")
 	}
-	var config map[string]interface{}
-	_ = json.Unmarshal(rule.Config, &config)
-	response := Rule{
-		Id:          (*openapi_types.UUID)(&rule.ID),
-		Name:        &rule.Name,
-		Description: &rule.Description.String,
-		Config:      &config,
-		Active:      &rule.Active,
-		CreatedAt:   &rule.CreatedAt,
-		UpdatedAt:   &rule.UpdatedAt,
-	}
-	return ctx.JSON(http.StatusOK, response)
+	return ctx.JSON(http.StatusOK, ruleFromDB(rule))
 }
 
 func (s *AdminService) PutRulesRuleId(ctx echo.Context, ruleId openapi_types.UUID) error {
@@ -242,55 +254,60 @@ func (s *AdminService) PutRulesRuleId(ctx echo.Context, ruleId openapi_types.UUI
 	if req.Active != nil {
 		active = *req.Active
 	}
-	rule, err := queries.UpdateRule(ctx.Request().Context(), db.UpdateRuleParams{
-		ID:          uuid.UUID(ruleId),
-		Name:        name,
-		Description: desc,
-		Config:      configBytes,
-		Active:      active,
+	var rule db.Rule
+	err := withTx(ctx.Request().Context(), func(q *db.Queries) error {
+		before, err := q.GetRule(ctx.Request().Context(), uuid.UUID(ruleId))
+		if err != nil {
+			return err
+		}
+		rule, err = q.UpdateRule(ctx.Request().Context(), db.UpdateRuleParams{
+			ID:          uuid.UUID(ruleId),
+			Name:        name,
+			Description: desc,
+			Config:      configBytes,
+			Active:      active,
+		})
+		if err != nil {
+			return err
+		}
+		if err := recordAudit(ctx, q, "rule.updated", auditEntityRule, rule.ID, before, rule); err != nil {
+			return err
+		}
+		return enqueueRuleEvent(ctx, q, "updated", rule, rule.Version)
 	})
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, sql.ErrNoRows) {
 			return echo.NewHTTPError(http.StatusNotFound, "Rule not found")
 		}
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update rule")
 	}
-	var config map[string]interface{}
-	_ = json.Unmarshal(rule.Config, &config)
-	response := Rule{
-		Id:          (*openapi_types.UUID)(&rule.ID),
-		Name:        &rule.Name,
-		Description: &rule.Description.String,
-		Config:      &config,
-		Active:      &rule.Active,
-		CreatedAt:   &rule.CreatedAt,
-		UpdatedAt:   &rule.UpdatedAt,
-	}
-	return ctx.JSON(http.StatusOK, response)
+	return ctx.JSON(http.StatusOK, ruleFromDB(rule))
 }
 
 func (s *AdminService) DeleteRulesRuleId(ctx echo.Context, ruleId openapi_types.UUID) error {
-	// Get user from context
-	user := ctx.Get("user").(*Claims)
-
-	// Delete rule from database
-	err := queries.DeleteRule(ctx.Request().Context(), uuid.UUID(ruleId))
+	// Delete rule from database, keeping its last state in the audit log
+	var rule db.Rule
+	err := withTx(ctx.Request().Context(), func(q *db.Queries) error {
+		var err error
+		rule, err = q.GetRule(ctx.Request().Context(), uuid.UUID(ruleId))
+		if err != nil {
+			return err
+		}
+		if err := q.DeleteRule(ctx.Request().Context(), rule.ID); err != nil {
+			return err
+		}
+		if err := recordAudit(ctx, q, "rule.deleted", auditEntityRule, rule.ID, rule, nil); err != nil {
+			return err
+		}
+		// Deletion is the rule's final version, carrying its last known state
+		return enqueueRuleEvent(ctx, q, "deleted", rule, rule.Version+1)
+	})
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, sql.ErrNoRows) {
 			return echo.NewHTTPError(http.StatusNotFound, "Rule not found")
 		}
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete rule")
 	}
-
-	// Publish event
-	RuleUpdated.Publish(ctx.Request().Context(), &RuleUpdateEvent{
-		RuleID:    uuid.UUID(ruleId),
-		Action:    "deleted",
-		RuleName:  "deleted",
-		UpdatedBy: user.UserID,
-		Timestamp: time.Now(),
-	})
-
 	return ctx.NoContent(http.StatusNoContent)
 }
 
@@ -312,6 +329,10 @@ func (s *AdminService) GetRewards(ctx echo.Context, params GetRewardsParams) err
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to retrieve rewards")
 	}
+	redeemedToday, err := inventory.RedeemedToday(ctx.Request().Context(), queries, time.Now())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to retrieve reward stock")
+	}
 	var filteredRewards []Reward
 	for _, reward := range rewards {
 		var segment map[string]interface{}
@@ -330,17 +351,7 @@ func (s *AdminService) GetRewards(ctx echo.Context, params GetRewardsParams) err
 				continue
 			}
 		}
-		cost := int(reward.Cost)
-		filteredRewards = append(filteredRewards, Reward{
-			Id:          (*openapi_types.UUID)(&reward.ID),
-			Name:        &reward.Name,
-			Description: &reward.Description.String,
-			Cost:        &cost,
-			Segment:     &segment,
-			Active:      &reward.Active,
-			CreatedBy:   (*openapi_types.UUID)(&reward.CreatedBy.UUID),
-			CreatedAt:   &reward.CreatedAt,
-		})
+		filteredRewards = append(filteredRewards, rewardWithStock(reward, redeemedToday[reward.ID]))
 	}
 	return ctx.JSON(http.StatusOK, filteredRewards)
 }
@@ -361,36 +372,138 @@ func (s *AdminService) PostRewards(ctx echo.Context) error {
 		if err == nil {
 			segmentRaw = pqtype.NullRawMessage{RawMessage: b, Valid: true}
 		}
+		if _, err := segments.ParseTarget(b); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid segment: "+err.Error())
+		}
+	}
+	minTier, err := minTierParam(req.MinTier)
+	if err != nil {
+		return err
+	}
+	stockTotal, err := stockParam("stock_total", req.StockTotal)
+	if err != nil {
+		return err
+	}
+	dailyStock, err := stockParam("daily_stock", req.DailyStock)
+	if err != nil {
+		return err
+	}
+	maxPerUser, err := limitParam("max_per_user", req.MaxPerUser, 1)
+	if err != nil {
+		return err
+	}
+	maxPerUserMonthly, err := limitParam("max_per_user_monthly", req.MaxPerUserMonthly, 1)
+	if err != nil {
+		return err
+	}
+	cooldown, err := limitParam("cooldown_seconds", req.CooldownSeconds, 0)
+	if err != nil {
+		return err
+	}
+	pendingTTL, err := limitParam("pending_ttl_seconds", req.PendingTtlSeconds, minPendingTTLSeconds)
+	if err != nil {
+		return err
+	}
+	availableFrom, availableUntil, availableDays, err := availabilityParams(req.AvailableFrom, req.AvailableUntil, req.AvailableDays)
+	if err != nil {
+		return err
+	}
+	minPoints, cashPrice, err := cashOptionParams(int32(req.Cost), req.MinPoints, req.CashPrice)
+	if err != nil {
+		return err
+	}
+	priceRules, err := priceRulesParam(req.PriceRules)
+	if err != nil {
+		return err
+	}
+	metadata, err := metadataParams(req.Category, req.Tags, req.ImageUrl, req.Terms, req.PartnerName, req.PartnerUrl, req.PartnerLogoUrl)
+	if err != nil {
+		return err
+	}
+	var sortOrder int32
+	if req.SortOrder != nil {
+		sortOrder = int32(*req.SortOrder)
+	}
+	codeSource, err := codeSourceParam(req.CodeSource)
+	if err != nil {
+		return err
+	}
+	codeFormat, err := codeFormatParam(req.CodeFormat)
+	if err != nil {
+		return err
+	}
+	codeLowThreshold, err := limitParam("code_low_threshold", req.CodeLowThreshold, 0)
+	if err != nil {
+		return err
+	}
+	rewardType, creditAmount, err := rewardTypeParam(req.RewardType, req.CreditAmount)
+	if err != nil {
+		return err
+	}
+	creditValidDays, err := limitParam("credit_valid_days", req.CreditValidDays, 1)
+	if err != nil {
+		return err
+	}
+	adapter, fulfillmentURL, fulfillmentSecret := fulfillmentParams(req.FulfillmentAdapter, req.FulfillmentUrl, req.FulfillmentSecret)
+	if err := validateFulfillment(adapter, fulfillmentURL, fulfillmentSecret); err != nil {
+		return err
 	}
 	createdBy := uuid.NullUUID{UUID: user.UserID, Valid: true}
-	reward, err := queries.CreateReward(ctx.Request().Context(), db.CreateRewardParams{
-		ID:          uuid.New(),
-		Name:        req.Name,
-		Description: desc,
-		Cost:        int32(req.Cost),
-		Segment:     segmentRaw,
-		Active:      true,
-		CreatedBy:   createdBy,
+	var reward db.RewardsCatalog
+	err = withTx(ctx.Request().Context(), func(q *db.Queries) error {
+		var err error
+		reward, err = q.CreateReward(ctx.Request().Context(), db.CreateRewardParams{
+			ID:                 uuid.New(),
+			Name:               req.Name,
+			Description:        desc,
+			Cost:               int32(req.Cost),
+			Segment:            segmentRaw,
+			Active:             true,
+			CreatedBy:          createdBy,
+			MinTier:            minTier,
+			StockTotal:         stockTotal,
+			DailyStock:         dailyStock,
+			MaxPerUser:         maxPerUser,
+			MaxPerUserMonthly:  maxPerUserMonthly,
+			CooldownSeconds:    cooldown,
+			CodeSource:         codeSource,
+			CodeFormat:         codeFormat,
+			CodeLowThreshold:   codeLowThreshold,
+			RewardType:         rewardType,
+			CreditAmount:       creditAmount,
+			CreditValidDays:    creditValidDays,
+			FulfillmentAdapter: adapter,
+			FulfillmentUrl:     fulfillmentURL,
+			FulfillmentSecret:  fulfillmentSecret,
+			PendingTtlSeconds:  pendingTTL,
+			AvailableFrom:      availableFrom,
+			AvailableUntil:     availableUntil,
+			AvailableDays:      availableDays,
+			MinPoints:          minPoints,
+			CashPrice:          cashPrice,
+			PriceRules:         priceRules,
+			Category:           metadata.category,
+			Tags:               metadata.tags,
+			ImageUrl:           metadata.imageURL,
+			Terms:              metadata.terms,
+			PartnerName:        metadata.partnerName,
+			PartnerUrl:         metadata.partnerURL,
+			PartnerLogoUrl:     metadata.partnerLogoURL,
+			SortOrder:          sortOrder,
+			Featured:           req.Featured != nil && *req.Featured,
+		})
+		if err != nil {
+			return err
+		}
+		if err := recordAudit(ctx, q, "reward.created", auditEntityReward, reward.ID, nil, auditReward(reward)); err != nil {
+			return err
+		}
+		return enqueueRewardEvent(ctx, q, "created", reward)
 	})
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create reward")
 	}
-	var segment map[string]interface{}
-	if reward.Segment.Valid {
-		_ = json.Unmarshal(reward.Segment.RawMessage, &segment)
-	}
-	cost := int(reward.Cost)
-	response := Reward{
-		Id:          (*openapi_types.UUID)(&reward.ID),
-		Name:        &reward.Name,
-		Description: &reward.Description.String,
-		Cost:        &cost,
-		Segment:     &segment,
-		Active:      &reward.Active,
-		CreatedBy:   (*openapi_types.UUID)(&reward.CreatedBy.UUID),
-		CreatedAt:   &reward.CreatedAt,
-	}
-	return ctx.JSON(http.StatusCreated, response)
+	return ctx.JSON(http.StatusCreated, rewardWithStock(reward, 0))
 }
 
 func (s *AdminService) GetRewardsRewardId(ctx echo.Context, rewardId openapi_types.UUID) error {
@@ -401,29 +514,140 @@ func (s *AdminService) GetRewardsRewardId(ctx echo.Context, rewardId openapi_typ
 		}
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to retrieve reward")
 	}
-	var segment map[string]interface{}
-	if reward.Segment.Valid {
-		_ = json.Unmarshal(reward.Segment.RawMessage, &segment)
-	}
-	cost := int(reward.Cost)
-	response := Reward{
-		Id:          (*openapi_types.UUID)(&reward.ID),
-		Name:        &reward.Name,
-		Description: &reward.Description.String,
-		Cost:        &cost,
-		Segment:     &segment,
-		Active:      &reward.Active,
-		CreatedBy:   (*openapi_types.UUID)(&reward.CreatedBy.UUID),
-		CreatedAt:   &reward.CreatedAt,
+	redeemedToday, err := inventory.RedeemedToday(ctx.Request().Context(), queries, time.Now())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to retrieve reward stock")
 	}
-	return ctx.JSON(http.StatusOK, response)
+	return ctx.JSON(http.StatusOK, rewardWithStock(reward, redeemedToday[reward.ID]))
 }
 
 func (s *AdminService) PutRewardsRewardId(ctx echo.Context, rewardId openapi_types.UUID) error {
-	var req PutRewardsRewardIdJSONBody
-	if err := ctx.Bind(&req); err != nil {
+	patch, err := io.ReadAll(ctx.Request().Body)
+	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
 	}
+	var reward db.RewardsCatalog
+	err = withTx(ctx.Request().Context(), func(q *db.Queries) error {
+		before, err := q.GetRewardForUpdate(ctx.Request().Context(), uuid.UUID(rewardId))
+		if err != nil {
+			return err
+		}
+		req, err := mergeRewardPatch(before, patch)
+		if err != nil {
+			return err
+		}
+		params, err := rewardUpdateParams(before.ID, req)
+		if err != nil {
+			return err
+		}
+		reward, err = q.UpdateReward(ctx.Request().Context(), params)
+		if err != nil {
+			return err
+		}
+		if err := recordAudit(ctx, q, "reward.updated", auditEntityReward, reward.ID, auditReward(before), auditReward(reward)); err != nil {
+			return err
+		}
+		return enqueueRewardEvent(ctx, q, "updated", reward)
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "Reward not found")
+		}
+		var httpErr *echo.HTTPError
+		if errors.As(err, &httpErr) {
+			return httpErr
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update reward")
+	}
+	redeemedToday, err := inventory.RedeemedToday(ctx.Request().Context(), queries, time.Now())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to retrieve reward stock")
+	}
+	return ctx.JSON(http.StatusOK, rewardWithStock(reward, redeemedToday[reward.ID]))
+}
+
+// mergeRewardPatch applies an update body to a stored reward: fields present
+// in the body replace the reward's, null clearing them, and omitted fields keep
+// their stored value. The result is validated as a whole by
+// rewardUpdateParams, so e.g. a new cost is checked against the stored
+// min_points.
+func mergeRewardPatch(reward db.RewardsCatalog, patch []byte) (PutRewardsRewardIdJSONBody, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &fields); err != nil {
+		return PutRewardsRewardIdJSONBody{}, echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	stored, err := json.Marshal(rewardBody(reward))
+	if err != nil {
+		return PutRewardsRewardIdJSONBody{}, err
+	}
+	merged := map[string]json.RawMessage{}
+	if err := json.Unmarshal(stored, &merged); err != nil {
+		return PutRewardsRewardIdJSONBody{}, err
+	}
+	for field, value := range fields {
+		merged[field] = value
+	}
+	b, err := json.Marshal(merged)
+	if err != nil {
+		return PutRewardsRewardIdJSONBody{}, err
+	}
+	var req PutRewardsRewardIdJSONBody
+	if err := json.Unmarshal(b, &req); err != nil {
+		return PutRewardsRewardIdJSONBody{}, echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	return req, nil
+}
+
+// rewardBody returns a stored reward as an update body, including its
+// fulfillment secret, which the API never returns
+func rewardBody(reward db.RewardsCatalog) PutRewardsRewardIdJSONBody {
+	r := rewardFromDB(reward)
+	var segment *map[string]interface{}
+	if reward.Segment.Valid {
+		segment = r.Segment
+	}
+	return PutRewardsRewardIdJSONBody{
+		Name:               r.Name,
+		Description:        nullStringPtr(reward.Description),
+		Cost:               r.Cost,
+		Segment:            segment,
+		Active:             r.Active,
+		MinTier:            r.MinTier,
+		RewardType:         r.RewardType,
+		CreditAmount:       r.CreditAmount,
+		CreditValidDays:    r.CreditValidDays,
+		StockTotal:         r.StockTotal,
+		DailyStock:         r.DailyStock,
+		MaxPerUser:         r.MaxPerUser,
+		MaxPerUserMonthly:  r.MaxPerUserMonthly,
+		CooldownSeconds:    r.CooldownSeconds,
+		PendingTtlSeconds:  r.PendingTtlSeconds,
+		AvailableFrom:      r.AvailableFrom,
+		AvailableUntil:     r.AvailableUntil,
+		AvailableDays:      r.AvailableDays,
+		MinPoints:          r.MinPoints,
+		CashPrice:          r.CashPrice,
+		PriceRules:         r.PriceRules,
+		Category:           r.Category,
+		Tags:               r.Tags,
+		ImageUrl:           r.ImageUrl,
+		Terms:              r.Terms,
+		PartnerName:        r.PartnerName,
+		PartnerUrl:         r.PartnerUrl,
+		PartnerLogoUrl:     r.PartnerLogoUrl,
+		SortOrder:          r.SortOrder,
+		Featured:           r.Featured,
+		CodeSource:         r.CodeSource,
+		CodeFormat:         r.CodeFormat,
+		CodeLowThreshold:   r.CodeLowThreshold,
+		FulfillmentAdapter: r.FulfillmentAdapter,
+		FulfillmentUrl:     r.FulfillmentUrl,
+		FulfillmentSecret:  nullStringPtr(reward.FulfillmentSecret),
+	}
+}
+
+// rewardUpdateParams validates a reward's full update body
+func rewardUpdateParams(id uuid.UUID, req PutRewardsRewardIdJSONBody) (db.UpdateRewardParams, error) {
 	desc := sql.NullString{String: "", Valid: false}
 	if req.Description != nil {
 		desc = sql.NullString{String: *req.Description, Valid: true}
@@ -438,6 +662,9 @@ func (s *AdminService) PutRewardsRewardId(ctx echo.Context, rewardId openapi_typ
 		if err == nil {
 			segmentRaw = pqtype.NullRawMessage{RawMessage: b, Valid: true}
 		}
+		if _, err := segments.ParseTarget(b); err != nil {
+			return db.UpdateRewardParams{}, echo.NewHTTPError(http.StatusBadRequest, "Invalid segment: "+err.Error())
+		}
 	}
 	cost := int32(0)
 	if req.Cost != nil {
@@ -447,36 +674,191 @@ func (s *AdminService) PutRewardsRewardId(ctx echo.Context, rewardId openapi_typ
 	if req.Active != nil {
 		active = *req.Active
 	}
-	reward, err := queries.UpdateReward(ctx.Request().Context(), db.UpdateRewardParams{
-		ID:          uuid.UUID(rewardId),
-		Name:        name,
-		Description: desc,
-		Cost:        cost,
-		Segment:     segmentRaw,
-		Active:      active,
-	})
+	minTier, err := minTierParam(req.MinTier)
+	if err != nil {
+		return db.UpdateRewardParams{}, err
+	}
+	stockTotal, err := stockParam("stock_total", req.StockTotal)
+	if err != nil {
+		return db.UpdateRewardParams{}, err
+	}
+	dailyStock, err := stockParam("daily_stock", req.DailyStock)
+	if err != nil {
+		return db.UpdateRewardParams{}, err
+	}
+	maxPerUser, err := limitParam("max_per_user", req.MaxPerUser, 1)
+	if err != nil {
+		return db.UpdateRewardParams{}, err
+	}
+	maxPerUserMonthly, err := limitParam("max_per_user_monthly", req.MaxPerUserMonthly, 1)
+	if err != nil {
+		return db.UpdateRewardParams{}, err
+	}
+	cooldown, err := limitParam("cooldown_seconds", req.CooldownSeconds, 0)
+	if err != nil {
+		return db.UpdateRewardParams{}, err
+	}
+	pendingTTL, err := limitParam("pending_ttl_seconds", req.PendingTtlSeconds, minPendingTTLSeconds)
+	if err != nil {
+		return db.UpdateRewardParams{}, err
+	}
+	availableFrom, availableUntil, availableDays, err := availabilityParams(req.AvailableFrom, req.AvailableUntil, req.AvailableDays)
+	if err != nil {
+		return db.UpdateRewardParams{}, err
+	}
+	minPoints, cashPrice, err := cashOptionParams(cost, req.MinPoints, req.CashPrice)
+	if err != nil {
+		return db.UpdateRewardParams{}, err
+	}
+	priceRules, err := priceRulesParam(req.PriceRules)
+	if err != nil {
+		return db.UpdateRewardParams{}, err
+	}
+	metadata, err := metadataParams(req.Category, req.Tags, req.ImageUrl, req.Terms, req.PartnerName, req.PartnerUrl, req.PartnerLogoUrl)
+	if err != nil {
+		return db.UpdateRewardParams{}, err
+	}
+	var sortOrder int32
+	if req.SortOrder != nil {
+		sortOrder = int32(*req.SortOrder)
+	}
+	codeSource, err := codeSourceParam(req.CodeSource)
+	if err != nil {
+		return db.UpdateRewardParams{}, err
+	}
+	codeFormat, err := codeFormatParam(req.CodeFormat)
+	if err != nil {
+		return db.UpdateRewardParams{}, err
+	}
+	codeLowThreshold, err := limitParam("code_low_threshold", req.CodeLowThreshold, 0)
+	if err != nil {
+		return db.UpdateRewardParams{}, err
+	}
+	rewardType, creditAmount, err := rewardTypeParam(req.RewardType, req.CreditAmount)
+	if err != nil {
+		return db.UpdateRewardParams{}, err
+	}
+	creditValidDays, err := limitParam("credit_valid_days", req.CreditValidDays, 1)
+	if err != nil {
+		return db.UpdateRewardParams{}, err
+	}
+	adapter, fulfillmentURL, fulfillmentSecret := fulfillmentParams(req.FulfillmentAdapter, req.FulfillmentUrl, req.FulfillmentSecret)
+	if err := validateFulfillment(adapter, fulfillmentURL, fulfillmentSecret); err != nil {
+		return db.UpdateRewardParams{}, err
+	}
+	return db.UpdateRewardParams{
+		ID:                 id,
+		Name:               name,
+		Description:        desc,
+		Cost:               cost,
+		Segment:            segmentRaw,
+		Active:             active,
+		MinTier:            minTier,
+		StockTotal:         stockTotal,
+		DailyStock:         dailyStock,
+		MaxPerUser:         maxPerUser,
+		MaxPerUserMonthly:  maxPerUserMonthly,
+		CooldownSeconds:    cooldown,
+		CodeSource:         codeSource,
+		CodeFormat:         codeFormat,
+		CodeLowThreshold:   codeLowThreshold,
+		RewardType:         rewardType,
+		CreditAmount:       creditAmount,
+		CreditValidDays:    creditValidDays,
+		FulfillmentAdapter: adapter,
+		FulfillmentUrl:     fulfillmentURL,
+		FulfillmentSecret:  fulfillmentSecret,
+		PendingTtlSeconds:  pendingTTL,
+		AvailableFrom:      availableFrom,
+		AvailableUntil:     availableUntil,
+		AvailableDays:      availableDays,
+		MinPoints:          minPoints,
+		CashPrice:          cashPrice,
+		PriceRules:         priceRules,
+		Category:           metadata.category,
+		Tags:               metadata.tags,
+		ImageUrl:           metadata.imageURL,
+		Terms:              metadata.terms,
+		PartnerName:        metadata.partnerName,
+		PartnerUrl:         metadata.partnerURL,
+		PartnerLogoUrl:     metadata.partnerLogoURL,
+		SortOrder:          sortOrder,
+		Featured:           req.Featured != nil && *req.Featured,
+	}, nil
+}
+
+// maxCodeUploadBytes bounds voucher code CSV uploads
+const maxCodeUploadBytes = 10 << 20
+
+// minPendingTTLSeconds is the shortest pending lifetime a reward may set
+const minPendingTTLSeconds = 60
+
+func (s *AdminService) GetRewardsRewardIdCodes(ctx echo.Context, rewardId openapi_types.UUID) error {
+	reward, err := queries.GetReward(ctx.Request().Context(), uuid.UUID(rewardId))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return echo.NewHTTPError(http.StatusNotFound, "Reward not found")
 		}
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update reward")
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to retrieve reward")
 	}
-	var segment map[string]interface{}
-	if reward.Segment.Valid {
-		_ = json.Unmarshal(reward.Segment.RawMessage, &segment)
+	counts, err := queries.CountRewardCodes(ctx.Request().Context(), reward.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to count voucher codes")
+	}
+	return ctx.JSON(http.StatusOK, voucherCodePool(reward, counts))
+}
+
+func (s *AdminService) PostRewardsRewardIdCodes(ctx echo.Context, rewardId openapi_types.UUID) error {
+	reward, err := queries.GetReward(ctx.Request().Context(), uuid.UUID(rewardId))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, "Reward not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to retrieve reward")
 	}
-	costVal := int(reward.Cost)
-	response := Reward{
-		Id:          (*openapi_types.UUID)(&reward.ID),
-		Name:        &reward.Name,
-		Description: &reward.Description.String,
-		Cost:        &costVal,
-		Segment:     &segment,
-		Active:      &reward.Active,
-		CreatedBy:   (*openapi_types.UUID)(&reward.CreatedBy.UUID),
-		CreatedAt:   &reward.CreatedAt,
+	if reward.CodeSource.String != vouchers.SourcePool {
+		return echo.NewHTTPError(http.StatusBadRequest, "Reward does not use a voucher code pool")
 	}
-	return ctx.JSON(http.StatusOK, response)
+	body := http.MaxBytesReader(ctx.Response(), ctx.Request().Body, maxCodeUploadBytes)
+	codes, err := vouchers.ParseCSV(body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid voucher code CSV: "+err.Error())
+	}
+
+	var pool VoucherCodePool
+	err = withTx(ctx.Request().Context(), func(q *db.Queries) error {
+		added := 0
+		for _, code := range codes {
+			n, err := q.AddRewardCode(ctx.Request().Context(), db.AddRewardCodeParams{RewardID: reward.ID, Code: code})
+			if err != nil {
+				return err
+			}
+			added += int(n)
+		}
+		counts, err := q.CountRewardCodes(ctx.Request().Context(), reward.ID)
+		if err != nil {
+			return err
+		}
+		// A pool topped up above its threshold can raise the alert again
+		if !reward.CodeLowThreshold.Valid || counts.Available > reward.CodeLowThreshold.Int32 {
+			if err := q.ClearRewardCodesLow(ctx.Request().Context(), reward.ID); err != nil {
+				return err
+			}
+		}
+		skipped := len(codes) - added
+		pool = voucherCodePool(reward, counts)
+		pool.Added = &added
+		pool.Skipped = &skipped
+		// The codes themselves stay out of the audit log
+		return recordAudit(ctx, q, "reward.codes_uploaded", auditEntityReward, reward.ID, nil, map[string]int{
+			"added":   added,
+			"skipped": skipped,
+		})
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to upload voucher codes")
+	}
+	return ctx.JSON(http.StatusOK, pool)
 }
 
 // Segments endpoints
@@ -487,15 +869,7 @@ func (s *AdminService) GetSegments(ctx echo.Context) error {
 	}
 	var response []Segment
 	for _, segment := range segments {
-		var criteria map[string]interface{}
-		_ = json.Unmarshal(segment.Criteria, &criteria)
-		response = append(response, Segment{
-			Id:        (*openapi_types.UUID)(&segment.ID),
-			Name:      &segment.Name,
-			Criteria:  &criteria,
-			Active:    &segment.Active,
-			CreatedAt: &segment.CreatedAt,
-		})
+		response = append(response, segmentFromDB(segment))
 	}
 	return ctx.JSON(http.StatusOK, response)
 }
@@ -510,27 +884,39 @@ func (s *AdminService) PostSegments(ctx echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid criteria format")
 	}
+	if _, err := segments.Parse(criteriaBytes); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
 	createdBy := uuid.NullUUID{UUID: user.UserID, Valid: true}
-	segment, err := queries.CreateSegment(ctx.Request().Context(), db.CreateSegmentParams{
-		ID:        uuid.New(),
-		Name:      req.Name,
-		Criteria:  criteriaBytes,
-		Active:    true,
-		CreatedBy: createdBy,
+	// Segments can be created inactive and previewed before activation
+	active := true
+	if req.Active != nil {
+		active = *req.Active
+	}
+	materialized := req.Materialized != nil && *req.Materialized
+	var segment db.Segment
+	err = withTx(ctx.Request().Context(), func(q *db.Queries) error {
+		var err error
+		segment, err = q.CreateSegment(ctx.Request().Context(), db.CreateSegmentParams{
+			ID:           uuid.New(),
+			Name:         req.Name,
+			Criteria:     criteriaBytes,
+			Active:       active,
+			Materialized: materialized,
+			CreatedBy:    createdBy,
+		})
+		if err != nil {
+			return err
+		}
+		if err := recordAudit(ctx, q, "segment.created", auditEntitySegment, segment.ID, nil, segment); err != nil {
+			return err
+		}
+		return enqueueSegmentEvent(ctx, q, "created", segment)
 	})
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create segment")
 	}
-	var criteria map[string]interface{}
-	_ = json.Unmarshal(segment.Criteria, &criteria)
-	response := Segment{
-		Id:        (*openapi_types.UUID)(&segment.ID),
-		Name:      &segment.Name,
-		Criteria:  &criteria,
-		Active:    &segment.Active,
-		CreatedAt: &segment.CreatedAt,
-	}
-	return ctx.JSON(http.StatusCreated, response)
+	return ctx.JSON(http.StatusCreated, segmentFromDB(segment))
 }
 
 func (s *AdminService) GetSegmentsSegmentId(ctx echo.Context, segmentId openapi_types.UUID) error {
@@ -541,16 +927,7 @@ func (s *AdminService) GetSegmentsSegmentId(ctx echo.Context, segmentId openapi_
 		}
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to retrieve segment")
 	}
-	var criteria map[string]interface{}
-	_ = json.Unmarshal(segment.Criteria, &criteria)
-	response := Segment{
-		Id:        (*openapi_types.UUID)(&segment.ID),
-		Name:      &segment.Name,
-		Criteria:  &criteria,
-		Active:    &segment.Active,
-		CreatedAt: &segment.CreatedAt,
-	}
-	return ctx.JSON(http.StatusOK, response)
+	return ctx.JSON(http.StatusOK, segmentFromDB(segment))
 }
 
 func (s *AdminService) PutSegmentsSegmentId(ctx echo.Context, segmentId openapi_types.UUID) error {
@@ -569,33 +946,514 @@ func (s *AdminService) PutSegmentsSegmentId(ctx echo.Context, segmentId openapi_
 		if err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, "Invalid criteria format")
 		}
+		if _, err := segments.Parse(criteriaBytes); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
 	}
 	active := true
 	if req.Active != nil {
 		active = *req.Active
 	}
-	segment, err := queries.UpdateSegment(ctx.Request().Context(), db.UpdateSegmentParams{
-		ID:       uuid.UUID(segmentId),
-		Name:     name,
-		Criteria: criteriaBytes,
-		Active:   active,
+	var segment db.Segment
+	err := withTx(ctx.Request().Context(), func(q *db.Queries) error {
+		before, err := q.GetSegment(ctx.Request().Context(), uuid.UUID(segmentId))
+		if err != nil {
+			return err
+		}
+		materialized := before.Materialized
+		if req.Materialized != nil {
+			materialized = *req.Materialized
+		}
+		segment, err = q.UpdateSegment(ctx.Request().Context(), db.UpdateSegmentParams{
+			ID:           uuid.UUID(segmentId),
+			Name:         name,
+			Criteria:     criteriaBytes,
+			Active:       active,
+			Materialized: materialized,
+		})
+		if err != nil {
+			return err
+		}
+		if err := recordAudit(ctx, q, "segment.updated", auditEntitySegment, segment.ID, before, segment); err != nil {
+			return err
+		}
+		return enqueueSegmentEvent(ctx, q, "updated", segment)
 	})
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, sql.ErrNoRows) {
 			return echo.NewHTTPError(http.StatusNotFound, "Segment not found")
 		}
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update segment")
 	}
+	return ctx.JSON(http.StatusOK, segmentFromDB(segment))
+}
+
+// ruleFromDB converts a stored rule to its API representation
+func ruleFromDB(rule db.Rule) Rule {
+	var config map[string]interface{}
+	if err := json.Unmarshal(rule.Config, &config); err != nil {
+		config = nil
+	}
+	version := int(rule.Version)
+	return Rule{
+		Id:          (*openapi_types.UUID)(&rule.ID),
+		Name:        &rule.Name,
+		Description: &rule.Description.String,
+		Config:      &config,
+		Active:      &rule.Active,
+		Version:     &version,
+		CreatedAt:   &rule.CreatedAt,
+		UpdatedAt:   &rule.UpdatedAt,
+	}
+}
+
+// rewardFromDB converts a stored reward to its API representation
+func rewardFromDB(reward db.RewardsCatalog) Reward {
+	var segment map[string]interface{}
+	if reward.Segment.Valid {
+		_ = json.Unmarshal(reward.Segment.RawMessage, &segment)
+	}
+	cost := int(reward.Cost)
+	version := int(reward.Version)
+	var minTier *Tier
+	if reward.MinTier.Valid {
+		t := Tier(reward.MinTier.String)
+		minTier = &t
+	}
+	rewardType := RewardType(reward.RewardType)
+	var creditAmount *int64
+	if reward.CreditAmount.Valid {
+		creditAmount = &reward.CreditAmount.Int64
+	}
+	var cashPrice *int64
+	if reward.CashPrice.Valid {
+		cashPrice = &reward.CashPrice.Int64
+	}
+	var priceRules *[]PriceRule
+	if reward.PriceRules.Valid {
+		_ = json.Unmarshal(reward.PriceRules.RawMessage, &priceRules)
+	}
+	var tags *[]string
+	if reward.Tags.Valid {
+		t := catalog.Tags(reward.Tags.RawMessage)
+		tags = &t
+	}
+	sortOrder := int(reward.SortOrder)
+	var fulfillmentAdapter *FulfillmentAdapter
+	if reward.FulfillmentAdapter.Valid {
+		a := FulfillmentAdapter(reward.FulfillmentAdapter.String)
+		fulfillmentAdapter = &a
+	}
+	var codeSource *CodeSource
+	if reward.CodeSource.Valid {
+		c := CodeSource(reward.CodeSource.String)
+		codeSource = &c
+	}
+	stockRedeemed := int(reward.StockRedeemed)
+	stockRemaining, _ := inventory.Remaining(reward, 0)
+	availableNow := reward.Active && availability.Available(reward, time.Now())
+	return Reward{
+		Id:                 (*openapi_types.UUID)(&reward.ID),
+		Name:               &reward.Name,
+		Description:        &reward.Description.String,
+		Cost:               &cost,
+		Segment:            &segment,
+		MinTier:            minTier,
+		RewardType:         &rewardType,
+		CreditAmount:       creditAmount,
+		CreditValidDays:    intPtr(reward.CreditValidDays),
+		StockTotal:         intPtr(reward.StockTotal),
+		StockRedeemed:      &stockRedeemed,
+		StockRemaining:     int32Ptr(stockRemaining),
+		DailyStock:         intPtr(reward.DailyStock),
+		MaxPerUser:         intPtr(reward.MaxPerUser),
+		MaxPerUserMonthly:  intPtr(reward.MaxPerUserMonthly),
+		CooldownSeconds:    intPtr(reward.CooldownSeconds),
+		PendingTtlSeconds:  intPtr(reward.PendingTtlSeconds),
+		AvailableFrom:      nullTimePtr(reward.AvailableFrom),
+		AvailableUntil:     nullTimePtr(reward.AvailableUntil),
+		AvailableDays:      weekdays(reward.AvailableDays),
+		AvailableNow:       &availableNow,
+		MinPoints:          intPtr(reward.MinPoints),
+		CashPrice:          cashPrice,
+		PriceRules:         priceRules,
+		Category:           nullStringPtr(reward.Category),
+		Tags:               tags,
+		ImageUrl:           nullStringPtr(reward.ImageUrl),
+		Terms:              nullStringPtr(reward.Terms),
+		PartnerName:        nullStringPtr(reward.PartnerName),
+		PartnerUrl:         nullStringPtr(reward.PartnerUrl),
+		PartnerLogoUrl:     nullStringPtr(reward.PartnerLogoUrl),
+		SortOrder:          &sortOrder,
+		Featured:           &reward.Featured,
+		CodeSource:         codeSource,
+		CodeFormat:         nullStringPtr(reward.CodeFormat),
+		CodeLowThreshold:   intPtr(reward.CodeLowThreshold),
+		FulfillmentAdapter: fulfillmentAdapter,
+		FulfillmentUrl:     nullStringPtr(reward.FulfillmentUrl),
+		Active:             &reward.Active,
+		Version:            &version,
+		CreatedBy:          (*openapi_types.UUID)(&reward.CreatedBy.UUID),
+		CreatedAt:          &reward.CreatedAt,
+	}
+}
+
+// rewardWithStock converts a stored reward to its API representation,
+// including the units left today
+func rewardWithStock(reward db.RewardsCatalog, redeemedToday int32) Reward {
+	r := rewardFromDB(reward)
+	_, today := inventory.Remaining(reward, redeemedToday)
+	r.DailyStockRemaining = int32Ptr(today)
+	return r
+}
+
+// stockParam validates a stock limit; nil means unlimited
+func stockParam(field string, n *int) (sql.NullInt32, error) {
+	return limitParam(field, n, 0)
+}
+
+// limitParam validates an optional limit of at least min; nil means no limit
+func limitParam(field string, n *int, min int) (sql.NullInt32, error) {
+	if n == nil {
+		return sql.NullInt32{}, nil
+	}
+	if *n < min {
+		if min == 0 {
+			return sql.NullInt32{}, echo.NewHTTPError(http.StatusBadRequest, field+" must not be negative")
+		}
+		return sql.NullInt32{}, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s must be at least %d", field, min))
+	}
+	return sql.NullInt32{Int32: int32(*n), Valid: true}, nil
+}
+
+// intPtr returns a nullable integer column as an API value
+func intPtr(n sql.NullInt32) *int {
+	if !n.Valid {
+		return nil
+	}
+	v := int(n.Int32)
+	return &v
+}
+
+// nullStringPtr returns a nullable text column as an API value
+func nullStringPtr(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
+
+// nullTimePtr returns a nullable timestamp column as an API value
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// int32Ptr converts an optional count to an API value
+func int32Ptr(n *int32) *int {
+	if n == nil {
+		return nil
+	}
+	v := int(*n)
+	return &v
+}
+
+// voucherCodePool converts a reward's code counts to their API representation
+func voucherCodePool(reward db.RewardsCatalog, counts db.CountRewardCodesRow) VoucherCodePool {
+	total := int(counts.Total)
+	available := int(counts.Available)
+	issued := total - available
+	return VoucherCodePool{
+		RewardId:     (*openapi_types.UUID)(&reward.ID),
+		Total:        &total,
+		Available:    &available,
+		Issued:       &issued,
+		LowThreshold: intPtr(reward.CodeLowThreshold),
+	}
+}
+
+// availabilityParams validates a reward's availability window and weekdays;
+// nil values leave the reward unscheduled
+func availabilityParams(from, until *time.Time, days *[]Weekday) (sql.NullTime, sql.NullTime, sql.NullInt32, error) {
+	var availableFrom, availableUntil sql.NullTime
+	if from != nil {
+		availableFrom = sql.NullTime{Time: *from, Valid: true}
+	}
+	if until != nil {
+		availableUntil = sql.NullTime{Time: *until, Valid: true}
+	}
+	if from != nil && until != nil && !until.After(*from) {
+		return availableFrom, availableUntil, sql.NullInt32{}, echo.NewHTTPError(http.StatusBadRequest, "available_until must be after available_from")
+	}
+	var availableDays sql.NullInt32
+	if days != nil {
+		names := make([]string, 0, len(*days))
+		for _, day := range *days {
+			names = append(names, string(day))
+		}
+		mask, err := availability.ParseDays(names)
+		if err != nil {
+			return availableFrom, availableUntil, availableDays, echo.NewHTTPError(http.StatusBadRequest, "Invalid available_days: "+err.Error())
+		}
+		availableDays = sql.NullInt32{Int32: mask, Valid: true}
+	}
+	return availableFrom, availableUntil, availableDays, nil
+}
+
+// weekdays returns a reward's available_days as an API value
+func weekdays(mask sql.NullInt32) *[]Weekday {
+	if !mask.Valid {
+		return nil
+	}
+	days := []Weekday{}
+	for _, name := range availability.FormatDays(mask.Int32) {
+		days = append(days, Weekday(name))
+	}
+	return &days
+}
+
+// rewardTypeParam validates a reward's type and the credit it issues,
+// defaulting to standard
+func rewardTypeParam(t *RewardType, amount *int64) (string, sql.NullInt64, error) {
+	rewardType := credits.RewardTypeStandard
+	if t != nil && *t != "" {
+		rewardType = string(*t)
+	}
+	var creditAmount sql.NullInt64
+	if amount != nil {
+		creditAmount = sql.NullInt64{Int64: *amount, Valid: true}
+	}
+	if err := credits.ValidateReward(rewardType, creditAmount); err != nil {
+		return "", sql.NullInt64{}, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if creditAmount.Valid && creditAmount.Int64 < 1 {
+		return "", sql.NullInt64{}, echo.NewHTTPError(http.StatusBadRequest, "credit_amount must be at least 1")
+	}
+	return rewardType, creditAmount, nil
+}
+
+// cashOptionParams validates a reward's points + cash option: min_points and
+// cash_price are set together, and the points part is less than cost
+func cashOptionParams(cost int32, minPoints *int, cashPrice *int64) (sql.NullInt32, sql.NullInt64, error) {
+	if minPoints == nil && cashPrice == nil {
+		return sql.NullInt32{}, sql.NullInt64{}, nil
+	}
+	if minPoints == nil || cashPrice == nil {
+		return sql.NullInt32{}, sql.NullInt64{}, echo.NewHTTPError(http.StatusBadRequest, "min_points and cash_price must be set together")
+	}
+	if *minPoints < 0 || int64(*minPoints) >= int64(cost) {
+		return sql.NullInt32{}, sql.NullInt64{}, echo.NewHTTPError(http.StatusBadRequest, "min_points must be at least 0 and less than cost")
+	}
+	if *cashPrice < 1 {
+		return sql.NullInt32{}, sql.NullInt64{}, echo.NewHTTPError(http.StatusBadRequest, "cash_price must be at least 1")
+	}
+	return sql.NullInt32{Int32: int32(*minPoints), Valid: true}, sql.NullInt64{Int64: *cashPrice, Valid: true}, nil
+}
+
+// priceRulesParam validates a reward's price rules; null or an empty list
+// clears them
+func priceRulesParam(rules *[]PriceRule) (pqtype.NullRawMessage, error) {
+	if rules == nil || len(*rules) == 0 {
+		return pqtype.NullRawMessage{}, nil
+	}
+	b, err := json.Marshal(rules)
+	if err != nil {
+		return pqtype.NullRawMessage{}, err
+	}
+	if _, err := pricing.Parse(b); err != nil {
+		return pqtype.NullRawMessage{}, echo.NewHTTPError(http.StatusBadRequest, "Invalid price_rules: "+err.Error())
+	}
+	return pqtype.NullRawMessage{RawMessage: b, Valid: true}, nil
+}
+
+// rewardMetadata is a reward's catalog metadata, as stored
+type rewardMetadata struct {
+	category       sql.NullString
+	tags           pqtype.NullRawMessage
+	imageURL       sql.NullString
+	terms          sql.NullString
+	partnerName    sql.NullString
+	partnerURL     sql.NullString
+	partnerLogoURL sql.NullString
+}
+
+// metadataParams validates a reward's catalog metadata: the category and tags
+// are normalized to lowercase slugs and URLs must be http or https. Empty
+// values clear them.
+func metadataParams(category *string, tags *[]string, imageURL, terms, partnerName, partnerURL, partnerLogoURL *string) (rewardMetadata, error) {
+	optional := func(s *string) sql.NullString {
+		if s == nil || strings.TrimSpace(*s) == "" {
+			return sql.NullString{}
+		}
+		return sql.NullString{String: strings.TrimSpace(*s), Valid: true}
+	}
+	m := rewardMetadata{
+		category:       optional(category),
+		imageURL:       optional(imageURL),
+		terms:          optional(terms),
+		partnerName:    optional(partnerName),
+		partnerURL:     optional(partnerURL),
+		partnerLogoURL: optional(partnerLogoURL),
+	}
+	if m.category.Valid {
+		slug, err := catalog.Normalize(m.category.String)
+		if err != nil {
+			return rewardMetadata{}, echo.NewHTTPError(http.StatusBadRequest, "Invalid category: "+err.Error())
+		}
+		m.category.String = slug
+	}
+	if tags != nil && len(*tags) > 0 {
+		normalized, err := catalog.NormalizeTags(*tags)
+		if err != nil {
+			return rewardMetadata{}, echo.NewHTTPError(http.StatusBadRequest, "Invalid tags: "+err.Error())
+		}
+		b, err := json.Marshal(normalized)
+		if err != nil {
+			return rewardMetadata{}, err
+		}
+		m.tags = pqtype.NullRawMessage{RawMessage: b, Valid: true}
+	}
+	urls := []struct {
+		field string
+		url   sql.NullString
+	}{{"image_url", m.imageURL}, {"partner_url", m.partnerURL}, {"partner_logo_url", m.partnerLogoURL}}
+	for _, u := range urls {
+		if !u.url.Valid {
+			continue
+		}
+		if err := catalog.ValidateURL(u.url.String); err != nil {
+			return rewardMetadata{}, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid %s: %s", u.field, err))
+		}
+	}
+	if !m.partnerName.Valid && (m.partnerURL.Valid || m.partnerLogoURL.Valid) {
+		return rewardMetadata{}, echo.NewHTTPError(http.StatusBadRequest, "partner_url and partner_logo_url require partner_name")
+	}
+	return m, nil
+}
+
+// fulfillmentParams converts a reward's fulfillment settings; empty values
+// clear them
+func fulfillmentParams(adapter *FulfillmentAdapter, url, secret *string) (sql.NullString, sql.NullString, sql.NullString) {
+	optional := func(s *string) sql.NullString {
+		if s == nil || *s == "" {
+			return sql.NullString{}
+		}
+		return sql.NullString{String: *s, Valid: true}
+	}
+	var a sql.NullString
+	if adapter != nil && *adapter != "" {
+		a = sql.NullString{String: string(*adapter), Valid: true}
+	}
+	return a, optional(url), optional(secret)
+}
+
+// validateFulfillment checks a reward's fulfillment settings
+func validateFulfillment(adapter, url, secret sql.NullString) error {
+	if err := fulfillment.ValidateReward(adapter, url, secret); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return nil
+}
+
+// codeSourceParam validates a reward's voucher code source
+func codeSourceParam(c *CodeSource) (sql.NullString, error) {
+	if c == nil || *c == "" {
+		return sql.NullString{}, nil
+	}
+	if err := vouchers.ValidateSource(string(*c)); err != nil {
+		return sql.NullString{}, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return sql.NullString{String: string(*c), Valid: true}, nil
+}
+
+// codeFormatParam validates the format of a reward's generated codes
+func codeFormatParam(format *string) (sql.NullString, error) {
+	if format == nil || *format == "" {
+		return sql.NullString{}, nil
+	}
+	if err := vouchers.ValidateFormat(*format); err != nil {
+		return sql.NullString{}, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return sql.NullString{String: *format, Valid: true}, nil
+}
+
+// minTierParam validates a reward's minimum loyalty tier
+func minTierParam(t *Tier) (sql.NullString, error) {
+	if t == nil || *t == "" {
+		return sql.NullString{}, nil
+	}
+	if !tiers.Valid(string(*t)) {
+		return sql.NullString{}, echo.NewHTTPError(http.StatusBadRequest, "min_tier must be one of silver, gold or platinum")
+	}
+	return sql.NullString{String: string(*t), Valid: true}, nil
+}
+
+// segmentFromDB converts a stored segment to its API representation
+func segmentFromDB(segment db.Segment) Segment {
 	var criteria map[string]interface{}
 	_ = json.Unmarshal(segment.Criteria, &criteria)
-	response := Segment{
-		Id:        (*openapi_types.UUID)(&segment.ID),
-		Name:      &segment.Name,
-		Criteria:  &criteria,
-		Active:    &segment.Active,
-		CreatedAt: &segment.CreatedAt,
+	version := int(segment.Version)
+	var materializedAt *time.Time
+	if segment.MaterializedAt.Valid {
+		materializedAt = &segment.MaterializedAt.Time
+	}
+	return Segment{
+		Id:             (*openapi_types.UUID)(&segment.ID),
+		Name:           &segment.Name,
+		Criteria:       &criteria,
+		Active:         &segment.Active,
+		Materialized:   &segment.Materialized,
+		MaterializedAt: materializedAt,
+		Version:        &version,
+		CreatedAt:      &segment.CreatedAt,
 	}
-	return ctx.JSON(http.StatusOK, response)
+}
+
+// Adjustments endpoints
+func (s *AdminService) PostAdjustments(ctx echo.Context) error {
+	var req PostAdjustmentsJSONBody
+	if err := ctx.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	if req.Points == 0 || req.Reason == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Points and reason are required")
+	}
+	user := ctx.Get("user").(*Claims)
+	meta, err := json.Marshal(map[string]interface{}{
+		"reason":      req.Reason,
+		"approved_by": user.UserID,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to record adjustment")
+	}
+	var event db.PointsEvent
+	err = withTx(ctx.Request().Context(), func(q *db.Queries) error {
+		var err error
+		event, err = q.CreatePointsEvent(ctx.Request().Context(), db.CreatePointsEventParams{
+			UserID:    uuid.UUID(req.UserId),
+			EventType: "MANUAL_ADJUST",
+			Points:    int32(req.Points),
+			Meta:      pqtype.NullRawMessage{RawMessage: meta, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+		return recordAudit(ctx, q, "adjustment.created", auditEntityAdjustment, event.ID, nil, event)
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to record adjustment")
+	}
+	points := int(event.Points)
+	response := PointsAdjustment{
+		Id:        (*openapi_types.UUID)(&event.ID),
+		UserId:    (*openapi_types.UUID)(&event.UserID),
+		Points:    &points,
+		Reason:    &req.Reason,
+		CreatedAt: &event.OccurredAt,
+	}
+	return ctx.JSON(http.StatusCreated, response)
 }
 
 // SwaggerUIResponse represents the response for Swagger UI
@@ -635,35 +1493,69 @@ func OpenAPISpecHandler(ctx context.Context) (map[string]interface{}, error) {
 	return spec, nil
 }
 
-// initService initializes the admin service
-func initService() {
-	// Create Echo server
-	e := echo.New()
-
-	// Add middleware
-	e.Use(jwtMiddleware)
-	e.Use(requireProductAdmin)
-
-	// Create admin service
-	adminService := &AdminService{}
+// Admin serves the admin API
+//
+//encore:api public raw path=/admin/*path
+func (s *Service) Admin(w http.ResponseWriter, req *http.Request) {
+	s.router.ServeHTTP(w, req)
+}
 
-	// Register handlers
-	RegisterHandlers(e, adminService)
+// initService loads the admin token verifier and registers the admin routes,
+// each guarded by the permissions admin.yaml lists for it. It fails, and the
+// service does not start, when no JWT verification keys are configured or a
+// route has no permissions. It starts the outbox relays publishing rule,
+// reward and segment change events.
+func initService() (*Service, error) {
+	// Load JWT verification keys (HS256 secret, RS256 public key or JWKS file)
+	v, err := auth.NewVerifier(auth.ConfigFromEnv())
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize JWT verifier: %w", err)
+	}
+	router, err := newRouter(v, specYAML)
+	if err != nil {
+		return nil, err
+	}
+	startRelays()
+	initFeatureFlags()
+	return &Service{router: router}, nil
+}
 
-	// Start server
-	go func() {
-		if err := e.Start(":8080"); err != nil {
-			// Remove encore.Log.Error("Failed to start admin service", "error", err)
-		}
-	}()
+// newRouter creates the echo router serving the admin routes under
+// adminBasePath, authenticated with tokens checked by v and authorized with
+// the operation permissions of spec
+func newRouter(v *auth.Verifier, spec []byte) (*echo.Echo, error) {
+	e := echo.New()
+	e.Use(requestIDMiddleware)
+	e.Use(jwtMiddleware(v))
 
-	// Remove encore.Log.Info("Admin service started on port 8080")
+	// Register handlers, each guarded by the permissions admin.yaml lists
+	// for its operation; a route without them is refused at startup
+	permissions, err := loadOperationPermissions(spec)
+	if err != nil {
+		return nil, err
+	}
+	router := &rbacRouter{EchoRouter: e.Group(adminBasePath), permissions: permissions}
+	RegisterHandlers(router, &AdminService{})
+	if len(router.unmapped) > 0 {
+		return nil, fmt.Errorf("admin: no permissions configured for %s", strings.Join(router.unmapped, ", "))
+	}
+	return e, nil
+}
 
-	// Initialize go-feature-flag
+// initFeatureFlags loads the go-feature-flag flags from the YAML file
+// GOFF_BACKEND_YAML_PATH names. Without it flags are not loaded and evaluate
+// to their defaults.
+func initFeatureFlags() {
+	path := os.Getenv("GOFF_BACKEND_YAML_PATH")
+	if path == "" {
+		log.Printf("admin: feature flags disabled: GOFF_BACKEND_YAML_PATH is not set")
+		return
+	}
 	err := ffclient.Init(ffclient.Config{
 		PollingInterval: 3 * time.Second,
+		Retriever:       &fileretriever.Retriever{Path: path},
 	})
 	if err != nil {
-		panic("failed to initialize go-feature-flag: " + err.Error())
+		log.Printf("admin: feature flags disabled: %v", err)
 	}
 }
@@ -0,0 +1,81 @@
+//go:build !encore
+// +build !encore
+
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"encore.app/internal/auth"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSecret is the HS256 key admin tokens are signed with in tests
+const testSecret = "admin-test-secret-0123456789"
+
+// startService starts the admin service the way Encore does, verifying
+// tokens signed with testSecret
+func startService(t *testing.T) *Service {
+	t.Setenv("JWT_SECRET", testSecret)
+	t.Setenv("JWT_ISSUER", "")
+	t.Setenv("JWT_AUDIENCE", "")
+	svc, err := initService()
+	require.NoError(t, err)
+	return svc
+}
+
+// adminToken returns a token for an admin user holding roles
+func adminToken(t *testing.T, roles ...string) string {
+	signer, err := auth.NewSigner(auth.Config{HMACSecret: []byte(testSecret)})
+	require.NoError(t, err)
+	token, err := signer.Sign(auth.Claims{
+		UserID:    uuid.NewString(),
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		Roles:     roles,
+	})
+	require.NoError(t, err)
+	return token
+}
+
+// serve sends a request to svc's admin endpoint and returns the response
+// status
+func serve(svc *Service, method, path, token string) int {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	svc.Admin(rec, req)
+	return rec.Code
+}
+
+func TestInitService(t *testing.T) {
+	svc := startService(t)
+
+	assert.Equal(t, http.StatusOK, serve(svc, http.MethodGet, "/admin/health", adminToken(t)))
+	assert.Equal(t, http.StatusUnauthorized, serve(svc, http.MethodGet, "/admin/health", ""))
+	assert.Equal(t, http.StatusUnauthorized, serve(svc, http.MethodGet, "/admin/health", "not-a-token"))
+	assert.Equal(t, http.StatusNotFound, serve(svc, http.MethodGet, "/admin/unknown", adminToken(t)))
+}
+
+func TestInitService_NoKeys(t *testing.T) {
+	for _, name := range []string{"JWT_SECRET", "JWT_PUBLIC_KEY_FILE", "JWT_JWKS_FILE"} {
+		t.Setenv(name, "")
+	}
+	_, err := initService()
+	assert.Error(t, err)
+}
+
+func TestJWTMiddleware_NoVerifier(t *testing.T) {
+	router, err := newRouter(nil, specYAML)
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/health", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
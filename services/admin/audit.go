@@ -0,0 +1,186 @@
+package admin
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+
+	"encore.app/internal/audit"
+	"encore.app/internal/db"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	openapi_types "github.com/oapi-codegen/runtime/types"
+	"github.com/sqlc-dev/pqtype"
+)
+
+// Audited entity types
+const (
+	auditEntityRule       = "rule"
+	auditEntityReward     = "reward"
+	auditEntitySegment    = "segment"
+	auditEntityAdjustment = "adjustment"
+)
+
+// Audit log paging defaults
+const (
+	defaultAuditLogLimit = 50
+	maxAuditLogLimit     = 500
+)
+
+// requestIDHeader carries the request ID used to correlate audit entries with logs
+const requestIDHeader = "X-Request-Id"
+
+// requestIDMiddleware propagates the caller's request ID or assigns a new one
+func requestIDMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id := c.Request().Header.Get(requestIDHeader)
+		if id == "" {
+			id = uuid.NewString()
+		}
+		c.Set("request_id", id)
+		c.Response().Header().Set(requestIDHeader, id)
+		return next(c)
+	}
+}
+
+// recordAudit writes an audit entry for a mutation of the given entity. before
+// is nil for creations and after is nil for deletions. Callers pass the
+// transaction-bound queries so the entry commits together with the change.
+func recordAudit(c echo.Context, q *db.Queries, action, entityType string, entityID uuid.UUID, before, after interface{}) error {
+	diff, err := audit.Diff(before, after)
+	if err != nil {
+		return err
+	}
+	beforeJSON, err := audit.Marshal(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := audit.Marshal(after)
+	if err != nil {
+		return err
+	}
+	diffJSON, err := json.Marshal(diff)
+	if err != nil {
+		return err
+	}
+
+	params := db.CreateAuditLogEntryParams{
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   uuid.NullUUID{UUID: entityID, Valid: entityID != uuid.Nil},
+		Before:     pqtype.NullRawMessage{RawMessage: beforeJSON, Valid: beforeJSON != nil},
+		After:      pqtype.NullRawMessage{RawMessage: afterJSON, Valid: afterJSON != nil},
+		Diff:       pqtype.NullRawMessage{RawMessage: diffJSON, Valid: true},
+		Ip:         sql.NullString{String: c.RealIP(), Valid: c.RealIP() != ""},
+	}
+	if user, ok := c.Get("user").(*Claims); ok {
+		params.ActorID = uuid.NullUUID{UUID: user.UserID, Valid: true}
+		params.ActorEmail = sql.NullString{String: user.Email, Valid: user.Email != ""}
+	}
+	if id, ok := c.Get("request_id").(string); ok {
+		params.RequestID = sql.NullString{String: id, Valid: id != ""}
+	}
+
+	_, err = q.CreateAuditLogEntry(c.Request().Context(), params)
+	return err
+}
+
+// auditReward returns reward as recorded in the audit log: the fulfillment
+// secret is replaced by a fingerprint, so rotations show in the diff without
+// exposing the key
+func auditReward(reward db.RewardsCatalog) db.RewardsCatalog {
+	if reward.FulfillmentSecret.Valid {
+		sum := sha256.Sum256([]byte(reward.FulfillmentSecret.String))
+		reward.FulfillmentSecret.String = "sha256:" + hex.EncodeToString(sum[:4])
+	}
+	return reward
+}
+
+// Audit log endpoint
+func (s *AdminService) GetAuditLog(ctx echo.Context, params GetAuditLogParams) error {
+	args := db.ListAuditLogParams{
+		RowLimit:  defaultAuditLogLimit,
+		RowOffset: 0,
+	}
+	if params.ActorId != nil {
+		args.ActorID = uuid.NullUUID{UUID: uuid.UUID(*params.ActorId), Valid: true}
+	}
+	if params.EntityType != nil {
+		args.EntityType = sql.NullString{String: *params.EntityType, Valid: true}
+	}
+	if params.EntityId != nil {
+		args.EntityID = uuid.NullUUID{UUID: uuid.UUID(*params.EntityId), Valid: true}
+	}
+	if params.Action != nil {
+		args.Action = sql.NullString{String: *params.Action, Valid: true}
+	}
+	if params.Since != nil {
+		args.Since = sql.NullTime{Time: *params.Since, Valid: true}
+	}
+	if params.Until != nil {
+		args.Until = sql.NullTime{Time: *params.Until, Valid: true}
+	}
+	if params.Limit != nil {
+		if *params.Limit < 1 || *params.Limit > maxAuditLogLimit {
+			return echo.NewHTTPError(http.StatusBadRequest, "Limit must be between 1 and 500")
+		}
+		args.RowLimit = int32(*params.Limit)
+	}
+	if params.Offset != nil {
+		if *params.Offset < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "Offset must not be negative")
+		}
+		args.RowOffset = int32(*params.Offset)
+	}
+
+	entries, err := queries.ListAuditLog(ctx.Request().Context(), args)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to retrieve audit log")
+	}
+	response := []AuditLogEntry{}
+	for _, entry := range entries {
+		response = append(response, toAuditLogEntry(entry))
+	}
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// toAuditLogEntry converts a stored audit entry to its API representation
+func toAuditLogEntry(entry db.AdminAuditLog) AuditLogEntry {
+	var before, after, diff map[string]interface{}
+	if entry.Before.Valid {
+		_ = json.Unmarshal(entry.Before.RawMessage, &before)
+	}
+	if entry.After.Valid {
+		_ = json.Unmarshal(entry.After.RawMessage, &after)
+	}
+	if entry.Diff.Valid {
+		_ = json.Unmarshal(entry.Diff.RawMessage, &diff)
+	}
+	result := AuditLogEntry{
+		Id:         (*openapi_types.UUID)(&entry.ID),
+		Action:     &entry.Action,
+		EntityType: &entry.EntityType,
+		Before:     &before,
+		After:      &after,
+		Diff:       &diff,
+		CreatedAt:  &entry.CreatedAt,
+	}
+	if entry.ActorID.Valid {
+		result.ActorId = (*openapi_types.UUID)(&entry.ActorID.UUID)
+	}
+	if entry.ActorEmail.Valid {
+		result.ActorEmail = &entry.ActorEmail.String
+	}
+	if entry.EntityID.Valid {
+		result.EntityId = (*openapi_types.UUID)(&entry.EntityID.UUID)
+	}
+	if entry.RequestID.Valid {
+		result.RequestId = &entry.RequestID.String
+	}
+	if entry.Ip.Valid {
+		result.Ip = &entry.Ip.String
+	}
+	return result
+}
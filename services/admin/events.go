@@ -0,0 +1,68 @@
+package admin
+
+import (
+	"time"
+
+	"encore.app/internal/db"
+	"encore.app/internal/outbox"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// Change events are written to the outbox in the mutation's transaction and
+// published by the outbox relays once it commits, so consumers never see a
+// change that was rolled back and never miss one that committed. Each event's
+// ordering key is its entity's ID, so an entity's versions are published in
+// order.
+
+// enqueueRuleEvent enqueues a rule change at the given version
+func enqueueRuleEvent(c echo.Context, q outbox.Enqueuer, action string, rule db.Rule, version int32) error {
+	state := ruleFromDB(rule)
+	v := int(version)
+	state.Version = &v
+	return outbox.Enqueue(c.Request().Context(), q, ruleOutboxTopic, rule.ID.String(), &RuleUpdateEvent{
+		RuleID:    rule.ID,
+		Action:    action,
+		RuleName:  rule.Name,
+		Version:   version,
+		Rule:      &state,
+		UpdatedBy: actorID(c),
+		Timestamp: time.Now(),
+	})
+}
+
+// enqueueRewardEvent enqueues a reward change
+func enqueueRewardEvent(c echo.Context, q outbox.Enqueuer, action string, reward db.RewardsCatalog) error {
+	state := rewardFromDB(reward)
+	return outbox.Enqueue(c.Request().Context(), q, rewardOutboxTopic, reward.ID.String(), &RewardUpdateEvent{
+		RewardID:   reward.ID,
+		Action:     action,
+		RewardName: reward.Name,
+		Version:    reward.Version,
+		Reward:     &state,
+		UpdatedBy:  actorID(c),
+		Timestamp:  time.Now(),
+	})
+}
+
+// enqueueSegmentEvent enqueues a segment change
+func enqueueSegmentEvent(c echo.Context, q outbox.Enqueuer, action string, segment db.Segment) error {
+	state := segmentFromDB(segment)
+	return outbox.Enqueue(c.Request().Context(), q, segmentOutboxTopic, segment.ID.String(), &SegmentUpdateEvent{
+		SegmentID:   segment.ID,
+		Action:      action,
+		SegmentName: segment.Name,
+		Version:     segment.Version,
+		Segment:     &state,
+		UpdatedBy:   actorID(c),
+		Timestamp:   time.Now(),
+	})
+}
+
+// actorID returns the authenticated admin's ID, or uuid.Nil
+func actorID(c echo.Context) uuid.UUID {
+	if user, ok := c.Get("user").(*Claims); ok {
+		return user.UserID
+	}
+	return uuid.Nil
+}
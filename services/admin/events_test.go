@@ -0,0 +1,137 @@
+//go:build !encore
+// +build !encore
+
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"encore.app/internal/db"
+	"encore.app/internal/inventory"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeOutbox records the events enqueued on it
+type fakeOutbox struct {
+	events []db.EnqueueOutboxEventParams
+}
+
+func (f *fakeOutbox) EnqueueOutboxEvent(ctx context.Context, arg db.EnqueueOutboxEventParams) (db.EventOutbox, error) {
+	f.events = append(f.events, arg)
+	return db.EventOutbox{ID: int64(len(f.events)), Topic: arg.Topic, OrderingKey: arg.OrderingKey, Payload: arg.Payload}, nil
+}
+
+// adminContext returns an echo context authenticated as adminID
+func adminContext(adminID uuid.UUID) echo.Context {
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/", nil), httptest.NewRecorder())
+	c.Set("user", &Claims{UserID: adminID})
+	return c
+}
+
+func TestEnqueueRuleEvent(t *testing.T) {
+	adminID := uuid.New()
+	rule := db.Rule{ID: uuid.New(), Name: "Weekend bonus", Config: []byte(`{"multiplier":2}`), Active: true, Version: 3}
+	q := &fakeOutbox{}
+	require.NoError(t, enqueueRuleEvent(adminContext(adminID), q, "deleted", rule, rule.Version+1))
+
+	require.Len(t, q.events, 1)
+	assert.Equal(t, ruleOutboxTopic, q.events[0].Topic)
+	assert.Equal(t, rule.ID.String(), q.events[0].OrderingKey)
+	var event RuleUpdateEvent
+	require.NoError(t, json.Unmarshal(q.events[0].Payload, &event))
+	assert.Equal(t, "deleted", event.Action)
+	assert.Equal(t, int32(4), event.Version)
+	assert.Equal(t, 4, *event.Rule.Version)
+	assert.Equal(t, "Weekend bonus", event.RuleName)
+	assert.Equal(t, adminID, event.UpdatedBy)
+}
+
+func TestEnqueueRewardEvent(t *testing.T) {
+	adminID := uuid.New()
+	reward := db.RewardsCatalog{ID: uuid.New(), Name: "Free Coffee", Cost: 300, Active: true, Version: 2}
+	q := &fakeOutbox{}
+	require.NoError(t, enqueueRewardEvent(adminContext(adminID), q, "updated", reward))
+
+	require.Len(t, q.events, 1)
+	assert.Equal(t, rewardOutboxTopic, q.events[0].Topic)
+	assert.Equal(t, reward.ID.String(), q.events[0].OrderingKey)
+	var event RewardUpdateEvent
+	require.NoError(t, json.Unmarshal(q.events[0].Payload, &event))
+	assert.Equal(t, "updated", event.Action)
+	assert.Equal(t, int32(2), event.Version)
+	assert.Equal(t, 300, *event.Reward.Cost)
+	assert.Equal(t, adminID, event.UpdatedBy)
+}
+
+func TestEnqueueSegmentEvent(t *testing.T) {
+	segment := db.Segment{ID: uuid.New(), Name: "Power users", Criteria: []byte(`{}`), Active: true, Version: 1}
+	q := &fakeOutbox{}
+	require.NoError(t, enqueueSegmentEvent(adminContext(uuid.New()), q, "created", segment))
+
+	require.Len(t, q.events, 1)
+	assert.Equal(t, segmentOutboxTopic, q.events[0].Topic)
+	assert.Equal(t, segment.ID.String(), q.events[0].OrderingKey)
+	var event SegmentUpdateEvent
+	require.NoError(t, json.Unmarshal(q.events[0].Payload, &event))
+	assert.Equal(t, "created", event.Action)
+	assert.Equal(t, "Power users", event.SegmentName)
+}
+
+// recordingRewardTopic records the reward events published to it
+type recordingRewardTopic struct {
+	published []*RewardUpdateEvent
+}
+
+func (t *recordingRewardTopic) Publish(ctx context.Context, msg *RewardUpdateEvent) (string, error) {
+	t.published = append(t.published, msg)
+	return "", nil
+}
+
+func TestRewardPublisher(t *testing.T) {
+	ctx := context.Background()
+	topic := &recordingRewardTopic{}
+	publish := rewardPublisher(topic)
+
+	// Admin API changes are published as written
+	adminID := uuid.New()
+	q := &fakeOutbox{}
+	updated := db.RewardsCatalog{ID: uuid.New(), Name: "Free Coffee", Cost: 300, Active: true, Version: 2}
+	require.NoError(t, enqueueRewardEvent(adminContext(adminID), q, "updated", updated))
+
+	// A reward sold out by a redemption carries its rendered state
+	soldOut := db.RewardsCatalog{
+		ID: uuid.New(), Name: "Coupon", Cost: 100, Version: 5,
+		StockTotal: sql.NullInt32{Int32: 10, Valid: true}, StockRedeemed: 10,
+	}
+	payload, err := json.Marshal(&inventory.SoldOut{
+		RewardID: soldOut.ID, Action: "updated", RewardName: soldOut.Name, Version: soldOut.Version,
+		Timestamp: time.Now(), Snapshot: soldOut,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, publish(ctx, q.events[0].Payload))
+	require.NoError(t, publish(ctx, payload))
+	require.Len(t, topic.published, 2)
+
+	assert.Equal(t, adminID, topic.published[0].UpdatedBy)
+	assert.True(t, *topic.published[0].Reward.Active)
+
+	event := topic.published[1]
+	assert.Equal(t, soldOut.ID, event.RewardID)
+	assert.Equal(t, "updated", event.Action)
+	assert.Equal(t, int32(5), event.Version)
+	assert.Equal(t, uuid.Nil, event.UpdatedBy)
+	require.NotNil(t, event.Reward)
+	assert.False(t, *event.Reward.Active)
+	assert.Equal(t, 5, *event.Reward.Version)
+	assert.Equal(t, 0, *event.Reward.StockRemaining)
+}
@@ -0,0 +1,71 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"encore.app/internal/db"
+	"encore.app/internal/inventory"
+	"encore.app/internal/outbox"
+)
+
+// Outbox topics of the admin service's change events
+const (
+	ruleOutboxTopic    = "rule-updated"
+	rewardOutboxTopic  = inventory.RewardOutboxTopic
+	segmentOutboxTopic = "segment-updated"
+)
+
+// newRuleRelay creates the relay publishing outboxed RuleUpdateEvents to
+// RuleUpdated
+func newRuleRelay(conn *sql.DB, metrics outbox.Metrics) *outbox.Relay {
+	return outbox.NewRelay(outbox.Config{
+		Topic:   ruleOutboxTopic,
+		Publish: outbox.JSONPublisher[RuleUpdateEvent](RuleUpdated),
+	}, outbox.DBTx(conn), metrics)
+}
+
+// newRewardRelay creates the relay publishing outboxed RewardUpdateEvents to
+// RewardUpdated
+func newRewardRelay(conn *sql.DB, metrics outbox.Metrics) *outbox.Relay {
+	return outbox.NewRelay(outbox.Config{
+		Topic:   rewardOutboxTopic,
+		Publish: rewardPublisher(RewardUpdated),
+	}, outbox.DBTx(conn), metrics)
+}
+
+// newSegmentRelay creates the relay publishing outboxed SegmentUpdateEvents to
+// SegmentUpdated
+func newSegmentRelay(conn *sql.DB, metrics outbox.Metrics) *outbox.Relay {
+	return outbox.NewRelay(outbox.Config{
+		Topic:   segmentOutboxTopic,
+		Publish: outbox.JSONPublisher[SegmentUpdateEvent](SegmentUpdated),
+	}, outbox.DBTx(conn), metrics)
+}
+
+// rewardOutboxEvent is a reward change as written to the outbox: the admin API
+// writes a RewardUpdateEvent, and inventory.Claim writes an inventory.SoldOut
+// whose snapshot is rendered into the event's reward state on publishing
+type rewardOutboxEvent struct {
+	RewardUpdateEvent
+	Snapshot *db.RewardsCatalog `json:"snapshot,omitempty"`
+}
+
+// rewardPublisher returns a Publisher that decodes reward change events and
+// publishes them to topic
+func rewardPublisher(topic outbox.Topic[RewardUpdateEvent]) outbox.Publisher {
+	return func(ctx context.Context, payload json.RawMessage) error {
+		var msg rewardOutboxEvent
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			return fmt.Errorf("decode outbox payload: %w", err)
+		}
+		if msg.Snapshot != nil {
+			state := rewardFromDB(*msg.Snapshot)
+			msg.Reward = &state
+		}
+		_, err := topic.Publish(ctx, &msg.RewardUpdateEvent)
+		return err
+	}
+}
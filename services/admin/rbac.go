@@ -0,0 +1,164 @@
+package admin
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"encore.app/internal/auth"
+	"github.com/labstack/echo/v4"
+	"gopkg.in/yaml.v3"
+)
+
+// specYAML is the admin OpenAPI spec, which admin.gen.go is generated from
+//
+//go:embed admin.yaml
+var specYAML []byte
+
+// specMethods are the operations of a spec path item, by HTTP method
+var specMethods = map[string]string{
+	"get":    http.MethodGet,
+	"post":   http.MethodPost,
+	"put":    http.MethodPut,
+	"patch":  http.MethodPatch,
+	"delete": http.MethodDelete,
+}
+
+// specPathParam matches a path parameter in spec syntax, e.g. {ruleId}
+var specPathParam = regexp.MustCompile(`\{(\w+)\}`)
+
+// loadOperationPermissions reads the permissions each operation requires from
+// the BearerAuth scopes of the spec, keyed by "METHOD path" as registered in
+// RegisterHandlers. Operations without scopes only require authentication.
+// Reading them from the spec the handlers are generated from keeps the two
+// from drifting; an unknown scope is an error.
+func loadOperationPermissions(spec []byte) (map[string][]auth.Permission, error) {
+	var doc struct {
+		Paths map[string]map[string]yaml.Node `yaml:"paths"`
+	}
+	if err := yaml.Unmarshal(spec, &doc); err != nil {
+		return nil, fmt.Errorf("admin: parse spec: %w", err)
+	}
+	known := map[auth.Permission]bool{}
+	for _, p := range auth.AllPermissions {
+		known[p] = true
+	}
+
+	permissions := map[string][]auth.Permission{}
+	for path, item := range doc.Paths {
+		route := specPathParam.ReplaceAllString(path, ":$1")
+		for key, node := range item {
+			method, ok := specMethods[key]
+			if !ok {
+				continue
+			}
+			var op struct {
+				Security []map[string][]string `yaml:"security"`
+			}
+			if err := node.Decode(&op); err != nil {
+				return nil, fmt.Errorf("admin: parse %s %s: %w", method, path, err)
+			}
+			perms := []auth.Permission{}
+			for _, requirement := range op.Security {
+				for _, scope := range requirement["BearerAuth"] {
+					if !known[auth.Permission(scope)] {
+						return nil, fmt.Errorf("admin: %s %s requires unknown permission %q", method, path, scope)
+					}
+					perms = append(perms, auth.Permission(scope))
+				}
+			}
+			permissions[method+" "+route] = perms
+		}
+	}
+	return permissions, nil
+}
+
+// requirePermission returns middleware that checks the authenticated user
+// holds every one of perms
+func requirePermission(perms ...auth.Permission) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			// Get user from context (set by JWT middleware)
+			user := c.Get("user")
+			if user == nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+			}
+
+			claims, ok := user.(*Claims)
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token claims")
+			}
+
+			for _, perm := range perms {
+				if !auth.HasPermission(claims.Roles, perm) {
+					return echo.NewHTTPError(http.StatusForbidden, Error{
+						Code:    strPtr("forbidden"),
+						Message: strPtr(fmt.Sprintf("Missing permission: %s", perm)),
+						Details: &map[string]interface{}{"permission": string(perm)},
+					})
+				}
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// rbacRouter is an EchoRouter that attaches the permission check for each
+// generated route before registering it on the underlying router
+type rbacRouter struct {
+	EchoRouter
+	// permissions are the operations' permissions, from loadOperationPermissions
+	permissions map[string][]auth.Permission
+	// unmapped lists the registered routes without permissions, which
+	// newRouter refuses to start with
+	unmapped []string
+}
+
+// route looks up the permissions for a route and prepends the check to its
+// middleware. A route without permissions fails closed: it is recorded in
+// unmapped and every request to it is forbidden.
+func (r *rbacRouter) route(method, path string, m []echo.MiddlewareFunc) []echo.MiddlewareFunc {
+	perms, ok := r.permissions[method+" "+path]
+	if !ok {
+		r.unmapped = append(r.unmapped, method+" "+path)
+		return append([]echo.MiddlewareFunc{denyAll}, m...)
+	}
+	return append([]echo.MiddlewareFunc{requirePermission(perms...)}, m...)
+}
+
+// denyAll forbids every request; it guards routes without permissions
+func denyAll(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusForbidden, Error{
+			Code:    strPtr("forbidden"),
+			Message: strPtr("No permission is configured for this operation"),
+		})
+	}
+}
+
+func (r *rbacRouter) GET(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return r.EchoRouter.GET(path, h, r.route(http.MethodGet, path, m)...)
+}
+
+func (r *rbacRouter) POST(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return r.EchoRouter.POST(path, h, r.route(http.MethodPost, path, m)...)
+}
+
+func (r *rbacRouter) PUT(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return r.EchoRouter.PUT(path, h, r.route(http.MethodPut, path, m)...)
+}
+
+func (r *rbacRouter) PATCH(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return r.EchoRouter.PATCH(path, h, r.route(http.MethodPatch, path, m)...)
+}
+
+func (r *rbacRouter) DELETE(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	return r.EchoRouter.DELETE(path, h, r.route(http.MethodDelete, path, m)...)
+}
+
+// strPtr returns a pointer to s
+func strPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,183 @@
+//go:build !encore
+// +build !encore
+
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"encore.app/internal/auth"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// routeRecorder is an EchoRouter recording the routes registered on it
+type routeRecorder struct {
+	*echo.Echo
+	routes []string
+}
+
+func (r *routeRecorder) GET(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	r.routes = append(r.routes, http.MethodGet+" "+path)
+	return r.Echo.GET(path, h, m...)
+}
+
+func (r *routeRecorder) POST(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	r.routes = append(r.routes, http.MethodPost+" "+path)
+	return r.Echo.POST(path, h, m...)
+}
+
+func (r *routeRecorder) PUT(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	r.routes = append(r.routes, http.MethodPut+" "+path)
+	return r.Echo.PUT(path, h, m...)
+}
+
+func (r *routeRecorder) DELETE(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	r.routes = append(r.routes, http.MethodDelete+" "+path)
+	return r.Echo.DELETE(path, h, m...)
+}
+
+// unimplementedServer panics on every operation; the generated wrappers set
+// their scopes before calling it
+type unimplementedServer struct {
+	ServerInterface
+}
+
+func TestLoadOperationPermissions(t *testing.T) {
+	permissions, err := loadOperationPermissions(specYAML)
+	require.NoError(t, err)
+
+	assert.Equal(t, []auth.Permission{auth.PermRulesWrite}, permissions["POST /rules"])
+	assert.Equal(t, []auth.Permission{auth.PermSegmentsRead, auth.PermUsersPII}, permissions["GET /segments/:segmentId/preview"])
+	assert.Empty(t, permissions["GET /health"])
+
+	_, err = loadOperationPermissions([]byte("paths:\n  /rules:\n    get:\n      security:\n        - BearerAuth: [rules:delete]\n"))
+	assert.Error(t, err)
+}
+
+// TestOperationPermissionsMatchGenerated checks every generated route is
+// mapped, to the scopes its generated wrapper sets
+func TestOperationPermissionsMatchGenerated(t *testing.T) {
+	permissions, err := loadOperationPermissions(specYAML)
+	require.NoError(t, err)
+
+	e := echo.New()
+	var scopes interface{}
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			defer func() {
+				recover()
+				scopes = c.Get(BearerAuthScopes)
+			}()
+			return next(c)
+		}
+	})
+	router := &routeRecorder{Echo: e}
+	RegisterHandlers(router, unimplementedServer{})
+	require.NotEmpty(t, router.routes)
+
+	id := uuid.NewString()
+	for _, route := range router.routes {
+		perms, ok := permissions[route]
+		require.True(t, ok, "no permissions for %s", route)
+		want := make([]string, len(perms))
+		for i, p := range perms {
+			want[i] = string(p)
+		}
+
+		method, path, _ := strings.Cut(route, " ")
+		for _, param := range []string{":ruleId", ":rewardId", ":segmentId"} {
+			path = strings.ReplaceAll(path, param, id)
+		}
+		scopes = nil
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, path, nil))
+		if len(want) == 0 {
+			assert.Nil(t, scopes, route)
+			continue
+		}
+		assert.Equal(t, want, scopes, route)
+	}
+}
+
+func TestRBACRouter(t *testing.T) {
+	permissions, err := loadOperationPermissions(specYAML)
+	require.NoError(t, err)
+
+	e := echo.New()
+	var roles []string
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("user", &Claims{UserID: uuid.New(), Roles: roles})
+			return next(c)
+		}
+	})
+	router := &rbacRouter{EchoRouter: e, permissions: permissions}
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	router.POST("/rules", ok)
+	router.GET("/segments/:segmentId/preview", ok)
+	router.GET("/health", ok)
+	router.GET("/users/:userId", ok)
+	assert.Equal(t, []string{"GET /users/:userId"}, router.unmapped)
+
+	tests := []struct {
+		name   string
+		roles  []string
+		method string
+		path   string
+		want   int
+	}{
+		{"product-admin writes rules", []string{auth.RoleProductAdmin}, http.MethodPost, "/rules", http.StatusOK},
+		{"marketing cannot write rules", []string{auth.RoleMarketing}, http.MethodPost, "/rules", http.StatusForbidden},
+		{"support-agent previews segments", []string{auth.RoleSupportAgent}, http.MethodGet, "/segments/" + uuid.NewString() + "/preview", http.StatusOK},
+		{"marketing cannot preview users", []string{auth.RoleMarketing}, http.MethodGet, "/segments/" + uuid.NewString() + "/preview", http.StatusForbidden},
+		{"no role checks health", nil, http.MethodGet, "/health", http.StatusOK},
+		{"unmapped route fails closed", []string{auth.RoleProductAdmin}, http.MethodGet, "/users/" + uuid.NewString(), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roles = tt.roles
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			assert.Equal(t, tt.want, rec.Code)
+		})
+	}
+}
+
+func TestInitService_Permissions(t *testing.T) {
+	svc := startService(t)
+
+	tests := []struct {
+		name   string
+		roles  []string
+		method string
+		path   string
+		want   int
+	}{
+		// An authorized request reaches the handler, which rejects the
+		// invalid rule ID
+		{"product-admin deletes rules", []string{auth.RoleProductAdmin}, http.MethodDelete, "/admin/rules/invalid", http.StatusBadRequest},
+		{"marketing cannot delete rules", []string{auth.RoleMarketing}, http.MethodDelete, "/admin/rules/invalid", http.StatusForbidden},
+		{"no role cannot delete rules", nil, http.MethodDelete, "/admin/rules/invalid", http.StatusForbidden},
+		{"marketing cannot preview users", []string{auth.RoleMarketing}, http.MethodGet, "/admin/segments/" + uuid.NewString() + "/preview", http.StatusForbidden},
+		{"no role checks health", nil, http.MethodGet, "/admin/health", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, serve(svc, tt.method, tt.path, adminToken(t, tt.roles...)))
+		})
+	}
+}
+
+func TestNewRouter_UnmappedRoute(t *testing.T) {
+	// A spec missing an operation's permissions is refused at startup
+	spec := strings.Replace(string(specYAML), "\n  /health:\n", "\n  /healthz:\n", 1)
+	require.NotEqual(t, string(specYAML), spec)
+	_, err := newRouter(nil, []byte(spec))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "GET /health")
+}
@@ -0,0 +1,142 @@
+//go:build !encore
+// +build !encore
+
+package admin
+
+import (
+	"database/sql"
+	"net/http"
+	"testing"
+	"time"
+
+	"encore.app/internal/db"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sqlc-dev/pqtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// storedReward returns a reward with every optional setting filled in
+func storedReward() db.RewardsCatalog {
+	return db.RewardsCatalog{
+		ID:                 uuid.New(),
+		Name:               "Coffee voucher",
+		Description:        sql.NullString{String: "Any size", Valid: true},
+		Cost:               300,
+		Segment:            pqtype.NullRawMessage{RawMessage: []byte(`{"min_points":100}`), Valid: true},
+		Active:             true,
+		MinTier:            sql.NullString{String: "gold", Valid: true},
+		StockTotal:         sql.NullInt32{Int32: 100, Valid: true},
+		StockRedeemed:      10,
+		DailyStock:         sql.NullInt32{Int32: 20, Valid: true},
+		MaxPerUser:         sql.NullInt32{Int32: 5, Valid: true},
+		MaxPerUserMonthly:  sql.NullInt32{Int32: 2, Valid: true},
+		CooldownSeconds:    sql.NullInt32{Int32: 3600, Valid: true},
+		CodeSource:         sql.NullString{String: "generated", Valid: true},
+		CodeLowThreshold:   sql.NullInt32{Int32: 10, Valid: true},
+		RewardType:         "standard",
+		FulfillmentAdapter: sql.NullString{String: "webhook", Valid: true},
+		FulfillmentUrl:     sql.NullString{String: "https://partner.example.com/fulfil", Valid: true},
+		FulfillmentSecret:  sql.NullString{String: "a-very-long-partner-secret", Valid: true},
+		PendingTtlSeconds:  sql.NullInt32{Int32: 900, Valid: true},
+		AvailableFrom:      sql.NullTime{Time: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), Valid: true},
+		AvailableUntil:     sql.NullTime{Time: time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC), Valid: true},
+		MinPoints:          sql.NullInt32{Int32: 100, Valid: true},
+		CashPrice:          sql.NullInt64{Int64: 250, Valid: true},
+		Category:           sql.NullString{String: "food", Valid: true},
+		Tags:               pqtype.NullRawMessage{RawMessage: []byte(`["coffee","morning"]`), Valid: true},
+		ImageUrl:           sql.NullString{String: "https://cdn.example.com/coffee.png", Valid: true},
+		PartnerName:        sql.NullString{String: "Bean Co", Valid: true},
+		SortOrder:          3,
+		Featured:           true,
+		Version:            4,
+	}
+}
+
+// updateParams returns the update that writes reward back unchanged
+func updateParams(reward db.RewardsCatalog) db.UpdateRewardParams {
+	return db.UpdateRewardParams{
+		ID:                 reward.ID,
+		Name:               reward.Name,
+		Description:        reward.Description,
+		Cost:               reward.Cost,
+		Segment:            reward.Segment,
+		Active:             reward.Active,
+		MinTier:            reward.MinTier,
+		DailyStock:         reward.DailyStock,
+		MaxPerUser:         reward.MaxPerUser,
+		MaxPerUserMonthly:  reward.MaxPerUserMonthly,
+		CooldownSeconds:    reward.CooldownSeconds,
+		CodeSource:         reward.CodeSource,
+		CodeFormat:         reward.CodeFormat,
+		CodeLowThreshold:   reward.CodeLowThreshold,
+		RewardType:         reward.RewardType,
+		CreditAmount:       reward.CreditAmount,
+		CreditValidDays:    reward.CreditValidDays,
+		FulfillmentAdapter: reward.FulfillmentAdapter,
+		FulfillmentUrl:     reward.FulfillmentUrl,
+		FulfillmentSecret:  reward.FulfillmentSecret,
+		PendingTtlSeconds:  reward.PendingTtlSeconds,
+		AvailableFrom:      reward.AvailableFrom,
+		AvailableUntil:     reward.AvailableUntil,
+		AvailableDays:      reward.AvailableDays,
+		MinPoints:          reward.MinPoints,
+		CashPrice:          reward.CashPrice,
+		PriceRules:         reward.PriceRules,
+		Category:           reward.Category,
+		Tags:               reward.Tags,
+		ImageUrl:           reward.ImageUrl,
+		Terms:              reward.Terms,
+		PartnerName:        reward.PartnerName,
+		PartnerUrl:         reward.PartnerUrl,
+		PartnerLogoUrl:     reward.PartnerLogoUrl,
+		SortOrder:          reward.SortOrder,
+		Featured:           reward.Featured,
+		StockTotal:         reward.StockTotal,
+	}
+}
+
+func TestMergeRewardPatch(t *testing.T) {
+	reward := storedReward()
+
+	t.Run("omitted fields keep their value", func(t *testing.T) {
+		req, err := mergeRewardPatch(reward, []byte(`{"cost":500}`))
+		require.NoError(t, err)
+		params, err := rewardUpdateParams(reward.ID, req)
+		require.NoError(t, err)
+
+		want := updateParams(reward)
+		want.Cost = 500
+		assert.Equal(t, want, params)
+	})
+
+	t.Run("null clears a field", func(t *testing.T) {
+		req, err := mergeRewardPatch(reward, []byte(`{"description":null,"min_points":null,"cash_price":null}`))
+		require.NoError(t, err)
+		params, err := rewardUpdateParams(reward.ID, req)
+		require.NoError(t, err)
+
+		want := updateParams(reward)
+		want.Description = sql.NullString{}
+		want.MinPoints = sql.NullInt32{}
+		want.CashPrice = sql.NullInt64{}
+		assert.Equal(t, want, params)
+	})
+
+	t.Run("merged body is validated", func(t *testing.T) {
+		req, err := mergeRewardPatch(reward, []byte(`{"cost":50}`))
+		require.NoError(t, err)
+		_, err = rewardUpdateParams(reward.ID, req)
+		var httpErr *echo.HTTPError
+		require.ErrorAs(t, err, &httpErr)
+		assert.Equal(t, http.StatusBadRequest, httpErr.Code)
+	})
+
+	t.Run("invalid body", func(t *testing.T) {
+		_, err := mergeRewardPatch(reward, []byte(`{"cost":"free"}`))
+		var httpErr *echo.HTTPError
+		require.ErrorAs(t, err, &httpErr)
+		assert.Equal(t, http.StatusBadRequest, httpErr.Code)
+	})
+}
@@ -0,0 +1,101 @@
+package admin
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"encore.app/internal/db"
+	"encore.app/internal/segments"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	openapi_types "github.com/oapi-codegen/runtime/types"
+)
+
+// Segment preview sample sizes
+const (
+	defaultPreviewSampleSize = 10
+	maxPreviewSampleSize     = 100
+)
+
+// GetSegmentsSegmentIdPreview returns how many users a segment targets and a
+// sample of them. Materialized segments are read from segment_members; other
+// segments, or any segment when live is set, are evaluated against all users.
+func (s *AdminService) GetSegmentsSegmentIdPreview(ctx echo.Context, segmentId openapi_types.UUID, params GetSegmentsSegmentIdPreviewParams) error {
+	sampleSize := defaultPreviewSampleSize
+	if params.SampleSize != nil {
+		if *params.SampleSize < 0 || *params.SampleSize > maxPreviewSampleSize {
+			return echo.NewHTTPError(http.StatusBadRequest, "Sample size must be between 0 and 100")
+		}
+		sampleSize = *params.SampleSize
+	}
+
+	reqCtx := ctx.Request().Context()
+	segment, err := queries.GetSegment(reqCtx, uuid.UUID(segmentId))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "Segment not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to retrieve segment")
+	}
+
+	live := params.Live != nil && *params.Live
+	if segment.Materialized && segment.MaterializedAt.Valid && !live {
+		count, err := queries.CountSegmentMembers(reqCtx, segment.ID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to count segment members")
+		}
+		rows, err := queries.ListSegmentMemberFacts(reqCtx, db.ListSegmentMemberFactsParams{
+			SegmentID:  segment.ID,
+			SampleSize: int32(sampleSize),
+		})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to retrieve segment members")
+		}
+		sample := make([]SegmentMember, len(rows))
+		for i, row := range rows {
+			sample[i] = segmentMember(row.ID, row.Balance, row.LifetimeKwh, row.CreatedAt)
+		}
+		return ctx.JSON(http.StatusOK, segmentPreview(segment.ID, count, sample, Materialized, segment.MaterializedAt.Time))
+	}
+
+	criteria, err := segments.Parse(segment.Criteria)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	now := time.Now()
+	preview, err := segments.PreviewCriteria(reqCtx, queries, criteria, sampleSize, now)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to evaluate segment")
+	}
+	sample := make([]SegmentMember, len(preview.Sample))
+	for i, u := range preview.Sample {
+		sample[i] = segmentMember(uuid.MustParse(u.ID), u.Balance, u.LifetimeKWH, u.SignupDate)
+	}
+	return ctx.JSON(http.StatusOK, segmentPreview(segment.ID, preview.Count, sample, Live, now))
+}
+
+// segmentMember builds the API representation of a sampled segment member
+func segmentMember(id uuid.UUID, balance int64, lifetimeKWH float64, signupDate time.Time) SegmentMember {
+	b := int(balance)
+	kwh := float32(lifetimeKWH)
+	return SegmentMember{
+		UserId:      (*openapi_types.UUID)(&id),
+		Balance:     &b,
+		LifetimeKwh: &kwh,
+		SignupDate:  &signupDate,
+	}
+}
+
+// segmentPreview builds the API representation of a segment preview
+func segmentPreview(id uuid.UUID, count int64, sample []SegmentMember, source SegmentPreviewSource, asOf time.Time) SegmentPreview {
+	c := int(count)
+	return SegmentPreview{
+		SegmentId: (*openapi_types.UUID)(&id),
+		Count:     &c,
+		Sample:    &sample,
+		Source:    &source,
+		AsOf:      &asOf,
+	}
+}
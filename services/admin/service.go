@@ -0,0 +1,42 @@
+//go:build encore
+// +build encore
+
+package admin
+
+import (
+	"context"
+
+	"encore.app/internal/db"
+	"encore.app/internal/outbox"
+	"encore.dev/pubsub"
+	"encore.dev/storage/sqldb"
+)
+
+// Shared rewards database
+var rewardsDB = sqldb.Named("rewards")
+
+// Pub/Sub topics for admin events
+var (
+	RuleUpdated    = pubsub.NewTopic[*RuleUpdateEvent]("rule-updated", pubsub.TopicConfig{DeliveryGuarantee: pubsub.AtLeastOnce})
+	RewardUpdated  = pubsub.NewTopic[*RewardUpdateEvent]("reward-updated", pubsub.TopicConfig{DeliveryGuarantee: pubsub.AtLeastOnce})
+	SegmentUpdated = pubsub.NewTopic[*SegmentUpdateEvent]("segment-updated", pubsub.TopicConfig{DeliveryGuarantee: pubsub.AtLeastOnce})
+)
+
+// init initializes the service
+func init() {
+	// Initialize database queries on the Encore-managed connection
+	conn = rewardsDB.Stdlib()
+	queries = db.New(conn)
+}
+
+// startRelays starts the outbox relays publishing rule, reward and segment
+// change events
+func startRelays() {
+	for _, relay := range []*outbox.Relay{
+		newRuleRelay(conn, outbox.DefaultMetrics),
+		newRewardRelay(conn, outbox.DefaultMetrics),
+		newSegmentRelay(conn, outbox.DefaultMetrics),
+	} {
+		go relay.Run(context.Background())
+	}
+}
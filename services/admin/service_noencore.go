@@ -0,0 +1,46 @@
+//go:build !encore
+// +build !encore
+
+package admin
+
+import "context"
+
+// Mock topics for non-Encore builds
+var (
+	RuleUpdated    = &MockRuleUpdatedTopic{}
+	RewardUpdated  = &MockRewardUpdatedTopic{}
+	SegmentUpdated = &MockSegmentUpdatedTopic{}
+)
+
+// MockRuleUpdatedTopic is a mock implementation for testing
+type MockRuleUpdatedTopic struct{}
+
+func (m *MockRuleUpdatedTopic) Publish(ctx context.Context, msg *RuleUpdateEvent) (string, error) {
+	// Mock implementation - does nothing
+	return "mock-message-id", nil
+}
+
+// MockRewardUpdatedTopic is a mock implementation for testing
+type MockRewardUpdatedTopic struct{}
+
+func (m *MockRewardUpdatedTopic) Publish(ctx context.Context, msg *RewardUpdateEvent) (string, error) {
+	// Mock implementation - does nothing
+	return "mock-message-id", nil
+}
+
+// MockSegmentUpdatedTopic is a mock implementation for testing
+type MockSegmentUpdatedTopic struct{}
+
+func (m *MockSegmentUpdatedTopic) Publish(ctx context.Context, msg *SegmentUpdateEvent) (string, error) {
+	// Mock implementation - does nothing
+	return "mock-message-id", nil
+}
+
+// init initializes the service
+func init() {
+	// Service will be initialized by Encore
+}
+
+// startRelays does nothing outside Encore, where there is no database to
+// relay from
+func startRelays() {}
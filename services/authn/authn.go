@@ -0,0 +1,95 @@
+package authn
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+
+	"encore.app/internal/auth"
+	encauth "encore.dev/beta/auth"
+	"encore.dev/beta/errs"
+)
+
+//encore:service
+type Service struct {
+	// Service will be initialized by Encore
+}
+
+// AuthParams are the credentials accepted by the auth handler
+type AuthParams struct {
+	// Authorization carries an end-user JWT as "Bearer <token>"
+	Authorization string `header:"Authorization"`
+	// APIKey identifies a backend integration such as the charge-point backend
+	APIKey string `header:"X-Api-Key"`
+}
+
+// secrets are provided by Encore (encore secret set ServiceAPIKeys)
+var secrets struct {
+	// ServiceAPIKeys is a comma-separated list of name=key service credentials
+	ServiceAPIKeys string
+}
+
+var (
+	initOnce     sync.Once
+	userVerifier *auth.Verifier
+	serviceKeys  auth.APIKeys
+)
+
+// loadCredentials initializes the user token verifier and service API keys
+func loadCredentials() {
+	v, err := auth.NewVerifier(auth.ConfigFromEnvPrefix("USER_JWT_"))
+	if err != nil {
+		log.Printf("authn: user tokens disabled: %v", err)
+	}
+	userVerifier = v
+
+	keys, err := auth.ParseAPIKeys(secrets.ServiceAPIKeys)
+	if err != nil {
+		log.Printf("authn: service API keys disabled: %v", err)
+	}
+	serviceKeys = keys
+}
+
+// AuthHandler authenticates app users by JWT and backend services by API key
+//
+//encore:authhandler
+func AuthHandler(ctx context.Context, p *AuthParams) (encauth.UID, *auth.Principal, error) {
+	initOnce.Do(loadCredentials)
+	return authenticate(p, userVerifier, serviceKeys)
+}
+
+// authenticate resolves the request credentials to a principal
+func authenticate(p *AuthParams, verifier *auth.Verifier, keys auth.APIKeys) (encauth.UID, *auth.Principal, error) {
+	if p.APIKey != "" {
+		name, ok := keys.Match(p.APIKey)
+		if !ok {
+			return "", nil, &errs.Error{Code: errs.Unauthenticated, Message: "invalid API key"}
+		}
+		return encauth.UID("service:" + name), &auth.Principal{
+			Kind:    auth.PrincipalService,
+			Service: name,
+		}, nil
+	}
+
+	token, ok := strings.CutPrefix(p.Authorization, "Bearer ")
+	if !ok || token == "" {
+		return "", nil, &errs.Error{Code: errs.Unauthenticated, Message: "missing bearer token"}
+	}
+	if verifier == nil {
+		return "", nil, &errs.Error{Code: errs.Unavailable, Message: "user authentication is not configured"}
+	}
+	claims, err := verifier.Verify(token)
+	if err != nil {
+		return "", nil, &errs.Error{Code: errs.Unauthenticated, Message: "invalid token"}
+	}
+	userID := claims.UserIDOrSubject()
+	if userID == "" {
+		return "", nil, &errs.Error{Code: errs.Unauthenticated, Message: "token has no subject"}
+	}
+	return encauth.UID(userID), &auth.Principal{
+		Kind:   auth.PrincipalUser,
+		UserID: userID,
+		Role:   claims.Role,
+	}, nil
+}
@@ -0,0 +1,76 @@
+//go:build !encore
+// +build !encore
+
+package authn
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"encore.app/internal/auth"
+	"encore.dev/beta/errs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hs256Token signs claims with the given secret
+func hs256Token(t *testing.T, secret string, claims auth.Claims) string {
+	t.Helper()
+	h, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	c, err := json.Marshal(claims)
+	require.NoError(t, err)
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(input))
+	return input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func errCode(err error) errs.ErrCode {
+	var e *errs.Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return errs.Unknown
+}
+
+func TestAuthenticate_UserToken(t *testing.T) {
+	verifier, err := auth.NewVerifier(auth.Config{Issuer: "urja-rewards", HMACSecret: []byte("user-secret")})
+	require.NoError(t, err)
+
+	token := hs256Token(t, "user-secret", auth.Claims{
+		Issuer:    "urja-rewards",
+		Subject:   "550e8400-e29b-41d4-a716-446655440000",
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	})
+
+	uid, principal, err := authenticate(&AuthParams{Authorization: "Bearer " + token}, verifier, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "550e8400-e29b-41d4-a716-446655440000", string(uid))
+	assert.Equal(t, auth.PrincipalUser, principal.Kind)
+	assert.Equal(t, "550e8400-e29b-41d4-a716-446655440000", principal.UserID)
+
+	_, _, err = authenticate(&AuthParams{Authorization: "Bearer " + token + "x"}, verifier, nil)
+	assert.Equal(t, errs.Unauthenticated, errCode(err))
+
+	_, _, err = authenticate(&AuthParams{}, verifier, nil)
+	assert.Equal(t, errs.Unauthenticated, errCode(err))
+}
+
+func TestAuthenticate_ServiceAPIKey(t *testing.T) {
+	keys, err := auth.ParseAPIKeys("chargepoint-backend=s3cr3t")
+	require.NoError(t, err)
+
+	uid, principal, err := authenticate(&AuthParams{APIKey: "s3cr3t"}, nil, keys)
+	require.NoError(t, err)
+	assert.Equal(t, "service:chargepoint-backend", string(uid))
+	assert.Equal(t, auth.PrincipalService, principal.Kind)
+	assert.Equal(t, "chargepoint-backend", principal.Service)
+
+	_, _, err = authenticate(&AuthParams{APIKey: "guess"}, nil, keys)
+	assert.Equal(t, errs.Unauthenticated, errCode(err))
+}
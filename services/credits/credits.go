@@ -0,0 +1,49 @@
+//go:build encore
+// +build encore
+
+package credits
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"encore.app/internal/db"
+	"encore.dev/cron"
+	"encore.dev/storage/sqldb"
+)
+
+//encore:service
+type Service struct {
+	conn *sql.DB
+	db   *db.Queries
+}
+
+// rewardsDB is the shared rewards database
+var rewardsDB = sqldb.Named("rewards")
+
+// init initializes the credits service
+func init() {
+	// Service will be initialized by Encore
+}
+
+// initService connects the service to the database
+func initService() (*Service, error) {
+	conn := rewardsDB.Stdlib()
+	return &Service{conn: conn, db: db.New(conn)}, nil
+}
+
+// ExpireCredits expires charging credits past their expiry and refunds the
+// points for their unused balance
+//
+//encore:api private method=POST path=/internal/credits/expire
+func ExpireCredits(ctx context.Context) (*ExpireCreditsResponse, error) {
+	return expireAll(ctx, dbTx(rewardsDB.Stdlib()), time.Now())
+}
+
+// Expire credits hourly so refunds land soon after expiry
+var _ = cron.NewJob("expire-charging-credits", cron.JobConfig{
+	Title:    "Expire charging credits",
+	Every:    1 * cron.Hour,
+	Endpoint: ExpireCredits,
+})
@@ -0,0 +1,21 @@
+//go:build !encore
+// +build !encore
+
+package credits
+
+import (
+	"database/sql"
+
+	"encore.app/internal/db"
+)
+
+//encore:service
+type Service struct {
+	conn *sql.DB
+	db   *db.Queries
+}
+
+// init initializes the credits service
+func init() {
+	// Service will be initialized by Encore
+}
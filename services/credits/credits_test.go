@@ -0,0 +1,117 @@
+//go:build !encore
+// +build !encore
+
+package credits
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"encore.app/internal/auth"
+	"encore.app/internal/credits"
+	"encore.app/internal/db"
+	"encore.dev/beta/errs"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeExpirer holds active credits that are all past expiry
+type fakeExpirer struct {
+	due    int
+	events int
+}
+
+func (f *fakeExpirer) ListDueChargingCredits(ctx context.Context, arg db.ListDueChargingCreditsParams) ([]db.ChargingCredit, error) {
+	n := f.due
+	if n > int(arg.BatchSize) {
+		n = int(arg.BatchSize)
+	}
+	due := make([]db.ChargingCredit, n)
+	for i := range due {
+		due[i] = db.ChargingCredit{ID: uuid.New(), UserID: uuid.New(), Amount: 100, Remaining: 50, PointsSpent: 10, Status: credits.StatusActive}
+	}
+	return due, nil
+}
+
+func (f *fakeExpirer) ExpireChargingCredit(ctx context.Context, arg db.ExpireChargingCreditParams) (db.ChargingCredit, error) {
+	f.due--
+	return db.ChargingCredit{ID: arg.ID, Status: credits.StatusExpired, RefundedPoints: arg.RefundedPoints}, nil
+}
+
+func (f *fakeExpirer) CreatePointsEvent(ctx context.Context, arg db.CreatePointsEventParams) (db.PointsEvent, error) {
+	f.events++
+	return db.PointsEvent{ID: uuid.New()}, nil
+}
+
+func TestExpireAll(t *testing.T) {
+	q := &fakeExpirer{due: expireBatchSize + 20}
+	batches := 0
+	tx := func(ctx context.Context, fn func(q credits.Expirer) error) error {
+		batches++
+		return fn(q)
+	}
+
+	resp, err := expireAll(context.Background(), tx, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, expireBatchSize+20, resp.Expired)
+	assert.Equal(t, int64(5*(expireBatchSize+20)), resp.RefundedPoints)
+	assert.Equal(t, 2, batches)
+	assert.Equal(t, expireBatchSize+20, q.events)
+
+	failing := func(ctx context.Context, fn func(q credits.Expirer) error) error {
+		return errors.New("connection reset")
+	}
+	_, err = expireAll(context.Background(), failing, time.Now())
+	assert.Error(t, err)
+}
+
+func TestAuthorizeUser(t *testing.T) {
+	userID := uuid.NewString()
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		wantCode  errs.ErrCode
+	}{
+		{"own credits", &auth.Principal{Kind: auth.PrincipalUser, UserID: userID}, errs.OK},
+		{"charge backend", &auth.Principal{Kind: auth.PrincipalService, Service: "charge-backend"}, errs.OK},
+		{"another user", &auth.Principal{Kind: auth.PrincipalUser, UserID: uuid.NewString()}, errs.PermissionDenied},
+		{"anonymous", nil, errs.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := authorizeUser(tt.principal, userID)
+			if tt.wantCode == errs.OK {
+				assert.NoError(t, err)
+				return
+			}
+			var e *errs.Error
+			require.ErrorAs(t, err, &e)
+			assert.Equal(t, tt.wantCode, e.Code)
+		})
+	}
+}
+
+func TestConsumeError(t *testing.T) {
+	var e *errs.Error
+	require.ErrorAs(t, consumeError(credits.ErrInvalidAmount), &e)
+	assert.Equal(t, errs.InvalidArgument, e.Code)
+
+	err := consumeError(errors.New("connection reset"))
+	assert.False(t, errors.As(err, &e))
+}
+
+func TestConsumeResponse(t *testing.T) {
+	creditID := uuid.New()
+	resp := consumeResponse("session-1", credits.Consumption{
+		Applied:      1500,
+		Balance:      500,
+		Consumptions: []db.CreditConsumption{{CreditID: creditID, Amount: 1500}},
+	})
+	assert.Equal(t, "session-1", resp.SessionID)
+	assert.Equal(t, int64(1500), resp.Applied)
+	assert.Equal(t, "INR", resp.Currency)
+	assert.Equal(t, []CreditConsumption{{CreditID: creditID.String(), Amount: 1500}}, resp.Consumptions)
+}
@@ -0,0 +1,54 @@
+package credits
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"encore.app/internal/credits"
+	"encore.app/internal/db"
+)
+
+// expireBatchSize bounds the credits expired per transaction
+const expireBatchSize = 100
+
+// ExpireCreditsResponse summarizes an expiry run
+type ExpireCreditsResponse struct {
+	Expired        int   `json:"expired"`
+	RefundedPoints int64 `json:"refunded_points"`
+}
+
+// txFunc runs fn inside a database transaction
+type txFunc func(ctx context.Context, fn func(q credits.Expirer) error) error
+
+// dbTx returns a txFunc running transactions on conn
+func dbTx(conn *sql.DB) txFunc {
+	return func(ctx context.Context, fn func(q credits.Expirer) error) error {
+		return db.WithTx(ctx, conn, func(q *db.Queries) error {
+			return fn(q)
+		})
+	}
+}
+
+// expireAll expires due credits in batches, each in its own transaction,
+// until none are left
+func expireAll(ctx context.Context, tx txFunc, now time.Time) (*ExpireCreditsResponse, error) {
+	resp := &ExpireCreditsResponse{}
+	for {
+		var batch credits.ExpireResult
+		err := tx(ctx, func(q credits.Expirer) error {
+			var err error
+			batch, err = credits.ExpireBatch(ctx, q, now, expireBatchSize)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to expire credits: %w", err)
+		}
+		resp.Expired += batch.Expired
+		resp.RefundedPoints += batch.RefundedPoints
+		if batch.Expired < expireBatchSize {
+			return resp, nil
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package credits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"encore.app/internal/auth"
+	"encore.app/internal/credits"
+	"encore.app/internal/db"
+	"encore.dev/beta/errs"
+	"github.com/google/uuid"
+)
+
+// Credit is an active charging credit
+type Credit struct {
+	ID           string `json:"id"`
+	RedemptionID string `json:"redemption_id"`
+	// Amount is the credit issued, in paise
+	Amount int64 `json:"amount"`
+	// Remaining is the credit not yet consumed, in paise
+	Remaining int64     `json:"remaining"`
+	Currency  string    `json:"currency"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ListCreditsResponse is a user's usable charging credit
+type ListCreditsResponse struct {
+	UserID string `json:"user_id"`
+	// Balance is the credit left across all active credits, in paise
+	Balance  int64    `json:"balance"`
+	Currency string   `json:"currency"`
+	Credits  []Credit `json:"credits"`
+}
+
+// ConsumeCreditsRequest asks to apply a user's credit to a charging session
+type ConsumeCreditsRequest struct {
+	UserID string `json:"user_id"`
+	// SessionID identifies the charging session; retries with the same ID
+	// return the first consumption
+	SessionID string `json:"session_id"`
+	// Amount is the session's bill, in paise
+	Amount int64 `json:"amount"`
+}
+
+// CreditConsumption is the part of a session's bill taken from one credit
+type CreditConsumption struct {
+	CreditID string `json:"credit_id"`
+	Amount   int64  `json:"amount"`
+}
+
+// ConsumeCreditsResponse reports the credit applied to a session
+type ConsumeCreditsResponse struct {
+	SessionID string `json:"session_id"`
+	// Applied is the credit applied, in paise; the backend bills the rest
+	Applied int64 `json:"applied"`
+	// Balance is the user's credit left, in paise
+	Balance      int64               `json:"balance"`
+	Currency     string              `json:"currency"`
+	Consumptions []CreditConsumption `json:"consumptions"`
+	// Replayed is set when the session had already consumed credit
+	Replayed bool `json:"replayed"`
+}
+
+// currency is the currency of all charging credits
+const currency = "INR"
+
+// ListCredits returns a user's active charging credits. Users may list their
+// own credits; the charge-point backend may list anyone's.
+//
+//encore:api auth method=GET path=/v1/users/:userID/credits
+func (s *Service) ListCredits(ctx context.Context, userID string) (*ListCreditsResponse, error) {
+	if err := authorizeUser(auth.CurrentPrincipal(), userID); err != nil {
+		return nil, err
+	}
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, &errs.Error{Code: errs.InvalidArgument, Message: "invalid user ID"}
+	}
+
+	rows, err := s.db.ListUserChargingCredits(ctx, db.ListUserChargingCreditsParams{UserID: id, Now: time.Now()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list credits: %w", err)
+	}
+	resp := &ListCreditsResponse{
+		UserID:   userID,
+		Balance:  credits.Balance(rows),
+		Currency: currency,
+		Credits:  make([]Credit, 0, len(rows)),
+	}
+	for _, c := range rows {
+		resp.Credits = append(resp.Credits, Credit{
+			ID:           c.ID.String(),
+			RedemptionID: c.RedemptionID.String(),
+			Amount:       c.Amount,
+			Remaining:    c.Remaining,
+			Currency:     c.Currency,
+			ExpiresAt:    c.ExpiresAt,
+		})
+	}
+	return resp, nil
+}
+
+// ConsumeCredits applies a user's charging credit to a session's bill. The
+// credit may cover part of the bill; the backend charges the rest. It must be
+// called with a service API key.
+//
+//encore:api auth method=POST path=/v1/credits/consume
+func (s *Service) ConsumeCredits(ctx context.Context, req *ConsumeCreditsRequest) (*ConsumeCreditsResponse, error) {
+	if _, err := auth.RequireService(auth.CurrentPrincipal()); err != nil {
+		return nil, err
+	}
+	userID, err := uuid.Parse(req.UserID)
+	if err != nil {
+		return nil, &errs.Error{Code: errs.InvalidArgument, Message: "invalid user ID"}
+	}
+
+	var result credits.Consumption
+	err = db.WithTx(ctx, s.conn, func(q *db.Queries) error {
+		result, err = credits.Consume(ctx, q, userID, req.SessionID, req.Amount, time.Now())
+		return err
+	})
+	if err != nil {
+		return nil, consumeError(err)
+	}
+	return consumeResponse(req.SessionID, result), nil
+}
+
+// authorizeUser lets users act on their own credits and service callers on
+// anyone's
+func authorizeUser(p *auth.Principal, userID string) error {
+	if p != nil && p.Kind == auth.PrincipalService {
+		return nil
+	}
+	_, err := auth.RequireUser(p, userID)
+	return err
+}
+
+// consumeError maps request validation failures to InvalidArgument
+func consumeError(err error) error {
+	if errors.Is(err, credits.ErrSessionRequired) || errors.Is(err, credits.ErrInvalidAmount) {
+		return &errs.Error{Code: errs.InvalidArgument, Message: err.Error()}
+	}
+	return fmt.Errorf("failed to consume credit: %w", err)
+}
+
+// consumeResponse converts a consumption to the API response
+func consumeResponse(sessionID string, result credits.Consumption) *ConsumeCreditsResponse {
+	resp := &ConsumeCreditsResponse{
+		SessionID:    sessionID,
+		Applied:      result.Applied,
+		Balance:      result.Balance,
+		Currency:     currency,
+		Consumptions: make([]CreditConsumption, 0, len(result.Consumptions)),
+		Replayed:     result.Replayed,
+	}
+	for _, c := range result.Consumptions {
+		resp.Consumptions = append(resp.Consumptions, CreditConsumption{CreditID: c.CreditID.String(), Amount: c.Amount})
+	}
+	return resp
+}
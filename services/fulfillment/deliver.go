@@ -0,0 +1,90 @@
+package fulfillment
+
+import (
+	"context"
+	"fmt"
+
+	"encore.app/internal/db"
+	"encore.app/internal/fulfillment"
+	"encore.app/services/redemption"
+	"github.com/google/uuid"
+)
+
+// maxBatchesPerRun bounds a retry run, leaving the rest to the next run
+const maxBatchesPerRun = 20
+
+// DeliverFulfillmentsResponse summarizes a delivery run
+type DeliverFulfillmentsResponse struct {
+	Delivered int `json:"delivered"`
+	Failed    int `json:"failed"`
+	Retried   int `json:"retried"`
+}
+
+// enqueueStore is the subset of *db.Queries used to queue deliveries
+type enqueueStore interface {
+	fulfillment.Enqueuer
+	GetReward(ctx context.Context, id uuid.UUID) (db.RewardsCatalog, error)
+}
+
+// enqueue queues a new redemption for delivery if its reward has a
+// fulfillment adapter. Redemptions already settled, such as charging credits,
+// are skipped.
+func enqueue(ctx context.Context, q enqueueStore, event *redemption.RedemptionCreated) (bool, error) {
+	if event.Status != "PENDING" {
+		return false, nil
+	}
+	redemptionID, err := uuid.Parse(event.RedemptionID)
+	if err != nil {
+		return false, fmt.Errorf("invalid redemption ID: %w", err)
+	}
+	rewardID, err := uuid.Parse(event.RewardID)
+	if err != nil {
+		return false, fmt.Errorf("invalid reward ID: %w", err)
+	}
+	reward, err := q.GetReward(ctx, rewardID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get reward: %w", err)
+	}
+	return fulfillment.Enqueue(ctx, q, reward, redemptionID, event)
+}
+
+// enqueueCheckout queues the redemptions of a checkout that need a delivery,
+// reporting whether any were queued
+func enqueueCheckout(ctx context.Context, q enqueueStore, event *redemption.CheckoutCompleted) (bool, error) {
+	queuedAny := false
+	for i := range event.Redemptions {
+		queued, err := enqueue(ctx, q, &event.Redemptions[i])
+		if err != nil {
+			return false, err
+		}
+		queuedAny = queuedAny || queued
+	}
+	return queuedAny, nil
+}
+
+// deliverBatch attempts one batch of due deliveries
+func deliverBatch(ctx context.Context, d *fulfillment.Dispatcher, tx fulfillment.TxFunc) (fulfillment.Summary, error) {
+	summary, err := d.DeliverDue(ctx, tx)
+	if err != nil {
+		return fulfillment.Summary{}, fmt.Errorf("failed to deliver fulfillments: %w", err)
+	}
+	return summary, nil
+}
+
+// deliverAll attempts due deliveries batch by batch until none are left
+func deliverAll(ctx context.Context, d *fulfillment.Dispatcher, tx fulfillment.TxFunc) (*DeliverFulfillmentsResponse, error) {
+	resp := &DeliverFulfillmentsResponse{}
+	for i := 0; i < maxBatchesPerRun; i++ {
+		summary, err := deliverBatch(ctx, d, tx)
+		if err != nil {
+			return nil, err
+		}
+		resp.Delivered += summary.Delivered
+		resp.Failed += summary.Failed
+		resp.Retried += summary.Retried
+		if summary.Attempts() < d.BatchSize() {
+			break
+		}
+	}
+	return resp, nil
+}
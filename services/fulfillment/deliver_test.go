@@ -0,0 +1,148 @@
+//go:build !encore
+// +build !encore
+
+package fulfillment
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"encore.app/internal/db"
+	"encore.app/internal/fulfillment"
+	"encore.app/services/redemption"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeQueue records queued deliveries for one reward
+type fakeQueue struct {
+	reward db.RewardsCatalog
+	queued []db.CreateFulfillmentDeliveryParams
+}
+
+func (f *fakeQueue) GetReward(ctx context.Context, id uuid.UUID) (db.RewardsCatalog, error) {
+	if id != f.reward.ID {
+		return db.RewardsCatalog{}, sql.ErrNoRows
+	}
+	return f.reward, nil
+}
+
+func (f *fakeQueue) CreateFulfillmentDelivery(ctx context.Context, arg db.CreateFulfillmentDeliveryParams) (int64, error) {
+	f.queued = append(f.queued, arg)
+	return 1, nil
+}
+
+func TestEnqueue(t *testing.T) {
+	q := &fakeQueue{reward: db.RewardsCatalog{
+		ID:                 uuid.New(),
+		FulfillmentAdapter: sql.NullString{String: fulfillment.AdapterWebhook, Valid: true},
+	}}
+	event := &redemption.RedemptionCreated{
+		RedemptionID: uuid.NewString(),
+		UserID:       uuid.NewString(),
+		RewardID:     q.reward.ID.String(),
+		PointsSpent:  500,
+		Status:       "PENDING",
+		VoucherCode:  "ACME-7KQ2-PL9X",
+	}
+
+	queued, err := enqueue(context.Background(), q, event)
+	require.NoError(t, err)
+	assert.True(t, queued)
+	require.Len(t, q.queued, 1)
+	assert.Equal(t, fulfillment.AdapterWebhook, q.queued[0].Adapter)
+	assert.JSONEq(t, `{"redemption_id":"`+event.RedemptionID+`","user_id":"`+event.UserID+`","reward_id":"`+event.RewardID+`","points_spent":500,"status":"PENDING","voucher_code":"ACME-7KQ2-PL9X"}`, string(q.queued[0].Payload))
+
+	// Redemptions fulfilled on creation are not delivered
+	fulfilled := *event
+	fulfilled.Status = "FULFILLED"
+	queued, err = enqueue(context.Background(), q, &fulfilled)
+	require.NoError(t, err)
+	assert.False(t, queued)
+	assert.Len(t, q.queued, 1)
+}
+
+func TestEnqueueCheckout(t *testing.T) {
+	q := &fakeQueue{reward: db.RewardsCatalog{
+		ID:                 uuid.New(),
+		FulfillmentAdapter: sql.NullString{String: fulfillment.AdapterWebhook, Valid: true},
+	}}
+	item := redemption.RedemptionCreated{UserID: uuid.NewString(), RewardID: q.reward.ID.String(), PointsSpent: 500, Status: "PENDING"}
+	first, second, credit := item, item, item
+	first.RedemptionID, second.RedemptionID = uuid.NewString(), uuid.NewString()
+	// Charging credits are fulfilled in the checkout and never looked up
+	credit.RedemptionID, credit.RewardID, credit.Status = uuid.NewString(), uuid.NewString(), "FULFILLED"
+	event := &redemption.CheckoutCompleted{
+		CheckoutID:  uuid.NewString(),
+		UserID:      item.UserID,
+		PointsSpent: 1500,
+		Redemptions: []redemption.RedemptionCreated{first, credit, second},
+	}
+
+	queued, err := enqueueCheckout(context.Background(), q, event)
+	require.NoError(t, err)
+	assert.True(t, queued)
+	require.Len(t, q.queued, 2)
+	assert.Equal(t, uuid.MustParse(first.RedemptionID), q.queued[0].RedemptionID)
+	assert.Equal(t, uuid.MustParse(second.RedemptionID), q.queued[1].RedemptionID)
+
+	queued, err = enqueueCheckout(context.Background(), q, &redemption.CheckoutCompleted{Redemptions: []redemption.RedemptionCreated{credit}})
+	require.NoError(t, err)
+	assert.False(t, queued)
+}
+
+// batchStore claims a fixed number of deliveries per batch
+type batchStore struct {
+	fulfillment.Store
+	left    int
+	batches int
+}
+
+func (b *batchStore) ClaimDueFulfillmentDeliveries(ctx context.Context, arg db.ClaimDueFulfillmentDeliveriesParams) ([]db.FulfillmentDelivery, error) {
+	b.batches++
+	n := b.left
+	if n > int(arg.BatchSize) {
+		n = int(arg.BatchSize)
+	}
+	b.left -= n
+	deliveries := make([]db.FulfillmentDelivery, n)
+	for i := range deliveries {
+		deliveries[i] = db.FulfillmentDelivery{ID: uuid.New(), Adapter: "unknown"}
+	}
+	return deliveries, nil
+}
+
+func (b *batchStore) GetReward(ctx context.Context, id uuid.UUID) (db.RewardsCatalog, error) {
+	return db.RewardsCatalog{}, nil
+}
+
+func (b *batchStore) FinishFulfillmentDelivery(ctx context.Context, arg db.FinishFulfillmentDeliveryParams) (int64, error) {
+	return 1, nil
+}
+
+func (b *batchStore) FinishPendingRedemption(ctx context.Context, arg db.FinishPendingRedemptionParams) (int64, error) {
+	return 0, nil
+}
+
+func TestDeliverAll(t *testing.T) {
+	q := &batchStore{left: 5}
+	tx := func(ctx context.Context, fn func(q fulfillment.Store) error) error {
+		return fn(q)
+	}
+	d := fulfillment.NewDispatcher(fulfillment.Config{BatchSize: 2}, nil)
+
+	// Deliveries for unknown adapters fail at once
+	resp, err := deliverAll(context.Background(), d, tx)
+	require.NoError(t, err)
+	assert.Equal(t, 5, resp.Failed)
+	assert.Equal(t, 3, q.batches)
+
+	failing := func(ctx context.Context, fn func(q fulfillment.Store) error) error {
+		return errors.New("connection reset")
+	}
+	_, err = deliverAll(context.Background(), d, failing)
+	assert.Error(t, err)
+}
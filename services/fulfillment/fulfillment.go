@@ -0,0 +1,93 @@
+//go:build encore
+// +build encore
+
+package fulfillment
+
+import (
+	"context"
+
+	"encore.app/internal/db"
+	"encore.app/internal/fulfillment"
+	"encore.app/services/redemption"
+	"encore.dev/cron"
+	"encore.dev/pubsub"
+	"encore.dev/storage/sqldb"
+)
+
+//encore:service
+type Service struct {
+	// Service will be initialized by Encore
+}
+
+// rewardsDB is the shared rewards database
+var rewardsDB = sqldb.Named("rewards")
+
+// dispatcher delivers redemptions with the registered adapters
+var dispatcher = fulfillment.NewDispatcher(fulfillment.Config{}, map[string]fulfillment.Adapter{
+	fulfillment.AdapterWebhook: fulfillment.NewWebhook(nil),
+})
+
+// init initializes the fulfillment service
+func init() {
+	// Service will be initialized by Encore
+}
+
+// HandleRedemptionCreated queues redemptions of rewards with a fulfillment
+// adapter and attempts their delivery right away
+//
+//encore:api private
+func HandleRedemptionCreated(ctx context.Context, event *redemption.RedemptionCreated) error {
+	conn := rewardsDB.Stdlib()
+	queued, err := enqueue(ctx, db.New(conn), event)
+	if err != nil || !queued {
+		return err
+	}
+	_, err = deliverBatch(ctx, dispatcher, fulfillment.DBTx(conn))
+	return err
+}
+
+// HandleCheckoutCompleted queues the redemptions of a checkout like
+// HandleRedemptionCreated
+//
+//encore:api private
+func HandleCheckoutCompleted(ctx context.Context, event *redemption.CheckoutCompleted) error {
+	conn := rewardsDB.Stdlib()
+	queued, err := enqueueCheckout(ctx, db.New(conn), event)
+	if err != nil || !queued {
+		return err
+	}
+	_, err = deliverBatch(ctx, dispatcher, fulfillment.DBTx(conn))
+	return err
+}
+
+// DeliverFulfillments retries deliveries whose backoff has passed
+//
+//encore:api private method=POST path=/internal/fulfillment/deliver
+func DeliverFulfillments(ctx context.Context) (*DeliverFulfillmentsResponse, error) {
+	return deliverAll(ctx, dispatcher, fulfillment.DBTx(rewardsDB.Stdlib()))
+}
+
+// Retry due deliveries every minute
+var _ = cron.NewJob("deliver-fulfillments", cron.JobConfig{
+	Title:    "Retry partner fulfillment deliveries",
+	Every:    1 * cron.Minute,
+	Endpoint: DeliverFulfillments,
+})
+
+// Subscribe to RedemptionCreated events
+var _ = pubsub.NewSubscription(
+	redemption.RedemptionCreatedTopic,
+	"fulfillment-redemption-created",
+	pubsub.SubscriptionConfig[*redemption.RedemptionCreated]{
+		Handler: HandleRedemptionCreated,
+	},
+)
+
+// Subscribe to CheckoutCompleted events
+var _ = pubsub.NewSubscription(
+	redemption.CheckoutCompletedTopic,
+	"fulfillment-checkout-completed",
+	pubsub.SubscriptionConfig[*redemption.CheckoutCompleted]{
+		Handler: HandleCheckoutCompleted,
+	},
+)
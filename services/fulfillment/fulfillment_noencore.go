@@ -0,0 +1,14 @@
+//go:build !encore
+// +build !encore
+
+package fulfillment
+
+//encore:service
+type Service struct {
+	// Service will be initialized by Encore
+}
+
+// init initializes the fulfillment service
+func init() {
+	// Service will be initialized by Encore
+}
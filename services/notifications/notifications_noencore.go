@@ -42,3 +42,36 @@ func HandleRedemptionCreated(ctx context.Context, event *redemption.RedemptionCr
 
 	return nil
 }
+
+// HandleCheckoutCompleted processes CheckoutCompleted events
+func HandleCheckoutCompleted(ctx context.Context, event *redemption.CheckoutCompleted) error {
+	log.Printf("🛒 CheckoutCompleted: User %s redeemed %d rewards for %d points (Checkout: %s)",
+		event.UserID, len(event.Redemptions), event.PointsSpent, event.CheckoutID)
+
+	// TODO: Send FCM notification to user's device
+	// This will be implemented in the next task
+
+	return nil
+}
+
+// HandleRedemptionCancelled processes RedemptionCancelled events
+func HandleRedemptionCancelled(ctx context.Context, event *redemption.RedemptionCancelled) error {
+	log.Printf("↩️ RedemptionCancelled: User %s cancelled redemption %s, %d points refunded",
+		event.UserID, event.RedemptionID, event.PointsRefunded)
+
+	// TODO: Send FCM notification to user's device
+	// This will be implemented in the next task
+
+	return nil
+}
+
+// HandleVoucherPoolLow alerts operations that a reward's voucher codes are running out
+func HandleVoucherPoolLow(ctx context.Context, event *redemption.VoucherPoolLow) error {
+	log.Printf("⚠️ VoucherPoolLow: Reward %s (%s) has %d voucher codes left (threshold %d)",
+		event.RewardID, event.RewardName, event.Available, event.Threshold)
+
+	// TODO: Page the partner team
+	// Upload more codes with POST /admin/rewards/{id}/codes
+
+	return nil
+}
@@ -40,3 +40,48 @@ func TestHandleRedemptionCreated(t *testing.T) {
 		t.Errorf("HandleRedemptionCreated failed: %v", err)
 	}
 }
+
+func TestHandleCheckoutCompleted(t *testing.T) {
+	event := &redemption.CheckoutCompleted{
+		CheckoutID:  "checkout123",
+		UserID:      "user456",
+		PointsSpent: 800,
+		Redemptions: []redemption.RedemptionCreated{
+			{RedemptionID: "redemption1", RewardID: "reward789", PointsSpent: 500, Status: "PENDING"},
+			{RedemptionID: "redemption2", RewardID: "reward790", PointsSpent: 300, Status: "FULFILLED"},
+		},
+	}
+
+	err := HandleCheckoutCompleted(context.Background(), event)
+	if err != nil {
+		t.Errorf("HandleCheckoutCompleted failed: %v", err)
+	}
+}
+
+func TestHandleRedemptionCancelled(t *testing.T) {
+	event := &redemption.RedemptionCancelled{
+		RedemptionID:   "redemption123",
+		UserID:         "user123",
+		RewardID:       "reward456",
+		PointsRefunded: 500,
+	}
+
+	err := HandleRedemptionCancelled(context.Background(), event)
+	if err != nil {
+		t.Errorf("HandleRedemptionCancelled failed: %v", err)
+	}
+}
+
+func TestHandleVoucherPoolLow(t *testing.T) {
+	event := &redemption.VoucherPoolLow{
+		RewardID:   "reward789",
+		RewardName: "Partner coupon",
+		Available:  10,
+		Threshold:  10,
+	}
+
+	err := HandleVoucherPoolLow(context.Background(), event)
+	if err != nil {
+		t.Errorf("HandleVoucherPoolLow failed: %v", err)
+	}
+}
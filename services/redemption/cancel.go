@@ -0,0 +1,167 @@
+package redemption
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"encore.app/internal/auth"
+	"encore.app/internal/db"
+	"encore.app/internal/inventory"
+	"encore.app/internal/outbox"
+	"encore.dev/beta/errs"
+	"github.com/google/uuid"
+)
+
+// DefaultCancelWindow is how long after redeeming a user may cancel, unless
+// REDEMPTION_CANCEL_WINDOW sets another duration ("0" disables cancellation)
+const DefaultCancelWindow = 15 * time.Minute
+
+// refundEventType is the points event type returning a cancelled
+// redemption's points
+const refundEventType = "REDEMPTION_REFUND"
+
+// cancelWindow returns the configured cancellation window
+func cancelWindow() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("REDEMPTION_CANCEL_WINDOW")); err == nil && d >= 0 {
+		return d
+	}
+	return DefaultCancelWindow
+}
+
+// CancelRedemption cancels one of the authenticated user's pending
+// redemptions, refunding its points and returning its stock
+//
+//encore:api auth method=POST path=/v1/redemptions/:id/cancel
+func (s *Service) CancelRedemption(ctx context.Context, id string) (*CancelRedemptionResponse, error) {
+	principal, err := auth.RequireUser(auth.CurrentPrincipal(), "")
+	if err != nil {
+		return nil, err
+	}
+	userID, err := uuid.Parse(principal.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user ID: %w", err)
+	}
+	redemptionID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, errRedemptionNotFound
+	}
+
+	now := time.Now()
+	var cancelled db.Redemption
+	err = db.WithTx(ctx, s.conn, func(q *db.Queries) error {
+		cancelled, err = cancelRedemption(ctx, q, userID, redemptionID, cancelWindow(), now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &CancelRedemptionResponse{
+		RedemptionID:   cancelled.ID.String(),
+		Status:         cancelled.Status,
+		PointsRefunded: cancelled.PointsSpent,
+		CancelledAt:    now,
+	}, nil
+}
+
+// cancelStore is the subset of *db.Queries used to cancel redemptions
+type cancelStore interface {
+	inventory.Releaser
+	outbox.Enqueuer
+	voucherWithdrawer
+	LockPendingFulfillmentDelivery(ctx context.Context, redemptionID uuid.UUID) (db.FulfillmentDelivery, error)
+	CancelFulfillmentDelivery(ctx context.Context, redemptionID uuid.UUID) error
+	CancelPendingRedemption(ctx context.Context, arg db.CancelPendingRedemptionParams) (db.Redemption, error)
+	GetRedemption(ctx context.Context, id uuid.UUID) (db.Redemption, error)
+	CreatePointsEvent(ctx context.Context, arg db.CreatePointsEventParams) (db.PointsEvent, error)
+}
+
+// cancelRedemption cancels a user's pending redemption made within window of
+// now, refunds its points, returns its stock, voids its voucher code and
+// records the RedemptionCancelled event. Call it in a transaction.
+func cancelRedemption(ctx context.Context, q cancelStore, userID, redemptionID uuid.UUID, window time.Duration, now time.Time) (db.Redemption, error) {
+	// Stop the partner delivery first, taking the locks in the same order as
+	// the fulfillment dispatcher. A delivery leased for an attempt is being
+	// sent to the partner, so the redemption cannot be taken back until the
+	// attempt is recorded.
+	delivery, err := q.LockPendingFulfillmentDelivery(ctx, redemptionID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return db.Redemption{}, fmt.Errorf("failed to lock fulfillment: %w", err)
+	case delivery.LockedUntil.Valid && delivery.LockedUntil.Time.After(now):
+		return db.Redemption{}, &errs.Error{
+			Code:    errs.FailedPrecondition,
+			Message: "redemption is being delivered to the partner; try again shortly",
+			Details: CancelErrorDetails{Reason: reasonDelivering, Status: "PENDING"},
+		}
+	default:
+		if err := q.CancelFulfillmentDelivery(ctx, redemptionID); err != nil {
+			return db.Redemption{}, fmt.Errorf("failed to cancel fulfillment: %w", err)
+		}
+	}
+
+	redemption, err := q.CancelPendingRedemption(ctx, db.CancelPendingRedemptionParams{
+		ID:               redemptionID,
+		UserID:           userID,
+		CancellableAfter: now.Add(-window),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return db.Redemption{}, notCancellable(ctx, q, userID, redemptionID, window)
+	}
+	if err != nil {
+		return db.Redemption{}, fmt.Errorf("failed to cancel redemption: %w", err)
+	}
+
+	if _, err := q.CreatePointsEvent(ctx, db.CreatePointsEventParams{
+		UserID:    userID,
+		EventType: refundEventType,
+		RefID:     sql.NullString{String: redemption.ID.String(), Valid: true},
+		Points:    redemption.PointsSpent,
+	}); err != nil {
+		return db.Redemption{}, fmt.Errorf("failed to refund points: %w", err)
+	}
+	if err := inventory.Release(ctx, q, redemption.RewardID, redemption.CreatedAt); err != nil {
+		return db.Redemption{}, fmt.Errorf("failed to release stock: %w", err)
+	}
+	// The user has seen the code, so it is voided rather than reissued
+	redemption, err = withdrawVoucher(ctx, q, redemption, true)
+	if err != nil {
+		return db.Redemption{}, err
+	}
+	err = outbox.Enqueue(ctx, q, redemptionCancelledOutboxTopic, userID.String(), &RedemptionCancelled{
+		RedemptionID:   redemption.ID.String(),
+		UserID:         userID.String(),
+		RewardID:       redemption.RewardID.String(),
+		PointsRefunded: redemption.PointsSpent,
+		CancelledAt:    now,
+	})
+	return redemption, err
+}
+
+// notCancellable explains why a redemption could not be cancelled
+func notCancellable(ctx context.Context, q cancelStore, userID, redemptionID uuid.UUID, window time.Duration) error {
+	redemption, err := q.GetRedemption(ctx, redemptionID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && redemption.UserID != userID) {
+		return errRedemptionNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get redemption: %w", err)
+	}
+	if redemption.Status != "PENDING" {
+		return &errs.Error{
+			Code:    errs.FailedPrecondition,
+			Message: fmt.Sprintf("redemption is %s and can no longer be cancelled", redemption.Status),
+			Details: CancelErrorDetails{Reason: reasonNotPending, Status: redemption.Status},
+		}
+	}
+	deadline := redemption.CreatedAt.Add(window)
+	return &errs.Error{
+		Code:    errs.FailedPrecondition,
+		Message: "the cancellation window has passed",
+		Details: CancelErrorDetails{Reason: reasonWindowPassed, Status: redemption.Status, CancellableUntil: &deadline},
+	}
+}
@@ -0,0 +1,221 @@
+//go:build !encore
+// +build !encore
+
+package redemption
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"encore.app/internal/db"
+	"encore.dev/beta/errs"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCancelStore holds redemptions and records the cancellation's writes
+type fakeCancelStore struct {
+	redemptions map[uuid.UUID]*db.Redemption
+	deliveries  map[uuid.UUID]string
+	leases      map[uuid.UUID]time.Time
+	// pooledCodes marks redemptions holding a pool code
+	pooledCodes   map[uuid.UUID]bool
+	returnedCodes []uuid.UUID
+	voidedCodes   []uuid.UUID
+	events        []db.CreatePointsEventParams
+	released      []uuid.UUID
+	outbox        []db.EnqueueOutboxEventParams
+}
+
+func (f *fakeCancelStore) LockPendingFulfillmentDelivery(ctx context.Context, redemptionID uuid.UUID) (db.FulfillmentDelivery, error) {
+	if f.deliveries[redemptionID] != "PENDING" {
+		return db.FulfillmentDelivery{}, sql.ErrNoRows
+	}
+	lease, leased := f.leases[redemptionID]
+	return db.FulfillmentDelivery{RedemptionID: redemptionID, Status: "PENDING", LockedUntil: sql.NullTime{Time: lease, Valid: leased}}, nil
+}
+
+func (f *fakeCancelStore) CancelFulfillmentDelivery(ctx context.Context, redemptionID uuid.UUID) error {
+	if f.deliveries[redemptionID] == "PENDING" {
+		f.deliveries[redemptionID] = "CANCELLED"
+	}
+	return nil
+}
+
+func (f *fakeCancelStore) CancelPendingRedemption(ctx context.Context, arg db.CancelPendingRedemptionParams) (db.Redemption, error) {
+	r, ok := f.redemptions[arg.ID]
+	if !ok || r.UserID != arg.UserID || r.Status != "PENDING" || !r.CreatedAt.After(arg.CancellableAfter) {
+		return db.Redemption{}, sql.ErrNoRows
+	}
+	r.Status = "CANCELLED"
+	return *r, nil
+}
+
+func (f *fakeCancelStore) GetRedemption(ctx context.Context, id uuid.UUID) (db.Redemption, error) {
+	r, ok := f.redemptions[id]
+	if !ok {
+		return db.Redemption{}, sql.ErrNoRows
+	}
+	return *r, nil
+}
+
+func (f *fakeCancelStore) CreatePointsEvent(ctx context.Context, arg db.CreatePointsEventParams) (db.PointsEvent, error) {
+	f.events = append(f.events, arg)
+	return db.PointsEvent{ID: uuid.New()}, nil
+}
+
+func (f *fakeCancelStore) ReleaseRewardStock(ctx context.Context, id uuid.UUID) error {
+	f.released = append(f.released, id)
+	return nil
+}
+
+func (f *fakeCancelStore) ReleaseDailyRewardStock(ctx context.Context, arg db.ReleaseDailyRewardStockParams) error {
+	return nil
+}
+
+func (f *fakeCancelStore) ReleaseRewardCode(ctx context.Context, redemptionID uuid.NullUUID) (int64, error) {
+	if !f.pooledCodes[redemptionID.UUID] {
+		return 0, nil
+	}
+	f.returnedCodes = append(f.returnedCodes, redemptionID.UUID)
+	return 1, nil
+}
+
+func (f *fakeCancelStore) VoidRewardCode(ctx context.Context, redemptionID uuid.NullUUID) error {
+	f.voidedCodes = append(f.voidedCodes, redemptionID.UUID)
+	return nil
+}
+
+func (f *fakeCancelStore) SetRedemptionVoucherCode(ctx context.Context, arg db.SetRedemptionVoucherCodeParams) error {
+	f.redemptions[arg.ID].VoucherCode = arg.VoucherCode
+	return nil
+}
+
+func (f *fakeCancelStore) EnqueueOutboxEvent(ctx context.Context, arg db.EnqueueOutboxEventParams) (db.EventOutbox, error) {
+	f.outbox = append(f.outbox, arg)
+	return db.EventOutbox{}, nil
+}
+
+func TestCancelRedemption(t *testing.T) {
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	userID := uuid.New()
+	pending := &db.Redemption{
+		ID: uuid.New(), UserID: userID, RewardID: uuid.New(), PointsSpent: 500, Status: "PENDING", CreatedAt: now.Add(-5 * time.Minute),
+		VoucherCode: sql.NullString{String: "ACME-7KQ2-PL9X", Valid: true},
+	}
+	q := &fakeCancelStore{
+		redemptions: map[uuid.UUID]*db.Redemption{pending.ID: pending},
+		deliveries:  map[uuid.UUID]string{pending.ID: "PENDING"},
+		pooledCodes: map[uuid.UUID]bool{pending.ID: true},
+	}
+
+	cancelled, err := cancelRedemption(context.Background(), q, userID, pending.ID, 15*time.Minute, now)
+	require.NoError(t, err)
+	assert.Equal(t, "CANCELLED", cancelled.Status)
+	assert.Equal(t, "CANCELLED", q.deliveries[pending.ID])
+
+	// The code the user has seen is voided, not returned to the pool
+	assert.False(t, cancelled.VoucherCode.Valid)
+	assert.False(t, pending.VoucherCode.Valid)
+	assert.Equal(t, []uuid.UUID{pending.ID}, q.voidedCodes)
+	assert.Empty(t, q.returnedCodes)
+
+	// The points come back and the stock is returned
+	require.Len(t, q.events, 1)
+	assert.Equal(t, refundEventType, q.events[0].EventType)
+	assert.Equal(t, int32(500), q.events[0].Points)
+	assert.Equal(t, pending.ID.String(), q.events[0].RefID.String)
+	assert.Equal(t, []uuid.UUID{pending.RewardID}, q.released)
+
+	require.Len(t, q.outbox, 1)
+	assert.Equal(t, redemptionCancelledOutboxTopic, q.outbox[0].Topic)
+	var event RedemptionCancelled
+	require.NoError(t, json.Unmarshal(q.outbox[0].Payload, &event))
+	assert.Equal(t, int32(500), event.PointsRefunded)
+
+	// A second cancellation is refused
+	_, err = cancelRedemption(context.Background(), q, userID, pending.ID, 15*time.Minute, now)
+	var e *errs.Error
+	require.ErrorAs(t, err, &e)
+	assert.Equal(t, errs.FailedPrecondition, e.Code)
+	assert.Equal(t, reasonNotPending, e.Details.(CancelErrorDetails).Reason)
+	assert.Len(t, q.events, 1)
+}
+
+func TestCancelRedemption_Refused(t *testing.T) {
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	userID := uuid.New()
+	old := &db.Redemption{ID: uuid.New(), UserID: userID, PointsSpent: 500, Status: "PENDING", CreatedAt: now.Add(-time.Hour)}
+	fulfilled := &db.Redemption{ID: uuid.New(), UserID: userID, PointsSpent: 500, Status: "FULFILLED", CreatedAt: now}
+	other := &db.Redemption{ID: uuid.New(), UserID: uuid.New(), PointsSpent: 500, Status: "PENDING", CreatedAt: now}
+	q := &fakeCancelStore{redemptions: map[uuid.UUID]*db.Redemption{old.ID: old, fulfilled.ID: fulfilled, other.ID: other}}
+
+	tests := []struct {
+		name       string
+		id         uuid.UUID
+		wantCode   errs.ErrCode
+		wantReason string
+	}{
+		{"window passed", old.ID, errs.FailedPrecondition, reasonWindowPassed},
+		{"already fulfilled", fulfilled.ID, errs.FailedPrecondition, reasonNotPending},
+		{"another user's", other.ID, errs.NotFound, ""},
+		{"unknown", uuid.New(), errs.NotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := cancelRedemption(context.Background(), q, userID, tt.id, 15*time.Minute, now)
+			var e *errs.Error
+			require.ErrorAs(t, err, &e)
+			assert.Equal(t, tt.wantCode, e.Code)
+			if tt.wantReason != "" {
+				assert.Equal(t, tt.wantReason, e.Details.(CancelErrorDetails).Reason)
+			}
+		})
+	}
+
+	_, err := cancelRedemption(context.Background(), q, userID, old.ID, 15*time.Minute, now)
+	var e *errs.Error
+	require.ErrorAs(t, err, &e)
+	assert.Equal(t, old.CreatedAt.Add(15*time.Minute), *e.Details.(CancelErrorDetails).CancellableUntil)
+	assert.Empty(t, q.events)
+	assert.Equal(t, "PENDING", other.Status)
+}
+
+func TestCancelRedemption_DeliveryInProgress(t *testing.T) {
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	userID := uuid.New()
+	pending := &db.Redemption{ID: uuid.New(), UserID: userID, RewardID: uuid.New(), PointsSpent: 500, Status: "PENDING", CreatedAt: now.Add(-5 * time.Minute)}
+	q := &fakeCancelStore{
+		redemptions: map[uuid.UUID]*db.Redemption{pending.ID: pending},
+		deliveries:  map[uuid.UUID]string{pending.ID: "PENDING"},
+		leases:      map[uuid.UUID]time.Time{pending.ID: now.Add(time.Minute)},
+	}
+
+	_, err := cancelRedemption(context.Background(), q, userID, pending.ID, 15*time.Minute, now)
+	var e *errs.Error
+	require.ErrorAs(t, err, &e)
+	assert.Equal(t, errs.FailedPrecondition, e.Code)
+	assert.Equal(t, reasonDelivering, e.Details.(CancelErrorDetails).Reason)
+	assert.Equal(t, "PENDING", pending.Status)
+	assert.Equal(t, "PENDING", q.deliveries[pending.ID])
+	assert.Empty(t, q.events)
+
+	// Once the lease has run out the redemption can be cancelled
+	cancelled, err := cancelRedemption(context.Background(), q, userID, pending.ID, 15*time.Minute, now.Add(2*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, "CANCELLED", cancelled.Status)
+	assert.Equal(t, "CANCELLED", q.deliveries[pending.ID])
+}
+
+func TestCancelWindow(t *testing.T) {
+	t.Setenv("REDEMPTION_CANCEL_WINDOW", "")
+	assert.Equal(t, DefaultCancelWindow, cancelWindow())
+	t.Setenv("REDEMPTION_CANCEL_WINDOW", "1h")
+	assert.Equal(t, time.Hour, cancelWindow())
+	t.Setenv("REDEMPTION_CANCEL_WINDOW", "0")
+	assert.Zero(t, cancelWindow())
+}
@@ -0,0 +1,194 @@
+package redemption
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"encore.app/internal/auth"
+	"encore.app/internal/db"
+	"encore.app/internal/outbox"
+	"encore.app/internal/pricing"
+	"encore.app/internal/segments"
+	"encore.dev/beta/errs"
+	"github.com/google/uuid"
+)
+
+// maxCheckoutUnits caps the redemptions one checkout makes
+const maxCheckoutUnits = 10
+
+// cartLine is one reward of a checkout and the units redeemed of it
+type cartLine struct {
+	rewardID uuid.UUID
+	quantity int32
+	reward   db.RewardsCatalog
+	price    pricing.Price
+}
+
+// parseCart validates a checkout's items and merges repeated rewards, keeping
+// the order in which they first appear
+func parseCart(items []CheckoutItem) ([]cartLine, error) {
+	if len(items) == 0 {
+		return nil, &errs.Error{Code: errs.InvalidArgument, Message: "checkout has no items"}
+	}
+	var lines []cartLine
+	index := map[uuid.UUID]int{}
+	units := 0
+	for _, item := range items {
+		rewardID, err := uuid.Parse(item.RewardID)
+		if err != nil {
+			return nil, &errs.Error{Code: errs.InvalidArgument, Message: fmt.Sprintf("invalid reward ID %q", item.RewardID)}
+		}
+		quantity := item.Quantity
+		if quantity == 0 {
+			quantity = 1
+		}
+		if quantity < 0 {
+			return nil, &errs.Error{Code: errs.InvalidArgument, Message: "quantity must be at least 1"}
+		}
+		units += int(quantity)
+		if units > maxCheckoutUnits {
+			return nil, &errs.Error{Code: errs.InvalidArgument, Message: fmt.Sprintf("checkout can redeem at most %d rewards", maxCheckoutUnits)}
+		}
+		if i, ok := index[rewardID]; ok {
+			lines[i].quantity += quantity
+			continue
+		}
+		index[rewardID] = len(lines)
+		lines = append(lines, cartLine{rewardID: rewardID, quantity: quantity})
+	}
+	return lines, nil
+}
+
+// cartTotal returns the points a checkout costs
+func cartTotal(lines []cartLine) int64 {
+	var total int64
+	for _, line := range lines {
+		total += int64(line.price.Cost) * int64(line.quantity)
+	}
+	return total
+}
+
+// itemError maps a cart item's failure like redeemError and names the item's
+// reward: in RedeemErrorDetails when the error has them, otherwise in the
+// message
+func itemError(rewardID uuid.UUID, err error, now time.Time) error {
+	e, ok := err.(*errs.Error)
+	if !ok {
+		if e, ok = redeemError(err, now).(*errs.Error); !ok {
+			return fmt.Errorf("reward %s: %w", rewardID, err)
+		}
+	}
+	item := *e
+	if d, ok := item.Details.(RedeemErrorDetails); ok {
+		d.RewardID = rewardID.String()
+		item.Details = d
+	} else {
+		item.Message = fmt.Sprintf("reward %s: %s", rewardID, item.Message)
+	}
+	return &item
+}
+
+// Checkout spends the authenticated user's points on several rewards at once.
+// Every item is checked for eligibility, stock and per-user limits, and the
+// whole cart is redeemed in one transaction: if any item fails, nothing is
+// redeemed.
+//
+//encore:api auth method=POST path=/v1/checkout
+func (s *Service) Checkout(ctx context.Context, req *CheckoutRequest) (*CheckoutResponse, error) {
+	// The user comes from the token; a user_id in the body must match it
+	principal, err := auth.RequireUser(auth.CurrentPrincipal(), req.UserID)
+	if err != nil {
+		return nil, err
+	}
+	userID, err := uuid.Parse(principal.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user ID: %w", err)
+	}
+	lines, err := parseCart(req.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := segments.LoadUser(ctx, s.db, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+
+	// Check every reward may be redeemed and price it, as quoted in the
+	// catalog
+	now := time.Now()
+	for i := range lines {
+		line := &lines[i]
+		line.reward, err = s.redeemableReward(ctx, line.rewardID, user, now)
+		if err != nil {
+			return nil, itemError(line.rewardID, err, now)
+		}
+		price, err := pricing.Evaluate(ctx, s.db, line.reward, user, now)
+		if err != nil {
+			return nil, err
+		}
+		line.price, err = quotedPrice(ctx, s.db, userID, req.QuoteID, line.rewardID, price, now)
+		if err != nil {
+			return nil, itemError(line.rewardID, err, now)
+		}
+	}
+	total := cartTotal(lines)
+
+	// Check the limits and balance, spend the points and record the
+	// CheckoutCompleted notification in one transaction
+	var checkout db.Checkout
+	resp := &CheckoutResponse{}
+	event := &CheckoutCompleted{UserID: userID.String()}
+	err = db.WithTx(ctx, s.conn, func(q *db.Queries) error {
+		// Serialize the user's redemptions so concurrent requests cannot
+		// both pass the limit and balance checks
+		if err := q.LockUserRedemptions(ctx, userID); err != nil {
+			return fmt.Errorf("failed to lock user: %w", err)
+		}
+
+		balance, err := q.GetUserPointsBalance(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("failed to get user balance: %w", err)
+		}
+		if balance < total {
+			return insufficientPoints(total, balance)
+		}
+
+		checkout, err = q.CreateCheckout(ctx, db.CreateCheckoutParams{UserID: userID, PointsSpent: int32(total)})
+		if err != nil {
+			return fmt.Errorf("failed to create checkout: %w", err)
+		}
+
+		for _, line := range lines {
+			for n := int32(0); n < line.quantity; n++ {
+				redemption, err := redeemUnit(ctx, q, unitRedemption{
+					userID:     userID,
+					reward:     line.reward,
+					price:      line.price,
+					status:     "PENDING",
+					expiresAt:  now.Add(pendingTTL(line.reward)),
+					checkoutID: uuid.NullUUID{UUID: checkout.ID, Valid: true},
+				}, now)
+				if err != nil {
+					return itemError(line.rewardID, err, now)
+				}
+				redemption, credit, err := fulfillCredit(ctx, q, line.reward, redemption, now)
+				if err != nil {
+					return itemError(line.rewardID, err, now)
+				}
+				resp.Redemptions = append(resp.Redemptions, *redeemResponse(redemption, credit, nil))
+				event.Redemptions = append(event.Redemptions, *redemptionCreated(redemption))
+			}
+		}
+
+		event.CheckoutID, event.PointsSpent = checkout.ID.String(), checkout.PointsSpent
+		return outbox.Enqueue(ctx, q, checkoutOutboxTopic, userID.String(), event)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp.CheckoutID, resp.PointsSpent = checkout.ID.String(), checkout.PointsSpent
+	return resp, nil
+}
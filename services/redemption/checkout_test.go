@@ -0,0 +1,76 @@
+//go:build !encore
+// +build !encore
+
+package redemption
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"encore.app/internal/inventory"
+	"encore.app/internal/limits"
+	"encore.app/internal/pricing"
+	"encore.dev/beta/errs"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCart(t *testing.T) {
+	coffee, session := uuid.New(), uuid.New()
+	lines, err := parseCart([]CheckoutItem{
+		{RewardID: coffee.String()},
+		{RewardID: session.String(), Quantity: 2},
+		{RewardID: coffee.String(), Quantity: 3},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []cartLine{{rewardID: coffee, quantity: 4}, {rewardID: session, quantity: 2}}, lines)
+
+	invalid := map[string][]CheckoutItem{
+		"empty":          nil,
+		"bad reward ID":  {{RewardID: "coffee"}},
+		"negative":       {{RewardID: coffee.String(), Quantity: -1}},
+		"too many units": {{RewardID: coffee.String(), Quantity: maxCheckoutUnits}, {RewardID: session.String()}},
+	}
+	for name, items := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseCart(items)
+			var e *errs.Error
+			require.ErrorAs(t, err, &e)
+			assert.Equal(t, errs.InvalidArgument, e.Code)
+		})
+	}
+}
+
+func TestCartTotal(t *testing.T) {
+	lines := []cartLine{
+		{quantity: 2, price: pricing.Price{Cost: 400, BaseCost: 500, Rule: pricing.TypeSale}},
+		{quantity: 1, price: pricing.Price{Cost: 300, BaseCost: 300}},
+	}
+	assert.Equal(t, int64(1100), cartTotal(lines))
+}
+
+func TestItemError(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	rewardID := uuid.New()
+
+	// Refusals name the item in their details
+	var e *errs.Error
+	require.ErrorAs(t, itemError(rewardID, &limits.Error{Reason: limits.ReasonLifetimeLimit, Limit: 1}, now), &e)
+	assert.Equal(t, errs.ResourceExhausted, e.Code)
+	assert.Equal(t, RedeemErrorDetails{Reason: limits.ReasonLifetimeLimit, Limit: 1, RewardID: rewardID.String()}, e.Details)
+	require.ErrorAs(t, itemError(rewardID, inventory.ErrSoldOut, now), &e)
+	assert.Equal(t, RedeemErrorDetails{Reason: reasonSoldOut, RewardID: rewardID.String()}, e.Details)
+
+	// Other API errors name it in their message, without changing the original
+	require.ErrorAs(t, itemError(rewardID, errUnknownQuote, now), &e)
+	assert.Equal(t, errs.InvalidArgument, e.Code)
+	assert.Contains(t, e.Message, rewardID.String())
+	assert.Equal(t, "unknown price quote for this reward", errUnknownQuote.Message)
+
+	other := errors.New("reward is not active")
+	err := itemError(rewardID, other, now)
+	assert.ErrorIs(t, err, other)
+	assert.Contains(t, err.Error(), rewardID.String())
+}
@@ -0,0 +1,125 @@
+package redemption
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"encore.app/internal/availability"
+	"encore.app/internal/inventory"
+	"encore.app/internal/limits"
+	"encore.app/internal/vouchers"
+	"encore.dev/beta/errs"
+)
+
+// Reasons reported in RedeemErrorDetails, besides the limits.Reason values
+const (
+	reasonSoldOut      = "sold_out"
+	reasonSoldOutToday = "sold_out_today"
+	reasonPriceChanged = "price_changed"
+	reasonNoPoints     = "insufficient_points"
+)
+
+// Reasons reported in CancelErrorDetails
+const (
+	reasonNotPending   = "not_pending"
+	reasonWindowPassed = "window_passed"
+	reasonDelivering   = "delivering"
+)
+
+// errRedemptionNotFound is returned for redemptions that do not exist or
+// belong to another user
+var errRedemptionNotFound = &errs.Error{Code: errs.NotFound, Message: "redemption not found"}
+
+// RedeemErrorDetails tells the app why a redemption was refused and when the
+// user may try again
+type RedeemErrorDetails struct {
+	// Reason is lifetime_limit, monthly_limit, cooldown, sold_out,
+	// sold_out_today, not_yet_available, no_longer_available,
+	// not_available_today, price_changed or insufficient_points
+	Reason string `json:"reason"`
+	// Limit is the redemption count allowed, for the count limits
+	Limit int32 `json:"limit,omitempty"`
+	// Cost is the reward's current price, for price_changed
+	Cost int32 `json:"cost,omitempty"`
+	// Need and Have are the points required and the user's balance, for
+	// insufficient_points
+	Need int64 `json:"need,omitempty"`
+	Have int64 `json:"have,omitempty"`
+	// RewardID is the cart item refused, for checkouts
+	RewardID string `json:"reward_id,omitempty"`
+	// AvailableAt is when the reward can be redeemed again, if ever
+	AvailableAt *time.Time `json:"available_at,omitempty"`
+	// RetryAfterSeconds is the time until AvailableAt
+	RetryAfterSeconds int `json:"retry_after_seconds,omitempty"`
+}
+
+// ErrDetails marks RedeemErrorDetails as Encore error details
+func (RedeemErrorDetails) ErrDetails() {}
+
+// CancelErrorDetails tells the app why a redemption could not be cancelled
+type CancelErrorDetails struct {
+	// Reason is not_pending, window_passed or delivering
+	Reason string `json:"reason"`
+	// Status is the redemption's current status
+	Status string `json:"status"`
+	// CancellableUntil is when the cancellation window closed
+	CancellableUntil *time.Time `json:"cancellable_until,omitempty"`
+}
+
+// ErrDetails marks CancelErrorDetails as Encore error details
+func (CancelErrorDetails) ErrDetails() {}
+
+// insufficientPoints refuses a redemption needing more points than the
+// user's balance
+func insufficientPoints(need, have int64) error {
+	return &errs.Error{
+		Code:    errs.FailedPrecondition,
+		Message: fmt.Sprintf("insufficient points: need %d, have %d", need, have),
+		Details: RedeemErrorDetails{Reason: reasonNoPoints, Need: need, Have: have},
+	}
+}
+
+// redeemError maps availability, limit and stock failures to API errors
+// carrying RedeemErrorDetails; other errors are returned unchanged
+func redeemError(err error, now time.Time) error {
+	var limit *limits.Error
+	var unavailable *availability.Error
+	switch {
+	case errors.As(err, &unavailable):
+		return &errs.Error{
+			Code:    errs.FailedPrecondition,
+			Message: unavailable.Error(),
+			Details: retryDetails(RedeemErrorDetails{Reason: unavailable.Reason}, unavailable.AvailableAt, now),
+		}
+	case errors.As(err, &limit):
+		return &errs.Error{
+			Code:    errs.ResourceExhausted,
+			Message: limit.Error(),
+			Details: retryDetails(RedeemErrorDetails{Reason: limit.Reason, Limit: limit.Limit}, limit.AvailableAt, now),
+		}
+	case errors.Is(err, inventory.ErrSoldOut), errors.Is(err, vouchers.ErrPoolEmpty):
+		return &errs.Error{
+			Code:    errs.ResourceExhausted,
+			Message: inventory.ErrSoldOut.Error(),
+			Details: RedeemErrorDetails{Reason: reasonSoldOut},
+		}
+	case errors.Is(err, inventory.ErrSoldOutToday):
+		return &errs.Error{
+			Code:    errs.ResourceExhausted,
+			Message: err.Error(),
+			Details: retryDetails(RedeemErrorDetails{Reason: reasonSoldOutToday}, inventory.Day(now).AddDate(0, 0, 1), now),
+		}
+	}
+	return err
+}
+
+// retryDetails sets when the user may retry, if availableAt is known
+func retryDetails(d RedeemErrorDetails, availableAt, now time.Time) RedeemErrorDetails {
+	if availableAt.IsZero() {
+		return d
+	}
+	d.AvailableAt = &availableAt
+	d.RetryAfterSeconds = int(availableAt.Sub(now).Round(time.Second).Seconds())
+	return d
+}
@@ -1,41 +1,68 @@
-//go:build encore
-// +build encore
-
 package redemption
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
 	"time"
 
 	"encore.app/internal/db"
 )
 
-//encore:api cron name=expire_pending schedule="0 0 * * *"
-func ExpirePendingRedemptions(ctx context.Context) error {
-	// Get database connection
-	queries := db.New(nil) // Encore injects DB
+// defaultPendingTTL is how long a redemption may stay PENDING before it
+// expires, for rewards without a pending_ttl_seconds of their own
+const defaultPendingTTL = 24 * time.Hour
+
+// expireBatchSize bounds the redemptions expired per transaction
+const expireBatchSize = 100
 
-	// Calculate the cutoff time (24 hours ago)
-	cutoffTime := time.Now().Add(-24 * time.Hour)
+// ExpirePendingResponse summarizes an expiry run
+type ExpirePendingResponse struct {
+	Expired int `json:"expired"`
+}
 
-	// Get all pending redemptions older than 24 hours
-	oldRedemptions, err := queries.GetPendingRedemptionsOlderThan(ctx, cutoffTime)
-	if err != nil {
-		return err
+// pendingTTL returns how long a redemption of reward may stay PENDING
+func pendingTTL(reward db.RewardsCatalog) time.Duration {
+	if reward.PendingTtlSeconds.Valid && reward.PendingTtlSeconds.Int32 > 0 {
+		return time.Duration(reward.PendingTtlSeconds.Int32) * time.Second
 	}
+	return defaultPendingTTL
+}
+
+// pendingExpirer is the subset of *db.Queries used to expire redemptions
+type pendingExpirer interface {
+	ExpireDueRedemptions(ctx context.Context, arg db.ExpireDueRedemptionsParams) ([]db.Redemption, error)
+}
+
+// txFunc runs fn inside a database transaction
+type txFunc func(ctx context.Context, fn func(q pendingExpirer) error) error
 
-	// Update each redemption to EXPIRED status
-	for _, redemption := range oldRedemptions {
-		_, err := queries.UpdateRedemptionStatus(ctx, db.UpdateRedemptionStatusParams{
-			ID:     redemption.ID,
-			Status: "EXPIRED",
+// dbTx returns a txFunc running transactions on conn
+func dbTx(conn *sql.DB) txFunc {
+	return func(ctx context.Context, fn func(q pendingExpirer) error) error {
+		return db.WithTx(ctx, conn, func(q *db.Queries) error {
+			return fn(q)
+		})
+	}
+}
+
+// expirePending expires pending redemptions past their expiry in batches,
+// each in its own transaction, until none are left
+func expirePending(ctx context.Context, tx txFunc, now time.Time) (*ExpirePendingResponse, error) {
+	resp := &ExpirePendingResponse{}
+	for {
+		var expired int
+		err := tx(ctx, func(q pendingExpirer) error {
+			rows, err := q.ExpireDueRedemptions(ctx, db.ExpireDueRedemptionsParams{Now: now, BatchSize: expireBatchSize})
+			expired = len(rows)
+			return err
 		})
 		if err != nil {
-			// Log error but continue processing other redemptions
-			// In production, you might want to handle this differently
-			continue
+			return nil, fmt.Errorf("failed to expire redemptions: %w", err)
+		}
+		resp.Expired += expired
+		if expired < expireBatchSize {
+			return resp, nil
 		}
 	}
-
-	return nil
 }
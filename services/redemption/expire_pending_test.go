@@ -0,0 +1,68 @@
+//go:build !encore
+// +build !encore
+
+package redemption
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"encore.app/internal/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePendingExpirer has due redemptions to expire in batches
+type fakePendingExpirer struct {
+	due int
+	now time.Time
+}
+
+func (f *fakePendingExpirer) ExpireDueRedemptions(ctx context.Context, arg db.ExpireDueRedemptionsParams) ([]db.Redemption, error) {
+	f.now = arg.Now
+	n := min(f.due, int(arg.BatchSize))
+	f.due -= n
+	return make([]db.Redemption, n), nil
+}
+
+func TestExpirePending(t *testing.T) {
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	q := &fakePendingExpirer{due: 2*expireBatchSize + 7}
+	batches := 0
+	tx := func(ctx context.Context, fn func(q pendingExpirer) error) error {
+		batches++
+		return fn(q)
+	}
+
+	resp, err := expirePending(context.Background(), tx, now)
+	require.NoError(t, err)
+	assert.Equal(t, 2*expireBatchSize+7, resp.Expired)
+	assert.Equal(t, 3, batches)
+	assert.Equal(t, now, q.now)
+
+	failing := func(ctx context.Context, fn func(q pendingExpirer) error) error {
+		return errors.New("connection reset")
+	}
+	_, err = expirePending(context.Background(), failing, now)
+	assert.Error(t, err)
+}
+
+func TestPendingTTL(t *testing.T) {
+	tests := []struct {
+		name   string
+		reward db.RewardsCatalog
+		want   time.Duration
+	}{
+		{"default", db.RewardsCatalog{}, 24 * time.Hour},
+		{"reward lifetime", db.RewardsCatalog{PendingTtlSeconds: sql.NullInt32{Int32: 900, Valid: true}}, 15 * time.Minute},
+		{"zero falls back", db.RewardsCatalog{PendingTtlSeconds: sql.NullInt32{Int32: 0, Valid: true}}, 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, pendingTTL(tt.reward))
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package redemption
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"encore.app/internal/auth"
+	"encore.app/internal/db"
+	"encore.dev/beta/errs"
+	"github.com/google/uuid"
+)
+
+// Page sizes of the redemption history
+const (
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 100
+)
+
+// redemptionStatuses are the statuses the history can be filtered by
+var redemptionStatuses = map[string]bool{
+	"AWAITING_PAYMENT": true,
+	"PENDING":          true,
+	"FULFILLED":        true,
+	"FAILED":           true,
+	"CANCELLED":        true,
+	"EXPIRED":          true,
+}
+
+// ListRedemptions returns a page of the authenticated user's redemptions,
+// newest first
+//
+//encore:api auth method=GET path=/v1/users/:userID/redemptions
+func (s *Service) ListRedemptions(ctx context.Context, userID string, params *ListRedemptionsParams) (*ListRedemptionsResponse, error) {
+	if _, err := auth.RequireUser(auth.CurrentPrincipal(), userID); err != nil {
+		return nil, err
+	}
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, &errs.Error{Code: errs.InvalidArgument, Message: "invalid user ID"}
+	}
+	if params == nil {
+		params = &ListRedemptionsParams{}
+	}
+	return listRedemptions(ctx, s.db, id, *params)
+}
+
+// GetRedemption returns one of the authenticated user's redemptions with its
+// reward, voucher code, status timeline and expiry
+//
+//encore:api auth method=GET path=/v1/redemptions/:id
+func (s *Service) GetRedemption(ctx context.Context, id string) (*RedemptionDetails, error) {
+	principal, err := auth.RequireUser(auth.CurrentPrincipal(), "")
+	if err != nil {
+		return nil, err
+	}
+	userID, err := uuid.Parse(principal.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user ID: %w", err)
+	}
+	redemptionID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, errRedemptionNotFound
+	}
+	return redemptionDetails(ctx, s.db, userID, redemptionID, cancelWindow(), time.Now())
+}
+
+// historyStore is the subset of *db.Queries used to read redemption history
+type historyStore interface {
+	ListUserRedemptions(ctx context.Context, arg db.ListUserRedemptionsParams) ([]db.ListUserRedemptionsRow, error)
+	GetRedemption(ctx context.Context, id uuid.UUID) (db.Redemption, error)
+	GetReward(ctx context.Context, id uuid.UUID) (db.RewardsCatalog, error)
+	GetRedemptionChargingCredit(ctx context.Context, redemptionID uuid.UUID) (db.ChargingCredit, error)
+	GetRedemptionPayment(ctx context.Context, redemptionID uuid.UUID) (db.RedemptionPayment, error)
+	ListRedemptionStatusEvents(ctx context.Context, redemptionID uuid.UUID) ([]db.RedemptionStatusEvent, error)
+}
+
+// listRedemptions returns the page of userID's redemptions selected by params
+func listRedemptions(ctx context.Context, q historyStore, userID uuid.UUID, params ListRedemptionsParams) (*ListRedemptionsResponse, error) {
+	args := db.ListUserRedemptionsParams{UserID: userID, RowLimit: defaultHistoryLimit}
+	if params.Status != "" {
+		if !redemptionStatuses[params.Status] {
+			return nil, &errs.Error{Code: errs.InvalidArgument, Message: fmt.Sprintf("unknown status %q", params.Status)}
+		}
+		args.Status = sql.NullString{String: params.Status, Valid: true}
+	}
+	if params.Limit != 0 {
+		if params.Limit < 1 || params.Limit > maxHistoryLimit {
+			return nil, &errs.Error{Code: errs.InvalidArgument, Message: fmt.Sprintf("limit must be between 1 and %d", maxHistoryLimit)}
+		}
+		args.RowLimit = int32(params.Limit)
+	}
+	if params.Offset < 0 {
+		return nil, &errs.Error{Code: errs.InvalidArgument, Message: "offset must not be negative"}
+	}
+	args.RowOffset = int32(params.Offset)
+
+	// Fetch one extra row to tell whether another page follows
+	limit := args.RowLimit
+	args.RowLimit++
+	rows, err := q.ListUserRedemptions(ctx, args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list redemptions: %w", err)
+	}
+
+	resp := &ListRedemptionsResponse{Redemptions: make([]RedemptionSummary, 0, len(rows))}
+	if len(rows) > int(limit) {
+		rows = rows[:limit]
+		next := params.Offset + int(limit)
+		resp.NextOffset = &next
+	}
+	for _, r := range rows {
+		resp.Redemptions = append(resp.Redemptions, RedemptionSummary{
+			RedemptionID: r.ID.String(),
+			RewardID:     r.RewardID.String(),
+			RewardName:   r.RewardName,
+			RewardType:   r.RewardType,
+			PointsSpent:  r.PointsSpent,
+			BaseCost:     r.BaseCost.Int32,
+			Status:       r.Status,
+			VoucherCode:  visibleVoucherCode(r.Status, r.VoucherCode),
+			ExpiresAt:    pendingExpiry(r.Status, r.ExpiresAt),
+			CreatedAt:    r.CreatedAt,
+			UpdatedAt:    r.UpdatedAt,
+		})
+	}
+	return resp, nil
+}
+
+// redemptionDetails returns userID's redemption redemptionID; redemptions of
+// other users are reported as not found
+func redemptionDetails(ctx context.Context, q historyStore, userID, redemptionID uuid.UUID, window time.Duration, now time.Time) (*RedemptionDetails, error) {
+	redemption, err := q.GetRedemption(ctx, redemptionID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && redemption.UserID != userID) {
+		return nil, errRedemptionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get redemption: %w", err)
+	}
+	reward, err := q.GetReward(ctx, redemption.RewardID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reward: %w", err)
+	}
+
+	details := &RedemptionDetails{
+		RedemptionID: redemption.ID.String(),
+		UserID:       redemption.UserID.String(),
+		Reward: RedeemedReward{
+			ID:          reward.ID.String(),
+			Name:        reward.Name,
+			Description: reward.Description.String,
+			RewardType:  reward.RewardType,
+			ImageURL:    reward.ImageUrl.String,
+			Terms:       reward.Terms.String,
+		},
+		PointsSpent: redemption.PointsSpent,
+		BaseCost:    redemption.BaseCost.Int32,
+		PriceRule:   redemption.PriceRule.String,
+		Status:      redemption.Status,
+		VoucherCode: visibleVoucherCode(redemption.Status, redemption.VoucherCode),
+		ExpiresAt:   pendingExpiry(redemption.Status, redemption.ExpiresAt),
+		CreatedAt:   redemption.CreatedAt,
+		UpdatedAt:   redemption.UpdatedAt,
+	}
+	if redemption.Status == "PENDING" && window > 0 && now.Before(redemption.CreatedAt.Add(window)) {
+		deadline := redemption.CreatedAt.Add(window)
+		details.CancellableUntil = &deadline
+	}
+	if redemption.CheckoutID.Valid {
+		details.CheckoutID = redemption.CheckoutID.UUID.String()
+	}
+
+	credit, err := q.GetRedemptionChargingCredit(ctx, redemption.ID)
+	switch {
+	case err == nil:
+		details.Credit = &IssuedCredit{
+			ID:        credit.ID.String(),
+			Amount:    credit.Amount,
+			Currency:  credit.Currency,
+			ExpiresAt: credit.ExpiresAt,
+		}
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, fmt.Errorf("failed to get credit: %w", err)
+	}
+
+	payment, err := q.GetRedemptionPayment(ctx, redemption.ID)
+	switch {
+	case err == nil:
+		details.Payment = paymentOf(payment, nil)
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, fmt.Errorf("failed to get payment: %w", err)
+	}
+
+	events, err := q.ListRedemptionStatusEvents(ctx, redemption.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get timeline: %w", err)
+	}
+	details.Timeline = timeline(redemption, events)
+	return details, nil
+}
+
+// timeline converts recorded status events to the redemption's timeline.
+// Redemptions made before statuses were recorded get one reconstructed from
+// their creation and last update.
+func timeline(redemption db.Redemption, events []db.RedemptionStatusEvent) []StatusChange {
+	if len(events) == 0 {
+		changes := []StatusChange{{Status: "PENDING", At: redemption.CreatedAt}}
+		if redemption.Status != "PENDING" {
+			changes = append(changes, StatusChange{Status: redemption.Status, At: redemption.UpdatedAt})
+		}
+		return changes
+	}
+	changes := make([]StatusChange, 0, len(events))
+	for _, e := range events {
+		changes = append(changes, StatusChange{Status: e.Status, At: e.OccurredAt})
+	}
+	return changes
+}
+
+// pendingExpiry returns expiresAt while a redemption is pending or awaiting
+// payment, nil after
+func pendingExpiry(status string, expiresAt time.Time) *time.Time {
+	if status != "PENDING" && status != "AWAITING_PAYMENT" {
+		return nil
+	}
+	return &expiresAt
+}
@@ -0,0 +1,296 @@
+//go:build !encore
+// +build !encore
+
+package redemption
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"encore.app/internal/db"
+	"encore.dev/beta/errs"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeHistoryStore serves redemptions, newest first, with their rewards,
+// credits and status events
+type fakeHistoryStore struct {
+	redemptions []db.Redemption
+	rewards     map[uuid.UUID]db.RewardsCatalog
+	credits     map[uuid.UUID]db.ChargingCredit
+	payments    map[uuid.UUID]db.RedemptionPayment
+	events      map[uuid.UUID][]db.RedemptionStatusEvent
+	listed      db.ListUserRedemptionsParams
+}
+
+func (f *fakeHistoryStore) ListUserRedemptions(ctx context.Context, arg db.ListUserRedemptionsParams) ([]db.ListUserRedemptionsRow, error) {
+	f.listed = arg
+	var rows []db.ListUserRedemptionsRow
+	for _, r := range f.redemptions {
+		if r.UserID != arg.UserID || (arg.Status.Valid && r.Status != arg.Status.String) {
+			continue
+		}
+		reward := f.rewards[r.RewardID]
+		rows = append(rows, db.ListUserRedemptionsRow{
+			ID:          r.ID,
+			RewardID:    r.RewardID,
+			PointsSpent: r.PointsSpent,
+			Status:      r.Status,
+			VoucherCode: r.VoucherCode,
+			ExpiresAt:   r.ExpiresAt,
+			CreatedAt:   r.CreatedAt,
+			UpdatedAt:   r.UpdatedAt,
+			RewardName:  reward.Name,
+			RewardType:  reward.RewardType,
+		})
+	}
+	if int(arg.RowOffset) >= len(rows) {
+		return nil, nil
+	}
+	rows = rows[arg.RowOffset:]
+	if len(rows) > int(arg.RowLimit) {
+		rows = rows[:arg.RowLimit]
+	}
+	return rows, nil
+}
+
+func (f *fakeHistoryStore) GetRedemption(ctx context.Context, id uuid.UUID) (db.Redemption, error) {
+	for _, r := range f.redemptions {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+	return db.Redemption{}, sql.ErrNoRows
+}
+
+func (f *fakeHistoryStore) GetReward(ctx context.Context, id uuid.UUID) (db.RewardsCatalog, error) {
+	reward, ok := f.rewards[id]
+	if !ok {
+		return db.RewardsCatalog{}, sql.ErrNoRows
+	}
+	return reward, nil
+}
+
+func (f *fakeHistoryStore) GetRedemptionChargingCredit(ctx context.Context, redemptionID uuid.UUID) (db.ChargingCredit, error) {
+	credit, ok := f.credits[redemptionID]
+	if !ok {
+		return db.ChargingCredit{}, sql.ErrNoRows
+	}
+	return credit, nil
+}
+
+func (f *fakeHistoryStore) GetRedemptionPayment(ctx context.Context, redemptionID uuid.UUID) (db.RedemptionPayment, error) {
+	payment, ok := f.payments[redemptionID]
+	if !ok {
+		return db.RedemptionPayment{}, sql.ErrNoRows
+	}
+	return payment, nil
+}
+
+func (f *fakeHistoryStore) ListRedemptionStatusEvents(ctx context.Context, redemptionID uuid.UUID) ([]db.RedemptionStatusEvent, error) {
+	return f.events[redemptionID], nil
+}
+
+func TestListRedemptions(t *testing.T) {
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	userID := uuid.New()
+	reward := db.RewardsCatalog{ID: uuid.New(), Name: "Coffee", RewardType: "standard"}
+	q := &fakeHistoryStore{rewards: map[uuid.UUID]db.RewardsCatalog{reward.ID: reward}}
+	statuses := []string{"PENDING", "FULFILLED", "FULFILLED", "EXPIRED", "FULFILLED"}
+	for i, status := range statuses {
+		q.redemptions = append(q.redemptions, db.Redemption{
+			ID:          uuid.New(),
+			UserID:      userID,
+			RewardID:    reward.ID,
+			PointsSpent: 100,
+			Status:      status,
+			ExpiresAt:   now.Add(-time.Duration(i) * time.Hour).Add(time.Hour),
+			CreatedAt:   now.Add(-time.Duration(i) * time.Hour),
+		})
+	}
+	q.redemptions = append(q.redemptions, db.Redemption{ID: uuid.New(), UserID: uuid.New(), RewardID: reward.ID, Status: "PENDING"})
+
+	tests := []struct {
+		name       string
+		params     ListRedemptionsParams
+		wantIDs    []uuid.UUID
+		wantNext   *int
+		wantErr    bool
+		wantLimit  int32
+		wantStatus string
+	}{
+		{
+			name:      "first page",
+			params:    ListRedemptionsParams{Limit: 2},
+			wantIDs:   []uuid.UUID{q.redemptions[0].ID, q.redemptions[1].ID},
+			wantNext:  intPtr(2),
+			wantLimit: 3,
+		},
+		{
+			name:      "last page",
+			params:    ListRedemptionsParams{Limit: 2, Offset: 4},
+			wantIDs:   []uuid.UUID{q.redemptions[4].ID},
+			wantLimit: 3,
+		},
+		{
+			name:       "status filter",
+			params:     ListRedemptionsParams{Status: "FULFILLED"},
+			wantIDs:    []uuid.UUID{q.redemptions[1].ID, q.redemptions[2].ID, q.redemptions[4].ID},
+			wantLimit:  defaultHistoryLimit + 1,
+			wantStatus: "FULFILLED",
+		},
+		{name: "unknown status", params: ListRedemptionsParams{Status: "SHIPPED"}, wantErr: true},
+		{name: "limit too large", params: ListRedemptionsParams{Limit: maxHistoryLimit + 1}, wantErr: true},
+		{name: "negative offset", params: ListRedemptionsParams{Offset: -1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := listRedemptions(context.Background(), q, userID, tt.params)
+			if tt.wantErr {
+				var e *errs.Error
+				require.ErrorAs(t, err, &e)
+				assert.Equal(t, errs.InvalidArgument, e.Code)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantLimit, q.listed.RowLimit)
+			assert.Equal(t, tt.wantStatus, q.listed.Status.String)
+			var ids []uuid.UUID
+			for _, r := range resp.Redemptions {
+				ids = append(ids, uuid.MustParse(r.RedemptionID))
+				assert.Equal(t, "Coffee", r.RewardName)
+			}
+			assert.Equal(t, tt.wantIDs, ids)
+			assert.Equal(t, tt.wantNext, resp.NextOffset)
+		})
+	}
+
+	resp, err := listRedemptions(context.Background(), q, userID, ListRedemptionsParams{Limit: 1})
+	require.NoError(t, err)
+	require.NotNil(t, resp.Redemptions[0].ExpiresAt, "pending redemptions report their expiry")
+	assert.Equal(t, now.Add(time.Hour), *resp.Redemptions[0].ExpiresAt)
+}
+
+func TestRedemptionDetails(t *testing.T) {
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	userID := uuid.New()
+	voucher := db.RewardsCatalog{ID: uuid.New(), Name: "Coffee", Description: sql.NullString{String: "Any size", Valid: true}, RewardType: "standard"}
+	credit := db.RewardsCatalog{ID: uuid.New(), Name: "Charging credit", RewardType: "charging_credit"}
+	pending := db.Redemption{
+		ID: uuid.New(), UserID: userID, RewardID: voucher.ID, PointsSpent: 500, Status: "PENDING",
+		VoucherCode: sql.NullString{String: "ACME-7KQ2", Valid: true}, ExpiresAt: now.Add(time.Hour), CreatedAt: now.Add(-5 * time.Minute), UpdatedAt: now.Add(-5 * time.Minute),
+	}
+	fulfilled := db.Redemption{
+		ID: uuid.New(), UserID: userID, RewardID: credit.ID, PointsSpent: 1000, Status: "FULFILLED",
+		CreatedAt: now.Add(-48 * time.Hour), UpdatedAt: now.Add(-48 * time.Hour),
+	}
+	legacy := db.Redemption{
+		ID: uuid.New(), UserID: userID, RewardID: voucher.ID, PointsSpent: 500, Status: "EXPIRED",
+		CreatedAt: now.Add(-72 * time.Hour), UpdatedAt: now.Add(-48 * time.Hour),
+	}
+	awaiting := db.Redemption{
+		ID: uuid.New(), UserID: userID, RewardID: voucher.ID, PointsSpent: 200, Status: "AWAITING_PAYMENT",
+		VoucherCode: sql.NullString{String: "ACME-9PX4", Valid: true}, ExpiresAt: now.Add(20 * time.Minute), CreatedAt: now.Add(-10 * time.Minute), UpdatedAt: now.Add(-10 * time.Minute),
+	}
+	q := &fakeHistoryStore{
+		redemptions: []db.Redemption{pending, fulfilled, legacy, awaiting},
+		rewards:     map[uuid.UUID]db.RewardsCatalog{voucher.ID: voucher, credit.ID: credit},
+		credits: map[uuid.UUID]db.ChargingCredit{fulfilled.ID: {
+			ID: uuid.New(), RedemptionID: fulfilled.ID, Amount: 10000, Currency: "INR", ExpiresAt: now.Add(30 * 24 * time.Hour),
+		}},
+		payments: map[uuid.UUID]db.RedemptionPayment{awaiting.ID: {
+			ID: uuid.New(), RedemptionID: awaiting.ID, Provider: "fake", IntentID: sql.NullString{String: "pi_fake_1", Valid: true},
+			Amount: 4900, Currency: "INR", Status: "REQUIRES_PAYMENT", ExpiresAt: awaiting.ExpiresAt,
+		}},
+		events: map[uuid.UUID][]db.RedemptionStatusEvent{
+			pending.ID: {{Status: "PENDING", OccurredAt: pending.CreatedAt}},
+			fulfilled.ID: {
+				{Status: "PENDING", OccurredAt: fulfilled.CreatedAt},
+				{Status: "FULFILLED", OccurredAt: fulfilled.CreatedAt.Add(time.Millisecond)},
+			},
+		},
+	}
+	ctx := context.Background()
+
+	t.Run("pending voucher", func(t *testing.T) {
+		d, err := redemptionDetails(ctx, q, userID, pending.ID, 15*time.Minute, now)
+		require.NoError(t, err)
+		assert.Equal(t, RedeemedReward{ID: voucher.ID.String(), Name: "Coffee", Description: "Any size", RewardType: "standard"}, d.Reward)
+		assert.Equal(t, "ACME-7KQ2", d.VoucherCode)
+		assert.Equal(t, []StatusChange{{Status: "PENDING", At: pending.CreatedAt}}, d.Timeline)
+		require.NotNil(t, d.ExpiresAt)
+		assert.Equal(t, pending.ExpiresAt, *d.ExpiresAt)
+		require.NotNil(t, d.CancellableUntil)
+		assert.Equal(t, pending.CreatedAt.Add(15*time.Minute), *d.CancellableUntil)
+		assert.Nil(t, d.Credit)
+	})
+
+	t.Run("cancellation window passed", func(t *testing.T) {
+		d, err := redemptionDetails(ctx, q, userID, pending.ID, time.Minute, now)
+		require.NoError(t, err)
+		assert.Nil(t, d.CancellableUntil)
+	})
+
+	t.Run("fulfilled credit", func(t *testing.T) {
+		d, err := redemptionDetails(ctx, q, userID, fulfilled.ID, 15*time.Minute, now)
+		require.NoError(t, err)
+		require.NotNil(t, d.Credit)
+		assert.Equal(t, int64(10000), d.Credit.Amount)
+		assert.Equal(t, []string{"PENDING", "FULFILLED"}, statusesOf(d.Timeline))
+		assert.Nil(t, d.ExpiresAt)
+		assert.Nil(t, d.CancellableUntil)
+	})
+
+	t.Run("awaiting payment", func(t *testing.T) {
+		d, err := redemptionDetails(ctx, q, userID, awaiting.ID, 15*time.Minute, now)
+		require.NoError(t, err)
+		assert.Empty(t, d.VoucherCode)
+		require.NotNil(t, d.Payment)
+		assert.Equal(t, Payment{
+			IntentID: "pi_fake_1", Amount: 4900, Currency: "INR", Status: "REQUIRES_PAYMENT", ExpiresAt: awaiting.ExpiresAt,
+		}, *d.Payment)
+		require.NotNil(t, d.ExpiresAt)
+		assert.Equal(t, awaiting.ExpiresAt, *d.ExpiresAt)
+		assert.Nil(t, d.CancellableUntil)
+	})
+
+	t.Run("timeline reconstructed without events", func(t *testing.T) {
+		d, err := redemptionDetails(ctx, q, userID, legacy.ID, 15*time.Minute, now)
+		require.NoError(t, err)
+		assert.Equal(t, []StatusChange{
+			{Status: "PENDING", At: legacy.CreatedAt},
+			{Status: "EXPIRED", At: legacy.UpdatedAt},
+		}, d.Timeline)
+	})
+
+	notFound := []struct {
+		name         string
+		userID       uuid.UUID
+		redemptionID uuid.UUID
+	}{
+		{name: "unknown redemption", userID: userID, redemptionID: uuid.New()},
+		{name: "other user's redemption", userID: uuid.New(), redemptionID: pending.ID},
+	}
+	for _, tt := range notFound {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := redemptionDetails(ctx, q, tt.userID, tt.redemptionID, 15*time.Minute, now)
+			var e *errs.Error
+			require.ErrorAs(t, err, &e)
+			assert.Equal(t, errs.NotFound, e.Code)
+		})
+	}
+}
+
+func statusesOf(changes []StatusChange) []string {
+	statuses := make([]string, 0, len(changes))
+	for _, c := range changes {
+		statuses = append(statuses, c.Status)
+	}
+	return statuses
+}
+
+func intPtr(i int) *int { return &i }
@@ -0,0 +1,51 @@
+package redemption
+
+import (
+	"database/sql"
+
+	"encore.app/internal/outbox"
+)
+
+// Outbox topics of the redemption service's events
+const (
+	redemptionOutboxTopic          = "redemption-created"
+	redemptionCancelledOutboxTopic = "redemption-cancelled"
+	voucherPoolLowOutboxTopic      = "voucher-pool-low"
+	checkoutOutboxTopic            = "checkout-completed"
+)
+
+// newRedemptionRelay creates the relay publishing outboxed RedemptionCreated
+// events to RedemptionCreatedTopic
+func newRedemptionRelay(conn *sql.DB, metrics outbox.Metrics) *outbox.Relay {
+	return outbox.NewRelay(outbox.Config{
+		Topic:   redemptionOutboxTopic,
+		Publish: outbox.JSONPublisher[RedemptionCreated](RedemptionCreatedTopic),
+	}, outbox.DBTx(conn), metrics)
+}
+
+// newRedemptionCancelledRelay creates the relay publishing outboxed
+// RedemptionCancelled events to RedemptionCancelledTopic
+func newRedemptionCancelledRelay(conn *sql.DB, metrics outbox.Metrics) *outbox.Relay {
+	return outbox.NewRelay(outbox.Config{
+		Topic:   redemptionCancelledOutboxTopic,
+		Publish: outbox.JSONPublisher[RedemptionCancelled](RedemptionCancelledTopic),
+	}, outbox.DBTx(conn), metrics)
+}
+
+// newVoucherPoolLowRelay creates the relay publishing outboxed VoucherPoolLow
+// alerts to VoucherPoolLowTopic
+func newVoucherPoolLowRelay(conn *sql.DB, metrics outbox.Metrics) *outbox.Relay {
+	return outbox.NewRelay(outbox.Config{
+		Topic:   voucherPoolLowOutboxTopic,
+		Publish: outbox.JSONPublisher[VoucherPoolLow](VoucherPoolLowTopic),
+	}, outbox.DBTx(conn), metrics)
+}
+
+// newCheckoutRelay creates the relay publishing outboxed CheckoutCompleted
+// events to CheckoutCompletedTopic
+func newCheckoutRelay(conn *sql.DB, metrics outbox.Metrics) *outbox.Relay {
+	return outbox.NewRelay(outbox.Config{
+		Topic:   checkoutOutboxTopic,
+		Publish: outbox.JSONPublisher[CheckoutCompleted](CheckoutCompletedTopic),
+	}, outbox.DBTx(conn), metrics)
+}
@@ -0,0 +1,398 @@
+package redemption
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"encore.app/internal/auth"
+	"encore.app/internal/db"
+	"encore.app/internal/inventory"
+	"encore.app/internal/payments"
+	"encore.dev/beta/errs"
+	"github.com/google/uuid"
+)
+
+// paymentWindow is how long a user has to pay the cash part of a redemption
+// before the payment is cancelled and the points refunded
+const paymentWindow = 30 * time.Minute
+
+// reconcileBatchSize bounds the payments checked per transaction
+const reconcileBatchSize = 50
+
+// paymentProvider takes the cash part of points + cash redemptions. It is the
+// provider PAYMENT_PROVIDER names: "fake" selects the in-memory fake for local
+// development, with FAKE_PAYMENT_OUTCOME choosing whether its intents
+// succeed, fail or wait for a manual outcome. Without a provider it is nil
+// and points + cash redemptions are refused.
+var paymentProvider = newPaymentProvider()
+
+// newPaymentProvider returns the configured payment provider, or nil
+func newPaymentProvider() payments.Provider {
+	provider, err := payments.NewProvider(os.Getenv("PAYMENT_PROVIDER"), os.Getenv("FAKE_PAYMENT_OUTCOME"))
+	if err != nil {
+		log.Printf("redemption: points + cash payments disabled: %v", err)
+		return nil
+	}
+	return provider
+}
+
+// errNoCashOption is returned when paying with cash for a reward without a
+// cash option
+var errNoCashOption = &errs.Error{Code: errs.FailedPrecondition, Message: "reward cannot be paid with points and cash"}
+
+// errPaymentsUnavailable is returned for points + cash payments when no
+// payment provider is configured
+var errPaymentsUnavailable = &errs.Error{Code: errs.Unavailable, Message: "points + cash payments are not available"}
+
+// checkCashPayment checks a reward can be paid with points and cash through
+// provider
+func checkCashPayment(reward db.RewardsCatalog, provider payments.Provider) error {
+	if !hasCashOption(reward) {
+		return errNoCashOption
+	}
+	if provider == nil {
+		return errPaymentsUnavailable
+	}
+	return nil
+}
+
+// ReconcilePaymentsResponse summarizes a payment reconciliation run
+type ReconcilePaymentsResponse struct {
+	// Confirmed is the redemptions confirmed after their payment succeeded
+	Confirmed int `json:"confirmed"`
+	// Failed is the redemptions refunded after their payment failed or
+	// expired
+	Failed int `json:"failed"`
+}
+
+// hasCashOption reports whether a reward can be paid with points and cash
+func hasCashOption(reward db.RewardsCatalog) bool {
+	return reward.MinPoints.Valid && reward.CashPrice.Valid
+}
+
+// cashOption returns a reward's points + cash price, or nil
+func cashOption(reward db.RewardsCatalog) *CashOption {
+	if !hasCashOption(reward) {
+		return nil
+	}
+	return &CashOption{Points: reward.MinPoints.Int32, CashPrice: reward.CashPrice.Int64, Currency: payments.Currency}
+}
+
+// paymentOf describes a redemption payment; the client secret is only given
+// with intent while the payment is open
+func paymentOf(payment db.RedemptionPayment, intent *payments.Intent) *Payment {
+	p := &Payment{
+		IntentID:      payment.IntentID.String,
+		Amount:        payment.Amount,
+		Currency:      payment.Currency,
+		Status:        payment.Status,
+		FailureReason: payment.FailureReason.String,
+		ExpiresAt:     payment.ExpiresAt,
+	}
+	if intent != nil && payment.Status == payments.StatusRequiresPayment {
+		p.ClientSecret = intent.ClientSecret
+	}
+	return p
+}
+
+// startPayment creates the payment intent for a redemption awaiting payment.
+// An intent the provider settles at once settles the redemption too; if no
+// intent can be created the redemption fails and its points are refunded.
+func (s *Service) startPayment(ctx context.Context, reward db.RewardsCatalog, redemption db.Redemption, payment db.RedemptionPayment, now time.Time) (*RedeemResponse, error) {
+	intent, err := paymentProvider.CreateIntent(ctx, payments.IntentRequest{
+		Amount:         payment.Amount,
+		Currency:       payment.Currency,
+		IdempotencyKey: redemption.ID.String(),
+		Description:    reward.Name,
+	})
+	if err != nil {
+		intent = payments.Intent{Status: payments.StatusFailed, FailureReason: "payment could not be started"}
+		log.Printf("redemption: failed to create payment intent for %s: %v", redemption.ID, err)
+	}
+
+	var outcome paymentOutcome
+	err = db.WithTx(ctx, s.conn, func(q *db.Queries) error {
+		payment, err := q.LockRedemptionPayment(ctx, redemption.ID)
+		if err != nil {
+			return fmt.Errorf("failed to lock payment: %w", err)
+		}
+		if intent.ID != "" {
+			payment.IntentID = sql.NullString{String: intent.ID, Valid: true}
+			if err := q.SetRedemptionPaymentIntent(ctx, db.SetRedemptionPaymentIntentParams{
+				ID:       payment.ID,
+				IntentID: payment.IntentID,
+			}); err != nil {
+				return fmt.Errorf("failed to record payment intent: %w", err)
+			}
+		}
+		outcome, err = settlePayment(ctx, q, payment, intent, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return redeemResponse(outcome.redemption, outcome.credit, paymentOf(outcome.payment, &intent)), nil
+}
+
+// ConfirmRedemptionPayment checks the payment of one of the authenticated
+// user's points + cash redemptions with the payment provider, confirming the
+// redemption once it is paid and refunding its points if the payment failed
+//
+//encore:api auth method=POST path=/v1/redemptions/:id/payment/confirm
+func (s *Service) ConfirmRedemptionPayment(ctx context.Context, id string) (*RedeemResponse, error) {
+	principal, err := auth.RequireUser(auth.CurrentPrincipal(), "")
+	if err != nil {
+		return nil, err
+	}
+	userID, err := uuid.Parse(principal.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user ID: %w", err)
+	}
+	redemptionID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, errRedemptionNotFound
+	}
+
+	payment, err := s.db.GetRedemptionPayment(ctx, redemptionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errRedemptionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment: %w", err)
+	}
+	redemption, err := s.db.GetRedemption(ctx, redemptionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get redemption: %w", err)
+	}
+	if redemption.UserID != userID {
+		return nil, errRedemptionNotFound
+	}
+	if payment.Status != payments.StatusRequiresPayment || !payment.IntentID.Valid {
+		return redeemResponse(redemption, nil, paymentOf(payment, nil)), nil
+	}
+	if paymentProvider == nil {
+		return nil, errPaymentsUnavailable
+	}
+
+	// The provider is asked before the payment is locked, so the row is
+	// only held while the outcome is recorded
+	intent, err := paymentProvider.GetIntent(ctx, payment.IntentID.String)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment intent: %w", err)
+	}
+	var outcome paymentOutcome
+	err = db.WithTx(ctx, s.conn, func(q *db.Queries) error {
+		outcome, err = settleCheckedPayment(ctx, q, payment, intent, time.Now())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return redeemResponse(outcome.redemption, outcome.credit, paymentOf(outcome.payment, &intent)), nil
+}
+
+// paymentStore is the subset of *db.Queries used to settle payments
+type paymentStore interface {
+	confirmStore
+	inventory.Releaser
+	GetRedemption(ctx context.Context, id uuid.UUID) (db.Redemption, error)
+	GetReward(ctx context.Context, id uuid.UUID) (db.RewardsCatalog, error)
+	FinishRedemptionPayment(ctx context.Context, arg db.FinishRedemptionPaymentParams) (db.RedemptionPayment, error)
+	SettleAwaitingRedemption(ctx context.Context, arg db.SettleAwaitingRedemptionParams) (db.Redemption, error)
+	voucherWithdrawer
+	CreatePointsEvent(ctx context.Context, arg db.CreatePointsEventParams) (db.PointsEvent, error)
+}
+
+// paymentOutcome is a payment and its redemption after settling
+type paymentOutcome struct {
+	payment    db.RedemptionPayment
+	redemption db.Redemption
+	credit     *IssuedCredit
+}
+
+// settlePayment records the outcome of a locked open payment's intent. A paid
+// intent confirms the redemption; a failed or cancelled one fails it, refunds
+// its points, returns its stock and withdraws its voucher code. An unpaid
+// intent changes nothing. Call it in a transaction.
+func settlePayment(ctx context.Context, q paymentStore, payment db.RedemptionPayment, intent payments.Intent, now time.Time) (paymentOutcome, error) {
+	redemption, err := q.GetRedemption(ctx, payment.RedemptionID)
+	if err != nil {
+		return paymentOutcome{}, fmt.Errorf("failed to get redemption: %w", err)
+	}
+	if !intent.Settled() {
+		return paymentOutcome{payment: payment, redemption: redemption}, nil
+	}
+	reward, err := q.GetReward(ctx, redemption.RewardID)
+	if err != nil {
+		return paymentOutcome{}, fmt.Errorf("failed to get reward: %w", err)
+	}
+
+	payment, err = q.FinishRedemptionPayment(ctx, db.FinishRedemptionPaymentParams{
+		ID:            payment.ID,
+		Status:        intent.Status,
+		FailureReason: sql.NullString{String: intent.FailureReason, Valid: intent.FailureReason != ""},
+	})
+	if err != nil {
+		return paymentOutcome{}, fmt.Errorf("failed to record payment outcome: %w", err)
+	}
+
+	if intent.Status == payments.StatusSucceeded {
+		// The pending lifetime starts once the redemption is paid for
+		redemption, err = q.SettleAwaitingRedemption(ctx, db.SettleAwaitingRedemptionParams{
+			ID:        redemption.ID,
+			Status:    "PENDING",
+			ExpiresAt: sql.NullTime{Time: now.Add(pendingTTL(reward)), Valid: true},
+		})
+		if err != nil {
+			return paymentOutcome{}, fmt.Errorf("failed to confirm redemption: %w", err)
+		}
+		redemption, credit, err := confirmRedemption(ctx, q, reward, redemption, now)
+		return paymentOutcome{payment: payment, redemption: redemption, credit: credit}, err
+	}
+
+	redemption, err = q.SettleAwaitingRedemption(ctx, db.SettleAwaitingRedemptionParams{
+		ID:     redemption.ID,
+		Status: "FAILED",
+	})
+	if err != nil {
+		return paymentOutcome{}, fmt.Errorf("failed to fail redemption: %w", err)
+	}
+	if _, err := q.CreatePointsEvent(ctx, db.CreatePointsEventParams{
+		UserID:    redemption.UserID,
+		EventType: refundEventType,
+		RefID:     sql.NullString{String: redemption.ID.String(), Valid: true},
+		Points:    redemption.PointsSpent,
+	}); err != nil {
+		return paymentOutcome{}, fmt.Errorf("failed to refund points: %w", err)
+	}
+	if err := inventory.Release(ctx, q, redemption.RewardID, redemption.CreatedAt); err != nil {
+		return paymentOutcome{}, fmt.Errorf("failed to release stock: %w", err)
+	}
+	// The code was withheld while awaiting payment, so a pool code can be
+	// issued again
+	redemption, err = withdrawVoucher(ctx, q, redemption, false)
+	if err != nil {
+		return paymentOutcome{}, err
+	}
+	return paymentOutcome{payment: payment, redemption: redemption}, nil
+}
+
+// paymentSettler is the subset of *db.Queries used to settle a payment
+// checked with the provider
+type paymentSettler interface {
+	paymentStore
+	LockRedemptionPayment(ctx context.Context, redemptionID uuid.UUID) (db.RedemptionPayment, error)
+}
+
+// settleCheckedPayment locks an open payment whose intent was checked with
+// the provider and settles it. A payment settled since it was read is
+// returned as it is. Call it in a transaction.
+func settleCheckedPayment(ctx context.Context, q paymentSettler, checked db.RedemptionPayment, intent payments.Intent, now time.Time) (paymentOutcome, error) {
+	payment, err := q.LockRedemptionPayment(ctx, checked.RedemptionID)
+	if err != nil {
+		return paymentOutcome{}, fmt.Errorf("failed to lock payment: %w", err)
+	}
+	if payment.Status != payments.StatusRequiresPayment || payment.IntentID != checked.IntentID {
+		redemption, err := q.GetRedemption(ctx, payment.RedemptionID)
+		if err != nil {
+			return paymentOutcome{}, fmt.Errorf("failed to get redemption: %w", err)
+		}
+		return paymentOutcome{payment: payment, redemption: redemption}, nil
+	}
+	return settlePayment(ctx, q, payment, intent, now)
+}
+
+// paymentReconciler is the subset of *db.Queries used to reconcile payments
+type paymentReconciler interface {
+	paymentSettler
+	ListOpenRedemptionPayments(ctx context.Context, arg db.ListOpenRedemptionPaymentsParams) ([]db.RedemptionPayment, error)
+}
+
+// paymentTxFunc runs fn inside a database transaction
+type paymentTxFunc func(ctx context.Context, fn func(q paymentReconciler) error) error
+
+// paymentDBTx returns a paymentTxFunc running transactions on conn
+func paymentDBTx(conn *sql.DB) paymentTxFunc {
+	return func(ctx context.Context, fn func(q paymentReconciler) error) error {
+		return db.WithTx(ctx, conn, func(q *db.Queries) error {
+			return fn(q)
+		})
+	}
+}
+
+// reconcilePayments settles open payments the user did not confirm: paid
+// and declined intents are settled, and intents still unpaid after the
+// payment window are cancelled. Open payments are listed in batches and
+// checked with the provider outside any transaction; each settled intent is
+// then recorded in its own short transaction. A payment the provider cannot
+// be reached for is left for the next run. Without a provider there is
+// nothing to check.
+func reconcilePayments(ctx context.Context, tx paymentTxFunc, provider payments.Provider, now time.Time) (*ReconcilePaymentsResponse, error) {
+	resp := &ReconcilePaymentsResponse{}
+	if provider == nil {
+		return resp, nil
+	}
+	after := uuid.Nil
+	for {
+		var open []db.RedemptionPayment
+		err := tx(ctx, func(q paymentReconciler) error {
+			var err error
+			open, err = q.ListOpenRedemptionPayments(ctx, db.ListOpenRedemptionPaymentsParams{After: after, BatchSize: reconcileBatchSize})
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list open payments: %w", err)
+		}
+		for _, payment := range open {
+			after = payment.ID
+			intent, err := checkIntent(ctx, provider, payment, now)
+			if err != nil {
+				log.Printf("redemption: failed to check payment %s: %v", payment.ID, err)
+				continue
+			}
+			if !intent.Settled() {
+				continue
+			}
+			var outcome paymentOutcome
+			err = tx(ctx, func(q paymentReconciler) error {
+				outcome, err = settleCheckedPayment(ctx, q, payment, intent, now)
+				return err
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to reconcile payment %s: %w", payment.ID, err)
+			}
+			switch outcome.payment.Status {
+			case payments.StatusSucceeded:
+				resp.Confirmed++
+			case payments.StatusFailed, payments.StatusCancelled:
+				resp.Failed++
+			}
+		}
+		if len(open) < reconcileBatchSize {
+			return resp, nil
+		}
+	}
+}
+
+// checkIntent returns the current state of an open payment's intent,
+// cancelling it once the payment window has passed. A payment whose intent
+// was never created is treated as cancelled after the window.
+func checkIntent(ctx context.Context, provider payments.Provider, payment db.RedemptionPayment, now time.Time) (payments.Intent, error) {
+	expired := !now.Before(payment.ExpiresAt)
+	if !payment.IntentID.Valid {
+		if expired {
+			return payments.Intent{Status: payments.StatusCancelled, FailureReason: "payment was not started"}, nil
+		}
+		return payments.Intent{Status: payments.StatusRequiresPayment}, nil
+	}
+	intent, err := provider.GetIntent(ctx, payment.IntentID.String)
+	if err != nil || intent.Settled() || !expired {
+		return intent, err
+	}
+	return provider.CancelIntent(ctx, payment.IntentID.String)
+}
@@ -0,0 +1,320 @@
+//go:build !encore
+// +build !encore
+
+package redemption
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+
+	"encore.app/internal/db"
+	"encore.app/internal/payments"
+	"encore.dev/beta/errs"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePaymentStore holds redemptions, rewards and payments and records the
+// settlement's writes
+type fakePaymentStore struct {
+	*fakeCancelStore
+	rewards  map[uuid.UUID]db.RewardsCatalog
+	payments map[uuid.UUID]*db.RedemptionPayment
+	credits  []db.CreateChargingCreditParams
+}
+
+func newFakePaymentStore() *fakePaymentStore {
+	return &fakePaymentStore{
+		fakeCancelStore: &fakeCancelStore{redemptions: map[uuid.UUID]*db.Redemption{}, pooledCodes: map[uuid.UUID]bool{}},
+		rewards:         map[uuid.UUID]db.RewardsCatalog{},
+		payments:        map[uuid.UUID]*db.RedemptionPayment{},
+	}
+}
+
+// add stores an awaiting redemption of reward with an open payment
+func (f *fakePaymentStore) add(reward db.RewardsCatalog, intentID string, expiresAt time.Time) db.RedemptionPayment {
+	f.rewards[reward.ID] = reward
+	r := &db.Redemption{
+		ID: uuid.New(), UserID: uuid.New(), RewardID: reward.ID, PointsSpent: reward.MinPoints.Int32, Status: "AWAITING_PAYMENT",
+		VoucherCode: sql.NullString{String: "ACME-7KQ2", Valid: true}, ExpiresAt: expiresAt,
+	}
+	f.redemptions[r.ID] = r
+	f.pooledCodes[r.ID] = true
+	p := &db.RedemptionPayment{
+		ID: uuid.New(), RedemptionID: r.ID, Provider: "fake", IntentID: sql.NullString{String: intentID, Valid: intentID != ""},
+		Amount: reward.CashPrice.Int64, Currency: payments.Currency, Status: payments.StatusRequiresPayment, ExpiresAt: expiresAt,
+	}
+	f.payments[p.ID] = p
+	return *p
+}
+
+func (f *fakePaymentStore) GetReward(ctx context.Context, id uuid.UUID) (db.RewardsCatalog, error) {
+	r, ok := f.rewards[id]
+	if !ok {
+		return db.RewardsCatalog{}, sql.ErrNoRows
+	}
+	return r, nil
+}
+
+func (f *fakePaymentStore) FinishRedemptionPayment(ctx context.Context, arg db.FinishRedemptionPaymentParams) (db.RedemptionPayment, error) {
+	p := f.payments[arg.ID]
+	p.Status, p.FailureReason = arg.Status, arg.FailureReason
+	return *p, nil
+}
+
+func (f *fakePaymentStore) SettleAwaitingRedemption(ctx context.Context, arg db.SettleAwaitingRedemptionParams) (db.Redemption, error) {
+	r, ok := f.redemptions[arg.ID]
+	if !ok || r.Status != "AWAITING_PAYMENT" {
+		return db.Redemption{}, sql.ErrNoRows
+	}
+	r.Status = arg.Status
+	if arg.ExpiresAt.Valid {
+		r.ExpiresAt = arg.ExpiresAt.Time
+	}
+	return *r, nil
+}
+
+func (f *fakePaymentStore) UpdateRedemptionStatus(ctx context.Context, arg db.UpdateRedemptionStatusParams) (db.Redemption, error) {
+	r := f.redemptions[arg.ID]
+	r.Status = arg.Status
+	return *r, nil
+}
+
+func (f *fakePaymentStore) CreateChargingCredit(ctx context.Context, arg db.CreateChargingCreditParams) (db.ChargingCredit, error) {
+	f.credits = append(f.credits, arg)
+	return db.ChargingCredit{ID: uuid.New(), Amount: arg.Amount, Currency: "INR", ExpiresAt: arg.ExpiresAt}, nil
+}
+
+func (f *fakePaymentStore) LockRedemptionPayment(ctx context.Context, redemptionID uuid.UUID) (db.RedemptionPayment, error) {
+	for _, p := range f.payments {
+		if p.RedemptionID == redemptionID {
+			return *p, nil
+		}
+	}
+	return db.RedemptionPayment{}, sql.ErrNoRows
+}
+
+func (f *fakePaymentStore) ListOpenRedemptionPayments(ctx context.Context, arg db.ListOpenRedemptionPaymentsParams) ([]db.RedemptionPayment, error) {
+	var open []db.RedemptionPayment
+	for _, p := range f.payments {
+		if p.Status == payments.StatusRequiresPayment && bytes.Compare(p.ID[:], arg.After[:]) > 0 {
+			open = append(open, *p)
+		}
+	}
+	sort.Slice(open, func(i, j int) bool { return bytes.Compare(open[i].ID[:], open[j].ID[:]) < 0 })
+	return open[:min(len(open), int(arg.BatchSize))], nil
+}
+
+// txCheckedProvider fails the test if the provider is called while a
+// transaction is open
+type txCheckedProvider struct {
+	payments.Provider
+	t    *testing.T
+	inTx *bool
+}
+
+func (p txCheckedProvider) GetIntent(ctx context.Context, id string) (payments.Intent, error) {
+	assert.False(p.t, *p.inTx, "provider called inside a transaction")
+	return p.Provider.GetIntent(ctx, id)
+}
+
+func (p txCheckedProvider) CancelIntent(ctx context.Context, id string) (payments.Intent, error) {
+	assert.False(p.t, *p.inTx, "provider called inside a transaction")
+	return p.Provider.CancelIntent(ctx, id)
+}
+
+func hybridReward(rewardType string) db.RewardsCatalog {
+	r := db.RewardsCatalog{
+		ID: uuid.New(), Name: "Session", Cost: 500, RewardType: rewardType,
+		MinPoints: sql.NullInt32{Int32: 200, Valid: true}, CashPrice: sql.NullInt64{Int64: 4900, Valid: true},
+	}
+	if rewardType == "charging_credit" {
+		r.CreditAmount = sql.NullInt64{Int64: 10000, Valid: true}
+	}
+	return r
+}
+
+func TestSettlePayment_Succeeded(t *testing.T) {
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	q := newFakePaymentStore()
+	payment := q.add(hybridReward("standard"), "pi_1", now.Add(paymentWindow))
+
+	outcome, err := settlePayment(context.Background(), q, payment, payments.Intent{ID: "pi_1", Status: payments.StatusSucceeded}, now)
+	require.NoError(t, err)
+	assert.Equal(t, payments.StatusSucceeded, outcome.payment.Status)
+	assert.Equal(t, "PENDING", outcome.redemption.Status)
+	assert.Equal(t, "ACME-7KQ2", outcome.redemption.VoucherCode.String)
+	// The pending lifetime restarts once paid
+	assert.Equal(t, now.Add(defaultPendingTTL), outcome.redemption.ExpiresAt)
+	assert.Empty(t, q.events)
+
+	require.Len(t, q.outbox, 1)
+	assert.Equal(t, redemptionOutboxTopic, q.outbox[0].Topic)
+	var event RedemptionCreated
+	require.NoError(t, json.Unmarshal(q.outbox[0].Payload, &event))
+	assert.Equal(t, int32(200), event.PointsSpent)
+	assert.Equal(t, "ACME-7KQ2", event.VoucherCode)
+}
+
+func TestSettlePayment_SucceededCredit(t *testing.T) {
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	q := newFakePaymentStore()
+	payment := q.add(hybridReward("charging_credit"), "pi_1", now.Add(paymentWindow))
+
+	outcome, err := settlePayment(context.Background(), q, payment, payments.Intent{ID: "pi_1", Status: payments.StatusSucceeded}, now)
+	require.NoError(t, err)
+	assert.Equal(t, "FULFILLED", outcome.redemption.Status)
+	require.NotNil(t, outcome.credit)
+	assert.Equal(t, int64(10000), outcome.credit.Amount)
+	assert.Len(t, q.credits, 1)
+}
+
+func TestSettlePayment_Failed(t *testing.T) {
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	for _, status := range []string{payments.StatusFailed, payments.StatusCancelled} {
+		t.Run(status, func(t *testing.T) {
+			q := newFakePaymentStore()
+			payment := q.add(hybridReward("standard"), "pi_1", now.Add(paymentWindow))
+
+			intent := payments.Intent{ID: "pi_1", Status: status, FailureReason: "card declined"}
+			outcome, err := settlePayment(context.Background(), q, payment, intent, now)
+			require.NoError(t, err)
+			assert.Equal(t, status, outcome.payment.Status)
+			assert.Equal(t, "card declined", outcome.payment.FailureReason.String)
+			assert.Equal(t, "FAILED", outcome.redemption.Status)
+			assert.False(t, outcome.redemption.VoucherCode.Valid)
+			assert.False(t, q.redemptions[outcome.redemption.ID].VoucherCode.Valid)
+			// The withheld pool code goes back to the pool
+			assert.Equal(t, []uuid.UUID{outcome.redemption.ID}, q.returnedCodes)
+			assert.Empty(t, q.voidedCodes)
+
+			// The points come back and the stock is returned
+			require.Len(t, q.events, 1)
+			assert.Equal(t, refundEventType, q.events[0].EventType)
+			assert.Equal(t, int32(200), q.events[0].Points)
+			assert.Equal(t, []uuid.UUID{outcome.redemption.RewardID}, q.released)
+			assert.Empty(t, q.outbox)
+		})
+	}
+}
+
+func TestSettlePayment_Unpaid(t *testing.T) {
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	q := newFakePaymentStore()
+	payment := q.add(hybridReward("standard"), "pi_1", now.Add(paymentWindow))
+
+	outcome, err := settlePayment(context.Background(), q, payment, payments.Intent{ID: "pi_1", Status: payments.StatusRequiresPayment}, now)
+	require.NoError(t, err)
+	assert.Equal(t, payments.StatusRequiresPayment, outcome.payment.Status)
+	assert.Equal(t, "AWAITING_PAYMENT", outcome.redemption.Status)
+	assert.Empty(t, q.events)
+	assert.Empty(t, q.outbox)
+}
+
+func TestReconcilePayments(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	provider := payments.NewFake(payments.OutcomeManual)
+	intent := func() string {
+		i, err := provider.CreateIntent(ctx, payments.IntentRequest{Amount: 4900, Currency: payments.Currency})
+		require.NoError(t, err)
+		return i.ID
+	}
+	q := newFakePaymentStore()
+
+	paid := intent()
+	_, _ = provider.Succeed(paid)
+	declined := intent()
+	_, _ = provider.Fail(declined, "card declined")
+	q.add(hybridReward("standard"), paid, now.Add(time.Minute))
+	q.add(hybridReward("standard"), declined, now.Add(time.Minute))
+	// Unpaid within the window, unpaid after it and never started
+	open := q.add(hybridReward("standard"), intent(), now.Add(time.Minute))
+	abandoned := q.add(hybridReward("standard"), intent(), now.Add(-time.Minute))
+	unstarted := q.add(hybridReward("standard"), "", now.Add(-time.Minute))
+	// A payment the provider does not know is left for the next run
+	unknown := q.add(hybridReward("standard"), "pi_unknown", now.Add(-time.Minute))
+
+	txs, inTx := 0, false
+	tx := func(ctx context.Context, fn func(q paymentReconciler) error) error {
+		txs++
+		inTx = true
+		defer func() { inTx = false }()
+		return fn(q)
+	}
+	resp, err := reconcilePayments(ctx, tx, txCheckedProvider{Provider: provider, t: t, inTx: &inTx}, now)
+	require.NoError(t, err)
+	assert.Equal(t, &ReconcilePaymentsResponse{Confirmed: 1, Failed: 3}, resp)
+	// One listing, then one per settled payment
+	assert.Equal(t, 5, txs)
+
+	assert.Equal(t, payments.StatusRequiresPayment, q.payments[open.ID].Status)
+	assert.Equal(t, payments.StatusCancelled, q.payments[abandoned.ID].Status)
+	cancelled, err := provider.GetIntent(ctx, abandoned.IntentID.String)
+	require.NoError(t, err)
+	assert.Equal(t, payments.StatusCancelled, cancelled.Status)
+	assert.Equal(t, payments.StatusCancelled, q.payments[unstarted.ID].Status)
+	assert.Equal(t, "FAILED", q.redemptions[unstarted.RedemptionID].Status)
+	assert.Equal(t, payments.StatusRequiresPayment, q.payments[unknown.ID].Status)
+	assert.Len(t, q.events, 3)
+}
+
+func TestSettleCheckedPayment_SettledSinceRead(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	q := newFakePaymentStore()
+	checked := q.add(hybridReward("standard"), "pi_1", now.Add(time.Minute))
+	// The user confirmed the payment after the reconciler read it
+	q.payments[checked.ID].Status = payments.StatusSucceeded
+	q.redemptions[checked.RedemptionID].Status = "FULFILLED"
+
+	outcome, err := settleCheckedPayment(ctx, q, checked, payments.Intent{ID: "pi_1", Status: payments.StatusCancelled}, now)
+	require.NoError(t, err)
+	assert.Equal(t, payments.StatusSucceeded, outcome.payment.Status)
+	assert.Equal(t, "FULFILLED", outcome.redemption.Status)
+	assert.Empty(t, q.events)
+}
+
+func TestReconcilePayments_NoProvider(t *testing.T) {
+	q := newFakePaymentStore()
+	q.add(hybridReward("standard"), "pi_1", time.Now().Add(-time.Minute))
+	tx := func(ctx context.Context, fn func(q paymentReconciler) error) error {
+		return fn(q)
+	}
+	resp, err := reconcilePayments(context.Background(), tx, nil, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, &ReconcilePaymentsResponse{}, resp)
+	assert.Empty(t, q.events)
+}
+
+func TestCheckCashPayment(t *testing.T) {
+	provider := payments.NewFake(payments.OutcomeSucceed)
+	assert.NoError(t, checkCashPayment(hybridReward("standard"), provider))
+	assert.Equal(t, errNoCashOption, checkCashPayment(db.RewardsCatalog{Cost: 500}, provider))
+
+	// Without a configured provider cash is refused, never faked
+	err := checkCashPayment(hybridReward("standard"), nil)
+	var e *errs.Error
+	require.ErrorAs(t, err, &e)
+	assert.Equal(t, errs.Unavailable, e.Code)
+	assert.Nil(t, newPaymentProviderFromEnv(t, ""))
+	assert.IsType(t, &payments.Fake{}, newPaymentProviderFromEnv(t, payments.ProviderFake))
+}
+
+// newPaymentProviderFromEnv returns the provider configured by a
+// PAYMENT_PROVIDER of kind
+func newPaymentProviderFromEnv(t *testing.T, kind string) payments.Provider {
+	t.Setenv("PAYMENT_PROVIDER", kind)
+	return newPaymentProvider()
+}
+
+func TestCashOption(t *testing.T) {
+	assert.Equal(t, &CashOption{Points: 200, CashPrice: 4900, Currency: "INR"}, cashOption(hybridReward("standard")))
+	assert.Nil(t, cashOption(db.RewardsCatalog{Cost: 500}))
+}
@@ -0,0 +1,100 @@
+package redemption
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"encore.app/internal/db"
+	"encore.app/internal/pricing"
+	"encore.dev/beta/errs"
+	"github.com/google/uuid"
+)
+
+// quoteWindow is how long the costs listed in a catalog are honoured by
+// redemptions quoting it
+const quoteWindow = 5 * time.Minute
+
+// errUnknownQuote is returned for quotes that do not exist, belong to another
+// user or do not list the reward
+var errUnknownQuote = &errs.Error{Code: errs.InvalidArgument, Message: "unknown price quote for this reward"}
+
+// DeleteExpiredQuotesResponse summarizes a quote cleanup run
+type DeleteExpiredQuotesResponse struct {
+	Deleted int64 `json:"deleted"`
+}
+
+// quoteStore is the subset of *db.Queries used for price quotes
+type quoteStore interface {
+	CreatePriceQuote(ctx context.Context, arg db.CreatePriceQuoteParams) (db.PriceQuote, error)
+	GetPriceQuote(ctx context.Context, id uuid.UUID) (db.PriceQuote, error)
+}
+
+// createQuote records the costs listed in a user's catalog for quoteWindow
+func createQuote(ctx context.Context, q quoteStore, userID uuid.UUID, catalog []Reward, now time.Time) (db.PriceQuote, error) {
+	prices := make(map[string]pricing.Price, len(catalog))
+	for _, r := range catalog {
+		price := pricing.Price{Cost: r.Cost, BaseCost: r.Cost, Rule: r.PriceRule, EndsAt: r.PriceEndsAt}
+		if r.PriceRule != "" {
+			price.BaseCost = r.BaseCost
+		}
+		prices[r.ID] = price
+	}
+	raw, err := json.Marshal(prices)
+	if err != nil {
+		return db.PriceQuote{}, err
+	}
+	quote, err := q.CreatePriceQuote(ctx, db.CreatePriceQuoteParams{
+		UserID:    userID,
+		Prices:    raw,
+		ExpiresAt: now.Add(quoteWindow),
+	})
+	if err != nil {
+		return db.PriceQuote{}, fmt.Errorf("failed to create price quote: %w", err)
+	}
+	return quote, nil
+}
+
+// quotedPrice returns the price to charge for a reward whose current price is
+// current. While the user's quote is valid its cost is charged; after it
+// expires the current price is charged only if it has not changed, so a user
+// is never charged a price they were not shown.
+func quotedPrice(ctx context.Context, q quoteStore, userID uuid.UUID, quoteID string, rewardID uuid.UUID, current pricing.Price, now time.Time) (pricing.Price, error) {
+	if quoteID == "" {
+		return current, nil
+	}
+	id, err := uuid.Parse(quoteID)
+	if err != nil {
+		return pricing.Price{}, errUnknownQuote
+	}
+	quote, err := q.GetPriceQuote(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && quote.UserID != userID) {
+		return pricing.Price{}, errUnknownQuote
+	}
+	if err != nil {
+		return pricing.Price{}, fmt.Errorf("failed to get price quote: %w", err)
+	}
+	var prices map[string]pricing.Price
+	if err := json.Unmarshal(quote.Prices, &prices); err != nil {
+		return pricing.Price{}, fmt.Errorf("invalid price quote: %w", err)
+	}
+	quoted, ok := prices[rewardID.String()]
+	if !ok {
+		return pricing.Price{}, errUnknownQuote
+	}
+
+	if now.Before(quote.ExpiresAt) {
+		return quoted, nil
+	}
+	if quoted.Cost != current.Cost {
+		return pricing.Price{}, &errs.Error{
+			Code:    errs.FailedPrecondition,
+			Message: "the quoted price has expired and the reward's price has changed",
+			Details: RedeemErrorDetails{Reason: reasonPriceChanged, Cost: current.Cost},
+		}
+	}
+	return current, nil
+}
@@ -0,0 +1,92 @@
+//go:build !encore
+// +build !encore
+
+package redemption
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"encore.app/internal/db"
+	"encore.app/internal/pricing"
+	"encore.dev/beta/errs"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeQuoteStore struct {
+	quotes map[uuid.UUID]db.PriceQuote
+}
+
+func (f *fakeQuoteStore) CreatePriceQuote(ctx context.Context, arg db.CreatePriceQuoteParams) (db.PriceQuote, error) {
+	quote := db.PriceQuote{ID: uuid.New(), UserID: arg.UserID, Prices: arg.Prices, ExpiresAt: arg.ExpiresAt}
+	f.quotes[quote.ID] = quote
+	return quote, nil
+}
+
+func (f *fakeQuoteStore) GetPriceQuote(ctx context.Context, id uuid.UUID) (db.PriceQuote, error) {
+	quote, ok := f.quotes[id]
+	if !ok {
+		return db.PriceQuote{}, sql.ErrNoRows
+	}
+	return quote, nil
+}
+
+func TestQuotedPrice(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	q := &fakeQuoteStore{quotes: map[uuid.UUID]db.PriceQuote{}}
+	userID, onSale, plain := uuid.New(), uuid.New(), uuid.New()
+	catalog := []Reward{
+		{ID: onSale.String(), Cost: 400, BaseCost: 500, PriceRule: pricing.TypeSale},
+		{ID: plain.String(), Cost: 300},
+	}
+	quote, err := createQuote(ctx, q, userID, catalog, now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(quoteWindow), quote.ExpiresAt)
+	quoteID := quote.ID.String()
+	base := pricing.Price{Cost: 500, BaseCost: 500}
+
+	t.Run("no quote", func(t *testing.T) {
+		got, err := quotedPrice(ctx, q, userID, "", onSale, base, now)
+		require.NoError(t, err)
+		assert.Equal(t, base, got)
+	})
+	t.Run("valid quote", func(t *testing.T) {
+		// The sale ended after the catalog was listed; the listed price holds
+		got, err := quotedPrice(ctx, q, userID, quoteID, onSale, base, now.Add(time.Minute))
+		require.NoError(t, err)
+		assert.Equal(t, pricing.Price{Cost: 400, BaseCost: 500, Rule: pricing.TypeSale}, got)
+
+		got, err = quotedPrice(ctx, q, userID, quoteID, plain, pricing.Price{Cost: 300, BaseCost: 300}, now.Add(time.Minute))
+		require.NoError(t, err)
+		assert.Equal(t, pricing.Price{Cost: 300, BaseCost: 300}, got)
+	})
+	t.Run("expired quote, same price", func(t *testing.T) {
+		current := pricing.Price{Cost: 400, BaseCost: 500, Rule: pricing.TypeTierDiscount}
+		got, err := quotedPrice(ctx, q, userID, quoteID, onSale, current, now.Add(quoteWindow))
+		require.NoError(t, err)
+		assert.Equal(t, current, got)
+	})
+	t.Run("expired quote, changed price", func(t *testing.T) {
+		_, err := quotedPrice(ctx, q, userID, quoteID, onSale, base, now.Add(quoteWindow))
+		var e *errs.Error
+		require.True(t, errors.As(err, &e))
+		assert.Equal(t, errs.FailedPrecondition, e.Code)
+		assert.Equal(t, RedeemErrorDetails{Reason: reasonPriceChanged, Cost: 500}, e.Details)
+	})
+	t.Run("unknown quote", func(t *testing.T) {
+		for name, id := range map[string]string{"malformed": "not-a-uuid", "missing": uuid.NewString()} {
+			_, err := quotedPrice(ctx, q, userID, id, onSale, base, now)
+			assert.Equal(t, errUnknownQuote, err, name)
+		}
+		_, err := quotedPrice(ctx, q, uuid.New(), quoteID, onSale, base, now)
+		assert.Equal(t, errUnknownQuote, err, "another user's quote")
+		_, err = quotedPrice(ctx, q, userID, quoteID, uuid.New(), base, now)
+		assert.Equal(t, errUnknownQuote, err, "reward not listed")
+	})
+}
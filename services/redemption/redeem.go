@@ -5,15 +5,36 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 
+	"encore.app/internal/auth"
+	"encore.app/internal/availability"
+	"encore.app/internal/credits"
 	"encore.app/internal/db"
+	"encore.app/internal/flags"
+	"encore.app/internal/inventory"
+	"encore.app/internal/limits"
+	"encore.app/internal/outbox"
+	"encore.app/internal/payments"
+	"encore.app/internal/pricing"
+	"encore.app/internal/segments"
+	"encore.app/internal/tiers"
+	"encore.app/internal/vouchers"
 	"github.com/google/uuid"
 )
 
-//encore:api public method=POST path=/v1/redeem
+// Redeem spends the authenticated user's points on a reward
+//
+//encore:api auth method=POST path=/v1/redeem
 func (s *Service) Redeem(ctx context.Context, req *RedeemRequest) (*RedeemResponse, error) {
+	// The user comes from the token; a user_id in the body must match it
+	principal, err := auth.RequireUser(auth.CurrentPrincipal(), req.UserID)
+	if err != nil {
+		return nil, err
+	}
+
 	// Parse user ID
-	userID, err := uuid.Parse(req.UserID)
+	userID, err := uuid.Parse(principal.UserID)
 	if err != nil {
 		return nil, fmt.Errorf("invalid user ID: %w", err)
 	}
@@ -24,67 +45,335 @@ func (s *Service) Redeem(ctx context.Context, req *RedeemRequest) (*RedeemRespon
 		return nil, fmt.Errorf("invalid reward ID: %w", err)
 	}
 
-	// Get the reward to check its cost
+	user, err := segments.LoadUser(ctx, s.db, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+
+	// Get the reward and check the user may redeem it now
+	now := time.Now()
+	reward, err := s.redeemableReward(ctx, rewardID, user, now)
+	if err != nil {
+		return nil, redeemError(err, now)
+	}
+
+	// Points + cash redemptions spend the reward's minimum points now and
+	// wait for the cash top-up before they are confirmed; others pay the
+	// user's price, as quoted in the catalog
+	var price pricing.Price
+	status := "PENDING"
+	if req.PayWithCash {
+		if err := checkCashPayment(reward, paymentProvider); err != nil {
+			return nil, err
+		}
+		price = pricing.Price{Cost: reward.MinPoints.Int32, BaseCost: reward.Cost}
+		status = "AWAITING_PAYMENT"
+	} else {
+		price, err = pricing.Evaluate(ctx, s.db, reward, user, now)
+		if err != nil {
+			return nil, err
+		}
+		price, err = quotedPrice(ctx, s.db, userID, req.QuoteID, rewardID, price, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+	pointsCost := price.Cost
+	expiresAt := now.Add(pendingTTL(reward))
+	if status == "AWAITING_PAYMENT" {
+		expiresAt = now.Add(paymentWindow)
+	}
+
+	// Check the limits and balance, spend the points and record the
+	// RedemptionCreated notification in one transaction; the outbox relay
+	// publishes it after commit
+	var redemption db.Redemption
+	var credit *IssuedCredit
+	var payment db.RedemptionPayment
+	err = db.WithTx(ctx, s.conn, func(q *db.Queries) error {
+		// Serialize the user's redemptions so concurrent requests cannot
+		// both pass the limit and balance checks
+		if err := q.LockUserRedemptions(ctx, userID); err != nil {
+			return fmt.Errorf("failed to lock user: %w", err)
+		}
+
+		// Get user's current points balance
+		balance, err := q.GetUserPointsBalance(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("failed to get user balance: %w", err)
+		}
+
+		// Check if user has enough points
+		if balance < int64(pointsCost) {
+			return insufficientPoints(int64(pointsCost), balance)
+		}
+
+		// Create the redemption; its voucher code is reserved but not
+		// shown while the redemption awaits payment
+		redemption, err = redeemUnit(ctx, q, unitRedemption{
+			userID:    userID,
+			reward:    reward,
+			price:     price,
+			status:    status,
+			expiresAt: expiresAt,
+		}, now)
+		if err != nil {
+			return err
+		}
+
+		if status == "AWAITING_PAYMENT" {
+			payment, err = q.CreateRedemptionPayment(ctx, db.CreateRedemptionPaymentParams{
+				RedemptionID: redemption.ID,
+				Provider:     paymentProvider.Name(),
+				Amount:       reward.CashPrice.Int64,
+				Currency:     payments.Currency,
+				ExpiresAt:    expiresAt,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to create payment: %w", err)
+			}
+			return nil
+		}
+		redemption, credit, err = confirmRedemption(ctx, q, reward, redemption, now)
+		return err
+	})
+	if err != nil {
+		return nil, redeemError(err, now)
+	}
+
+	if status == "AWAITING_PAYMENT" {
+		return s.startPayment(ctx, reward, redemption, payment, now)
+	}
+	return redeemResponse(redemption, credit, nil), nil
+}
+
+// unitRedemption is one redemption to create: a unit of reward for userID at
+// price, in a checkout if checkoutID is set
+type unitRedemption struct {
+	userID     uuid.UUID
+	reward     db.RewardsCatalog
+	price      pricing.Price
+	status     string
+	expiresAt  time.Time
+	checkoutID uuid.NullUUID
+}
+
+// redeemUnit creates a redemption of one unit of a reward: it enforces the
+// reward's per-user limits, takes a unit of stock, spends the points in the
+// redemption's own ledger entry and issues the voucher code, if any. Call it
+// in the user's redemption transaction after checking the balance; earlier
+// redemptions of the transaction count towards the limits.
+func redeemUnit(ctx context.Context, q *db.Queries, unit unitRedemption, now time.Time) (db.Redemption, error) {
+	// Enforce the reward's per-user limits and cooldown
+	if err := limits.Enforce(ctx, q, unit.userID, unit.reward, now); err != nil {
+		return db.Redemption{}, err
+	}
+
+	// Take a unit of stock; the last unit deactivates the reward
+	if _, err := inventory.Claim(ctx, q, unit.reward.ID, now); err != nil {
+		return db.Redemption{}, err
+	}
+
+	redemption, err := q.CreateRedemption(ctx, db.CreateRedemptionParams{
+		UserID:      unit.userID,
+		RewardID:    unit.reward.ID,
+		PointsSpent: unit.price.Cost,
+		ExpiresAt:   unit.expiresAt,
+		Status:      unit.status,
+		BaseCost:    sql.NullInt32{Int32: unit.price.BaseCost, Valid: true},
+		PriceRule:   sql.NullString{String: unit.price.Rule, Valid: unit.price.Rule != ""},
+		CheckoutID:  unit.checkoutID,
+	})
+	if err != nil {
+		return db.Redemption{}, fmt.Errorf("failed to create redemption: %w", err)
+	}
+
+	// Deduct points by creating a negative points event; each redemption
+	// has its own so it can be refunded alone
+	_, err = q.CreatePointsEvent(ctx, db.CreatePointsEventParams{
+		UserID:    unit.userID,
+		EventType: "REDEMPTION",
+		RefID:     sql.NullString{String: redemption.ID.String(), Valid: true},
+		Points:    -unit.price.Cost,
+	})
+	if err != nil {
+		return db.Redemption{}, fmt.Errorf("failed to deduct points: %w", err)
+	}
+
+	// Issue the reward's voucher code, if it has one
+	return issueVoucher(ctx, q, unit.reward, redemption)
+}
+
+// redeemableReward returns a reward the user may redeem at now: it must be
+// active, inside its availability window and offered to the user's segment
+// and tier
+func (s *Service) redeemableReward(ctx context.Context, rewardID uuid.UUID, user *segments.User, now time.Time) (db.RewardsCatalog, error) {
 	reward, err := s.db.GetReward(ctx, rewardID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("reward not found")
+			return db.RewardsCatalog{}, fmt.Errorf("reward not found")
 		}
-		return nil, fmt.Errorf("failed to get reward: %w", err)
+		return db.RewardsCatalog{}, fmt.Errorf("failed to get reward: %w", err)
 	}
 
 	if !reward.Active {
-		return nil, fmt.Errorf("reward is not active")
+		return db.RewardsCatalog{}, fmt.Errorf("reward is not active")
 	}
 
-	// Get user's current points balance
-	balance, err := s.db.GetUserPointsBalance(ctx, userID)
+	// Check the reward is inside its availability window
+	if err := availability.Check(reward, now); err != nil {
+		return db.RewardsCatalog{}, err
+	}
+
+	// Check the user is in the reward's target segment
+	target, err := segments.ParseTarget(reward.Segment.RawMessage)
+	if err != nil {
+		return db.RewardsCatalog{}, fmt.Errorf("invalid reward segment: %w", err)
+	}
+	eligible, err := target.Matches(ctx, s.db, user, now)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user balance: %w", err)
+		return db.RewardsCatalog{}, fmt.Errorf("failed to evaluate reward segment: %w", err)
 	}
+	if !eligible || (isEarlyAccess(reward.Segment.RawMessage) && !flags.Bool(earlyAccessFlag, user, false)) ||
+		!tiers.Eligible(user.Tier, reward.MinTier.String) {
+		return db.RewardsCatalog{}, fmt.Errorf("reward is not available to this user")
+	}
+	return reward, nil
+}
 
-	// Check if user has enough points
-	if balance < int64(reward.Cost) {
-		return nil, fmt.Errorf("insufficient points: need %d, have %d", reward.Cost, balance)
+// voucherWithdrawer is the subset of *db.Queries used to withdraw voucher
+// codes
+type voucherWithdrawer interface {
+	vouchers.Withdrawer
+	SetRedemptionVoucherCode(ctx context.Context, arg db.SetRedemptionVoucherCodeParams) error
+}
+
+// withdrawVoucher takes back the voucher code of a redemption that will not
+// be fulfilled, if it has one; revealed is whether the user has seen the
+// code. Call it in the redemption's transaction.
+func withdrawVoucher(ctx context.Context, q voucherWithdrawer, redemption db.Redemption, revealed bool) (db.Redemption, error) {
+	if !redemption.VoucherCode.Valid {
+		return redemption, nil
+	}
+	if err := vouchers.Withdraw(ctx, q, redemption.ID, revealed); err != nil {
+		return db.Redemption{}, fmt.Errorf("failed to withdraw voucher code: %w", err)
 	}
+	redemption.VoucherCode = sql.NullString{}
+	if err := q.SetRedemptionVoucherCode(ctx, db.SetRedemptionVoucherCodeParams{ID: redemption.ID}); err != nil {
+		return db.Redemption{}, fmt.Errorf("failed to withdraw voucher code: %w", err)
+	}
+	return redemption, nil
+}
 
-	// Create the redemption
-	redemption, err := s.db.CreateRedemption(ctx, db.CreateRedemptionParams{
-		UserID:      userID,
-		RewardID:    rewardID,
-		PointsSpent: reward.Cost,
-	})
+// issueVoucher issues the reward's voucher code to a new redemption, if the
+// reward has codes, and alerts when the code pool runs low. Call it in the
+// redemption's transaction.
+func issueVoucher(ctx context.Context, q *db.Queries, reward db.RewardsCatalog, redemption db.Redemption) (db.Redemption, error) {
+	issued, err := vouchers.Issue(ctx, q, reward, redemption.ID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create redemption: %w", err)
+		return db.Redemption{}, err
 	}
+	if issued.Code != "" {
+		redemption.VoucherCode = sql.NullString{String: issued.Code, Valid: true}
+		if err := q.SetRedemptionVoucherCode(ctx, db.SetRedemptionVoucherCodeParams{
+			ID:          redemption.ID,
+			VoucherCode: redemption.VoucherCode,
+		}); err != nil {
+			return db.Redemption{}, fmt.Errorf("failed to record voucher code: %w", err)
+		}
+	}
+	if issued.LowStock {
+		err := outbox.Enqueue(ctx, q, voucherPoolLowOutboxTopic, reward.ID.String(), &VoucherPoolLow{
+			RewardID:   reward.ID.String(),
+			RewardName: reward.Name,
+			Available:  issued.Available,
+			Threshold:  reward.CodeLowThreshold.Int32,
+		})
+		if err != nil {
+			return db.Redemption{}, err
+		}
+	}
+	return redemption, nil
+}
 
-	// Deduct points by creating a negative points event
-	_, err = s.db.CreatePointsEvent(ctx, db.CreatePointsEventParams{
-		UserID:    userID,
-		EventType: "REDEMPTION",
-		RefID:     sql.NullString{String: redemption.ID.String(), Valid: true},
-		Points:    -reward.Cost, // Negative points to deduct
+// confirmStore is the subset of *db.Queries used to confirm redemptions
+type confirmStore interface {
+	credits.Issuer
+	outbox.Enqueuer
+	UpdateRedemptionStatus(ctx context.Context, arg db.UpdateRedemptionStatusParams) (db.Redemption, error)
+}
+
+// confirmRedemption completes a pending redemption whose points, and cash if
+// any, have been paid: charging credit rewards are fulfilled at once and the
+// RedemptionCreated event is recorded. Call it in the redemption's
+// transaction.
+func confirmRedemption(ctx context.Context, q confirmStore, reward db.RewardsCatalog, redemption db.Redemption, now time.Time) (db.Redemption, *IssuedCredit, error) {
+	redemption, credit, err := fulfillCredit(ctx, q, reward, redemption, now)
+	if err != nil {
+		return db.Redemption{}, nil, err
+	}
+	err = outbox.Enqueue(ctx, q, redemptionOutboxTopic, redemption.UserID.String(), redemptionCreated(redemption))
+	return redemption, credit, err
+}
+
+// fulfillCredit fulfills a paid redemption of a charging credit reward at once
+// with a credit the charge backend applies to the user's next sessions; other
+// redemptions are returned unchanged
+func fulfillCredit(ctx context.Context, q confirmStore, reward db.RewardsCatalog, redemption db.Redemption, now time.Time) (db.Redemption, *IssuedCredit, error) {
+	if !credits.IsCredit(reward) {
+		return redemption, nil, nil
+	}
+	issuedCredit, err := credits.Issue(ctx, q, reward, redemption, now)
+	if err != nil {
+		return db.Redemption{}, nil, fmt.Errorf("failed to issue charging credit: %w", err)
+	}
+	redemption, err = q.UpdateRedemptionStatus(ctx, db.UpdateRedemptionStatusParams{
+		ID:     redemption.ID,
+		Status: "FULFILLED",
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to deduct points: %w", err)
+		return db.Redemption{}, nil, fmt.Errorf("failed to fulfill redemption: %w", err)
 	}
+	return redemption, &IssuedCredit{
+		ID:        issuedCredit.ID.String(),
+		Amount:    issuedCredit.Amount,
+		Currency:  issuedCredit.Currency,
+		ExpiresAt: issuedCredit.ExpiresAt,
+	}, nil
+}
 
-	// Publish RedemptionCreated event
-	_, err = RedemptionCreatedTopic.Publish(ctx, &RedemptionCreated{
+// redemptionCreated describes a confirmed redemption for its event
+func redemptionCreated(redemption db.Redemption) *RedemptionCreated {
+	return &RedemptionCreated{
 		RedemptionID: redemption.ID.String(),
-		UserID:       req.UserID,
-		RewardID:     req.RewardID,
+		UserID:       redemption.UserID.String(),
+		RewardID:     redemption.RewardID.String(),
 		PointsSpent:  redemption.PointsSpent,
 		Status:       redemption.Status,
-	})
-	if err != nil {
-		// Log error but don't fail the request
-		// In production, you might want to handle this differently
+		VoucherCode:  redemption.VoucherCode.String,
 	}
+}
 
+// redeemResponse describes a redemption to the user who made it
+func redeemResponse(redemption db.Redemption, credit *IssuedCredit, payment *Payment) *RedeemResponse {
 	return &RedeemResponse{
 		RedemptionID: redemption.ID.String(),
 		PointsSpent:  redemption.PointsSpent,
+		BaseCost:     redemption.BaseCost.Int32,
+		PriceRule:    redemption.PriceRule.String,
 		Status:       redemption.Status,
-	}, nil
+		VoucherCode:  visibleVoucherCode(redemption.Status, redemption.VoucherCode),
+		Credit:       credit,
+		Payment:      payment,
+	}
+}
+
+// visibleVoucherCode returns a redemption's voucher code, withheld until the
+// redemption is paid for and once it can no longer be used
+func visibleVoucherCode(status string, code sql.NullString) string {
+	switch status {
+	case "AWAITING_PAYMENT", "CANCELLED", "EXPIRED", "FAILED":
+		return ""
+	}
+	return code.String
 }
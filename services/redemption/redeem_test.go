@@ -4,9 +4,18 @@
 package redemption
 
 import (
+	"database/sql"
+	"errors"
 	"testing"
+	"time"
 
+	"encore.app/internal/availability"
+	"encore.app/internal/inventory"
+	"encore.app/internal/limits"
+	"encore.app/internal/vouchers"
+	"encore.dev/beta/errs"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestRedeemRequest_Validation(t *testing.T) {
@@ -46,3 +55,81 @@ func TestRedemptionCreated_EventStructure(t *testing.T) {
 	assert.Greater(t, event.PointsSpent, int32(0))
 	assert.NotEmpty(t, event.Status)
 }
+
+func TestVisibleVoucherCode(t *testing.T) {
+	code := sql.NullString{String: "ACME-7KQ2", Valid: true}
+	for status, want := range map[string]string{
+		"PENDING":          "ACME-7KQ2",
+		"FULFILLED":        "ACME-7KQ2",
+		"AWAITING_PAYMENT": "",
+		"CANCELLED":        "",
+		"EXPIRED":          "",
+		"FAILED":           "",
+	} {
+		assert.Equal(t, want, visibleVoucherCode(status, code), status)
+	}
+}
+
+func TestRedeemError(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	cooldownEnds := now.Add(90 * time.Minute)
+	tomorrow := time.Date(2024, 6, 16, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		err  error
+		want RedeemErrorDetails
+	}{
+		{
+			name: "lifetime limit",
+			err:  &limits.Error{Reason: limits.ReasonLifetimeLimit, Limit: 3},
+			want: RedeemErrorDetails{Reason: limits.ReasonLifetimeLimit, Limit: 3},
+		},
+		{
+			name: "cooldown",
+			err:  &limits.Error{Reason: limits.ReasonCooldown, Cooldown: 2 * time.Hour, AvailableAt: cooldownEnds},
+			want: RedeemErrorDetails{Reason: limits.ReasonCooldown, AvailableAt: &cooldownEnds, RetryAfterSeconds: 5400},
+		},
+		{
+			name: "sold out",
+			err:  inventory.ErrSoldOut,
+			want: RedeemErrorDetails{Reason: reasonSoldOut},
+		},
+		{
+			name: "voucher pool empty",
+			err:  vouchers.ErrPoolEmpty,
+			want: RedeemErrorDetails{Reason: reasonSoldOut},
+		},
+		{
+			name: "sold out today",
+			err:  inventory.ErrSoldOutToday,
+			want: RedeemErrorDetails{Reason: reasonSoldOutToday, AvailableAt: &tomorrow, RetryAfterSeconds: 43200},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e *errs.Error
+			require.ErrorAs(t, redeemError(tt.err, now), &e)
+			assert.Equal(t, errs.ResourceExhausted, e.Code)
+			assert.Equal(t, tt.want, e.Details)
+		})
+	}
+
+	// Rewards outside their availability window are a failed precondition
+	var e *errs.Error
+	require.ErrorAs(t, redeemError(&availability.Error{Reason: availability.ReasonNotAvailableToday, AvailableAt: tomorrow}, now), &e)
+	assert.Equal(t, errs.FailedPrecondition, e.Code)
+	assert.Equal(t, RedeemErrorDetails{Reason: availability.ReasonNotAvailableToday, AvailableAt: &tomorrow, RetryAfterSeconds: 43200}, e.Details)
+	require.ErrorAs(t, redeemError(&availability.Error{Reason: availability.ReasonNoLongerAvailable}, now), &e)
+	assert.Equal(t, RedeemErrorDetails{Reason: availability.ReasonNoLongerAvailable}, e.Details)
+
+	// A short balance is a failed precondition with the shortfall
+	require.ErrorAs(t, insufficientPoints(500, 300), &e)
+	assert.Equal(t, errs.FailedPrecondition, e.Code)
+	assert.Equal(t, RedeemErrorDetails{Reason: reasonNoPoints, Need: 500, Have: 300}, e.Details)
+
+	// Other errors pass through unchanged
+	other := errors.New("failed to get user balance")
+	assert.Equal(t, other, redeemError(other, now))
+}
@@ -5,13 +5,16 @@ package redemption
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"encore.app/internal/db"
 )
 
 //encore:service
 type Service struct {
-	db *db.Queries
+	conn *sql.DB
+	db   *db.Queries
 }
 
 // Reward represents a reward from the catalog
@@ -19,26 +22,165 @@ type Reward struct {
 	ID          string `json:"id"`
 	Name        string `json:"name"`
 	Description string `json:"description,omitempty"`
-	Cost        int32  `json:"cost"`
-	Segment     string `json:"segment,omitempty"`
+	// Category is the reward's category, e.g. charging
+	Category string   `json:"category,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	ImageURL string   `json:"image_url,omitempty"`
+	// Terms are the reward's terms & conditions
+	Terms string `json:"terms,omitempty"`
+	// Partner is the partner providing the reward, if any
+	Partner *RewardPartner `json:"partner,omitempty"`
+	// Featured rewards are listed first and highlighted
+	Featured bool `json:"featured,omitempty"`
+	// Cost is the user's price, after price rules
+	Cost int32 `json:"cost"`
+	// BaseCost is the cost before price rules, set when a rule lowered it
+	BaseCost int32 `json:"base_cost,omitempty"`
+	// PriceRule is the type of the rule that lowered the cost: tier_discount,
+	// segment_price or sale
+	PriceRule string `json:"price_rule,omitempty"`
+	// PriceEndsAt is when the lowered cost ends, if it does
+	PriceEndsAt *time.Time `json:"price_ends_at,omitempty"`
+	Segment     string     `json:"segment,omitempty"`
+	// MinTier is the lowest loyalty tier the reward is offered to, if limited
+	MinTier string `json:"min_tier,omitempty"`
+	// RewardType is standard or charging_credit
+	RewardType string `json:"reward_type"`
+	// CreditAmount is the charging credit issued, in paise, for charging_credit rewards
+	CreditAmount int64 `json:"credit_amount,omitempty"`
+	// CanAfford reports whether the user's balance covers the cost
+	CanAfford bool `json:"can_afford"`
+	// CashOption is the points + cash price, for rewards that offer one
+	CashOption *CashOption `json:"cash_option,omitempty"`
+	// CanAffordWithCash reports whether the user's balance covers the points
+	// part of the cash option
+	CanAffordWithCash bool `json:"can_afford_with_cash,omitempty"`
+	// PointsShort is how many more points the user needs, 0 if affordable
+	PointsShort int32 `json:"points_short"`
+	// StockRemaining is the number of units left, omitted when unlimited
+	StockRemaining *int32 `json:"stock_remaining,omitempty"`
+	// DailyStockRemaining is the number of units left today, omitted when unlimited
+	DailyStockRemaining *int32 `json:"daily_stock_remaining,omitempty"`
+	// AvailableUntil is when the reward stops being available, if scheduled
+	AvailableUntil *time.Time `json:"available_until,omitempty"`
+}
+
+// RewardPartner is the partner providing a reward
+type RewardPartner struct {
+	Name    string `json:"name"`
+	URL     string `json:"url,omitempty"`
+	LogoURL string `json:"logo_url,omitempty"`
+}
+
+// GetRewardsParams filters the catalog
+type GetRewardsParams struct {
+	// Category limits the catalog to one category
+	Category string `query:"category"`
+	// Tag limits the catalog to rewards with the tag
+	Tag string `query:"tag"`
+	// Q searches the rewards' names, descriptions, categories, tags and
+	// partners
+	Q string `query:"q"`
+	// Featured limits the catalog to featured rewards
+	Featured bool `query:"featured"`
 }
 
 // GetRewardsResponse represents the response for getting rewards
 type GetRewardsResponse struct {
 	Rewards []Reward `json:"rewards"`
+	// QuoteID guarantees the listed costs when passed to Redeem before
+	// QuoteExpiresAt
+	QuoteID        string    `json:"quote_id"`
+	QuoteExpiresAt time.Time `json:"quote_expires_at"`
 }
 
-// RedeemRequest represents a redemption request
+// RedeemRequest represents a redemption request. The user is taken from the
+// auth token; UserID is optional and, if set, must match the authenticated user.
 type RedeemRequest struct {
-	UserID   string `json:"user_id"`
+	UserID   string `json:"user_id,omitempty"`
 	RewardID string `json:"reward_id"`
+	// PayWithCash pays with the reward's cash option: its minimum points
+	// plus a cash top-up
+	PayWithCash bool `json:"pay_with_cash,omitempty"`
+	// QuoteID is the quote_id of the catalog the user redeems from; the
+	// catalog's cost is charged while the quote is valid
+	QuoteID string `json:"quote_id,omitempty"`
+}
+
+// CashOption is a reward's points + cash price
+type CashOption struct {
+	Points int32 `json:"points"`
+	// CashPrice is the top-up in paise
+	CashPrice int64  `json:"cash_price"`
+	Currency  string `json:"currency"`
 }
 
 // RedeemResponse represents the response from a redemption
 type RedeemResponse struct {
 	RedemptionID string `json:"redemption_id"`
 	PointsSpent  int32  `json:"points_spent"`
-	Status       string `json:"status"`
+	// BaseCost is the reward's cost before price rules
+	BaseCost int32 `json:"base_cost,omitempty"`
+	// PriceRule is the type of the price rule that set points_spent, if any
+	PriceRule string `json:"price_rule,omitempty"`
+	Status    string `json:"status"`
+	// VoucherCode is the code issued for rewards with a code pool or generated codes
+	VoucherCode string `json:"voucher_code,omitempty"`
+	// Credit is the charging credit issued for charging_credit rewards
+	Credit *IssuedCredit `json:"credit,omitempty"`
+	// Payment is the cash top-up of points + cash redemptions
+	Payment *Payment `json:"payment,omitempty"`
+}
+
+// Payment is the cash top-up of a points + cash redemption
+type Payment struct {
+	// IntentID is the payment provider's intent
+	IntentID string `json:"intent_id,omitempty"`
+	// ClientSecret lets the app complete the payment with the provider; it is
+	// only returned while the payment is open
+	ClientSecret string `json:"client_secret,omitempty"`
+	// Amount is in paise
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+	// Status is REQUIRES_PAYMENT, SUCCEEDED, FAILED or CANCELLED
+	Status        string `json:"status"`
+	FailureReason string `json:"failure_reason,omitempty"`
+	// ExpiresAt is when an unpaid payment is cancelled
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// IssuedCredit is a charging credit issued with a redemption
+type IssuedCredit struct {
+	ID string `json:"id"`
+	// Amount is the credit in paise
+	Amount    int64     `json:"amount"`
+	Currency  string    `json:"currency"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CheckoutRequest redeems several rewards at once. The user is taken from the
+// auth token; UserID is optional and, if set, must match the authenticated user.
+type CheckoutRequest struct {
+	UserID string         `json:"user_id,omitempty"`
+	Items  []CheckoutItem `json:"items"`
+	// QuoteID is the quote_id of the catalog the user redeems from; the
+	// catalog's costs are charged while the quote is valid
+	QuoteID string `json:"quote_id,omitempty"`
+}
+
+// CheckoutItem is a reward in a checkout
+type CheckoutItem struct {
+	RewardID string `json:"reward_id"`
+	// Quantity is the units of the reward to redeem, 1 if omitted
+	Quantity int32 `json:"quantity,omitempty"`
+}
+
+// CheckoutResponse is a completed checkout and its redemptions, one per unit
+type CheckoutResponse struct {
+	CheckoutID string `json:"checkout_id"`
+	// PointsSpent is the total of the redemptions' points
+	PointsSpent int32            `json:"points_spent"`
+	Redemptions []RedeemResponse `json:"redemptions"`
 }
 
 // RedemptionCreated is published when a redemption is created
@@ -48,6 +190,8 @@ type RedemptionCreated struct {
 	RewardID     string `json:"reward_id"`
 	PointsSpent  int32  `json:"points_spent"`
 	Status       string `json:"status"`
+	// VoucherCode is the code issued with the redemption, if any
+	VoucherCode string `json:"voucher_code,omitempty"`
 }
 
 // RedemptionCreatedTopic is a mock topic for non-Encore builds
@@ -61,6 +205,156 @@ func (m *MockTopic) Publish(ctx context.Context, msg *RedemptionCreated) (string
 	return "mock-message-id", nil
 }
 
+// CheckoutCompleted is published once for a checkout, in place of a
+// RedemptionCreated event for each of its redemptions
+type CheckoutCompleted struct {
+	CheckoutID  string              `json:"checkout_id"`
+	UserID      string              `json:"user_id"`
+	PointsSpent int32               `json:"points_spent"`
+	Redemptions []RedemptionCreated `json:"redemptions"`
+}
+
+// CheckoutCompletedTopic is a mock topic for non-Encore builds
+var CheckoutCompletedTopic = &MockCheckoutCompletedTopic{}
+
+// MockCheckoutCompletedTopic is a mock implementation for testing
+type MockCheckoutCompletedTopic struct{}
+
+func (m *MockCheckoutCompletedTopic) Publish(ctx context.Context, msg *CheckoutCompleted) (string, error) {
+	// Mock implementation - does nothing
+	return "mock-message-id", nil
+}
+
+// CancelRedemptionResponse is a cancelled redemption
+type CancelRedemptionResponse struct {
+	RedemptionID string `json:"redemption_id"`
+	Status       string `json:"status"`
+	// PointsRefunded is the points returned to the user's balance
+	PointsRefunded int32     `json:"points_refunded"`
+	CancelledAt    time.Time `json:"cancelled_at"`
+}
+
+// RedemptionCancelled is published when a user cancels a redemption
+type RedemptionCancelled struct {
+	RedemptionID   string    `json:"redemption_id"`
+	UserID         string    `json:"user_id"`
+	RewardID       string    `json:"reward_id"`
+	PointsRefunded int32     `json:"points_refunded"`
+	CancelledAt    time.Time `json:"cancelled_at"`
+}
+
+// ListRedemptionsParams filters and pages a user's redemption history
+type ListRedemptionsParams struct {
+	// Status limits the list to one status, e.g. PENDING
+	Status string `query:"status"`
+	// Limit is the page size, 20 by default and at most 100
+	Limit int `query:"limit"`
+	// Offset is the number of redemptions to skip
+	Offset int `query:"offset"`
+}
+
+// ListRedemptionsResponse is a page of a user's redemptions, newest first
+type ListRedemptionsResponse struct {
+	Redemptions []RedemptionSummary `json:"redemptions"`
+	// NextOffset is the offset of the next page, omitted on the last page
+	NextOffset *int `json:"next_offset,omitempty"`
+}
+
+// RedemptionSummary is a redemption in a user's history
+type RedemptionSummary struct {
+	RedemptionID string `json:"redemption_id"`
+	RewardID     string `json:"reward_id"`
+	RewardName   string `json:"reward_name"`
+	RewardType   string `json:"reward_type"`
+	PointsSpent  int32  `json:"points_spent"`
+	// BaseCost is the reward's cost before price rules, when recorded
+	BaseCost    int32  `json:"base_cost,omitempty"`
+	Status      string `json:"status"`
+	VoucherCode string `json:"voucher_code,omitempty"`
+	// ExpiresAt is when a pending redemption expires unless fulfilled, or
+	// when an unpaid one is cancelled
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// RedemptionDetails is a redemption with its reward and status timeline
+type RedemptionDetails struct {
+	RedemptionID string         `json:"redemption_id"`
+	UserID       string         `json:"user_id"`
+	Reward       RedeemedReward `json:"reward"`
+	PointsSpent  int32          `json:"points_spent"`
+	// BaseCost is the reward's cost before price rules, when recorded
+	BaseCost int32 `json:"base_cost,omitempty"`
+	// PriceRule is the type of the price rule that set points_spent, if any
+	PriceRule   string `json:"price_rule,omitempty"`
+	Status      string `json:"status"`
+	VoucherCode string `json:"voucher_code,omitempty"`
+	// Credit is the charging credit issued for charging_credit rewards
+	Credit *IssuedCredit `json:"credit,omitempty"`
+	// Payment is the cash top-up of points + cash redemptions
+	Payment *Payment `json:"payment,omitempty"`
+	// CheckoutID is the checkout that made the redemption, if any
+	CheckoutID string `json:"checkout_id,omitempty"`
+	// Timeline lists the statuses the redemption has been through, oldest first
+	Timeline []StatusChange `json:"timeline"`
+	// ExpiresAt is when a pending redemption expires unless fulfilled, or
+	// when an unpaid one is cancelled
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// CancellableUntil is when a pending redemption stops being cancellable
+	CancellableUntil *time.Time `json:"cancellable_until,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// RedeemedReward is the catalog entry a redemption was made for
+type RedeemedReward struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	RewardType  string `json:"reward_type"`
+	ImageURL    string `json:"image_url,omitempty"`
+	// Terms are the reward's terms & conditions
+	Terms string `json:"terms,omitempty"`
+}
+
+// StatusChange is a status a redemption entered
+type StatusChange struct {
+	Status string    `json:"status"`
+	At     time.Time `json:"at"`
+}
+
+// VoucherPoolLow is published when a reward's voucher code pool drops to its
+// low-stock threshold
+type VoucherPoolLow struct {
+	RewardID   string `json:"reward_id"`
+	RewardName string `json:"reward_name"`
+	Available  int32  `json:"available"`
+	Threshold  int32  `json:"threshold"`
+}
+
+// VoucherPoolLowTopic is a mock topic for non-Encore builds
+var VoucherPoolLowTopic = &MockVoucherPoolLowTopic{}
+
+// MockVoucherPoolLowTopic is a mock implementation for testing
+type MockVoucherPoolLowTopic struct{}
+
+func (m *MockVoucherPoolLowTopic) Publish(ctx context.Context, msg *VoucherPoolLow) (string, error) {
+	// Mock implementation - does nothing
+	return "mock-message-id", nil
+}
+
+// RedemptionCancelledTopic is a mock topic for non-Encore builds
+var RedemptionCancelledTopic = &MockRedemptionCancelledTopic{}
+
+// MockRedemptionCancelledTopic is a mock implementation for testing
+type MockRedemptionCancelledTopic struct{}
+
+func (m *MockRedemptionCancelledTopic) Publish(ctx context.Context, msg *RedemptionCancelled) (string, error) {
+	// Mock implementation - does nothing
+	return "mock-message-id", nil
+}
+
 // init initializes the redemption service
 func init() {
 	// Service will be initialized by Encore
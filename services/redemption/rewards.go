@@ -2,34 +2,175 @@ package redemption
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"encore.app/internal/auth"
+	"encore.app/internal/catalog"
+	"encore.app/internal/db"
+	"encore.app/internal/flags"
+	"encore.app/internal/inventory"
+	"encore.app/internal/pricing"
+	"encore.app/internal/segments"
+	"encore.app/internal/tiers"
+	"github.com/google/uuid"
 )
 
-//encore:api public method=GET path=/v1/rewards
-func (s *Service) GetRewards(ctx context.Context) (*GetRewardsResponse, error) {
-	// Get all active rewards from the catalog
-	rewards, err := s.db.GetRewardsCatalog(ctx)
+// earlyAccessFlag gates rewards whose segment has "type": "early-access"
+const earlyAccessFlag = "early-access"
+
+// GetRewards returns the active rewards the authenticated user can see,
+// annotated with whether their balance covers each one, optionally filtered
+// by category, tag, search query or featured flag
+//
+//encore:api auth method=GET path=/v1/rewards
+func (s *Service) GetRewards(ctx context.Context, params *GetRewardsParams) (*GetRewardsResponse, error) {
+	principal, err := auth.RequireUser(auth.CurrentPrincipal(), "")
+	if err != nil {
+		return nil, err
+	}
+
+	userID, err := uuid.Parse(principal.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user ID: %w", err)
+	}
+
+	user, err := segments.LoadUser(ctx, s.db, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+
+	// Get the active rewards available now from the catalog
+	now := time.Now()
+	rewards, err := s.db.GetRewardsCatalog(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+	rewards = filterCatalog(rewards, catalog.Filter{
+		Category: params.Category,
+		Tag:      params.Tag,
+		Query:    params.Q,
+		Featured: params.Featured,
+	})
+
+	redeemedToday, err := inventory.RedeemedToday(ctx, s.db, now)
+	if err != nil {
+		return nil, err
+	}
+
+	listed, err := personalizeCatalog(ctx, s.db, rewards, redeemedToday, user, flags.Bool(earlyAccessFlag, user, false), now)
+	if err != nil {
+		return nil, err
+	}
+
+	// Quote the listed costs so a redemption made shortly after is charged
+	// what the user was shown
+	quote, err := createQuote(ctx, s.db, userID, listed, now)
 	if err != nil {
 		return nil, err
 	}
+	return &GetRewardsResponse{Rewards: listed, QuoteID: quote.ID.String(), QuoteExpiresAt: quote.ExpiresAt}, nil
+}
 
-	// Convert database models to API response
-	response := &GetRewardsResponse{
-		Rewards: make([]Reward, len(rewards)),
+// filterCatalog keeps the rewards matching the filter, in their order
+func filterCatalog(rewards []db.RewardsCatalog, filter catalog.Filter) []db.RewardsCatalog {
+	kept := rewards[:0:0]
+	for _, reward := range rewards {
+		if filter.Matches(reward) {
+			kept = append(kept, reward)
+		}
 	}
+	return kept
+}
+
+// personalizeCatalog keeps the rewards the user is eligible for and annotates
+// each with the user's price and its affordability. Early-access rewards are
+// only kept when the user has the early-access flag, and tier-exclusive
+// rewards when the user's tier is high enough. redeemedToday holds the units
+// of each reward claimed today, for the remaining daily stock.
+func personalizeCatalog(ctx context.Context, q segments.Querier, rewards []db.RewardsCatalog, redeemedToday map[uuid.UUID]int32, user *segments.User, earlyAccess bool, now time.Time) ([]Reward, error) {
+	listed := make([]Reward, 0, len(rewards))
+	for _, reward := range rewards {
+		if !earlyAccess && isEarlyAccess(reward.Segment.RawMessage) {
+			continue
+		}
+		if !tiers.Eligible(user.Tier, reward.MinTier.String) {
+			continue
+		}
+
+		// Segment names the audience the reward is limited to, if any
+		target, err := segments.ParseTarget(reward.Segment.RawMessage)
+		if err != nil {
+			return nil, fmt.Errorf("invalid segment on reward %s: %w", reward.ID, err)
+		}
+		eligible, err := target.Matches(ctx, q, user, now)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate reward segment: %w", err)
+		}
+		if !eligible {
+			continue
+		}
 
-	for i, reward := range rewards {
-		response.Rewards[i] = Reward{
-			ID:      reward.ID.String(),
-			Name:    reward.Name,
-			Cost:    reward.Cost,
-			Segment: "", // TODO: Implement segment matching logic
+		price, err := pricing.Evaluate(ctx, q, reward, user, now)
+		if err != nil {
+			return nil, err
+		}
+
+		r := Reward{
+			ID:           reward.ID.String(),
+			Name:         reward.Name,
+			Category:     reward.Category.String,
+			Tags:         catalog.Tags(reward.Tags.RawMessage),
+			ImageURL:     reward.ImageUrl.String,
+			Terms:        reward.Terms.String,
+			Featured:     reward.Featured,
+			Cost:         price.Cost,
+			Segment:      target.Name,
+			MinTier:      reward.MinTier.String,
+			RewardType:   reward.RewardType,
+			CreditAmount: reward.CreditAmount.Int64,
+			CanAfford:    user.Balance >= int64(price.Cost),
+		}
+		if reward.PartnerName.Valid {
+			r.Partner = &RewardPartner{
+				Name:    reward.PartnerName.String,
+				URL:     reward.PartnerUrl.String,
+				LogoURL: reward.PartnerLogoUrl.String,
+			}
+		}
+		if price.Discounted() {
+			r.BaseCost, r.PriceRule, r.PriceEndsAt = price.BaseCost, price.Rule, price.EndsAt
+		}
+		if !r.CanAfford {
+			r.PointsShort = int32(int64(price.Cost) - user.Balance)
+		}
+		if r.CashOption = cashOption(reward); r.CashOption != nil {
+			r.CanAffordWithCash = user.Balance >= int64(r.CashOption.Points)
+		}
+		r.StockRemaining, r.DailyStockRemaining = inventory.Remaining(reward, redeemedToday[reward.ID])
+		if reward.AvailableUntil.Valid {
+			until := reward.AvailableUntil.Time
+			r.AvailableUntil = &until
 		}
 
 		// Add description if available
 		if reward.Description.Valid {
-			response.Rewards[i].Description = reward.Description.String
+			r.Description = reward.Description.String
 		}
+		listed = append(listed, r)
 	}
+	return listed, nil
+}
 
-	return response, nil
+// isEarlyAccess reports whether a reward's segment marks it as early access
+func isEarlyAccess(segment json.RawMessage) bool {
+	if len(segment) == 0 {
+		return false
+	}
+	var s struct {
+		Type string `json:"type"`
+	}
+	_ = json.Unmarshal(segment, &s)
+	return s.Type == earlyAccessFlag
 }
@@ -4,9 +4,19 @@
 package redemption
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
 	"testing"
+	"time"
 
+	"encore.app/internal/catalog"
+	"encore.app/internal/db"
+	"encore.app/internal/segments"
+	"github.com/google/uuid"
+	"github.com/sqlc-dev/pqtype"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetRewards_ResponseStructure(t *testing.T) {
@@ -41,3 +51,201 @@ func TestReward_Validation(t *testing.T) {
 	assert.NotEmpty(t, reward.Name)
 	assert.Greater(t, reward.Cost, int32(0))
 }
+
+type fakeSegmentQuerier struct {
+	segments map[string]db.Segment
+}
+
+func (f *fakeSegmentQuerier) GetUserSegmentFacts(ctx context.Context, id uuid.UUID) (db.GetUserSegmentFactsRow, error) {
+	return db.GetUserSegmentFactsRow{}, sql.ErrNoRows
+}
+
+func (f *fakeSegmentQuerier) GetSegmentByName(ctx context.Context, name string) (db.Segment, error) {
+	s, ok := f.segments[name]
+	if !ok {
+		return s, sql.ErrNoRows
+	}
+	return s, nil
+}
+
+func catalogReward(name string, cost int32, segment string) db.RewardsCatalog {
+	r := db.RewardsCatalog{ID: uuid.New(), Name: name, Cost: cost, Active: true}
+	if segment != "" {
+		r.Segment = pqtype.NullRawMessage{RawMessage: json.RawMessage(segment), Valid: true}
+	}
+	return r
+}
+
+func TestPersonalizeCatalog(t *testing.T) {
+	q := &fakeSegmentQuerier{segments: map[string]db.Segment{
+		"power-chargers": {
+			Name:     "power-chargers",
+			Criteria: json.RawMessage(`{"attr": "lifetime_kwh", "op": "gte", "value": 500}`),
+			Active:   true,
+		},
+	}}
+	rewards := []db.RewardsCatalog{
+		catalogReward("Coffee", 100, ""),
+		catalogReward("Free Session", 500, `{"name": "power-chargers"}`),
+		catalogReward("Pune Lounge", 200, `{"criteria": {"attr": "city", "op": "eq", "value": "Pune"}}`),
+		catalogReward("Preview", 50, `{"type": "early-access"}`),
+	}
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	names := func(catalog []Reward) []string {
+		var out []string
+		for _, r := range catalog {
+			out = append(out, r.Name)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name        string
+		user        *segments.User
+		earlyAccess bool
+		want        []string
+	}{
+		{"everyone rewards only", &segments.User{Balance: 300, City: "Delhi"}, false, []string{"Coffee"}},
+		{"segment member", &segments.User{Balance: 300, LifetimeKWH: 800}, false, []string{"Coffee", "Free Session"}},
+		{"inline criteria", &segments.User{Balance: 300, City: "pune"}, false, []string{"Coffee", "Pune Lounge"}},
+		{"early access flag", &segments.User{Balance: 300}, true, []string{"Coffee", "Preview"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			catalog, err := personalizeCatalog(context.Background(), q, rewards, nil, tt.user, tt.earlyAccess, now)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, names(catalog))
+		})
+	}
+}
+
+func TestPersonalizeCatalog_Affordability(t *testing.T) {
+	rewards := []db.RewardsCatalog{
+		catalogReward("Coffee", 100, ""),
+		catalogReward("Exact", 300, ""),
+		catalogReward("Session", 500, ""),
+	}
+	user := &segments.User{Balance: 300}
+
+	catalog, err := personalizeCatalog(context.Background(), &fakeSegmentQuerier{}, rewards, nil, user, false, time.Now())
+	require.NoError(t, err)
+	require.Len(t, catalog, 3)
+
+	assert.True(t, catalog[0].CanAfford)
+	assert.Equal(t, int32(0), catalog[0].PointsShort)
+	assert.True(t, catalog[1].CanAfford)
+	assert.Equal(t, int32(0), catalog[1].PointsShort)
+	assert.False(t, catalog[2].CanAfford)
+	assert.Equal(t, int32(200), catalog[2].PointsShort)
+}
+
+func TestPersonalizeCatalog_CashOption(t *testing.T) {
+	hybrid := catalogReward("Session", 500, "")
+	hybrid.MinPoints = sql.NullInt32{Int32: 200, Valid: true}
+	hybrid.CashPrice = sql.NullInt64{Int64: 4900, Valid: true}
+	user := &segments.User{Balance: 300}
+
+	catalog, err := personalizeCatalog(context.Background(), &fakeSegmentQuerier{}, []db.RewardsCatalog{hybrid, catalogReward("Coffee", 100, "")}, nil, user, false, time.Now())
+	require.NoError(t, err)
+	require.Len(t, catalog, 2)
+
+	assert.False(t, catalog[0].CanAfford)
+	assert.Equal(t, &CashOption{Points: 200, CashPrice: 4900, Currency: "INR"}, catalog[0].CashOption)
+	assert.True(t, catalog[0].CanAffordWithCash)
+	assert.Nil(t, catalog[1].CashOption)
+	assert.False(t, catalog[1].CanAffordWithCash)
+}
+
+func TestPersonalizeCatalog_Metadata(t *testing.T) {
+	coffee := catalogReward("Coffee", 100, "")
+	coffee.Category = sql.NullString{String: "food", Valid: true}
+	coffee.Tags = pqtype.NullRawMessage{RawMessage: json.RawMessage(`["weekend", "partner-deal"]`), Valid: true}
+	coffee.ImageUrl = sql.NullString{String: "https://cdn.urja.example.com/rewards/coffee.png", Valid: true}
+	coffee.Terms = sql.NullString{String: "One per visit", Valid: true}
+	coffee.PartnerName = sql.NullString{String: "Blue Tokai", Valid: true}
+	coffee.PartnerUrl = sql.NullString{String: "https://bluetokai.example.com", Valid: true}
+	coffee.Featured = true
+
+	catalog, err := personalizeCatalog(context.Background(), &fakeSegmentQuerier{}, []db.RewardsCatalog{coffee, catalogReward("Session", 500, "")}, nil, &segments.User{}, false, time.Now())
+	require.NoError(t, err)
+	require.Len(t, catalog, 2)
+
+	assert.Equal(t, "food", catalog[0].Category)
+	assert.Equal(t, []string{"weekend", "partner-deal"}, catalog[0].Tags)
+	assert.Equal(t, "https://cdn.urja.example.com/rewards/coffee.png", catalog[0].ImageURL)
+	assert.Equal(t, "One per visit", catalog[0].Terms)
+	assert.Equal(t, &RewardPartner{Name: "Blue Tokai", URL: "https://bluetokai.example.com"}, catalog[0].Partner)
+	assert.True(t, catalog[0].Featured)
+
+	assert.Empty(t, catalog[1].Category)
+	assert.Nil(t, catalog[1].Tags)
+	assert.Nil(t, catalog[1].Partner)
+	assert.False(t, catalog[1].Featured)
+}
+
+func TestFilterCatalog(t *testing.T) {
+	coffee, session, snack := catalogReward("Coffee", 100, ""), catalogReward("Session", 500, ""), catalogReward("Snack", 80, "")
+	coffee.Category = sql.NullString{String: "food", Valid: true}
+	snack.Category = sql.NullString{String: "food", Valid: true}
+	rewards := []db.RewardsCatalog{coffee, session, snack}
+
+	assert.Equal(t, []db.RewardsCatalog{coffee, snack}, filterCatalog(rewards, catalog.Filter{Category: "food"}))
+	assert.Equal(t, rewards, filterCatalog(rewards, catalog.Filter{}))
+	assert.Empty(t, filterCatalog(rewards, catalog.Filter{Tag: "weekend"}))
+	// The catalog it filters is left as it was
+	assert.Equal(t, "Session", rewards[1].Name)
+}
+
+func TestPersonalizeCatalog_TierExclusive(t *testing.T) {
+	lounge := catalogReward("Lounge", 100, "")
+	lounge.MinTier = sql.NullString{String: "gold", Valid: true}
+	rewards := []db.RewardsCatalog{catalogReward("Coffee", 100, ""), lounge}
+
+	tests := []struct {
+		tier string
+		want int
+	}{
+		{"", 1},
+		{"silver", 1},
+		{"gold", 2},
+		{"platinum", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tier, func(t *testing.T) {
+			user := &segments.User{Balance: 300, Tier: tt.tier}
+			catalog, err := personalizeCatalog(context.Background(), &fakeSegmentQuerier{}, rewards, nil, user, false, time.Now())
+			require.NoError(t, err)
+			assert.Len(t, catalog, tt.want)
+		})
+	}
+
+	user := &segments.User{Balance: 300, Tier: "gold"}
+	catalog, err := personalizeCatalog(context.Background(), &fakeSegmentQuerier{}, rewards, nil, user, false, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, "gold", catalog[1].MinTier)
+}
+
+func TestPersonalizeCatalog_Stock(t *testing.T) {
+	r := catalogReward("Coupon", 100, "")
+	r.StockTotal = sql.NullInt32{Int32: 100, Valid: true}
+	r.StockRedeemed = 40
+	r.DailyStock = sql.NullInt32{Int32: 10, Valid: true}
+	redeemedToday := map[uuid.UUID]int32{r.ID: 7}
+
+	catalog, err := personalizeCatalog(context.Background(), &fakeSegmentQuerier{}, []db.RewardsCatalog{r, catalogReward("Coffee", 50, "")},
+		redeemedToday, &segments.User{Balance: 300}, false, time.Now())
+	require.NoError(t, err)
+	require.Len(t, catalog, 2)
+	assert.Equal(t, int32(60), *catalog[0].StockRemaining)
+	assert.Equal(t, int32(3), *catalog[0].DailyStockRemaining)
+	assert.Nil(t, catalog[1].StockRemaining)
+	assert.Nil(t, catalog[1].DailyStockRemaining)
+}
+
+func TestIsEarlyAccess(t *testing.T) {
+	assert.True(t, isEarlyAccess(json.RawMessage(`{"type": "early-access"}`)))
+	assert.False(t, isEarlyAccess(json.RawMessage(`{"name": "power-chargers"}`)))
+	assert.False(t, isEarlyAccess(nil))
+}
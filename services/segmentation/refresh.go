@@ -0,0 +1,49 @@
+package segmentation
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"encore.app/internal/segments"
+	"github.com/google/uuid"
+)
+
+// RefreshSegmentsResponse reports the outcome of a full materialization run
+type RefreshSegmentsResponse struct {
+	Segments int   `json:"segments"`
+	Members  int64 `json:"members"`
+	Failed   int   `json:"failed"`
+}
+
+// refreshSegments recomputes the members of every active materialized segment.
+// A segment that fails to refresh is logged and skipped.
+func refreshSegments(ctx context.Context, q segments.MemberQuerier, now time.Time) (*RefreshSegmentsResponse, error) {
+	materialized, err := q.ListMaterializedSegments(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list materialized segments: %w", err)
+	}
+
+	resp := &RefreshSegmentsResponse{}
+	for _, segment := range materialized {
+		members, err := segments.Refresh(ctx, q, segment, now)
+		if err != nil {
+			log.Printf("segmentation: failed to refresh segment %s: %v", segment.Name, err)
+			resp.Failed++
+			continue
+		}
+		resp.Segments++
+		resp.Members += members
+	}
+	return resp, nil
+}
+
+// refreshUser updates a user's materialized memberships after their points change
+func refreshUser(ctx context.Context, q segments.MemberQuerier, userID string, now time.Time) error {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return fmt.Errorf("invalid user ID: %w", err)
+	}
+	return segments.RefreshUser(ctx, q, id, now)
+}
@@ -0,0 +1,58 @@
+//go:build encore
+// +build encore
+
+package segmentation
+
+import (
+	"context"
+	"time"
+
+	"encore.app/internal/db"
+	"encore.app/services/accrual"
+	"encore.dev/cron"
+	"encore.dev/pubsub"
+	"encore.dev/storage/sqldb"
+)
+
+//encore:service
+type Service struct {
+	// Service will be initialized by Encore
+}
+
+// rewardsDB is the shared rewards database
+var rewardsDB = sqldb.Named("rewards")
+
+// init initializes the segmentation service
+func init() {
+	// Service will be initialized by Encore
+}
+
+// RefreshSegments recomputes the members of every materialized segment
+//
+//encore:api private method=POST path=/internal/segments/refresh
+func RefreshSegments(ctx context.Context) (*RefreshSegmentsResponse, error) {
+	return refreshSegments(ctx, db.New(rewardsDB.Stdlib()), time.Now())
+}
+
+// HandleUserPointsUpdated keeps the user's materialized memberships current
+//
+//encore:api private
+func HandleUserPointsUpdated(ctx context.Context, event *accrual.UserPointsUpdated) error {
+	return refreshUser(ctx, db.New(rewardsDB.Stdlib()), event.UserID, time.Now())
+}
+
+// Refresh materialized segments every hour
+var _ = cron.NewJob("refresh-segments", cron.JobConfig{
+	Title:    "Refresh materialized segment members",
+	Every:    1 * cron.Hour,
+	Endpoint: RefreshSegments,
+})
+
+// Subscribe to UserPointsUpdated events
+var _ = pubsub.NewSubscription(
+	accrual.UserPointsUpdatedTopic,
+	"segmentation-user-points-updated",
+	pubsub.SubscriptionConfig[*accrual.UserPointsUpdated]{
+		Handler: HandleUserPointsUpdated,
+	},
+)
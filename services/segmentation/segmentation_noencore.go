@@ -0,0 +1,14 @@
+//go:build !encore
+// +build !encore
+
+package segmentation
+
+//encore:service
+type Service struct {
+	// Service will be initialized by Encore
+}
+
+// init initializes the segmentation service
+func init() {
+	// Service will be initialized by Encore
+}
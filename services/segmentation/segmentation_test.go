@@ -0,0 +1,96 @@
+//go:build !encore
+// +build !encore
+
+package segmentation
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"encore.app/internal/db"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeQueries holds a single user and records membership changes
+type fakeQueries struct {
+	user         db.GetUserSegmentFactsRow
+	materialized []db.Segment
+	members      map[uuid.UUID]bool
+}
+
+func (f *fakeQueries) GetUserSegmentFacts(ctx context.Context, id uuid.UUID) (db.GetUserSegmentFactsRow, error) {
+	if id != f.user.ID {
+		return db.GetUserSegmentFactsRow{}, sql.ErrNoRows
+	}
+	return f.user, nil
+}
+
+func (f *fakeQueries) GetSegmentByName(ctx context.Context, name string) (db.Segment, error) {
+	return db.Segment{}, sql.ErrNoRows
+}
+
+func (f *fakeQueries) ListUserSegmentFacts(ctx context.Context, arg db.ListUserSegmentFactsParams) ([]db.ListUserSegmentFactsRow, error) {
+	if arg.AfterID != uuid.Nil {
+		return nil, nil
+	}
+	return []db.ListUserSegmentFactsRow{db.ListUserSegmentFactsRow(f.user)}, nil
+}
+
+func (f *fakeQueries) ListMaterializedSegments(ctx context.Context) ([]db.Segment, error) {
+	return f.materialized, nil
+}
+
+func (f *fakeQueries) UpsertSegmentMember(ctx context.Context, arg db.UpsertSegmentMemberParams) error {
+	f.members[arg.SegmentID] = true
+	return nil
+}
+
+func (f *fakeQueries) DeleteSegmentMember(ctx context.Context, arg db.DeleteSegmentMemberParams) error {
+	delete(f.members, arg.SegmentID)
+	return nil
+}
+
+func (f *fakeQueries) DeleteStaleSegmentMembers(ctx context.Context, arg db.DeleteStaleSegmentMembersParams) (int64, error) {
+	return 0, nil
+}
+
+func (f *fakeQueries) SetSegmentMaterializedAt(ctx context.Context, arg db.SetSegmentMaterializedAtParams) error {
+	return nil
+}
+
+func newFakeQueries() *fakeQueries {
+	return &fakeQueries{
+		user: db.GetUserSegmentFactsRow{ID: uuid.New(), CreatedAt: time.Now(), Balance: 900, LifetimeKwh: 650},
+		materialized: []db.Segment{
+			{ID: uuid.New(), Name: "heavy-chargers", Criteria: json.RawMessage(`{"attr": "lifetime_kwh", "op": "gte", "value": 500}`)},
+			{ID: uuid.New(), Name: "big-spenders", Criteria: json.RawMessage(`{"attr": "balance", "op": "gte", "value": 5000}`)},
+			{ID: uuid.New(), Name: "broken", Criteria: json.RawMessage(`{"attr": "shoe_size", "op": "eq", "value": 9}`)},
+		},
+		members: map[uuid.UUID]bool{},
+	}
+}
+
+func TestRefreshSegments(t *testing.T) {
+	q := newFakeQueries()
+
+	resp, err := refreshSegments(context.Background(), q, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, &RefreshSegmentsResponse{Segments: 2, Members: 1, Failed: 1}, resp)
+	assert.True(t, q.members[q.materialized[0].ID])
+	assert.False(t, q.members[q.materialized[1].ID])
+}
+
+func TestRefreshUser(t *testing.T) {
+	q := newFakeQueries()
+	q.materialized = q.materialized[:2]
+
+	require.NoError(t, refreshUser(context.Background(), q, q.user.ID.String(), time.Now()))
+	assert.Equal(t, map[uuid.UUID]bool{q.materialized[0].ID: true}, q.members)
+
+	assert.Error(t, refreshUser(context.Background(), q, "not-a-uuid", time.Now()))
+}
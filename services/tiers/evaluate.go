@@ -0,0 +1,201 @@
+package tiers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"encore.app/internal/db"
+	"encore.app/internal/outbox"
+	"encore.app/internal/rules"
+	"encore.app/internal/tiers"
+	"github.com/google/uuid"
+)
+
+// tierOutboxTopic is the outbox topic of TierChanged events
+const tierOutboxTopic = "tier-changed"
+
+// userBatchSize is how many users are evaluated per page
+const userBatchSize = 500
+
+// TierChanged is published when a user moves to another loyalty tier. A new
+// user's first assignment to the base tier is not announced.
+type TierChanged struct {
+	UserID string `json:"user_id"`
+	// From is empty when the user had no tier before
+	From string `json:"from,omitempty"`
+	// To is empty when the user dropped below every tier
+	To string `json:"to"`
+	// Direction is "upgrade" or "downgrade"
+	Direction     string    `json:"direction"`
+	RollingKWH    float64   `json:"rolling_kwh"`
+	RollingPoints int64     `json:"rolling_points"`
+	ChangedAt     time.Time `json:"changed_at"`
+}
+
+// EvaluateTiersResponse reports the outcome of a full re-evaluation
+type EvaluateTiersResponse struct {
+	Evaluated  int `json:"evaluated"`
+	Upgraded   int `json:"upgraded"`
+	Downgraded int `json:"downgraded"`
+	// GracePeriods counts users who no longer qualify but keep their tier for now
+	GracePeriods int `json:"grace_periods"`
+	Failed       int `json:"failed"`
+}
+
+// tierStore is the subset of *db.Queries used to evaluate tiers
+type tierStore interface {
+	outbox.Enqueuer
+	GetUserTier(ctx context.Context, userID uuid.UUID) (db.UserTier, error)
+	GetUserTierStats(ctx context.Context, arg db.GetUserTierStatsParams) (db.GetUserTierStatsRow, error)
+	ListUserTierStats(ctx context.Context, arg db.ListUserTierStatsParams) ([]db.ListUserTierStatsRow, error)
+	UpsertUserTier(ctx context.Context, arg db.UpsertUserTierParams) (db.UserTier, error)
+	DeleteUserTier(ctx context.Context, userID uuid.UUID) error
+}
+
+// txFunc runs fn inside a database transaction
+type txFunc func(ctx context.Context, fn func(q tierStore) error) error
+
+// dbTx returns a txFunc running transactions on conn
+func dbTx(conn *sql.DB) txFunc {
+	return func(ctx context.Context, fn func(q tierStore) error) error {
+		return db.WithTx(ctx, conn, func(q *db.Queries) error {
+			return fn(q)
+		})
+	}
+}
+
+// evaluator applies the tier rules to stored ledger stats
+type evaluator struct {
+	cfg  rules.TierConfig
+	q    tierStore
+	tx   txFunc
+	now  time.Time
+	resp EvaluateTiersResponse
+}
+
+// evaluateAll re-evaluates every user's tier. A user that fails is logged and
+// skipped.
+func evaluateAll(ctx context.Context, cfg rules.TierConfig, q tierStore, tx txFunc, now time.Time) (*EvaluateTiersResponse, error) {
+	e := &evaluator{cfg: cfg, q: q, tx: tx, now: now}
+	since := tiers.WindowStart(cfg, now)
+	after := uuid.Nil
+	for {
+		rows, err := q.ListUserTierStats(ctx, db.ListUserTierStatsParams{
+			Since:     since,
+			AfterID:   after,
+			BatchSize: userBatchSize,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list users: %w", err)
+		}
+		for _, row := range rows {
+			if _, err := e.apply(ctx, row.ID, row.Tier.String, row.DowngradeAt, row.Kwh, row.EarnedPoints); err != nil {
+				log.Printf("tiers: failed to evaluate user %s: %v", row.ID, err)
+				e.resp.Failed++
+			}
+		}
+		if len(rows) < userBatchSize {
+			return &e.resp, nil
+		}
+		after = rows[len(rows)-1].ID
+	}
+}
+
+// evaluateUser re-evaluates a single user's tier, e.g. after they earn points
+func evaluateUser(ctx context.Context, cfg rules.TierConfig, q tierStore, tx txFunc, userID string, now time.Time) (tiers.Decision, error) {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return tiers.Decision{}, fmt.Errorf("invalid user ID: %w", err)
+	}
+
+	current, err := q.GetUserTier(ctx, id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return tiers.Decision{}, err
+	}
+	stats, err := q.GetUserTierStats(ctx, db.GetUserTierStatsParams{
+		UserID: id,
+		Since:  tiers.WindowStart(cfg, now),
+	})
+	if err != nil {
+		return tiers.Decision{}, err
+	}
+
+	e := &evaluator{cfg: cfg, q: q, tx: tx, now: now}
+	return e.apply(ctx, id, current.Tier, current.DowngradeAt, stats.Kwh, stats.EarnedPoints)
+}
+
+// apply decides a user's tier and stores it, recording a TierChanged event in
+// the same transaction when the tier moves. Without a base tier, a user below
+// every threshold has no tier: a stored tier is removed once its grace period
+// is over.
+func (e *evaluator) apply(ctx context.Context, userID uuid.UUID, current string, downgradeAt sql.NullTime, kwh float64, points int64) (tiers.Decision, error) {
+	qualified := tiers.Qualify(e.cfg, kwh, points)
+	d := tiers.Decide(e.cfg, current, downgradeAt, qualified, e.now)
+	if d.Tier == "" && d.From == "" {
+		// Below every threshold with nothing stored; there is nothing to record
+		e.resp.Evaluated++
+		return d, nil
+	}
+
+	// A new user's first assignment to the base tier is not a change
+	announce := d.Changed() && !(d.From == "" && tiers.Rank(d.Tier) == 0)
+	err := e.tx(ctx, func(q tierStore) error {
+		if d.Tier == "" {
+			if err := q.DeleteUserTier(ctx, userID); err != nil {
+				return err
+			}
+		} else if _, err := q.UpsertUserTier(ctx, db.UpsertUserTierParams{
+			UserID:        userID,
+			Tier:          d.Tier,
+			RollingKwh:    kwh,
+			RollingPoints: points,
+			DowngradeAt:   d.DowngradeAt,
+			EvaluatedAt:   e.now,
+		}); err != nil {
+			return err
+		}
+		if !announce {
+			return nil
+		}
+		direction := "downgrade"
+		if d.Upgrade() {
+			direction = "upgrade"
+		}
+		return outbox.Enqueue(ctx, q, tierOutboxTopic, userID.String(), &TierChanged{
+			UserID:        userID.String(),
+			From:          d.From,
+			To:            d.Tier,
+			Direction:     direction,
+			RollingKWH:    kwh,
+			RollingPoints: points,
+			ChangedAt:     e.now,
+		})
+	})
+	if err != nil {
+		return d, err
+	}
+
+	e.resp.Evaluated++
+	switch {
+	case announce && d.Upgrade():
+		e.resp.Upgraded++
+	case announce:
+		e.resp.Downgraded++
+	case d.DowngradeAt.Valid:
+		e.resp.GracePeriods++
+	}
+	return d, nil
+}
+
+// loadTierConfig reads the tier configuration from the rules file
+func loadTierConfig(path string) (rules.TierConfig, error) {
+	engine, err := rules.NewEngine(path)
+	if err != nil {
+		return rules.TierConfig{}, err
+	}
+	return engine.GetConfig().Tiers, nil
+}
@@ -0,0 +1,16 @@
+package tiers
+
+import (
+	"database/sql"
+
+	"encore.app/internal/outbox"
+)
+
+// newTierRelay creates the relay publishing outboxed TierChanged events to
+// TierChangedTopic
+func newTierRelay(conn *sql.DB, metrics outbox.Metrics) *outbox.Relay {
+	return outbox.NewRelay(outbox.Config{
+		Topic:   tierOutboxTopic,
+		Publish: outbox.JSONPublisher[TierChanged](TierChangedTopic),
+	}, outbox.DBTx(conn), metrics)
+}
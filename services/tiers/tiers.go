@@ -0,0 +1,91 @@
+//go:build encore
+// +build encore
+
+package tiers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"encore.app/internal/db"
+	"encore.app/internal/outbox"
+	"encore.app/services/accrual"
+	"encore.dev/cron"
+	"encore.dev/pubsub"
+	"encore.dev/storage/sqldb"
+)
+
+//encore:service
+type Service struct {
+	// Service will be initialized by Encore
+}
+
+// TierChangedTopic is the pub/sub topic for loyalty tier changes
+var TierChangedTopic = pubsub.NewTopic[*TierChanged]("tier-changed", pubsub.TopicConfig{
+	DeliveryGuarantee: pubsub.AtLeastOnce,
+})
+
+// rewardsDB is the shared rewards database
+var rewardsDB = sqldb.Named("rewards")
+
+// relayOnce starts the TierChanged outbox relay on first use
+var relayOnce sync.Once
+
+// init initializes the tiers service
+func init() {
+	// Service will be initialized by Encore
+}
+
+// queries returns the database queries and transaction runner, starting the
+// outbox relay if it is not running yet
+func queries() (*db.Queries, txFunc) {
+	conn := rewardsDB.Stdlib()
+	relayOnce.Do(func() {
+		go newTierRelay(conn, outbox.DefaultMetrics).Run(context.Background())
+	})
+	return db.New(conn), dbTx(conn)
+}
+
+// EvaluateTiers re-evaluates every user's loyalty tier from the rolling ledger
+//
+//encore:api private method=POST path=/internal/tiers/evaluate
+func EvaluateTiers(ctx context.Context) (*EvaluateTiersResponse, error) {
+	cfg, err := loadTierConfig("rules.yaml")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tier config: %w", err)
+	}
+	q, tx := queries()
+	return evaluateAll(ctx, cfg, q, tx, time.Now())
+}
+
+// HandleUserPointsUpdated upgrades a user as soon as they qualify
+//
+//encore:api private
+func HandleUserPointsUpdated(ctx context.Context, event *accrual.UserPointsUpdated) error {
+	cfg, err := loadTierConfig("rules.yaml")
+	if err != nil {
+		return fmt.Errorf("failed to load tier config: %w", err)
+	}
+	q, tx := queries()
+	_, err = evaluateUser(ctx, cfg, q, tx, event.UserID, time.Now())
+	return err
+}
+
+// Re-evaluate tiers nightly so grace periods start and end for users who have
+// stopped earning
+var _ = cron.NewJob("evaluate-tiers", cron.JobConfig{
+	Title:    "Re-evaluate loyalty tiers",
+	Schedule: "0 2 * * *",
+	Endpoint: EvaluateTiers,
+})
+
+// Subscribe to UserPointsUpdated events
+var _ = pubsub.NewSubscription(
+	accrual.UserPointsUpdatedTopic,
+	"tiers-user-points-updated",
+	pubsub.SubscriptionConfig[*accrual.UserPointsUpdated]{
+		Handler: HandleUserPointsUpdated,
+	},
+)
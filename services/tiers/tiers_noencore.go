@@ -0,0 +1,29 @@
+//go:build !encore
+// +build !encore
+
+package tiers
+
+import (
+	"context"
+)
+
+//encore:service
+type Service struct {
+	// Service will be initialized by Encore
+}
+
+// TierChangedTopic is a mock topic for non-Encore builds
+var TierChangedTopic = &MockTopic{}
+
+// MockTopic is a mock implementation for testing
+type MockTopic struct{}
+
+func (m *MockTopic) Publish(ctx context.Context, msg *TierChanged) (string, error) {
+	// Mock implementation - does nothing
+	return "mock-message-id", nil
+}
+
+// init initializes the tiers service
+func init() {
+	// Service will be initialized by Encore
+}
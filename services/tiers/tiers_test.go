@@ -0,0 +1,254 @@
+//go:build !encore
+// +build !encore
+
+package tiers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"encore.app/internal/credits"
+	"encore.app/internal/db"
+	"encore.app/internal/fulfillment"
+	"encore.app/internal/rules"
+	"encore.app/internal/tiers"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testNow = time.Date(2025, 3, 1, 2, 0, 0, 0, time.UTC)
+
+var testConfig = rules.TierConfig{
+	WindowDays:         365,
+	DowngradeGraceDays: 30,
+	Levels: map[string]rules.TierLevel{
+		tiers.Silver:   {},
+		tiers.Gold:     {MinKWH: 500, MinPoints: 5000},
+		tiers.Platinum: {MinKWH: 2000, MinPoints: 20000},
+	},
+}
+
+type userStats struct {
+	kwh    float64
+	points int64
+}
+
+type fakeStore struct {
+	stats  map[uuid.UUID]userStats
+	tiers  map[uuid.UUID]db.UserTier
+	events []TierChanged
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{stats: map[uuid.UUID]userStats{}, tiers: map[uuid.UUID]db.UserTier{}}
+}
+
+func (f *fakeStore) tx(ctx context.Context, fn func(q tierStore) error) error {
+	return fn(f)
+}
+
+func (f *fakeStore) EnqueueOutboxEvent(ctx context.Context, arg db.EnqueueOutboxEventParams) (db.EventOutbox, error) {
+	var e TierChanged
+	if err := json.Unmarshal(arg.Payload, &e); err != nil {
+		return db.EventOutbox{}, err
+	}
+	f.events = append(f.events, e)
+	return db.EventOutbox{Topic: arg.Topic}, nil
+}
+
+func (f *fakeStore) GetUserTier(ctx context.Context, userID uuid.UUID) (db.UserTier, error) {
+	t, ok := f.tiers[userID]
+	if !ok {
+		return t, sql.ErrNoRows
+	}
+	return t, nil
+}
+
+func (f *fakeStore) GetUserTierStats(ctx context.Context, arg db.GetUserTierStatsParams) (db.GetUserTierStatsRow, error) {
+	s := f.stats[arg.UserID]
+	return db.GetUserTierStatsRow{EarnedPoints: s.points, Kwh: s.kwh}, nil
+}
+
+func (f *fakeStore) ListUserTierStats(ctx context.Context, arg db.ListUserTierStatsParams) ([]db.ListUserTierStatsRow, error) {
+	var rows []db.ListUserTierStatsRow
+	for id, s := range f.stats {
+		if id.String() <= arg.AfterID.String() {
+			continue
+		}
+		row := db.ListUserTierStatsRow{ID: id, EarnedPoints: s.points, Kwh: s.kwh}
+		if t, ok := f.tiers[id]; ok {
+			row.Tier = sql.NullString{String: t.Tier, Valid: true}
+			row.DowngradeAt = t.DowngradeAt
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].ID.String() < rows[j].ID.String() })
+	if len(rows) > int(arg.BatchSize) {
+		rows = rows[:arg.BatchSize]
+	}
+	return rows, nil
+}
+
+func (f *fakeStore) UpsertUserTier(ctx context.Context, arg db.UpsertUserTierParams) (db.UserTier, error) {
+	t := db.UserTier{
+		UserID:        arg.UserID,
+		Tier:          arg.Tier,
+		RollingKwh:    arg.RollingKwh,
+		RollingPoints: arg.RollingPoints,
+		DowngradeAt:   arg.DowngradeAt,
+		EvaluatedAt:   arg.EvaluatedAt,
+	}
+	f.tiers[arg.UserID] = t
+	return t, nil
+}
+
+func (f *fakeStore) DeleteUserTier(ctx context.Context, userID uuid.UUID) error {
+	delete(f.tiers, userID)
+	return nil
+}
+
+func (f *fakeStore) addUser(tier string, kwh float64, points int64) uuid.UUID {
+	id := uuid.New()
+	f.stats[id] = userStats{kwh: kwh, points: points}
+	if tier != "" {
+		f.tiers[id] = db.UserTier{UserID: id, Tier: tier}
+	}
+	return id
+}
+
+func TestEvaluateAll(t *testing.T) {
+	f := newFakeStore()
+	newUser := f.addUser("", 10, 100)
+	rising := f.addUser(tiers.Silver, 600, 0)
+	lapsing := f.addUser(tiers.Platinum, 100, 1000)
+	for i := 0; i < userBatchSize; i++ {
+		f.addUser(tiers.Silver, 0, 0)
+	}
+
+	resp, err := evaluateAll(context.Background(), testConfig, f, f.tx, testNow)
+	require.NoError(t, err)
+	assert.Equal(t, &EvaluateTiersResponse{Evaluated: userBatchSize + 3, Upgraded: 1, GracePeriods: 1}, resp)
+
+	assert.Equal(t, tiers.Silver, f.tiers[newUser].Tier)
+	assert.Equal(t, tiers.Gold, f.tiers[rising].Tier)
+	assert.Equal(t, 600.0, f.tiers[rising].RollingKwh)
+
+	// The lapsing user keeps platinum through the grace period
+	assert.Equal(t, tiers.Platinum, f.tiers[lapsing].Tier)
+	assert.Equal(t, testNow.AddDate(0, 0, 30), f.tiers[lapsing].DowngradeAt.Time)
+
+	require.Len(t, f.events, 1, "only the upgrade is announced")
+	assert.Equal(t, TierChanged{
+		UserID:     rising.String(),
+		From:       tiers.Silver,
+		To:         tiers.Gold,
+		Direction:  "upgrade",
+		RollingKWH: 600,
+		ChangedAt:  testNow,
+	}, f.events[0])
+
+	// After the grace period the user drops to the tier they qualify for
+	later := testNow.AddDate(0, 0, 31)
+	resp, err = evaluateAll(context.Background(), testConfig, f, f.tx, later)
+	require.NoError(t, err)
+	assert.Equal(t, 1, resp.Downgraded)
+	assert.Equal(t, tiers.Silver, f.tiers[lapsing].Tier)
+	assert.False(t, f.tiers[lapsing].DowngradeAt.Valid)
+	require.Len(t, f.events, 2)
+	assert.Equal(t, "downgrade", f.events[1].Direction)
+	assert.Equal(t, tiers.Platinum, f.events[1].From)
+}
+
+func TestEvaluateAll_NoBaseTier(t *testing.T) {
+	cfg := rules.TierConfig{
+		WindowDays:         365,
+		DowngradeGraceDays: 30,
+		Levels:             map[string]rules.TierLevel{tiers.Gold: {MinKWH: 500, MinPoints: 5000}},
+	}
+	f := newFakeStore()
+	lapsing := f.addUser(tiers.Gold, 10, 100)
+
+	// Below every threshold the user keeps gold through the grace period
+	resp, err := evaluateAll(context.Background(), cfg, f, f.tx, testNow)
+	require.NoError(t, err)
+	assert.Equal(t, 1, resp.GracePeriods)
+	assert.Equal(t, tiers.Gold, f.tiers[lapsing].Tier)
+
+	// and then loses their tier, and its multiplier, altogether
+	resp, err = evaluateAll(context.Background(), cfg, f, f.tx, testNow.AddDate(0, 0, 31))
+	require.NoError(t, err)
+	assert.Equal(t, 1, resp.Downgraded)
+	assert.NotContains(t, f.tiers, lapsing)
+	require.Len(t, f.events, 1)
+	assert.Equal(t, TierChanged{
+		UserID:        lapsing.String(),
+		From:          tiers.Gold,
+		Direction:     "downgrade",
+		RollingKWH:    10,
+		RollingPoints: 100,
+		ChangedAt:     testNow.AddDate(0, 0, 31),
+	}, f.events[0])
+
+	// Nothing is recorded for a user with no tier to lose
+	resp, err = evaluateAll(context.Background(), cfg, f, f.tx, testNow.AddDate(0, 0, 32))
+	require.NoError(t, err)
+	assert.Equal(t, &EvaluateTiersResponse{Evaluated: 1}, resp)
+	assert.Len(t, f.events, 1)
+}
+
+func TestEvaluateUser(t *testing.T) {
+	f := newFakeStore()
+	id := f.addUser("", 0, 0)
+
+	// A new user's base tier is assigned silently
+	d, err := evaluateUser(context.Background(), testConfig, f, f.tx, id.String(), testNow)
+	require.NoError(t, err)
+	assert.Equal(t, tiers.Silver, d.Tier)
+	assert.Empty(t, f.events)
+
+	// Earning past a threshold upgrades straight away
+	f.stats[id] = userStats{kwh: 2100}
+	d, err = evaluateUser(context.Background(), testConfig, f, f.tx, id.String(), testNow)
+	require.NoError(t, err)
+	assert.Equal(t, tiers.Platinum, d.Tier)
+	require.Len(t, f.events, 1)
+	assert.Equal(t, tiers.Silver, f.events[0].From)
+
+	_, err = evaluateUser(context.Background(), testConfig, f, f.tx, "not-a-uuid", testNow)
+	assert.Error(t, err)
+}
+
+func TestLoadTierConfig(t *testing.T) {
+	cfg, err := loadTierConfig("../../rules.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, 30, cfg.DowngradeGraceDays)
+	for _, name := range tiers.Names {
+		assert.Contains(t, cfg.Levels, name)
+	}
+}
+
+// TestTierStatsExcludeRefunds checks the tier stats queries leave refunds out
+// of earned points: they give back spent points, so redeeming and cancelling
+// repeatedly must not climb tiers
+func TestTierStatsExcludeRefunds(t *testing.T) {
+	data, err := os.ReadFile("../../db/query.sql")
+	require.NoError(t, err)
+	for _, name := range []string{"GetUserTierStats", "ListUserTierStats"} {
+		_, query, ok := strings.Cut(string(data), "-- name: "+name+" ")
+		require.True(t, ok, name)
+		query, _, _ = strings.Cut(query, "-- name: ")
+		earned, _, ok := strings.Cut(query, "AS earned_points")
+		require.True(t, ok, name)
+		for _, refund := range []string{fulfillment.RefundEventType, credits.RefundEventType} {
+			assert.Contains(t, earned, "NOT IN (", name)
+			assert.Contains(t, earned, "'"+refund+"'", name)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"encore.app/internal/auth"
+	"encore.app/internal/db"
+	"encore.app/internal/otp"
+	"encore.dev/beta/errs"
+)
+
+// RequestOTPRequest asks for a login code to be sent by SMS
+type RequestOTPRequest struct {
+	// Phone is the number in E.164 format, e.g. +919876543210
+	Phone string `json:"phone"`
+}
+
+// RequestOTPResponse describes the code that was sent
+type RequestOTPResponse struct {
+	Phone              string `json:"phone"`
+	ExpiresInSeconds   int    `json:"expires_in_seconds"`
+	ResendAfterSeconds int    `json:"resend_after_seconds"`
+}
+
+// VerifyOTPRequest exchanges a login code for a user token
+type VerifyOTPRequest struct {
+	Phone string `json:"phone"`
+	Code  string `json:"code"`
+}
+
+// VerifyOTPResponse carries the user token accepted by the rewards APIs
+type VerifyOTPResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	UserID    string    `json:"user_id"`
+	// NewUser is set when the phone number was registered by this login
+	NewUser bool `json:"new_user"`
+}
+
+// errLoginUnavailable is returned when no SMS sender or token signer is
+// configured
+var errLoginUnavailable = &errs.Error{Code: errs.Unavailable, Message: "user login is not configured"}
+
+// userStore is the subset of *db.Queries used to register users on login
+type userStore interface {
+	GetUserByPhone(ctx context.Context, phone string) (db.User, error)
+	CreateUser(ctx context.Context, phone string) (db.User, error)
+}
+
+// RequestOTP sends a login code to a phone number
+//
+//encore:api public method=POST path=/v1/auth/otp
+func (s *Service) RequestOTP(ctx context.Context, req *RequestOTPRequest) (*RequestOTPResponse, error) {
+	if s.otp == nil {
+		return nil, errLoginUnavailable
+	}
+	challenge, err := s.otp.Request(ctx, req.Phone)
+	if err != nil {
+		return nil, otpError(err)
+	}
+	now := time.Now()
+	return &RequestOTPResponse{
+		Phone:              challenge.Phone,
+		ExpiresInSeconds:   int(challenge.ExpiresAt.Sub(now).Round(time.Second).Seconds()),
+		ResendAfterSeconds: int(challenge.ResendAfter.Sub(now).Round(time.Second).Seconds()),
+	}, nil
+}
+
+// VerifyOTP checks a login code, registering the phone number on first login,
+// and issues a user token
+//
+//encore:api public method=POST path=/v1/auth/verify
+func (s *Service) VerifyOTP(ctx context.Context, req *VerifyOTPRequest) (*VerifyOTPResponse, error) {
+	if s.otp == nil || s.signer == nil {
+		return nil, errLoginUnavailable
+	}
+	phone, err := s.otp.Verify(ctx, req.Phone, req.Code)
+	if err != nil {
+		return nil, otpError(err)
+	}
+	return login(ctx, s.db, s.signer, phone)
+}
+
+// login finds or registers the user with the given phone number and issues their token
+func login(ctx context.Context, store userStore, signer *auth.Signer, phone string) (*VerifyOTPResponse, error) {
+	user, err := store.GetUserByPhone(ctx, phone)
+	newUser := false
+	if errors.Is(err, sql.ErrNoRows) {
+		user, err = store.CreateUser(ctx, phone)
+		newUser = err == nil
+		if err != nil {
+			// A concurrent login may have registered the number first
+			user, err = store.GetUserByPhone(ctx, phone)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+
+	token, expires, err := signer.IssueUserToken(user.ID.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue token: %w", err)
+	}
+	return &VerifyOTPResponse{
+		Token:     token,
+		ExpiresAt: expires,
+		UserID:    user.ID.String(),
+		NewUser:   newUser,
+	}, nil
+}
+
+// otpError maps OTP failures to API errors
+func otpError(err error) error {
+	var rl *otp.RateLimitError
+	switch {
+	case errors.As(err, &rl):
+		return &errs.Error{
+			Code:    errs.ResourceExhausted,
+			Message: fmt.Sprintf("too many code requests, retry in %d seconds", int(rl.RetryAfter.Round(time.Second).Seconds())),
+		}
+	case errors.Is(err, otp.ErrInvalidPhone):
+		return &errs.Error{Code: errs.InvalidArgument, Message: err.Error()}
+	case errors.Is(err, otp.ErrInvalidCode), errors.Is(err, otp.ErrCodeExpired):
+		return &errs.Error{Code: errs.Unauthenticated, Message: err.Error()}
+	case errors.Is(err, otp.ErrTooManyAttempts):
+		return &errs.Error{Code: errs.ResourceExhausted, Message: "too many attempts, request a new code"}
+	case errors.Is(err, otp.ErrSenderUnavailable):
+		return &errs.Error{Code: errs.Unavailable, Message: err.Error()}
+	}
+	return err
+}
@@ -0,0 +1,119 @@
+//go:build !encore
+// +build !encore
+
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"encore.app/internal/auth"
+	"encore.app/internal/db"
+	"encore.app/internal/otp"
+	"encore.dev/beta/errs"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeUserStore struct {
+	users map[string]db.User
+	// raced simulates a concurrent login registering the number first
+	raced bool
+}
+
+func (f *fakeUserStore) GetUserByPhone(ctx context.Context, phone string) (db.User, error) {
+	u, ok := f.users[phone]
+	if !ok {
+		return u, sql.ErrNoRows
+	}
+	return u, nil
+}
+
+func (f *fakeUserStore) CreateUser(ctx context.Context, phone string) (db.User, error) {
+	u := db.User{ID: uuid.New(), Phone: phone}
+	f.users[phone] = u
+	if f.raced {
+		return db.User{}, errors.New("duplicate key value violates unique constraint")
+	}
+	return u, nil
+}
+
+func testSigner(t *testing.T) (*auth.Signer, *auth.Verifier) {
+	cfg := auth.Config{HMACSecret: []byte("user-secret"), TokenTTL: time.Hour}
+	signer, err := auth.NewSigner(cfg)
+	require.NoError(t, err)
+	verifier, err := auth.NewVerifier(cfg)
+	require.NoError(t, err)
+	return signer, verifier
+}
+
+func TestLogin(t *testing.T) {
+	ctx := context.Background()
+	signer, verifier := testSigner(t)
+	store := &fakeUserStore{users: map[string]db.User{}}
+
+	first, err := login(ctx, store, signer, "+919876543210")
+	require.NoError(t, err)
+	assert.True(t, first.NewUser)
+
+	// The token is accepted wherever user tokens are verified
+	claims, err := verifier.Verify(first.Token)
+	require.NoError(t, err)
+	assert.Equal(t, first.UserID, claims.UserIDOrSubject())
+
+	again, err := login(ctx, store, signer, "+919876543210")
+	require.NoError(t, err)
+	assert.False(t, again.NewUser)
+	assert.Equal(t, first.UserID, again.UserID)
+}
+
+func TestLogin_ConcurrentRegistration(t *testing.T) {
+	store := &fakeUserStore{users: map[string]db.User{}, raced: true}
+	signer, _ := testSigner(t)
+
+	resp, err := login(context.Background(), store, signer, "+919876543210")
+	require.NoError(t, err)
+	assert.False(t, resp.NewUser)
+	assert.Equal(t, store.users["+919876543210"].ID.String(), resp.UserID)
+}
+
+func TestOTPError(t *testing.T) {
+	tests := []struct {
+		err  error
+		code errs.ErrCode
+	}{
+		{otp.ErrInvalidPhone, errs.InvalidArgument},
+		{otp.ErrInvalidCode, errs.Unauthenticated},
+		{otp.ErrCodeExpired, errs.Unauthenticated},
+		{otp.ErrTooManyAttempts, errs.ResourceExhausted},
+		{&otp.RateLimitError{RetryAfter: 30 * time.Second}, errs.ResourceExhausted},
+		{otp.ErrSenderUnavailable, errs.Unavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code.String(), func(t *testing.T) {
+			var e *errs.Error
+			require.ErrorAs(t, otpError(tt.err), &e)
+			assert.Equal(t, tt.code, e.Code)
+		})
+	}
+
+	other := errors.New("boom")
+	assert.Equal(t, other, otpError(other))
+}
+
+func TestRequestOTP_NoSender(t *testing.T) {
+	s := &Service{}
+	var e *errs.Error
+
+	_, err := s.RequestOTP(context.Background(), &RequestOTPRequest{Phone: "+919876543210"})
+	require.ErrorAs(t, err, &e)
+	assert.Equal(t, errs.Unavailable, e.Code)
+
+	_, err = s.VerifyOTP(context.Background(), &VerifyOTPRequest{Phone: "+919876543210", Code: "123456"})
+	require.ErrorAs(t, err, &e)
+	assert.Equal(t, errs.Unavailable, e.Code)
+}
@@ -0,0 +1,231 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+	_ "time/tzdata" // timezones are validated without relying on the host's zoneinfo
+
+	"encore.app/internal/auth"
+	"encore.app/internal/db"
+	"encore.dev/beta/errs"
+	"github.com/google/uuid"
+)
+
+// Profile limits
+const (
+	maxTextLength   = 200
+	maxAttributes   = 50
+	maxAttributeKey = 64
+	maxBatteryKWH   = 1000
+)
+
+var (
+	localePattern   = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+	languagePattern = regexp.MustCompile(`^[a-z]{2,3}$`)
+	vehicleTypes    = map[string]bool{"2W": true, "3W": true, "4W": true}
+)
+
+// Profile is a user's profile. Every field is optional; attributes are used
+// for segment targeting as "attributes.<key>".
+type Profile struct {
+	UserID            string                 `json:"user_id"`
+	Name              string                 `json:"name,omitempty"`
+	Email             string                 `json:"email,omitempty"`
+	Locale            string                 `json:"locale,omitempty"`
+	Timezone          string                 `json:"timezone,omitempty"`
+	City              string                 `json:"city,omitempty"`
+	VehicleType       string                 `json:"vehicle_type,omitempty"`
+	VehicleMake       string                 `json:"vehicle_make,omitempty"`
+	VehicleModel      string                 `json:"vehicle_model,omitempty"`
+	BatteryKWH        float64                `json:"battery_kwh,omitempty"`
+	PreferredLanguage string                 `json:"preferred_language,omitempty"`
+	Attributes        map[string]interface{} `json:"attributes,omitempty"`
+	UpdatedAt         *time.Time             `json:"updated_at,omitempty"`
+}
+
+// UpdateProfileRequest replaces the authenticated user's profile; omitted
+// fields are cleared
+type UpdateProfileRequest struct {
+	Name              string                 `json:"name,omitempty"`
+	Email             string                 `json:"email,omitempty"`
+	Locale            string                 `json:"locale,omitempty"`
+	Timezone          string                 `json:"timezone,omitempty"`
+	City              string                 `json:"city,omitempty"`
+	VehicleType       string                 `json:"vehicle_type,omitempty"`
+	VehicleMake       string                 `json:"vehicle_make,omitempty"`
+	VehicleModel      string                 `json:"vehicle_model,omitempty"`
+	BatteryKWH        float64                `json:"battery_kwh,omitempty"`
+	PreferredLanguage string                 `json:"preferred_language,omitempty"`
+	Attributes        map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// GetProfile returns the authenticated user's profile
+//
+//encore:api auth method=GET path=/v1/profile
+func (s *Service) GetProfile(ctx context.Context) (*Profile, error) {
+	userID, err := currentUserID()
+	if err != nil {
+		return nil, err
+	}
+
+	profile, err := s.db.GetUserProfile(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// Users start without a profile
+			return &Profile{UserID: userID.String()}, nil
+		}
+		return nil, fmt.Errorf("failed to get profile: %w", err)
+	}
+	return profileFromDB(profile)
+}
+
+// UpdateProfile replaces the authenticated user's profile
+//
+//encore:api auth method=PUT path=/v1/profile
+func (s *Service) UpdateProfile(ctx context.Context, req *UpdateProfileRequest) (*Profile, error) {
+	userID, err := currentUserID()
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := profileParams(userID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	profile, err := s.db.UpsertUserProfile(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update profile: %w", err)
+	}
+	return profileFromDB(profile)
+}
+
+// currentUserID returns the ID of the authenticated user
+func currentUserID() (uuid.UUID, error) {
+	principal, err := auth.RequireUser(auth.CurrentPrincipal(), "")
+	if err != nil {
+		return uuid.Nil, err
+	}
+	userID, err := uuid.Parse(principal.UserID)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
+	}
+	return userID, nil
+}
+
+// profileParams validates and normalizes a profile update
+func profileParams(userID uuid.UUID, req *UpdateProfileRequest) (db.UpsertUserProfileParams, error) {
+	params := db.UpsertUserProfileParams{UserID: userID}
+	invalid := func(format string, args ...interface{}) (db.UpsertUserProfileParams, error) {
+		return params, &errs.Error{Code: errs.InvalidArgument, Message: fmt.Sprintf(format, args...)}
+	}
+
+	for field, value := range map[string]string{
+		"name":          req.Name,
+		"city":          req.City,
+		"vehicle_make":  req.VehicleMake,
+		"vehicle_model": req.VehicleModel,
+	} {
+		if len(strings.TrimSpace(value)) > maxTextLength {
+			return invalid("%s must be at most %d characters", field, maxTextLength)
+		}
+	}
+	params.Name = nullString(req.Name)
+	params.City = nullString(req.City)
+	params.VehicleMake = nullString(req.VehicleMake)
+	params.VehicleModel = nullString(req.VehicleModel)
+
+	if email := strings.TrimSpace(req.Email); email != "" {
+		addr, err := mail.ParseAddress(email)
+		if err != nil || addr.Address != email {
+			return invalid("email is not a valid address")
+		}
+		params.Email = nullString(strings.ToLower(email))
+	}
+	if locale := strings.TrimSpace(req.Locale); locale != "" {
+		if !localePattern.MatchString(locale) {
+			return invalid("locale must be a BCP 47 tag such as en-IN")
+		}
+		params.Locale = nullString(locale)
+	}
+	if tz := strings.TrimSpace(req.Timezone); tz != "" {
+		if _, err := time.LoadLocation(tz); err != nil {
+			return invalid("timezone must be an IANA zone such as Asia/Kolkata")
+		}
+		params.Timezone = nullString(tz)
+	}
+	if vt := strings.ToUpper(strings.TrimSpace(req.VehicleType)); vt != "" {
+		if !vehicleTypes[vt] {
+			return invalid("vehicle_type must be one of 2W, 3W or 4W")
+		}
+		params.VehicleType = nullString(vt)
+	}
+	if req.BatteryKWH < 0 || req.BatteryKWH > maxBatteryKWH {
+		return invalid("battery_kwh must be between 0 and %d", maxBatteryKWH)
+	}
+	if req.BatteryKWH > 0 {
+		params.BatteryKwh = sql.NullFloat64{Float64: req.BatteryKWH, Valid: true}
+	}
+	if lang := strings.ToLower(strings.TrimSpace(req.PreferredLanguage)); lang != "" {
+		if !languagePattern.MatchString(lang) {
+			return invalid("preferred_language must be an ISO 639 code such as hi")
+		}
+		params.PreferredLanguage = nullString(lang)
+	}
+
+	if len(req.Attributes) > maxAttributes {
+		return invalid("at most %d attributes are allowed", maxAttributes)
+	}
+	for key := range req.Attributes {
+		if key == "" || len(key) > maxAttributeKey {
+			return invalid("attribute keys must be 1 to %d characters", maxAttributeKey)
+		}
+	}
+	params.Attributes = json.RawMessage("{}")
+	if len(req.Attributes) > 0 {
+		attrs, err := json.Marshal(req.Attributes)
+		if err != nil {
+			return invalid("attributes must be JSON values")
+		}
+		params.Attributes = attrs
+	}
+	return params, nil
+}
+
+// profileFromDB converts a stored profile to its API representation
+func profileFromDB(p db.UserProfile) (*Profile, error) {
+	profile := &Profile{
+		UserID:            p.UserID.String(),
+		Name:              p.Name.String,
+		Email:             p.Email.String,
+		Locale:            p.Locale.String,
+		Timezone:          p.Timezone.String,
+		City:              p.City.String,
+		VehicleType:       p.VehicleType.String,
+		VehicleMake:       p.VehicleMake.String,
+		VehicleModel:      p.VehicleModel.String,
+		BatteryKWH:        p.BatteryKwh.Float64,
+		PreferredLanguage: p.PreferredLanguage.String,
+		UpdatedAt:         &p.UpdatedAt,
+	}
+	if err := json.Unmarshal(p.Attributes, &profile.Attributes); err != nil {
+		return nil, fmt.Errorf("invalid profile attributes: %w", err)
+	}
+	if len(profile.Attributes) == 0 {
+		profile.Attributes = nil
+	}
+	return profile, nil
+}
+
+// nullString trims s and maps an empty string to NULL
+func nullString(s string) sql.NullString {
+	s = strings.TrimSpace(s)
+	return sql.NullString{String: s, Valid: s != ""}
+}
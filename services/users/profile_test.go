@@ -0,0 +1,113 @@
+//go:build !encore
+// +build !encore
+
+package users
+
+import (
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"encore.app/internal/db"
+	"encore.dev/beta/errs"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfileParams(t *testing.T) {
+	userID := uuid.New()
+	params, err := profileParams(userID, &UpdateProfileRequest{
+		Name:              " Asha Rao ",
+		Email:             "Asha@Example.com",
+		Locale:            "en-IN",
+		Timezone:          "Asia/Kolkata",
+		City:              "Pune",
+		VehicleType:       "4w",
+		VehicleMake:       "Tata",
+		VehicleModel:      "Nexon EV",
+		BatteryKWH:        40.5,
+		PreferredLanguage: "MR",
+		Attributes:        map[string]interface{}{"fleet": true},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, db.UpsertUserProfileParams{
+		UserID:            userID,
+		Name:              sql.NullString{String: "Asha Rao", Valid: true},
+		Email:             sql.NullString{String: "asha@example.com", Valid: true},
+		Locale:            sql.NullString{String: "en-IN", Valid: true},
+		Timezone:          sql.NullString{String: "Asia/Kolkata", Valid: true},
+		City:              sql.NullString{String: "Pune", Valid: true},
+		VehicleType:       sql.NullString{String: "4W", Valid: true},
+		VehicleMake:       sql.NullString{String: "Tata", Valid: true},
+		VehicleModel:      sql.NullString{String: "Nexon EV", Valid: true},
+		BatteryKwh:        sql.NullFloat64{Float64: 40.5, Valid: true},
+		PreferredLanguage: sql.NullString{String: "mr", Valid: true},
+		Attributes:        json.RawMessage(`{"fleet":true}`),
+	}, params)
+}
+
+func TestProfileParams_EmptyClearsFields(t *testing.T) {
+	params, err := profileParams(uuid.New(), &UpdateProfileRequest{})
+	require.NoError(t, err)
+	assert.False(t, params.Name.Valid)
+	assert.False(t, params.Email.Valid)
+	assert.False(t, params.BatteryKwh.Valid)
+	assert.JSONEq(t, `{}`, string(params.Attributes))
+}
+
+func TestProfileParams_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		req    UpdateProfileRequest
+		errMsg string
+	}{
+		{"bad email", UpdateProfileRequest{Email: "not-an-email"}, "email"},
+		{"email with display name", UpdateProfileRequest{Email: "Asha <asha@example.com>"}, "email"},
+		{"bad locale", UpdateProfileRequest{Locale: "english"}, "locale"},
+		{"unknown timezone", UpdateProfileRequest{Timezone: "Mars/Olympus"}, "timezone"},
+		{"unknown vehicle type", UpdateProfileRequest{VehicleType: "truck"}, "vehicle_type"},
+		{"negative battery", UpdateProfileRequest{BatteryKWH: -1}, "battery_kwh"},
+		{"bad language", UpdateProfileRequest{PreferredLanguage: "hindi"}, "preferred_language"},
+		{"empty attribute key", UpdateProfileRequest{Attributes: map[string]interface{}{"": 1}}, "attribute keys"},
+		{"long name", UpdateProfileRequest{Name: string(make([]byte, maxTextLength+1))}, "name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := profileParams(uuid.New(), &tt.req)
+			var e *errs.Error
+			require.ErrorAs(t, err, &e)
+			assert.Equal(t, errs.InvalidArgument, e.Code)
+			assert.Contains(t, e.Message, tt.errMsg)
+		})
+	}
+}
+
+func TestProfileFromDB(t *testing.T) {
+	updated := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	row := db.UserProfile{
+		UserID:     uuid.New(),
+		City:       sql.NullString{String: "Pune", Valid: true},
+		BatteryKwh: sql.NullFloat64{Float64: 30, Valid: true},
+		Attributes: json.RawMessage(`{"plan": "commuter"}`),
+		UpdatedAt:  updated,
+	}
+
+	profile, err := profileFromDB(row)
+	require.NoError(t, err)
+	assert.Equal(t, &Profile{
+		UserID:     row.UserID.String(),
+		City:       "Pune",
+		BatteryKWH: 30,
+		Attributes: map[string]interface{}{"plan": "commuter"},
+		UpdatedAt:  &updated,
+	}, profile)
+
+	row.Attributes = json.RawMessage(`{}`)
+	profile, err = profileFromDB(row)
+	require.NoError(t, err)
+	assert.Nil(t, profile.Attributes)
+}
@@ -0,0 +1,53 @@
+//go:build encore
+// +build encore
+
+package users
+
+import (
+	"log"
+	"os"
+
+	"encore.app/internal/auth"
+	"encore.app/internal/db"
+	"encore.app/internal/otp"
+	"encore.dev/storage/sqldb"
+)
+
+//encore:service
+type Service struct {
+	db     *db.Queries
+	otp    *otp.Manager
+	signer *auth.Signer
+}
+
+// rewardsDB is the shared rewards database
+var rewardsDB = sqldb.Named("rewards")
+
+// init initializes the users service
+func init() {
+	// Service will be initialized by Encore
+}
+
+// initService connects the service to the database and sets up phone login.
+// Codes are sent by the SMS sender OTP_SENDER names; without one, phone login
+// is disabled.
+func initService() (*Service, error) {
+	conn := rewardsDB.Stdlib()
+	q := db.New(conn)
+	signer, err := auth.NewSigner(auth.ConfigFromEnvPrefix("USER_JWT_"))
+	if err != nil {
+		log.Printf("users: login disabled: %v", err)
+	}
+	var manager *otp.Manager
+	sender, err := otp.NewSender(os.Getenv("OTP_SENDER"))
+	if err != nil {
+		log.Printf("users: phone login disabled: %v", err)
+	} else {
+		manager = otp.NewManager(otp.DefaultConfig(), q, otp.DBTx(conn), sender)
+	}
+	return &Service{
+		db:     q,
+		otp:    manager,
+		signer: signer,
+	}, nil
+}
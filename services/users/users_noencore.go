@@ -0,0 +1,22 @@
+//go:build !encore
+// +build !encore
+
+package users
+
+import (
+	"encore.app/internal/auth"
+	"encore.app/internal/db"
+	"encore.app/internal/otp"
+)
+
+//encore:service
+type Service struct {
+	db     *db.Queries
+	otp    *otp.Manager
+	signer *auth.Signer
+}
+
+// init initializes the users service
+func init() {
+	// Service will be initialized by Encore
+}